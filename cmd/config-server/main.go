@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -13,16 +16,23 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/internal/confighistory"
 )
 
 const (
 	defaultPort       = 8090
 	defaultConfigPath = "configs/config.yaml"
+	defaultHistoryDir = "configs/history"
 	shutdownTimeout   = 30 * time.Second
+	// startupAuthor is the author of revisions recorded at startup
+	startupAuthor = "config-server"
+	// maxConfigSize bounds the size of a configuration document upload
+	maxConfigSize = 4 << 20
 )
 
 type ConfigServer struct {
 	configManager *config.Manager
+	history       *confighistory.Store
 	router        *gin.Engine
 	logger        *zap.Logger
 }
@@ -44,10 +54,26 @@ func main() {
 	if err := configManager.Load(configPath); err != nil {
 		logger.Fatal("Failed to load configuration", zap.Error(err))
 	}
+	// Saved configurations take effect through the file watcher
+	configManager.Watch()
+
+	// Record the loaded configuration unless it is the latest revision
+	history, err := confighistory.NewStore(getHistoryDir())
+	if err != nil {
+		logger.Fatal("Failed to open configuration history", zap.Error(err))
+	}
+	revision, _, err := history.Record(configManager.Raw(), startupAuthor, "loaded at startup")
+	if err != nil {
+		logger.Fatal("Failed to record configuration revision", zap.Error(err))
+	}
+	logger.Info("Running configuration revision",
+		zap.Int64("version", revision.Version),
+		zap.String("checksum", revision.Checksum))
 
 	// Create config server
 	server := &ConfigServer{
 		configManager: configManager,
+		history:       history,
 		router:        gin.New(),
 		logger:        logger,
 	}
@@ -103,6 +129,8 @@ func (cs *ConfigServer) setupRoutes() {
 	api.PUT("/config", cs.updateConfig)
 	api.POST("/config/reload", cs.reloadConfig)
 	api.GET("/config/validate", cs.validateConfig)
+	api.GET("/config/history", cs.getHistory)
+	api.POST("/config/rollback/:version", cs.rollbackConfig)
 
 	// Health check
 	cs.router.GET("/health", cs.healthCheck)
@@ -123,22 +151,35 @@ func (cs *ConfigServer) getConfig(c *gin.Context) {
 	})
 }
 
+// updateConfig takes a YAML configuration document (JSON is accepted as
+// YAML), validates it and writes it to the configuration file
 func (cs *ConfigServer) updateConfig(c *gin.Context) {
-	var newConfig config.Config
-	if err := c.ShouldBindJSON(&newConfig); err != nil {
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxConfigSize))
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error":   "Invalid configuration format",
+			"error":   "Failed to read configuration",
 			"details": err.Error(),
 		})
 		return
 	}
+	if len(data) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Configuration is required"})
+		return
+	}
+
+	if !cs.saveConfig(c, data) {
+		return
+	}
+	revision, ok := cs.recordRevision(c, data, "updated through the API")
+	if !ok {
+		return
+	}
 
-	// TODO: Validate configuration
-	// TODO: Save configuration to file
 	// TODO: Notify gateways of configuration change
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":   "Configuration updated successfully",
+		"revision":  revision,
 		"timestamp": time.Now().UTC(),
 	})
 }
@@ -153,8 +194,61 @@ func (cs *ConfigServer) reloadConfig(c *gin.Context) {
 		return
 	}
 
+	revision, ok := cs.recordRevision(c, cs.configManager.Raw(), "reloaded from file")
+	if !ok {
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message":   "Configuration reloaded successfully",
+		"revision":  revision,
+		"timestamp": time.Now().UTC(),
+	})
+}
+
+func (cs *ConfigServer) getHistory(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"revisions": cs.history.List(),
+		"current":   cs.configManager.Revision(),
+	})
+}
+
+func (cs *ConfigServer) rollbackConfig(c *gin.Context) {
+	version, err := strconv.ParseInt(c.Param("version"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision version"})
+		return
+	}
+
+	target, data, err := cs.history.Get(version)
+	if errors.Is(err, confighistory.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		cs.logger.Error("Failed to read configuration revision", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read configuration revision"})
+		return
+	}
+
+	// Revisions were valid when recorded, but validation may have since
+	// become stricter
+	if !cs.saveConfig(c, data) {
+		return
+	}
+
+	revision, ok := cs.recordRevision(c, data, fmt.Sprintf("rollback to revision %d", target.Version))
+	if !ok {
+		return
+	}
+	cs.logger.Info("Configuration rolled back",
+		zap.Int64("to", target.Version),
+		zap.Int64("revision", revision.Version),
+		zap.String("author", revision.Author))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Configuration rolled back successfully",
+		"revision":  revision,
 		"timestamp": time.Now().UTC(),
 	})
 }
@@ -199,6 +293,52 @@ func (cs *ConfigServer) getMetrics(c *gin.Context) {
 
 // Helper functions
 
+// saveConfig validates a configuration document and writes it to the
+// configuration file, writing an error response and returning false if
+// either fails
+func (cs *ConfigServer) saveConfig(c *gin.Context, data []byte) bool {
+	if err := cs.configManager.Validate(data); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":   "Invalid configuration",
+			"details": err.Error(),
+		})
+		return false
+	}
+	if err := cs.configManager.Save(data); err != nil {
+		cs.logger.Error("Failed to save configuration", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to save configuration",
+			"details": err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// recordRevision stores an accepted configuration document in the history,
+// writing an error response and returning false if that fails
+func (cs *ConfigServer) recordRevision(c *gin.Context, data []byte, message string) (confighistory.Revision, bool) {
+	revision, _, err := cs.history.Record(data, requestAuthor(c), message)
+	if err != nil {
+		cs.logger.Error("Failed to record configuration revision", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Configuration applied but not recorded in history",
+			"details": err.Error(),
+		})
+		return confighistory.Revision{}, false
+	}
+	return revision, true
+}
+
+// requestAuthor returns the author of a configuration change, taken from
+// the X-Config-Author header or else the client address
+func requestAuthor(c *gin.Context) string {
+	if author := c.GetHeader("X-Config-Author"); author != "" {
+		return author
+	}
+	return c.ClientIP()
+}
+
 func initLogger() (*zap.Logger, error) {
 	config := zap.NewProductionConfig()
 	config.Level = zap.NewAtomicLevelAt(zap.InfoLevel)
@@ -212,3 +352,10 @@ func getConfigPath() string {
 	return defaultConfigPath
 }
 
+func getHistoryDir() string {
+	if dir := os.Getenv("CONFIG_HISTORY_DIR"); dir != "" {
+		return dir
+	}
+	return defaultHistoryDir
+}
+
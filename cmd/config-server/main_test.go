@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/internal/confighistory"
+)
+
+func TestUpdateConfig(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	base, err := os.ReadFile("../../configs/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, base, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	configManager := config.NewManager(zap.NewNop())
+	if err := configManager.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	history, err := confighistory.NewStore(filepath.Join(dir, "history"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &ConfigServer{
+		configManager: configManager,
+		history:       history,
+		router:        gin.New(),
+		logger:        zap.NewNop(),
+	}
+	server.setupRoutes()
+
+	put := func(body []byte) int {
+		r := httptest.NewRequest(http.MethodPut, "/api/v1/config", bytes.NewReader(body))
+		r.Header.Set("X-Config-Author", "alice")
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	updated := []byte(strings.Replace(string(base), "  port: 8080", "  port: 9000", 1))
+	if code := put(updated); code != http.StatusOK {
+		t.Fatalf("PUT of a valid configuration = %d, want 200", code)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, updated) {
+		t.Error("configuration file was not replaced")
+	}
+	if data, _ := os.ReadFile(path + ".bak"); !bytes.Equal(data, base) {
+		t.Error("previous configuration was not backed up")
+	}
+	revisions := history.List()
+	if len(revisions) != 1 || revisions[0].Author != "alice" {
+		t.Errorf("history = %+v, want one revision by alice", revisions)
+	}
+
+	if code := put([]byte("server:\n  port: -1\n")); code != http.StatusUnprocessableEntity {
+		t.Errorf("PUT of an invalid configuration = %d, want 422", code)
+	}
+	if code := put(nil); code != http.StatusBadRequest {
+		t.Errorf("PUT without a body = %d, want 400", code)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, updated) {
+		t.Error("rejected configuration changed the file")
+	}
+	if len(history.List()) != 1 {
+		t.Error("rejected configuration was recorded in the history")
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sony/gobreaker"
+
+	"github.com/max/api-gateway/internal/circuit"
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/internal/events"
+	"github.com/max/api-gateway/internal/loadshed"
+	"github.com/max/api-gateway/internal/loginguard"
+	"github.com/max/api-gateway/internal/middleware"
+)
+
+// registerEventPublishers publishes circuit breaker changes and the
+// middleware's bot, admin, security, rate limit, flag and login lockout
+// reports as events. Every listener only builds the event and hands it to
+// the bounded queue, which keeps the broker off the request path and never
+// blocks the caller.
+func registerEventPublishers(cfg *config.Config, queue *events.Queue, circuitManager *circuit.Manager, middlewareManager *middleware.Manager) {
+	circuitManager.OnStateChange(publishBreakerStateChange(queue))
+	middlewareManager.OnBotDecision(publishBotDecision(queue))
+	middlewareManager.OnAdminDenial(publishAdminDenial(queue))
+	middlewareManager.OnSecurityEvent(publishSecurityEvent(queue))
+	middlewareManager.OnRateLimitExceeded(publishRateLimitExceeded(queue))
+	middlewareManager.OnFlagEvaluations(publishFlagEvaluations(queue))
+	if cfg.Auth.LoginProtection.Enabled || cfg.Auth.Basic.Enabled {
+		middlewareManager.LoginGuard().OnLockout(publishLoginLockout(queue))
+	}
+}
+
+// publishBreakerStateChange returns a listener that publishes circuit breaker
+// state changes as events
+func publishBreakerStateChange(queue *events.Queue) circuit.StateChangeListener {
+	return func(name string, from gobreaker.State, to gobreaker.State, lastFailure string) {
+		event := &events.APIEvent{
+			Timestamp: time.Now().UTC(),
+			EventType: events.EventTypeCircuitBreakerStateChanged,
+			Service:   name,
+			Metadata: map[string]string{
+				"from": from.String(),
+				"to":   to.String(),
+			},
+		}
+		if lastFailure != "" {
+			event.Metadata["last_failure"] = lastFailure
+		}
+
+		queue.Publish("circuit breaker", event)
+	}
+}
+
+// publishBotDecision returns a listener that publishes bot mitigation
+// decisions as events
+func publishBotDecision(queue *events.Queue) middleware.BotDecisionListener {
+	return func(decision middleware.BotDecision) {
+		event := &events.APIEvent{
+			Timestamp: time.Now().UTC(),
+			EventType: events.EventTypeBotDecision,
+			Path:      decision.Path,
+			Method:    decision.Method,
+			IPAddress: decision.ClientIP,
+			UserAgent: decision.UserAgent,
+			Metadata: map[string]string{
+				"action":  decision.Action,
+				"score":   strconv.Itoa(decision.Score),
+				"reasons": strings.Join(decision.Reasons, ","),
+			},
+		}
+		if decision.Crawler != "" {
+			event.Metadata["crawler"] = decision.Crawler
+		}
+		if decision.JA4 != "" {
+			event.Metadata["ja3"] = decision.JA3
+			event.Metadata["ja4"] = decision.JA4
+		}
+
+		queue.Publish("bot decision", event)
+	}
+}
+
+// publishAdminDenial returns a listener that publishes refused admin
+// operations as audit events
+func publishAdminDenial(queue *events.Queue) middleware.AdminDenialListener {
+	return func(denial middleware.AdminDenial) {
+		event := &events.APIEvent{
+			Timestamp:  denial.Timestamp,
+			EventType:  events.EventTypeAuditLog,
+			UserID:     denial.User,
+			Service:    "admin",
+			Path:       denial.Operation,
+			StatusCode: http.StatusForbidden,
+			IPAddress:  denial.ClientIP,
+			Metadata: map[string]string{
+				"action":     "admin_denied",
+				"permission": denial.Permission,
+				"roles":      strings.Join(denial.Roles, ","),
+			},
+		}
+
+		queue.Publish("admin audit", event)
+	}
+}
+
+// publishSecurityEvent returns a listener that publishes authentication
+// failures, refused authorizations, blocked requests and revoked
+// credentials as security events
+func publishSecurityEvent(queue *events.Queue) middleware.SecurityEventListener {
+	return func(security middleware.SecurityEvent) {
+		event := &events.APIEvent{
+			Timestamp:  security.Timestamp,
+			EventType:  events.EventTypeSecurity,
+			UserID:     security.User,
+			Path:       security.Path,
+			Method:     security.Method,
+			StatusCode: security.Status,
+			IPAddress:  security.ClientIP,
+			UserAgent:  security.UserAgent,
+			Metadata: map[string]string{
+				"kind":   security.Kind,
+				"reason": security.Reason,
+			},
+		}
+		for k, v := range security.Details {
+			if v != "" {
+				event.Metadata[k] = v
+			}
+		}
+
+		queue.Publish("security", event)
+	}
+}
+
+// publishRateLimitExceeded returns a listener that publishes every rate
+// limit rejection with the key, the rule and the route it hit
+func publishRateLimitExceeded(queue *events.Queue) middleware.RateLimitListener {
+	return func(exceeded middleware.RateLimitExceeded) {
+		event := &events.APIEvent{
+			Timestamp:  exceeded.Timestamp,
+			EventType:  events.EventTypeRateLimitExceeded,
+			Service:    exceeded.Service,
+			Path:       exceeded.Path,
+			Method:     exceeded.Method,
+			StatusCode: http.StatusTooManyRequests,
+			IPAddress:  exceeded.ClientIP,
+			Metadata: map[string]string{
+				"key":        exceeded.Key,
+				"key_type":   exceeded.KeyType,
+				"rule":       exceeded.Rule,
+				"route":      exceeded.Route,
+				"limit":      strconv.Itoa(exceeded.Limit),
+				"window":     exceeded.Window.String(),
+				"rejections": strconv.Itoa(exceeded.Rejections),
+			},
+		}
+		if exceeded.Count >= 0 {
+			event.Metadata["count"] = strconv.Itoa(exceeded.Count)
+		}
+
+		queue.Publish("rate limit", event)
+	}
+}
+
+// publishFlagEvaluations returns a listener that publishes the feature
+// flags each request evaluated, with the request and its outcome
+func publishFlagEvaluations(queue *events.Queue) middleware.FlagEvaluationListener {
+	return func(evaluated middleware.FlagEvaluations) {
+		event := &events.APIEvent{
+			Timestamp:  evaluated.Timestamp,
+			EventType:  events.EventTypeFeatureFlagEvaluation,
+			UserID:     evaluated.UserID,
+			Service:    evaluated.Service,
+			Path:       evaluated.Path,
+			Method:     evaluated.Method,
+			StatusCode: evaluated.StatusCode,
+			Latency:    evaluated.Latency,
+			IPAddress:  evaluated.ClientIP,
+			Metadata:   make(map[string]string, 2*len(evaluated.Evaluations)),
+		}
+		for _, evaluation := range evaluated.Evaluations {
+			event.Metadata["flag."+evaluation.Flag] = strconv.FormatBool(evaluation.Enabled)
+			if evaluation.Variant != "" {
+				event.Metadata["flag."+evaluation.Flag+".variant"] = evaluation.Variant
+			}
+			if evaluation.Reason != "" {
+				event.Metadata["flag."+evaluation.Flag+".reason"] = evaluation.Reason
+			}
+		}
+
+		queue.Publish("feature flag", event)
+	}
+}
+
+// publishDegradation returns a listener that publishes the degradation mode
+// engaging and disengaging as events
+func publishDegradation(queue *events.Queue) loadshed.DegradationListener {
+	return func(change loadshed.Degradation) {
+		event := &events.APIEvent{
+			Timestamp: change.Timestamp,
+			EventType: events.EventTypeDegradationChanged,
+			Metadata: map[string]string{
+				"engaged":         strconv.FormatBool(change.Engaged),
+				"signal":          change.Signal,
+				"ratio":           strconv.FormatFloat(change.Ratio, 'f', 2, 64),
+				"cpu":             strconv.FormatFloat(change.CPU, 'f', 2, 64),
+				"memory_bytes":    strconv.FormatUint(change.Memory, 10),
+				"features":        strings.Join(change.Features, ","),
+				"admission_scale": strconv.FormatFloat(change.AdmissionScale, 'f', -1, 64),
+			},
+		}
+
+		queue.Publish("degradation", event)
+	}
+}
+
+// publishLoginLockout returns a listener that publishes login lockouts as
+// security events to the audit topic
+func publishLoginLockout(queue *events.Queue) loginguard.LockoutListener {
+	return func(lockout loginguard.Lockout) {
+		event := &events.APIEvent{
+			Timestamp:  lockout.Timestamp,
+			EventType:  events.EventTypeAuditLog,
+			UserID:     lockout.Username,
+			Service:    "auth",
+			Path:       "/auth/login",
+			Method:     http.MethodPost,
+			StatusCode: http.StatusTooManyRequests,
+			IPAddress:  lockout.ClientIP,
+			Metadata: map[string]string{
+				"action":   "login_lockout",
+				"kind":     lockout.Kind,
+				"failures": strconv.FormatInt(lockout.Failures, 10),
+				"duration": lockout.Duration.String(),
+			},
+		}
+
+		queue.Publish("login lockout", event)
+	}
+}
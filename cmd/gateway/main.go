@@ -2,24 +2,54 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net"
 	"net/http"
+	"net/http/pprof"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	"github.com/max/api-gateway/internal/auth"
+	"github.com/max/api-gateway/internal/cache"
+	"github.com/max/api-gateway/internal/capture"
 	"github.com/max/api-gateway/internal/circuit"
+	"github.com/max/api-gateway/internal/cluster"
 	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/internal/events"
 	"github.com/max/api-gateway/internal/gateway"
+	"github.com/max/api-gateway/internal/health"
+	"github.com/max/api-gateway/internal/identity"
+	"github.com/max/api-gateway/internal/loadshed"
+	"github.com/max/api-gateway/internal/metering"
 	"github.com/max/api-gateway/internal/middleware"
 	"github.com/max/api-gateway/internal/proxy"
 	"github.com/max/api-gateway/internal/ratelimit"
+	"github.com/max/api-gateway/internal/registry"
+	"github.com/max/api-gateway/internal/schedule"
+	"github.com/max/api-gateway/internal/startup"
+	"github.com/max/api-gateway/internal/synthetics"
+	"github.com/max/api-gateway/internal/upgrade"
+	"github.com/max/api-gateway/internal/webhook"
+	"github.com/max/api-gateway/pkg/connlimit"
+	"github.com/max/api-gateway/pkg/egress"
 	"github.com/max/api-gateway/pkg/metrics"
+	"github.com/max/api-gateway/pkg/proxyproto"
+	"github.com/max/api-gateway/pkg/socketactivation"
+	"github.com/max/api-gateway/pkg/tlsfingerprint"
 )
 
 const (
@@ -39,14 +69,27 @@ func main() {
 	logger.Info("Starting API Gateway")
 
 	// Load configuration
-	configPath := getConfigPath()
 	configManager := config.NewManager(logger)
-	if err := configManager.Load(configPath); err != nil {
+	origin, err := loadConfig(configManager)
+	if err != nil {
 		logger.Fatal("Failed to load configuration", zap.Error(err))
 	}
 
 	cfg := configManager.Get()
-	logger.Info("Configuration loaded", zap.String("config_path", configPath))
+	logger.Info("Configuration loaded", zap.String("config_path", origin))
+
+	// Take over the listeners of the previous process after an upgrade
+	upgrader, err := upgrade.New(cfg.Server.Upgrade, logger)
+	if err != nil {
+		logger.Fatal("Failed to inherit listeners", zap.Error(err))
+	}
+
+	// Wait for dependencies before anything connects to them
+	if cfg.Server.Startup.Enabled {
+		if err := waitForDependencies(cfg, logger); err != nil {
+			logger.Fatal("Startup dependencies not ready", zap.Error(err))
+		}
+	}
 
 	// Initialize Redis client
 	redisClient := initRedis(cfg.Redis, logger)
@@ -55,8 +98,23 @@ func main() {
 		logger.Info("Redis client initialized")
 	}
 
+	// Initialize event processor
+	eventProcessor := initEventProcessor(cfg.EventProcessing, logger)
+	defer eventProcessor.Close()
+
 	// Initialize components
 	metricsManager := metrics.NewManager(logger)
+	if cfg.Monitoring.Prometheus.RuntimeMetrics {
+		metricsManager.EnableRuntimeMetrics()
+	}
+	initExporters(cfg.Monitoring, metricsManager, logger)
+	defer metricsManager.Close()
+	eventProcessor.SetMetrics(metricsManager)
+	eventQueue := events.NewQueue(eventProcessor, cfg.EventProcessing.QueueSize, metricsManager, logger)
+	defer eventQueue.Close()
+	eventsCtx, stopEvents := context.WithCancel(context.Background())
+	defer stopEvents()
+	go eventProcessor.MonitorDeadLetters(eventsCtx)
 	jwtAuth := auth.NewJWTAuth(
 		cfg.Auth.JWT.Secret,
 		cfg.Auth.JWT.ExpirationTime,
@@ -66,10 +124,87 @@ func main() {
 		cfg.Auth.JWT.Algorithm,
 		logger,
 	)
+	jwtAuth.SetValidation(jwtValidation(cfg.Auth.JWT))
 	rateLimiter := ratelimit.NewManager(&cfg.RateLimit, redisClient, logger)
-	circuitManager := circuit.NewManager(logger)
+	circuitManager := circuit.NewManager(logger, metricsManager)
+	if breakerState := cfg.Routing.BreakerState; breakerState.Persist || breakerState.Shared {
+		if redisClient == nil {
+			logger.Warn("Redis unavailable, circuit breaker state is not persisted or shared")
+		} else if store := circuit.NewRedisStore(redisClient, breakerState.Prefix); breakerState.Shared {
+			circuitManager.ShareState(store)
+			circuitManager.Start(breakerState.SyncInterval)
+			defer circuitManager.Stop()
+		} else {
+			circuitManager.SetStore(store)
+		}
+	}
 	proxyManager := proxy.NewProxyManager(logger, metricsManager)
-	middlewareManager := middleware.NewManager(cfg, jwtAuth, rateLimiter, metricsManager, logger)
+	proxyManager.SetLocalZone(cfg.Server.Zone)
+	proxyManager.SetTimeouts(cfg.Routing.Timeouts)
+	if cfg.Security.Egress.Enabled {
+		// The allowlist was validated with the configuration
+		allowlist, _ := egress.NewAllowlist(cfg.Security.Egress.AllowedHosts)
+		proxyManager.SetEgress(allowlist)
+	}
+	tenantCache := newTenantCache(cfg.Cache, redisClient, logger)
+	if tenantCache != nil {
+		proxyManager.SetSharedCache(tenantCache)
+		tenantCache.Start(cfg.Cache.Tenants.SampleInterval)
+		defer tenantCache.Stop()
+	} else if cfg.Cache.Enabled && cfg.Cache.Backends["responses"] != "" {
+		// Fallback responses are shared through the configured backend
+		cacheManager, err := cache.NewManager(&cfg.Cache, redisClient, logger)
+		if err != nil {
+			logger.Fatal("Failed to initialize cache backends", zap.Error(err))
+		}
+		proxyManager.SetSharedCache(cacheManager.GetResponseCache())
+	}
+	middlewareManager := middleware.NewManager(cfg, jwtAuth, rateLimiter, redisClient, metricsManager, logger)
+	registerEventPublishers(cfg, eventQueue, circuitManager, middlewareManager)
+
+	// Switch expensive features off and tighten admission while the
+	// gateway itself is overloaded
+	if cfg.Server.Degradation.Enabled {
+		degrader := middlewareManager.Degrader()
+		degrader.OnChange(func(change loadshed.Degradation) {
+			proxyManager.SetAdmissionScale(change.AdmissionScale)
+		})
+		degrader.OnChange(publishDegradation(eventQueue))
+		degraderCtx, stopDegrader := context.WithCancel(context.Background())
+		defer stopDegrader()
+		degrader.Start(degraderCtx)
+	}
+
+	// Deliver gateway events to webhook endpoints
+	var webhooks *webhook.Dispatcher
+	if cfg.Webhooks.Enabled && len(cfg.Webhooks.Endpoints) > 0 {
+		webhooks = webhook.NewDispatcher(cfg.Webhooks, metricsManager, logger)
+		webhooksCtx, stopWebhooks := context.WithCancel(context.Background())
+		defer stopWebhooks()
+		webhooks.Start(webhooksCtx)
+		circuitManager.OnStateChange(webhookBreakerStateChange(webhooks))
+		middlewareManager.OnRateLimitExceeded(webhookRateLimitExceeded(webhooks))
+		configManager.OnChange(webhookConfigReloaded(webhooks))
+		logger.Info("Webhook dispatcher started", zap.Int("endpoints", len(cfg.Webhooks.Endpoints)))
+	}
+
+	// Initialize usage metering
+	var meter *metering.Meter
+	if cfg.Metering.Enabled {
+		meter = initMeter(cfg, logger)
+		if meter != nil {
+			middlewareManager.SetMeter(meter)
+		}
+	}
+
+	// Initialize traffic capture
+	var recorder *capture.Recorder
+	if cfg.Capture.Enabled {
+		recorder = initRecorder(cfg, logger)
+		if recorder != nil {
+			middlewareManager.SetRecorder(recorder)
+		}
+	}
 
 	// Initialize gateway
 	gw := gateway.NewGateway(
@@ -84,6 +219,25 @@ func main() {
 		logger,
 	)
 
+	registerHealthChecks(gw.Health(), cfg, redisClient, eventProcessor)
+	if webhooks != nil {
+		gw.SetWebhooks(webhooks)
+	}
+
+	// Authenticate logins against the user store. Without it the gateway
+	// would fall back to the demo credentials, so failing to open it is fatal.
+	if cfg.Auth.Users.Enabled {
+		users, err := initUsers(cfg, logger)
+		if err != nil {
+			logger.Fatal("Failed to initialize user store", zap.Error(err))
+		}
+		defer users.Close()
+		gw.SetUsers(users, newChallengeStore(cfg.Auth.Users.TOTP, redisClient, logger))
+	}
+	if tenantCache != nil {
+		gw.SetTenantCache(tenantCache)
+	}
+
 	// Setup routes
 	if err := gw.SetupRoutes(); err != nil {
 		logger.Fatal("Failed to setup routes", zap.Error(err))
@@ -94,46 +248,129 @@ func main() {
 		logger.Fatal("Failed to initialize services", zap.Error(err))
 	}
 
-	// Create HTTP server
-	server := &http.Server{
-		Addr:         fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
-		Handler:      gw.Router(),
-		ReadTimeout:  cfg.Server.ReadTimeout,
-		WriteTimeout: cfg.Server.WriteTimeout,
-		IdleTimeout:  cfg.Server.IdleTimeout,
+	// Apply time-of-day overrides on top of the configured services
+	if len(cfg.Schedules) > 0 {
+		scheduler, err := schedule.NewScheduler(cfg, rateLimiter, proxyManager, logger)
+		if err != nil {
+			logger.Fatal("Failed to initialize scheduler", zap.Error(err))
+		}
+		schedulerCtx, stopScheduler := context.WithCancel(context.Background())
+		defer stopScheduler()
+		scheduler.Start(schedulerCtx)
+		logger.Info("Scheduler started",
+			zap.Int("schedules", len(cfg.Schedules)),
+			zap.Strings("active", scheduler.Active()))
+	}
+
+	// Probe the gateway's own routes once the services are registered
+	if cfg.Synthetics.Enabled && len(cfg.Synthetics.Probes) > 0 {
+		runner, err := synthetics.NewRunner(cfg.Synthetics, gw.Router(), metricsManager, logger)
+		if err != nil {
+			logger.Fatal("Failed to initialize synthetic probes", zap.Error(err))
+		}
+		gw.SetSynthetics(runner)
+		syntheticsCtx, stopSynthetics := context.WithCancel(context.Background())
+		defer stopSynthetics()
+		runner.Start(syntheticsCtx)
+		logger.Info("Synthetic probes started", zap.Int("probes", len(cfg.Synthetics.Probes)))
+	}
+
+	// Let backends register themselves next to the configured services
+	if cfg.Registry.Enabled {
+		serviceRegistry := registry.New(cfg.Registry, proxyManager, logger)
+		gw.SetRegistry(serviceRegistry)
+		serviceRegistry.Start()
+		defer serviceRegistry.Stop()
+	}
+
+	// Join the cluster after the static services are registered so the
+	// replayed runtime changes apply on top of them
+	if cfg.Cluster.Enabled {
+		if redisClient == nil {
+			logger.Warn("Cluster coordination requires Redis, running standalone")
+		} else {
+			gatewayCluster := cluster.NewCluster(cfg.Cluster, redisClient, logger)
+			gw.JoinCluster(gatewayCluster)
+			if err := gatewayCluster.Start(context.Background()); err != nil {
+				logger.Error("Failed to join cluster, running standalone", zap.Error(err))
+			} else {
+				defer gatewayCluster.Close()
+			}
+		}
 	}
 
 	// Start metrics server if enabled
 	var metricsServer *http.Server
 	if cfg.Monitoring.Prometheus.Enabled {
-		metricsServer = startMetricsServer(cfg.Monitoring.Prometheus, metricsManager, logger)
+		monitoring := cfg.Monitoring
+		// pprof moves to the admin server when there is one
+		if cfg.Server.Admin.Enabled {
+			monitoring.Profiling.Enabled = false
+		}
+		metricsServer = startMetricsServer(monitoring, metricsManager, upgrader, logger)
+	}
+
+	// Start the gRPC admin API if enabled
+	var adminServer *grpc.Server
+	if cfg.Server.AdminGRPC.Enabled {
+		adminServer, err = startAdminGRPCServer(cfg.Server, gw, upgrader, logger)
+		if err != nil {
+			logger.Fatal("Failed to start gRPC admin server", zap.Error(err))
+		}
 	}
 
 	// Start configuration watcher
 	go configManager.Watch()
 
-	// Start server
-	go func() {
-		logger.Info("Starting HTTP server",
-			zap.String("address", server.Addr),
-			zap.Bool("tls_enabled", cfg.Server.TLS.Enabled))
+	// Track client connections across the gateway and admin servers
+	connTracker, err := newConnTracker(cfg.Server, metricsManager)
+	if err != nil {
+		logger.Fatal("Failed to create connection tracker", zap.Error(err))
+	}
 
-		var err error
-		if cfg.Server.TLS.Enabled {
-			err = server.ListenAndServeTLS(cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
-		} else {
-			err = server.ListenAndServe()
-		}
+	// Start the HTTP servers
+	servers, err := startServers(cfg, gw, upgrader, connTracker, logger)
+	if err != nil {
+		logger.Fatal("Failed to create listener", zap.Error(err))
+	}
 
-		if err != nil && err != http.ErrServerClosed {
-			logger.Fatal("Server startup failed", zap.Error(err))
+	// Start the admin server, which takes the admin routes, health checks,
+	// metrics and pprof off the gateway listeners
+	if cfg.Server.Admin.Enabled {
+		adminHTTPServer, err := startAdminServer(cfg, gw, upgrader, connTracker, logger)
+		if err != nil {
+			logger.Fatal("Failed to start admin server", zap.Error(err))
 		}
-	}()
+		servers = append(servers, adminHTTPServer)
+	}
+
+	// Let the previous process drain now that this one serves
+	if err := upgrader.Ready(); err != nil {
+		logger.Error("Failed to report readiness", zap.Error(err))
+	}
 
-	// Wait for interrupt signal
+	// Wait for interrupt signal, or for a new process to take over
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	upgradeRequested := make(chan os.Signal, 1)
+	if cfg.Server.Upgrade.Enabled {
+		upgrade.Notify(upgradeRequested)
+	}
+wait:
+	for {
+		select {
+		case <-quit:
+			break wait
+		case <-upgradeRequested:
+			logger.Info("Upgrade requested, starting new process")
+			if err := upgrader.Upgrade(); err != nil {
+				logger.Error("Upgrade failed, continuing to serve", zap.Error(err))
+				continue
+			}
+			logger.Info("New process is serving, draining connections")
+			break wait
+		}
+	}
 
 	logger.Info("Shutting down server...")
 
@@ -141,9 +378,46 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 
-	// Shutdown main server
-	if err := server.Shutdown(ctx); err != nil {
-		logger.Error("Server forced to shutdown", zap.Error(err))
+	// Shutdown the HTTP servers, draining their connections together
+	var drained sync.WaitGroup
+	for _, server := range servers {
+		drained.Add(1)
+		go func(server *http.Server) {
+			defer drained.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				logger.Error("Server forced to shutdown", zap.String("address", server.Addr), zap.Error(err))
+			}
+		}(server)
+	}
+	drained.Wait()
+
+	// Export the usage of the last, partial interval once requests drained
+	if meter != nil {
+		if err := meter.Close(ctx); err != nil {
+			logger.Error("Failed to flush usage records", zap.Error(err))
+		}
+	}
+
+	// Write the exchanges still queued for capture
+	if recorder != nil {
+		if err := recorder.Close(); err != nil {
+			logger.Error("Failed to close capture sink", zap.Error(err))
+		}
+	}
+
+	// Shutdown gRPC admin server, cutting long-lived stats streams once the
+	// shutdown deadline passes
+	if adminServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			adminServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			adminServer.Stop()
+		}
 	}
 
 	// Shutdown metrics server
@@ -163,6 +437,25 @@ func initLogger() (*zap.Logger, error) {
 	return config.Build()
 }
 
+// loadConfig loads the configuration from the file, or from the remote
+// source selected by CONFIG_SOURCE, and returns where it came from
+func loadConfig(configManager *config.Manager) (string, error) {
+	sourceCfg, err := config.SourceFromEnv()
+	if err != nil {
+		return "", err
+	}
+	if sourceCfg.Type == config.SourceFile {
+		configPath := getConfigPath()
+		return configPath, configManager.Load(configPath)
+	}
+
+	source, err := config.NewSource(sourceCfg, nil)
+	if err != nil {
+		return "", err
+	}
+	return source.String(), configManager.LoadSource(source, sourceCfg.PollInterval)
+}
+
 // getConfigPath returns the configuration file path
 func getConfigPath() string {
 	if path := os.Getenv("CONFIG_PATH"); path != "" {
@@ -173,12 +466,7 @@ func getConfigPath() string {
 
 // initRedis initializes Redis client
 func initRedis(cfg config.RedisConfig, logger *zap.Logger) *redis.Client {
-	client := redis.NewClient(&redis.Options{
-		Addr:     fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
-		Password: cfg.Password,
-		DB:       cfg.DB,
-		PoolSize: cfg.PoolSize,
-	})
+	client := redis.NewClient(redisOptions(cfg))
 
 	// Test connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -193,6 +481,442 @@ func initRedis(cfg config.RedisConfig, logger *zap.Logger) *redis.Client {
 	return client
 }
 
+// redisOptions builds the Redis client options from configuration
+func redisOptions(cfg config.RedisConfig) *redis.Options {
+	return &redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+		Password: cfg.Password,
+		DB:       cfg.DB,
+		PoolSize: cfg.PoolSize,
+	}
+}
+
+// waitForDependencies blocks until the configured dependencies are reachable,
+// failing only for those listed as required
+func waitForDependencies(cfg *config.Config, logger *zap.Logger) error {
+	waiter := startup.NewWaiter(cfg.Server.Startup, logger)
+
+	redisClient := redis.NewClient(redisOptions(cfg.Redis))
+	defer redisClient.Close()
+	waiter.Add("redis", func(ctx context.Context) error {
+		return redisClient.Ping(ctx).Err()
+	})
+
+	if cfg.Database.Host != "" {
+		waiter.Add("database", startup.TCPCheck(net.JoinHostPort(cfg.Database.Host, strconv.Itoa(cfg.Database.Port))))
+	}
+
+	if cfg.EventProcessing.Enabled {
+		switch cfg.EventProcessing.Provider {
+		case "kafka":
+			waiter.Add("events", startup.TCPCheck(cfg.EventProcessing.Kafka.Brokers...))
+		case "rabbitmq":
+			if u, err := url.Parse(cfg.EventProcessing.RabbitMQ.URL); err == nil {
+				address := u.Host
+				if u.Port() == "" {
+					address = net.JoinHostPort(u.Hostname(), "5672")
+				}
+				waiter.Add("events", startup.TCPCheck(address))
+			}
+		}
+	}
+
+	if hosts := upstreamHosts(cfg.Routing); len(hosts) > 0 {
+		waiter.Add("upstream_dns", startup.DNSCheck(nil, hosts))
+	}
+
+	logger.Info("Waiting for startup dependencies", zap.Strings("required", cfg.Server.Startup.Required))
+	return waiter.Wait(context.Background())
+}
+
+// upstreamHosts returns the distinct upstream host names that need DNS
+func upstreamHosts(cfg config.RoutingConfig) []string {
+	seen := make(map[string]bool)
+	var hosts []string
+	add := func(rawURL string) {
+		u, err := url.Parse(rawURL)
+		if err != nil || u.Hostname() == "" || net.ParseIP(u.Hostname()) != nil || seen[u.Hostname()] {
+			return
+		}
+		seen[u.Hostname()] = true
+		hosts = append(hosts, u.Hostname())
+	}
+
+	for _, service := range cfg.Services {
+		for _, u := range service.URLs {
+			add(u)
+		}
+		for _, target := range service.Targets {
+			add(target.URL)
+		}
+	}
+	return hosts
+}
+
+// registerHealthChecks registers checks for the gateway's dependencies
+func registerHealthChecks(checker *health.Checker, cfg *config.Config, redisClient *redis.Client, eventProcessor *events.EventProcessor) {
+	checker.Register("redis", func(ctx context.Context) error {
+		if redisClient == nil {
+			// Rate limits, sessions and caches fall back to memory
+			return health.Degraded(fmt.Errorf("redis unavailable, using in-memory fallbacks"))
+		}
+		return redisClient.Ping(ctx).Err()
+	})
+
+	if cfg.Database.Host != "" {
+		address := net.JoinHostPort(cfg.Database.Host, strconv.Itoa(cfg.Database.Port))
+		checker.Register("database", func(ctx context.Context) error {
+			var dialer net.Dialer
+			conn, err := dialer.DialContext(ctx, "tcp", address)
+			if err != nil {
+				return fmt.Errorf("database unreachable: %w", err)
+			}
+			return conn.Close()
+		})
+	}
+
+	if cfg.EventProcessing.Enabled {
+		checker.Register("events", eventProcessor.HealthCheck)
+	}
+}
+
+// initEventProcessor initializes the event processor, falling back to a
+// disabled processor if the configured provider is unreachable
+func initEventProcessor(cfg config.EventProcessingConfig, logger *zap.Logger) *events.EventProcessor {
+	eventConfig := &events.EventConfig{
+		Enabled:  cfg.Enabled,
+		Provider: cfg.Provider,
+		Encoding: cfg.Encoding,
+		Kafka: events.KafkaConfig{
+			Brokers:        cfg.Kafka.Brokers,
+			Topics:         cfg.Kafka.Topics,
+			ConsumerGroup:  cfg.Kafka.ConsumerGroup,
+			ProducerConfig: events.ProducerConfig(cfg.Kafka.ProducerConfig),
+			SchemaRegistry: events.SchemaRegistryConfig(cfg.Kafka.SchemaRegistry),
+		},
+		RabbitMQ: events.RabbitMQConfig(cfg.RabbitMQ),
+		Consumer: events.ConsumerConfig(cfg.Consumer),
+		Buffer:   events.BufferConfig(cfg.Buffer),
+	}
+
+	processor, err := events.NewEventProcessor(eventConfig, logger)
+	if err != nil {
+		logger.Warn("Failed to initialize event processor, events disabled", zap.Error(err))
+		eventConfig.Enabled = false
+		processor, _ = events.NewEventProcessor(eventConfig, logger)
+	}
+
+	return processor
+}
+
+// initMeter creates the usage meter and its exporter and starts periodic
+// export. Metering is disabled if the exporter cannot be created.
+func initMeter(cfg *config.Config, logger *zap.Logger) *metering.Meter {
+	var exporter metering.Exporter
+	var err error
+	switch cfg.Metering.Exporter {
+	case "kafka":
+		brokers := cfg.Metering.Kafka.Brokers
+		if len(brokers) == 0 {
+			brokers = cfg.EventProcessing.Kafka.Brokers
+		}
+		exporter, err = metering.NewKafkaExporter(brokers, cfg.Metering.Kafka.Topic)
+	case "postgres":
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		exporter, err = metering.NewPostgresExporter(ctx, cfg.Database, cfg.Metering.Postgres.Table)
+	default:
+		err = fmt.Errorf("unknown metering exporter: %s", cfg.Metering.Exporter)
+	}
+	if err != nil {
+		logger.Error("Failed to initialize usage metering, metering disabled", zap.Error(err))
+		return nil
+	}
+
+	meter := metering.NewMeter(cfg.Metering, exporter, logger)
+	meter.Start(context.Background())
+	logger.Info("Usage metering started",
+		zap.String("exporter", cfg.Metering.Exporter),
+		zap.Duration("interval", cfg.Metering.Interval))
+	return meter
+}
+
+// jwtValidation returns the token validation options of the JWT settings
+func jwtValidation(cfg config.JWTConfig) auth.ValidationOptions {
+	algorithms := cfg.AllowedAlgorithms
+	if len(algorithms) == 0 {
+		algorithms = []string{cfg.Algorithm}
+	}
+	return auth.ValidationOptions{
+		Issuer:            cfg.Issuer,
+		Audiences:         cfg.AcceptedAudiences(),
+		Algorithms:        algorithms,
+		ClockSkew:         cfg.ClockSkew,
+		RequireExpiration: cfg.RequireExpiration,
+	}
+}
+
+// initUsers opens the user store, migrating its schema and creating the
+// bootstrap admin if needed
+func initUsers(cfg *config.Config, logger *zap.Logger) (*identity.Store, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	users, err := identity.NewStore(ctx, cfg.Database, cfg.Auth.Users, logger)
+	if err != nil {
+		return nil, err
+	}
+	if err := users.Bootstrap(ctx); err != nil {
+		users.Close()
+		return nil, err
+	}
+	logger.Info("User store initialized", zap.Bool("require_verified_email", cfg.Auth.Users.RequireVerifiedEmail))
+	return users, nil
+}
+
+// newChallengeStore creates the store of logins waiting for their second
+// factor, in Redis so that the code can be sent to any instance
+func newChallengeStore(cfg config.TOTPConfig, redisClient *redis.Client, logger *zap.Logger) *identity.ChallengeStore {
+	var store cache.Cache
+	if redisClient != nil {
+		store = cache.NewRedisCache(redisClient, "gateway", cfg.ChallengeTTL, logger)
+	} else {
+		logger.Warn("Redis unavailable, login challenges are kept in memory and not shared between instances")
+		store = cache.NewMemoryCache(0, cfg.ChallengeTTL, logger)
+	}
+	return identity.NewChallengeStore(store, cfg.ChallengeTTL, cfg.MaxAttempts)
+}
+
+// newTenantCache creates the Redis cache shared by the services' fallback
+// responses, namespaced per tenant, or returns nil when it is not enabled
+func newTenantCache(cfg config.CacheConfig, redisClient *redis.Client, logger *zap.Logger) *cache.TenantCache {
+	if !cfg.Enabled || !cfg.Tenants.Enabled {
+		return nil
+	}
+	if redisClient == nil {
+		logger.Warn("Redis unavailable, fallback responses are cached per instance without tenant quotas")
+		return nil
+	}
+
+	tenants := cfg.Tenants
+	quotas := make(map[string]cache.TenantQuota, len(tenants.Quotas))
+	for tenant, quota := range tenants.Quotas {
+		quotas[tenant] = cache.TenantQuota{MaxKeys: quota.MaxKeys, MaxBytes: quota.MaxBytes}
+	}
+	quota := cache.TenantQuota{MaxKeys: tenants.MaxKeys, MaxBytes: tenants.MaxBytes}
+	return cache.NewTenantCache(redisClient, "gateway:responses", cfg.TTL, quota, quotas, tenants.SampleSize, logger)
+}
+
+// initRecorder creates the traffic capture recorder and its sink
+func initRecorder(cfg *config.Config, logger *zap.Logger) *capture.Recorder {
+	var sink capture.Sink
+	var err error
+	switch cfg.Capture.Sink {
+	case "file":
+		sink, err = capture.NewFileSink(cfg.Capture.File)
+	case "kafka":
+		brokers := cfg.Capture.Kafka.Brokers
+		if len(brokers) == 0 {
+			brokers = cfg.EventProcessing.Kafka.Brokers
+		}
+		sink, err = capture.NewKafkaSink(brokers, cfg.Capture.Kafka.Topic)
+	default:
+		err = fmt.Errorf("unknown capture sink: %s", cfg.Capture.Sink)
+	}
+	if err != nil {
+		logger.Error("Failed to initialize traffic capture, capture disabled", zap.Error(err))
+		return nil
+	}
+
+	logger.Info("Traffic capture started",
+		zap.String("sink", cfg.Capture.Sink),
+		zap.Int("routes", len(cfg.Capture.Routes)))
+	return capture.NewRecorder(sink, cfg.Capture.QueueSize, logger)
+}
+
+// newConnTracker creates the tracker enforcing the connection lifetime and
+// per-IP limits. Trusted proxies are exempt from the per-IP limit since
+// every client behind them shares their address.
+func newConnTracker(cfg config.ServerConfig, metricsManager *metrics.Manager) (*connlimit.Tracker, error) {
+	exempt, err := proxyproto.ParseCIDRs(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	limits := connlimit.Limits{
+		MaxPerIP:          cfg.MaxConnsPerIP,
+		MaxLifetime:       cfg.MaxConnLifetime,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		Exempt:            exempt,
+		CountOnRequest:    cfg.ProxyProtocol.Enabled,
+	}
+	return connlimit.New(limits, metricsManager.RecordConnectionDropped, metricsManager.SetActiveConnections), nil
+}
+
+// startServers starts an HTTP server per configured listener, or a single
+// one on server.host and server.port
+func startServers(cfg *config.Config, gw *gateway.Gateway, upgrader *upgrade.Upgrader, tracker *connlimit.Tracker, logger *zap.Logger) ([]*http.Server, error) {
+	sockets, err := socketactivation.Passed()
+	if err != nil {
+		return nil, err
+	}
+	defer sockets.Close()
+
+	listeners := cfg.Server.Listeners
+	if len(listeners) == 0 {
+		listeners = []config.ListenerConfig{{
+			Name:    "http",
+			Address: fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
+			TLS:     cfg.Server.TLS.Enabled,
+		}}
+	}
+
+	var servers []*http.Server
+	for _, spec := range listeners {
+		listener, err := newListener(upgrader, sockets, spec, cfg.Server)
+		if err != nil {
+			for _, server := range servers {
+				server.Close()
+			}
+			return nil, fmt.Errorf("listener %s: %w", spec.Name, err)
+		}
+
+		server := &http.Server{
+			Addr:              listener.Addr().String(),
+			Handler:           gw.ListenerHandler(spec),
+			ReadTimeout:       cfg.Server.ReadTimeout,
+			ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+			WriteTimeout:      cfg.Server.WriteTimeout,
+			IdleTimeout:       cfg.Server.IdleTimeout,
+			MaxHeaderBytes:    cfg.Server.MaxHeaderBytes,
+			ConnState:         tracker.ConnState,
+		}
+
+		// Fingerprint TLS clients below the TLS stack so the ClientHello is seen
+		if spec.TLS && cfg.Security.TLSFingerprint.Enabled {
+			listener = tlsfingerprint.NewListener(listener)
+			server.ConnContext = tlsfingerprint.ConnContext
+		}
+
+		go func(spec config.ListenerConfig) {
+			logger.Info("Starting HTTP server",
+				zap.String("listener", spec.Name),
+				zap.String("address", server.Addr),
+				zap.String("role", spec.Role),
+				zap.Bool("systemd", spec.Systemd != ""),
+				zap.Bool("tls_enabled", spec.TLS),
+				zap.Bool("proxy_protocol", cfg.Server.ProxyProtocol.Enabled))
+
+			var err error
+			if spec.TLS {
+				err = server.ServeTLS(listener, cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
+			} else {
+				err = server.Serve(listener)
+			}
+
+			if err != nil && err != http.ErrServerClosed {
+				logger.Fatal("Server startup failed", zap.String("listener", spec.Name), zap.Error(err))
+			}
+		}(spec)
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+// startAdminServer starts the dedicated admin server, over TLS when a
+// certificate is configured and requiring client certificates when a
+// client CA is
+func startAdminServer(cfg *config.Config, gw *gateway.Gateway, upgrader *upgrade.Upgrader, tracker *connlimit.Tracker, logger *zap.Logger) (*http.Server, error) {
+	adminCfg := cfg.Server.Admin
+
+	extra := http.NewServeMux()
+	if cfg.Monitoring.Profiling.Enabled {
+		registerProfiling(extra, cfg.Monitoring.Profiling, logger)
+	}
+	handler, err := gw.AdminServerHandler(extra)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{
+		Addr:              adminCfg.Address,
+		Handler:           handler,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		MaxHeaderBytes:    cfg.Server.MaxHeaderBytes,
+		ConnState:         tracker.ConnState,
+	}
+	if adminCfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(adminCfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read admin client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in admin client CA file %s", adminCfg.ClientCAFile)
+		}
+		server.TLSConfig = &tls.Config{
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  pool,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	listener, err := upgrader.Listen("admin_http", adminCfg.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		logger.Info("Starting admin server",
+			zap.String("address", server.Addr),
+			zap.Bool("tls_enabled", adminCfg.CertFile != ""),
+			zap.Bool("client_certificates", adminCfg.ClientCAFile != ""),
+			zap.Bool("profiling", cfg.Monitoring.Profiling.Enabled))
+
+		var err error
+		if adminCfg.CertFile != "" {
+			err = server.ServeTLS(listener, adminCfg.CertFile, adminCfg.KeyFile)
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			logger.Fatal("Admin server failed", zap.Error(err))
+		}
+	}()
+	return server, nil
+}
+
+// newListener creates the listener of a gateway server from its address or
+// the socket systemd passed, accepting PROXY protocol headers from trusted
+// proxies when enabled
+func newListener(upgrader *upgrade.Upgrader, sockets *socketactivation.Sockets, spec config.ListenerConfig, cfg config.ServerConfig) (net.Listener, error) {
+	var listener net.Listener
+	var err error
+	if spec.Systemd != "" {
+		listener, err = upgrader.ListenWith(spec.Name, "systemd:"+spec.Systemd, func() (net.Listener, error) {
+			return sockets.Listener(spec.Systemd)
+		})
+	} else {
+		listener, err = upgrader.Listen(spec.Name, spec.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !cfg.ProxyProtocol.Enabled {
+		return listener, nil
+	}
+
+	proxyListener, err := proxyproto.NewListener(listener, cfg.TrustedProxies, cfg.ProxyProtocol.HeaderTimeout)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to enable proxy protocol: %w", err)
+	}
+	return proxyListener, nil
+}
+
 // initializeServices initializes services from configuration
 func initializeServices(cfg *config.Config, proxyManager *proxy.ProxyManager, circuitManager *circuit.Manager, logger *zap.Logger, metricsMgr *metrics.Manager) error {
 	for serviceName, serviceConfig := range cfg.Routing.Services {
@@ -215,26 +939,131 @@ func initializeServices(cfg *config.Config, proxyManager *proxy.ProxyManager, ci
 	return nil
 }
 
-// startMetricsServer starts the Prometheus metrics server
-func startMetricsServer(cfg config.PrometheusConfig, metricsManager *metrics.Manager, logger *zap.Logger) *http.Server {
+// registerProfiling mounts the pprof handlers under /debug/pprof/, guarded
+// by the profiling token or allowed networks
+func registerProfiling(mux *http.ServeMux, cfg config.ProfilingConfig, logger *zap.Logger) {
+	guard := accessGuard("profiling", cfg.Token, cfg.AllowedCIDRs, logger)
+
+	mux.Handle("/debug/pprof/", guard(http.HandlerFunc(pprof.Index)))
+	mux.Handle("/debug/pprof/cmdline", guard(http.HandlerFunc(pprof.Cmdline)))
+	mux.Handle("/debug/pprof/profile", guard(http.HandlerFunc(pprof.Profile)))
+	mux.Handle("/debug/pprof/symbol", guard(http.HandlerFunc(pprof.Symbol)))
+	mux.Handle("/debug/pprof/trace", guard(http.HandlerFunc(pprof.Trace)))
+}
+
+// accessGuard restricts a handler to requests bearing token or coming from
+// allowedCIDRs. Requests are rejected when neither is set.
+func accessGuard(name, token string, allowedCIDRs []string, logger *zap.Logger) func(http.Handler) http.Handler {
+	allowed, _ := proxyproto.ParseCIDRs(allowedCIDRs)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			tokenValid := token != "" && subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1
+
+			remote, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+			if !tokenValid && (err != nil || !proxyproto.Contains(allowed, remote)) {
+				logger.Warn("Rejected "+name+" request", zap.String("remote_addr", r.RemoteAddr), zap.String("path", r.URL.Path))
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// initExporters registers the configured push exporters. Exporters that
+// fail to start are skipped; Prometheus keeps working regardless.
+func initExporters(cfg config.MonitoringConfig, metricsManager *metrics.Manager, logger *zap.Logger) {
+	if cfg.StatsD.Enabled {
+		exporter, err := metrics.NewStatsDExporter(cfg.StatsD.Address, cfg.StatsD.Prefix, cfg.StatsD.Tags, cfg.StatsD.FlushInterval, logger)
+		if err != nil {
+			logger.Error("Failed to start StatsD exporter", zap.Error(err))
+		} else {
+			metricsManager.AddExporter(exporter)
+			logger.Info("StatsD exporter started", zap.String("address", cfg.StatsD.Address))
+		}
+	}
+
+	if cfg.OTLP.Enabled {
+		metricsManager.AddExporter(metrics.NewOTLPExporter(
+			cfg.OTLP.Endpoint,
+			cfg.OTLP.Headers,
+			cfg.OTLP.ServiceName,
+			cfg.OTLP.Interval,
+			cfg.OTLP.Timeout,
+			logger,
+		))
+		logger.Info("OTLP metrics exporter started", zap.String("endpoint", cfg.OTLP.Endpoint))
+	}
+}
+
+// startAdminGRPCServer serves the gRPC admin API, over TLS with the server
+// certificate when server TLS is enabled
+func startAdminGRPCServer(cfg config.ServerConfig, gw *gateway.Gateway, upgrader *upgrade.Upgrader, logger *zap.Logger) (*grpc.Server, error) {
+	var opts []grpc.ServerOption
+	if cfg.TLS.Enabled {
+		creds, err := credentials.NewServerTLSFromFile(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load admin TLS credentials: %w", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	address := fmt.Sprintf("%s:%d", cfg.Host, cfg.AdminGRPC.Port)
+	listener, err := upgrader.Listen("admin_grpc", address)
+	if err != nil {
+		return nil, err
+	}
+
+	server := gw.NewAdminGRPCServer(opts...)
+	go func() {
+		logger.Info("Starting gRPC admin server",
+			zap.String("address", address),
+			zap.Bool("tls_enabled", cfg.TLS.Enabled))
+		if err := server.Serve(listener); err != nil {
+			logger.Error("gRPC admin server failed", zap.Error(err))
+		}
+	}()
+	return server, nil
+}
+
+// startMetricsServer starts the Prometheus metrics server, which also
+// serves the pprof endpoints when profiling is enabled
+func startMetricsServer(cfg config.MonitoringConfig, metricsManager *metrics.Manager, upgrader *upgrade.Upgrader, logger *zap.Logger) *http.Server {
 	mux := http.NewServeMux()
-	mux.Handle(cfg.Path, metricsManager.Handler())
+	handler := metricsManager.Handler()
+	if cfg.Prometheus.Token != "" || len(cfg.Prometheus.AllowedCIDRs) > 0 {
+		handler = accessGuard("metrics", cfg.Prometheus.Token, cfg.Prometheus.AllowedCIDRs, logger)(handler)
+	}
+	mux.Handle(cfg.Prometheus.Path, handler)
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
 
+	if cfg.Profiling.Enabled {
+		registerProfiling(mux, cfg.Profiling, logger)
+	}
+
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", cfg.Port),
+		Addr:    fmt.Sprintf(":%d", cfg.Prometheus.Port),
 		Handler: mux,
 	}
 
+	listener, err := upgrader.Listen("metrics", server.Addr)
+	if err != nil {
+		logger.Error("Metrics server startup failed", zap.Error(err))
+		return server
+	}
+
 	go func() {
 		logger.Info("Starting metrics server",
 			zap.String("address", server.Addr),
-			zap.String("path", cfg.Path))
+			zap.String("path", cfg.Prometheus.Path),
+			zap.Bool("profiling", cfg.Profiling.Enabled))
 
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
 			logger.Error("Metrics server startup failed", zap.Error(err))
 		}
 	}()
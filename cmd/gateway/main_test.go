@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/auth"
+	"github.com/max/api-gateway/internal/circuit"
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/internal/gateway"
+	"github.com/max/api-gateway/internal/middleware"
+	"github.com/max/api-gateway/internal/proxy"
+	"github.com/max/api-gateway/internal/ratelimit"
+	"github.com/max/api-gateway/internal/upgrade"
+	"github.com/max/api-gateway/pkg/metrics"
+)
+
+func TestRegisterProfiling(t *testing.T) {
+	mux := http.NewServeMux()
+	registerProfiling(mux, config.ProfilingConfig{
+		Enabled:      true,
+		Token:        "profile-token",
+		AllowedCIDRs: []string{"10.0.0.0/8"},
+	}, zap.NewNop())
+
+	tests := []struct {
+		name   string
+		remote string
+		token  string
+		want   int
+	}{
+		{name: "token", remote: "203.0.113.7:40000", token: "profile-token", want: http.StatusOK},
+		{name: "allowed network", remote: "10.1.2.3:40000", want: http.StatusOK},
+		{name: "wrong token", remote: "203.0.113.7:40000", token: "guess", want: http.StatusForbidden},
+		{name: "anonymous", remote: "203.0.113.7:40000", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)
+			r.RemoteAddr = tt.remote
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("GET /debug/pprof/ = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+
+	// Without a token or networks the endpoints stay closed
+	mux = http.NewServeMux()
+	registerProfiling(mux, config.ProfilingConfig{Enabled: true}, zap.NewNop())
+	r := httptest.NewRequest(http.MethodGet, "/debug/pprof/cmdline", nil)
+	r.RemoteAddr = "127.0.0.1:40000"
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("GET /debug/pprof/cmdline without a guard configured = %d, want 403", w.Code)
+	}
+}
+
+func TestStartAdminServerRequiresClientCertificate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zap.NewNop()
+	dir := t.TempDir()
+
+	ca, caKey := newTestCertificate(t, nil, nil, "admin CA")
+	server, serverKey := newTestCertificate(t, ca, caKey, "127.0.0.1")
+	client, clientKey := newTestCertificate(t, ca, caKey, "ops")
+	writeTestPEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", ca.Raw)
+	writeTestPEM(t, filepath.Join(dir, "server.pem"), "CERTIFICATE", server.Raw)
+	writeTestPEM(t, filepath.Join(dir, "server-key.pem"), "EC PRIVATE KEY", marshalTestKey(t, serverKey))
+
+	// Reserve a port for the admin listener
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := probe.Addr().String()
+	probe.Close()
+
+	cfg := &config.Config{}
+	cfg.Server.Admin = config.AdminServerConfig{
+		Enabled:      true,
+		Address:      address,
+		CertFile:     filepath.Join(dir, "server.pem"),
+		KeyFile:      filepath.Join(dir, "server-key.pem"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+	}
+	jwtAuth := auth.NewJWTAuth("test-secret", time.Hour, 24*time.Hour, "gateway", "gateway", "HS256", logger)
+	metricsManager := metrics.NewManager(logger)
+	rateLimiter := ratelimit.NewManager(&cfg.RateLimit, nil, logger)
+	gw := gateway.NewGateway(cfg, config.NewManager(logger), jwtAuth, rateLimiter,
+		circuit.NewManager(logger, metricsManager), proxy.NewProxyManager(logger, metricsManager),
+		middleware.NewManager(cfg, jwtAuth, rateLimiter, nil, metricsManager, logger), metricsManager, logger)
+	if err := gw.SetupRoutes(); err != nil {
+		t.Fatal(err)
+	}
+	upgrader, err := upgrade.New(cfg.Server.Upgrade, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker, err := newConnTracker(cfg.Server, metricsManager)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adminServer, err := startAdminServer(cfg, gw, upgrader, tracker, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adminServer.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	get := func(certificates []tls.Certificate) (*http.Response, error) {
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: certificates,
+		}}}
+		defer httpClient.CloseIdleConnections()
+		return httpClient.Get("https://" + address + "/health/live")
+	}
+
+	if resp, err := get(nil); err == nil {
+		resp.Body.Close()
+		t.Errorf("request without a client certificate = %d, want a rejected handshake", resp.StatusCode)
+	}
+
+	resp, err := get([]tls.Certificate{{Certificate: [][]byte{client.Raw}, PrivateKey: clientKey}})
+	if err != nil {
+		t.Fatalf("request with a client certificate: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("request with a client certificate = %d, want 200", resp.StatusCode)
+	}
+}
+
+// newTestCertificate issues a certificate for name, self-signed when parent
+// is nil. Names that are IP addresses become IP SANs.
+func newTestCertificate(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate, key
+}
+
+func marshalTestKey(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	t.Helper()
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func writeTestPEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"time"
+
+	gocache "github.com/patrickmn/go-cache"
+	"github.com/sony/gobreaker"
+
+	"github.com/max/api-gateway/internal/circuit"
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/internal/middleware"
+	"github.com/max/api-gateway/internal/webhook"
+)
+
+// webhookRateLimitInterval is the least time between rate_limit_exceeded
+// webhooks for the same key
+const webhookRateLimitInterval = time.Minute
+
+// webhookBreakerStateChange returns a listener that sends circuit breaker
+// state changes as breaker_open, breaker_half_open and breaker_closed
+// webhooks
+func webhookBreakerStateChange(webhooks *webhook.Dispatcher) circuit.StateChangeListener {
+	return func(name string, from gobreaker.State, to gobreaker.State, lastFailure string) {
+		eventType := config.WebhookEventBreakerClosed
+		switch to {
+		case gobreaker.StateOpen:
+			eventType = config.WebhookEventBreakerOpen
+		case gobreaker.StateHalfOpen:
+			eventType = config.WebhookEventBreakerHalfOpen
+		}
+		data := map[string]interface{}{
+			"name": name,
+			"from": from.String(),
+			"to":   to.String(),
+		}
+		if lastFailure != "" {
+			data["last_failure"] = lastFailure
+		}
+		webhooks.Dispatch(eventType, data)
+	}
+}
+
+// webhookRateLimitExceeded returns a listener that sends rate_limit_exceeded
+// webhooks, at most one per key every webhookRateLimitInterval
+func webhookRateLimitExceeded(webhooks *webhook.Dispatcher) middleware.RateLimitListener {
+	sent := gocache.New(webhookRateLimitInterval, 2*webhookRateLimitInterval)
+	return func(exceeded middleware.RateLimitExceeded) {
+		if sent.Add(exceeded.Key, struct{}{}, gocache.DefaultExpiration) != nil {
+			return
+		}
+		webhooks.Dispatch(config.WebhookEventRateLimitExceeded, map[string]interface{}{
+			"key":       exceeded.Key,
+			"key_type":  exceeded.KeyType,
+			"rule":      exceeded.Rule,
+			"limit":     exceeded.Limit,
+			"client_ip": exceeded.ClientIP,
+			"method":    exceeded.Method,
+			"path":      exceeded.Path,
+			"route":     exceeded.Route,
+		})
+	}
+}
+
+// webhookConfigReloaded returns a listener that sends config_reloaded
+// webhooks
+func webhookConfigReloaded(webhooks *webhook.Dispatcher) config.ChangeListener {
+	return func(version int64, revision string) {
+		webhooks.Dispatch(config.WebhookEventConfigReloaded, map[string]interface{}{
+			"version":  version,
+			"revision": revision,
+		})
+	}
+}
@@ -0,0 +1,525 @@
+// Command gwctl administers a running gateway through its admin API.
+//
+//	gwctl login -user admin
+//	gwctl services
+//	gwctl routes
+//	gwctl tail
+//	gwctl breakers reset orders
+//	gwctl cache purge acme
+//	gwctl config push configs/config.yaml
+//	gwctl config diff configs/config.yaml
+//
+// The gateway address comes from -server or GWCTL_SERVER, and the admin
+// token from -token, GWCTL_TOKEN or the token saved by gwctl login.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+const usage = `Usage: gwctl [flags] <command> [arguments]
+
+Commands:
+  login -user NAME [-password PASSWORD]   log in and save the admin token
+  services                                list services and their targets
+  routes                                  list the routes of the running config
+  tail                                    follow live traffic per service
+  breakers                                list circuit breakers
+  breakers reset NAME                     reset a circuit breaker
+  cache purge TENANT                      delete a tenant's cached responses
+  config reload                           reload the gateway's config source
+  config push FILE                        apply a config document in memory
+  config diff FILE                        compare the running config with a file
+
+Flags:
+`
+
+// errDiffers makes config diff exit with 1, like diff
+var errDiffers = errors.New("configurations differ")
+
+// client calls the admin API
+type client struct {
+	server string
+	token  string
+	output string
+	http   *http.Client
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	server := flag.String("server", envOr("GWCTL_SERVER", "http://localhost:8080"), "gateway base URL (GWCTL_SERVER)")
+	token := flag.String("token", os.Getenv("GWCTL_TOKEN"), "admin bearer token (GWCTL_TOKEN); defaults to the saved login")
+	output := flag.String("o", "table", "output format: table or json")
+	timeout := flag.Duration("timeout", 30*time.Second, "request timeout")
+	flag.Parse()
+
+	if *output != "table" && *output != "json" {
+		fmt.Fprintln(os.Stderr, "-o must be table or json")
+		os.Exit(2)
+	}
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c := &client{
+		server: strings.TrimSuffix(*server, "/"),
+		token:  *token,
+		output: *output,
+		http:   &http.Client{Timeout: *timeout},
+	}
+	if c.token == "" {
+		c.token = savedToken()
+	}
+
+	if err := c.run(flag.Args()); err != nil {
+		if !errors.Is(err, errDiffers) {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// run dispatches a command
+func (c *client) run(args []string) error {
+	switch {
+	case args[0] == "login":
+		return c.login(args[1:])
+	case args[0] == "services":
+		return c.services()
+	case args[0] == "routes":
+		return c.routes()
+	case args[0] == "tail":
+		return c.tail()
+	case args[0] == "breakers" && len(args) == 1:
+		return c.breakers()
+	case args[0] == "breakers" && len(args) == 3 && args[1] == "reset":
+		return c.message(http.MethodPost, "/admin/circuit-breakers/"+url.PathEscape(args[2])+"/reset", nil)
+	case args[0] == "cache" && len(args) == 3 && args[1] == "purge":
+		return c.message(http.MethodDelete, "/admin/cache/tenants/"+url.PathEscape(args[2]), nil)
+	case args[0] == "config" && len(args) == 2 && args[1] == "reload":
+		return c.message(http.MethodPost, "/admin/config/reload", nil)
+	case args[0] == "config" && len(args) == 3 && args[1] == "push":
+		data, err := os.ReadFile(args[2])
+		if err != nil {
+			return err
+		}
+		return c.message(http.MethodPut, "/admin/config", data)
+	case args[0] == "config" && len(args) == 3 && args[1] == "diff":
+		return c.diff(args[2])
+	}
+	flag.Usage()
+	return fmt.Errorf("unknown command: %s", strings.Join(args, " "))
+}
+
+// do calls the admin API and decodes the JSON response into out. Error
+// responses are returned with their message.
+func (c *client) do(method, path string, body []byte, out interface{}) error {
+	req, err := http.NewRequest(method, c.server+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/yaml")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &failure) == nil && failure.Error != "" {
+			return fmt.Errorf("%s %s: %s (%d)", method, path, failure.Error, resp.StatusCode)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// message runs a command answered with a message, printing the response
+func (c *client) message(method, path string, body []byte) error {
+	var resp map[string]interface{}
+	if err := c.do(method, path, body, &resp); err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return printJSON(resp)
+	}
+	if message, ok := resp["message"].(string); ok {
+		fmt.Println(message)
+		return nil
+	}
+	return printJSON(resp)
+}
+
+// login exchanges credentials for a token and saves it
+func (c *client) login(args []string) error {
+	flags := flag.NewFlagSet("login", flag.ContinueOnError)
+	user := flags.String("user", "", "username")
+	password := flags.String("password", os.Getenv("GWCTL_PASSWORD"), "password (GWCTL_PASSWORD); read from stdin when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *user == "" {
+		return fmt.Errorf("-user is required")
+	}
+	if *password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("failed to read password: %w", err)
+		}
+		*password = strings.TrimRight(line, "\r\n")
+	}
+
+	body, _ := json.Marshal(map[string]string{"username": *user, "password": *password})
+	req, err := http.NewRequest(http.MethodPost, c.server+"/auth/login", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Token string `json:"token"`
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	switch {
+	case resp.StatusCode != http.StatusOK && result.Error != "":
+		return fmt.Errorf("login failed: %s", result.Error)
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("login failed: %s", resp.Status)
+	case result.Token == "":
+		return fmt.Errorf("login needs a second factor; log in elsewhere and pass the token with -token")
+	}
+
+	path, err := tokenPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(result.Token), 0o600); err != nil {
+		return err
+	}
+	fmt.Println("Token saved to", path)
+	return nil
+}
+
+// services lists the services and the health of their targets
+func (c *client) services() error {
+	var resp struct {
+		Services []string `json:"services"`
+		Targets  map[string][]struct {
+			URL     string `json:"url"`
+			Healthy bool   `json:"healthy"`
+			Weight  int    `json:"weight"`
+		} `json:"targets"`
+	}
+	if err := c.do(http.MethodGet, "/admin/services", nil, &resp); err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return printJSON(resp)
+	}
+
+	w := table("SERVICE", "TARGET", "HEALTHY", "WEIGHT")
+	for _, service := range resp.Services {
+		for _, target := range resp.Targets[service] {
+			fmt.Fprintf(w, "%s\t%s\t%t\t%d\n", service, target.URL, target.Healthy, target.Weight)
+		}
+	}
+	return w.Flush()
+}
+
+// routes lists the service and composite routes of the running config
+func (c *client) routes() error {
+	var cfg config.Config
+	if err := c.do(http.MethodGet, "/admin/config", nil, &cfg); err != nil {
+		return err
+	}
+
+	type route struct {
+		Path    string `json:"path"`
+		Method  string `json:"method"`
+		Target  string `json:"target"`
+		Auth    string `json:"auth"`
+		Timeout string `json:"timeout,omitempty"`
+	}
+	var routes []route
+	for name, service := range cfg.Routing.Services {
+		r := route{Path: "/" + name + "/*", Method: "*", Target: name, Auth: service.Auth}
+		if service.Timeout > 0 {
+			r.Timeout = service.Timeout.String()
+		}
+		routes = append(routes, r)
+	}
+	for _, composite := range cfg.Routing.Composites {
+		method := composite.Method
+		if method == "" {
+			method = http.MethodGet
+		}
+		branches := make([]string, 0, len(composite.Branches))
+		for _, branch := range composite.Branches {
+			branches = append(branches, branch.Service)
+		}
+		auth := config.AuthNone
+		if composite.RequireAuth {
+			auth = config.AuthRequired
+		}
+		r := route{Path: composite.Path, Method: method, Target: "composite: " + strings.Join(branches, ", "), Auth: auth}
+		if composite.Timeout > 0 {
+			r.Timeout = composite.Timeout.String()
+		}
+		routes = append(routes, r)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Path < routes[j].Path })
+	if c.output == "json" {
+		return printJSON(routes)
+	}
+
+	w := table("ROUTE", "METHOD", "TARGET", "AUTH", "TIMEOUT")
+	for _, r := range routes {
+		auth := r.Auth
+		if auth == "" {
+			auth = "default"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Path, r.Method, r.Target, auth, r.Timeout)
+	}
+	return w.Flush()
+}
+
+// breakers lists the circuit breakers
+func (c *client) breakers() error {
+	var resp struct {
+		Breakers map[string]struct {
+			Name                string `json:"name"`
+			State               string `json:"state"`
+			Requests            uint32 `json:"requests"`
+			TotalFailures       uint32 `json:"total_failures"`
+			ConsecutiveFailures uint32 `json:"consecutive_failures"`
+		} `json:"circuit_breakers"`
+	}
+	if err := c.do(http.MethodGet, "/admin/circuit-breakers", nil, &resp); err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return printJSON(resp.Breakers)
+	}
+
+	names := make([]string, 0, len(resp.Breakers))
+	for name := range resp.Breakers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w := table("NAME", "STATE", "REQUESTS", "FAILURES", "CONSECUTIVE FAILURES")
+	for _, name := range names {
+		b := resp.Breakers[name]
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\n", name, b.State, b.Requests, b.TotalFailures, b.ConsecutiveFailures)
+	}
+	return w.Flush()
+}
+
+// tail follows the dashboard live feed and prints each service's traffic
+// since the previous snapshot, until interrupted
+func (c *client) tail() error {
+	feed, err := url.Parse(c.server + "/admin/live")
+	if err != nil {
+		return err
+	}
+	feed.Scheme = strings.Replace(feed.Scheme, "http", "ws", 1)
+	header := http.Header{}
+	if c.token != "" {
+		header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, feed.String(), header)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("live feed: %s", resp.Status)
+		}
+		return err
+	}
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	type serviceStats struct {
+		Requests       int64   `json:"requests"`
+		Errors         int64   `json:"errors"`
+		ServerErrors   int64   `json:"server_errors"`
+		TotalLatencyMs float64 `json:"total_latency_ms"`
+	}
+	var previous map[string]serviceStats
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if c.output == "json" {
+			fmt.Println(string(data))
+			continue
+		}
+
+		var snapshot struct {
+			Timestamp time.Time               `json:"timestamp"`
+			Services  map[string]serviceStats `json:"services"`
+		}
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return err
+		}
+		// The first snapshot is the baseline
+		if previous != nil {
+			names := make([]string, 0, len(snapshot.Services))
+			for name := range snapshot.Services {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				now, before := snapshot.Services[name], previous[name]
+				requests := now.Requests - before.Requests
+				if requests <= 0 {
+					continue
+				}
+				fmt.Printf("%s  %-20s %6d req %5d err %5d 5xx  avg %.1fms\n",
+					snapshot.Timestamp.Local().Format("15:04:05"), name, requests,
+					now.Errors-before.Errors, now.ServerErrors-before.ServerErrors,
+					(now.TotalLatencyMs-before.TotalLatencyMs)/float64(requests))
+			}
+		}
+		previous = snapshot.Services
+	}
+}
+
+// diff compares the running config with a config file, printing the keys
+// that differ: - for the running value and + for the file's
+func (c *client) diff(path string) error {
+	local := config.NewManager(zap.NewNop())
+	if err := local.Load(path); err != nil {
+		return err
+	}
+	var running config.Config
+	if err := c.do(http.MethodGet, "/admin/config", nil, &running); err != nil {
+		return err
+	}
+
+	changes := config.Diff(&running, local.Get())
+	if c.output == "json" {
+		if err := printJSON(changes); err != nil {
+			return err
+		}
+	} else {
+		for _, change := range changes {
+			if change.Running != "" {
+				fmt.Printf("- %s: %s\n", change.Key, change.Running)
+			}
+			if change.Other != "" {
+				fmt.Printf("+ %s: %s\n", change.Key, change.Other)
+			}
+		}
+	}
+	if len(changes) > 0 {
+		return errDiffers
+	}
+	return nil
+}
+
+// table starts a table with the given columns
+func table(columns ...string) *tabwriter.Writer {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(columns, "\t"))
+	return w
+}
+
+// printJSON prints a value as indented JSON
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// tokenPath returns where gwctl login saves the token
+func tokenPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gwctl", "token"), nil
+}
+
+// savedToken returns the token saved by gwctl login, if any
+func savedToken() string {
+	path, err := tokenPath()
+	if err != nil {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// envOr returns an environment variable, or fallback when it is unset
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// adminRequest is a request received by the fake admin API
+type adminRequest struct {
+	method        string
+	path          string
+	authorization string
+	contentType   string
+	body          string
+}
+
+// fakeAdminAPI records requests and answers them with the response for
+// "METHOD /path", or a message when there is none
+func fakeAdminAPI(t *testing.T, responses map[string]string) (*httptest.Server, func() []adminRequest) {
+	t.Helper()
+	var (
+		mu       sync.Mutex
+		requests []adminRequest
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, adminRequest{
+			method:        r.Method,
+			path:          r.URL.EscapedPath(),
+			authorization: r.Header.Get("Authorization"),
+			contentType:   r.Header.Get("Content-Type"),
+			body:          string(body),
+		})
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if response, ok := responses[r.Method+" "+r.URL.Path]; ok {
+			if strings.Contains(response, `"error"`) {
+				w.WriteHeader(http.StatusForbidden)
+			}
+			io.WriteString(w, response)
+			return
+		}
+		io.WriteString(w, `{"message":"done"}`)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []adminRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]adminRequest(nil), requests...)
+	}
+}
+
+// captureStdout returns what fn prints to standard output
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+	err = fn()
+	w.Close()
+	return <-output, err
+}
+
+func TestRunCommands(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configFile, []byte("server:\n  port: 9000\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args []string
+		want adminRequest
+	}{
+		{[]string{"breakers", "reset", "orders"}, adminRequest{method: http.MethodPost, path: "/admin/circuit-breakers/orders/reset"}},
+		{[]string{"cache", "purge", "acme corp"}, adminRequest{method: http.MethodDelete, path: "/admin/cache/tenants/acme%20corp"}},
+		{[]string{"config", "reload"}, adminRequest{method: http.MethodPost, path: "/admin/config/reload"}},
+		{[]string{"config", "push", configFile}, adminRequest{method: http.MethodPut, path: "/admin/config",
+			contentType: "application/yaml", body: "server:\n  port: 9000\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args[:2], " "), func(t *testing.T) {
+			server, requests := fakeAdminAPI(t, nil)
+			c := &client{server: server.URL, token: "admin-token", output: "table", http: server.Client()}
+
+			output, err := captureStdout(t, func() error { return c.run(tt.args) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if output != "done\n" {
+				t.Errorf("output = %q, want the response message", output)
+			}
+			tt.want.authorization = "Bearer admin-token"
+			if got := requests(); len(got) != 1 || got[0] != tt.want {
+				t.Errorf("requests = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	c := &client{server: "http://localhost", output: "table", http: http.DefaultClient}
+	if err := c.run([]string{"breakers", "open", "orders"}); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("run() of an unknown command = %v, want an unknown command error", err)
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	server, _ := fakeAdminAPI(t, map[string]string{
+		"GET /admin/services": `{"error":"Admin role required"}`,
+	})
+	c := &client{server: server.URL, output: "table", http: server.Client()}
+
+	err := c.services()
+	if err == nil || err.Error() != "GET /admin/services: Admin role required (403)" {
+		t.Errorf("services() = %v, want the error message and status", err)
+	}
+}
+
+func TestServicesAndBreakers(t *testing.T) {
+	server, _ := fakeAdminAPI(t, map[string]string{
+		"GET /admin/services": `{"services":["orders"],"targets":{"orders":[
+			{"url":"http://orders-1:8080","healthy":true,"weight":3},
+			{"url":"http://orders-2:8080","healthy":false,"weight":1}]}}`,
+		"GET /admin/circuit-breakers": `{"circuit_breakers":{
+			"users":{"state":"closed","requests":10},
+			"orders":{"state":"open","requests":7,"total_failures":5,"consecutive_failures":5}}}`,
+	})
+	c := &client{server: server.URL, output: "table", http: server.Client()}
+
+	output, err := captureStdout(t, c.services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "SERVICE") ||
+		strings.Join(strings.Fields(lines[2]), " ") != "orders http://orders-2:8080 false 1" {
+		t.Errorf("services output =\n%s", output)
+	}
+
+	output, err = captureStdout(t, c.breakers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines = strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 3 || strings.Join(strings.Fields(lines[1]), " ") != "orders open 7 5 5" ||
+		!strings.HasPrefix(lines[2], "users") {
+		t.Errorf("breakers output =\n%s", output)
+	}
+
+	c.output = "json"
+	output, err = captureStdout(t, c.breakers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var breakers map[string]map[string]interface{}
+	if err := json.Unmarshal([]byte(output), &breakers); err != nil || breakers["orders"]["state"] != "open" {
+		t.Errorf("breakers JSON output = %s (%v)", output, err)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	server, requests := fakeAdminAPI(t, map[string]string{
+		"POST /auth/login": `{"token":"issued-token"}`,
+	})
+	c := &client{server: server.URL, output: "table", http: server.Client()}
+
+	if _, err := captureStdout(t, func() error { return c.login([]string{"-user", "admin", "-password", "secret"}) }); err != nil {
+		t.Fatal(err)
+	}
+	var credentials map[string]string
+	if got := requests(); len(got) != 1 || json.Unmarshal([]byte(got[0].body), &credentials) != nil ||
+		credentials["username"] != "admin" || credentials["password"] != "secret" {
+		t.Errorf("login requests = %+v", got)
+	}
+	if token := savedToken(); token != "issued-token" {
+		t.Errorf("savedToken() = %q, want the issued token", token)
+	}
+	path, _ := tokenPath()
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("token file = %v, %v; want mode 0600", info, err)
+	}
+
+	// Logins waiting for a second factor return no token
+	server, _ = fakeAdminAPI(t, map[string]string{"POST /auth/login": `{"mfa_required":true}`})
+	c.server = server.URL
+	if err := c.login([]string{"-user", "admin", "-password", "secret"}); err == nil {
+		t.Error("login() without a token in the response = nil, want an error")
+	}
+	if err := c.login(nil); err == nil {
+		t.Error("login() without -user = nil, want an error")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	local := config.NewManager(zap.NewNop())
+	if err := local.Load("../../configs/config.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	running, err := json.Marshal(local.Get())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, _ := fakeAdminAPI(t, map[string]string{"GET /admin/config": string(running)})
+	c := &client{server: server.URL, output: "table", http: server.Client()}
+
+	output, err := captureStdout(t, func() error { return c.diff("../../configs/config.yaml") })
+	if err != nil || output != "" {
+		t.Errorf("diff() of the running config = %v, output %q; want no differences", err, output)
+	}
+
+	base, err := os.ReadFile("../../configs/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(changed, []byte(strings.Replace(string(base), "  port: 8080", "  port: 9000", 1)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	output, err = captureStdout(t, func() error { return c.diff(changed) })
+	if !errors.Is(err, errDiffers) {
+		t.Errorf("diff() of a changed config = %v, want errDiffers", err)
+	}
+	if output != "- server.port: 8080\n+ server.port: 9000\n" {
+		t.Errorf("diff output = %q", output)
+	}
+}
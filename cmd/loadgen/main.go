@@ -0,0 +1,466 @@
+// Command loadgen drives a fixed request rate against gateway routes,
+// reports latency percentiles and checks them against SLOs.
+//
+//	loadgen -scenario configs/loadgen.yaml
+//	loadgen -scenario configs/loadgen.yaml -rps 500 -duration 2m -json
+//
+// It exits with status 1 when an SLO is missed.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+
+	"github.com/max/api-gateway/internal/auth"
+	"github.com/max/api-gateway/internal/config"
+)
+
+// Scenario describes a load test
+type Scenario struct {
+	Target      string        `yaml:"target"`
+	RPS         float64       `yaml:"rps"`
+	Duration    time.Duration `yaml:"duration"`
+	Warmup      time.Duration `yaml:"warmup"` // Requests sent before measuring starts
+	Concurrency int           `yaml:"concurrency"`
+	Timeout     time.Duration `yaml:"timeout"`
+	Auth        AuthConfig    `yaml:"auth"`
+	Routes      []Route       `yaml:"routes"`
+	SLOs        SLOs          `yaml:"slos"`
+}
+
+// AuthConfig sets the credentials sent with every request
+type AuthConfig struct {
+	// GatewayConfig is a gateway configuration file whose auth.jwt settings
+	// are used to sign tokens for UserID and Roles
+	GatewayConfig string   `yaml:"gateway_config"`
+	UserID        string   `yaml:"user_id"`
+	Roles         []string `yaml:"roles"`
+	Token         string   `yaml:"token"` // Static bearer token
+	APIKey        string   `yaml:"api_key"`
+	APIKeyHeader  string   `yaml:"api_key_header"`
+}
+
+// Route is a request in the traffic mix
+type Route struct {
+	Name         string            `yaml:"name"`
+	Method       string            `yaml:"method"`
+	Path         string            `yaml:"path"`
+	Weight       int               `yaml:"weight"` // Share of the traffic relative to other routes
+	Headers      map[string]string `yaml:"headers"`
+	Body         string            `yaml:"body"`
+	ExpectStatus int               `yaml:"expect_status"` // Any 2xx or 3xx when unset
+}
+
+// SLOs are the latency and error objectives of the whole run; zero values
+// are not checked
+type SLOs struct {
+	P50       time.Duration `yaml:"p50"`
+	P95       time.Duration `yaml:"p95"`
+	P99       time.Duration `yaml:"p99"`
+	ErrorRate float64       `yaml:"error_rate"` // Fraction of requests
+}
+
+// sample is the outcome of one request
+type sample struct {
+	route   int
+	latency time.Duration
+	failed  bool
+}
+
+// Stats summarizes the samples of a route or the whole run
+type Stats struct {
+	Requests  int     `json:"requests"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	P50Ms     float64 `json:"p50_ms"`
+	P95Ms     float64 `json:"p95_ms"`
+	P99Ms     float64 `json:"p99_ms"`
+	MaxMs     float64 `json:"max_ms"`
+}
+
+// Report is the result of a run
+type Report struct {
+	Duration    string           `json:"duration"`
+	AchievedRPS float64          `json:"achieved_rps"`
+	Skipped     int              `json:"skipped"` // Requests not sent because all workers were busy
+	Total       Stats            `json:"total"`
+	Routes      map[string]Stats `json:"routes"`
+	Violations  []string         `json:"slo_violations"`
+}
+
+func main() {
+	scenarioPath := flag.String("scenario", "configs/loadgen.yaml", "scenario file")
+	target := flag.String("target", "", "override the scenario target")
+	rps := flag.Float64("rps", 0, "override the scenario request rate")
+	duration := flag.Duration("duration", 0, "override the scenario duration")
+	jsonOutput := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	scenario, err := loadScenario(*scenarioPath)
+	if err == nil {
+		if *target != "" {
+			scenario.Target = *target
+		}
+		if *rps > 0 {
+			scenario.RPS = *rps
+		}
+		if *duration > 0 {
+			scenario.Duration = *duration
+		}
+		err = scenario.validate()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid scenario: %v\n", err)
+		os.Exit(2)
+	}
+
+	headers, err := authHeaders(scenario.Auth)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to prepare credentials: %v\n", err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	report := run(ctx, scenario, headers)
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		printReport(scenario, report)
+	}
+	if len(report.Violations) > 0 {
+		os.Exit(1)
+	}
+}
+
+// loadScenario reads a scenario file and applies defaults
+func loadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	scenario := &Scenario{
+		RPS:         10,
+		Duration:    30 * time.Second,
+		Concurrency: 64,
+		Timeout:     10 * time.Second,
+	}
+	if err := yaml.Unmarshal(data, scenario); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for i := range scenario.Routes {
+		route := &scenario.Routes[i]
+		if route.Method == "" {
+			route.Method = http.MethodGet
+		}
+		route.Method = strings.ToUpper(route.Method)
+		if route.Weight == 0 {
+			route.Weight = 1
+		}
+		if route.Name == "" {
+			route.Name = route.Method + " " + route.Path
+		}
+	}
+	if scenario.Auth.APIKeyHeader == "" {
+		scenario.Auth.APIKeyHeader = "X-API-Key"
+	}
+	return scenario, nil
+}
+
+// validate checks a scenario
+func (s *Scenario) validate() error {
+	if !strings.HasPrefix(s.Target, "http://") && !strings.HasPrefix(s.Target, "https://") {
+		return fmt.Errorf("target must be an http or https URL")
+	}
+	s.Target = strings.TrimSuffix(s.Target, "/")
+	if s.RPS <= 0 || s.Duration <= 0 || s.Concurrency <= 0 || s.Timeout <= 0 {
+		return fmt.Errorf("rps, duration, concurrency and timeout must be positive")
+	}
+	if len(s.Routes) == 0 {
+		return fmt.Errorf("at least one route is required")
+	}
+	names := make(map[string]bool, len(s.Routes))
+	for _, route := range s.Routes {
+		if names[route.Name] {
+			return fmt.Errorf("duplicate route: %s", route.Name)
+		}
+		names[route.Name] = true
+		if !strings.HasPrefix(route.Path, "/") {
+			return fmt.Errorf("route %s: path must start with /", route.Name)
+		}
+		if route.Weight < 0 {
+			return fmt.Errorf("route %s: weight must not be negative", route.Name)
+		}
+	}
+	return nil
+}
+
+// authHeaders returns the credential headers of every request. Tokens are
+// signed with the gateway's JWT settings when a gateway config is given.
+func authHeaders(cfg AuthConfig) (http.Header, error) {
+	headers := make(http.Header)
+	if cfg.APIKey != "" {
+		headers.Set(cfg.APIKeyHeader, cfg.APIKey)
+	}
+	switch {
+	case cfg.Token != "":
+		headers.Set("Authorization", "Bearer "+cfg.Token)
+	case cfg.GatewayConfig != "":
+		manager := config.NewManager(zap.NewNop())
+		if err := manager.Load(cfg.GatewayConfig); err != nil {
+			return nil, err
+		}
+		jwtCfg := manager.Get().Auth.JWT
+		jwtAuth := auth.NewJWTAuth(jwtCfg.Secret, jwtCfg.ExpirationTime, jwtCfg.RefreshTime,
+			jwtCfg.Issuer, jwtCfg.Audience, jwtCfg.Algorithm, zap.NewNop())
+		userID := cfg.UserID
+		if userID == "" {
+			userID = "loadgen"
+		}
+		token, err := jwtAuth.GenerateToken(userID, userID, "", cfg.Roles, nil)
+		if err != nil {
+			return nil, err
+		}
+		headers.Set("Authorization", "Bearer "+token)
+	}
+	return headers, nil
+}
+
+// run sends requests at the scenario rate and collects the measured
+// samples. Requests are sent on schedule whether or not earlier ones have
+// completed, so a slow gateway cannot slow the load down; when every
+// worker is busy the request is skipped and counted.
+func run(ctx context.Context, s *Scenario, headers http.Header) Report {
+	client := &http.Client{Timeout: s.Timeout}
+	picker := newPicker(s.Routes)
+
+	var (
+		mu      sync.Mutex
+		samples []sample
+		skipped int
+		wg      sync.WaitGroup
+	)
+	slots := make(chan struct{}, s.Concurrency)
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / s.RPS))
+	defer ticker.Stop()
+	start := time.Now()
+	measureFrom := start.Add(s.Warmup)
+	deadline := measureFrom.Add(s.Duration)
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case now := <-ticker.C:
+			if now.After(deadline) {
+				break loop
+			}
+			measured := !now.Before(measureFrom)
+			select {
+			case slots <- struct{}{}:
+			default:
+				if measured {
+					mu.Lock()
+					skipped++
+					mu.Unlock()
+				}
+				continue
+			}
+
+			i := picker.pick()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-slots }()
+				result := send(ctx, client, s.Target, s.Routes[i], headers)
+				result.route = i
+				if measured {
+					mu.Lock()
+					samples = append(samples, result)
+					mu.Unlock()
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	elapsed := time.Since(measureFrom)
+	if elapsed > s.Duration {
+		elapsed = s.Duration
+	}
+	report := Report{
+		Duration:    elapsed.Round(time.Millisecond).String(),
+		AchievedRPS: float64(len(samples)) / elapsed.Seconds(),
+		Skipped:     skipped,
+		Total:       summarize(samples),
+		Routes:      make(map[string]Stats),
+	}
+	for i, route := range s.Routes {
+		var routeSamples []sample
+		for _, result := range samples {
+			if result.route == i {
+				routeSamples = append(routeSamples, result)
+			}
+		}
+		report.Routes[route.Name] = summarize(routeSamples)
+	}
+	report.Violations = checkSLOs(s.SLOs, report.Total)
+	return report
+}
+
+// send makes one request and classifies its outcome
+func send(ctx context.Context, client *http.Client, target string, route Route, headers http.Header) sample {
+	req, err := http.NewRequestWithContext(ctx, route.Method, target+route.Path, strings.NewReader(route.Body))
+	if err != nil {
+		return sample{failed: true}
+	}
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+	for name, value := range route.Headers {
+		req.Header.Set(name, value)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return sample{latency: time.Since(start), failed: true}
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	latency := time.Since(start)
+
+	failed := resp.StatusCode >= 400
+	if route.ExpectStatus != 0 {
+		failed = resp.StatusCode != route.ExpectStatus
+	}
+	return sample{latency: latency, failed: failed}
+}
+
+// picker chooses routes by weight
+type picker struct {
+	cumulative []int
+	total      int
+	mu         sync.Mutex
+	rand       *rand.Rand
+}
+
+func newPicker(routes []Route) *picker {
+	p := &picker{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	for _, route := range routes {
+		p.total += route.Weight
+		p.cumulative = append(p.cumulative, p.total)
+	}
+	return p
+}
+
+// pick returns the index of the next route
+func (p *picker) pick() int {
+	p.mu.Lock()
+	n := p.rand.Intn(p.total)
+	p.mu.Unlock()
+	return sort.SearchInts(p.cumulative, n+1)
+}
+
+// summarize computes the statistics of samples
+func summarize(samples []sample) Stats {
+	stats := Stats{Requests: len(samples)}
+	if len(samples) == 0 {
+		return stats
+	}
+	latencies := make([]time.Duration, len(samples))
+	for i, result := range samples {
+		latencies[i] = result.latency
+		if result.failed {
+			stats.Errors++
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	stats.ErrorRate = float64(stats.Errors) / float64(len(samples))
+	stats.P50Ms = milliseconds(percentile(latencies, 50))
+	stats.P95Ms = milliseconds(percentile(latencies, 95))
+	stats.P99Ms = milliseconds(percentile(latencies, 99))
+	stats.MaxMs = milliseconds(latencies[len(latencies)-1])
+	return stats
+}
+
+// checkSLOs returns the objectives the run missed
+func checkSLOs(slos SLOs, total Stats) []string {
+	violations := []string{}
+	if total.Requests == 0 {
+		return append(violations, "no requests were measured")
+	}
+	for _, check := range []struct {
+		name      string
+		objective time.Duration
+		actualMs  float64
+	}{
+		{"p50", slos.P50, total.P50Ms},
+		{"p95", slos.P95, total.P95Ms},
+		{"p99", slos.P99, total.P99Ms},
+	} {
+		if check.objective > 0 && check.actualMs > milliseconds(check.objective) {
+			violations = append(violations, fmt.Sprintf("%s latency %.1fms exceeds %s", check.name, check.actualMs, check.objective))
+		}
+	}
+	if slos.ErrorRate > 0 && total.ErrorRate > slos.ErrorRate {
+		violations = append(violations, fmt.Sprintf("error rate %.4f exceeds %.4f", total.ErrorRate, slos.ErrorRate))
+	}
+	return violations
+}
+
+// printReport prints a human-readable report
+func printReport(s *Scenario, report Report) {
+	fmt.Printf("Target %s: %.1f req/s over %s (requested %.1f), %d skipped\n\n",
+		s.Target, report.AchievedRPS, report.Duration, s.RPS, report.Skipped)
+	fmt.Printf("%-30s %9s %8s %9s %9s %9s %9s\n", "ROUTE", "REQUESTS", "ERRORS", "P50", "P95", "P99", "MAX")
+	for _, route := range s.Routes {
+		printStats(route.Name, report.Routes[route.Name])
+	}
+	printStats("total", report.Total)
+
+	if len(report.Violations) == 0 {
+		fmt.Println("\nAll SLOs met")
+		return
+	}
+	fmt.Println("\nSLO violations:")
+	for _, violation := range report.Violations {
+		fmt.Println("  " + violation)
+	}
+}
+
+func printStats(name string, stats Stats) {
+	fmt.Printf("%-30s %9d %8d %7.1fms %7.1fms %7.1fms %7.1fms\n",
+		name, stats.Requests, stats.Errors, stats.P50Ms, stats.P95Ms, stats.P99Ms, stats.MaxMs)
+}
+
+// percentile returns the p-th percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(float64(len(sorted))*p/100+0.5) - 1
+	return sorted[min(max(i, 0), len(sorted)-1)]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
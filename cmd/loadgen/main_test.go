@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/auth"
+)
+
+func TestLoadScenario(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scenario.yaml")
+	scenario := "target: http://localhost:8080/\nroutes:\n  - path: /orders\n  - name: create\n    method: post\n    path: /orders\n    weight: 3\n"
+	if err := os.WriteFile(path, []byte(scenario), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := loadScenario(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.validate(); err != nil {
+		t.Fatalf("validate() = %v", err)
+	}
+	if s.Target != "http://localhost:8080" || s.RPS != 10 || s.Concurrency != 64 || s.Auth.APIKeyHeader != "X-API-Key" {
+		t.Errorf("scenario defaults = %+v", s)
+	}
+	if route := s.Routes[0]; route.Name != "GET /orders" || route.Weight != 1 {
+		t.Errorf("default route = %+v", route)
+	}
+	if route := s.Routes[1]; route.Method != http.MethodPost || route.Weight != 3 {
+		t.Errorf("route = %+v, want POST with weight 3", route)
+	}
+
+	for _, invalid := range []Scenario{
+		{Target: "localhost:8080", RPS: 1, Duration: time.Second, Concurrency: 1, Timeout: time.Second, Routes: s.Routes},
+		{Target: "http://localhost", Duration: time.Second, Concurrency: 1, Timeout: time.Second, Routes: s.Routes},
+		{Target: "http://localhost", RPS: 1, Duration: time.Second, Concurrency: 1, Timeout: time.Second},
+		{Target: "http://localhost", RPS: 1, Duration: time.Second, Concurrency: 1, Timeout: time.Second,
+			Routes: []Route{{Name: "a", Path: "/a"}, {Name: "a", Path: "/b"}}},
+		{Target: "http://localhost", RPS: 1, Duration: time.Second, Concurrency: 1, Timeout: time.Second,
+			Routes: []Route{{Name: "a", Path: "a"}}},
+	} {
+		if err := invalid.validate(); err == nil {
+			t.Errorf("validate() of %+v = nil, want an error", invalid)
+		}
+	}
+}
+
+func TestAuthHeaders(t *testing.T) {
+	headers, err := authHeaders(AuthConfig{Token: "static", APIKey: "k-123", APIKeyHeader: "X-API-Key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headers.Get("Authorization") != "Bearer static" || headers.Get("X-API-Key") != "k-123" {
+		t.Errorf("headers = %v", headers)
+	}
+
+	// Tokens signed with the gateway's settings validate there
+	headers, err = authHeaders(AuthConfig{GatewayConfig: "../../configs/config.yaml", UserID: "alice", Roles: []string{"admin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwtAuth := auth.NewJWTAuth("your-super-secret-jwt-key-change-this-in-production", time.Hour, 24*time.Hour,
+		"api-gateway", "api-gateway-users", "HS256", zap.NewNop())
+	claims, err := jwtAuth.ValidateToken(strings.TrimPrefix(headers.Get("Authorization"), "Bearer "))
+	if err != nil {
+		t.Fatalf("signed token does not validate: %v", err)
+	}
+	if claims.UserID != "alice" || len(claims.Roles) != 1 || claims.Roles[0] != "admin" {
+		t.Errorf("claims = %+v, want alice with the admin role", claims)
+	}
+}
+
+func TestRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer static" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	s := &Scenario{
+		Target:      server.URL,
+		RPS:         200,
+		Duration:    300 * time.Millisecond,
+		Concurrency: 8,
+		Timeout:     time.Second,
+		Routes: []Route{
+			{Name: "ok", Method: http.MethodGet, Path: "/ok", Weight: 1},
+			{Name: "created", Method: http.MethodPost, Path: "/orders", Weight: 1, ExpectStatus: http.StatusCreated},
+		},
+		SLOs: SLOs{P99: time.Second, ErrorRate: 0.1},
+	}
+	headers, err := authHeaders(AuthConfig{Token: "static"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := run(context.Background(), s, headers)
+	ok, created := report.Routes["ok"], report.Routes["created"]
+	if report.Total.Requests < 20 || ok.Requests+created.Requests != report.Total.Requests {
+		t.Fatalf("requests = %d (ok %d, created %d), want about 60", report.Total.Requests, ok.Requests, created.Requests)
+	}
+	if ok.Errors != 0 || created.Errors != created.Requests {
+		t.Errorf("errors = ok %d, created %d/%d; want only the unexpected statuses", ok.Errors, created.Errors, created.Requests)
+	}
+	if len(report.Violations) != 1 || !strings.HasPrefix(report.Violations[0], "error rate") {
+		t.Errorf("violations = %v, want only the error rate", report.Violations)
+	}
+}
+
+func TestSummarizeAndCheckSLOs(t *testing.T) {
+	var samples []sample
+	for i := 1; i <= 100; i++ {
+		samples = append(samples, sample{latency: time.Duration(i) * time.Millisecond, failed: i > 98})
+	}
+
+	stats := summarize(samples)
+	if stats.Requests != 100 || stats.Errors != 2 || stats.ErrorRate != 0.02 {
+		t.Errorf("counts = %+v", stats)
+	}
+	if stats.P50Ms != 50 || stats.P95Ms != 95 || stats.P99Ms != 99 || stats.MaxMs != 100 {
+		t.Errorf("percentiles = %+v, want 50/95/99/100ms", stats)
+	}
+
+	if violations := checkSLOs(SLOs{P95: 100 * time.Millisecond, ErrorRate: 0.05}, stats); len(violations) != 0 {
+		t.Errorf("violations = %v, want none", violations)
+	}
+	violations := checkSLOs(SLOs{P50: 10 * time.Millisecond, P99: 100 * time.Millisecond, ErrorRate: 0.01}, stats)
+	if len(violations) != 2 || !strings.HasPrefix(violations[0], "p50") || !strings.HasPrefix(violations[1], "error rate") {
+		t.Errorf("violations = %v, want p50 and error rate", violations)
+	}
+	if violations := checkSLOs(SLOs{}, summarize(nil)); len(violations) != 1 {
+		t.Errorf("violations of an empty run = %v, want one", violations)
+	}
+}
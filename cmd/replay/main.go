@@ -0,0 +1,271 @@
+// Command replay re-sends traffic captured by the gateway against another
+// gateway, such as staging, and reports how the responses compare.
+//
+//	replay -file capture.jsonl -target http://staging:8080 -speed 2
+//	replay -kafka kafka:9092 -topic gateway-capture -target http://staging:8080 -speed 0
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/max/api-gateway/internal/capture"
+)
+
+// headerFlags collects repeated -header flags
+type headerFlags []string
+
+func (h *headerFlags) String() string { return strings.Join(*h, ", ") }
+
+func (h *headerFlags) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return fmt.Errorf("header %q must be \"Name: value\"", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// options are the command line options
+type options struct {
+	file        string
+	brokers     string
+	topic       string
+	target      string
+	speed       float64
+	concurrency int
+	timeout     time.Duration
+	headers     http.Header
+}
+
+// outcome is the result of replaying one exchange
+type outcome struct {
+	exchange capture.Exchange
+	status   int
+	latency  time.Duration
+	err      error
+}
+
+func main() {
+	opts, err := parseFlags()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	exchanges, err := load(ctx, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load captured traffic: %v\n", err)
+		os.Exit(1)
+	}
+	if len(exchanges) == 0 {
+		fmt.Println("No captured exchanges to replay")
+		return
+	}
+
+	outcomes := replay(ctx, opts, exchanges)
+	if report(outcomes) {
+		os.Exit(1)
+	}
+}
+
+// parseFlags parses and checks the command line
+func parseFlags() (options, error) {
+	var opts options
+	var headers headerFlags
+	flag.StringVar(&opts.file, "file", "", "capture file (JSON lines) to replay")
+	flag.StringVar(&opts.brokers, "kafka", "", "comma-separated Kafka brokers to read the capture topic from")
+	flag.StringVar(&opts.topic, "topic", "gateway-capture", "Kafka capture topic")
+	flag.StringVar(&opts.target, "target", "", "base URL of the gateway to replay against")
+	flag.Float64Var(&opts.speed, "speed", 1, "replay speed relative to the capture; 0 sends as fast as possible")
+	flag.IntVar(&opts.concurrency, "concurrency", 16, "maximum requests in flight")
+	flag.DurationVar(&opts.timeout, "timeout", 30*time.Second, "request timeout")
+	flag.Var(&headers, "header", "header to set on every request, e.g. \"Authorization: Bearer ...\" (repeatable)")
+	flag.Parse()
+
+	if (opts.file == "") == (opts.brokers == "") {
+		return opts, fmt.Errorf("exactly one of -file and -kafka is required")
+	}
+	target, err := url.Parse(opts.target)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		return opts, fmt.Errorf("-target must be an absolute URL")
+	}
+	if opts.speed < 0 || opts.concurrency <= 0 {
+		return opts, fmt.Errorf("-speed must not be negative and -concurrency must be positive")
+	}
+
+	opts.target = strings.TrimSuffix(opts.target, "/")
+	opts.headers = make(http.Header)
+	for _, header := range headers {
+		name, value, _ := strings.Cut(header, ":")
+		opts.headers.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return opts, nil
+}
+
+// load reads the captured exchanges in capture order
+func load(ctx context.Context, opts options) ([]capture.Exchange, error) {
+	if opts.brokers != "" {
+		return capture.ReadKafka(ctx, strings.Split(opts.brokers, ","), opts.topic)
+	}
+
+	file, err := os.Open(opts.file)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var exchanges []capture.Exchange
+	err = capture.Decode(file, func(exchange capture.Exchange) error {
+		exchanges = append(exchanges, exchange)
+		return nil
+	})
+	sort.SliceStable(exchanges, func(i, j int) bool {
+		return exchanges[i].Timestamp.Before(exchanges[j].Timestamp)
+	})
+	return exchanges, err
+}
+
+// replay sends the exchanges, spaced as captured and scaled by the speed
+func replay(ctx context.Context, opts options, exchanges []capture.Exchange) []outcome {
+	client := &http.Client{
+		Timeout: opts.timeout,
+		// Report redirects as captured instead of following them
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	jobs := make(chan capture.Exchange)
+	results := make(chan outcome, len(exchanges))
+	var wg sync.WaitGroup
+	for i := 0; i < opts.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for exchange := range jobs {
+				results <- send(ctx, client, opts, exchange)
+			}
+		}()
+	}
+
+	start := time.Now()
+	first := exchanges[0].Timestamp
+dispatch:
+	for _, exchange := range exchanges {
+		if opts.speed > 0 {
+			due := start.Add(time.Duration(float64(exchange.Timestamp.Sub(first)) / opts.speed))
+			select {
+			case <-ctx.Done():
+				break dispatch
+			case <-time.After(time.Until(due)):
+			}
+		}
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case jobs <- exchange:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(results)
+
+	outcomes := make([]outcome, 0, len(exchanges))
+	for result := range results {
+		outcomes = append(outcomes, result)
+	}
+	return outcomes
+}
+
+// send replays one exchange. Redacted headers are dropped unless -header
+// provides them.
+func send(ctx context.Context, client *http.Client, opts options, exchange capture.Exchange) outcome {
+	result := outcome{exchange: exchange}
+	if exchange.Request.Truncated {
+		result.err = fmt.Errorf("request body was truncated at capture")
+		return result
+	}
+
+	req, err := http.NewRequestWithContext(ctx, exchange.Method, opts.target+exchange.URL, bytes.NewReader(exchange.Request.Body))
+	if err != nil {
+		result.err = err
+		return result
+	}
+	for name, values := range exchange.Request.Header {
+		for _, value := range values {
+			if value != capture.Redacted {
+				req.Header.Add(name, value)
+			}
+		}
+	}
+	for name, values := range opts.headers {
+		req.Header[name] = values
+	}
+	req.Header.Del("Content-Length")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	result.latency = time.Since(start)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	resp.Body.Close()
+	result.status = resp.StatusCode
+	return result
+}
+
+// report prints a summary and returns true if any exchange failed or got
+// a different status than captured
+func report(outcomes []outcome) bool {
+	var failed, mismatched int
+	var latencies []time.Duration
+	statuses := make(map[int]int)
+	for _, o := range outcomes {
+		if o.err != nil {
+			failed++
+			fmt.Printf("FAIL %s %s: %v\n", o.exchange.Method, o.exchange.URL, o.err)
+			continue
+		}
+		statuses[o.status]++
+		latencies = append(latencies, o.latency)
+		if o.status != o.exchange.Response.Status {
+			mismatched++
+			fmt.Printf("DIFF %s %s: status %d, captured %d\n", o.exchange.Method, o.exchange.URL, o.status, o.exchange.Response.Status)
+		}
+	}
+
+	fmt.Printf("\nReplayed %d exchanges: %d failed, %d with a different status\n", len(outcomes), failed, mismatched)
+	codes := make([]int, 0, len(statuses))
+	for code := range statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Printf("  %d: %d\n", code, statuses[code])
+	}
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		fmt.Printf("Latency p50 %s, p95 %s, p99 %s, max %s\n",
+			percentile(latencies, 50), percentile(latencies, 95), percentile(latencies, 99), latencies[len(latencies)-1])
+	}
+	return failed > 0 || mismatched > 0
+}
+
+// percentile returns the p-th percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(float64(len(sorted))*p/100+0.5) - 1
+	return sorted[min(max(i, 0), len(sorted)-1)]
+}
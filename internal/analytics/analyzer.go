@@ -0,0 +1,193 @@
+package analytics
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Dimensions requests are counted by
+const (
+	DimensionPath      = "paths"
+	DimensionClient    = "clients"
+	DimensionUserAgent = "user_agents"
+	DimensionStatus    = "status_codes"
+)
+
+// dimensions lists every dimension in report order
+var dimensions = []string{DimensionPath, DimensionClient, DimensionUserAgent, DimensionStatus}
+
+// Windows are the periods reports can cover. The longest one bounds the
+// history kept.
+var Windows = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute}
+
+// slotDuration is the span of each slot of history
+const slotDuration = time.Minute
+
+// Request is what the analyzer is told about a request
+type Request struct {
+	Path      string
+	ClientIP  string
+	UserAgent string
+	Status    int
+}
+
+// Entry is an estimated count of one value of a dimension
+type Entry struct {
+	Value string `json:"value"`
+	Count uint64 `json:"count"`
+}
+
+// Report is the heavy hitters of each dimension over a window
+type Report struct {
+	Window   string             `json:"window"`
+	Requests uint64             `json:"requests"`
+	Top      map[string][]Entry `json:"top"`
+}
+
+// heavyHitters tracks the values of one dimension seen during a slot: a
+// sketch for the counts and the candidates with the highest estimates
+type heavyHitters struct {
+	sketch     *sketch
+	candidates map[string]uint32
+	floor      uint32 // Lower bound of the smallest candidate estimate
+}
+
+// add counts value, making it a candidate if it is among the most frequent
+func (h *heavyHitters) add(value string, k int) {
+	estimate := h.sketch.add(value)
+	if _, exists := h.candidates[value]; exists || len(h.candidates) < k {
+		h.candidates[value] = estimate
+		return
+	}
+	if estimate <= h.floor {
+		return
+	}
+
+	// Candidate estimates only grow, so find the actual smallest one
+	smallest, smallestKey := ^uint32(0), ""
+	for key, count := range h.candidates {
+		if count < smallest {
+			smallest, smallestKey = count, key
+		}
+	}
+	if estimate <= smallest {
+		h.floor = smallest
+		return
+	}
+	delete(h.candidates, smallestKey)
+	h.candidates[value] = estimate
+	h.floor = smallest
+}
+
+// slot holds one minute of history
+type slot struct {
+	minute   int64
+	requests uint64
+	hitters  map[string]*heavyHitters
+}
+
+// Analyzer finds the most frequent paths, clients, user agents and status
+// codes of recent requests in fixed memory. Counts are estimates and may
+// be slightly high; values that are not among the top k of a minute are
+// not reported for it.
+type Analyzer struct {
+	k     int
+	mu    sync.Mutex
+	slots []*slot
+}
+
+// NewAnalyzer creates an analyzer keeping the top k values of each
+// dimension per minute, counted in sketches of width by depth counters
+func NewAnalyzer(k, width, depth int) *Analyzer {
+	history := int(Windows[len(Windows)-1] / slotDuration)
+	a := &Analyzer{k: k, slots: make([]*slot, history)}
+	for i := range a.slots {
+		s := &slot{minute: -1, hitters: make(map[string]*heavyHitters, len(dimensions))}
+		for _, dimension := range dimensions {
+			s.hitters[dimension] = &heavyHitters{
+				sketch:     newSketch(width, depth),
+				candidates: make(map[string]uint32, k),
+			}
+		}
+		a.slots[i] = s
+	}
+	return a
+}
+
+// Observe counts a request
+func (a *Analyzer) Observe(req Request) {
+	minute := time.Now().Unix() / int64(slotDuration/time.Second)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s := a.slots[minute%int64(len(a.slots))]
+	if s.minute != minute {
+		s.minute = minute
+		s.requests = 0
+		for _, h := range s.hitters {
+			h.sketch.reset()
+			clear(h.candidates)
+			h.floor = 0
+		}
+	}
+
+	s.requests++
+	s.hitters[DimensionPath].add(req.Path, a.k)
+	s.hitters[DimensionClient].add(req.ClientIP, a.k)
+	s.hitters[DimensionUserAgent].add(req.UserAgent, a.k)
+	s.hitters[DimensionStatus].add(strconv.Itoa(req.Status), a.k)
+}
+
+// Top returns the n most frequent values of each dimension over window,
+// which covers the current minute and the ones before it
+func (a *Analyzer) Top(window time.Duration, n int) Report {
+	minute := time.Now().Unix() / int64(slotDuration/time.Second)
+	oldest := minute - int64(window/slotDuration) + 1
+	report := Report{Window: window.String(), Top: make(map[string][]Entry, len(dimensions))}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var slots []*slot
+	for _, s := range a.slots {
+		if s.minute >= oldest && s.minute <= minute {
+			slots = append(slots, s)
+			report.Requests += s.requests
+		}
+	}
+
+	for _, dimension := range dimensions {
+		// A value is reported when it was a candidate in any minute, with
+		// its estimated count summed over all of them
+		counts := make(map[string]uint64)
+		for _, s := range slots {
+			for value := range s.hitters[dimension].candidates {
+				if _, seen := counts[value]; seen {
+					continue
+				}
+				for _, other := range slots {
+					counts[value] += uint64(other.hitters[dimension].sketch.estimate(value))
+				}
+			}
+		}
+
+		entries := make([]Entry, 0, len(counts))
+		for value, count := range counts {
+			entries = append(entries, Entry{Value: value, Count: count})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			if entries[i].Count != entries[j].Count {
+				return entries[i].Count > entries[j].Count
+			}
+			return entries[i].Value < entries[j].Value
+		})
+		if len(entries) > n {
+			entries = entries[:n]
+		}
+		report.Top[dimension] = entries
+	}
+	return report
+}
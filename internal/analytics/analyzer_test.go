@@ -0,0 +1,49 @@
+package analytics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAnalyzerTop(t *testing.T) {
+	a := NewAnalyzer(5, 256, 4)
+
+	for i := 0; i < 50; i++ {
+		a.Observe(Request{Path: "/users", ClientIP: "10.0.0.1", UserAgent: "curl", Status: 200})
+	}
+	for i := 0; i < 20; i++ {
+		a.Observe(Request{Path: "/orders", ClientIP: "10.0.0.2", UserAgent: "curl", Status: 429})
+	}
+	// A long tail of values seen once must not push out the heavy hitters
+	for i := 0; i < 100; i++ {
+		a.Observe(Request{Path: fmt.Sprintf("/items/%d", i), ClientIP: "10.0.0.3", UserAgent: "bot", Status: 404})
+	}
+
+	report := a.Top(5*time.Minute, 2)
+	if report.Requests != 170 || report.Window != "5m0s" {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	paths := report.Top[DimensionPath]
+	if len(paths) != 2 || paths[0].Value != "/users" || paths[0].Count < 50 || paths[1].Value != "/orders" {
+		t.Errorf("unexpected top paths %+v", paths)
+	}
+	if clients := report.Top[DimensionClient]; clients[0].Value != "10.0.0.3" || clients[0].Count < 100 {
+		t.Errorf("unexpected top clients %+v", clients)
+	}
+	if statuses := report.Top[DimensionStatus]; statuses[0].Value != "404" || statuses[1].Value != "200" {
+		t.Errorf("unexpected top status codes %+v", statuses)
+	}
+}
+
+func TestSketchNeverUndercounts(t *testing.T) {
+	s := newSketch(16, 3)
+	for i := 0; i < 200; i++ {
+		s.add(fmt.Sprintf("key-%d", i%40))
+	}
+	for i := 0; i < 40; i++ {
+		if estimate := s.estimate(fmt.Sprintf("key-%d", i)); estimate < 5 {
+			t.Errorf("key-%d estimated at %d, seen 5 times", i, estimate)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package analytics
+
+import (
+	"hash/fnv"
+)
+
+// sketch is a count-min sketch: it estimates how often each key was seen in
+// fixed memory, never under-counting and over-counting only on collisions
+type sketch struct {
+	width  uint64
+	counts [][]uint32
+}
+
+// newSketch creates a sketch of depth rows of width counters
+func newSketch(width, depth int) *sketch {
+	counts := make([][]uint32, depth)
+	for i := range counts {
+		counts[i] = make([]uint32, width)
+	}
+	return &sketch{width: uint64(width), counts: counts}
+}
+
+// indexes returns the counter of key in each row, derived from two halves
+// of one hash
+func (s *sketch) indexes(key string, idx []uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	for i := range idx {
+		idx[i] = (h1 + uint64(i)*h2) % s.width
+	}
+}
+
+// add counts key once and returns its new estimate
+func (s *sketch) add(key string) uint32 {
+	idx := make([]uint64, len(s.counts))
+	s.indexes(key, idx)
+	estimate := ^uint32(0)
+	for i, row := range s.counts {
+		row[idx[i]]++
+		estimate = min(estimate, row[idx[i]])
+	}
+	return estimate
+}
+
+// estimate returns how often key was seen, 0 for an empty sketch
+func (s *sketch) estimate(key string) uint32 {
+	idx := make([]uint64, len(s.counts))
+	s.indexes(key, idx)
+	estimate := ^uint32(0)
+	for i, row := range s.counts {
+		estimate = min(estimate, row[idx[i]])
+	}
+	return estimate
+}
+
+// reset clears every counter
+func (s *sketch) reset() {
+	for _, row := range s.counts {
+		clear(row)
+	}
+}
@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/max/api-gateway/internal/cache"
+)
+
+// apiKeyPrefix marks gateway-issued API keys so they are easy to recognise
+// in logs and secret scanners
+const apiKeyPrefix = "gw_"
+
+// ErrAPIKeyNotFound is returned for unknown, expired or revoked API keys
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// APIKey is an API key issued to a developer. The secret itself is never
+// stored, only its digest.
+type APIKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Owner     string    `json:"owner"`
+	Prefix    string    `json:"prefix"` // First characters of the secret, for display
+	Digest    string    `json:"digest"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Expired reports whether the key has passed its expiry
+func (k *APIKey) Expired() bool {
+	return !k.ExpiresAt.IsZero() && time.Now().After(k.ExpiresAt)
+}
+
+// APIKeyStore keeps API keys in a cache, typically Redis, indexed by owner
+// and by secret digest
+type APIKeyStore struct {
+	store cache.Cache
+
+	// mu serializes updates to the per-owner index on this replica
+	mu sync.Mutex
+}
+
+// NewAPIKeyStore creates a new API key store
+func NewAPIKeyStore(store cache.Cache) *APIKeyStore {
+	return &APIKeyStore{store: store}
+}
+
+// Create issues a key for the owner and returns it with its secret, which
+// is only available at this point. A zero ttl never expires.
+func (s *APIKeyStore) Create(ctx context.Context, owner, name string, ttl time.Duration) (*APIKey, string, error) {
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return nil, "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, "", fmt.Errorf("failed to generate api key id: %w", err)
+	}
+	secret := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secretBytes)
+
+	now := time.Now()
+	key := &APIKey{
+		ID:        hex.EncodeToString(idBytes),
+		Name:      name,
+		Owner:     owner,
+		Prefix:    secret[:len(apiKeyPrefix)+6],
+		Digest:    apiKeyDigest(secret),
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		key.ExpiresAt = now.Add(ttl)
+	}
+
+	data, err := json.Marshal(key)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode api key: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids, err := s.ownerIndex(ctx, owner)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.store.Set(ctx, apiKeyIDKey(key.ID), data, ttl); err != nil {
+		return nil, "", fmt.Errorf("failed to store api key: %w", err)
+	}
+	if err := s.store.Set(ctx, apiKeyDigestKey(key.Digest), []byte(key.ID), ttl); err != nil {
+		return nil, "", fmt.Errorf("failed to store api key: %w", err)
+	}
+	if err := s.saveOwnerIndex(ctx, owner, append(ids, key.ID)); err != nil {
+		return nil, "", err
+	}
+	return key, secret, nil
+}
+
+// List returns the live keys of an owner, oldest first
+func (s *APIKeyStore) List(ctx context.Context, owner string) ([]*APIKey, error) {
+	ids, err := s.ownerIndex(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*APIKey, 0, len(ids))
+	for _, id := range ids {
+		key, err := s.get(ctx, id)
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// Revoke deletes a key of the owner
+func (s *APIKeyStore) Revoke(ctx context.Context, owner, id string) error {
+	key, err := s.get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if key.Owner != owner {
+		return ErrAPIKeyNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.store.Delete(ctx, apiKeyDigestKey(key.Digest)); err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	if err := s.store.Delete(ctx, apiKeyIDKey(id)); err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+
+	ids, err := s.ownerIndex(ctx, owner)
+	if err != nil {
+		return err
+	}
+	remaining := ids[:0]
+	for _, other := range ids {
+		if other != id {
+			remaining = append(remaining, other)
+		}
+	}
+	return s.saveOwnerIndex(ctx, owner, remaining)
+}
+
+// Authenticate returns the key matching a secret
+func (s *APIKeyStore) Authenticate(ctx context.Context, secret string) (*APIKey, error) {
+	id, err := s.store.Get(ctx, apiKeyDigestKey(apiKeyDigest(secret)))
+	if err != nil {
+		if errors.Is(err, cache.ErrCacheMiss) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to look up api key: %w", err)
+	}
+	return s.get(ctx, string(id))
+}
+
+// get loads a key by ID
+func (s *APIKeyStore) get(ctx context.Context, id string) (*APIKey, error) {
+	data, err := s.store.Get(ctx, apiKeyIDKey(id))
+	if err != nil {
+		if errors.Is(err, cache.ErrCacheMiss) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to load api key: %w", err)
+	}
+
+	var key APIKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("failed to decode api key: %w", err)
+	}
+	if key.Expired() {
+		return nil, ErrAPIKeyNotFound
+	}
+	return &key, nil
+}
+
+// ownerIndex returns the key IDs of an owner
+func (s *APIKeyStore) ownerIndex(ctx context.Context, owner string) ([]string, error) {
+	data, err := s.store.Get(ctx, apiKeyOwnerKey(owner))
+	if err != nil {
+		if errors.Is(err, cache.ErrCacheMiss) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to load api keys: %w", err)
+	}
+
+	var ids []string
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, fmt.Errorf("failed to decode api keys: %w", err)
+	}
+	return ids, nil
+}
+
+// saveOwnerIndex stores the key IDs of an owner
+func (s *APIKeyStore) saveOwnerIndex(ctx context.Context, owner string, ids []string) error {
+	if len(ids) == 0 {
+		return s.store.Delete(ctx, apiKeyOwnerKey(owner))
+	}
+
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return fmt.Errorf("failed to encode api keys: %w", err)
+	}
+	if err := s.store.Set(ctx, apiKeyOwnerKey(owner), data, 0); err != nil {
+		return fmt.Errorf("failed to store api keys: %w", err)
+	}
+	return nil
+}
+
+// apiKeyDigest hashes a secret for lookup; secrets are random, so a plain
+// SHA-256 is enough
+func apiKeyDigest(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyIDKey builds the cache key for a key record
+func apiKeyIDKey(id string) string {
+	return "apikey:id:" + id
+}
+
+// apiKeyDigestKey builds the cache key mapping a digest to a key ID
+func apiKeyDigestKey(digest string) string {
+	return "apikey:digest:" + digest
+}
+
+// apiKeyOwnerKey builds the cache key for an owner's key IDs
+func apiKeyOwnerKey(owner string) string {
+	return "apikey:owner:" + owner
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/cache"
+)
+
+func TestAPIKeyStoreLifecycle(t *testing.T) {
+	ctx := context.Background()
+	store := NewAPIKeyStore(cache.NewMemoryCache(0, 0, zap.NewNop()))
+
+	key, secret, err := store.Create(ctx, "dev-1", "ci", 0)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	got, err := store.Authenticate(ctx, secret)
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if got.ID != key.ID || got.Owner != "dev-1" {
+		t.Errorf("Authenticate() = %+v, want key %s of dev-1", got, key.ID)
+	}
+
+	if err := store.Revoke(ctx, "dev-2", key.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("Revoke() by another owner error = %v, want ErrAPIKeyNotFound", err)
+	}
+	if err := store.Revoke(ctx, "dev-1", key.ID); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+
+	if _, err := store.Authenticate(ctx, secret); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("Authenticate() after revoke error = %v, want ErrAPIKeyNotFound", err)
+	}
+	keys, err := store.List(ctx, "dev-1")
+	if err != nil || len(keys) != 0 {
+		t.Errorf("List() = %v, %v, want no keys", keys, err)
+	}
+}
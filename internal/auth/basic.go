@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidCredentials is returned when a username or password is rejected
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// BasicAuthenticator verifies HTTP Basic credentials and maps the user into
+// Claims so the same role checks apply as for JWT users
+type BasicAuthenticator interface {
+	Authenticate(ctx context.Context, username, password string) (*Claims, error)
+}
+
+// htpasswdEntry is a user from an htpasswd-style file
+type htpasswdEntry struct {
+	hash  string
+	roles []string
+}
+
+// HtpasswdAuthenticator validates users against an htpasswd-style file.
+// Lines have the form "user:hash" or "user:hash:role1,role2"; bcrypt and
+// {SHA} hashes are supported.
+type HtpasswdAuthenticator struct {
+	users map[string]htpasswdEntry
+}
+
+// LoadHtpasswd reads an htpasswd-style file
+func LoadHtpasswd(path string) (*HtpasswdAuthenticator, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open htpasswd file: %w", err)
+	}
+	defer file.Close()
+
+	users := make(map[string]htpasswdEntry)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(text, ":", 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid htpasswd entry on line %d", line)
+		}
+
+		entry := htpasswdEntry{hash: parts[1]}
+		if len(parts) == 3 && parts[2] != "" {
+			entry.roles = strings.Split(parts[2], ",")
+		}
+		users[parts[0]] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read htpasswd file: %w", err)
+	}
+
+	return &HtpasswdAuthenticator{users: users}, nil
+}
+
+// Authenticate checks the password against the stored hash
+func (h *HtpasswdAuthenticator) Authenticate(ctx context.Context, username, password string) (*Claims, error) {
+	entry, ok := h.users[username]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	switch {
+	case strings.HasPrefix(entry.hash, "$2"):
+		if bcrypt.CompareHashAndPassword([]byte(entry.hash), []byte(password)) != nil {
+			return nil, ErrInvalidCredentials
+		}
+	case strings.HasPrefix(entry.hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		if subtle.ConstantTimeCompare([]byte(entry.hash), []byte(expected)) != 1 {
+			return nil, ErrInvalidCredentials
+		}
+	default:
+		return nil, fmt.Errorf("unsupported password hash for user %s", username)
+	}
+
+	return basicClaims(username, entry.roles), nil
+}
+
+// LDAPAuthenticator validates users with an LDAP simple bind
+type LDAPAuthenticator struct {
+	url            *url.URL
+	userDNTemplate string
+	roles          []string
+	timeout        time.Duration
+}
+
+// NewLDAPAuthenticator creates an LDAP authenticator. The user DN is built
+// from userDNTemplate with %s replaced by the escaped username, e.g.
+// "uid=%s,ou=people,dc=example,dc=com". Authenticated users get roles.
+func NewLDAPAuthenticator(rawURL, userDNTemplate string, roles []string, timeout time.Duration) (*LDAPAuthenticator, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ldap url: %w", err)
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return nil, fmt.Errorf("unsupported ldap scheme: %s", u.Scheme)
+	}
+	if !strings.Contains(userDNTemplate, "%s") {
+		return nil, fmt.Errorf("ldap user DN template must contain %%s")
+	}
+
+	return &LDAPAuthenticator{
+		url:            u,
+		userDNTemplate: userDNTemplate,
+		roles:          roles,
+		timeout:        timeout,
+	}, nil
+}
+
+// ldapBindRequest is the BindRequest protocol operation (RFC 4511 4.2)
+type ldapBindRequest struct {
+	Version  int
+	Name     []byte
+	Password asn1.RawValue
+}
+
+// ldapBindRequestMessage is an LDAPMessage carrying a BindRequest
+type ldapBindRequestMessage struct {
+	MessageID   int
+	BindRequest ldapBindRequest `asn1:"application,tag:0"`
+}
+
+// ldapResult is the LDAPResult of a BindResponse
+type ldapResult struct {
+	ResultCode        asn1.Enumerated
+	MatchedDN         []byte
+	DiagnosticMessage []byte
+}
+
+// ldapBindResponseMessage is an LDAPMessage carrying a BindResponse
+type ldapBindResponseMessage struct {
+	MessageID    int
+	BindResponse ldapResult `asn1:"application,tag:1"`
+}
+
+// Authenticate binds as the user; a successful bind accepts the password
+func (l *LDAPAuthenticator) Authenticate(ctx context.Context, username, password string) (*Claims, error) {
+	// An empty password would be an unauthenticated bind, which LDAP servers
+	// accept without checking anything
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := l.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if l.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(l.timeout))
+	}
+
+	request, err := asn1.Marshal(ldapBindRequestMessage{
+		MessageID: 1,
+		BindRequest: ldapBindRequest{
+			Version:  3,
+			Name:     []byte(fmt.Sprintf(l.userDNTemplate, escapeDN(username))),
+			Password: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: []byte(password)},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode ldap bind request: %w", err)
+	}
+	if _, err := conn.Write(request); err != nil {
+		return nil, fmt.Errorf("failed to send ldap bind request: %w", err)
+	}
+
+	packet, err := readBERPacket(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ldap bind response: %w", err)
+	}
+
+	var response ldapBindResponseMessage
+	if _, err := asn1.Unmarshal(packet, &response); err != nil {
+		return nil, fmt.Errorf("failed to decode ldap bind response: %w", err)
+	}
+
+	switch response.BindResponse.ResultCode {
+	case 0: // success
+		return basicClaims(username, l.roles), nil
+	case 49: // invalidCredentials
+		return nil, ErrInvalidCredentials
+	default:
+		return nil, fmt.Errorf("ldap bind failed with result code %d: %s",
+			response.BindResponse.ResultCode, response.BindResponse.DiagnosticMessage)
+	}
+}
+
+// dial connects to the LDAP server
+func (l *LDAPAuthenticator) dial(ctx context.Context) (net.Conn, error) {
+	host := l.url.Host
+	if l.url.Port() == "" {
+		if l.url.Scheme == "ldaps" {
+			host = net.JoinHostPort(l.url.Hostname(), "636")
+		} else {
+			host = net.JoinHostPort(l.url.Hostname(), "389")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: l.timeout}
+	var conn net.Conn
+	var err error
+	if l.url.Scheme == "ldaps" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: l.url.Hostname()}}).DialContext(ctx, "tcp", host)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to ldap server: %w", err)
+	}
+	return conn, nil
+}
+
+// readBERPacket reads a single BER-encoded element
+func readBERPacket(r io.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	length := int(header[1])
+	if length&0x80 != 0 {
+		count := length & 0x7f
+		if count == 0 || count > 4 {
+			return nil, fmt.Errorf("unsupported BER length encoding")
+		}
+		lengthBytes := make([]byte, count)
+		if _, err := io.ReadFull(r, lengthBytes); err != nil {
+			return nil, err
+		}
+		length = 0
+		for _, b := range lengthBytes {
+			length = length<<8 | int(b)
+		}
+		header = append(header, lengthBytes...)
+	}
+	if length > 1<<20 {
+		return nil, fmt.Errorf("BER packet too large: %d bytes", length)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return append(header, body...), nil
+}
+
+// escapeDN escapes a value for use in a distinguished name (RFC 4514)
+func escapeDN(value string) string {
+	var b strings.Builder
+	for i, r := range value {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, r),
+			(r == ' ' || r == '#') && i == 0,
+			r == ' ' && i == len(value)-1:
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// basicClaims maps a Basic-authenticated user into Claims
+func basicClaims(username string, roles []string) *Claims {
+	claims := &Claims{
+		UserID:   username,
+		Username: username,
+		Roles:    roles,
+	}
+	claims.Subject = username
+	return claims
+}
@@ -19,6 +19,41 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
+// Value returns a claim by name as a string. Custom claims are looked up
+// in the metadata.
+func (c *Claims) Value(name string) string {
+	switch name {
+	case "user_id":
+		return c.UserID
+	case "username":
+		return c.Username
+	case "email":
+		return c.Email
+	case "roles":
+		return strings.Join(c.Roles, ",")
+	case "sub":
+		return c.Subject
+	case "iss":
+		return c.Issuer
+	}
+	return c.Metadata[name]
+}
+
+// ValidationOptions control which tokens ValidateToken accepts
+type ValidationOptions struct {
+	// Issuer is the required iss claim; empty accepts any issuer
+	Issuer string
+	// Audiences are the accepted aud values, one of which the token must
+	// carry; empty accepts any audience
+	Audiences []string
+	// Algorithms are the accepted alg header values
+	Algorithms []string
+	// ClockSkew is the leeway applied to exp, nbf and iat
+	ClockSkew time.Duration
+	// RequireExpiration rejects tokens without an exp claim
+	RequireExpiration bool
+}
+
 // JWTAuth handles JWT authentication
 type JWTAuth struct {
 	secret         []byte
@@ -28,10 +63,22 @@ type JWTAuth struct {
 	audience       string
 	algorithm      string
 	logger         *zap.Logger
+
+	validation ValidationOptions
 }
 
-// NewJWTAuth creates a new JWT authenticator
+// NewJWTAuth creates a new JWT authenticator. Tokens must come from issuer,
+// carry audience and be signed with algorithm unless SetValidation widens
+// the options.
 func NewJWTAuth(secret string, expirationTime, refreshTime time.Duration, issuer, audience, algorithm string, logger *zap.Logger) *JWTAuth {
+	if algorithm == "" {
+		algorithm = jwt.SigningMethodHS256.Alg()
+	}
+	validation := ValidationOptions{Issuer: issuer, Algorithms: []string{algorithm}}
+	if audience != "" {
+		validation.Audiences = []string{audience}
+	}
+
 	return &JWTAuth{
 		secret:         []byte(secret),
 		expirationTime: expirationTime,
@@ -40,9 +87,15 @@ func NewJWTAuth(secret string, expirationTime, refreshTime time.Duration, issuer
 		audience:       audience,
 		algorithm:      algorithm,
 		logger:         logger,
+		validation:     validation,
 	}
 }
 
+// SetValidation replaces the token validation options
+func (j *JWTAuth) SetValidation(options ValidationOptions) {
+	j.validation = options
+}
+
 // GenerateToken generates a new JWT token
 func (j *JWTAuth) GenerateToken(userID, username, email string, roles []string, metadata map[string]string) (string, error) {
 	now := time.Now()
@@ -62,7 +115,11 @@ func (j *JWTAuth) GenerateToken(userID, username, email string, roles []string,
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	method := jwt.GetSigningMethod(j.algorithm)
+	if _, ok := method.(*jwt.SigningMethodHMAC); !ok {
+		return "", fmt.Errorf("unsupported signing algorithm: %s", j.algorithm)
+	}
+	token := jwt.NewWithClaims(method, claims)
 	tokenString, err := token.SignedString(j.secret)
 	if err != nil {
 		j.logger.Error("Failed to sign JWT token", zap.Error(err))
@@ -75,16 +132,42 @@ func (j *JWTAuth) GenerateToken(userID, username, email string, roles []string,
 
 // ValidateToken validates a JWT token and returns claims
 func (j *JWTAuth) ValidateToken(tokenString string) (*Claims, error) {
+	return j.validate(tokenString, j.validation.Audiences)
+}
+
+// ValidateTokenFor validates a JWT token for a route that accepts its own
+// audiences instead of the configured ones
+func (j *JWTAuth) ValidateTokenFor(tokenString string, audiences []string) (*Claims, error) {
+	return j.validate(tokenString, audiences)
+}
+
+// validate checks the signature and registered claims of a token
+func (j *JWTAuth) validate(tokenString string, audiences []string) (*Claims, error) {
 	// Remove "Bearer " prefix if present
 	tokenString = strings.TrimPrefix(tokenString, "Bearer ")
 
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(j.validation.Algorithms),
+		jwt.WithLeeway(j.validation.ClockSkew),
+		jwt.WithIssuedAt(),
+	}
+	if j.validation.Issuer != "" {
+		options = append(options, jwt.WithIssuer(j.validation.Issuer))
+	}
+	if len(audiences) > 0 {
+		options = append(options, jwt.WithAudience(audiences...))
+	}
+	if j.validation.RequireExpiration {
+		options = append(options, jwt.WithExpirationRequired())
+	}
+
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		// Validate signing method
+		// Only HMAC keys are configured, whatever the allowed algorithms
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 		return j.secret, nil
-	})
+	}, options...)
 
 	if err != nil {
 		j.logger.Debug("Token validation failed", zap.Error(err))
@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"go.uber.org/zap"
 )
 
@@ -91,3 +92,97 @@ func TestJWTAuth_RoleChecking(t *testing.T) {
 		t.Error("Expected user to not have all roles")
 	}
 }
+
+func TestInternalTokenIssuer_IssueAndVerify(t *testing.T) {
+	issuer := NewInternalTokenIssuer("internal-secret", "api-gateway", time.Minute)
+
+	claims := &Claims{UserID: "user123", Roles: []string{"user"}}
+	claims.Subject = "user123"
+
+	token, err := issuer.Issue(claims, "order_service")
+	if err != nil {
+		t.Fatalf("Failed to issue internal token: %v", err)
+	}
+
+	internal, err := issuer.Verify(token, "order_service")
+	if err != nil {
+		t.Fatalf("Failed to verify internal token: %v", err)
+	}
+	if internal.Subject != "user123" || internal.UserID != "user123" {
+		t.Errorf("Expected subject user123, got %s", internal.Subject)
+	}
+	if len(internal.Scopes) != 1 || internal.Scopes[0] != "user" {
+		t.Errorf("Expected scopes [user], got %v", internal.Scopes)
+	}
+
+	if _, err := issuer.Verify(token, "payment_service"); err == nil {
+		t.Error("Expected token for another service to be rejected")
+	}
+}
+
+func TestJWTAuth_ValidationOptions(t *testing.T) {
+	logger := zap.NewNop()
+	jwtAuth := NewJWTAuth("test-secret-key", time.Hour, 24*time.Hour, "test-issuer", "test-audience", "HS256", logger)
+
+	sign := func(method jwt.SigningMethod, claims jwt.RegisteredClaims) string {
+		token, err := jwt.NewWithClaims(method, &Claims{UserID: "user123", RegisteredClaims: claims}).SignedString([]byte("test-secret-key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	now := time.Now()
+	valid := jwt.RegisteredClaims{
+		Issuer:    "test-issuer",
+		Audience:  []string{"test-audience"},
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+	}
+
+	if _, err := jwtAuth.ValidateToken(sign(jwt.SigningMethodHS256, valid)); err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+
+	otherIssuer := valid
+	otherIssuer.Issuer = "someone-else"
+	if _, err := jwtAuth.ValidateToken(sign(jwt.SigningMethodHS256, otherIssuer)); err == nil {
+		t.Error("token from another issuer accepted")
+	}
+
+	otherAudience := valid
+	otherAudience.Audience = []string{"orders"}
+	orders := sign(jwt.SigningMethodHS256, otherAudience)
+	if _, err := jwtAuth.ValidateToken(orders); err == nil {
+		t.Error("token for another audience accepted")
+	}
+	if _, err := jwtAuth.ValidateTokenFor(orders, []string{"orders", "billing"}); err != nil {
+		t.Errorf("token rejected for its route audience: %v", err)
+	}
+
+	if _, err := jwtAuth.ValidateToken(sign(jwt.SigningMethodHS512, valid)); err == nil {
+		t.Error("token with a disallowed algorithm accepted")
+	}
+
+	// A token valid a few seconds from now passes only with clock skew
+	early := valid
+	early.NotBefore = jwt.NewNumericDate(now.Add(10 * time.Second))
+	token := sign(jwt.SigningMethodHS256, early)
+	if _, err := jwtAuth.ValidateToken(token); err == nil {
+		t.Error("token not yet valid accepted without clock skew")
+	}
+	jwtAuth.SetValidation(ValidationOptions{
+		Issuer:     "test-issuer",
+		Audiences:  []string{"test-audience"},
+		Algorithms: []string{"HS256"},
+		ClockSkew:  30 * time.Second,
+	})
+	if _, err := jwtAuth.ValidateToken(token); err != nil {
+		t.Errorf("token within clock skew rejected: %v", err)
+	}
+
+	noExpiry := valid
+	noExpiry.ExpiresAt = nil
+	jwtAuth.SetValidation(ValidationOptions{Algorithms: []string{"HS256"}, RequireExpiration: true})
+	if _, err := jwtAuth.ValidateToken(sign(jwt.SigningMethodHS256, noExpiry)); err == nil {
+		t.Error("token without expiry accepted")
+	}
+}
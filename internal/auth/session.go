@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/max/api-gateway/internal/cache"
+)
+
+// ErrSessionNotFound is returned for unknown, expired or revoked sessions
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session is a server-side login session referenced by a cookie
+type Session struct {
+	ID        string    `json:"id"`
+	Claims    *Claims   `json:"claims"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// SessionStore keeps sessions in a cache, typically Redis. Sessions expire
+// after idleTimeout without use and after absoluteTimeout regardless.
+type SessionStore struct {
+	store           cache.Cache
+	idleTimeout     time.Duration
+	absoluteTimeout time.Duration
+}
+
+// NewSessionStore creates a new session store
+func NewSessionStore(store cache.Cache, idleTimeout, absoluteTimeout time.Duration) *SessionStore {
+	return &SessionStore{
+		store:           store,
+		idleTimeout:     idleTimeout,
+		absoluteTimeout: absoluteTimeout,
+	}
+}
+
+// Create starts a session for the claims
+func (s *SessionStore) Create(ctx context.Context, claims *Claims) (*Session, error) {
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate session id: %w", err)
+	}
+
+	now := time.Now()
+	session := &Session{
+		ID:        base64.RawURLEncoding.EncodeToString(id),
+		Claims:    claims,
+		CreatedAt: now,
+		LastSeen:  now,
+	}
+
+	if err := s.save(ctx, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Get returns a live session and extends its idle timeout
+func (s *SessionStore) Get(ctx context.Context, id string) (*Session, error) {
+	data, err := s.store.Get(ctx, sessionKey(id))
+	if err != nil {
+		if errors.Is(err, cache.ErrCacheMiss) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to load session: %w", err)
+	}
+
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to decode session: %w", err)
+	}
+
+	now := time.Now()
+	if now.Sub(session.LastSeen) > s.idleTimeout || now.Sub(session.CreatedAt) > s.absoluteTimeout {
+		s.store.Delete(ctx, sessionKey(id))
+		return nil, ErrSessionNotFound
+	}
+
+	session.LastSeen = now
+	if err := s.save(ctx, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Delete revokes a session
+func (s *SessionStore) Delete(ctx context.Context, id string) error {
+	return s.store.Delete(ctx, sessionKey(id))
+}
+
+// MaxAge returns how long a new session can live at most
+func (s *SessionStore) MaxAge() time.Duration {
+	return s.absoluteTimeout
+}
+
+// save stores the session until whichever timeout comes first
+func (s *SessionStore) save(ctx context.Context, session *Session) error {
+	ttl := s.idleTimeout
+	if remaining := s.absoluteTimeout - time.Since(session.CreatedAt); remaining < ttl {
+		ttl = remaining
+	}
+	if ttl <= 0 {
+		return ErrSessionNotFound
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+	if err := s.store.Set(ctx, sessionKey(session.ID), data, ttl); err != nil {
+		return fmt.Errorf("failed to store session: %w", err)
+	}
+	return nil
+}
+
+// sessionKey builds the cache key for a session
+func sessionKey(id string) string {
+	return "session:" + id
+}
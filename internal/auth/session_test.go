@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/cache"
+)
+
+// sessionBackends returns a memory and a Redis backed cache for session tests
+func sessionBackends(t *testing.T) map[string]cache.Cache {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return map[string]cache.Cache{
+		"memory": cache.NewMemoryCache(0, time.Hour, zap.NewNop()),
+		"redis":  cache.NewRedisCache(client, "test", time.Hour, zap.NewNop()),
+	}
+}
+
+func TestSessionStoreExpiry(t *testing.T) {
+	ctx := context.Background()
+	claims := &Claims{UserID: "1", Username: "tester"}
+
+	for name, backend := range sessionBackends(t) {
+		t.Run(name+"/idle", func(t *testing.T) {
+			store := NewSessionStore(backend, 150*time.Millisecond, time.Hour)
+			session, err := store.Create(ctx, claims)
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+
+			// Each use slides the idle deadline forward
+			for i := 0; i < 4; i++ {
+				time.Sleep(75 * time.Millisecond)
+				if _, err := store.Get(ctx, session.ID); err != nil {
+					t.Fatalf("Get() after %d uses error = %v", i, err)
+				}
+			}
+
+			time.Sleep(250 * time.Millisecond)
+			if _, err := store.Get(ctx, session.ID); !errors.Is(err, ErrSessionNotFound) {
+				t.Errorf("Get() after idle timeout error = %v, want ErrSessionNotFound", err)
+			}
+		})
+
+		t.Run(name+"/absolute", func(t *testing.T) {
+			store := NewSessionStore(backend, time.Hour, 300*time.Millisecond)
+			session, err := store.Create(ctx, claims)
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+
+			deadline := time.Now().Add(time.Second)
+			for time.Now().Before(deadline) {
+				if _, err = store.Get(ctx, session.ID); err != nil {
+					break
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+			if !errors.Is(err, ErrSessionNotFound) {
+				t.Fatalf("Get() kept in use error = %v, want ErrSessionNotFound after the absolute timeout", err)
+			}
+			if since := time.Since(session.CreatedAt); since < 300*time.Millisecond {
+				t.Errorf("session expired after %v, before the absolute timeout", since)
+			}
+		})
+	}
+}
+
+func TestSessionStoreGetRefreshesLastSeen(t *testing.T) {
+	ctx := context.Background()
+	store := NewSessionStore(cache.NewMemoryCache(0, time.Hour, zap.NewNop()), time.Hour, time.Hour)
+
+	session, err := store.Create(ctx, &Claims{UserID: "1"})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	got, err := store.Get(ctx, session.ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if !got.LastSeen.After(session.LastSeen) {
+		t.Errorf("LastSeen = %v, want later than %v", got.LastSeen, session.LastSeen)
+	}
+	if !got.CreatedAt.Equal(session.CreatedAt) {
+		t.Errorf("CreatedAt = %v, want it unchanged at %v", got.CreatedAt, session.CreatedAt)
+	}
+	if got.Claims == nil || got.Claims.UserID != "1" {
+		t.Errorf("Claims = %+v, want user 1", got.Claims)
+	}
+}
+
+func TestSessionStoreDelete(t *testing.T) {
+	ctx := context.Background()
+
+	for name, backend := range sessionBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			store := NewSessionStore(backend, time.Hour, time.Hour)
+			session, err := store.Create(ctx, &Claims{UserID: "1"})
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			other, err := store.Create(ctx, &Claims{UserID: "2"})
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+
+			if err := store.Delete(ctx, session.ID); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if _, err := store.Get(ctx, session.ID); !errors.Is(err, ErrSessionNotFound) {
+				t.Errorf("Get() after Delete() error = %v, want ErrSessionNotFound", err)
+			}
+			if _, err := store.Get(ctx, other.ID); err != nil {
+				t.Errorf("Get() of another session error = %v", err)
+			}
+		})
+	}
+}
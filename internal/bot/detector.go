@@ -0,0 +1,208 @@
+package bot
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	gocache "github.com/patrickmn/go-cache"
+
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/pkg/proxyproto"
+)
+
+// Heuristic scores; a request scoring at or above the configured threshold
+// is treated as a bot
+const (
+	scoreMissingUserAgent   = 50
+	scoreAutomationAgent    = 40
+	scoreMissingAcceptLang  = 20
+	scoreMissingAcceptEnc   = 10
+	scoreMissingAccept      = 10
+	scoreUnverifiedCrawler  = 60
+	scoreRequestRateAnomaly = 40
+)
+
+// verificationTTL is how long reverse DNS verification results are cached
+const verificationTTL = time.Hour
+
+// automationAgents are user agent fragments of common HTTP libraries and
+// headless browsers
+var automationAgents = []string{
+	"curl/", "wget/", "python-requests", "python-urllib", "aiohttp", "go-http-client",
+	"java/", "libwww-perl", "scrapy", "httpclient", "headlesschrome", "phantomjs",
+}
+
+// Verdict is the outcome of inspecting a request
+type Verdict struct {
+	Score   int
+	Reasons []string
+	// Crawler is set when the request comes from a verified crawler
+	Crawler string
+	// Allowed is set for allowlisted networks and verified crawlers
+	Allowed bool
+}
+
+// Resolver performs the DNS lookups used to verify crawlers
+type Resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// Detector scores requests with header and request rate heuristics
+type Detector struct {
+	cfg      config.BotConfig
+	allowed  []*net.IPNet
+	requests *gocache.Cache
+	verified *gocache.Cache
+	resolver Resolver
+}
+
+// NewDetector creates a bot detector
+func NewDetector(cfg config.BotConfig, resolver Resolver) (*Detector, error) {
+	allowed, err := proxyproto.ParseCIDRs(cfg.AllowedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	return &Detector{
+		cfg:      cfg,
+		allowed:  allowed,
+		requests: gocache.New(cfg.RateWindow, time.Minute),
+		verified: gocache.New(verificationTTL, 10*time.Minute),
+		resolver: resolver,
+	}, nil
+}
+
+// Inspect scores a request from the given client IP
+func (d *Detector) Inspect(ctx context.Context, r *http.Request, clientIP string) Verdict {
+	ip := net.ParseIP(clientIP)
+	for _, network := range d.allowed {
+		if ip != nil && network.Contains(ip) {
+			return Verdict{Allowed: true}
+		}
+	}
+
+	var verdict Verdict
+	add := func(score int, reason string) {
+		verdict.Score += score
+		verdict.Reasons = append(verdict.Reasons, reason)
+	}
+
+	agent := r.UserAgent()
+	lowerAgent := strings.ToLower(agent)
+
+	// Crawlers are identified by user agent and verified by reverse DNS so
+	// the user agent alone cannot be used to get allowlisted
+	for _, crawler := range d.cfg.VerifiedCrawlers {
+		if crawler.UserAgent == "" || !strings.Contains(lowerAgent, strings.ToLower(crawler.UserAgent)) {
+			continue
+		}
+		if d.verifyCrawler(ctx, clientIP, crawler.Domains) {
+			return Verdict{Allowed: true, Crawler: crawler.Name}
+		}
+		add(scoreUnverifiedCrawler, "unverified_crawler")
+		break
+	}
+
+	if agent == "" {
+		add(scoreMissingUserAgent, "missing_user_agent")
+	} else {
+		for _, fragment := range automationAgents {
+			if strings.Contains(lowerAgent, fragment) {
+				add(scoreAutomationAgent, "automation_user_agent")
+				break
+			}
+		}
+
+		// Real browsers always send these; scripts imitating a browser often
+		// only copy the user agent
+		if strings.HasPrefix(agent, "Mozilla/") {
+			if r.Header.Get("Accept-Language") == "" {
+				add(scoreMissingAcceptLang, "missing_accept_language")
+			}
+			if r.Header.Get("Accept-Encoding") == "" {
+				add(scoreMissingAcceptEnc, "missing_accept_encoding")
+			}
+			if r.Header.Get("Accept") == "" {
+				add(scoreMissingAccept, "missing_accept")
+			}
+		}
+	}
+
+	if d.cfg.RateLimit > 0 && d.countRequest(clientIP) > d.cfg.RateLimit {
+		add(scoreRequestRateAnomaly, "request_rate")
+	}
+
+	return verdict
+}
+
+// countRequest counts requests from the IP in the current rate window
+func (d *Detector) countRequest(clientIP string) int {
+	if d.requests.Add(clientIP, 1, d.cfg.RateWindow) == nil {
+		return 1
+	}
+	count, err := d.requests.IncrementInt(clientIP, 1)
+	if err != nil {
+		// The entry expired between Add and Increment
+		d.requests.Set(clientIP, 1, d.cfg.RateWindow)
+		return 1
+	}
+	return count
+}
+
+// verifyCrawler checks that the IP reverse-resolves to one of the crawler's
+// domains and that the name resolves back to the IP
+func (d *Detector) verifyCrawler(ctx context.Context, clientIP string, domains []string) bool {
+	if cached, found := d.verified.Get(clientIP); found {
+		return cached.(bool)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	verified := false
+	names, err := d.resolver.LookupAddr(ctx, clientIP)
+	if err == nil {
+	lookup:
+		for _, name := range names {
+			name = strings.TrimSuffix(strings.ToLower(name), ".")
+			if !matchesDomain(name, domains) {
+				continue
+			}
+			addrs, err := d.resolver.LookupHost(ctx, name)
+			if err != nil {
+				continue
+			}
+			for _, addr := range addrs {
+				if addr == clientIP {
+					verified = true
+					break lookup
+				}
+			}
+		}
+	}
+
+	// Lookup failures are not cached so a DNS hiccup does not block a crawler
+	// for an hour
+	if err == nil {
+		d.verified.SetDefault(clientIP, verified)
+	}
+	return verified
+}
+
+// matchesDomain reports whether name equals or is a subdomain of a domain
+func matchesDomain(name string, domains []string) bool {
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
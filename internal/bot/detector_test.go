@@ -0,0 +1,96 @@
+package bot
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// fakeResolver answers DNS lookups from fixed tables
+type fakeResolver struct {
+	ptr  map[string][]string
+	host map[string][]string
+}
+
+func (f *fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return f.ptr[addr], nil
+}
+
+func (f *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return f.host[host], nil
+}
+
+func TestDetector_Heuristics(t *testing.T) {
+	detector, err := NewDetector(config.BotConfig{
+		RateWindow:   time.Minute,
+		RateLimit:    3,
+		AllowedCIDRs: []string{"10.0.0.0/8"},
+	}, &fakeResolver{})
+	if err != nil {
+		t.Fatalf("Failed to create detector: %v", err)
+	}
+
+	browser := httptest.NewRequest("GET", "/", nil)
+	browser.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) Firefox/128.0")
+	browser.Header.Set("Accept", "text/html")
+	browser.Header.Set("Accept-Language", "en-US")
+	browser.Header.Set("Accept-Encoding", "gzip")
+	if verdict := detector.Inspect(context.Background(), browser, "203.0.113.1"); verdict.Score != 0 {
+		t.Errorf("Expected browser request to score 0, got %d (%v)", verdict.Score, verdict.Reasons)
+	}
+
+	script := httptest.NewRequest("GET", "/", nil)
+	script.Header.Set("User-Agent", "python-requests/2.31")
+	if verdict := detector.Inspect(context.Background(), script, "203.0.113.2"); verdict.Score != scoreAutomationAgent {
+		t.Errorf("Expected automation score, got %d (%v)", verdict.Score, verdict.Reasons)
+	}
+
+	for i := 0; i < 3; i++ {
+		detector.Inspect(context.Background(), browser, "203.0.113.3")
+	}
+	if verdict := detector.Inspect(context.Background(), browser, "203.0.113.3"); verdict.Score != scoreRequestRateAnomaly {
+		t.Errorf("Expected request rate score, got %d (%v)", verdict.Score, verdict.Reasons)
+	}
+
+	if verdict := detector.Inspect(context.Background(), script, "10.1.2.3"); !verdict.Allowed {
+		t.Error("Expected allowlisted network to be allowed")
+	}
+}
+
+func TestDetector_VerifiedCrawler(t *testing.T) {
+	resolver := &fakeResolver{
+		ptr: map[string][]string{
+			"66.249.66.1":  {"crawl-66-249-66-1.googlebot.com."},
+			"198.51.100.7": {"crawl.googlebot.com.evil.example."},
+		},
+		host: map[string][]string{
+			"crawl-66-249-66-1.googlebot.com": {"66.249.66.1"},
+		},
+	}
+	detector, err := NewDetector(config.BotConfig{
+		RateWindow: time.Minute,
+		VerifiedCrawlers: []config.CrawlerConfig{
+			{Name: "googlebot", UserAgent: "Googlebot", Domains: []string{"googlebot.com"}},
+		},
+	}, resolver)
+	if err != nil {
+		t.Fatalf("Failed to create detector: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)")
+	req.Header.Set("Accept", "*/*")
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	if verdict := detector.Inspect(context.Background(), req, "66.249.66.1"); !verdict.Allowed || verdict.Crawler != "googlebot" {
+		t.Errorf("Expected verified crawler, got %+v", verdict)
+	}
+
+	verdict := detector.Inspect(context.Background(), req, "198.51.100.7")
+	if verdict.Allowed || verdict.Score < scoreUnverifiedCrawler {
+		t.Errorf("Expected spoofed crawler to be flagged, got %+v", verdict)
+	}
+}
@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// Built-in cache backends
+const (
+	BackendMemory    = "memory"
+	BackendRedis     = "redis"
+	BackendMemcached = "memcached"
+	BackendDynamoDB  = "dynamodb"
+)
+
+// BackendOptions describes the cache a backend factory opens
+type BackendOptions struct {
+	Config     *config.CacheConfig
+	Redis      *redis.Client // nil when Redis is not configured
+	Prefix     string        // Namespace of the cache's keys
+	DefaultTTL time.Duration
+	Logger     *zap.Logger
+}
+
+// BackendFactory opens a cache on a backend
+type BackendFactory func(opts BackendOptions) (Cache, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]BackendFactory{
+		BackendMemory: func(opts BackendOptions) (Cache, error) {
+			return NewMemoryCache(opts.Config.MaxSize, opts.DefaultTTL, opts.Logger), nil
+		},
+		BackendRedis: func(opts BackendOptions) (Cache, error) {
+			if opts.Redis == nil {
+				return nil, fmt.Errorf("redis is not configured")
+			}
+			c := NewRedisCache(opts.Redis, opts.Prefix, opts.DefaultTTL, opts.Logger)
+			c.SetClearTimeout(opts.Config.ClearTimeout)
+			return c, nil
+		},
+		BackendMemcached: func(opts BackendOptions) (Cache, error) {
+			return NewMemcachedCache(opts.Config.Memcached, opts.Prefix, opts.DefaultTTL, opts.Logger)
+		},
+		BackendDynamoDB: func(opts BackendOptions) (Cache, error) {
+			return NewDynamoDBCache(opts.Config.DynamoDB, opts.Prefix, opts.DefaultTTL, opts.Logger)
+		},
+	}
+)
+
+// RegisterBackend makes a backend available to the caches' configuration,
+// replacing any backend of the same name. It is meant to be called from
+// init functions, before the cache manager is created.
+func RegisterBackend(name string, factory BackendFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[name] = factory
+}
+
+// openBackend opens a cache on the named backend
+func openBackend(name string, opts BackendOptions) (Cache, error) {
+	backendsMu.RLock()
+	factory, ok := backends[name]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown cache backend %q", name)
+	}
+	return factory(opts)
+}
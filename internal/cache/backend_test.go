@@ -0,0 +1,304 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// fakeMemcached serves the memcached commands the cache sends
+func fakeMemcached(t testing.TB) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var mu sync.Mutex
+	values := map[string][]byte{}
+	expiries := map[string]time.Time{}
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+		for {
+			line, err := readLine(rw.Reader)
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			mu.Lock()
+			switch fields[0] {
+			case "get":
+				if value, ok := values[fields[1]]; ok {
+					fmt.Fprintf(rw, "VALUE %s 0 %d\r\n%s\r\n", fields[1], len(value), value)
+				}
+				rw.WriteString("END\r\n")
+			case "set":
+				size, _ := strconv.Atoi(fields[4])
+				value := make([]byte, size+2)
+				io.ReadFull(rw, value)
+				seconds, _ := strconv.Atoi(fields[3])
+				values[fields[1]] = value[:size]
+				expiries[fields[1]] = time.Now().Add(time.Duration(seconds) * time.Second)
+				rw.WriteString("STORED\r\n")
+			case "delete":
+				delete(values, fields[1])
+				rw.WriteString("DELETED\r\n")
+			case "mg":
+				if _, ok := values[fields[1]]; ok {
+					fmt.Fprintf(rw, "HD t%d\r\n", int(time.Until(expiries[fields[1]]).Round(time.Second).Seconds()))
+				} else {
+					rw.WriteString("EN\r\n")
+				}
+			default:
+				rw.WriteString("ERROR\r\n")
+			}
+			mu.Unlock()
+			rw.Flush()
+		}
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// fakeDynamoDB serves the DynamoDB operations the cache calls
+func fakeDynamoDB(t testing.TB) string {
+	var mu sync.Mutex
+	items := map[string]dynamoItem{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "/dynamodb/aws4_request") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var input struct {
+			Key                       dynamoItem
+			Item                      dynamoItem
+			ExpressionAttributeValues dynamoItem
+			RequestItems              map[string][]struct{ DeleteRequest struct{ Key dynamoItem } }
+		}
+		json.NewDecoder(r.Body).Decode(&input)
+
+		mu.Lock()
+		defer mu.Unlock()
+		output := map[string]interface{}{}
+		switch strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.") {
+		case "GetItem":
+			if item, ok := items[input.Key["key"].S]; ok {
+				output["Item"] = item
+			}
+		case "PutItem":
+			items[input.Item["key"].S] = input.Item
+		case "DeleteItem":
+			delete(items, input.Key["key"].S)
+		case "Scan":
+			var found []dynamoItem
+			for key := range items {
+				if strings.HasPrefix(key, input.ExpressionAttributeValues[":p"].S) {
+					found = append(found, dynamoItem{"key": {S: key}})
+				}
+			}
+			output["Items"] = found
+		case "BatchWriteItem":
+			for _, requests := range input.RequestItems {
+				for _, request := range requests {
+					delete(items, request.DeleteRequest.Key["key"].S)
+				}
+			}
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(output)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func newTestDynamoDB(t testing.TB, prefix string) *DynamoDBCache {
+	c, err := NewDynamoDBCache(config.DynamoDBConfig{
+		Table:           "cache",
+		Region:          "us-east-1",
+		Endpoint:        fakeDynamoDB(t),
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+	}, prefix, time.Minute, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewDynamoDBCache() error = %v", err)
+	}
+	return c
+}
+
+// testBackend runs the operations every backend supports
+func testBackend(t *testing.T, c Cache) {
+	ctx := context.Background()
+	if _, err := c.Get(ctx, "missing"); err != ErrCacheMiss {
+		t.Errorf("Get(missing) error = %v, want ErrCacheMiss", err)
+	}
+	if err := c.Set(ctx, "user 1", []byte("alice"), time.Hour); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if value, err := c.Get(ctx, "user 1"); err != nil || string(value) != "alice" {
+		t.Errorf("Get() = %q, %v, want alice", value, err)
+	}
+	if exists, err := c.Exists(ctx, "user 1"); err != nil || !exists {
+		t.Errorf("Exists() = %v, %v, want true", exists, err)
+	}
+	if ttl, err := c.GetTTL(ctx, "user 1"); err != nil || ttl < 59*time.Minute || ttl > time.Hour {
+		t.Errorf("GetTTL() = %v, %v, want about an hour", ttl, err)
+	}
+	if err := c.Delete(ctx, "user 1"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if exists, _ := c.Exists(ctx, "user 1"); exists {
+		t.Error("Exists() = true after Delete()")
+	}
+}
+
+func TestMemcachedCache(t *testing.T) {
+	c, err := NewMemcachedCache(config.MemcachedConfig{Servers: []string{fakeMemcached(t)}, MaxIdleConns: 2}, "gateway", time.Minute, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewMemcachedCache() error = %v", err)
+	}
+	testBackend(t, c)
+
+	if err := c.Clear(context.Background()); err != ErrClearUnsupported {
+		t.Errorf("Clear() error = %v, want ErrClearUnsupported", err)
+	}
+	if key := c.buildKey(strings.Repeat("k", 300)); len(key) > memcachedMaxKey {
+		t.Errorf("buildKey() of a long key is %d bytes", len(key))
+	}
+}
+
+func TestDynamoDBCache(t *testing.T) {
+	c := newTestDynamoDB(t, "gateway")
+	testBackend(t, c)
+
+	ctx := context.Background()
+	for i := 0; i < 30; i++ {
+		c.Set(ctx, strconv.Itoa(i), []byte("v"), 0)
+	}
+	if err := c.Clear(ctx); err != nil {
+		t.Fatalf("Clear() error = %v", err)
+	}
+	if exists, _ := c.Exists(ctx, "29"); exists {
+		t.Error("Exists() = true after Clear()")
+	}
+}
+
+func TestManagerBackends(t *testing.T) {
+	cfg := &config.CacheConfig{
+		Enabled:   true,
+		TTL:       time.Minute,
+		Backends:  map[string]string{"responses": BackendMemcached},
+		Memcached: config.MemcachedConfig{Servers: []string{fakeMemcached(t)}},
+	}
+	manager, err := NewManager(cfg, nil, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	if _, ok := manager.GetResponseCache().(*MemcachedCache); !ok {
+		t.Errorf("responses cache is %T, want memcached", manager.GetResponseCache())
+	}
+	if _, ok := manager.GetAuthCache().(*MemoryCache); !ok {
+		t.Errorf("auth cache is %T, want memory without Redis", manager.GetAuthCache())
+	}
+
+	cfg.Backends = map[string]string{"auth": "unknown"}
+	if _, err := NewManager(cfg, nil, zap.NewNop()); err == nil {
+		t.Error("NewManager() accepted an unknown backend")
+	}
+}
+
+// BenchmarkBackends compares the backends. Memcached, DynamoDB and Redis
+// run against MEMCACHED_ADDR, DYNAMODB_ENDPOINT and REDIS_ADDR when set;
+// without them the first two run against in-process fakes and Redis is
+// skipped.
+func BenchmarkBackends(b *testing.B) {
+	logger := zap.NewNop()
+	value := []byte(strings.Repeat("x", 1024))
+	backends := map[string]func(b *testing.B) Cache{
+		"memory": func(b *testing.B) Cache {
+			return NewMemoryCache(0, time.Minute, logger)
+		},
+		"memcached": func(b *testing.B) Cache {
+			addr := os.Getenv("MEMCACHED_ADDR")
+			if addr == "" {
+				addr = fakeMemcached(b)
+			}
+			c, err := NewMemcachedCache(config.MemcachedConfig{Servers: []string{addr}, MaxIdleConns: 16}, "bench", time.Minute, logger)
+			if err != nil {
+				b.Fatal(err)
+			}
+			return c
+		},
+		"dynamodb": func(b *testing.B) Cache {
+			endpoint := os.Getenv("DYNAMODB_ENDPOINT")
+			if endpoint == "" {
+				return newTestDynamoDB(b, "bench")
+			}
+			c, err := NewDynamoDBCache(config.DynamoDBConfig{Table: "cache", Region: "us-east-1", Endpoint: endpoint}, "bench", time.Minute, logger)
+			if err != nil {
+				b.Fatal(err)
+			}
+			return c
+		},
+		"redis": func(b *testing.B) Cache {
+			addr := os.Getenv("REDIS_ADDR")
+			if addr == "" {
+				b.Skip("REDIS_ADDR not set")
+			}
+			client := redis.NewClient(&redis.Options{Addr: addr})
+			b.Cleanup(func() { client.Close() })
+			return NewRedisCache(client, "bench", time.Minute, logger)
+		},
+	}
+
+	for _, name := range []string{"memory", "redis", "memcached", "dynamodb"} {
+		b.Run(name, func(b *testing.B) {
+			c := backends[name](b)
+			ctx := context.Background()
+			b.Run("Set", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if err := c.Set(ctx, strconv.Itoa(i%1000), value, 0); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+			b.Run("Get", func(b *testing.B) {
+				for i := 0; i < 1000; i++ {
+					c.Set(ctx, strconv.Itoa(i), value, 0)
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := c.Get(ctx, strconv.Itoa(i%1000)); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/internal/upstreamauth"
+)
+
+// dynamoBatchSize is the most writes BatchWriteItem accepts
+const dynamoBatchSize = 25
+
+// DynamoDBCache implements caching on a DynamoDB table, calling its HTTP
+// API. Expired items are hidden on read, as DynamoDB TTL deletes them late.
+type DynamoDBCache struct {
+	client         *http.Client
+	signer         upstreamauth.Signer
+	endpoint       string
+	table          string
+	consistentRead bool
+	prefix         string
+	defaultTTL     time.Duration
+	logger         *zap.Logger
+}
+
+// dynamoAttr is a DynamoDB attribute value
+type dynamoAttr struct {
+	S string `json:"S,omitempty"`
+	N string `json:"N,omitempty"`
+	B []byte `json:"B,omitempty"`
+}
+
+// dynamoItem is a DynamoDB item or key
+type dynamoItem map[string]dynamoAttr
+
+// dynamoProjection reads an item's key and expiry only
+var dynamoProjection = map[string]interface{}{
+	"ProjectionExpression":     "#k, #e",
+	"ExpressionAttributeNames": map[string]string{"#k": "key", "#e": "expires_at"},
+}
+
+// NewDynamoDBCache creates a new DynamoDB cache
+func NewDynamoDBCache(cfg config.DynamoDBConfig, prefix string, defaultTTL time.Duration, logger *zap.Logger) (*DynamoDBCache, error) {
+	if cfg.Table == "" {
+		return nil, fmt.Errorf("no dynamodb table configured")
+	}
+	signer, err := upstreamauth.New(config.UpstreamAuthConfig{
+		Type: config.UpstreamAuthSigV4,
+		SigV4: config.SigV4Config{
+			Region:          cfg.Region,
+			Service:         "dynamodb",
+			AccessKeyID:     cfg.AccessKeyID,
+			SecretAccessKey: cfg.SecretAccessKey,
+			SessionToken:    cfg.SessionToken,
+		},
+	}, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamodb signer: %w", err)
+	}
+
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = "https://dynamodb." + cfg.Region + ".amazonaws.com"
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+
+	return &DynamoDBCache{
+		client:         &http.Client{Timeout: timeout},
+		signer:         signer,
+		endpoint:       strings.TrimSuffix(endpoint, "/") + "/",
+		table:          cfg.Table,
+		consistentRead: cfg.ConsistentRead,
+		prefix:         prefix,
+		defaultTTL:     defaultTTL,
+		logger:         logger,
+	}, nil
+}
+
+// Get retrieves a value from cache
+func (d *DynamoDBCache) Get(ctx context.Context, key string) ([]byte, error) {
+	item, err := d.getItem(ctx, key, nil)
+	if err != nil {
+		if err != ErrCacheMiss {
+			d.logger.Error("DynamoDB get error", zap.String("key", key), zap.Error(err))
+		}
+		return nil, err
+	}
+	value := item["value"].B
+	if value == nil {
+		value = []byte{}
+	}
+	return value, nil
+}
+
+// Set stores a value in cache
+func (d *DynamoDBCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if ttl == 0 {
+		ttl = d.defaultTTL
+	}
+
+	item := dynamoItem{"key": {S: d.buildKey(key)}}
+	// Empty binary attributes are rejected; a missing value reads as empty
+	if len(value) > 0 {
+		item["value"] = dynamoAttr{B: value}
+	}
+	if ttl > 0 {
+		item["expires_at"] = dynamoAttr{N: strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)}
+	}
+
+	err := d.call(ctx, "PutItem", map[string]interface{}{"TableName": d.table, "Item": item}, nil)
+	if err != nil {
+		d.logger.Error("DynamoDB set error", zap.String("key", key), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// Delete removes a value from cache
+func (d *DynamoDBCache) Delete(ctx context.Context, key string) error {
+	err := d.call(ctx, "DeleteItem", map[string]interface{}{
+		"TableName": d.table,
+		"Key":       dynamoItem{"key": {S: d.buildKey(key)}},
+	}, nil)
+	if err != nil {
+		d.logger.Error("DynamoDB delete error", zap.String("key", key), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// Exists checks if a key exists in cache
+func (d *DynamoDBCache) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := d.getItem(ctx, key, dynamoProjection)
+	if err == ErrCacheMiss {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Clear removes all cached items with the prefix. It scans the table, so
+// it is slow on large tables.
+func (d *DynamoDBCache) Clear(ctx context.Context) error {
+	input := map[string]interface{}{
+		"TableName":                 d.table,
+		"ProjectionExpression":      "#k",
+		"FilterExpression":          "begins_with(#k, :p)",
+		"ExpressionAttributeNames":  map[string]string{"#k": "key"},
+		"ExpressionAttributeValues": dynamoItem{":p": {S: d.buildKey("")}},
+	}
+
+	deleted := 0
+	for {
+		var page struct {
+			Items            []dynamoItem
+			LastEvaluatedKey dynamoItem
+		}
+		if err := d.call(ctx, "Scan", input, &page); err != nil {
+			d.logger.Error("DynamoDB clear error", zap.Error(err))
+			return err
+		}
+		for start := 0; start < len(page.Items); start += dynamoBatchSize {
+			end := min(start+dynamoBatchSize, len(page.Items))
+			if err := d.deleteBatch(ctx, page.Items[start:end]); err != nil {
+				d.logger.Error("DynamoDB clear delete error", zap.Error(err))
+				return err
+			}
+		}
+		deleted += len(page.Items)
+		if len(page.LastEvaluatedKey) == 0 {
+			break
+		}
+		input["ExclusiveStartKey"] = page.LastEvaluatedKey
+	}
+
+	d.logger.Info("Cache cleared", zap.String("prefix", d.prefix), zap.Int("keys_deleted", deleted))
+	return nil
+}
+
+// GetTTL returns the TTL of a key
+func (d *DynamoDBCache) GetTTL(ctx context.Context, key string) (time.Duration, error) {
+	item, err := d.getItem(ctx, key, dynamoProjection)
+	if err != nil {
+		return 0, err
+	}
+	expiresAt, ok := item["expires_at"]
+	if !ok {
+		return -1, nil // No expiration
+	}
+	unix, err := strconv.ParseInt(expiresAt.N, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("dynamodb: malformed expires_at %q", expiresAt.N)
+	}
+	return time.Until(time.Unix(unix, 0)).Round(time.Second), nil
+}
+
+// getItem reads an item, treating expired items as missing
+func (d *DynamoDBCache) getItem(ctx context.Context, key string, projection map[string]interface{}) (dynamoItem, error) {
+	input := map[string]interface{}{
+		"TableName":      d.table,
+		"Key":            dynamoItem{"key": {S: d.buildKey(key)}},
+		"ConsistentRead": d.consistentRead,
+	}
+	for name, value := range projection {
+		input[name] = value
+	}
+
+	var output struct{ Item dynamoItem }
+	if err := d.call(ctx, "GetItem", input, &output); err != nil {
+		return nil, err
+	}
+	if output.Item == nil {
+		return nil, ErrCacheMiss
+	}
+	if expiresAt, ok := output.Item["expires_at"]; ok {
+		if unix, err := strconv.ParseInt(expiresAt.N, 10, 64); err == nil && time.Now().Unix() >= unix {
+			return nil, ErrCacheMiss
+		}
+	}
+	return output.Item, nil
+}
+
+// deleteBatch deletes up to dynamoBatchSize items, resending those the
+// table did not process
+func (d *DynamoDBCache) deleteBatch(ctx context.Context, items []dynamoItem) error {
+	requests := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		requests = append(requests, map[string]interface{}{
+			"DeleteRequest": map[string]interface{}{"Key": dynamoItem{"key": item["key"]}},
+		})
+	}
+
+	backoff := 50 * time.Millisecond
+	for len(requests) > 0 {
+		var output struct {
+			UnprocessedItems map[string][]interface{}
+		}
+		err := d.call(ctx, "BatchWriteItem", map[string]interface{}{
+			"RequestItems": map[string][]interface{}{d.table: requests},
+		}, &output)
+		if err != nil {
+			return err
+		}
+		requests = output.UnprocessedItems[d.table]
+		if len(requests) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, time.Second)
+	}
+	return nil
+}
+
+// call sends a signed request to the DynamoDB API and decodes its output
+func (d *DynamoDBCache) call(ctx context.Context, operation string, input, output interface{}) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s input: %w", operation, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "DynamoDB_20120810."+operation)
+	if req, err = d.signer.Prepare(req); err != nil {
+		return err
+	}
+	d.signer.Sign(req)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("dynamodb %s: %w", operation, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiErr)
+		errType := apiErr.Type[strings.LastIndex(apiErr.Type, "#")+1:]
+		return fmt.Errorf("dynamodb %s: %s (%d): %s", operation, errType, resp.StatusCode, apiErr.Message)
+	}
+	if output == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(output); err != nil {
+		return fmt.Errorf("failed to decode %s output: %w", operation, err)
+	}
+	return nil
+}
+
+// buildKey builds the full key with prefix
+func (d *DynamoDBCache) buildKey(key string) string {
+	if d.prefix == "" {
+		return key
+	}
+	return d.prefix + ":" + key
+}
@@ -0,0 +1,320 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+const (
+	// memcachedMaxKey is the longest key memcached accepts
+	memcachedMaxKey = 250
+	// memcachedRelativeTTL is the longest expiry memcached reads as
+	// relative; longer ones must be sent as Unix times
+	memcachedRelativeTTL = 30 * 24 * time.Hour
+)
+
+// ErrClearUnsupported is returned by backends that cannot list their keys
+var ErrClearUnsupported = errors.New("cache backend cannot clear a prefix")
+
+// MemcachedCache implements caching on memcached servers, speaking the text
+// protocol. Exists and GetTTL use the meta commands of memcached 1.6.
+type MemcachedCache struct {
+	servers    []*memcachedServer
+	prefix     string
+	defaultTTL time.Duration
+	timeout    time.Duration
+	logger     *zap.Logger
+}
+
+// memcachedServer pools the connections to one server
+type memcachedServer struct {
+	addr string
+	idle chan *memcachedConn
+}
+
+// memcachedConn is a buffered connection to a server
+type memcachedConn struct {
+	net.Conn
+	rw *bufio.ReadWriter
+}
+
+// NewMemcachedCache creates a new memcached cache
+func NewMemcachedCache(cfg config.MemcachedConfig, prefix string, defaultTTL time.Duration, logger *zap.Logger) (*MemcachedCache, error) {
+	if len(cfg.Servers) == 0 {
+		return nil, fmt.Errorf("no memcached servers configured")
+	}
+	m := &MemcachedCache{
+		prefix:     prefix,
+		defaultTTL: defaultTTL,
+		timeout:    cfg.Timeout,
+		logger:     logger,
+	}
+	if m.timeout <= 0 {
+		m.timeout = 500 * time.Millisecond
+	}
+	for _, addr := range cfg.Servers {
+		m.servers = append(m.servers, &memcachedServer{addr: addr, idle: make(chan *memcachedConn, cfg.MaxIdleConns)})
+	}
+	return m, nil
+}
+
+// Get retrieves a value from cache
+func (m *MemcachedCache) Get(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := m.do(ctx, key, func(rw *bufio.ReadWriter, fullKey string) error {
+		fmt.Fprintf(rw, "get %s\r\n", fullKey)
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		line, err := readLine(rw.Reader)
+		if err != nil {
+			return err
+		}
+		if line == "END" {
+			return ErrCacheMiss
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 4 || fields[0] != "VALUE" {
+			return memcachedError(line)
+		}
+		size, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return fmt.Errorf("memcached: malformed reply %q", line)
+		}
+		value = make([]byte, size+2)
+		if _, err := io.ReadFull(rw, value); err != nil {
+			return err
+		}
+		value = value[:size]
+		if line, err = readLine(rw.Reader); err != nil {
+			return err
+		} else if line != "END" {
+			return memcachedError(line)
+		}
+		return nil
+	})
+	if err != nil {
+		if err != ErrCacheMiss {
+			m.logger.Error("Memcached get error", zap.String("key", key), zap.Error(err))
+		}
+		return nil, err
+	}
+	return value, nil
+}
+
+// Set stores a value in cache
+func (m *MemcachedCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if ttl == 0 {
+		ttl = m.defaultTTL
+	}
+	exptime := int64(ttl.Seconds())
+	if ttl > memcachedRelativeTTL {
+		exptime = time.Now().Add(ttl).Unix()
+	}
+
+	err := m.do(ctx, key, func(rw *bufio.ReadWriter, fullKey string) error {
+		fmt.Fprintf(rw, "set %s 0 %d %d\r\n", fullKey, exptime, len(value))
+		rw.Write(value)
+		rw.WriteString("\r\n")
+		return expectReply(rw, "STORED")
+	})
+	if err != nil {
+		m.logger.Error("Memcached set error", zap.String("key", key), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// Delete removes a value from cache
+func (m *MemcachedCache) Delete(ctx context.Context, key string) error {
+	err := m.do(ctx, key, func(rw *bufio.ReadWriter, fullKey string) error {
+		fmt.Fprintf(rw, "delete %s\r\n", fullKey)
+		return expectReply(rw, "DELETED", "NOT_FOUND")
+	})
+	if err != nil {
+		m.logger.Error("Memcached delete error", zap.String("key", key), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// Exists checks if a key exists in cache
+func (m *MemcachedCache) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := m.metaGet(ctx, key)
+	if err == ErrCacheMiss {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Clear is not supported: memcached cannot list the keys of a prefix, and
+// flushing the servers would drop every other cache's entries
+func (m *MemcachedCache) Clear(ctx context.Context) error {
+	return ErrClearUnsupported
+}
+
+// GetTTL returns the TTL of a key
+func (m *MemcachedCache) GetTTL(ctx context.Context, key string) (time.Duration, error) {
+	flags, err := m.metaGet(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	for _, flag := range flags {
+		if strings.HasPrefix(flag, "t") {
+			seconds, err := strconv.Atoi(flag[1:])
+			if err != nil {
+				return 0, fmt.Errorf("memcached: malformed ttl %q", flag)
+			}
+			if seconds < 0 {
+				return -1, nil // No expiration
+			}
+			return time.Duration(seconds) * time.Second, nil
+		}
+	}
+	return 0, fmt.Errorf("memcached: no ttl in reply")
+}
+
+// metaGet returns the flags of a key's metadata, without its value
+func (m *MemcachedCache) metaGet(ctx context.Context, key string) ([]string, error) {
+	var flags []string
+	err := m.do(ctx, key, func(rw *bufio.ReadWriter, fullKey string) error {
+		fmt.Fprintf(rw, "mg %s t\r\n", fullKey)
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		line, err := readLine(rw.Reader)
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(line)
+		switch {
+		case line == "EN":
+			return ErrCacheMiss
+		case len(fields) > 0 && fields[0] == "HD":
+			flags = fields[1:]
+			return nil
+		}
+		return memcachedError(line)
+	})
+	return flags, err
+}
+
+// do runs a command on the server of key, over a pooled connection
+func (m *MemcachedCache) do(ctx context.Context, key string, command func(rw *bufio.ReadWriter, fullKey string) error) error {
+	fullKey := m.buildKey(key)
+	server := m.servers[0]
+	if len(m.servers) > 1 {
+		server = m.servers[crc32.ChecksumIEEE([]byte(fullKey))%uint32(len(m.servers))]
+	}
+
+	conn, err := server.get(ctx, m.timeout)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(m.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	err = command(conn.rw, fullKey)
+	if err == nil || err == ErrCacheMiss {
+		server.put(conn)
+	} else {
+		// The connection may be out of sync with the server
+		conn.Close()
+	}
+	return err
+}
+
+// buildKey builds the full key, hashing keys memcached would reject
+func (m *MemcachedCache) buildKey(key string) string {
+	fullKey := key
+	if m.prefix != "" {
+		fullKey = m.prefix + ":" + key
+	}
+	if len(fullKey) <= memcachedMaxKey && strings.IndexFunc(fullKey, func(r rune) bool { return r <= ' ' || r == 0x7f }) < 0 {
+		return fullKey
+	}
+	sum := sha256.Sum256([]byte(key))
+	return m.prefix + ":sha256:" + hex.EncodeToString(sum[:])
+}
+
+// get returns an idle connection or dials a new one
+func (s *memcachedServer) get(ctx context.Context, timeout time.Duration) (*memcachedConn, error) {
+	select {
+	case conn := <-s.idle:
+		return conn, nil
+	default:
+	}
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to memcached %s: %w", s.addr, err)
+	}
+	return &memcachedConn{Conn: conn, rw: bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))}, nil
+}
+
+// put returns a connection to the pool, closing it when the pool is full
+func (s *memcachedServer) put(conn *memcachedConn) {
+	select {
+	case s.idle <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// memcachedReplyError is an error reply from the server
+type memcachedReplyError struct {
+	reply string
+}
+
+func (e *memcachedReplyError) Error() string {
+	return "memcached: " + e.reply
+}
+
+// memcachedError wraps an unexpected reply line
+func memcachedError(line string) error {
+	return &memcachedReplyError{reply: line}
+}
+
+// expectReply flushes a command and checks its one-line reply
+func expectReply(rw *bufio.ReadWriter, accepted ...string) error {
+	if err := rw.Flush(); err != nil {
+		return err
+	}
+	line, err := readLine(rw.Reader)
+	if err != nil {
+		return err
+	}
+	for _, reply := range accepted {
+		if line == reply {
+			return nil
+		}
+	}
+	return memcachedError(line)
+}
+
+// readLine reads a reply line without its CRLF
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimRight(line, "\r\n")), nil
+}
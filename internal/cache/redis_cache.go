@@ -24,22 +24,38 @@ type Cache interface {
 
 // RedisCache implements Redis-based caching
 type RedisCache struct {
-	client     *redis.Client
-	prefix     string
-	defaultTTL time.Duration
-	logger     *zap.Logger
+	client       *redis.Client
+	prefix       string
+	defaultTTL   time.Duration
+	clearTimeout time.Duration
+	logger       *zap.Logger
 }
 
+const (
+	// defaultClearTimeout bounds how long Clear may scan the keyspace
+	defaultClearTimeout = time.Minute
+	// unlinkBatch is the SCAN count and UNLINK batch size of bulk deletes
+	unlinkBatch = 500
+	// clearProgressKeys is how often Clear logs its progress
+	clearProgressKeys = 10000
+)
+
 // NewRedisCache creates a new Redis cache
 func NewRedisCache(client *redis.Client, prefix string, defaultTTL time.Duration, logger *zap.Logger) *RedisCache {
 	return &RedisCache{
-		client:     client,
-		prefix:     prefix,
-		defaultTTL: defaultTTL,
-		logger:     logger,
+		client:       client,
+		prefix:       prefix,
+		defaultTTL:   defaultTTL,
+		clearTimeout: defaultClearTimeout,
+		logger:       logger,
 	}
 }
 
+// SetClearTimeout bounds how long Clear may run; 0 means no limit
+func (r *RedisCache) SetClearTimeout(timeout time.Duration) {
+	r.clearTimeout = timeout
+}
+
 // Get retrieves a value from cache
 func (r *RedisCache) Get(ctx context.Context, key string) ([]byte, error) {
 	fullKey := r.buildKey(key)
@@ -103,25 +119,43 @@ func (r *RedisCache) Exists(ctx context.Context, key string) (bool, error) {
 	return exists > 0, nil
 }
 
-// Clear removes all cached items with the prefix
+// Clear removes all cached items with the prefix. Keys are scanned and
+// unlinked in batches so Redis is never blocked, and the clear stops once
+// the clear timeout passes, leaving the remaining keys.
 func (r *RedisCache) Clear(ctx context.Context) error {
-	pattern := r.buildKey("*")
-
-	keys, err := r.client.Keys(ctx, pattern).Result()
-	if err != nil {
-		r.logger.Error("Cache clear error", zap.Error(err))
-		return err
+	pattern := "*"
+	if r.prefix != "" {
+		pattern = globEscape(r.prefix) + ":*"
+	}
+	if r.clearTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.clearTimeout)
+		defer cancel()
 	}
 
-	if len(keys) > 0 {
-		err = r.client.Del(ctx, keys...).Err()
-		if err != nil {
-			r.logger.Error("Cache clear delete error", zap.Error(err))
-			return err
+	start := time.Now()
+	reported := int64(0)
+	deleted, err := unlinkMatching(ctx, r.client, pattern, func(deleted int64) {
+		if deleted-reported >= clearProgressKeys {
+			reported = deleted
+			r.logger.Info("Cache clear in progress",
+				zap.String("prefix", r.prefix),
+				zap.Int64("keys_deleted", deleted),
+				zap.Duration("elapsed", time.Since(start)))
 		}
+	})
+	if err != nil {
+		r.logger.Error("Cache clear error",
+			zap.String("prefix", r.prefix),
+			zap.Int64("keys_deleted", deleted),
+			zap.Error(err))
+		return fmt.Errorf("cache clear stopped after %d keys: %w", deleted, err)
 	}
 
-	r.logger.Info("Cache cleared", zap.Int("keys_deleted", len(keys)))
+	r.logger.Info("Cache cleared",
+		zap.String("prefix", r.prefix),
+		zap.Int64("keys_deleted", deleted),
+		zap.Duration("duration", time.Since(start)))
 	return nil
 }
 
@@ -146,6 +180,39 @@ func (r *RedisCache) buildKey(key string) string {
 	return fmt.Sprintf("%s:%s", r.prefix, key)
 }
 
+// unlinkMatching deletes the keys matching a pattern, scanning and
+// unlinking them in batches. progress, if set, is called with the running
+// count after each batch.
+func unlinkMatching(ctx context.Context, client *redis.Client, pattern string, progress func(deleted int64)) (int64, error) {
+	var deleted int64
+	iter := client.Scan(ctx, 0, pattern, unlinkBatch).Iterator()
+	batch := make([]string, 0, unlinkBatch)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := client.Unlink(ctx, batch...).Result()
+		deleted += n
+		batch = batch[:0]
+		if err == nil && progress != nil {
+			progress(deleted)
+		}
+		return err
+	}
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == unlinkBatch {
+			if err := flush(); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return deleted, err
+	}
+	return deleted, flush()
+}
+
 // Manager manages multiple cache instances
 type Manager struct {
 	caches     map[string]Cache
@@ -153,36 +220,49 @@ type Manager struct {
 	logger     *zap.Logger
 }
 
-// NewManager creates a new cache manager
-func NewManager(cfg *config.CacheConfig, redisClient *redis.Client, logger *zap.Logger) *Manager {
+// NewManager creates a new cache manager, opening each named cache on its
+// configured backend. Caches without a backend use Redis when it is
+// available, else memory.
+func NewManager(cfg *config.CacheConfig, redisClient *redis.Client, logger *zap.Logger) (*Manager, error) {
 	manager := &Manager{
 		caches:     make(map[string]Cache),
 		defaultTTL: cfg.TTL,
 		logger:     logger,
 	}
 
+	defaultBackend := BackendMemory
 	if cfg.Enabled && redisClient != nil {
-		// Create default cache
-		manager.caches["default"] = NewRedisCache(redisClient, "gateway", cfg.TTL, logger)
-
-		// Create specialized caches
-		manager.caches["responses"] = NewRedisCache(redisClient, "gateway:responses", cfg.TTL, logger)
-		manager.caches["auth"] = NewRedisCache(redisClient, "gateway:auth", 1*time.Hour, logger)
-		manager.caches["ratelimit"] = NewRedisCache(redisClient, "gateway:ratelimit", 1*time.Minute, logger)
-
-		logger.Info("Cache manager initialized with Redis")
-	} else {
-		// Use in-memory cache as fallback
-		memCache := NewMemoryCache(cfg.MaxSize, cfg.TTL, logger)
-		manager.caches["default"] = memCache
-		manager.caches["responses"] = memCache
-		manager.caches["auth"] = memCache
-		manager.caches["ratelimit"] = memCache
-
-		logger.Info("Cache manager initialized with in-memory cache")
+		defaultBackend = BackendRedis
+	}
+
+	for _, named := range []struct {
+		name, prefix string
+		ttl          time.Duration
+	}{
+		{"default", "gateway", cfg.TTL},
+		{"responses", "gateway:responses", cfg.TTL},
+		{"auth", "gateway:auth", 1 * time.Hour},
+		{"ratelimit", "gateway:ratelimit", 1 * time.Minute},
+	} {
+		backend := cfg.Backends[named.name]
+		if backend == "" {
+			backend = defaultBackend
+		}
+		c, err := openBackend(backend, BackendOptions{
+			Config:     cfg,
+			Redis:      redisClient,
+			Prefix:     named.prefix,
+			DefaultTTL: named.ttl,
+			Logger:     logger,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s cache on %s: %w", named.name, backend, err)
+		}
+		manager.caches[named.name] = c
+		logger.Info("Cache initialized", zap.String("cache", named.name), zap.String("backend", backend))
 	}
 
-	return manager
+	return manager, nil
 }
 
 // GetCache returns a cache instance by name
@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// unlinkRecorder records every UNLINK batch and whether DEL was sent.
+// miniredis SCAN cursors are offsets into the sorted keys, so unlinking
+// while scanning would skip keys there, unlike on Redis where SCAN returns
+// every key present for the whole iteration. UNLINKs are therefore held
+// back and applied by apply.
+type unlinkRecorder struct {
+	mu      sync.Mutex
+	batches [][]string
+	del     bool
+}
+
+// apply deletes the recorded keys from server
+func (u *unlinkRecorder) apply(server *miniredis.Miniredis) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, batch := range u.batches {
+		for _, key := range batch {
+			server.Del(key)
+		}
+	}
+}
+
+// sizes returns the size of every UNLINK batch
+func (u *unlinkRecorder) sizes() []int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	sizes := make([]int, len(u.batches))
+	for i, batch := range u.batches {
+		sizes[i] = len(batch)
+	}
+	return sizes
+}
+
+func (u *unlinkRecorder) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (u *unlinkRecorder) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func (u *unlinkRecorder) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		switch cmd.Name() {
+		case "unlink":
+			batch := make([]string, 0, len(cmd.Args())-1)
+			for _, arg := range cmd.Args()[1:] {
+				batch = append(batch, fmt.Sprint(arg))
+			}
+			u.mu.Lock()
+			u.batches = append(u.batches, batch)
+			u.mu.Unlock()
+			cmd.(*redis.IntCmd).SetVal(int64(len(batch)))
+			return nil
+		case "del":
+			u.mu.Lock()
+			u.del = true
+			u.mu.Unlock()
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func TestRedisCacheClear(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	recorder := &unlinkRecorder{}
+	client.AddHook(recorder)
+
+	for i := 0; i < 1200; i++ {
+		server.Set(fmt.Sprintf("api[1]:key-%d", i), "v")
+	}
+	// Neither another prefix nor one the glob characters would match
+	server.Set("other:key", "v")
+	server.Set("api1:key", "v")
+
+	c := NewRedisCache(client, "api[1]", 0, zap.NewNop())
+	if err := c.Clear(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	recorder.apply(server)
+
+	keys := server.Keys()
+	if len(keys) != 2 || keys[0] != "api1:key" || keys[1] != "other:key" {
+		t.Errorf("keys after Clear() = %v, want only the other prefixes", keys)
+	}
+	if recorder.del {
+		t.Error("Clear() sent DEL, want UNLINK")
+	}
+	if sizes := recorder.sizes(); len(sizes) != 3 || sizes[0] != unlinkBatch || sizes[1] != unlinkBatch || sizes[2] != 200 {
+		t.Errorf("UNLINK batches = %v, want 500, 500, 200", sizes)
+	}
+}
+
+func TestRedisCacheClearStopsWithContext(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	for i := 0; i < 10; i++ {
+		server.Set(fmt.Sprintf("api:key-%d", i), "v")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c := NewRedisCache(client, "api", 0, zap.NewNop())
+	if err := c.Clear(ctx); err == nil {
+		t.Error("Clear() with a cancelled context = nil, want an error")
+	}
+	if len(server.Keys()) != 10 {
+		t.Errorf("keys after a cancelled Clear() = %d, want all 10 left", len(server.Keys()))
+	}
+}
+
+func TestUnlinkMatchingProgress(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	recorder := &unlinkRecorder{}
+	client.AddHook(recorder)
+	for i := 0; i < 1100; i++ {
+		server.Set(fmt.Sprintf("tenant:%d", i), "v")
+	}
+
+	var progress []int64
+	deleted, err := unlinkMatching(context.Background(), client, "tenant:*", func(deleted int64) {
+		progress = append(progress, deleted)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1100 {
+		t.Errorf("deleted = %d, want 1100", deleted)
+	}
+	if len(progress) != 3 || progress[0] != 500 || progress[1] != 1000 || progress[2] != 1100 {
+		t.Errorf("progress = %v, want 500, 1000, 1100", progress)
+	}
+}
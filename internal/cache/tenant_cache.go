@@ -0,0 +1,386 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// ErrTenantQuotaExceeded is returned when a write would take a tenant over
+// its quota
+var ErrTenantQuotaExceeded = errors.New("tenant cache quota exceeded")
+
+// sharedNamespace holds the keys of requests without a tenant
+const sharedNamespace = "shared"
+
+// tenantScanCount is the SCAN batch size used to sample and purge
+const tenantScanCount = 500
+
+// tenantContextKey is the context key of the tenant a request belongs to
+type tenantContextKey struct{}
+
+// WithTenant returns a context whose cache reads and writes belong to tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant of ctx, "" for none
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantContextKey{}).(string)
+	return tenant
+}
+
+// TenantQuota caps the keys and bytes of a tenant; 0 means no limit
+type TenantQuota struct {
+	MaxKeys  int64
+	MaxBytes int64
+}
+
+// TenantUsage is a tenant's cache usage as last sampled, plus the writes
+// made since
+type TenantUsage struct {
+	Tenant    string    `json:"tenant"`
+	Keys      int64     `json:"keys"`
+	Bytes     int64     `json:"bytes"` // Estimated from a sample of keys
+	MaxKeys   int64     `json:"max_keys,omitempty"`
+	MaxBytes  int64     `json:"max_bytes,omitempty"`
+	Rejected  int64     `json:"rejected"` // Writes refused over the quota
+	SampledAt time.Time `json:"sampled_at,omitempty"`
+}
+
+// TenantCache is a Redis cache whose keys are namespaced by the tenant of
+// the request context, so tenants sharing Redis can be measured and purged
+// separately. Writes that would take a tenant over its quota are refused
+// rather than left to evict other tenants' keys. Usage is measured by
+// sampling Redis periodically and estimated from the writes in between.
+type TenantCache struct {
+	client     *redis.Client
+	prefix     string
+	defaultTTL time.Duration
+	quota      TenantQuota
+	quotas     map[string]TenantQuota
+	sampleSize int
+	logger     *zap.Logger
+
+	mu    sync.Mutex
+	usage map[string]*TenantUsage
+	stop  chan struct{}
+}
+
+// NewTenantCache creates a tenant cache under prefix. quota applies to
+// tenants without an entry in quotas. sampleSize keys of each tenant are
+// sized to estimate its bytes.
+func NewTenantCache(client *redis.Client, prefix string, defaultTTL time.Duration, quota TenantQuota, quotas map[string]TenantQuota, sampleSize int, logger *zap.Logger) *TenantCache {
+	return &TenantCache{
+		client:     client,
+		prefix:     prefix,
+		defaultTTL: defaultTTL,
+		quota:      quota,
+		quotas:     quotas,
+		sampleSize: sampleSize,
+		logger:     logger,
+		usage:      make(map[string]*TenantUsage),
+	}
+}
+
+// namespace returns the key namespace of a tenant. Tenants are escaped so
+// they never contain the separator or glob characters.
+func namespace(tenant string) string {
+	if tenant == "" {
+		return sharedNamespace
+	}
+	return "t-" + strings.ReplaceAll(url.QueryEscape(tenant), "*", "%2A")
+}
+
+// tenantOf returns the tenant of a key namespace
+func tenantOf(ns string) (string, bool) {
+	if ns == sharedNamespace {
+		return "", true
+	}
+	escaped, ok := strings.CutPrefix(ns, "t-")
+	if !ok {
+		return "", false
+	}
+	tenant, err := url.QueryUnescape(escaped)
+	return tenant, err == nil
+}
+
+// tenantKey returns the Redis key of key for tenant
+func (t *TenantCache) tenantKey(tenant, key string) string {
+	return t.prefix + ":" + namespace(tenant) + ":" + key
+}
+
+// quotaOf returns the quota of a tenant
+func (t *TenantCache) quotaOf(tenant string) TenantQuota {
+	if quota, ok := t.quotas[tenant]; ok {
+		return quota
+	}
+	return t.quota
+}
+
+// usageOf returns the usage entry of a tenant; t.mu must be held
+func (t *TenantCache) usageOf(tenant string) *TenantUsage {
+	usage, ok := t.usage[tenant]
+	if !ok {
+		usage = &TenantUsage{Tenant: tenant}
+		t.usage[tenant] = usage
+	}
+	return usage
+}
+
+// Get retrieves a value of the context's tenant
+func (t *TenantCache) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := t.client.Get(ctx, t.tenantKey(TenantFromContext(ctx), key)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrCacheMiss
+	}
+	if err != nil {
+		t.logger.Error("Tenant cache get error", zap.String("key", key), zap.Error(err))
+		return nil, err
+	}
+	return value, nil
+}
+
+// Set stores a value of the context's tenant unless it would take the
+// tenant over its quota. Existing keys may always be overwritten.
+func (t *TenantCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	tenant := TenantFromContext(ctx)
+	fullKey := t.tenantKey(tenant, key)
+	quota := t.quotaOf(tenant)
+	size := int64(len(fullKey) + len(value))
+
+	t.mu.Lock()
+	usage := t.usageOf(tenant)
+	over := quota.MaxKeys > 0 && usage.Keys >= quota.MaxKeys ||
+		quota.MaxBytes > 0 && usage.Bytes+size > quota.MaxBytes
+	t.mu.Unlock()
+
+	if over {
+		exists, err := t.client.Exists(ctx, fullKey).Result()
+		if err != nil || exists == 0 {
+			t.mu.Lock()
+			usage.Rejected++
+			t.mu.Unlock()
+			t.logger.Debug("Tenant cache quota exceeded", zap.String("tenant", tenant), zap.String("key", key))
+			return fmt.Errorf("%w: %s", ErrTenantQuotaExceeded, tenant)
+		}
+	}
+
+	if ttl == 0 {
+		ttl = t.defaultTTL
+	}
+	if err := t.client.Set(ctx, fullKey, value, ttl).Err(); err != nil {
+		t.logger.Error("Tenant cache set error", zap.String("key", key), zap.Error(err))
+		return err
+	}
+
+	// Counted until the next sample corrects the estimate
+	t.mu.Lock()
+	if !over {
+		usage.Keys++
+	}
+	usage.Bytes += size
+	t.mu.Unlock()
+	return nil
+}
+
+// Delete removes a value of the context's tenant
+func (t *TenantCache) Delete(ctx context.Context, key string) error {
+	tenant := TenantFromContext(ctx)
+	deleted, err := t.client.Del(ctx, t.tenantKey(tenant, key)).Result()
+	if err != nil {
+		t.logger.Error("Tenant cache delete error", zap.String("key", key), zap.Error(err))
+		return err
+	}
+	if deleted > 0 {
+		t.mu.Lock()
+		if usage := t.usageOf(tenant); usage.Keys > 0 {
+			usage.Keys--
+		}
+		t.mu.Unlock()
+	}
+	return nil
+}
+
+// Exists checks if a key of the context's tenant exists
+func (t *TenantCache) Exists(ctx context.Context, key string) (bool, error) {
+	exists, err := t.client.Exists(ctx, t.tenantKey(TenantFromContext(ctx), key)).Result()
+	if err != nil {
+		return false, err
+	}
+	return exists > 0, nil
+}
+
+// Clear removes the keys of every tenant
+func (t *TenantCache) Clear(ctx context.Context) error {
+	deleted, err := unlinkMatching(ctx, t.client, globEscape(t.prefix)+":*", nil)
+	if err != nil {
+		t.logger.Error("Tenant cache clear error", zap.Error(err))
+		return err
+	}
+
+	t.mu.Lock()
+	t.usage = make(map[string]*TenantUsage)
+	t.mu.Unlock()
+	t.logger.Info("Tenant cache cleared", zap.Int64("keys_deleted", deleted))
+	return nil
+}
+
+// GetTTL returns the TTL of a key of the context's tenant
+func (t *TenantCache) GetTTL(ctx context.Context, key string) (time.Duration, error) {
+	return t.client.TTL(ctx, t.tenantKey(TenantFromContext(ctx), key)).Result()
+}
+
+// Purge removes every key of a tenant and returns how many were deleted
+func (t *TenantCache) Purge(ctx context.Context, tenant string) (int64, error) {
+	deleted, err := unlinkMatching(ctx, t.client, globEscape(t.prefix+":"+namespace(tenant))+":*", nil)
+	if err != nil {
+		return deleted, fmt.Errorf("failed to purge tenant %s: %w", tenant, err)
+	}
+
+	t.mu.Lock()
+	if usage, ok := t.usage[tenant]; ok {
+		usage.Keys, usage.Bytes = 0, 0
+	}
+	t.mu.Unlock()
+	t.logger.Info("Tenant cache purged", zap.String("tenant", tenant), zap.Int64("keys_deleted", deleted))
+	return deleted, nil
+}
+
+// Sample measures the usage of every tenant: keys are counted with SCAN
+// and the bytes of up to sampleSize keys per tenant are extrapolated
+func (t *TenantCache) Sample(ctx context.Context) error {
+	type sample struct {
+		keys, sized, bytes int64
+	}
+	samples := make(map[string]*sample)
+	sizes := make(map[string][]*redis.IntCmd)
+
+	pipe := t.client.Pipeline()
+	iter := t.client.Scan(ctx, 0, globEscape(t.prefix)+":*", tenantScanCount).Iterator()
+	for iter.Next(ctx) {
+		ns, _, _ := strings.Cut(strings.TrimPrefix(iter.Val(), t.prefix+":"), ":")
+		tenant, ok := tenantOf(ns)
+		if !ok {
+			continue
+		}
+		s, ok := samples[tenant]
+		if !ok {
+			s = &sample{}
+			samples[tenant] = s
+		}
+		s.keys++
+		if len(sizes[tenant]) < t.sampleSize {
+			sizes[tenant] = append(sizes[tenant], pipe.MemoryUsage(ctx, iter.Val()))
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("failed to scan tenant cache: %w", err)
+	}
+	if pipe.Len() > 0 {
+		// Keys may expire between SCAN and MEMORY USAGE
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return fmt.Errorf("failed to size tenant cache keys: %w", err)
+		}
+	}
+	for tenant, cmds := range sizes {
+		s := samples[tenant]
+		for _, cmd := range cmds {
+			if size, err := cmd.Result(); err == nil {
+				s.sized++
+				s.bytes += size
+			}
+		}
+	}
+
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for tenant, usage := range t.usage {
+		if _, ok := samples[tenant]; !ok {
+			if usage.Rejected == 0 {
+				delete(t.usage, tenant)
+				continue
+			}
+			usage.Keys, usage.Bytes, usage.SampledAt = 0, 0, now
+		}
+	}
+	for tenant, s := range samples {
+		usage := t.usageOf(tenant)
+		usage.Keys = s.keys
+		usage.Bytes = 0
+		if s.sized > 0 {
+			usage.Bytes = s.bytes * s.keys / s.sized
+		}
+		usage.SampledAt = now
+	}
+	return nil
+}
+
+// Start samples the usage every interval until Stop is called
+func (t *TenantCache) Start(interval time.Duration) {
+	t.stop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := t.Sample(context.Background()); err != nil {
+				t.logger.Warn("Tenant cache sampling failed", zap.Error(err))
+			}
+			select {
+			case <-ticker.C:
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops sampling
+func (t *TenantCache) Stop() {
+	if t.stop != nil {
+		close(t.stop)
+	}
+}
+
+// Usage returns the usage of every tenant, largest first
+func (t *TenantCache) Usage() []TenantUsage {
+	t.mu.Lock()
+	usages := make([]TenantUsage, 0, len(t.usage))
+	for _, usage := range t.usage {
+		entry := *usage
+		quota := t.quotaOf(entry.Tenant)
+		entry.MaxKeys, entry.MaxBytes = quota.MaxKeys, quota.MaxBytes
+		usages = append(usages, entry)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].Bytes != usages[j].Bytes {
+			return usages[i].Bytes > usages[j].Bytes
+		}
+		return usages[i].Tenant < usages[j].Tenant
+	})
+	return usages
+}
+
+// globEscape escapes the glob characters of a SCAN MATCH pattern
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
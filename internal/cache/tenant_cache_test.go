@@ -0,0 +1,26 @@
+package cache
+
+import "testing"
+
+func TestTenantNamespace(t *testing.T) {
+	for _, tenant := range []string{"", "acme", "a:b*c", "shared", "t-x", "ünï code"} {
+		ns := namespace(tenant)
+		for _, c := range ns {
+			if c == ':' || c == '*' || c == '?' || c == '[' || c == ']' {
+				t.Errorf("namespace(%q) = %q contains %q", tenant, ns, c)
+			}
+		}
+		if got, ok := tenantOf(ns); !ok || got != tenant {
+			t.Errorf("tenantOf(namespace(%q)) = %q, %v", tenant, got, ok)
+		}
+	}
+	if namespace("shared") == namespace("") {
+		t.Error("a tenant named shared collides with the shared namespace")
+	}
+}
+
+func TestGlobEscape(t *testing.T) {
+	if got, want := globEscape(`gw:t-a*b?[c]\`), `gw:t-a\*b\?\[c\]\\`; got != want {
+		t.Errorf("globEscape() = %q, want %q", got, want)
+	}
+}
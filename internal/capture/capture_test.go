@@ -0,0 +1,67 @@
+package capture
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestSanitizer(t *testing.T) {
+	s := NewSanitizer([]string{"X-Session"}, []string{"token"}, []string{"password"})
+
+	header := s.Header(http.Header{"Authorization": {"Bearer x"}, "X-Session": {"abc"}, "Accept": {"*/*"}})
+	if header.Get("Authorization") != Redacted || header.Get("X-Session") != Redacted || header.Get("Accept") != "*/*" {
+		t.Errorf("header %v", header)
+	}
+
+	u, _ := url.Parse("/login?token=secret&page=2")
+	if got := s.URL(u); got != "/login?page=2&token=REDACTED" {
+		t.Errorf("url %s", got)
+	}
+
+	body := s.Body([]byte(`{"user":"ann","credentials":{"Password":"hunter2"}}`), "application/json")
+	if string(body) != `{"credentials":{"Password":"REDACTED"},"user":"ann"}` {
+		t.Errorf("body %s", body)
+	}
+	if body := s.Body([]byte(`{"password":"hun`), "application/json"); body != nil {
+		t.Errorf("truncated body kept: %s", body)
+	}
+}
+
+func TestRecorderWritesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	sink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := NewRecorder(sink, 10, zap.NewNop())
+	for _, id := range []string{"a", "b"} {
+		recorder.Record(Exchange{RequestID: id, Timestamp: time.Now(), Method: "POST", URL: "/orders",
+			Request: Message{Body: []byte(`{"qty":1}`)}, Response: Message{Status: 201}})
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var ids []string
+	err = Decode(file, func(e Exchange) error {
+		if string(e.Request.Body) != `{"qty":1}` || e.Response.Status != 201 {
+			t.Errorf("exchange %+v", e)
+		}
+		ids = append(ids, e.RequestID)
+		return nil
+	})
+	if err != nil || len(ids) != 2 || ids[0] != "a" || ids[1] != "b" {
+		t.Errorf("decoded %v, err %v", ids, err)
+	}
+}
@@ -0,0 +1,166 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Redacted replaces sanitized header, query and body values
+const Redacted = "REDACTED"
+
+// alwaysRedacted are headers carrying credentials, redacted whatever the
+// configuration says
+var alwaysRedacted = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-API-Key",
+	"X-Gateway-Debug-Secret",
+}
+
+// Exchange is a captured request and its response
+type Exchange struct {
+	RequestID  string    `json:"request_id,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+	Method     string    `json:"method"`
+	URL        string    `json:"url"` // Path and query
+	Host       string    `json:"host"`
+	DurationMs float64   `json:"duration_ms"`
+	Request    Message   `json:"request"`
+	Response   Message   `json:"response"`
+}
+
+// Message is the captured part of a request or response. Body is encoded
+// as base64 in JSON.
+type Message struct {
+	Status    int         `json:"status,omitempty"`
+	Header    http.Header `json:"header,omitempty"`
+	Body      []byte      `json:"body,omitempty"`
+	Truncated bool        `json:"truncated,omitempty"`
+}
+
+// Sanitizer removes credentials and configured sensitive values from
+// captured traffic
+type Sanitizer struct {
+	headers map[string]bool
+	query   map[string]bool
+	fields  map[string]bool
+}
+
+// NewSanitizer creates a sanitizer redacting the given headers, query
+// parameters and JSON body fields in addition to credential headers
+func NewSanitizer(headers, query, fields []string) *Sanitizer {
+	s := &Sanitizer{
+		headers: make(map[string]bool),
+		query:   make(map[string]bool),
+		fields:  make(map[string]bool),
+	}
+	for _, name := range append(alwaysRedacted, headers...) {
+		s.headers[http.CanonicalHeaderKey(name)] = true
+	}
+	for _, name := range query {
+		s.query[name] = true
+	}
+	for _, name := range fields {
+		s.fields[strings.ToLower(name)] = true
+	}
+	return s
+}
+
+// Header returns a copy of h with sensitive values redacted
+func (s *Sanitizer) Header(h http.Header) http.Header {
+	out := h.Clone()
+	for name, values := range out {
+		if s.headers[name] {
+			for i := range values {
+				values[i] = Redacted
+			}
+		}
+	}
+	return out
+}
+
+// URL returns the path and query of u with sensitive parameters redacted
+func (s *Sanitizer) URL(u *url.URL) string {
+	if u.RawQuery == "" || len(s.query) == 0 {
+		return u.RequestURI()
+	}
+	query := u.Query()
+	for name, values := range query {
+		if s.query[name] {
+			for i := range values {
+				values[i] = Redacted
+			}
+		}
+	}
+	out := *u
+	out.RawQuery = query.Encode()
+	return out.RequestURI()
+}
+
+// Body returns a body with sensitive JSON fields redacted. A JSON body that
+// cannot be parsed, such as a truncated one, is dropped when fields are to
+// be redacted, since it may contain them.
+func (s *Sanitizer) Body(body []byte, contentType string) []byte {
+	if len(body) == 0 || len(s.fields) == 0 || !strings.Contains(strings.ToLower(contentType), "json") {
+		return body
+	}
+
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil
+	}
+	redacted, err := json.Marshal(s.redact(value))
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+// redact replaces the values of sensitive fields at any depth
+func (s *Sanitizer) redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if s.fields[strings.ToLower(key)] {
+				v[key] = Redacted
+			} else {
+				v[key] = s.redact(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = s.redact(item)
+		}
+	}
+	return value
+}
+
+// Decode reads exchanges written as JSON lines
+func Decode(r io.Reader, fn func(Exchange) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var exchange Exchange
+		if err := json.Unmarshal(scanner.Bytes(), &exchange); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := fn(exchange); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,103 @@
+package capture
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// batchSize bounds the exchanges handed to the sink at once
+const batchSize = 100
+
+// Sink stores captured exchanges
+type Sink interface {
+	Write(exchanges []Exchange) error
+	Close() error
+}
+
+// Recorder queues captured exchanges and writes them to a sink in the
+// background, so capture never slows down requests. Exchanges arriving
+// while the queue is full are dropped.
+type Recorder struct {
+	sink   Sink
+	queue  chan Exchange
+	logger *zap.Logger
+
+	dropped atomic.Int64
+	mu      sync.RWMutex
+	closed  bool
+	done    chan struct{}
+}
+
+// NewRecorder creates a recorder and starts writing to sink
+func NewRecorder(sink Sink, queueSize int, logger *zap.Logger) *Recorder {
+	r := &Recorder{
+		sink:   sink,
+		queue:  make(chan Exchange, queueSize),
+		logger: logger,
+		done:   make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// Record queues an exchange. It returns false if the exchange was dropped.
+func (r *Recorder) Record(exchange Exchange) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return false
+	}
+	select {
+	case r.queue <- exchange:
+		return true
+	default:
+		if r.dropped.Add(1) == 1 {
+			r.logger.Warn("Capture queue full, dropping exchanges")
+		}
+		return false
+	}
+}
+
+// Dropped returns the number of exchanges dropped because the queue was full
+func (r *Recorder) Dropped() int64 {
+	return r.dropped.Load()
+}
+
+// Close writes the queued exchanges and closes the sink
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.queue)
+	}
+	r.mu.Unlock()
+
+	<-r.done
+	return r.sink.Close()
+}
+
+// run writes queued exchanges in batches
+func (r *Recorder) run() {
+	defer close(r.done)
+	batch := make([]Exchange, 0, batchSize)
+	for exchange := range r.queue {
+		batch = append(batch[:0], exchange)
+	fill:
+		for len(batch) < batchSize {
+			select {
+			case next, ok := <-r.queue:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, next)
+			default:
+				break fill
+			}
+		}
+		if err := r.sink.Write(batch); err != nil {
+			r.logger.Error("Failed to write captured exchanges", zap.Int("exchanges", len(batch)), zap.Error(err))
+		}
+	}
+}
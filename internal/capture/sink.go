@@ -0,0 +1,162 @@
+package capture
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// FileSink appends exchanges to a file as JSON lines
+type FileSink struct {
+	file *os.File
+	mu   sync.Mutex
+}
+
+// NewFileSink opens path for appending, creating it if needed
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open capture file: %w", err)
+	}
+	return &FileSink{file: file}, nil
+}
+
+// Write appends the exchanges
+func (s *FileSink) Write(exchanges []Exchange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := bufio.NewWriter(s.file)
+	encoder := json.NewEncoder(w)
+	for _, exchange := range exchanges {
+		if err := encoder.Encode(exchange); err != nil {
+			return fmt.Errorf("failed to encode exchange: %w", err)
+		}
+	}
+	return w.Flush()
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// KafkaSink publishes exchanges to a Kafka topic, keyed by request ID
+type KafkaSink struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+// NewKafkaSink creates a producer for the capture topic
+func NewKafkaSink(brokers []string, topic string) (*KafkaSink, error) {
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V2_1_0_0
+	cfg.Producer.RequiredAcks = sarama.WaitForLocal
+	cfg.Producer.Retry.Max = 3
+	cfg.Producer.Return.Successes = true
+
+	producer, err := sarama.NewSyncProducer(brokers, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka producer: %w", err)
+	}
+	return &KafkaSink{producer: producer, topic: topic}, nil
+}
+
+// Write publishes the exchanges in one batch
+func (s *KafkaSink) Write(exchanges []Exchange) error {
+	messages := make([]*sarama.ProducerMessage, 0, len(exchanges))
+	for _, exchange := range exchanges {
+		data, err := json.Marshal(exchange)
+		if err != nil {
+			return fmt.Errorf("failed to encode exchange: %w", err)
+		}
+		messages = append(messages, &sarama.ProducerMessage{
+			Topic: s.topic,
+			Key:   sarama.StringEncoder(exchange.RequestID),
+			Value: sarama.ByteEncoder(data),
+		})
+	}
+
+	if err := s.producer.SendMessages(messages); err != nil {
+		return fmt.Errorf("failed to send exchanges to Kafka: %w", err)
+	}
+	return nil
+}
+
+// Close closes the producer
+func (s *KafkaSink) Close() error {
+	return s.producer.Close()
+}
+
+// ReadKafka reads every exchange currently in a capture topic, ordered by
+// timestamp
+func ReadKafka(ctx context.Context, brokers []string, topic string) ([]Exchange, error) {
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V2_1_0_0
+	client, err := sarama.NewClient(brokers, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Kafka: %w", err)
+	}
+	defer client.Close()
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions of %s: %w", topic, err)
+	}
+
+	var exchanges []Exchange
+	for _, partition := range partitions {
+		end, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get offset of partition %d: %w", partition, err)
+		}
+		start, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get offset of partition %d: %w", partition, err)
+		}
+		if start >= end {
+			continue
+		}
+
+		pc, err := consumer.ConsumePartition(topic, partition, start)
+		if err != nil {
+			return nil, fmt.Errorf("failed to consume partition %d: %w", partition, err)
+		}
+		for offset := start; offset < end; {
+			select {
+			case <-ctx.Done():
+				pc.Close()
+				return nil, ctx.Err()
+			case msg := <-pc.Messages():
+				offset = msg.Offset + 1
+				var exchange Exchange
+				if err := json.Unmarshal(msg.Value, &exchange); err != nil {
+					pc.Close()
+					return nil, fmt.Errorf("partition %d offset %d: %w", partition, msg.Offset, err)
+				}
+				exchanges = append(exchanges, exchange)
+			case err := <-pc.Errors():
+				pc.Close()
+				return nil, fmt.Errorf("failed to read partition %d: %w", partition, err)
+			}
+		}
+		pc.Close()
+	}
+
+	sort.SliceStable(exchanges, func(i, j int) bool {
+		return exchanges[i].Timestamp.Before(exchanges[j].Timestamp)
+	})
+	return exchanges, nil
+}
@@ -0,0 +1,208 @@
+package chaos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// ProductionEnvironment is the server environment in which injection is
+// refused unless explicitly allowed
+const ProductionEnvironment = "production"
+
+// ErrNotAllowed is returned when enabling injection in production without
+// chaos.allow_production
+var ErrNotAllowed = errors.New("chaos injection is not allowed in production")
+
+// Rule is a fault injection rule
+type Rule config.ChaosRuleConfig
+
+// ruleJSON is the admin API form of a rule, with the latency as a duration
+// string such as "250ms"
+type ruleJSON struct {
+	Name        string   `json:"name"`
+	PathPrefix  string   `json:"path_prefix"`
+	Methods     []string `json:"methods,omitempty"`
+	Percentage  float64  `json:"percentage"`
+	Latency     string   `json:"latency,omitempty"`
+	AbortStatus int      `json:"abort_status,omitempty"`
+	Drop        bool     `json:"drop,omitempty"`
+}
+
+// MarshalJSON encodes the rule in its admin API form
+func (r Rule) MarshalJSON() ([]byte, error) {
+	out := ruleJSON{
+		Name:        r.Name,
+		PathPrefix:  r.PathPrefix,
+		Methods:     r.Methods,
+		Percentage:  r.Percentage,
+		AbortStatus: r.AbortStatus,
+		Drop:        r.Drop,
+	}
+	if r.Latency > 0 {
+		out.Latency = r.Latency.String()
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes a rule from its admin API form
+func (r *Rule) UnmarshalJSON(data []byte) error {
+	var in ruleJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	*r = Rule{
+		Name:        in.Name,
+		PathPrefix:  in.PathPrefix,
+		Methods:     in.Methods,
+		Percentage:  in.Percentage,
+		AbortStatus: in.AbortStatus,
+		Drop:        in.Drop,
+	}
+	if in.Latency != "" {
+		latency, err := time.ParseDuration(in.Latency)
+		if err != nil {
+			return fmt.Errorf("invalid latency: %w", err)
+		}
+		r.Latency = latency
+	}
+	return nil
+}
+
+// Fault returns the name of the fault the rule injects
+func (r Rule) Fault() string {
+	switch {
+	case r.Drop:
+		return "drop"
+	case r.AbortStatus != 0:
+		return "abort"
+	}
+	return "latency"
+}
+
+// matches reports whether the rule applies to a request
+func (r Rule) matches(req *http.Request) bool {
+	if !strings.HasPrefix(req.URL.Path, r.PathPrefix) {
+		return false
+	}
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, method := range r.Methods {
+		if strings.EqualFold(method, req.Method) {
+			return true
+		}
+	}
+	return false
+}
+
+// Injector decides which requests are faulted. Rules and the enabled
+// switch can be changed at runtime.
+type Injector struct {
+	allowed bool
+
+	mu      sync.RWMutex
+	enabled bool
+	rules   []Rule
+	random  func() float64
+}
+
+// NewInjector creates an injector with the configured rules. It starts
+// enabled unless the environment is production and production use is not
+// allowed.
+func NewInjector(cfg config.ChaosConfig, environment string) *Injector {
+	allowed := cfg.AllowProduction || !strings.EqualFold(environment, ProductionEnvironment)
+	i := &Injector{
+		allowed: allowed,
+		enabled: cfg.Enabled && allowed,
+		random:  rand.Float64,
+	}
+	for _, rule := range cfg.Rules {
+		i.rules = append(i.rules, Rule(rule))
+	}
+	return i
+}
+
+// Allowed reports whether injection may be enabled in this environment
+func (i *Injector) Allowed() bool {
+	return i.allowed
+}
+
+// Enabled reports whether faults are being injected
+func (i *Injector) Enabled() bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.enabled
+}
+
+// SetEnabled turns injection on or off
+func (i *Injector) SetEnabled(enabled bool) error {
+	if enabled && !i.allowed {
+		return ErrNotAllowed
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.enabled = enabled
+	return nil
+}
+
+// Rules returns the current rules
+func (i *Injector) Rules() []Rule {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return append([]Rule(nil), i.rules...)
+}
+
+// SetRule adds a rule or replaces the rule with the same name
+func (i *Injector) SetRule(rule Rule) error {
+	if err := config.ValidateChaosRule(config.ChaosRuleConfig(rule)); err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for n, existing := range i.rules {
+		if existing.Name == rule.Name {
+			i.rules[n] = rule
+			return nil
+		}
+	}
+	i.rules = append(i.rules, rule)
+	return nil
+}
+
+// RemoveRule removes a rule. It returns false if there is no such rule.
+func (i *Injector) RemoveRule(name string) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for n, rule := range i.rules {
+		if rule.Name == name {
+			i.rules = append(i.rules[:n], i.rules[n+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns the rule whose fault the request gets, if any. The first
+// matching rule decides; its percentage is rolled once per request.
+func (i *Injector) Match(req *http.Request) (Rule, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if !i.enabled {
+		return Rule{}, false
+	}
+	for _, rule := range i.rules {
+		if rule.matches(req) {
+			return rule, i.random()*100 < rule.Percentage
+		}
+	}
+	return Rule{}, false
+}
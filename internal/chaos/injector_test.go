@@ -0,0 +1,66 @@
+package chaos
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestInjectorDisabledInProduction(t *testing.T) {
+	cfg := config.ChaosConfig{Enabled: true}
+	injector := NewInjector(cfg, "production")
+	if injector.Enabled() || injector.SetEnabled(true) != ErrNotAllowed {
+		t.Error("injection enabled in production")
+	}
+
+	cfg.AllowProduction = true
+	if injector = NewInjector(cfg, "production"); !injector.Enabled() {
+		t.Error("injection not enabled with allow_production")
+	}
+	if injector = NewInjector(config.ChaosConfig{Enabled: true}, "staging"); !injector.Enabled() {
+		t.Error("injection not enabled in staging")
+	}
+}
+
+func TestInjectorMatch(t *testing.T) {
+	injector := NewInjector(config.ChaosConfig{Enabled: true}, "staging")
+	injector.random = func() float64 { return 0.25 }
+	if err := injector.SetRule(Rule{Name: "orders", PathPrefix: "/orders", Methods: []string{"POST"}, Percentage: 30, AbortStatus: 503}); err != nil {
+		t.Fatal(err)
+	}
+	if err := injector.SetRule(Rule{Name: "users", PathPrefix: "/users", Percentage: 20, Drop: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if rule, ok := injector.Match(httptest.NewRequest("POST", "/orders/1", nil)); !ok || rule.Name != "orders" {
+		t.Errorf("POST /orders/1 not faulted by orders")
+	}
+	if _, ok := injector.Match(httptest.NewRequest("GET", "/orders/1", nil)); ok {
+		t.Error("GET /orders/1 faulted, rule only covers POST")
+	}
+	if _, ok := injector.Match(httptest.NewRequest("GET", "/users", nil)); ok {
+		t.Error("/users faulted above its percentage")
+	}
+
+	injector.SetEnabled(false)
+	if _, ok := injector.Match(httptest.NewRequest("POST", "/orders/1", nil)); ok {
+		t.Error("request faulted while disabled")
+	}
+}
+
+func TestRuleJSON(t *testing.T) {
+	var rule Rule
+	if err := json.Unmarshal([]byte(`{"path_prefix":"/orders","percentage":10,"latency":"250ms"}`), &rule); err != nil {
+		t.Fatal(err)
+	}
+	if rule.Latency != 250*time.Millisecond || rule.Fault() != "latency" {
+		t.Errorf("rule %+v, want 250ms of latency", rule)
+	}
+	data, _ := json.Marshal(rule)
+	if string(data) != `{"name":"","path_prefix":"/orders","percentage":10,"latency":"250ms"}` {
+		t.Errorf("got %s", data)
+	}
+}
@@ -1,51 +1,151 @@
 package circuit
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/sony/gobreaker"
 	"go.uber.org/zap"
 
 	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/internal/upstreamerr"
+	"github.com/max/api-gateway/pkg/metrics"
 )
 
 // CircuitBreaker wraps the gobreaker circuit breaker
 type CircuitBreaker struct {
-	breaker *gobreaker.CircuitBreaker
-	logger  *zap.Logger
+	breaker           *gobreaker.CircuitBreaker
+	slowCallThreshold time.Duration
+	failureStatuses   []string
+	logger            *zap.Logger
+	// lastFailure is the kind of the most recent counted failure
+	lastFailure atomic.Value
+	// heldUntil is the Unix time in nanoseconds until which a restored
+	// open state rejects calls
+	heldUntil atomic.Int64
+	// fleet, if set, shares the breaker's counts with the other replicas
+	fleet *fleet
 }
 
+// StateChangeListener is notified whenever a circuit breaker changes state,
+// with the kind of the most recent failure the breaker counted, such as
+// connection_refused, status_503 or slow_call. Listeners run while the
+// breaker holds its internal lock and must not block.
+type StateChangeListener func(name string, from gobreaker.State, to gobreaker.State, lastFailure string)
+
 // NewCircuitBreaker creates a new circuit breaker
 func NewCircuitBreaker(name string, cfg config.CircuitBreakerConfig, logger *zap.Logger) *CircuitBreaker {
+	return newCircuitBreaker(name, cfg, logger, nil)
+}
+
+// newCircuitBreaker creates a new circuit breaker that reports state changes to onStateChange
+func newCircuitBreaker(name string, cfg config.CircuitBreakerConfig, logger *zap.Logger, onStateChange StateChangeListener) *CircuitBreaker {
 	if !cfg.Enabled {
 		return &CircuitBreaker{
 			logger: logger,
 		}
 	}
 
+	failureStatuses := cfg.FailureStatusCodes
+	if len(failureStatuses) == 0 {
+		failureStatuses = []string{"5xx"}
+	}
+
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = cfg.RecoveryTimeout
+	}
+
+	cb := &CircuitBreaker{
+		slowCallThreshold: cfg.SlowCallThreshold,
+		failureStatuses:   failureStatuses,
+		logger:            logger,
+	}
 	settings := gobreaker.Settings{
-		Name:        name,
-		MaxRequests: uint32(cfg.HalfOpenRequests),
-		Interval:    cfg.RecoveryTimeout,
-		Timeout:     cfg.RecoveryTimeout,
-		ReadyToTrip: func(counts gobreaker.Counts) bool {
-			return counts.ConsecutiveFailures >= uint32(cfg.FailureThreshold)
-		},
+		Name:         name,
+		MaxRequests:  uint32(cfg.HalfOpenRequests),
+		Interval:     interval,
+		Timeout:      cfg.RecoveryTimeout,
+		ReadyToTrip:  ReadyToTrip(cfg),
+		IsSuccessful: cb.isSuccessful,
 		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
+			lastFailure := cb.lastFailureKind()
 			logger.Info("Circuit breaker state changed",
 				zap.String("name", name),
 				zap.String("from", from.String()),
-				zap.String("to", to.String()))
+				zap.String("to", to.String()),
+				zap.String("last_failure", lastFailure))
+
+			if onStateChange != nil {
+				onStateChange(name, from, to, lastFailure)
+			}
 		},
 	}
+	cb.breaker = gobreaker.NewCircuitBreaker(settings)
+	return cb
+}
+
+// isSuccessful reports whether a call result counts as a success, and
+// remembers the kind of counted failures. Upstream failures that say
+// nothing about the upstream's health, such as oversized responses, are
+// not counted.
+func (cb *CircuitBreaker) isSuccessful(err error) bool {
+	success := err == nil
+	var upstreamErr *upstreamerr.Error
+	if errors.As(err, &upstreamErr) && !upstreamErr.Kind.BreakerFailure() {
+		success = true
+	}
+	if !success {
+		cb.lastFailure.Store(failureKind(err))
+	}
+	if cb.fleet != nil {
+		cb.fleet.record(success)
+	}
+	return success
+}
 
-	breaker := gobreaker.NewCircuitBreaker(settings)
+// lastFailureKind returns the kind of the most recent counted failure
+func (cb *CircuitBreaker) lastFailureKind() string {
+	kind, _ := cb.lastFailure.Load().(string)
+	return kind
+}
 
-	return &CircuitBreaker{
-		breaker: breaker,
-		logger:  logger,
+// failureKind names the cause of a breaker failure
+func failureKind(err error) string {
+	var statusErr *StatusError
+	switch {
+	case errors.Is(err, errSlowCall):
+		return "slow_call"
+	case errors.As(err, &statusErr):
+		return "status_" + strconv.Itoa(statusErr.StatusCode)
+	}
+	return string(upstreamerr.KindOf(err))
+}
+
+// ReadyToTrip returns the tripping predicate for the configured strategy
+func ReadyToTrip(cfg config.CircuitBreakerConfig) func(counts gobreaker.Counts) bool {
+	switch cfg.Strategy {
+	case "error_rate":
+		minimum := uint32(cfg.MinimumRequests)
+		if minimum == 0 {
+			minimum = 1
+		}
+		return func(counts gobreaker.Counts) bool {
+			if counts.Requests < minimum {
+				return false
+			}
+			return float64(counts.TotalFailures)/float64(counts.Requests) > cfg.ErrorRateThreshold
+		}
+	default:
+		return func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= uint32(cfg.FailureThreshold)
+		}
 	}
 }
 
@@ -56,7 +156,16 @@ func (cb *CircuitBreaker) Execute(fn func() (interface{}, error)) (interface{},
 		return fn()
 	}
 
-	return cb.breaker.Execute(fn)
+	if cb.heldOpen() {
+		return nil, gobreaker.ErrOpenState
+	}
+
+	result, err := cb.breaker.Execute(cb.timed(fn))
+	if err == errSlowCall {
+		// The call succeeded; it was only reported as a failure to the breaker
+		return result, nil
+	}
+	return result, err
 }
 
 // Call executes a function with circuit breaker protection (no return value)
@@ -66,20 +175,82 @@ func (cb *CircuitBreaker) Call(fn func() error) error {
 		return fn()
 	}
 
-	_, err := cb.breaker.Execute(func() (interface{}, error) {
+	_, err := cb.Execute(func() (interface{}, error) {
 		return nil, fn()
 	})
 	return err
 }
 
+// CallHTTP executes an HTTP call with circuit breaker protection. fn returns
+// the status code sent to the client and any transport error; responses
+// whose status matches the configured failure codes count as failures and
+// are reported as a *StatusError.
+func (cb *CircuitBreaker) CallHTTP(fn func() (int, error)) error {
+	return cb.Call(func() error {
+		status, err := fn()
+		if err != nil {
+			return err
+		}
+		if cb.IsFailureStatus(status) {
+			return &StatusError{StatusCode: status}
+		}
+		return nil
+	})
+}
+
+// IsFailureStatus reports whether an upstream status code counts as a failure
+func (cb *CircuitBreaker) IsFailureStatus(status int) bool {
+	code := strconv.Itoa(status)
+	for _, pattern := range cb.failureStatuses {
+		if pattern == code || (strings.HasSuffix(pattern, "xx") && pattern[0] == code[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// timed wraps fn so that successful calls exceeding the slow-call threshold
+// are reported to the breaker as failures
+func (cb *CircuitBreaker) timed(fn func() (interface{}, error)) func() (interface{}, error) {
+	if cb.slowCallThreshold <= 0 {
+		return fn
+	}
+
+	return func() (interface{}, error) {
+		start := time.Now()
+		result, err := fn()
+		if err == nil && time.Since(start) > cb.slowCallThreshold {
+			cb.logger.Debug("Slow call counted as failure",
+				zap.Duration("duration", time.Since(start)),
+				zap.Duration("threshold", cb.slowCallThreshold))
+			return result, errSlowCall
+		}
+		return result, err
+	}
+}
+
 // State returns the current state of the circuit breaker
 func (cb *CircuitBreaker) State() gobreaker.State {
 	if cb.breaker == nil {
 		return gobreaker.StateClosed
 	}
+	if cb.heldOpen() {
+		return gobreaker.StateOpen
+	}
 	return cb.breaker.State()
 }
 
+// holdOpen rejects calls until openUntil, restoring an open state
+func (cb *CircuitBreaker) holdOpen(openUntil time.Time) {
+	cb.heldUntil.Store(openUntil.UnixNano())
+}
+
+// heldOpen reports whether a restored open state still rejects calls
+func (cb *CircuitBreaker) heldOpen() bool {
+	until := cb.heldUntil.Load()
+	return until != 0 && time.Now().UnixNano() < until
+}
+
 // Counts returns the current counts of the circuit breaker
 func (cb *CircuitBreaker) Counts() gobreaker.Counts {
 	if cb.breaker == nil {
@@ -106,18 +277,68 @@ func (cb *CircuitBreaker) IsHalfOpen() bool {
 // Manager manages multiple circuit breakers
 type Manager struct {
 	breakers map[string]*CircuitBreaker
+	metrics  *metrics.Manager
 	mu       sync.RWMutex
 	logger   *zap.Logger
+
+	// store, if set, persists the open breakers
+	store Store
+	// fleetStore, if set, shares the breakers' counts and state with the
+	// other replicas; fleetDown is set while it is unreachable
+	fleetStore FleetStore
+	fleetDown  bool
+	stop       chan struct{}
+
+	// listeners has its own lock because state changes can fire while mu is
+	// held, e.g. when GetAllStates reads a breaker whose timeout has expired
+	listeners  []StateChangeListener
+	listenerMu sync.RWMutex
 }
 
 // NewManager creates a new circuit breaker manager
-func NewManager(logger *zap.Logger) *Manager {
+func NewManager(logger *zap.Logger, metricsMgr *metrics.Manager) *Manager {
 	return &Manager{
 		breakers: make(map[string]*CircuitBreaker),
+		metrics:  metricsMgr,
 		logger:   logger,
 	}
 }
 
+// OnStateChange registers a listener for state changes of all managed breakers
+func (m *Manager) OnStateChange(listener StateChangeListener) {
+	m.listenerMu.Lock()
+	defer m.listenerMu.Unlock()
+	m.listeners = append(m.listeners, listener)
+}
+
+// notifyStateChange publishes a breaker state change to metrics and listeners
+func (m *Manager) notifyStateChange(name string, from gobreaker.State, to gobreaker.State, lastFailure string) {
+	if m.metrics != nil {
+		m.metrics.SetCircuitBreakerState(name, stateValue(to))
+	}
+
+	m.listenerMu.RLock()
+	listeners := make([]StateChangeListener, len(m.listeners))
+	copy(listeners, m.listeners)
+	m.listenerMu.RUnlock()
+
+	for _, listener := range listeners {
+		listener(name, from, to, lastFailure)
+	}
+}
+
+// stateValue maps a breaker state to the gateway_circuit_breaker_state gauge value
+func stateValue(state gobreaker.State) int {
+	switch state {
+	case gobreaker.StateHalfOpen:
+		return 1
+	case gobreaker.StateOpen:
+		return 2
+	default:
+		return 0
+	}
+}
+
 // GetBreaker returns a circuit breaker by name
 func (m *Manager) GetBreaker(name string) *CircuitBreaker {
 	m.mu.RLock()
@@ -130,8 +351,25 @@ func (m *Manager) CreateBreaker(name string, cfg config.CircuitBreakerConfig) *C
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	breaker := NewCircuitBreaker(name, cfg, m.logger)
+	onStateChange := m.notifyStateChange
+	if m.store != nil && cfg.Enabled {
+		onStateChange = func(name string, from gobreaker.State, to gobreaker.State, lastFailure string) {
+			m.persist(name, to, cfg.RecoveryTimeout)
+			m.notifyStateChange(name, from, to, lastFailure)
+		}
+	}
+	breaker := newCircuitBreaker(name, cfg, m.logger, onStateChange)
+	if m.fleetStore != nil && cfg.Enabled {
+		breaker.fleet = newFleet(cfg)
+	}
 	m.breakers[name] = breaker
+	if m.store != nil && cfg.Enabled {
+		m.restore(name, breaker)
+	}
+
+	if m.metrics != nil {
+		m.metrics.SetCircuitBreakerState(name, stateValue(breaker.State()))
+	}
 
 	m.logger.Info("Circuit breaker created",
 		zap.String("name", name),
@@ -254,7 +492,7 @@ func (m *Manager) HealthCheck() error {
 // ResetBreaker resets a circuit breaker to closed state
 func (m *Manager) ResetBreaker(name string) error {
 	m.mu.RLock()
-	breaker := m.breakers[name]
+	breaker, store := m.breakers[name], m.store
 	m.mu.RUnlock()
 
 	if breaker == nil {
@@ -265,6 +503,19 @@ func (m *Manager) ResetBreaker(name string) error {
 		return nil // Circuit breaker is disabled
 	}
 
+	// A restored open state is dropped, here and in the store
+	breaker.heldUntil.Store(0)
+	if store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		if err := store.Delete(ctx, name); err != nil {
+			m.logger.Warn("Failed to reset stored circuit breaker state", zap.String("name", name), zap.Error(err))
+		}
+	}
+	if m.metrics != nil {
+		m.metrics.SetCircuitBreakerState(name, stateValue(breaker.State()))
+	}
+
 	// Reset by creating a new circuit breaker with the same settings
 	// This is a limitation of the gobreaker library
 	m.logger.Info("Circuit breaker reset requested", zap.String("name", name))
@@ -297,5 +548,23 @@ var (
 	ErrCircuitBreakerOpen     = errors.New("circuit breaker is open")
 	ErrCircuitBreakerNotFound = errors.New("circuit breaker not found")
 	ErrTooManyRequests        = errors.New("too many requests")
+
+	// errSlowCall marks a successful call that exceeded the slow-call threshold
+	errSlowCall = errors.New("slow call")
 )
 
+// StatusError reports an upstream response counted as a breaker failure
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("upstream responded with status %d", e.StatusCode)
+}
+
+// IsRejected reports whether err means the breaker refused the call without
+// executing it, as opposed to the call itself failing
+func IsRejected(err error) bool {
+	return errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests)
+}
+
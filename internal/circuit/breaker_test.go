@@ -0,0 +1,326 @@
+package circuit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/pkg/metrics"
+)
+
+func TestReadyToTrip_ConsecutiveFailures(t *testing.T) {
+	trip := ReadyToTrip(config.CircuitBreakerConfig{FailureThreshold: 3})
+
+	if trip(gobreaker.Counts{Requests: 10, TotalFailures: 8, ConsecutiveFailures: 2}) {
+		t.Error("Expected breaker to stay closed below consecutive failure threshold")
+	}
+	if !trip(gobreaker.Counts{Requests: 3, TotalFailures: 3, ConsecutiveFailures: 3}) {
+		t.Error("Expected breaker to trip at consecutive failure threshold")
+	}
+}
+
+func TestReadyToTrip_ErrorRate(t *testing.T) {
+	trip := ReadyToTrip(config.CircuitBreakerConfig{
+		Strategy:           "error_rate",
+		ErrorRateThreshold: 0.5,
+		MinimumRequests:    20,
+	})
+
+	if trip(gobreaker.Counts{Requests: 10, TotalFailures: 10}) {
+		t.Error("Expected breaker to stay closed below minimum requests")
+	}
+	if trip(gobreaker.Counts{Requests: 20, TotalFailures: 10}) {
+		t.Error("Expected breaker to stay closed at exactly the threshold")
+	}
+	if !trip(gobreaker.Counts{Requests: 20, TotalFailures: 11}) {
+		t.Error("Expected breaker to trip above the error rate threshold")
+	}
+}
+
+func TestCircuitBreaker_SlowCallCountsAsFailure(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	cb := NewCircuitBreaker("slow", config.CircuitBreakerConfig{
+		Enabled:           true,
+		FailureThreshold:  2,
+		RecoveryTimeout:   time.Minute,
+		HalfOpenRequests:  1,
+		SlowCallThreshold: time.Millisecond,
+	}, logger)
+
+	for i := 0; i < 2; i++ {
+		err := cb.Call(func() error {
+			time.Sleep(5 * time.Millisecond)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Expected slow call to succeed for the caller, got %v", err)
+		}
+	}
+
+	if !cb.IsOpen() {
+		t.Error("Expected breaker to open after consecutive slow calls")
+	}
+
+	err := cb.Call(func() error { return nil })
+	if !errors.Is(err, gobreaker.ErrOpenState) {
+		t.Errorf("Expected open state error, got %v", err)
+	}
+}
+
+func TestCircuitBreaker_CallHTTPCountsFailureStatuses(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	cb := NewCircuitBreaker("http", config.CircuitBreakerConfig{
+		Enabled:            true,
+		FailureThreshold:   2,
+		RecoveryTimeout:    time.Minute,
+		HalfOpenRequests:   1,
+		FailureStatusCodes: []string{"5xx", "429"},
+	}, logger)
+
+	if err := cb.CallHTTP(func() (int, error) { return 404, nil }); err != nil {
+		t.Errorf("Expected 404 to count as success, got %v", err)
+	}
+
+	var statusErr *StatusError
+	if err := cb.CallHTTP(func() (int, error) { return 429, nil }); !errors.As(err, &statusErr) {
+		t.Errorf("Expected 429 to be reported as a status error, got %v", err)
+	}
+	if err := cb.CallHTTP(func() (int, error) { return 503, nil }); !errors.As(err, &statusErr) {
+		t.Errorf("Expected 503 to be reported as a status error, got %v", err)
+	}
+
+	if !cb.IsOpen() {
+		t.Error("Expected breaker to open after consecutive failure statuses")
+	}
+	if err := cb.CallHTTP(func() (int, error) { return 200, nil }); !IsRejected(err) {
+		t.Errorf("Expected open breaker to reject the call, got %v", err)
+	}
+}
+
+// memoryStore is a breaker state store kept in memory
+type memoryStore struct {
+	mu    sync.Mutex
+	state map[string]time.Time
+}
+
+func (s *memoryStore) Load(ctx context.Context, name string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state[name], nil
+}
+
+func (s *memoryStore) Save(ctx context.Context, name string, openUntil time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state[name] = openUntil
+	return nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.state, name)
+	return nil
+}
+
+func TestManager_PersistsOpenBreakers(t *testing.T) {
+	cfg := config.CircuitBreakerConfig{Enabled: true, FailureThreshold: 1, RecoveryTimeout: time.Minute, HalfOpenRequests: 1}
+	store := &memoryStore{state: map[string]time.Time{}}
+
+	first := NewManager(zap.NewNop(), nil)
+	first.SetStore(store)
+	first.CreateBreaker("orders", cfg).Call(func() error { return errors.New("down") })
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if openUntil, _ := store.Load(context.Background(), "orders"); !openUntil.IsZero() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("open breaker was not persisted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// A restarted gateway keeps the breaker open
+	restarted := NewManager(zap.NewNop(), nil)
+	restarted.SetStore(store)
+	breaker := restarted.CreateBreaker("orders", cfg)
+	if !breaker.IsOpen() {
+		t.Fatal("restored breaker is not open")
+	}
+	called := false
+	if err := breaker.Call(func() error { called = true; return nil }); !IsRejected(err) || called {
+		t.Errorf("Call() on a restored open breaker = %v, called = %v; want rejected", err, called)
+	}
+
+	if err := restarted.ResetBreaker("orders"); err != nil {
+		t.Fatalf("ResetBreaker() error = %v", err)
+	}
+	if breaker.IsOpen() {
+		t.Error("breaker still open after reset")
+	}
+	if openUntil, _ := store.Load(context.Background(), "orders"); !openUntil.IsZero() {
+		t.Error("reset did not drop the stored state")
+	}
+}
+
+// memoryFleet is a fleet store kept in memory
+type memoryFleet struct {
+	memoryStore
+	counts map[string]gobreaker.Counts
+}
+
+func (s *memoryFleet) Add(ctx context.Context, name string, window time.Duration, delta Delta) (gobreaker.Counts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := s.counts[name]
+	counts.Requests += uint32(delta.Requests)
+	counts.TotalFailures += uint32(delta.Failures)
+	if delta.Succeeded {
+		counts.ConsecutiveFailures = 0
+	}
+	counts.ConsecutiveFailures += uint32(delta.Trailing)
+	s.counts[name] = counts
+	return counts, nil
+}
+
+func (s *memoryFleet) Save(ctx context.Context, name string, openUntil time.Time) error {
+	s.mu.Lock()
+	delete(s.counts, name)
+	s.mu.Unlock()
+	return s.memoryStore.Save(ctx, name, openUntil)
+}
+
+func TestManager_FleetTripsTogether(t *testing.T) {
+	cfg := config.CircuitBreakerConfig{Enabled: true, FailureThreshold: 3, RecoveryTimeout: time.Minute, HalfOpenRequests: 1}
+	store := &memoryFleet{memoryStore: memoryStore{state: map[string]time.Time{}}, counts: map[string]gobreaker.Counts{}}
+
+	replicas := make([]*Manager, 2)
+	breakers := make([]*CircuitBreaker, 2)
+	for i := range replicas {
+		replicas[i] = NewManager(zap.NewNop(), nil)
+		replicas[i].ShareState(store)
+		breakers[i] = replicas[i].CreateBreaker("orders", cfg)
+	}
+
+	// Each replica sees two failures, below the threshold on its own
+	for _, breaker := range breakers {
+		for i := 0; i < 2; i++ {
+			breaker.Call(func() error { return errors.New("down") })
+		}
+		if breaker.IsOpen() {
+			t.Fatal("breaker tripped on local counts below the threshold")
+		}
+	}
+
+	replicas[0].syncFleet()
+	if breakers[0].IsOpen() {
+		t.Fatal("fleet tripped before its counts reached the threshold")
+	}
+	replicas[1].syncFleet()
+	replicas[0].syncFleet()
+	for i, breaker := range breakers {
+		if !breaker.IsOpen() {
+			t.Errorf("replica %d breaker is not open after the fleet tripped", i)
+		}
+	}
+}
+
+// stateChange is a state change seen by a listener
+type stateChange struct {
+	from, to    gobreaker.State
+	lastFailure string
+}
+
+func TestManager_StateChangeEventsAndMetrics(t *testing.T) {
+	logger := zap.NewNop()
+	metricsManager := metrics.NewManager(logger)
+	m := NewManager(logger, metricsManager)
+
+	var mu sync.Mutex
+	var changes []stateChange
+	m.OnStateChange(func(name string, from gobreaker.State, to gobreaker.State, lastFailure string) {
+		if name != "orders" {
+			t.Errorf("state change of %q, want orders", name)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		changes = append(changes, stateChange{from, to, lastFailure})
+	})
+	seen := func() []stateChange {
+		mu.Lock()
+		defer mu.Unlock()
+		seen := changes
+		changes = nil
+		return seen
+	}
+	gauge := func() string {
+		w := httptest.NewRecorder()
+		metricsManager.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		for _, line := range strings.Split(w.Body.String(), "\n") {
+			if strings.HasPrefix(line, `gateway_circuit_breaker_state{name="orders"}`) {
+				return strings.Fields(line)[1]
+			}
+		}
+		return ""
+	}
+
+	cb := m.CreateBreaker("orders", config.CircuitBreakerConfig{
+		Enabled:          true,
+		FailureThreshold: 2,
+		RecoveryTimeout:  50 * time.Millisecond,
+		HalfOpenRequests: 1,
+	})
+	if got := gauge(); got != "0" {
+		t.Errorf("gauge of a new breaker = %s, want 0", got)
+	}
+
+	refused := &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}
+	cb.CallHTTP(func() (int, error) { return 503, nil })
+	if err := cb.CallHTTP(func() (int, error) { return 0, refused }); !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("failed call error = %v, want the upstream error", err)
+	}
+	if got := seen(); len(got) != 1 || got[0] != (stateChange{gobreaker.StateClosed, gobreaker.StateOpen, "connection_refused"}) {
+		t.Errorf("state changes = %+v, want closed to open after connection_refused", got)
+	}
+	if got := gauge(); got != "2" {
+		t.Errorf("gauge of an open breaker = %s, want 2", got)
+	}
+	if err := cb.CallHTTP(func() (int, error) { return 200, nil }); !IsRejected(err) {
+		t.Errorf("call on an open breaker error = %v, want it rejected", err)
+	}
+
+	// A failed probe opens the breaker again
+	time.Sleep(60 * time.Millisecond)
+	cb.CallHTTP(func() (int, error) { return 502, nil })
+	want := []stateChange{
+		{gobreaker.StateOpen, gobreaker.StateHalfOpen, "connection_refused"},
+		{gobreaker.StateHalfOpen, gobreaker.StateOpen, "status_502"},
+	}
+	if got := seen(); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("state changes = %+v, want %+v", got, want)
+	}
+
+	// A successful probe closes it
+	time.Sleep(60 * time.Millisecond)
+	if err := cb.CallHTTP(func() (int, error) { return 200, nil }); err != nil {
+		t.Fatalf("probe error = %v", err)
+	}
+	if got := seen(); len(got) != 2 || got[1].to != gobreaker.StateClosed {
+		t.Errorf("state changes = %+v, want half-open and then closed", got)
+	}
+	if got := gauge(); got != "0" {
+		t.Errorf("gauge of a recovered breaker = %s, want 0", got)
+	}
+}
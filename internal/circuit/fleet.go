@@ -0,0 +1,237 @@
+package circuit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sony/gobreaker"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// defaultFleetWindow is the counting window and open period of breakers
+// without an interval or recovery timeout, as gobreaker's default timeout
+const defaultFleetWindow = time.Minute
+
+// FleetStore aggregates breaker counts across the gateway replicas
+type FleetStore interface {
+	Store
+	// Add adds a replica's calls to a breaker's counts and returns the
+	// fleet's counts. Counts are reset when window has passed since the
+	// first call, and when the breaker opens.
+	Add(ctx context.Context, name string, window time.Duration, delta Delta) (gobreaker.Counts, error)
+}
+
+// Delta is the calls a replica made through a breaker since its last sync
+type Delta struct {
+	Requests  int64
+	Failures  int64
+	Trailing  int64 // Failures since the last success
+	Succeeded bool  // Whether any call succeeded
+}
+
+// fleet is the shared-mode state of a breaker
+type fleet struct {
+	trip            func(counts gobreaker.Counts) bool
+	window          time.Duration
+	recoveryTimeout time.Duration
+
+	mu    sync.Mutex
+	delta Delta
+	held  bool // The breaker was reported held open by the fleet
+}
+
+// newFleet creates the shared-mode state of a breaker
+func newFleet(cfg config.CircuitBreakerConfig) *fleet {
+	window := cfg.Interval
+	if window <= 0 {
+		window = cfg.RecoveryTimeout
+	}
+	if window <= 0 {
+		window = defaultFleetWindow
+	}
+	recoveryTimeout := cfg.RecoveryTimeout
+	if recoveryTimeout <= 0 {
+		recoveryTimeout = defaultFleetWindow
+	}
+	return &fleet{trip: ReadyToTrip(cfg), window: window, recoveryTimeout: recoveryTimeout}
+}
+
+// record counts a call made through the breaker
+func (f *fleet) record(success bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.delta.Requests++
+	if success {
+		f.delta.Succeeded = true
+		f.delta.Trailing = 0
+		return
+	}
+	f.delta.Failures++
+	f.delta.Trailing++
+}
+
+// take returns and resets the calls counted since the last sync
+func (f *fleet) take() Delta {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delta := f.delta
+	f.delta = Delta{}
+	return delta
+}
+
+// fleetScript adds a replica's counts to the fleet's. The counts expire a
+// window after the first call. The fleet's consecutive failures restart
+// from the replica's trailing failures when any of its calls succeeded.
+var fleetScript = redis.NewScript(`
+local fresh = redis.call("EXISTS", KEYS[1]) == 0
+redis.call("HINCRBY", KEYS[1], "requests", ARGV[1])
+redis.call("HINCRBY", KEYS[1], "failures", ARGV[2])
+if ARGV[4] == "1" then
+	redis.call("HSET", KEYS[1], "consecutive", ARGV[3])
+else
+	redis.call("HINCRBY", KEYS[1], "consecutive", ARGV[3])
+end
+if fresh then
+	redis.call("PEXPIRE", KEYS[1], ARGV[5])
+end
+return redis.call("HMGET", KEYS[1], "requests", "failures", "consecutive")
+`)
+
+// Add adds a replica's calls to a breaker's counts and returns the fleet's
+// counts
+func (s *RedisStore) Add(ctx context.Context, name string, window time.Duration, delta Delta) (gobreaker.Counts, error) {
+	succeeded := "0"
+	if delta.Succeeded {
+		succeeded = "1"
+	}
+	values, err := fleetScript.Run(ctx, s.client, []string{s.countsKey(name)},
+		delta.Requests, delta.Failures, delta.Trailing, succeeded, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return gobreaker.Counts{}, err
+	}
+	return gobreaker.Counts{
+		Requests:            uint32(values[0]),
+		TotalFailures:       uint32(values[1]),
+		TotalSuccesses:      uint32(values[0] - values[1]),
+		ConsecutiveFailures: uint32(values[2]),
+	}, nil
+}
+
+// ShareState makes the breakers created afterwards trip and recover with
+// the fleet: their counts are aggregated in store, and they are held open
+// while any replica's breaker is open
+func (m *Manager) ShareState(store FleetStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.store = store
+	m.fleetStore = store
+}
+
+// Start syncs the shared breakers with the fleet every interval
+func (m *Manager) Start(interval time.Duration) {
+	m.mu.Lock()
+	if m.fleetStore == nil || m.stop != nil {
+		m.mu.Unlock()
+		return
+	}
+	m.stop = make(chan struct{})
+	stop := m.stop
+	m.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				m.syncFleet()
+			}
+		}
+	}()
+}
+
+// Stop stops syncing with the fleet
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
+	}
+}
+
+// syncFleet pushes each shared breaker's counts and applies the fleet's
+// state. While the store is unreachable, breakers trip on their own counts.
+func (m *Manager) syncFleet() {
+	m.mu.RLock()
+	breakers := make(map[string]*CircuitBreaker, len(m.breakers))
+	for name, breaker := range m.breakers {
+		if breaker.fleet != nil {
+			breakers[name] = breaker
+		}
+	}
+	m.mu.RUnlock()
+
+	var failed error
+	for name, breaker := range breakers {
+		if err := m.syncBreaker(name, breaker); err != nil {
+			failed = err
+		}
+	}
+	if failed != nil && !m.fleetDown {
+		m.logger.Warn("Circuit breaker state store unavailable, breakers trip on local counts", zap.Error(failed))
+	} else if failed == nil && m.fleetDown {
+		m.logger.Info("Circuit breaker state store available, breakers trip with the fleet")
+	}
+	m.fleetDown = failed != nil
+}
+
+// syncBreaker pushes a breaker's counts, trips it when the fleet's counts
+// call for it, and holds it open while the fleet's breaker is open
+func (m *Manager) syncBreaker(name string, breaker *CircuitBreaker) error {
+	f := breaker.fleet
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	now := time.Now()
+	delta := f.take()
+	if delta.Requests > 0 {
+		counts, err := m.fleetStore.Add(ctx, name, f.window, delta)
+		if err != nil {
+			return err
+		}
+		if !breaker.IsOpen() && f.trip(counts) {
+			if err := m.fleetStore.Save(ctx, name, now.Add(f.recoveryTimeout)); err != nil {
+				return err
+			}
+		}
+	}
+
+	openUntil, err := m.fleetStore.Load(ctx, name)
+	if err != nil {
+		return err
+	}
+	if openUntil.After(now) && breaker.breaker.State() == gobreaker.StateClosed {
+		breaker.holdOpen(openUntil)
+	}
+
+	// Report the fleet's transitions like the breaker's own
+	held := breaker.heldOpen()
+	f.mu.Lock()
+	changed := held != f.held
+	f.held = held
+	f.mu.Unlock()
+	if changed && held {
+		m.logger.Info("Circuit breaker held open by the fleet", zap.String("name", name), zap.Time("open_until", openUntil))
+		m.notifyStateChange(name, gobreaker.StateClosed, gobreaker.StateOpen, "fleet")
+	} else if changed {
+		m.notifyStateChange(name, gobreaker.StateOpen, breaker.State(), "fleet")
+	}
+	return nil
+}
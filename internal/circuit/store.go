@@ -0,0 +1,142 @@
+package circuit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sony/gobreaker"
+	"go.uber.org/zap"
+)
+
+// storeTimeout bounds each call to the breaker state store
+const storeTimeout = 2 * time.Second
+
+// Store persists until when breakers are open, so their state outlives the
+// gateway process
+type Store interface {
+	// Load returns until when a breaker is open, or the zero time
+	Load(ctx context.Context, name string) (time.Time, error)
+	Save(ctx context.Context, name string, openUntil time.Time) error
+	Delete(ctx context.Context, name string) error
+}
+
+// RedisStore keeps breaker state in Redis, shared by the gateway replicas.
+// Keys expire when the breaker's open period ends.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore creates a breaker state store
+func NewRedisStore(client *redis.Client, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+// Load returns until when a breaker is open, or the zero time
+func (s *RedisStore) Load(ctx context.Context, name string) (time.Time, error) {
+	value, err := s.client.Get(ctx, s.key(name)).Result()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("malformed breaker state %q: %w", value, err)
+	}
+	return time.UnixMilli(ms), nil
+}
+
+// Save records that a breaker is open until openUntil. The breaker's
+// shared counts start over, as those of a breaker that opens.
+func (s *RedisStore) Save(ctx context.Context, name string, openUntil time.Time) error {
+	remaining := time.Until(openUntil)
+	if remaining <= 0 {
+		return nil
+	}
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, s.key(name), openUntil.UnixMilli(), remaining)
+	pipe.Del(ctx, s.countsKey(name))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Delete forgets a breaker's state
+func (s *RedisStore) Delete(ctx context.Context, name string) error {
+	return s.client.Del(ctx, s.key(name)).Err()
+}
+
+// key returns the Redis key of a breaker's open state
+func (s *RedisStore) key(name string) string {
+	return s.prefix + ":" + name
+}
+
+// countsKey returns the Redis key of a breaker's shared counts
+func (s *RedisStore) countsKey(name string) string {
+	return s.prefix + ":" + name + ":counts"
+}
+
+// SetStore persists the open state of the breakers created afterwards in
+// store, and restores it when they are created
+func (m *Manager) SetStore(store Store) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.store = store
+}
+
+// persist records a breaker opening or closing in the store. It runs in
+// the background because state changes fire under the breaker's lock.
+func (m *Manager) persist(name string, to gobreaker.State, recoveryTimeout time.Duration) {
+	var op func(ctx context.Context) error
+	switch to {
+	case gobreaker.StateOpen:
+		openUntil := time.Now().Add(recoveryTimeout)
+		op = func(ctx context.Context) error { return m.store.Save(ctx, name, openUntil) }
+	case gobreaker.StateClosed:
+		op = func(ctx context.Context) error { return m.store.Delete(ctx, name) }
+	default:
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		if err := op(ctx); err != nil {
+			m.logger.Warn("Failed to persist circuit breaker state",
+				zap.String("name", name),
+				zap.String("state", to.String()),
+				zap.Error(err))
+		}
+	}()
+}
+
+// restore holds a new breaker open while the store says it is open. The
+// breaker resumes closed once the stored open period ends.
+func (m *Manager) restore(name string, breaker *CircuitBreaker) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	openUntil, err := m.store.Load(ctx, name)
+	if err != nil {
+		m.logger.Warn("Failed to restore circuit breaker state", zap.String("name", name), zap.Error(err))
+		return
+	}
+	remaining := time.Until(openUntil)
+	if remaining <= 0 {
+		return
+	}
+
+	breaker.holdOpen(openUntil)
+	m.logger.Info("Circuit breaker restored open",
+		zap.String("name", name),
+		zap.Time("open_until", openUntil))
+
+	time.AfterFunc(remaining, func() {
+		if m.metrics != nil && m.GetBreaker(name) == breaker {
+			m.metrics.SetCircuitBreakerState(name, stateValue(breaker.State()))
+		}
+	})
+}
@@ -0,0 +1,331 @@
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// Announcement types
+const (
+	TypeHeartbeat     = "heartbeat"
+	TypeServiceUpsert = "service_upsert"
+	TypeServiceDelete = "service_delete"
+	TypeTargetWeight  = "target_weight"
+	TypeBreakerReset  = "breaker_reset"
+	TypeConfigPush    = "config_push"
+	TypeConfigReload  = "config_reload"
+	TypeRegistration  = "registration"
+	TypeTargetHealth  = "target_health"
+)
+
+// periodicTypes are announced every few seconds, such as registration
+// heartbeats, so applying them is logged at debug level
+var periodicTypes = map[string]bool{
+	TypeRegistration: true,
+	TypeTargetHealth: true,
+}
+
+// Announcement is a runtime state change broadcast to the other replicas
+type Announcement struct {
+	Type string `json:"type"`
+	// Key identifies the piece of state the announcement sets, such as
+	// "service:orders". Keyed announcements are kept in Redis and replayed
+	// to replicas that join later; the others are transient.
+	Key       string          `json:"key,omitempty"`
+	Node      string          `json:"node"`
+	Timestamp time.Time       `json:"timestamp"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+// Handler applies an announcement from another replica
+type Handler func(a Announcement) error
+
+// Peer is a replica seen through its heartbeats
+type Peer struct {
+	Node     string    `json:"node"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Cluster broadcasts runtime state changes between gateway replicas over
+// Redis pub/sub, so admin changes on one replica reach all of them
+type Cluster struct {
+	cfg    config.ClusterConfig
+	node   string
+	client *redis.Client
+	logger *zap.Logger
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+	applied  map[string]time.Time
+	peers    map[string]time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewCluster creates a cluster member. The node ID defaults to the host name
+// with a random suffix.
+func NewCluster(cfg config.ClusterConfig, client *redis.Client, logger *zap.Logger) *Cluster {
+	node := cfg.NodeID
+	if node == "" {
+		node = defaultNodeID()
+	}
+	return &Cluster{
+		cfg:      cfg,
+		node:     node,
+		client:   client,
+		logger:   logger.With(zap.String("node", node)),
+		handlers: make(map[string]Handler),
+		applied:  make(map[string]time.Time),
+		peers:    make(map[string]time.Time),
+	}
+}
+
+// defaultNodeID returns the host name with a random suffix
+func defaultNodeID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "gateway"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return host + "-" + hex.EncodeToString(suffix)
+}
+
+// Node returns the ID of this replica
+func (c *Cluster) Node() string {
+	return c.node
+}
+
+// Handle registers the handler for an announcement type
+func (c *Cluster) Handle(announcementType string, handler Handler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers[announcementType] = handler
+}
+
+// Announce broadcasts a state change made on this replica. A non-empty key
+// stores it as the latest value of that state for replicas joining later.
+func (c *Cluster) Announce(ctx context.Context, announcementType, key string, payload interface{}) error {
+	a := Announcement{Type: announcementType, Key: key, Node: c.node, Timestamp: time.Now()}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to encode announcement: %w", err)
+		}
+		a.Payload = data
+	}
+
+	data, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("failed to encode announcement: %w", err)
+	}
+
+	pipe := c.client.TxPipeline()
+	if key != "" {
+		pipe.HSet(ctx, c.cfg.StateKey, key, data)
+		c.markApplied(key, a.Timestamp)
+	}
+	pipe.Publish(ctx, c.cfg.Channel, data)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to announce %s: %w", announcementType, err)
+	}
+	return nil
+}
+
+// leaseScript acquires a lease, or renews it when this replica holds it
+var leaseScript = redis.NewScript(`
+local owner = redis.call("GET", KEYS[1])
+if owner == false then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+if owner == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// Lead reports whether this replica leads a task, such as probing a
+// service, acquiring or renewing the task's lease for ttl. The lease passes
+// to another replica once its holder stops renewing it.
+func (c *Cluster) Lead(ctx context.Context, task string, ttl time.Duration) (bool, error) {
+	led, err := leaseScript.Run(ctx, c.client, []string{c.cfg.StateKey + ":lease:" + task}, c.node, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lease %s: %w", task, err)
+	}
+	return led == 1, nil
+}
+
+// Start subscribes to announcements, replays the stored state and starts
+// sending heartbeats
+func (c *Cluster) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+
+	// Subscribe before replaying so no change is missed in between; stale
+	// duplicates are dropped by timestamp
+	pubsub := c.client.Subscribe(ctx, c.cfg.Channel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		cancel()
+		pubsub.Close()
+		return fmt.Errorf("failed to subscribe to %s: %w", c.cfg.Channel, err)
+	}
+
+	if err := c.replay(ctx); err != nil {
+		c.logger.Warn("Failed to replay cluster state", zap.Error(err))
+	}
+
+	c.cancel = cancel
+	c.done = make(chan struct{})
+	go c.run(ctx, pubsub)
+
+	c.logger.Info("Joined gateway cluster", zap.String("channel", c.cfg.Channel))
+	return nil
+}
+
+// Close leaves the cluster
+func (c *Cluster) Close() {
+	if c.cancel == nil {
+		return
+	}
+	c.cancel()
+	<-c.done
+}
+
+// Peers returns the replicas that sent a heartbeat within three intervals
+func (c *Cluster) Peers() []Peer {
+	cutoff := time.Now().Add(-3 * c.cfg.HeartbeatInterval)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	peers := make([]Peer, 0, len(c.peers))
+	for node, lastSeen := range c.peers {
+		if lastSeen.After(cutoff) {
+			peers = append(peers, Peer{Node: node, LastSeen: lastSeen})
+		}
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Node < peers[j].Node })
+	return peers
+}
+
+// run dispatches announcements and sends heartbeats until the context ends
+func (c *Cluster) run(ctx context.Context, pubsub *redis.PubSub) {
+	defer close(c.done)
+	defer pubsub.Close()
+
+	heartbeat := time.NewTicker(c.cfg.HeartbeatInterval)
+	defer heartbeat.Stop()
+	c.sendHeartbeat(ctx)
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			c.sendHeartbeat(ctx)
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			var a Announcement
+			if err := json.Unmarshal([]byte(msg.Payload), &a); err != nil {
+				c.logger.Warn("Ignoring malformed cluster announcement", zap.Error(err))
+				continue
+			}
+			c.dispatch(a)
+		}
+	}
+}
+
+// sendHeartbeat announces that this replica is alive
+func (c *Cluster) sendHeartbeat(ctx context.Context) {
+	if err := c.Announce(ctx, TypeHeartbeat, "", nil); err != nil && ctx.Err() == nil {
+		c.logger.Warn("Failed to send cluster heartbeat", zap.Error(err))
+	}
+}
+
+// replay applies the stored state in the order it was announced
+func (c *Cluster) replay(ctx context.Context) error {
+	entries, err := c.client.HGetAll(ctx, c.cfg.StateKey).Result()
+	if err != nil {
+		return err
+	}
+
+	announcements := make([]Announcement, 0, len(entries))
+	for _, data := range entries {
+		var a Announcement
+		if err := json.Unmarshal([]byte(data), &a); err != nil {
+			continue
+		}
+		announcements = append(announcements, a)
+	}
+	sort.Slice(announcements, func(i, j int) bool {
+		return announcements[i].Timestamp.Before(announcements[j].Timestamp)
+	})
+
+	for _, a := range announcements {
+		c.dispatch(a)
+	}
+	return nil
+}
+
+// dispatch applies an announcement from another replica. Keyed
+// announcements older than the state already applied are dropped.
+func (c *Cluster) dispatch(a Announcement) {
+	if a.Node == c.node {
+		return
+	}
+
+	c.mu.Lock()
+	c.peers[a.Node] = time.Now()
+	handler := c.handlers[a.Type]
+	if a.Key != "" {
+		if last, ok := c.applied[a.Key]; ok && !a.Timestamp.After(last) {
+			c.mu.Unlock()
+			return
+		}
+		c.applied[a.Key] = a.Timestamp
+	}
+	c.mu.Unlock()
+
+	if handler == nil {
+		return
+	}
+	if err := handler(a); err != nil {
+		c.logger.Error("Failed to apply cluster announcement",
+			zap.String("type", a.Type),
+			zap.String("from", a.Node),
+			zap.Error(err))
+		return
+	}
+	log := c.logger.Info
+	if periodicTypes[a.Type] {
+		log = c.logger.Debug
+	}
+	log("Applied cluster announcement",
+		zap.String("type", a.Type),
+		zap.String("key", a.Key),
+		zap.String("from", a.Node))
+}
+
+// markApplied records that this replica set the state itself
+func (c *Cluster) markApplied(key string, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.applied[key] = at
+}
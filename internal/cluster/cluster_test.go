@@ -0,0 +1,41 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestDispatch(t *testing.T) {
+	c := NewCluster(config.ClusterConfig{NodeID: "a", HeartbeatInterval: time.Second}, nil, zap.NewNop())
+
+	var applied []string
+	c.Handle(TypeServiceUpsert, func(a Announcement) error {
+		applied = append(applied, a.Node+":"+string(a.Payload))
+		return nil
+	})
+
+	now := time.Now()
+	c.dispatch(Announcement{Type: TypeServiceUpsert, Key: "service:orders", Node: "a", Timestamp: now, Payload: []byte("own")})
+	c.dispatch(Announcement{Type: TypeServiceUpsert, Key: "service:orders", Node: "b", Timestamp: now, Payload: []byte("new")})
+	c.dispatch(Announcement{Type: TypeServiceUpsert, Key: "service:orders", Node: "c", Timestamp: now.Add(-time.Minute), Payload: []byte("stale")})
+	c.dispatch(Announcement{Type: TypeServiceUpsert, Node: "c", Timestamp: now.Add(-time.Minute), Payload: []byte("transient")})
+
+	want := []string{"b:new", "c:transient"}
+	if len(applied) != len(want) {
+		t.Fatalf("applied = %v, want %v", applied, want)
+	}
+	for i := range want {
+		if applied[i] != want[i] {
+			t.Errorf("applied[%d] = %q, want %q", i, applied[i], want[i])
+		}
+	}
+
+	peers := c.Peers()
+	if len(peers) != 2 || peers[0].Node != "b" || peers[1].Node != "c" {
+		t.Errorf("Peers() = %v, want b and c", peers)
+	}
+}
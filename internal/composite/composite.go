@@ -0,0 +1,79 @@
+package composite
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// placeholder matches a {param} in a branch path
+var placeholder = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// ExpandPath replaces the {param} placeholders of a branch path with the
+// route parameters, escaped for use in a path
+func ExpandPath(path string, param func(name string) string) string {
+	return placeholder.ReplaceAllStringFunc(path, func(match string) string {
+		return url.PathEscape(param(match[1 : len(match)-1]))
+	})
+}
+
+// Merge builds the combined payload from the decoded branch responses.
+// Without a mapping each response is placed under its branch name; values
+// missing from a response are set to null.
+func Merge(mapping []config.CompositeFieldConfig, results map[string]interface{}) map[string]interface{} {
+	if len(mapping) == 0 {
+		merged := make(map[string]interface{}, len(results))
+		for name, body := range results {
+			merged[name] = body
+		}
+		return merged
+	}
+
+	merged := make(map[string]interface{})
+	for _, field := range mapping {
+		branch, path, _ := strings.Cut(field.From, ".")
+		value := lookup(results[branch], path)
+		set(merged, field.Field, value)
+	}
+	return merged
+}
+
+// lookup follows a dotted path through objects and arrays
+func lookup(value interface{}, path string) interface{} {
+	if path == "" {
+		return value
+	}
+
+	for _, segment := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[segment]
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil
+			}
+			value = v[i]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+// set assigns a value at a dotted path, creating intermediate objects
+func set(target map[string]interface{}, path string, value interface{}) {
+	segments := strings.Split(path, ".")
+	for _, segment := range segments[:len(segments)-1] {
+		next, ok := target[segment].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			target[segment] = next
+		}
+		target = next
+	}
+	target[segments[len(segments)-1]] = value
+}
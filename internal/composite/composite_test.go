@@ -0,0 +1,49 @@
+package composite
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestExpandPath(t *testing.T) {
+	params := map[string]string{"id": "42", "name": "a b/c"}
+	got := ExpandPath("/user_service/users/{id}/files/{name}", func(name string) string {
+		return params[name]
+	})
+	if want := "/user_service/users/42/files/a%20b%2Fc"; got != want {
+		t.Errorf("ExpandPath() = %q, want %q", got, want)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	var order, customer interface{}
+	json.Unmarshal([]byte(`{"id": 7, "items": [{"sku": "A1"}, {"sku": "B2"}]}`), &order)
+	json.Unmarshal([]byte(`{"name": "Ada", "tier": "gold"}`), &customer)
+	results := map[string]interface{}{"order": order, "customer": customer, "shipping": nil}
+
+	mapping := []config.CompositeFieldConfig{
+		{Field: "id", From: "order.id"},
+		{Field: "customer.name", From: "customer.name"},
+		{Field: "first_sku", From: "order.items.0.sku"},
+		{Field: "items", From: "order.items"},
+		{Field: "eta", From: "shipping.eta"},
+		{Field: "missing", From: "order.items.5.sku"},
+	}
+
+	data, err := json.Marshal(Merge(mapping, results))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"customer":{"name":"Ada"},"eta":null,"first_sku":"A1","id":7,"items":[{"sku":"A1"},{"sku":"B2"}],"missing":null}`
+	if string(data) != want {
+		t.Errorf("Merge() = %s, want %s", data, want)
+	}
+
+	data, _ = json.Marshal(Merge(nil, results))
+	want = `{"customer":{"name":"Ada","tier":"gold"},"order":{"id":7,"items":[{"sku":"A1"},{"sku":"B2"}]},"shipping":null}`
+	if string(data) != want {
+		t.Errorf("Merge() without mapping = %s, want %s", data, want)
+	}
+}
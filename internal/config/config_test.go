@@ -0,0 +1,66 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestSave(t *testing.T) {
+	base, err := os.ReadFile("../../configs/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, base, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(zap.NewNop())
+	if err := m.Load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	updated := []byte(strings.Replace(string(base), "  port: 8080", "  port: 9000", 1))
+	if err := m.Save(updated); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != string(updated) {
+		t.Error("Save() did not replace the configuration file")
+	}
+	if data, _ := os.ReadFile(path + ".bak"); string(data) != string(base) {
+		t.Error("Save() did not keep the previous file as the backup")
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o640 {
+		t.Errorf("file mode after Save() = %v (%v), want 0640", info.Mode().Perm(), err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("directory holds %d files after Save(), want the file and its backup", len(entries))
+	}
+
+	// Saving takes effect on the next reload only
+	if m.Get().Server.Port != 8080 {
+		t.Error("Save() applied the configuration")
+	}
+	if err := m.Reload(); err != nil || m.Get().Server.Port != 9000 {
+		t.Errorf("port after Reload() = %d (%v), want 9000", m.Get().Server.Port, err)
+	}
+
+	// Invalid documents leave the file and its backup alone
+	if err := m.Save([]byte("server:\n  port: -1\n")); err == nil {
+		t.Error("Save() of an invalid configuration succeeded")
+	}
+	if err := m.Save([]byte("server: [")); err == nil {
+		t.Error("Save() of malformed YAML succeeded")
+	}
+	if data, _ := os.ReadFile(path); string(data) != string(updated) {
+		t.Error("rejected Save() changed the configuration file")
+	}
+	if data, _ := os.ReadFile(path + ".bak"); string(data) != string(base) {
+		t.Error("rejected Save() changed the backup")
+	}
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// redacted replaces the values of secret keys in a diff
+const redacted = "<redacted>"
+
+// secretKeyPattern matches the last segment of the keys whose values a
+// diff does not show, including credentials in header maps
+var secretKeyPattern = regexp.MustCompile(`(^|_|-)(secret|password|token|access_key|private_key|api_key|apikey|authorization|cookie)$`)
+
+// Change is a configuration key whose value differs between two
+// configurations. A value is empty when the key is unset on that side.
+type Change struct {
+	Key     string `json:"key"`
+	Running string `json:"running,omitempty"`
+	Other   string `json:"other,omitempty"`
+}
+
+// Diff returns the keys whose values differ between the running
+// configuration and another one, sorted by key. Keys are dotted mapstructure
+// paths such as routing.services.orders.timeout, and the values of secrets
+// are redacted.
+func Diff(running, other *Config) []Change {
+	runningValues, otherValues := map[string]string{}, map[string]string{}
+	flatten("", reflect.ValueOf(running), runningValues)
+	flatten("", reflect.ValueOf(other), otherValues)
+
+	keys := make(map[string]bool)
+	for key := range runningValues {
+		keys[key] = true
+	}
+	for key := range otherValues {
+		keys[key] = true
+	}
+
+	changes := []Change{}
+	for key := range keys {
+		if runningValues[key] == otherValues[key] {
+			continue
+		}
+		change := Change{Key: key, Running: runningValues[key], Other: otherValues[key]}
+		if secretKey(key) {
+			change.Running, change.Other = redact(change.Running), redact(change.Other)
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// flatten records the set scalar values of a configuration by key path
+func flatten(prefix string, v reflect.Value, out map[string]string) {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			flatten(prefix, v.Elem(), out)
+		}
+	case reflect.Struct:
+		if t, ok := v.Interface().(time.Time); ok {
+			if !t.IsZero() {
+				out[prefix] = t.String()
+			}
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			key, _, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+			if key == "" || key == "-" || !field.IsExported() {
+				continue
+			}
+			flatten(join(key), v.Field(i), out)
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			flatten(join(fmt.Sprint(key.Interface())), v.MapIndex(key), out)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			flatten(fmt.Sprintf("%s[%d]", prefix, i), v.Index(i), out)
+		}
+	default:
+		if !v.IsZero() {
+			out[prefix] = fmt.Sprint(v.Interface())
+		}
+	}
+}
+
+// secretKey reports whether a key holds a secret. The values of a clients
+// map are shared secrets keyed by client ID.
+func secretKey(key string) bool {
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		if strings.HasSuffix(key[:i], ".clients") {
+			return true
+		}
+		key = key[i+1:]
+	}
+	if i := strings.Index(key, "["); i >= 0 {
+		key = key[:i]
+	}
+	return secretKeyPattern.MatchString(strings.ToLower(key))
+}
+
+// redact hides a set secret value
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redacted
+}
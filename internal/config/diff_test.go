@@ -0,0 +1,64 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestDiffFileConfig(t *testing.T) {
+	base, err := os.ReadFile("../../configs/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, base, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(zap.NewNop())
+	if err := m.Load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	file, revision, err := m.FileConfig()
+	if err != nil {
+		t.Fatalf("FileConfig() error = %v", err)
+	}
+	if revision != m.Revision() {
+		t.Errorf("FileConfig() revision = %s, want the running %s", revision, m.Revision())
+	}
+	if changes := Diff(m.Get(), file); len(changes) != 0 {
+		t.Errorf("Diff() of an unchanged file = %v, want none", changes)
+	}
+
+	// Edits on disk show up without being applied
+	edited := strings.Replace(string(base), "  port: 8080", "  port: 9000", 1)
+	edited = strings.Replace(edited, "your-super-secret-jwt-key-change-this-in-production", "rotated-jwt-key", 1)
+	if err := os.WriteFile(path, []byte(edited), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	file, _, err = m.FileConfig()
+	if err != nil {
+		t.Fatalf("FileConfig() error = %v", err)
+	}
+	if m.Get().Server.Port != 8080 {
+		t.Error("FileConfig() applied the file")
+	}
+
+	want := []Change{
+		{Key: "auth.jwt.secret", Running: redacted, Other: redacted},
+		{Key: "server.port", Running: "8080", Other: "9000"},
+	}
+	changes := Diff(m.Get(), file)
+	if len(changes) != len(want) {
+		t.Fatalf("Diff() = %v, want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("Diff()[%d] = %+v, want %+v", i, changes[i], want[i])
+		}
+	}
+}
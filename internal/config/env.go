@@ -0,0 +1,168 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// defaultEnvPrefix starts the variables of the env source
+	defaultEnvPrefix = "GATEWAY_"
+	// envSeparator separates nested keys in variable names, since keys
+	// themselves contain underscores
+	envSeparator = "__"
+)
+
+// envSource builds the configuration document from environment variables,
+// such as GATEWAY_SERVER__PORT for server.port
+type envSource struct {
+	prefix  string
+	environ func() []string
+}
+
+// Fetch returns the document built from the environment
+func (s *envSource) Fetch(ctx context.Context, version string) ([]byte, string, error) {
+	data, err := EnvDocument(s.prefix, s.environ())
+	if err != nil {
+		return nil, "", err
+	}
+	next := Checksum(data)
+	if next == version {
+		return nil, version, nil
+	}
+	return data, next, nil
+}
+
+func (s *envSource) String() string {
+	return "env " + s.prefix + "*"
+}
+
+// EnvDocument builds a YAML configuration document from the variables of
+// environ starting with prefix. The rest of a name is the key path in
+// lower case, with __ between nested keys. Lists of scalars are comma
+// separated, and structs, maps and lists of structs are given as JSON,
+// e.g. GATEWAY_ROUTING__SERVICES__ORDERS='{"urls": ["http://orders"]}'.
+// Any list may be given as JSON too.
+func EnvDocument(prefix string, environ []string) ([]byte, error) {
+	// Sorted names set a JSON value before the variables nested in it
+	variables := append([]string{}, environ...)
+	sort.Strings(variables)
+
+	root := map[string]interface{}{}
+	for _, variable := range variables {
+		name, value, _ := strings.Cut(variable, "=")
+		if !strings.HasPrefix(name, prefix) || name == prefix {
+			continue
+		}
+		path := strings.Split(strings.ToLower(strings.TrimPrefix(name, prefix)), envSeparator)
+
+		t, err := envKeyType(reflect.TypeOf(Config{}), path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		decoded, err := envValue(t, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		node := root
+		for _, key := range path[:len(path)-1] {
+			child, ok := node[key].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				node[key] = child
+			}
+			node = child
+		}
+		node[path[len(path)-1]] = decoded
+	}
+	return yaml.Marshal(root)
+}
+
+// envKeyType returns the type of the configuration key at path
+func envKeyType(t reflect.Type, path []string) (reflect.Type, error) {
+	for i, segment := range path {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Struct:
+			field, ok := envField(t, segment)
+			if !ok {
+				return nil, fmt.Errorf("unknown config key %s", strings.Join(path[:i+1], "."))
+			}
+			t = field.Type
+		case reflect.Map:
+			// The segment is a map key, such as a service name
+			t = t.Elem()
+		default:
+			return nil, fmt.Errorf("config key %s has no nested keys", strings.Join(path[:i], "."))
+		}
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t, nil
+}
+
+// envField returns the struct field of a mapstructure key
+func envField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, _, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+		if tag == key {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// envValue decodes a variable's value for a key of type t. Scalars are
+// left as strings for the configuration decoder to convert.
+func envValue(t reflect.Type, value string) (interface{}, error) {
+	trimmed := strings.TrimSpace(value)
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		var decoded map[string]interface{}
+		if err := envJSON(trimmed, &decoded); err != nil {
+			return nil, fmt.Errorf("must be a JSON object: %w", err)
+		}
+		return decoded, nil
+	case reflect.Slice, reflect.Array:
+		if strings.HasPrefix(trimmed, "[") {
+			var decoded []interface{}
+			if err := envJSON(trimmed, &decoded); err != nil {
+				return nil, fmt.Errorf("invalid JSON list: %w", err)
+			}
+			return decoded, nil
+		}
+		switch t.Elem().Kind() {
+		case reflect.Struct, reflect.Map, reflect.Slice:
+			return nil, fmt.Errorf("must be a JSON list")
+		}
+		items := []interface{}{}
+		if trimmed == "" {
+			return items, nil
+		}
+		for _, item := range strings.Split(trimmed, ",") {
+			items = append(items, strings.TrimSpace(item))
+		}
+		return items, nil
+	default:
+		return value, nil
+	}
+}
+
+// envJSON decodes a JSON value, keeping numbers as written
+func envJSON(data string, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(data)))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
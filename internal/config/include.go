@@ -0,0 +1,181 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// includeDirKey names the directory of configuration fragments, relative
+// to the main configuration file. It is read before unmarshaling and is
+// not part of Config.
+const includeDirKey = "include_dir"
+
+// includeDebounce coalesces the bursts of events editors and deploy tools
+// produce when changing fragments
+const includeDebounce = 200 * time.Millisecond
+
+// fragment is a configuration file from the include directory
+type fragment struct {
+	name string
+	data []byte
+	keys []string
+}
+
+// includeDir returns the include directory named in a loaded main file, or
+// "" when it has none
+func includeDir(v *viper.Viper, configPath string) string {
+	dir := v.GetString(includeDirKey)
+	if dir == "" || filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(filepath.Dir(configPath), dir)
+}
+
+// readFragments reads the *.yaml and *.yml files of the include directory
+// in lexical order. Hidden files, such as editor swap files, are skipped.
+func readFragments(dir string) ([]fragment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read include directory: %w", err)
+	}
+
+	var fragments []fragment
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := filepath.Ext(name)
+		if entry.IsDir() || strings.HasPrefix(name, ".") || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		v := viper.New()
+		v.SetConfigType("yaml")
+		if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if v.IsSet(includeDirKey) {
+			return nil, fmt.Errorf("%s: %s is only allowed in the main config file", path, includeDirKey)
+		}
+		fragments = append(fragments, fragment{name: path, data: data, keys: v.AllKeys()})
+	}
+	sort.Slice(fragments, func(i, j int) bool { return fragments[i].name < fragments[j].name })
+	return fragments, nil
+}
+
+// mergeFragments merges fragments over the main configuration document and
+// returns the merged document. Fragments override the main file, but a key
+// set by two fragments is an error so that the result never depends on
+// file names.
+func mergeFragments(main []byte, fragments []fragment) ([]byte, error) {
+	owners := make(map[string]string)
+	for _, f := range fragments {
+		for _, key := range f.keys {
+			if owner, ok := owners[key]; ok {
+				return nil, fmt.Errorf("%s: %s is already set in %s", f.name, key, owner)
+			}
+			owners[key] = f.name
+		}
+	}
+
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewReader(main)); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	for _, f := range fragments {
+		if err := v.MergeConfig(bytes.NewReader(f.data)); err != nil {
+			return nil, fmt.Errorf("failed to merge %s: %w", f.name, err)
+		}
+	}
+
+	settings := v.AllSettings()
+	delete(settings, includeDirKey)
+	return yaml.Marshal(settings)
+}
+
+// loadFragments merges the include directory into the main configuration
+// document and validates the result. A validation error is attributed to
+// a fragment when the configuration is valid without it.
+func (m *Manager) loadFragments(main []byte, dir string) (*Config, []byte, error) {
+	fragments, err := readFragments(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	merged, err := mergeFragments(main, fragments)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config, err := m.parse(merged)
+	if err == nil {
+		return config, merged, nil
+	}
+	for i, f := range fragments {
+		others := append(fragments[:i:i], fragments[i+1:]...)
+		without, mergeErr := mergeFragments(main, others)
+		if mergeErr == nil && m.Validate(without) == nil {
+			return nil, nil, fmt.Errorf("%s: %w", f.name, err)
+		}
+	}
+	return nil, nil, err
+}
+
+// watchInclude reloads the configuration when files in the include
+// directory change
+func (m *Manager) watchInclude(dir string) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		m.logger.Error("Failed to watch include directory", zap.Error(err))
+		return
+	}
+	if err := watcher.Add(dir); err != nil {
+		m.logger.Error("Failed to watch include directory", zap.String("dir", dir), zap.Error(err))
+		watcher.Close()
+		return
+	}
+
+	go func() {
+		var pending *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				name := filepath.Base(event.Name)
+				ext := filepath.Ext(name)
+				if strings.HasPrefix(name, ".") || (ext != ".yaml" && ext != ".yml") || event.Op == fsnotify.Chmod {
+					continue
+				}
+				if pending != nil {
+					pending.Stop()
+				}
+				pending = time.AfterFunc(includeDebounce, func() {
+					m.logger.Info("Configuration fragment changed, reloading", zap.String("file", event.Name))
+					if err := m.Reload(); err != nil {
+						m.logger.Error("Failed to reload configuration", zap.Error(err))
+					}
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				m.logger.Error("Include directory watch error", zap.Error(err))
+			}
+		}
+	}()
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// writeIncludeConfig writes the example configuration with an include
+// directory holding files, and returns the main file's path
+func writeIncludeConfig(t *testing.T, files map[string]string) string {
+	t.Helper()
+	base, err := os.ReadFile("../../configs/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "conf.d"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, "conf.d", name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, append(base, "\ninclude_dir: conf.d\n"...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadIncludeDir(t *testing.T) {
+	path := writeIncludeConfig(t, map[string]string{
+		"10-billing.yaml": "routing:\n  services:\n    billing:\n      urls: [\"http://billing:8080\"]\n",
+		"20-server.yml":   "server:\n  port: 9000\n",
+		".swap.yaml":      "server:\n  port: 1\n",
+		"notes.txt":       "not: [yaml",
+	})
+	m := NewManager(zap.NewNop())
+	if err := m.Load(path); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	cfg := m.Get()
+	if cfg.Server.Port != 9000 {
+		t.Errorf("server.port = %d, want 9000 from the fragment", cfg.Server.Port)
+	}
+	if urls := cfg.Routing.Services["billing"].URLs; len(urls) != 1 || urls[0] != "http://billing:8080" {
+		t.Errorf("billing urls = %v, want the fragment's service", urls)
+	}
+	if _, ok := cfg.Routing.Services["user_service"]; !ok {
+		t.Error("services of the main file were dropped by the merge")
+	}
+
+	// The revision covers the fragments
+	revision := m.Revision()
+	if err := os.WriteFile(filepath.Join(filepath.Dir(path), "conf.d", "20-server.yml"), []byte("server:\n  port: 9001\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if m.Get().Server.Port != 9001 || m.Revision() == revision {
+		t.Errorf("after editing a fragment port = %d, revision changed = %v", m.Get().Server.Port, m.Revision() != revision)
+	}
+}
+
+func TestLoadIncludeDirErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{
+			name: "key set twice",
+			files: map[string]string{
+				"a.yaml": "server:\n  port: 9000\n",
+				"b.yaml": "server:\n  port: 9001\n",
+			},
+			want: "b.yaml: server.port is already set in",
+		},
+		{
+			name: "invalid fragment",
+			files: map[string]string{
+				"a.yaml": "routing:\n  services:\n    billing:\n      urls: [\"http://billing:8080\"]\n",
+				"b.yaml": "server:\n  port: -1\n",
+			},
+			want: "b.yaml: config validation failed: invalid server port",
+		},
+		{
+			name:  "nested include",
+			files: map[string]string{"a.yaml": "include_dir: more\n"},
+			want:  "include_dir is only allowed in the main config file",
+		},
+		{
+			name:  "malformed fragment",
+			files: map[string]string{"a.yaml": "server: [\n"},
+			want:  "a.yaml",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewManager(zap.NewNop()).Load(writeIncludeConfig(t, tt.files))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
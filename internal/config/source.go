@@ -0,0 +1,401 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Configuration source types
+const (
+	SourceFile   = "file"
+	SourceEtcd   = "etcd"
+	SourceConsul = "consul"
+	// SourceS3 reads an object from S3 or an S3-compatible store, such as
+	// GCS through its XML API with HMAC keys
+	SourceS3 = "s3"
+	// SourceEnv builds the configuration from environment variables, for
+	// platforms where mounting a file is awkward
+	SourceEnv = "env"
+)
+
+const (
+	defaultSourcePollInterval = 30 * time.Second
+	sourceFetchTimeout        = 10 * time.Second
+	// maxSourceDocument bounds the size of a fetched configuration document
+	maxSourceDocument = 4 << 20
+)
+
+// SourceConfig selects where the configuration is loaded from. It is read
+// from the environment because it is needed before any configuration is
+// loaded.
+type SourceConfig struct {
+	Type         string
+	Endpoint     string // etcd or Consul address, or S3 endpoint
+	Key          string // etcd or Consul key, bucket/object for S3, or the env variable prefix
+	Token        string // etcd auth token or Consul ACL token
+	Region       string // S3 signing region
+	PollInterval time.Duration
+}
+
+// SourceFromEnv reads the configuration source from CONFIG_SOURCE and the
+// CONFIG_SOURCE_* variables. S3 credentials come from the standard AWS_*
+// variables; without them requests are unsigned.
+func SourceFromEnv() (SourceConfig, error) {
+	cfg := SourceConfig{
+		Type:         os.Getenv("CONFIG_SOURCE"),
+		Endpoint:     os.Getenv("CONFIG_SOURCE_ENDPOINT"),
+		Key:          os.Getenv("CONFIG_SOURCE_KEY"),
+		Token:        os.Getenv("CONFIG_SOURCE_TOKEN"),
+		Region:       os.Getenv("AWS_REGION"),
+		PollInterval: defaultSourcePollInterval,
+	}
+	if cfg.Type == "" {
+		cfg.Type = SourceFile
+	}
+	if interval := os.Getenv("CONFIG_SOURCE_POLL_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid CONFIG_SOURCE_POLL_INTERVAL: %s", interval)
+		}
+		cfg.PollInterval = d
+	}
+
+	switch cfg.Type {
+	case SourceFile:
+		return cfg, nil
+	case SourceEnv:
+		if cfg.Key == "" {
+			cfg.Key = defaultEnvPrefix
+		}
+		return cfg, nil
+	case SourceEtcd, SourceConsul:
+		if cfg.Endpoint == "" {
+			return cfg, fmt.Errorf("CONFIG_SOURCE_ENDPOINT is required for %s", cfg.Type)
+		}
+	case SourceS3:
+		if cfg.Region == "" {
+			cfg.Region = "us-east-1"
+		}
+		if cfg.Endpoint == "" {
+			cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+		}
+		if !strings.Contains(strings.Trim(cfg.Key, "/"), "/") {
+			return cfg, fmt.Errorf("CONFIG_SOURCE_KEY must be bucket/object for s3")
+		}
+	default:
+		return cfg, fmt.Errorf("unsupported CONFIG_SOURCE: %s (supported: file, env, etcd, consul, s3)", cfg.Type)
+	}
+	if cfg.Key == "" {
+		return cfg, fmt.Errorf("CONFIG_SOURCE_KEY is required for %s", cfg.Type)
+	}
+	if _, err := url.Parse(cfg.Endpoint); err != nil {
+		return cfg, fmt.Errorf("invalid CONFIG_SOURCE_ENDPOINT: %w", err)
+	}
+	return cfg, nil
+}
+
+// Source is a remote store holding the configuration document
+type Source interface {
+	// Fetch returns the document and its version. When the version is
+	// unchanged from the given one, the document is nil.
+	Fetch(ctx context.Context, version string) ([]byte, string, error)
+	String() string
+}
+
+// NewSource creates the source of a remote configuration
+func NewSource(cfg SourceConfig, client *http.Client) (Source, error) {
+	if client == nil {
+		client = &http.Client{Timeout: sourceFetchTimeout}
+	}
+	endpoint := strings.TrimSuffix(cfg.Endpoint, "/")
+
+	switch cfg.Type {
+	case SourceEnv:
+		prefix := cfg.Key
+		if prefix == "" {
+			prefix = defaultEnvPrefix
+		}
+		return &envSource{prefix: prefix, environ: os.Environ}, nil
+	case SourceEtcd:
+		return &etcdSource{client: client, endpoint: endpoint, key: cfg.Key, token: cfg.Token}, nil
+	case SourceConsul:
+		return &consulSource{client: client, endpoint: endpoint, key: strings.TrimPrefix(cfg.Key, "/"), token: cfg.Token}, nil
+	case SourceS3:
+		return &s3Source{
+			client:   client,
+			endpoint: endpoint,
+			object:   strings.Trim(cfg.Key, "/"),
+			region:   cfg.Region,
+			credentials: s3Credentials{
+				accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+				secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+				sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+			},
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported config source: %s", cfg.Type)
+}
+
+// pollSource reloads the configuration from the remote source every
+// interval. Failures keep the current configuration.
+func (m *Manager) pollSource(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := m.Reload(); err != nil {
+			m.logger.Error("Failed to reload configuration", zap.Error(err))
+		}
+	}
+}
+
+// etcdSource reads a key through the etcd v3 JSON gateway, versioned by
+// the key's mod_revision
+type etcdSource struct {
+	client   *http.Client
+	endpoint string
+	key      string
+	token    string
+}
+
+func (s *etcdSource) String() string { return "etcd " + s.endpoint + " " + s.key }
+
+func (s *etcdSource) Fetch(ctx context.Context, version string) ([]byte, string, error) {
+	body, err := json.Marshal(struct {
+		Key []byte `json:"key"`
+	}{Key: []byte(s.key)})
+	if err != nil {
+		return nil, "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint+"/v3/kv/range", bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("etcd request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("etcd returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Kvs []struct {
+			Value       []byte `json:"value"`
+			ModRevision string `json:"mod_revision"`
+		} `json:"kvs"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxSourceDocument*2)).Decode(&result); err != nil {
+		return nil, "", fmt.Errorf("invalid etcd response: %w", err)
+	}
+	if len(result.Kvs) == 0 {
+		return nil, "", fmt.Errorf("etcd key %s not found", s.key)
+	}
+	kv := result.Kvs[0]
+	if kv.ModRevision == version {
+		return nil, version, nil
+	}
+	return kv.Value, kv.ModRevision, nil
+}
+
+// consulSource reads a key from the Consul KV store, versioned by its
+// X-Consul-Index
+type consulSource struct {
+	client   *http.Client
+	endpoint string
+	key      string
+	token    string
+}
+
+func (s *consulSource) String() string { return "consul " + s.endpoint + " " + s.key }
+
+func (s *consulSource) Fetch(ctx context.Context, version string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.endpoint+"/v1/kv/"+s.key+"?raw", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if s.token != "" {
+		req.Header.Set("X-Consul-Token", s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("consul request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, "", fmt.Errorf("consul key %s not found", s.key)
+	default:
+		return nil, "", fmt.Errorf("consul returned status %d", resp.StatusCode)
+	}
+
+	index := resp.Header.Get("X-Consul-Index")
+	if index != "" && index == version {
+		return nil, version, nil
+	}
+	data, err := readSourceDocument(resp.Body)
+	return data, index, err
+}
+
+// s3Source reads an object from S3, versioned by its ETag
+type s3Source struct {
+	client      *http.Client
+	endpoint    string
+	object      string // bucket/key
+	region      string
+	credentials s3Credentials
+}
+
+// s3Credentials are AWS credentials; without an access key requests are
+// sent unsigned
+type s3Credentials struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+func (s *s3Source) String() string { return "s3 " + s.endpoint + "/" + s.object }
+
+func (s *s3Source) Fetch(ctx context.Context, version string) ([]byte, string, error) {
+	endpoint, err := url.Parse(s.endpoint)
+	if err != nil {
+		return nil, "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.endpoint, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	// Path-style addressing, with the path encoded exactly as signed
+	path := strings.TrimSuffix(endpoint.EscapedPath(), "/") + "/" + awsURIEncode(s.object)
+	req.URL.Path = strings.TrimSuffix(endpoint.Path, "/") + "/" + s.object
+	req.URL.RawPath = path
+	if version != "" {
+		req.Header.Set("If-None-Match", version)
+	}
+	if s.credentials.accessKey != "" {
+		signS3Request(req, path, s.region, s.credentials, time.Now())
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("s3 request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, version, nil
+	case http.StatusNotFound:
+		return nil, "", fmt.Errorf("s3 object %s not found", s.object)
+	default:
+		return nil, "", fmt.Errorf("s3 returned status %d", resp.StatusCode)
+	}
+
+	data, err := readSourceDocument(resp.Body)
+	return data, resp.Header.Get("ETag"), err
+}
+
+// emptyPayloadHash is the SHA-256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// signS3Request signs a bodiless request with AWS Signature Version 4,
+// covering the host and every header already set on the request
+func signS3Request(req *http.Request, path, region string, creds s3Credentials, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, emptyPayloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + creds.secretKey)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsURIEncode percent-encodes an object path the way AWS signatures
+// expect: everything but unreserved characters and slashes
+func awsURIEncode(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// readSourceDocument reads a fetched document, bounded in size
+func readSourceDocument(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSourceDocument+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config document: %w", err)
+	}
+	if len(data) > maxSourceDocument {
+		return nil, fmt.Errorf("config document exceeds %d bytes", maxSourceDocument)
+	}
+	return data, nil
+}
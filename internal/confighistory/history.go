@@ -0,0 +1,213 @@
+package confighistory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// ErrNotFound is returned for revisions that are not in the history
+var ErrNotFound = errors.New("config revision not found")
+
+// Revision describes one accepted configuration document
+type Revision struct {
+	Version   int64     `json:"version"`
+	Author    string    `json:"author"`
+	Timestamp time.Time `json:"timestamp"`
+	Checksum  string    `json:"checksum"`
+	Message   string    `json:"message,omitempty"`
+	// Diff is a line diff against the previous revision
+	Diff string `json:"diff"`
+}
+
+// Store keeps every accepted configuration revision on disk. Each revision
+// is written as <version>.yaml with its metadata in <version>.json.
+type Store struct {
+	dir       string
+	revisions []Revision
+	mu        sync.RWMutex
+}
+
+// NewStore opens the history in dir, creating the directory if needed
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create config history directory: %w", err)
+	}
+
+	metas, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list config history: %w", err)
+	}
+
+	s := &Store{dir: dir}
+	for _, path := range metas {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config revision: %w", err)
+		}
+		var revision Revision
+		if err := json.Unmarshal(data, &revision); err != nil {
+			return nil, fmt.Errorf("invalid config revision %s: %w", path, err)
+		}
+		s.revisions = append(s.revisions, revision)
+	}
+	sort.Slice(s.revisions, func(i, j int) bool {
+		return s.revisions[i].Version < s.revisions[j].Version
+	})
+	return s, nil
+}
+
+// Record stores a configuration document as a new revision. A document
+// identical to the latest revision is not recorded again; the latest
+// revision is returned with recorded set to false.
+func (s *Store) Record(data []byte, author, message string) (revision Revision, recorded bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	checksum := config.Checksum(data)
+	var previous []byte
+	if n := len(s.revisions); n > 0 {
+		latest := s.revisions[n-1]
+		if latest.Checksum == checksum {
+			return latest, false, nil
+		}
+		if previous, err = os.ReadFile(s.path(latest.Version, ".yaml")); err != nil {
+			return Revision{}, false, fmt.Errorf("failed to read config revision %d: %w", latest.Version, err)
+		}
+	}
+
+	revision = Revision{
+		Version:   1,
+		Author:    author,
+		Timestamp: time.Now().UTC(),
+		Checksum:  checksum,
+		Message:   message,
+		Diff:      Diff(string(previous), string(data)),
+	}
+	if n := len(s.revisions); n > 0 {
+		revision.Version = s.revisions[n-1].Version + 1
+	}
+
+	meta, err := json.MarshalIndent(revision, "", "  ")
+	if err != nil {
+		return Revision{}, false, err
+	}
+	// The document goes first so a listed revision always has its content
+	if err := os.WriteFile(s.path(revision.Version, ".yaml"), data, 0o644); err != nil {
+		return Revision{}, false, fmt.Errorf("failed to write config revision: %w", err)
+	}
+	if err := os.WriteFile(s.path(revision.Version, ".json"), meta, 0o644); err != nil {
+		return Revision{}, false, fmt.Errorf("failed to write config revision: %w", err)
+	}
+
+	s.revisions = append(s.revisions, revision)
+	return revision, true, nil
+}
+
+// List returns the revisions, newest first
+func (s *Store) List() []Revision {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	revisions := make([]Revision, len(s.revisions))
+	for i, revision := range s.revisions {
+		revisions[len(revisions)-1-i] = revision
+	}
+	return revisions
+}
+
+// Get returns a revision and its configuration document
+func (s *Store) Get(version int64) (Revision, []byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := sort.Search(len(s.revisions), func(i int) bool {
+		return s.revisions[i].Version >= version
+	})
+	if i == len(s.revisions) || s.revisions[i].Version != version {
+		return Revision{}, nil, ErrNotFound
+	}
+
+	data, err := os.ReadFile(s.path(version, ".yaml"))
+	if err != nil {
+		return Revision{}, nil, fmt.Errorf("failed to read config revision %d: %w", version, err)
+	}
+	return s.revisions[i], data, nil
+}
+
+// path returns the file of a revision, zero-padded so the directory lists
+// in order
+func (s *Store) path(version int64, ext string) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%06d%s", version, ext))
+}
+
+// maxDiffCells bounds the size of the diff table; larger documents are
+// shown as fully replaced
+const maxDiffCells = 4 << 20
+
+// Diff returns a line diff from a to b, with removed lines prefixed by
+// "-", added lines by "+" and unchanged lines omitted
+func Diff(a, b string) string {
+	if a == b {
+		return ""
+	}
+	before, after := splitLines(a), splitLines(b)
+
+	var out strings.Builder
+	if len(before)*len(after) > maxDiffCells {
+		for _, line := range before {
+			out.WriteString("-" + line + "\n")
+		}
+		for _, line := range after {
+			out.WriteString("+" + line + "\n")
+		}
+		return out.String()
+	}
+
+	// lcs[i][j] is the longest common subsequence of before[i:] and after[j:]
+	lcs := make([][]int, len(before)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(after)+1)
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(before) || j < len(after) {
+		switch {
+		case i < len(before) && j < len(after) && before[i] == after[j]:
+			i++
+			j++
+		case i < len(before) && (j == len(after) || lcs[i+1][j] >= lcs[i][j+1]):
+			out.WriteString("-" + before[i] + "\n")
+			i++
+		default:
+			out.WriteString("+" + after[j] + "\n")
+			j++
+		}
+	}
+	return out.String()
+}
+
+// splitLines splits a document into lines without their terminators
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,41 @@
+package confighistory
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, recorded, err := store.Record([]byte("server:\n  port: 8080\n"), "alice", "")
+	if err != nil || !recorded || first.Version != 1 {
+		t.Fatalf("first revision %+v, recorded %v, err %v", first, recorded, err)
+	}
+	if _, recorded, _ := store.Record([]byte("server:\n  port: 8080\n"), "bob", ""); recorded {
+		t.Error("unchanged document recorded again")
+	}
+	second, _, err := store.Record([]byte("server:\n  port: 9090\n"), "bob", "")
+	if err != nil || second.Version != 2 || second.Diff != "-  port: 8080\n+  port: 9090\n" {
+		t.Fatalf("second revision %+v, err %v", second, err)
+	}
+
+	// The history survives a restart
+	store, err = NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revisions := store.List(); len(revisions) != 2 || revisions[0].Version != 2 || revisions[1].Author != "alice" {
+		t.Errorf("revisions = %+v, want newest first", revisions)
+	}
+	if _, data, err := store.Get(1); err != nil || string(data) != "server:\n  port: 8080\n" {
+		t.Errorf("Get(1) = %q, %v", data, err)
+	}
+	if _, _, err := store.Get(3); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(3) err = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,201 @@
+package cors
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// Response headers set by a policy. Upstream responses to requests the
+// gateway answers CORS for have these removed.
+var responseHeaders = []string{
+	"Access-Control-Allow-Origin",
+	"Access-Control-Allow-Credentials",
+	"Access-Control-Allow-Methods",
+	"Access-Control-Allow-Headers",
+	"Access-Control-Expose-Headers",
+	"Access-Control-Max-Age",
+}
+
+// Policy is a compiled CORS policy
+type Policy struct {
+	origins     map[string]bool
+	patterns    []*regexp.Regexp
+	anyOrigin   bool
+	methods     string
+	headers     string
+	anyHeader   bool
+	exposed     string
+	credentials bool
+	maxAge      string
+}
+
+// New compiles a CORS policy. Disabled policies yield nil, which allows no
+// cross-origin requests.
+func New(cfg config.CORSConfig) *Policy {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	p := &Policy{
+		origins:     make(map[string]bool, len(cfg.AllowedOrigins)),
+		methods:     strings.Join(cfg.AllowedMethods, ", "),
+		exposed:     strings.Join(cfg.ExposedHeaders, ", "),
+		credentials: cfg.AllowCredentials,
+	}
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			p.anyOrigin = true
+		}
+		// Invalid patterns are rejected by config validation
+		if pattern, err := config.OriginPattern(origin); pattern != nil {
+			p.patterns = append(p.patterns, pattern)
+		} else if err == nil {
+			p.origins[origin] = true
+		}
+	}
+	for _, header := range cfg.AllowedHeaders {
+		if header == "*" {
+			p.anyHeader = true
+		}
+	}
+	if !p.anyHeader {
+		p.headers = strings.Join(cfg.AllowedHeaders, ", ")
+	}
+	if cfg.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	}
+	return p
+}
+
+// IsPreflight reports whether r is a CORS preflight request
+func IsPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// Apply writes the CORS response headers for r. Requests without an Origin
+// or from an origin the policy does not allow get none, which makes the
+// browser reject the response.
+func (p *Policy) Apply(h http.Header, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if p == nil || origin == "" {
+		return
+	}
+	h.Add("Vary", "Origin")
+	if !p.allows(origin) {
+		return
+	}
+
+	// Browsers ignore a wildcard origin on credentialed requests, so the
+	// origin is echoed instead
+	if p.anyOrigin && !p.credentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !IsPreflight(r) {
+		if p.exposed != "" {
+			h.Set("Access-Control-Expose-Headers", p.exposed)
+		}
+		return
+	}
+
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	if p.methods != "" {
+		h.Set("Access-Control-Allow-Methods", p.methods)
+	} else {
+		h.Set("Access-Control-Allow-Methods", r.Header.Get("Access-Control-Request-Method"))
+	}
+	// A wildcard is literal on credentialed requests, so the requested
+	// headers are echoed instead
+	headers := p.headers
+	if p.anyHeader {
+		headers = r.Header.Get("Access-Control-Request-Headers")
+	}
+	if headers != "" {
+		h.Set("Access-Control-Allow-Headers", headers)
+	}
+	if p.maxAge != "" {
+		h.Set("Access-Control-Max-Age", p.maxAge)
+	}
+}
+
+// allows reports whether the policy allows an origin
+func (p *Policy) allows(origin string) bool {
+	if p.anyOrigin || p.origins[origin] {
+		return true
+	}
+	for _, pattern := range p.patterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// route is a compiled route policy
+type route struct {
+	pathPrefix string
+	policy     *Policy
+}
+
+// Resolver picks the CORS policy of a request path: the first route policy
+// matching it, or the default policy
+type Resolver struct {
+	policy *Policy
+	routes []route
+}
+
+// NewResolver compiles a CORS configuration and its route policies. A
+// disabled configuration resolves every path to nil.
+func NewResolver(cfg config.CORSConfig) *Resolver {
+	r := &Resolver{policy: New(cfg)}
+	if !cfg.Enabled {
+		return r
+	}
+	for _, rc := range cfg.Routes {
+		r.routes = append(r.routes, route{pathPrefix: rc.PathPrefix, policy: New(rc.Policy())})
+	}
+	return r
+}
+
+// Policy returns the policy of a request path
+func (r *Resolver) Policy(path string) *Policy {
+	for _, route := range r.routes {
+		if strings.HasPrefix(path, route.pathPrefix) {
+			return route.policy
+		}
+	}
+	return r.policy
+}
+
+type handledKey struct{}
+
+// WithHandled marks a request whose CORS headers are set by the gateway
+func WithHandled(ctx context.Context) context.Context {
+	return context.WithValue(ctx, handledKey{}, true)
+}
+
+// Handled reports whether the gateway sets the CORS headers of a request,
+// so upstream CORS headers must be dropped
+func Handled(ctx context.Context) bool {
+	handled, _ := ctx.Value(handledKey{}).(bool)
+	return handled
+}
+
+// StripHeaders removes the CORS headers from an upstream response
+func StripHeaders(h http.Header) {
+	for _, name := range responseHeaders {
+		h.Del(name)
+	}
+}
@@ -0,0 +1,115 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestPolicyApply(t *testing.T) {
+	resolver := NewResolver(config.CORSConfig{
+		Enabled:        true,
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET"},
+		AllowedHeaders: []string{"*"},
+		Routes: []config.RouteCORSConfig{{
+			PathPrefix:       "/billing",
+			AllowedOrigins:   []string{"https://billing.example.com"},
+			AllowedMethods:   []string{"GET", "POST"},
+			AllowedHeaders:   []string{"Content-Type", "Authorization"},
+			ExposedHeaders:   []string{"X-Invoice-ID"},
+			AllowCredentials: true,
+			MaxAge:           10 * time.Minute,
+		}},
+	})
+
+	preflight := func(path, origin string) http.Header {
+		r := httptest.NewRequest(http.MethodOptions, path, nil)
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Method", "POST")
+		r.Header.Set("Access-Control-Request-Headers", "X-Trace")
+		if !IsPreflight(r) {
+			t.Fatal("request not detected as preflight")
+		}
+		h := http.Header{}
+		resolver.Policy(path).Apply(h, r)
+		return h
+	}
+
+	h := preflight("/catalog/items", "https://shop.example.com")
+	if got := h.Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("default origin = %q, want *", got)
+	}
+	if got := h.Get("Access-Control-Allow-Headers"); got != "X-Trace" {
+		t.Errorf("wildcard headers = %q, want the requested headers", got)
+	}
+	if h.Get("Access-Control-Allow-Credentials") != "" {
+		t.Error("credentials allowed by the default policy")
+	}
+
+	h = preflight("/billing/invoices", "https://billing.example.com")
+	if got := h.Get("Access-Control-Allow-Origin"); got != "https://billing.example.com" {
+		t.Errorf("route origin = %q", got)
+	}
+	if got := h.Get("Access-Control-Allow-Methods"); got != "GET, POST" {
+		t.Errorf("route methods = %q", got)
+	}
+	if h.Get("Access-Control-Allow-Credentials") != "true" || h.Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("route credentials and max age not set: %v", h)
+	}
+
+	if h = preflight("/billing/invoices", "https://shop.example.com"); h.Get("Access-Control-Allow-Origin") != "" {
+		t.Error("origin allowed outside the route policy")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/billing/invoices", nil)
+	r.Header.Set("Origin", "https://billing.example.com")
+	h = http.Header{}
+	resolver.Policy(r.URL.Path).Apply(h, r)
+	if h.Get("Access-Control-Expose-Headers") != "X-Invoice-ID" || h.Get("Access-Control-Allow-Methods") != "" {
+		t.Errorf("unexpected headers on an actual request: %v", h)
+	}
+
+	if NewResolver(config.CORSConfig{}).Policy("/") != nil {
+		t.Error("disabled configuration resolved to a policy")
+	}
+}
+
+func TestOriginPatterns(t *testing.T) {
+	policy := New(config.CORSConfig{
+		Enabled: true,
+		AllowedOrigins: []string{
+			"https://app.example.com",
+			"https://*.app.example.com",
+			`regex:https://[a-z]+\.staging\.example\.com(:\d+)?`,
+		},
+	})
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://app.example.com", true},
+		{"https://pr-123.app.example.com", true},
+		{"http://pr-123.app.example.com", false},
+		{"https://a.pr-123.app.example.com", false},
+		{"https://pr-123.app.example.com.evil.com", false},
+		{"https://evilapp.example.com", false},
+		{"https://web.staging.example.com:8443", true},
+		{"https://web.staging.example.com.evil.com", false},
+	}
+	for _, tt := range tests {
+		if got := policy.allows(tt.origin); got != tt.want {
+			t.Errorf("allows(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+
+	for _, origin := range []string{"https://*.com", "https://pr-*.example.com", "*.example.com", "https://*.example.com:*", "regex:("} {
+		if _, err := config.OriginPattern(origin); err == nil {
+			t.Errorf("pattern %q accepted", origin)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package errorpage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// Data describes a gateway error response
+type Data struct {
+	Status     int
+	Message    string
+	RequestID  string
+	Service    string
+	RetryAfter string
+	Method     string
+	Path       string
+}
+
+// page holds the templates of a status, class or the default
+type page struct {
+	json *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// Renderer renders gateway error responses from templates
+type Renderer struct {
+	pages map[string]page
+}
+
+// NewRenderer parses the configured templates
+func NewRenderer(cfg config.ErrorPagesConfig) (*Renderer, error) {
+	r := &Renderer{pages: make(map[string]page, len(cfg.Templates))}
+	for key, tmpl := range cfg.Templates {
+		var p page
+		var err error
+		if tmpl.JSON != "" {
+			if p.json, err = texttemplate.New(key).Option("missingkey=zero").Parse(tmpl.JSON); err != nil {
+				return nil, fmt.Errorf("error page %s: invalid json template: %w", key, err)
+			}
+		}
+		if tmpl.HTML != "" {
+			if p.html, err = htmltemplate.New(key).Option("missingkey=zero").Parse(tmpl.HTML); err != nil {
+				return nil, fmt.Errorf("error page %s: invalid html template: %w", key, err)
+			}
+		}
+		r.pages[strings.ToLower(key)] = p
+	}
+	return r, nil
+}
+
+// lookup returns the templates of a status, falling back to its class and
+// the default
+func (r *Renderer) lookup(status int) (page, bool) {
+	for _, key := range []string{strconv.Itoa(status), strconv.Itoa(status/100) + "xx", "default"} {
+		if p, ok := r.pages[key]; ok {
+			return p, true
+		}
+	}
+	return page{}, false
+}
+
+// Has reports whether any template applies to a status
+func (r *Renderer) Has(status int) bool {
+	_, ok := r.lookup(status)
+	return ok
+}
+
+// Render renders the error as HTML if the Accept header prefers it and as
+// JSON otherwise, returning the content type and body. The body is nil if
+// no template applies to the status and format.
+func (r *Renderer) Render(accept string, data Data) (string, []byte, error) {
+	p, found := r.lookup(data.Status)
+	if !found {
+		return "", nil, nil
+	}
+
+	var buf bytes.Buffer
+	if p.html != nil && prefersHTML(accept) {
+		if err := p.html.Execute(&buf, data.values(func(s string) string { return s })); err != nil {
+			return "", nil, fmt.Errorf("failed to render html error page: %w", err)
+		}
+		return "text/html; charset=utf-8", buf.Bytes(), nil
+	}
+	if p.json == nil {
+		return "", nil, nil
+	}
+
+	if err := p.json.Execute(&buf, data.values(jsonEscape)); err != nil {
+		return "", nil, fmt.Errorf("failed to render json error page: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return "", nil, fmt.Errorf("json error page for %d is not valid JSON", data.Status)
+	}
+	return "application/json; charset=utf-8", buf.Bytes(), nil
+}
+
+// values returns the template variables with strings escaped for the format
+func (d Data) values(escape func(string) string) map[string]interface{} {
+	return map[string]interface{}{
+		"status":      d.Status,
+		"status_text": escape(http.StatusText(d.Status)),
+		"message":     escape(d.Message),
+		"request_id":  escape(d.RequestID),
+		"service":     escape(d.Service),
+		"retry_after": escape(d.RetryAfter),
+		"method":      escape(d.Method),
+		"path":        escape(d.Path),
+	}
+}
+
+// jsonEscape escapes a string for use inside a JSON string literal
+func jsonEscape(s string) string {
+	encoded, _ := json.Marshal(s)
+	return string(encoded[1 : len(encoded)-1])
+}
+
+// prefersHTML reports whether text/html is the client's first choice
+func prefersHTML(accept string) bool {
+	best, bestQ := "", -1.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestQ = mediaType, q
+		}
+	}
+	return best == "text/html" || best == "application/xhtml+xml"
+}
+
+// Message extracts the error message of a response body written by the
+// gateway: the "error" field of a JSON object, or the text itself
+func Message(body []byte, status int) string {
+	var object struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &object) == nil && object.Error != "" {
+		return object.Error
+	}
+	if text := strings.TrimSpace(string(body)); text != "" && !json.Valid(body) {
+		return text
+	}
+	return http.StatusText(status)
+}
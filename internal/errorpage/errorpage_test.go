@@ -0,0 +1,57 @@
+package errorpage
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestRender(t *testing.T) {
+	r, err := NewRenderer(config.ErrorPagesConfig{
+		Templates: map[string]config.ErrorTemplateConfig{
+			"5xx": {
+				JSON: `{"error": "{{.message}}", "status": {{.status}}, "request_id": "{{.request_id}}"}`,
+				HTML: `<h1>{{.status}} {{.status_text}}</h1><p>{{.message}}</p>`,
+			},
+			"429": {JSON: `{"error": "slow down", "retry_after": "{{.retry_after}}"}`},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Has(404) || !r.Has(503) || !r.Has(429) {
+		t.Errorf("Has: 404 = %v, 503 = %v, 429 = %v", r.Has(404), r.Has(503), r.Has(429))
+	}
+
+	data := Data{Status: 503, Message: Message([]byte(`{"error": "Service \"a\" unavailable"}`), 503), RequestID: "req-1"}
+	contentType, body, err := r.Render("application/json", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("invalid JSON %s: %v", body, err)
+	}
+	if !strings.HasPrefix(contentType, "application/json") || decoded["error"] != `Service "a" unavailable` || decoded["request_id"] != "req-1" {
+		t.Errorf("json = %s (%s)", body, contentType)
+	}
+
+	data.Message = "<script>"
+	contentType, body, _ = r.Render("text/html,application/json;q=0.9", data)
+	if !strings.HasPrefix(contentType, "text/html") || !strings.Contains(string(body), "&lt;script&gt;") {
+		t.Errorf("html = %s (%s)", body, contentType)
+	}
+
+	// No HTML template for 429, so HTML clients get JSON
+	contentType, _, _ = r.Render("text/html", Data{Status: 429, RetryAfter: "1"})
+	if !strings.HasPrefix(contentType, "application/json") {
+		t.Errorf("429 content type = %s, want JSON", contentType)
+	}
+
+	if got := Message([]byte("Bad Gateway\n"), 502); got != "Bad Gateway" {
+		t.Errorf("Message of plain text = %q", got)
+	}
+}
@@ -0,0 +1,262 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/pkg/metrics"
+)
+
+const (
+	// minPublishRetry is the first backoff after a failed publish
+	minPublishRetry = 100 * time.Millisecond
+	// bufferDrainTimeout bounds publishing buffered events on close
+	bufferDrainTimeout = 5 * time.Second
+)
+
+// ErrBufferFull is returned when an event is dropped because the buffer
+// and its spill log are full
+var ErrBufferFull = errors.New("event buffer full")
+
+// BufferConfig holds the asynchronous publishing buffer
+type BufferConfig struct {
+	Enabled          bool          `mapstructure:"enabled"`
+	Size             int           `mapstructure:"size"`
+	SpillDir         string        `mapstructure:"spill_dir"`
+	MaxSpillBytes    int64         `mapstructure:"max_spill_bytes"`
+	MaxRetryInterval time.Duration `mapstructure:"max_retry_interval"`
+}
+
+// eventBuffer publishes events in the background. Events wait in a ring
+// buffer while the broker is unavailable. When it fills up they spill to
+// disk, and later events follow them there until the spill log is drained,
+// so events are published in order.
+type eventBuffer struct {
+	config  BufferConfig
+	publish func(*APIEvent) error
+	logger  *zap.Logger
+
+	mu      sync.Mutex
+	ring    []*APIEvent
+	head    int
+	count   int
+	spill   *spillLog // nil without a spill directory
+	metrics *metrics.Manager
+
+	notify   chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	deadline time.Time
+}
+
+// newEventBuffer creates a buffer, reopening events spilled by a previous
+// run so they are published first
+func newEventBuffer(config BufferConfig, publish func(*APIEvent) error, logger *zap.Logger) (*eventBuffer, error) {
+	if config.MaxRetryInterval < minPublishRetry {
+		config.MaxRetryInterval = minPublishRetry
+	}
+	b := &eventBuffer{
+		config:  config,
+		publish: publish,
+		logger:  logger,
+		ring:    make([]*APIEvent, config.Size),
+		notify:  make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if config.SpillDir != "" {
+		spill, err := openSpillLog(config.SpillDir, config.MaxSpillBytes, logger)
+		if err != nil {
+			return nil, err
+		}
+		b.spill = spill
+		if spill.pending > 0 {
+			logger.Info("Recovered spilled events", zap.Int("events", spill.pending))
+		}
+	}
+	go b.run()
+	return b, nil
+}
+
+// setMetrics sets where the buffer depth is reported
+func (b *eventBuffer) setMetrics(metricsManager *metrics.Manager) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.metrics = metricsManager
+	b.reportDepthLocked()
+}
+
+// enqueue adds an event to the buffer without blocking
+func (b *eventBuffer) enqueue(event *APIEvent) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	full := b.count == len(b.ring)
+	if b.spill != nil && (full || b.spill.pending > 0) {
+		if err := b.spill.append(event); err != nil {
+			return b.dropLocked(err)
+		}
+	} else if full {
+		return b.dropLocked(ErrBufferFull)
+	} else {
+		b.ring[(b.head+b.count)%len(b.ring)] = event
+		b.count++
+	}
+
+	b.reportDepthLocked()
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// dropLocked counts an event that could not be buffered
+func (b *eventBuffer) dropLocked(err error) error {
+	if b.metrics != nil {
+		b.metrics.RecordEventDropped("buffer_full")
+	}
+	if !errors.Is(err, ErrBufferFull) {
+		err = fmt.Errorf("%w: %v", ErrBufferFull, err)
+	}
+	return err
+}
+
+// run publishes buffered events, oldest first, retrying with backoff while
+// the broker is unavailable
+func (b *eventBuffer) run() {
+	defer close(b.done)
+
+	backoff := minPublishRetry
+	failing := false
+	for {
+		closing := b.closing()
+		if closing && time.Now().After(b.deadline) {
+			return
+		}
+		event, fromDisk := b.next()
+		if event == nil {
+			if closing {
+				return
+			}
+			select {
+			case <-b.notify:
+			case <-b.stop:
+			}
+			continue
+		}
+
+		if err := b.publish(event); err != nil {
+			// Closing during an outage leaves the rest for the spill log
+			if closing {
+				return
+			}
+			if !failing {
+				b.logger.Warn("Failed to publish event, buffering until the broker recovers", zap.Error(err))
+				failing = true
+			}
+			select {
+			case <-time.After(backoff):
+			case <-b.stop:
+			}
+			backoff = min(backoff*2, b.config.MaxRetryInterval)
+			continue
+		}
+
+		if failing {
+			b.logger.Info("Event publishing recovered", zap.Int("buffered", b.depth()))
+			failing = false
+		}
+		backoff = minPublishRetry
+		b.commit(fromDisk)
+	}
+}
+
+// next returns the oldest buffered event without removing it
+func (b *eventBuffer) next() (*APIEvent, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.count > 0 {
+		return b.ring[b.head], false
+	}
+	if b.spill != nil && b.spill.pending > 0 {
+		return b.spill.peek(), true
+	}
+	return nil, false
+}
+
+// commit removes the event returned by next once it is published
+func (b *eventBuffer) commit(fromDisk bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if fromDisk {
+		b.spill.advance()
+	} else {
+		b.ring[b.head] = nil
+		b.head = (b.head + 1) % len(b.ring)
+		b.count--
+	}
+	b.reportDepthLocked()
+}
+
+// depth returns the number of buffered events
+func (b *eventBuffer) depth() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := b.count
+	if b.spill != nil {
+		n += b.spill.pending
+	}
+	return n
+}
+
+// reportDepthLocked updates the buffer depth gauges
+func (b *eventBuffer) reportDepthLocked() {
+	if b.metrics == nil {
+		return
+	}
+	b.metrics.SetEventBufferDepth("memory", b.count)
+	if b.spill != nil {
+		b.metrics.SetEventBufferDepth("disk", b.spill.pending)
+	}
+}
+
+// closing reports whether close was called
+func (b *eventBuffer) closing() bool {
+	select {
+	case <-b.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// close publishes what it can within the drain timeout. Events left in
+// memory are written to the spill log, or lost without one.
+func (b *eventBuffer) close() error {
+	b.deadline = time.Now().Add(bufferDrainTimeout)
+	close(b.stop)
+	<-b.done
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	lost := 0
+	for ; b.count > 0; b.count-- {
+		event := b.ring[b.head]
+		b.head = (b.head + 1) % len(b.ring)
+		if b.spill == nil || b.spill.append(event) != nil {
+			lost++
+		}
+	}
+	if lost > 0 {
+		b.logger.Error("Buffered events lost on shutdown", zap.Int("events", lost))
+	}
+	if b.spill != nil {
+		return b.spill.close()
+	}
+	return nil
+}
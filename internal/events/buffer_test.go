@@ -0,0 +1,122 @@
+package events
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// flakyBroker records published events and fails while down
+type flakyBroker struct {
+	mu        sync.Mutex
+	down      bool
+	published []string
+}
+
+func (b *flakyBroker) publish(event *APIEvent) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.down {
+		return errors.New("broker unavailable")
+	}
+	b.published = append(b.published, event.UserID)
+	return nil
+}
+
+func (b *flakyBroker) setDown(down bool) {
+	b.mu.Lock()
+	b.down = down
+	b.mu.Unlock()
+}
+
+func (b *flakyBroker) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.published)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestEventBufferSpillsInOrder(t *testing.T) {
+	dir := t.TempDir()
+	broker := &flakyBroker{down: true}
+	config := BufferConfig{Size: 2, SpillDir: dir, MaxSpillBytes: 1 << 20, MaxRetryInterval: 10 * time.Millisecond}
+	buffer, err := newEventBuffer(config, broker.publish, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 6; i++ {
+		if err := buffer.enqueue(&APIEvent{UserID: strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if buffer.depth() != 6 || buffer.spill.pending != 4 {
+		t.Fatalf("depth = %d with %d spilled, want 6 with 4 spilled", buffer.depth(), buffer.spill.pending)
+	}
+
+	broker.setDown(false)
+	waitFor(t, func() bool { return broker.count() == 6 })
+	for i, id := range broker.published {
+		if id != strconv.Itoa(i) {
+			t.Fatalf("published %v, want events in order", broker.published)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%d spill segments left after draining", len(entries))
+	}
+	buffer.close()
+}
+
+func TestEventBufferRecoversSpilledEvents(t *testing.T) {
+	dir := t.TempDir()
+	broker := &flakyBroker{down: true}
+	config := BufferConfig{Size: 1, SpillDir: dir, MaxSpillBytes: 1 << 20, MaxRetryInterval: 10 * time.Millisecond}
+	buffer, err := newEventBuffer(config, broker.publish, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		buffer.enqueue(&APIEvent{UserID: strconv.Itoa(i)})
+	}
+	// The event held in memory is spilled on close
+	if err := buffer.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	broker.setDown(false)
+	restarted, err := newEventBuffer(config, broker.publish, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.close()
+	waitFor(t, func() bool { return broker.count() == 3 })
+}
+
+func TestEventBufferFull(t *testing.T) {
+	broker := &flakyBroker{down: true}
+	buffer, err := newEventBuffer(BufferConfig{Size: 1, MaxRetryInterval: time.Second}, broker.publish, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buffer.close()
+
+	buffer.enqueue(&APIEvent{})
+	if err := buffer.enqueue(&APIEvent{}); !errors.Is(err, ErrBufferFull) {
+		t.Errorf("err = %v, want ErrBufferFull", err)
+	}
+}
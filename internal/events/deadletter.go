@@ -0,0 +1,254 @@
+package events
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/pkg/metrics"
+)
+
+// Reasons for dead-lettering a message
+const (
+	deadLetterDecodeError  = "decode_error"
+	deadLetterHandlerError = "handler_error"
+)
+
+// maxDeadLetterError bounds the error text attached to dead letters
+const maxDeadLetterError = 1024
+
+// ConsumerConfig holds the retry policy of event consumers. Messages that
+// still fail go to the provider's dead-letter topic or queue.
+type ConsumerConfig struct {
+	MaxRetries     int           `mapstructure:"max_retries"`
+	InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+	MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+	DepthInterval  time.Duration `mapstructure:"depth_interval"`
+}
+
+// SetMetrics sets where consumer retries, dead letters and the publishing
+// buffer are recorded
+func (ep *EventProcessor) SetMetrics(metricsManager *metrics.Manager) {
+	ep.metrics = metricsManager
+	if ep.buffer != nil {
+		ep.buffer.setMetrics(metricsManager)
+	}
+}
+
+// handleWithRetry runs handler, retrying failures with exponential backoff.
+// It returns the last error once the retries are exhausted.
+func (ep *EventProcessor) handleWithRetry(ctx context.Context, handler func(*APIEvent) error, event *APIEvent) error {
+	policy := ep.config.Consumer
+	backoff := policy.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		err := handler(event)
+		if err == nil || attempt >= policy.MaxRetries {
+			return err
+		}
+		if ep.metrics != nil {
+			ep.metrics.RecordEventRetry(ep.config.Provider)
+		}
+		ep.logger.Warn("Failed to handle event, retrying",
+			zap.String("event_type", event.EventType),
+			zap.Int("attempt", attempt+1),
+			zap.Duration("backoff", backoff),
+			zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+// deadLetterKafka sends a message that could not be handled to the dead
+// letter topic, keeping its key and headers. Without a dead-letter topic the
+// message is dropped.
+func (ep *EventProcessor) deadLetterKafka(msg *sarama.ConsumerMessage, reason string, cause error) error {
+	topic := ep.config.Kafka.DeadLetterTopic
+	if topic == "" {
+		ep.logger.Error("Dropping event that could not be handled",
+			zap.String("topic", msg.Topic),
+			zap.Int64("offset", msg.Offset),
+			zap.String("reason", reason),
+			zap.Error(cause))
+		return nil
+	}
+
+	headers := make([]sarama.RecordHeader, 0, len(msg.Headers)+5)
+	for _, h := range msg.Headers {
+		headers = append(headers, *h)
+	}
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte("dlq_reason"), Value: []byte(reason)},
+		sarama.RecordHeader{Key: []byte("dlq_error"), Value: []byte(truncateError(cause))},
+		sarama.RecordHeader{Key: []byte("dlq_original_topic"), Value: []byte(msg.Topic)},
+		sarama.RecordHeader{Key: []byte("dlq_original_partition"), Value: []byte(strconv.Itoa(int(msg.Partition)))},
+		sarama.RecordHeader{Key: []byte("dlq_original_offset"), Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+	)
+	dead := &sarama.ProducerMessage{
+		Topic:   topic,
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	}
+	if msg.Key != nil {
+		dead.Key = sarama.ByteEncoder(msg.Key)
+	}
+	if _, _, err := ep.kafkaProducer.SendMessage(dead); err != nil {
+		return err
+	}
+
+	ep.recordDeadLetter(reason, cause, zap.String("topic", msg.Topic), zap.Int64("offset", msg.Offset))
+	return nil
+}
+
+// deadLetterRabbitMQ publishes a message that could not be handled to the
+// dead-letter queue. Without one the message is rejected, so a
+// dead-letter exchange set on the queue by policy still receives it.
+func (ep *EventProcessor) deadLetterRabbitMQ(msg amqp.Delivery, reason string, cause error) error {
+	queue := ep.config.RabbitMQ.DeadLetterQueue
+	if queue == "" {
+		ep.logger.Error("Rejecting event that could not be handled",
+			zap.String("routing_key", msg.RoutingKey),
+			zap.String("reason", reason),
+			zap.Error(cause))
+		return msg.Nack(false, false)
+	}
+
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers["dlq_reason"] = reason
+	headers["dlq_error"] = truncateError(cause)
+	headers["dlq_original_exchange"] = msg.Exchange
+	headers["dlq_original_routing_key"] = msg.RoutingKey
+
+	// The default exchange routes to the queue named by the routing key
+	err := ep.rabbitChannel.Publish("", queue, false, false, amqp.Publishing{
+		ContentType:  msg.ContentType,
+		Body:         msg.Body,
+		DeliveryMode: amqp.Persistent,
+		Headers:      headers,
+		Timestamp:    msg.Timestamp,
+	})
+	if err != nil {
+		return err
+	}
+
+	ep.recordDeadLetter(reason, cause, zap.String("routing_key", msg.RoutingKey))
+	return msg.Ack(false)
+}
+
+// recordDeadLetter logs and counts a dead-lettered message
+func (ep *EventProcessor) recordDeadLetter(reason string, cause error, fields ...zap.Field) {
+	if ep.metrics != nil {
+		ep.metrics.RecordEventDeadLettered(ep.config.Provider, reason)
+	}
+	ep.logger.Warn("Event sent to the dead-letter queue",
+		append(fields, zap.String("reason", reason), zap.Error(cause))...)
+}
+
+// MonitorDeadLetters reports the depth of the dead-letter topic or queue
+// every depth interval until ctx is done
+func (ep *EventProcessor) MonitorDeadLetters(ctx context.Context) {
+	if !ep.config.Enabled || ep.metrics == nil || ep.config.Consumer.DepthInterval <= 0 {
+		return
+	}
+
+	var (
+		destination string
+		depth       func() (int64, error)
+	)
+	switch ep.config.Provider {
+	case "kafka":
+		destination, depth = ep.config.Kafka.DeadLetterTopic, ep.kafkaDeadLetterDepth
+	case "rabbitmq":
+		destination, depth = ep.config.RabbitMQ.DeadLetterQueue, ep.rabbitMQDeadLetterDepth
+	}
+	if destination == "" {
+		return
+	}
+
+	ticker := time.NewTicker(ep.config.Consumer.DepthInterval)
+	defer ticker.Stop()
+	for {
+		if n, err := depth(); err != nil {
+			ep.logger.Warn("Failed to read dead-letter depth",
+				zap.String("destination", destination),
+				zap.Error(err))
+		} else {
+			ep.metrics.SetDeadLetterDepth(ep.config.Provider, destination, n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// kafkaDeadLetterDepth returns the number of messages retained in the
+// dead-letter topic
+func (ep *EventProcessor) kafkaDeadLetterDepth() (int64, error) {
+	client, err := sarama.NewClient(ep.config.Kafka.Brokers, sarama.NewConfig())
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	topic := ep.config.Kafka.DeadLetterTopic
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return 0, err
+	}
+	var depth int64
+	for _, partition := range partitions {
+		newest, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return 0, err
+		}
+		oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			return 0, err
+		}
+		depth += newest - oldest
+	}
+	return depth, nil
+}
+
+// rabbitMQDeadLetterDepth returns the number of messages ready in the
+// dead-letter queue
+func (ep *EventProcessor) rabbitMQDeadLetterDepth() (int64, error) {
+	// A failed passive declare closes its channel, so it gets its own
+	ch, err := ep.rabbitConn.Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+
+	q, err := ch.QueueDeclarePassive(ep.config.RabbitMQ.DeadLetterQueue, true, false, false, false, nil)
+	if err != nil {
+		return 0, err
+	}
+	return int64(q.Messages), nil
+}
+
+// truncateError returns the text of err, bounded for message headers
+func truncateError(err error) string {
+	s := err.Error()
+	if len(s) > maxDeadLetterError {
+		s = s[:maxDeadLetterError]
+	}
+	return s
+}
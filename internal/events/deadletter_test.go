@@ -0,0 +1,99 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"go.uber.org/zap"
+)
+
+// recordingProducer keeps the messages sent to it
+type recordingProducer struct {
+	sarama.SyncProducer
+	sent []*sarama.ProducerMessage
+}
+
+func (p *recordingProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.sent = append(p.sent, msg)
+	return 0, int64(len(p.sent)), nil
+}
+
+func TestHandleWithRetry(t *testing.T) {
+	ep := &EventProcessor{
+		config: &EventConfig{Consumer: ConsumerConfig{MaxRetries: 2, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}},
+		logger: zap.NewNop(),
+	}
+
+	calls := 0
+	failing := func(*APIEvent) error {
+		calls++
+		return errors.New("downstream unavailable")
+	}
+	if err := ep.handleWithRetry(context.Background(), failing, &APIEvent{}); err == nil {
+		t.Error("expected the last error after exhausting retries")
+	}
+	if calls != 3 {
+		t.Errorf("handler called %d times, want 3", calls)
+	}
+
+	calls = 0
+	flaky := func(*APIEvent) error {
+		calls++
+		if calls < 2 {
+			return errors.New("timeout")
+		}
+		return nil
+	}
+	if err := ep.handleWithRetry(context.Background(), flaky, &APIEvent{}); err != nil || calls != 2 {
+		t.Errorf("err = %v after %d calls, want success on the retry", err, calls)
+	}
+}
+
+func TestDeadLetterKafka(t *testing.T) {
+	producer := &recordingProducer{}
+	ep := &EventProcessor{
+		kafkaProducer: producer,
+		config:        &EventConfig{Provider: "kafka", Kafka: KafkaConfig{DeadLetterTopic: "api-events-dlq"}},
+		logger:        zap.NewNop(),
+	}
+
+	msg := &sarama.ConsumerMessage{
+		Topic:     "api-events",
+		Partition: 2,
+		Offset:    41,
+		Key:       []byte("user-1"),
+		Value:     []byte("not json"),
+		Headers:   []*sarama.RecordHeader{{Key: []byte("event_type"), Value: []byte("user_event")}},
+	}
+	if err := ep.deadLetterKafka(msg, deadLetterDecodeError, errors.New("invalid character")); err != nil {
+		t.Fatal(err)
+	}
+	if len(producer.sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(producer.sent))
+	}
+
+	dead := producer.sent[0]
+	if dead.Topic != "api-events-dlq" {
+		t.Errorf("topic = %q", dead.Topic)
+	}
+	headers := map[string]string{}
+	for _, h := range dead.Headers {
+		headers[string(h.Key)] = string(h.Value)
+	}
+	want := map[string]string{
+		"event_type":             "user_event",
+		"dlq_reason":             deadLetterDecodeError,
+		"dlq_error":              "invalid character",
+		"dlq_original_topic":     "api-events",
+		"dlq_original_partition": "2",
+		"dlq_original_offset":    "41",
+	}
+	for k, v := range want {
+		if headers[k] != v {
+			t.Errorf("header %s = %q, want %q", k, headers[k], v)
+		}
+	}
+}
@@ -4,11 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
+	"strconv"
 	"time"
 
 	"github.com/Shopify/sarama"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/pkg/metrics"
 )
 
 // EventProcessor handles distributed event processing
@@ -19,22 +23,42 @@ type EventProcessor struct {
 	rabbitChannel *amqp.Channel
 	config        *EventConfig
 	logger        *zap.Logger
+	// codecs encode the events of each Kafka topic; nil means JSON
+	codecs  map[string]*codec
+	metrics *metrics.Manager
+	// buffer publishes events in the background when enabled
+	buffer *eventBuffer
 }
 
 // EventConfig holds event processing configuration
 type EventConfig struct {
 	Enabled  bool   `mapstructure:"enabled"`
 	Provider string `mapstructure:"provider"` // "kafka" or "rabbitmq"
+	Encoding string `mapstructure:"encoding"` // json, or avro or protobuf for Kafka
 	Kafka    KafkaConfig
 	RabbitMQ RabbitMQConfig
+	Consumer ConsumerConfig `mapstructure:"consumer"`
+	Buffer   BufferConfig   `mapstructure:"buffer"`
 }
 
 // KafkaConfig holds Kafka-specific configuration
 type KafkaConfig struct {
-	Brokers        []string          `mapstructure:"brokers"`
-	Topics         map[string]string `mapstructure:"topics"`
-	ConsumerGroup  string            `mapstructure:"consumer_group"`
-	ProducerConfig ProducerConfig    `mapstructure:"producer_config"`
+	Brokers        []string             `mapstructure:"brokers"`
+	Topics         map[string]string    `mapstructure:"topics"`
+	ConsumerGroup  string               `mapstructure:"consumer_group"`
+	ProducerConfig ProducerConfig       `mapstructure:"producer_config"`
+	SchemaRegistry SchemaRegistryConfig `mapstructure:"schema_registry"`
+	// DeadLetterTopic receives the events consumers failed to handle
+	DeadLetterTopic string `mapstructure:"dead_letter_topic"`
+}
+
+// SchemaRegistryConfig holds the Confluent Schema Registry used by the avro
+// and protobuf encodings
+type SchemaRegistryConfig struct {
+	URL          string `mapstructure:"url"`
+	Username     string `mapstructure:"username"`
+	Password     string `mapstructure:"password"`
+	AutoRegister bool   `mapstructure:"auto_register"`
 }
 
 // RabbitMQConfig holds RabbitMQ-specific configuration
@@ -42,6 +66,8 @@ type RabbitMQConfig struct {
 	URL       string            `mapstructure:"url"`
 	Exchanges map[string]string `mapstructure:"exchanges"`
 	Queues    map[string]string `mapstructure:"queues"`
+	// DeadLetterQueue receives the events consumers failed to handle
+	DeadLetterQueue string `mapstructure:"dead_letter_queue"`
 }
 
 // ProducerConfig holds producer-specific settings
@@ -54,21 +80,37 @@ type ProducerConfig struct {
 
 // APIEvent represents an API gateway event
 type APIEvent struct {
-	Timestamp  time.Time         `json:"timestamp"`
-	EventType  string            `json:"event_type"`
-	UserID     string            `json:"user_id"`
-	Service    string            `json:"service"`
-	Path       string            `json:"path"`
-	Method     string            `json:"method"`
-	StatusCode int               `json:"status_code"`
-	Latency    time.Duration     `json:"latency"`
-	IPAddress  string            `json:"ip_address"`
-	UserAgent  string            `json:"user_agent"`
-	Metadata   map[string]string `json:"metadata"`
-	TraceID    string            `json:"trace_id,omitempty"`
-	SpanID     string            `json:"span_id,omitempty"`
+	// SchemaVersion is set to the current SchemaVersion when published
+	SchemaVersion int               `json:"schema_version"`
+	Timestamp     time.Time         `json:"timestamp"`
+	EventType     string            `json:"event_type"`
+	UserID        string            `json:"user_id"`
+	Service       string            `json:"service"`
+	Path          string            `json:"path"`
+	Method        string            `json:"method"`
+	StatusCode    int               `json:"status_code"`
+	Latency       time.Duration     `json:"latency"`
+	IPAddress     string            `json:"ip_address"`
+	UserAgent     string            `json:"user_agent"`
+	Metadata      map[string]string `json:"metadata"`
+	TraceID       string            `json:"trace_id,omitempty"`
+	SpanID        string            `json:"span_id,omitempty"`
 }
 
+// Event types published by the gateway itself
+const (
+	EventTypeCircuitBreakerStateChanged = "circuit_breaker_state_changed"
+	EventTypeBotDecision                = "bot_decision"
+	EventTypeRateLimitExceeded          = "rate_limit_exceeded"
+	EventTypeFeatureFlagEvaluation      = "feature_flag_evaluation"
+	EventTypeDegradationChanged         = "degradation_changed"
+	// EventTypeAuditLog events go to the audit topic or routing key
+	EventTypeAuditLog = "audit_log"
+	// EventTypeSecurity events go to the security topic, falling back to
+	// the audit topic, or the security routing key
+	EventTypeSecurity = "security"
+)
+
 // NewEventProcessor creates a new event processor
 func NewEventProcessor(config *EventConfig, logger *zap.Logger) (*EventProcessor, error) {
 	if !config.Enabled {
@@ -93,6 +135,15 @@ func NewEventProcessor(config *EventConfig, logger *zap.Logger) (*EventProcessor
 		return nil, fmt.Errorf("unsupported event provider: %s", config.Provider)
 	}
 
+	if config.Buffer.Enabled {
+		buffer, err := newEventBuffer(config.Buffer, ep.publish, logger)
+		if err != nil {
+			ep.Close()
+			return nil, fmt.Errorf("failed to initialize event buffer: %w", err)
+		}
+		ep.buffer = buffer
+	}
+
 	logger.Info("Event processor initialized", zap.String("provider", config.Provider))
 	return ep, nil
 }
@@ -119,6 +170,10 @@ func (ep *EventProcessor) initKafka() error {
 	producerConfig.Producer.Flush.Bytes = ep.config.Kafka.ProducerConfig.BatchSize
 	producerConfig.Producer.Flush.Frequency = time.Duration(ep.config.Kafka.ProducerConfig.LingerMs) * time.Millisecond
 
+	if err := ep.initSchemas(); err != nil {
+		return err
+	}
+
 	// Create producer
 	producer, err := sarama.NewSyncProducer(ep.config.Kafka.Brokers, producerConfig)
 	if err != nil {
@@ -143,6 +198,43 @@ func (ep *EventProcessor) initKafka() error {
 	return nil
 }
 
+// initSchemas checks the event schema against the schema registry and
+// registers it for each topic, so incompatible changes fail at startup
+func (ep *EventProcessor) initSchemas() error {
+	encoding := ep.config.Encoding
+	if encoding == "" || encoding == EncodingJSON {
+		return nil
+	}
+	schemaType, schema := schemaFor(encoding)
+	if schema == "" {
+		return fmt.Errorf("unsupported event encoding: %s", encoding)
+	}
+	if ep.config.Kafka.SchemaRegistry.URL == "" {
+		return fmt.Errorf("%s encoding requires a schema registry", encoding)
+	}
+
+	registry := newSchemaRegistry(ep.config.Kafka.SchemaRegistry)
+	ep.codecs = make(map[string]*codec)
+	for _, key := range []string{"api_events", "user_events", "audit_logs", "security_events"} {
+		topic := ep.config.Kafka.Topics[key]
+		if topic == "" || ep.codecs[topic] != nil {
+			continue
+		}
+		// Subjects follow the default topic name strategy
+		id, err := registry.ensureSchema(context.Background(), topic+"-value", schemaType, schema)
+		if err != nil {
+			return err
+		}
+		ep.codecs[topic] = &codec{encoding: encoding, schemaID: id}
+		ep.logger.Info("Event schema registered",
+			zap.String("topic", topic),
+			zap.String("encoding", encoding),
+			zap.Int("schema_id", id),
+			zap.Int("schema_version", SchemaVersion))
+	}
+	return nil
+}
+
 // initRabbitMQ initializes RabbitMQ connections
 func (ep *EventProcessor) initRabbitMQ() error {
 	// Connect to RabbitMQ
@@ -204,18 +296,37 @@ func (ep *EventProcessor) initRabbitMQ() error {
 		}
 	}
 
+	if queue := ep.config.RabbitMQ.DeadLetterQueue; queue != "" {
+		if _, err := ch.QueueDeclare(queue, true, false, false, false, nil); err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to declare dead-letter queue %s: %w", queue, err)
+		}
+	}
+
 	ep.rabbitConn = conn
 	ep.rabbitChannel = ch
 
 	return nil
 }
 
-// PublishEvent publishes an event to the configured provider
+// PublishEvent publishes an event to the configured provider. With the
+// buffer enabled it returns once the event is buffered, and fails only if
+// the buffer is full.
 func (ep *EventProcessor) PublishEvent(event *APIEvent) error {
 	if !ep.config.Enabled {
 		return nil
 	}
+	if event.SchemaVersion == 0 {
+		event.SchemaVersion = SchemaVersion
+	}
+	if ep.buffer != nil {
+		return ep.buffer.enqueue(event)
+	}
+	return ep.publish(event)
+}
 
+// publish sends an event to the configured provider
+func (ep *EventProcessor) publish(event *APIEvent) error {
 	switch ep.config.Provider {
 	case "kafka":
 		return ep.publishToKafka(event)
@@ -228,18 +339,23 @@ func (ep *EventProcessor) PublishEvent(event *APIEvent) error {
 
 // publishToKafka publishes an event to Kafka
 func (ep *EventProcessor) publishToKafka(event *APIEvent) error {
-	data, err := json.Marshal(event)
-	if err != nil {
-		return fmt.Errorf("failed to marshal event: %w", err)
-	}
-
 	// Determine topic based on event type
 	topic := ep.config.Kafka.Topics["api_events"]
 	switch event.EventType {
 	case "user_event":
 		topic = ep.config.Kafka.Topics["user_events"]
-	case "audit_log":
+	case EventTypeAuditLog:
 		topic = ep.config.Kafka.Topics["audit_logs"]
+	case EventTypeSecurity:
+		topic = ep.config.Kafka.Topics["security_events"]
+		if topic == "" {
+			topic = ep.config.Kafka.Topics["audit_logs"]
+		}
+	}
+
+	data, err := ep.codecs[topic].encode(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
 	}
 
 	msg := &sarama.ProducerMessage{
@@ -249,6 +365,7 @@ func (ep *EventProcessor) publishToKafka(event *APIEvent) error {
 		Headers: []sarama.RecordHeader{
 			{Key: []byte("event_type"), Value: []byte(event.EventType)},
 			{Key: []byte("service"), Value: []byte(event.Service)},
+			{Key: []byte("schema_version"), Value: []byte(strconv.Itoa(event.SchemaVersion))},
 		},
 	}
 
@@ -281,8 +398,10 @@ func (ep *EventProcessor) publishToRabbitMQ(event *APIEvent) error {
 	case "user_event":
 		exchange = ep.config.RabbitMQ.Exchanges["user_events"]
 		routingKey = "user.event"
-	case "audit_log":
+	case EventTypeAuditLog:
 		routingKey = "audit.log"
+	case EventTypeSecurity:
+		routingKey = "security.event"
 	}
 
 	err = ep.rabbitChannel.Publish(
@@ -295,9 +414,10 @@ func (ep *EventProcessor) publishToRabbitMQ(event *APIEvent) error {
 			Body:         data,
 			DeliveryMode: amqp.Persistent,
 			Headers: amqp.Table{
-				"event_type": event.EventType,
-				"service":    event.Service,
-				"user_id":    event.UserID,
+				"event_type":     event.EventType,
+				"service":        event.Service,
+				"user_id":        event.UserID,
+				"schema_version": int32(event.SchemaVersion),
 			},
 		})
 
@@ -335,7 +455,7 @@ func (ep *EventProcessor) startKafkaConsumer(ctx context.Context, handler func(*
 	config := sarama.NewConfig()
 	config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
 	config.Consumer.Offsets.Initial = sarama.OffsetOldest
-	
+
 	group, err := sarama.NewConsumerGroup(ep.config.Kafka.Brokers, ep.config.Kafka.ConsumerGroup, config)
 	if err != nil {
 		return fmt.Errorf("failed to create consumer group: %w", err)
@@ -348,10 +468,11 @@ func (ep *EventProcessor) startKafkaConsumer(ctx context.Context, handler func(*
 				group.Close()
 				return
 			default:
-				topics := []string{ep.config.Kafka.Topics["api_events"]}
-				err := group.Consume(ctx, topics, &kafkaConsumerHandler{
-					handler: handler,
-					logger:  ep.logger,
+				topic := ep.config.Kafka.Topics["api_events"]
+				err := group.Consume(ctx, []string{topic}, &kafkaConsumerHandler{
+					processor: ep,
+					handler:   handler,
+					codec:     ep.codecs[topic],
 				})
 				if err != nil {
 					ep.logger.Error("Error from consumer", zap.Error(err))
@@ -383,21 +504,33 @@ func (ep *EventProcessor) startRabbitMQConsumer(ctx context.Context, handler fun
 			select {
 			case <-ctx.Done():
 				return
-			case msg := <-msgs:
+			case msg, ok := <-msgs:
+				if !ok {
+					ep.logger.Error("RabbitMQ consumer channel closed")
+					return
+				}
+
 				var event APIEvent
-				if err := json.Unmarshal(msg.Body, &event); err != nil {
-					ep.logger.Error("Failed to unmarshal event", zap.Error(err))
-					msg.Nack(false, true)
+				reason := deadLetterDecodeError
+				err := json.Unmarshal(msg.Body, &event)
+				if err == nil {
+					reason = deadLetterHandlerError
+					err = ep.handleWithRetry(ctx, handler, &event)
+				}
+				if err == nil {
+					msg.Ack(false)
 					continue
 				}
 
-				if err := handler(&event); err != nil {
-					ep.logger.Error("Failed to handle event", zap.Error(err))
+				// Shutting down: leave the message to the next consumer
+				if ctx.Err() != nil {
+					msg.Nack(false, true)
+					return
+				}
+				if err := ep.deadLetterRabbitMQ(msg, reason, err); err != nil {
+					ep.logger.Error("Failed to dead-letter event", zap.Error(err))
 					msg.Nack(false, true)
-					continue
 				}
-
-				msg.Ack(false)
 			}
 		}
 	}()
@@ -405,10 +538,50 @@ func (ep *EventProcessor) startRabbitMQConsumer(ctx context.Context, handler fun
 	return nil
 }
 
+// HealthCheck verifies the connection to the configured provider
+func (ep *EventProcessor) HealthCheck(ctx context.Context) error {
+	if !ep.config.Enabled {
+		return fmt.Errorf("event processor is disabled")
+	}
+
+	switch ep.config.Provider {
+	case "kafka":
+		// The producer does not expose its brokers, so check reachability
+		if len(ep.config.Kafka.Brokers) == 0 {
+			return fmt.Errorf("no Kafka brokers configured")
+		}
+		var dialer net.Dialer
+		var lastErr error
+		for _, broker := range ep.config.Kafka.Brokers {
+			conn, err := dialer.DialContext(ctx, "tcp", broker)
+			if err == nil {
+				conn.Close()
+				return nil
+			}
+			lastErr = err
+		}
+		return fmt.Errorf("no Kafka broker reachable: %w", lastErr)
+	case "rabbitmq":
+		if ep.rabbitConn == nil || ep.rabbitConn.IsClosed() {
+			return fmt.Errorf("RabbitMQ connection is closed")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported event provider: %s", ep.config.Provider)
+	}
+}
+
 // Close closes all connections
 func (ep *EventProcessor) Close() error {
 	var errs []error
 
+	// Publish or spill buffered events while the producers are still open
+	if ep.buffer != nil {
+		if err := ep.buffer.close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close event buffer: %w", err))
+		}
+	}
+
 	if ep.kafkaProducer != nil {
 		if err := ep.kafkaProducer.Close(); err != nil {
 			errs = append(errs, fmt.Errorf("failed to close Kafka producer: %w", err))
@@ -442,8 +615,9 @@ func (ep *EventProcessor) Close() error {
 
 // kafkaConsumerHandler handles Kafka consumer callbacks
 type kafkaConsumerHandler struct {
-	handler func(*APIEvent) error
-	logger  *zap.Logger
+	processor *EventProcessor
+	handler   func(*APIEvent) error
+	codec     *codec
 }
 
 func (h *kafkaConsumerHandler) Setup(sarama.ConsumerGroupSession) error   { return nil }
@@ -451,15 +625,21 @@ func (h *kafkaConsumerHandler) Cleanup(sarama.ConsumerGroupSession) error { retu
 
 func (h *kafkaConsumerHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	for message := range claim.Messages() {
-		var event APIEvent
-		if err := json.Unmarshal(message.Value, &event); err != nil {
-			h.logger.Error("Failed to unmarshal event", zap.Error(err))
-			continue
+		reason := deadLetterDecodeError
+		event, err := h.codec.decode(message.Value)
+		if err == nil {
+			reason = deadLetterHandlerError
+			err = h.processor.handleWithRetry(session.Context(), h.handler, event)
 		}
 
-		if err := h.handler(&event); err != nil {
-			h.logger.Error("Failed to handle event", zap.Error(err))
-			continue
+		if err != nil {
+			// Unmarked messages are consumed again by the next session
+			if session.Context().Err() != nil {
+				return nil
+			}
+			if err := h.processor.deadLetterKafka(message, reason, err); err != nil {
+				return fmt.Errorf("failed to dead-letter message: %w", err)
+			}
 		}
 
 		session.MarkMessage(message, "")
@@ -0,0 +1,118 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/pkg/metrics"
+)
+
+// queueDrainTimeout bounds publishing queued events on close
+const queueDrainTimeout = 5 * time.Second
+
+// queuedEvent is an event waiting in the publish queue with what it reports,
+// for the log when publishing fails
+type queuedEvent struct {
+	kind  string
+	event *APIEvent
+}
+
+// Queue publishes events from a single goroutine, so listeners on the
+// request path neither wait for the broker nor start a goroutine per event.
+// Events arriving while the queue is full are dropped.
+type Queue struct {
+	publish func(*APIEvent) error
+	metrics *metrics.Manager
+	logger  *zap.Logger
+
+	events    chan queuedEvent
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewQueue creates a queue of size events in front of the processor and
+// starts publishing. metrics may be nil.
+func NewQueue(processor *EventProcessor, size int, metricsManager *metrics.Manager, logger *zap.Logger) *Queue {
+	return newQueue(processor.PublishEvent, size, metricsManager, logger)
+}
+
+func newQueue(publish func(*APIEvent) error, size int, metricsManager *metrics.Manager, logger *zap.Logger) *Queue {
+	q := &Queue{
+		publish: publish,
+		metrics: metricsManager,
+		logger:  logger,
+		events:  make(chan queuedEvent, size),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// Publish queues an event without blocking. kind names the event in the log
+// if publishing fails, e.g. "rate limit".
+func (q *Queue) Publish(kind string, event *APIEvent) {
+	select {
+	case <-q.stop:
+		q.drop(kind)
+		return
+	default:
+	}
+	select {
+	case q.events <- queuedEvent{kind: kind, event: event}:
+	default:
+		q.drop(kind)
+	}
+}
+
+// drop counts an event that did not fit in the queue
+func (q *Queue) drop(kind string) {
+	if q.metrics != nil {
+		q.metrics.RecordEventDropped("queue_full")
+	}
+	q.logger.Debug("Event queue full, event dropped", zap.String("kind", kind))
+}
+
+// run publishes queued events in order. Once stopped it publishes what is
+// left for up to the drain timeout.
+func (q *Queue) run() {
+	defer close(q.done)
+	for {
+		select {
+		case queued := <-q.events:
+			q.send(queued)
+		case <-q.stop:
+			deadline := time.Now().Add(queueDrainTimeout)
+			for time.Now().Before(deadline) {
+				select {
+				case queued := <-q.events:
+					q.send(queued)
+				default:
+					return
+				}
+			}
+			if lost := len(q.events); lost > 0 {
+				q.logger.Error("Queued events lost on shutdown", zap.Int("events", lost))
+			}
+			return
+		}
+	}
+}
+
+// send publishes a single event
+func (q *Queue) send(queued queuedEvent) {
+	if err := q.publish(queued.event); err != nil {
+		q.logger.Warn("Failed to publish "+queued.kind+" event", zap.Error(err))
+	}
+}
+
+// Close stops the queue after publishing the queued events
+func (q *Queue) Close() {
+	q.closeOnce.Do(func() {
+		close(q.stop)
+		<-q.done
+	})
+}
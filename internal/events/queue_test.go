@@ -0,0 +1,44 @@
+package events
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestQueueDropsWhenFull(t *testing.T) {
+	broker := &flakyBroker{}
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	queue := newQueue(func(event *APIEvent) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		return broker.publish(event)
+	}, 2, nil, zap.NewNop())
+
+	// The first event blocks the publisher, two more fill the queue
+	queue.Publish("test", &APIEvent{UserID: "0"})
+	<-started
+	for i := 1; i <= 5; i++ {
+		queue.Publish("test", &APIEvent{UserID: string(rune('0' + i))})
+	}
+
+	close(release)
+	queue.Close()
+	if got := broker.count(); got != 3 {
+		t.Errorf("published %d events, want 3 with the rest dropped", got)
+	}
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	for i, want := range []string{"0", "1", "2"} {
+		if broker.published[i] != want {
+			t.Errorf("event %d = %s, want %s", i, broker.published[i], want)
+		}
+	}
+
+	// Events published after close are dropped rather than blocking
+	queue.Publish("test", &APIEvent{UserID: "late"})
+}
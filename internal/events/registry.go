@@ -0,0 +1,112 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// registryTimeout bounds each schema registry call
+const registryTimeout = 10 * time.Second
+
+// schemaRegistry is a client of the Confluent Schema Registry REST API
+type schemaRegistry struct {
+	config SchemaRegistryConfig
+	client *http.Client
+}
+
+// registryError is the error body of the schema registry
+type registryError struct {
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+// newSchemaRegistry creates a schema registry client
+func newSchemaRegistry(config SchemaRegistryConfig) *schemaRegistry {
+	return &schemaRegistry{config: config, client: &http.Client{Timeout: registryTimeout}}
+}
+
+// ensureSchema checks that a schema is compatible with the latest version
+// of a subject and returns its ID, registering it when allowed
+func (r *schemaRegistry) ensureSchema(ctx context.Context, subject, schemaType, schema string) (int, error) {
+	body := map[string]string{"schema": schema}
+	// The registry defaults to Avro and rejects it as an explicit type on
+	// older versions
+	if schemaType != schemaTypeAvro {
+		body["schemaType"] = schemaType
+	}
+
+	var compat struct {
+		IsCompatible bool     `json:"is_compatible"`
+		Messages     []string `json:"messages"`
+	}
+	status, err := r.post(ctx, "/compatibility/subjects/"+url.PathEscape(subject)+"/versions/latest?verbose=true", body, &compat)
+	// A subject without versions has nothing to be compatible with
+	switch {
+	case err != nil && status != http.StatusNotFound:
+		return 0, fmt.Errorf("compatibility check of %s failed: %w", subject, err)
+	case err == nil && !compat.IsCompatible:
+		return 0, fmt.Errorf("schema is incompatible with the latest version of %s: %s",
+			subject, strings.Join(compat.Messages, "; "))
+	}
+
+	var result struct {
+		ID int `json:"id"`
+	}
+	if r.config.AutoRegister {
+		if _, err := r.post(ctx, "/subjects/"+url.PathEscape(subject)+"/versions", body, &result); err != nil {
+			return 0, fmt.Errorf("failed to register schema for %s: %w", subject, err)
+		}
+		return result.ID, nil
+	}
+	if _, err := r.post(ctx, "/subjects/"+url.PathEscape(subject), body, &result); err != nil {
+		return 0, fmt.Errorf("schema is not registered for %s: %w", subject, err)
+	}
+	return result.ID, nil
+}
+
+// post sends a JSON request and decodes the response into out. Errors
+// carry the HTTP status, 0 when no response was received.
+func (r *schemaRegistry) post(ctx context.Context, path string, in, out interface{}) (int, error) {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(r.config.URL, "/")+path, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if r.config.Username != "" {
+		req.SetBasicAuth(r.config.Username, r.config.Password)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var regErr registryError
+		if json.Unmarshal(body, &regErr) == nil && regErr.Message != "" {
+			return resp.StatusCode, fmt.Errorf("%s (error code %d)", regErr.Message, regErr.ErrorCode)
+		}
+		return resp.StatusCode, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return resp.StatusCode, fmt.Errorf("invalid response: %w", err)
+	}
+	return resp.StatusCode, nil
+}
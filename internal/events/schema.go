@@ -0,0 +1,389 @@
+package events
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// SchemaVersion is the version of the APIEvent payload published by this
+// gateway. Events without a schema_version predate versioning and have the
+// shape of version 1. Adding optional fields keeps the version; removing or
+// retyping fields requires a new one.
+const SchemaVersion = 1
+
+// Event encodings
+const (
+	EncodingJSON     = "json"
+	EncodingAvro     = "avro"
+	EncodingProtobuf = "protobuf"
+)
+
+// Schema registry schema types
+const (
+	schemaTypeAvro     = "AVRO"
+	schemaTypeProtobuf = "PROTOBUF"
+)
+
+// avroSchema describes APIEvent for the avro encoding. Fields may only be
+// appended, with defaults, so older consumers keep reading new events.
+const avroSchema = `{
+  "type": "record",
+  "name": "APIEvent",
+  "namespace": "gateway.events",
+  "fields": [
+    {"name": "schema_version", "type": "int"},
+    {"name": "timestamp", "type": {"type": "long", "logicalType": "timestamp-micros"}},
+    {"name": "event_type", "type": "string"},
+    {"name": "user_id", "type": "string"},
+    {"name": "service", "type": "string"},
+    {"name": "path", "type": "string"},
+    {"name": "method", "type": "string"},
+    {"name": "status_code", "type": "int"},
+    {"name": "latency", "type": "long", "doc": "nanoseconds"},
+    {"name": "ip_address", "type": "string"},
+    {"name": "user_agent", "type": "string"},
+    {"name": "metadata", "type": {"type": "map", "values": "string"}},
+    {"name": "trace_id", "type": "string", "default": ""},
+    {"name": "span_id", "type": "string", "default": ""}
+  ]
+}`
+
+// protobufSchema describes APIEvent for the protobuf encoding. Field
+// numbers must never be reused.
+const protobufSchema = `syntax = "proto3";
+package gateway.events;
+
+message APIEvent {
+  int32 schema_version = 1;
+  int64 timestamp = 2; // microseconds since the Unix epoch
+  string event_type = 3;
+  string user_id = 4;
+  string service = 5;
+  string path = 6;
+  string method = 7;
+  int32 status_code = 8;
+  int64 latency = 9; // nanoseconds
+  string ip_address = 10;
+  string user_agent = 11;
+  map<string, string> metadata = 12;
+  string trace_id = 13;
+  string span_id = 14;
+}
+`
+
+// errUnknownSchema is returned for framed payloads written with a schema
+// other than the registered one
+var errUnknownSchema = errors.New("unknown schema id")
+
+// codec encodes events in the configured encoding. Binary encodings are
+// framed in the Confluent wire format: a zero magic byte and the 4-byte
+// schema ID, then the payload.
+type codec struct {
+	encoding string
+	schemaID int
+}
+
+// schemaFor returns the registry schema type and definition of an encoding
+func schemaFor(encoding string) (schemaType, definition string) {
+	switch encoding {
+	case EncodingAvro:
+		return schemaTypeAvro, avroSchema
+	case EncodingProtobuf:
+		return schemaTypeProtobuf, protobufSchema
+	}
+	return "", ""
+}
+
+// encode serializes an event
+func (c *codec) encode(event *APIEvent) ([]byte, error) {
+	if c == nil || c.encoding == EncodingJSON || c.encoding == "" {
+		return json.Marshal(event)
+	}
+
+	buf := make([]byte, 5, 256)
+	binary.BigEndian.PutUint32(buf[1:], uint32(c.schemaID))
+	switch c.encoding {
+	case EncodingAvro:
+		return appendAvro(buf, event), nil
+	case EncodingProtobuf:
+		// Message index of the first message in the schema
+		buf = append(buf, 0)
+		return appendProtobuf(buf, event), nil
+	}
+	return nil, fmt.Errorf("unsupported encoding: %s", c.encoding)
+}
+
+// decode parses an event. JSON payloads are accepted in any encoding, so
+// topics can be migrated while they still hold JSON events.
+func (c *codec) decode(data []byte) (*APIEvent, error) {
+	if len(data) == 0 || data[0] != 0 || c == nil || c.encoding == EncodingJSON || c.encoding == "" {
+		var event APIEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, err
+		}
+		return &event, nil
+	}
+	if len(data) < 5 {
+		return nil, fmt.Errorf("truncated payload")
+	}
+	if id := int(binary.BigEndian.Uint32(data[1:5])); id != c.schemaID {
+		return nil, fmt.Errorf("%w %d", errUnknownSchema, id)
+	}
+
+	data = data[5:]
+	switch c.encoding {
+	case EncodingAvro:
+		return decodeAvro(data)
+	case EncodingProtobuf:
+		if len(data) == 0 || data[0] != 0 {
+			return nil, fmt.Errorf("unsupported message index")
+		}
+		return decodeProtobuf(data[1:])
+	}
+	return nil, fmt.Errorf("unsupported encoding: %s", c.encoding)
+}
+
+// appendAvro appends the Avro binary encoding of an event
+func appendAvro(buf []byte, e *APIEvent) []byte {
+	putLong := func(v int64) { buf = binary.AppendVarint(buf, v) }
+	putString := func(s string) {
+		putLong(int64(len(s)))
+		buf = append(buf, s...)
+	}
+
+	putLong(int64(e.SchemaVersion))
+	putLong(e.Timestamp.UnixMicro())
+	putString(e.EventType)
+	putString(e.UserID)
+	putString(e.Service)
+	putString(e.Path)
+	putString(e.Method)
+	putLong(int64(e.StatusCode))
+	putLong(int64(e.Latency))
+	putString(e.IPAddress)
+	putString(e.UserAgent)
+	// Maps are written as one block of entries and an empty block
+	if len(e.Metadata) > 0 {
+		putLong(int64(len(e.Metadata)))
+		for k, v := range e.Metadata {
+			putString(k)
+			putString(v)
+		}
+	}
+	putLong(0)
+	putString(e.TraceID)
+	putString(e.SpanID)
+	return buf
+}
+
+// avroReader reads Avro primitives, keeping the first error
+type avroReader struct {
+	data []byte
+	err  error
+}
+
+func (r *avroReader) long() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.err = fmt.Errorf("invalid avro long")
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *avroReader) string() string {
+	n := r.long()
+	if r.err != nil {
+		return ""
+	}
+	if n < 0 || n > int64(len(r.data)) {
+		r.err = fmt.Errorf("invalid avro string length")
+		return ""
+	}
+	s := string(r.data[:n])
+	r.data = r.data[n:]
+	return s
+}
+
+// decodeAvro parses the Avro binary encoding of an event
+func decodeAvro(data []byte) (*APIEvent, error) {
+	r := &avroReader{data: data}
+	e := &APIEvent{}
+	e.SchemaVersion = int(r.long())
+	e.Timestamp = time.UnixMicro(r.long()).UTC()
+	e.EventType = r.string()
+	e.UserID = r.string()
+	e.Service = r.string()
+	e.Path = r.string()
+	e.Method = r.string()
+	e.StatusCode = int(r.long())
+	e.Latency = time.Duration(r.long())
+	e.IPAddress = r.string()
+	e.UserAgent = r.string()
+	for {
+		count := r.long()
+		if r.err != nil || count == 0 {
+			break
+		}
+		// A negative count is followed by the block size in bytes
+		if count < 0 {
+			count = -count
+			r.long()
+		}
+		if e.Metadata == nil {
+			e.Metadata = make(map[string]string)
+		}
+		for i := int64(0); i < count && r.err == nil; i++ {
+			k := r.string()
+			e.Metadata[k] = r.string()
+		}
+	}
+	e.TraceID = r.string()
+	e.SpanID = r.string()
+	if r.err != nil {
+		return nil, r.err
+	}
+	return e, nil
+}
+
+// appendProtobuf appends the protobuf encoding of an event. Zero values
+// are omitted, as in proto3.
+func appendProtobuf(buf []byte, e *APIEvent) []byte {
+	putInt := func(num protowire.Number, v int64) {
+		if v != 0 {
+			buf = protowire.AppendTag(buf, num, protowire.VarintType)
+			buf = protowire.AppendVarint(buf, uint64(v))
+		}
+	}
+	putString := func(num protowire.Number, s string) {
+		if s != "" {
+			buf = protowire.AppendTag(buf, num, protowire.BytesType)
+			buf = protowire.AppendString(buf, s)
+		}
+	}
+
+	putInt(1, int64(e.SchemaVersion))
+	putInt(2, e.Timestamp.UnixMicro())
+	putString(3, e.EventType)
+	putString(4, e.UserID)
+	putString(5, e.Service)
+	putString(6, e.Path)
+	putString(7, e.Method)
+	putInt(8, int64(e.StatusCode))
+	putInt(9, int64(e.Latency))
+	putString(10, e.IPAddress)
+	putString(11, e.UserAgent)
+	for k, v := range e.Metadata {
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, k)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendString(entry, v)
+		buf = protowire.AppendTag(buf, 12, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, entry)
+	}
+	putString(13, e.TraceID)
+	putString(14, e.SpanID)
+	return buf
+}
+
+// decodeProtobuf parses the protobuf encoding of an event. Unknown fields
+// are skipped.
+func decodeProtobuf(data []byte) (*APIEvent, error) {
+	e := &APIEvent{}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var (
+			v uint64
+			b []byte
+		)
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(data)
+		case protowire.BytesType:
+			b, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		switch num {
+		case 1:
+			e.SchemaVersion = int(int32(v))
+		case 2:
+			e.Timestamp = time.UnixMicro(int64(v)).UTC()
+		case 3:
+			e.EventType = string(b)
+		case 4:
+			e.UserID = string(b)
+		case 5:
+			e.Service = string(b)
+		case 6:
+			e.Path = string(b)
+		case 7:
+			e.Method = string(b)
+		case 8:
+			e.StatusCode = int(int32(v))
+		case 9:
+			e.Latency = time.Duration(int64(v))
+		case 10:
+			e.IPAddress = string(b)
+		case 11:
+			e.UserAgent = string(b)
+		case 12:
+			k, val, err := decodeMapEntry(b)
+			if err != nil {
+				return nil, err
+			}
+			if e.Metadata == nil {
+				e.Metadata = make(map[string]string)
+			}
+			e.Metadata[k] = val
+		case 13:
+			e.TraceID = string(b)
+		case 14:
+			e.SpanID = string(b)
+		}
+	}
+	return e, nil
+}
+
+// decodeMapEntry parses a map<string, string> entry
+func decodeMapEntry(data []byte) (key, value string, err error) {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 || typ != protowire.BytesType {
+			return "", "", fmt.Errorf("invalid metadata entry")
+		}
+		data = data[n:]
+		b, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		data = data[n:]
+		switch num {
+		case 1:
+			key = string(b)
+		case 2:
+			value = string(b)
+		}
+	}
+	return key, value, nil
+}
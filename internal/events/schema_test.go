@@ -0,0 +1,130 @@
+package events
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	event := &APIEvent{
+		SchemaVersion: SchemaVersion,
+		Timestamp:     time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.UTC),
+		EventType:     EventTypeAuditLog,
+		UserID:        "user-1",
+		Service:       "orders",
+		Path:          "/orders/42",
+		Method:        http.MethodDelete,
+		StatusCode:    403,
+		Latency:       1500 * time.Microsecond,
+		IPAddress:     "10.0.0.1",
+		UserAgent:     "curl/8.0",
+		Metadata:      map[string]string{"reason": "missing role", "role": "admin"},
+		TraceID:       "4bf92f3577b34da6a3ce929d0e0e4736",
+	}
+
+	for _, encoding := range []string{EncodingJSON, EncodingAvro, EncodingProtobuf} {
+		c := &codec{encoding: encoding, schemaID: 7}
+		data, err := c.encode(event)
+		if err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+		decoded, err := c.decode(data)
+		if err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+		if !reflect.DeepEqual(decoded, event) {
+			t.Errorf("%s: decoded %+v, want %+v", encoding, decoded, event)
+		}
+
+		if encoding != EncodingJSON {
+			if _, err := (&codec{encoding: encoding, schemaID: 8}).decode(data); err == nil {
+				t.Errorf("%s: payload of another schema decoded", encoding)
+			}
+		}
+	}
+
+	// JSON events are still read after switching to a binary encoding
+	data, _ := json.Marshal(event)
+	if _, err := (&codec{encoding: EncodingAvro, schemaID: 7}).decode(data); err != nil {
+		t.Errorf("JSON event rejected: %v", err)
+	}
+}
+
+func TestAvroSchemaCoversEvent(t *testing.T) {
+	var schema struct {
+		Fields []struct {
+			Name string `json:"name"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal([]byte(avroSchema), &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	eventType := reflect.TypeOf(APIEvent{})
+	if len(schema.Fields) != eventType.NumField() {
+		t.Fatalf("schema has %d fields, APIEvent has %d", len(schema.Fields), eventType.NumField())
+	}
+	// Fields must follow the encoding order of appendAvro
+	for i, field := range schema.Fields {
+		name := strings.Split(eventType.Field(i).Tag.Get("json"), ",")[0]
+		if field.Name != name {
+			t.Errorf("schema field %d is %q, want %q", i, field.Name, name)
+		}
+	}
+}
+
+func TestInitSchemas(t *testing.T) {
+	var registered []string
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["schemaType"] != "PROTOBUF" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/compatibility/subjects/api-events-value/"):
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error_code":40401,"message":"Subject not found"}`))
+		case strings.HasPrefix(r.URL.Path, "/compatibility/subjects/audit-logs-value/"):
+			w.Write([]byte(`{"is_compatible":false,"messages":["field 3 changed type"]}`))
+		case strings.HasPrefix(r.URL.Path, "/subjects/"):
+			registered = append(registered, r.URL.Path)
+			w.Write([]byte(`{"id":12}`))
+		}
+	}))
+	defer registry.Close()
+
+	ep := &EventProcessor{
+		config: &EventConfig{
+			Encoding: EncodingProtobuf,
+			Kafka: KafkaConfig{
+				Topics:         map[string]string{"api_events": "api-events"},
+				SchemaRegistry: SchemaRegistryConfig{URL: registry.URL, AutoRegister: true},
+			},
+		},
+		logger: zap.NewNop(),
+	}
+	if err := ep.initSchemas(); err != nil {
+		t.Fatal(err)
+	}
+	if c := ep.codecs["api-events"]; c == nil || c.schemaID != 12 {
+		t.Errorf("codec = %+v, want schema 12", c)
+	}
+	if len(registered) != 1 || registered[0] != "/subjects/api-events-value/versions" {
+		t.Errorf("registered %v", registered)
+	}
+
+	// Incompatible schemas fail startup
+	ep.config.Kafka.Topics["audit_logs"] = "audit-logs"
+	if err := ep.initSchemas(); err == nil || !strings.Contains(err.Error(), "field 3 changed type") {
+		t.Errorf("err = %v, want incompatible schema", err)
+	}
+}
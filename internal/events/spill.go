@@ -0,0 +1,238 @@
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// spillSegmentBytes is the size at which the spill log starts a new
+	// segment, so published events free disk space
+	spillSegmentBytes = 16 << 20
+	spillSuffix       = ".wal"
+)
+
+// errSpillFull is returned when the spill log reached its size limit
+var errSpillFull = errors.New("spill log full")
+
+// spillLog is an append-only log of events on disk, one JSON event per
+// line, split into numbered segments. Segments are deleted once all their
+// events are published. The read position is not persisted, so events of
+// a partly published segment are published again after a restart.
+type spillLog struct {
+	dir      string
+	maxBytes int64
+	logger   *zap.Logger
+
+	segments []uint64 // oldest first; the last one is written to
+	size     int64    // bytes on disk
+	pending  int      // events not yet published
+
+	writer     *os.File
+	writerSize int64
+	nextSeq    uint64
+
+	reader     *bufio.Reader
+	readerFile *os.File
+	peeked     *APIEvent
+}
+
+// openSpillLog opens the spill log in dir, counting the events left by a
+// previous run
+func openSpillLog(dir string, maxBytes int64, logger *zap.Logger) (*spillLog, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spill directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spill directory: %w", err)
+	}
+
+	l := &spillLog{dir: dir, maxBytes: maxBytes, logger: logger}
+	for _, entry := range entries {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), spillSuffix), 10, 64)
+		if err != nil || !strings.HasSuffix(entry.Name(), spillSuffix) {
+			continue
+		}
+		data, err := os.ReadFile(l.path(seq))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read spill segment: %w", err)
+		}
+		l.segments = append(l.segments, seq)
+		l.size += int64(len(data))
+		// A torn last line has no newline and is skipped when read
+		l.pending += bytes.Count(data, []byte{'\n'})
+	}
+	sort.Slice(l.segments, func(i, j int) bool { return l.segments[i] < l.segments[j] })
+	if n := len(l.segments); n > 0 {
+		l.nextSeq = l.segments[n-1] + 1
+	}
+	if l.pending == 0 {
+		l.reset()
+	}
+	return l, nil
+}
+
+// path returns the file of a segment
+func (l *spillLog) path(seq uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d%s", seq, spillSuffix))
+}
+
+// append writes an event to the newest segment
+func (l *spillLog) append(event *APIEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if l.size+int64(len(data)) > l.maxBytes {
+		return errSpillFull
+	}
+
+	if l.writer == nil || l.writerSize+int64(len(data)) > spillSegmentBytes {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.writer.Write(data)
+	l.size += int64(n)
+	l.writerSize += int64(n)
+	if err != nil {
+		// Later events go to a new segment, after the torn line
+		l.writer.Close()
+		l.writer = nil
+		return fmt.Errorf("failed to write spill segment: %w", err)
+	}
+	l.pending++
+	return nil
+}
+
+// rotate starts a new segment. Events of a previous run are never
+// appended to, as their last line may be torn.
+func (l *spillLog) rotate() error {
+	if l.writer != nil {
+		l.writer.Sync()
+		l.writer.Close()
+	}
+	seq := l.nextSeq
+	f, err := os.OpenFile(l.path(seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		l.writer = nil
+		return fmt.Errorf("failed to create spill segment: %w", err)
+	}
+	l.nextSeq++
+	l.segments = append(l.segments, seq)
+	l.writer, l.writerSize = f, 0
+	return nil
+}
+
+// peek returns the oldest unpublished event. Lines that cannot be decoded
+// are skipped.
+func (l *spillLog) peek() *APIEvent {
+	for l.peeked == nil && l.pending > 0 && len(l.segments) > 0 {
+		if l.reader == nil {
+			f, err := os.Open(l.path(l.segments[0]))
+			if err != nil {
+				l.logger.Error("Failed to open spill segment, skipping it", zap.Error(err))
+				l.dropSegment()
+				continue
+			}
+			l.readerFile, l.reader = f, bufio.NewReader(f)
+		}
+
+		line, err := l.reader.ReadBytes('\n')
+		if err == io.EOF {
+			// The segment being written may still grow
+			if len(l.segments) == 1 && l.writer != nil {
+				return nil
+			}
+			l.dropSegment()
+			continue
+		}
+		if err != nil {
+			l.logger.Error("Failed to read spill segment, skipping it", zap.Error(err))
+			l.dropSegment()
+			continue
+		}
+
+		var event APIEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			l.logger.Error("Skipping invalid spilled event", zap.Error(err))
+			l.pending--
+			continue
+		}
+		l.peeked = &event
+	}
+	return l.peeked
+}
+
+// advance removes the peeked event. Once every event is published the
+// segments are deleted.
+func (l *spillLog) advance() {
+	l.peeked = nil
+	l.pending--
+	if l.pending <= 0 {
+		l.reset()
+	}
+}
+
+// dropSegment deletes the oldest segment after it was read
+func (l *spillLog) dropSegment() {
+	if l.readerFile != nil {
+		l.readerFile.Close()
+		l.readerFile, l.reader = nil, nil
+	}
+	seq := l.segments[0]
+	if info, err := os.Stat(l.path(seq)); err == nil {
+		l.size -= info.Size()
+	}
+	os.Remove(l.path(seq))
+	l.segments = l.segments[1:]
+	if len(l.segments) == 0 && l.writer != nil {
+		l.writer.Close()
+		l.writer = nil
+	}
+}
+
+// reset deletes every segment once nothing is pending
+func (l *spillLog) reset() {
+	if l.readerFile != nil {
+		l.readerFile.Close()
+		l.readerFile, l.reader = nil, nil
+	}
+	if l.writer != nil {
+		l.writer.Close()
+		l.writer = nil
+	}
+	for _, seq := range l.segments {
+		os.Remove(l.path(seq))
+	}
+	l.segments = nil
+	l.size, l.pending, l.peeked = 0, 0, nil
+}
+
+// close flushes the segment being written
+func (l *spillLog) close() error {
+	if l.readerFile != nil {
+		l.readerFile.Close()
+	}
+	if l.writer == nil {
+		return nil
+	}
+	if err := l.writer.Sync(); err != nil {
+		l.writer.Close()
+		return fmt.Errorf("failed to sync spill segment: %w", err)
+	}
+	return l.writer.Close()
+}
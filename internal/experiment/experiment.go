@@ -0,0 +1,175 @@
+package experiment
+
+import (
+	"hash/fnv"
+	"net/url"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// HeaderPrefix prefixes the headers carrying assignments upstream
+const HeaderPrefix = "X-Experiment-"
+
+// visitorKey holds the visitor ID in the cookie alongside the assignments
+const visitorKey = "_id"
+
+// Assignment is the variant a user was assigned in an experiment
+type Assignment struct {
+	Experiment string
+	Variant    string
+}
+
+// experiment is a compiled experiment definition
+type experiment struct {
+	cfg      config.ExperimentConfig
+	services map[string]bool
+	variants map[string]bool
+}
+
+// Set assigns users to the variants of the configured experiments
+type Set struct {
+	experiments []experiment
+	names       map[string]bool
+}
+
+// NewSet compiles experiment definitions
+func NewSet(definitions []config.ExperimentConfig) *Set {
+	s := &Set{
+		experiments: make([]experiment, 0, len(definitions)),
+		names:       make(map[string]bool, len(definitions)),
+	}
+	for _, cfg := range definitions {
+		s.names[cfg.Name] = true
+		e := experiment{cfg: cfg, variants: make(map[string]bool, len(cfg.Variants))}
+		if len(cfg.Services) > 0 {
+			e.services = make(map[string]bool, len(cfg.Services))
+			for _, service := range cfg.Services {
+				e.services[service] = true
+			}
+		}
+		for _, variant := range cfg.Variants {
+			e.variants[variant.Name] = true
+		}
+		s.experiments = append(s.experiments, e)
+	}
+	return s
+}
+
+// Applies reports whether any experiment enrolls requests to a service
+func (s *Set) Applies(service string) bool {
+	for _, e := range s.experiments {
+		if e.services == nil || e.services[service] {
+			return true
+		}
+	}
+	return false
+}
+
+// Assign returns the assignments of a user in the experiments that apply to
+// a service. Variants recorded in the cookie state are kept while they
+// still exist, so assignments survive changes to the weights; otherwise
+// the variant is picked by hashing the unit ID. Experiments with a flag
+// only enroll users while active reports the flag on; a nil active skips
+// the check.
+func (s *Set) Assign(service, unitID string, state *State, active func(flag string) bool) []Assignment {
+	var assignments []Assignment
+	for _, e := range s.experiments {
+		if e.services != nil && !e.services[service] {
+			continue
+		}
+		if e.cfg.Flag != "" && active != nil && !active(e.cfg.Flag) {
+			continue
+		}
+
+		name := e.cfg.Name
+		variant, persisted := state.variants[name]
+		if !persisted || !e.variants[variant] {
+			variant = pick(e.cfg, unitID)
+		}
+		if variant == "" {
+			continue
+		}
+
+		assignments = append(assignments, Assignment{Experiment: name, Variant: variant})
+		if state.variants[name] != variant {
+			state.variants[name] = variant
+			state.changed = true
+		}
+	}
+	return assignments
+}
+
+// pick returns the variant a unit hashes into, or "" if the unit falls
+// outside the experiment's traffic
+func pick(cfg config.ExperimentConfig, unitID string) string {
+	bucket := Bucket(cfg.Name, unitID)
+	for _, variant := range cfg.Variants {
+		if bucket < variant.Weight {
+			return variant.Name
+		}
+		bucket -= variant.Weight
+	}
+	return ""
+}
+
+// Bucket hashes a unit into one of 100 buckets of an experiment. Hashing
+// with the experiment name keeps assignments independent across
+// experiments.
+func Bucket(experimentName, unitID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(experimentName))
+	h.Write([]byte{0})
+	h.Write([]byte(unitID))
+	return int(h.Sum32() % 100)
+}
+
+// State is the visitor ID and assignments kept in the experiment cookie
+type State struct {
+	VisitorID string
+	variants  map[string]string
+	changed   bool
+}
+
+// ParseState decodes the experiment cookie, dropping experiments that no
+// longer exist; an invalid or empty value gives an empty state
+func (s *Set) ParseState(value string) *State {
+	state := &State{variants: make(map[string]string)}
+	values, err := url.ParseQuery(value)
+	if err != nil {
+		return state
+	}
+	for key := range values {
+		switch {
+		case key == visitorKey:
+			state.VisitorID = values.Get(key)
+		case s.names[key]:
+			state.variants[key] = values.Get(key)
+		default:
+			state.changed = true
+		}
+	}
+	return state
+}
+
+// SetVisitorID records a new visitor ID
+func (st *State) SetVisitorID(id string) {
+	st.VisitorID = id
+	st.changed = true
+}
+
+// Changed reports whether the cookie needs to be written
+func (st *State) Changed() bool {
+	return st.changed
+}
+
+// Encode encodes the state as a cookie value
+func (st *State) Encode() string {
+	values := make(url.Values, len(st.variants)+1)
+	if st.VisitorID != "" {
+		values.Set(visitorKey, st.VisitorID)
+	}
+	for name, variant := range st.variants {
+		values.Set(name, variant)
+	}
+	return values.Encode()
+}
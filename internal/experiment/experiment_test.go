@@ -0,0 +1,65 @@
+package experiment
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestAssign(t *testing.T) {
+	set := NewSet([]config.ExperimentConfig{
+		{
+			Name:     "checkout",
+			Services: []string{"order_service"},
+			Variants: []config.ExperimentVariantConfig{{Name: "control", Weight: 40}, {Name: "one-page", Weight: 40}},
+		},
+	})
+
+	if set.Applies("user_service") {
+		t.Error("experiment applies to a service it does not list")
+	}
+
+	// Variants get roughly their share of traffic, the rest is not enrolled
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		assignments := set.Assign("order_service", fmt.Sprintf("user:%d", i), set.ParseState(""), nil)
+		if len(assignments) == 0 {
+			counts[""]++
+			continue
+		}
+		counts[assignments[0].Variant]++
+	}
+	for variant, want := range map[string]int{"control": 4000, "one-page": 4000, "": 2000} {
+		if got := counts[variant]; got < want-300 || got > want+300 {
+			t.Errorf("%q assigned %d times, want about %d", variant, got, want)
+		}
+	}
+
+	// Assignment is deterministic and persisted in the cookie state
+	state := set.ParseState("")
+	first := set.Assign("order_service", "user:7", state, nil)
+	again := set.Assign("order_service", "user:7", set.ParseState(""), nil)
+	if fmt.Sprint(first) != fmt.Sprint(again) {
+		t.Errorf("assignments differ for the same user: %v and %v", first, again)
+	}
+
+	state = set.ParseState("_id=abc&checkout=one-page&retired=b")
+	if state.VisitorID != "abc" {
+		t.Errorf("VisitorID = %q, want abc", state.VisitorID)
+	}
+	assignments := set.Assign("order_service", "user:7", state, nil)
+	if len(assignments) != 1 || assignments[0].Variant != "one-page" {
+		t.Errorf("assignments with a persisted variant = %v, want one-page", assignments)
+	}
+	if !state.Changed() || state.Encode() != "_id=abc&checkout=one-page" {
+		t.Errorf("state = %q, changed = %v, want the retired experiment dropped", state.Encode(), state.Changed())
+	}
+
+	// A persisted variant that no longer exists is reassigned
+	state = set.ParseState("checkout=gone")
+	assignments = set.Assign("order_service", "user:1", state, nil)
+	if len(assignments) == 1 && assignments[0].Variant == "gone" {
+		t.Error("removed variant was kept")
+	}
+}
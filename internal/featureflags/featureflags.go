@@ -0,0 +1,158 @@
+package featureflags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// Evaluation reasons set by the gateway; remote providers report their own
+const (
+	// ReasonStatic is a value of the static provider
+	ReasonStatic = "STATIC"
+	// ReasonDefault is a flag the provider does not know, using its default
+	ReasonDefault = "DEFAULT"
+	// ReasonError is a failed evaluation, using the flag's default
+	ReasonError = "ERROR"
+)
+
+// ErrFlagNotFound is returned by providers for a flag they do not know
+var ErrFlagNotFound = errors.New("flag not found")
+
+// EvaluationContext describes the request a flag is evaluated for.
+// TargetingKey identifies the user, so percentage rollouts are sticky.
+type EvaluationContext struct {
+	TargetingKey string
+	Attributes   map[string]string
+}
+
+// Evaluation is the value of a flag for a request
+type Evaluation struct {
+	Flag    string `json:"flag"`
+	Enabled bool   `json:"enabled"`
+	Variant string `json:"variant,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Provider evaluates boolean flags
+type Provider interface {
+	Evaluate(ctx context.Context, flag string, ec EvaluationContext) (Evaluation, error)
+}
+
+// Client evaluates flags with a provider, falling back to the configured
+// defaults when a flag cannot be evaluated
+type Client struct {
+	provider Provider
+	defaults map[string]bool
+	logger   *zap.Logger
+}
+
+// New creates a client for the configured provider
+func New(cfg config.FeatureFlagsConfig, logger *zap.Logger) (*Client, error) {
+	var provider Provider
+	switch cfg.Provider {
+	case "static":
+		provider = staticProvider(cfg.Static)
+	case "ofrep":
+		provider = newOFREPProvider(cfg.OFREP)
+	default:
+		return nil, fmt.Errorf("unknown feature flag provider: %s", cfg.Provider)
+	}
+	return NewClient(provider, cfg.Defaults, logger), nil
+}
+
+// NewClient creates a client for a provider
+func NewClient(provider Provider, defaults map[string]bool, logger *zap.Logger) *Client {
+	return &Client{provider: provider, defaults: defaults, logger: logger}
+}
+
+// Evaluate returns the value of a flag for a request
+func (c *Client) Evaluate(ctx context.Context, flag string, ec EvaluationContext) Evaluation {
+	evaluation, err := c.provider.Evaluate(ctx, flag, ec)
+	if err != nil {
+		reason := ReasonDefault
+		if !errors.Is(err, ErrFlagNotFound) {
+			reason = ReasonError
+			c.logger.Warn("Failed to evaluate feature flag, using its default",
+				zap.String("flag", flag),
+				zap.Error(err))
+		}
+		// Viper lowercases the keys of the defaults
+		return Evaluation{Flag: flag, Enabled: c.defaults[strings.ToLower(flag)], Reason: reason}
+	}
+	evaluation.Flag = flag
+	return evaluation
+}
+
+// staticProvider serves the flag values of the configuration
+type staticProvider map[string]bool
+
+func (p staticProvider) Evaluate(ctx context.Context, flag string, ec EvaluationContext) (Evaluation, error) {
+	enabled, ok := p[strings.ToLower(flag)]
+	if !ok {
+		return Evaluation{}, ErrFlagNotFound
+	}
+	return Evaluation{Flag: flag, Enabled: enabled, Reason: ReasonStatic}, nil
+}
+
+// contextKey is the context key of a request's flag state
+type contextKey struct{}
+
+// requestFlags evaluates each flag once per request and keeps the results
+type requestFlags struct {
+	client *Client
+	ec     EvaluationContext
+
+	mu          sync.Mutex
+	evaluations []Evaluation
+}
+
+// NewContext returns a context evaluating flags for a request with client
+func NewContext(ctx context.Context, client *Client, ec EvaluationContext) context.Context {
+	return context.WithValue(ctx, contextKey{}, &requestFlags{client: client, ec: ec})
+}
+
+// Enabled evaluates a flag for the request of ctx. Flags are off in
+// contexts without a client.
+func Enabled(ctx context.Context, flag string) bool {
+	state, _ := ctx.Value(contextKey{}).(*requestFlags)
+	if state == nil {
+		return false
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	for _, evaluation := range state.evaluations {
+		if evaluation.Flag == flag {
+			return evaluation.Enabled
+		}
+	}
+	evaluation := state.client.Evaluate(ctx, flag, state.ec)
+	state.evaluations = append(state.evaluations, evaluation)
+	return evaluation.Enabled
+}
+
+// Evaluations returns the flags evaluated for the request of ctx, in the
+// order they were first evaluated
+func Evaluations(ctx context.Context) []Evaluation {
+	state, _ := ctx.Value(contextKey{}).(*requestFlags)
+	if state == nil {
+		return nil
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return append([]Evaluation(nil), state.evaluations...)
+}
+
+// HasContext reports whether ctx already evaluates flags, since route
+// groups repeat the default chain
+func HasContext(ctx context.Context) bool {
+	_, ok := ctx.Value(contextKey{}).(*requestFlags)
+	return ok
+}
@@ -0,0 +1,117 @@
+package featureflags
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestStaticFlags(t *testing.T) {
+	client, err := New(config.FeatureFlagsConfig{
+		Provider: "static",
+		Static:   map[string]bool{"new-checkout": true, "legacy-soap": false},
+		Defaults: map[string]bool{"unlisted": true},
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := NewContext(context.Background(), client, EvaluationContext{TargetingKey: "user:1"})
+	if !Enabled(ctx, "New-Checkout") {
+		t.Error("new-checkout is off, want on")
+	}
+	if Enabled(ctx, "legacy-soap") {
+		t.Error("legacy-soap is on, want off")
+	}
+	if !Enabled(ctx, "unlisted") {
+		t.Error("unlisted flag ignored its default")
+	}
+	Enabled(ctx, "New-Checkout")
+
+	want := []Evaluation{
+		{Flag: "New-Checkout", Enabled: true, Reason: ReasonStatic},
+		{Flag: "legacy-soap", Enabled: false, Reason: ReasonStatic},
+		{Flag: "unlisted", Enabled: true, Reason: ReasonDefault},
+	}
+	got := Evaluations(ctx)
+	if len(got) != len(want) {
+		t.Fatalf("Evaluations() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Evaluations()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if Enabled(context.Background(), "new-checkout") {
+		t.Error("flag is on without a client")
+	}
+}
+
+func TestOFREPFlags(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body struct {
+			Context map[string]string `json:"context"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		switch r.URL.Path {
+		case "/ofrep/v1/evaluate/flags/beta":
+			enabled := body.Context["targetingKey"] == "user:1"
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"key": "beta", "value": enabled, "variant": "on", "reason": "TARGETING_MATCH",
+			})
+		case "/ofrep/v1/evaluate/flags/color":
+			json.NewEncoder(w).Encode(map[string]interface{}{"key": "color", "value": "blue"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"key": "missing", "errorCode": "FLAG_NOT_FOUND"})
+		}
+	}))
+	defer server.Close()
+
+	client, err := New(config.FeatureFlagsConfig{
+		Provider: "ofrep",
+		OFREP:    config.OFREPConfig{Endpoint: server.URL, Token: "secret", Timeout: time.Second, CacheTTL: time.Minute, MaxCacheEntries: 10},
+		Defaults: map[string]bool{"color": true},
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := EvaluationContext{TargetingKey: "user:1", Attributes: map[string]string{"path": "/orders"}}
+	if got := client.Evaluate(context.Background(), "beta", user); !got.Enabled || got.Variant != "on" || got.Reason != "TARGETING_MATCH" {
+		t.Errorf("Evaluate(beta) = %+v, want on by targeting", got)
+	}
+	if got := client.Evaluate(context.Background(), "beta", EvaluationContext{TargetingKey: "user:2"}); got.Enabled {
+		t.Errorf("Evaluate(beta) for another user = %+v, want off", got)
+	}
+
+	// Repeated evaluations are served from the cache
+	before := calls.Load()
+	client.Evaluate(context.Background(), "beta", user)
+	if calls.Load() != before {
+		t.Error("cached evaluation called the flag service")
+	}
+
+	if got := client.Evaluate(context.Background(), "missing", user); got.Enabled || got.Reason != ReasonDefault {
+		t.Errorf("Evaluate(missing) = %+v, want off by default", got)
+	}
+	if got := client.Evaluate(context.Background(), "color", user); !got.Enabled || got.Reason != ReasonError {
+		t.Errorf("Evaluate(color) = %+v, want its default after a type error", got)
+	}
+}
@@ -0,0 +1,157 @@
+package featureflags
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// maxOFREPResponse bounds an evaluation response
+const maxOFREPResponse = 64 << 10
+
+// ofrepProvider evaluates flags with the OpenFeature Remote Evaluation
+// Protocol: POST /ofrep/v1/evaluate/flags/{key} with the evaluation context
+type ofrepProvider struct {
+	endpoint string
+	token    string
+	client   *http.Client
+
+	ttl        time.Duration
+	maxEntries int
+	mu         sync.Mutex
+	cache      map[string]cachedEvaluation
+}
+
+// cachedEvaluation is an evaluation kept for the cache TTL
+type cachedEvaluation struct {
+	evaluation Evaluation
+	expires    time.Time
+}
+
+// ofrepResponse is a successful or failed OFREP evaluation
+type ofrepResponse struct {
+	Key          string      `json:"key"`
+	Value        interface{} `json:"value"`
+	Reason       string      `json:"reason"`
+	Variant      string      `json:"variant"`
+	ErrorCode    string      `json:"errorCode"`
+	ErrorDetails string      `json:"errorDetails"`
+}
+
+func newOFREPProvider(cfg config.OFREPConfig) *ofrepProvider {
+	return &ofrepProvider{
+		endpoint:   strings.TrimSuffix(cfg.Endpoint, "/"),
+		token:      cfg.Token,
+		client:     &http.Client{Timeout: cfg.Timeout},
+		ttl:        cfg.CacheTTL,
+		maxEntries: cfg.MaxCacheEntries,
+		cache:      make(map[string]cachedEvaluation),
+	}
+}
+
+func (p *ofrepProvider) Evaluate(ctx context.Context, flag string, ec EvaluationContext) (Evaluation, error) {
+	key := cacheKey(flag, ec)
+	if p.ttl > 0 {
+		p.mu.Lock()
+		cached, ok := p.cache[key]
+		p.mu.Unlock()
+		if ok && time.Now().Before(cached.expires) {
+			return cached.evaluation, nil
+		}
+	}
+
+	evaluation, err := p.fetch(ctx, flag, ec)
+	if err != nil {
+		return Evaluation{}, err
+	}
+
+	if p.ttl > 0 {
+		p.mu.Lock()
+		// Dropping everything keeps the cache bounded without tracking use
+		if len(p.cache) >= p.maxEntries {
+			p.cache = make(map[string]cachedEvaluation)
+		}
+		p.cache[key] = cachedEvaluation{evaluation: evaluation, expires: time.Now().Add(p.ttl)}
+		p.mu.Unlock()
+	}
+	return evaluation, nil
+}
+
+// fetch evaluates a flag on the OFREP service
+func (p *ofrepProvider) fetch(ctx context.Context, flag string, ec EvaluationContext) (Evaluation, error) {
+	evalContext := make(map[string]string, len(ec.Attributes)+1)
+	for name, value := range ec.Attributes {
+		evalContext[name] = value
+	}
+	evalContext["targetingKey"] = ec.TargetingKey
+	body, err := json.Marshal(map[string]interface{}{"context": evalContext})
+	if err != nil {
+		return Evaluation{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		p.endpoint+"/ofrep/v1/evaluate/flags/"+url.PathEscape(flag), bytes.NewReader(body))
+	if err != nil {
+		return Evaluation{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return Evaluation{}, err
+	}
+	defer resp.Body.Close()
+
+	var result ofrepResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxOFREPResponse)).Decode(&result); err != nil && resp.StatusCode == http.StatusOK {
+		return Evaluation{}, fmt.Errorf("invalid evaluation response: %w", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound || result.ErrorCode == "FLAG_NOT_FOUND":
+		return Evaluation{}, ErrFlagNotFound
+	case resp.StatusCode != http.StatusOK && result.ErrorCode != "":
+		return Evaluation{}, fmt.Errorf("%s: %s", result.ErrorCode, result.ErrorDetails)
+	case resp.StatusCode != http.StatusOK:
+		return Evaluation{}, fmt.Errorf("flag service returned %s", resp.Status)
+	}
+
+	enabled, ok := result.Value.(bool)
+	if !ok {
+		return Evaluation{}, fmt.Errorf("flag is not a boolean")
+	}
+	return Evaluation{Flag: flag, Enabled: enabled, Variant: result.Variant, Reason: result.Reason}, nil
+}
+
+// cacheKey identifies an evaluation of a flag for a context
+func cacheKey(flag string, ec EvaluationContext) string {
+	names := make([]string, 0, len(ec.Attributes))
+	for name := range ec.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(flag)
+	b.WriteByte(0)
+	b.WriteString(ec.TargetingKey)
+	for _, name := range names {
+		b.WriteByte(0)
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(ec.Attributes[name])
+	}
+	return b.String()
+}
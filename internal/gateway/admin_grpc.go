@@ -0,0 +1,351 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	adminv1 "github.com/max/api-gateway/api/admin/v1"
+	"github.com/max/api-gateway/internal/cluster"
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/internal/proxy"
+)
+
+// Stats streaming intervals
+const (
+	defaultStatsInterval = 5 * time.Second
+	minStatsInterval     = time.Second
+)
+
+// adminGRPCPermissions are the admin permissions the gRPC methods require
+var adminGRPCPermissions = map[string]string{
+	adminv1.AdminService_ListServices_FullMethodName:        config.PermServicesRead,
+	adminv1.AdminService_UpsertService_FullMethodName:       config.PermServicesWrite,
+	adminv1.AdminService_DeleteService_FullMethodName:       config.PermServicesWrite,
+	adminv1.AdminService_SetTargetWeight_FullMethodName:     config.PermServicesWrite,
+	adminv1.AdminService_ListCircuitBreakers_FullMethodName: config.PermBreakersRead,
+	adminv1.AdminService_ResetCircuitBreaker_FullMethodName: config.PermBreakersReset,
+	adminv1.AdminService_ResetRateLimit_FullMethodName:      config.PermRateLimitsReset,
+	adminv1.AdminService_ReloadConfig_FullMethodName:        config.PermConfigWrite,
+	adminv1.AdminService_PushConfig_FullMethodName:          config.PermConfigWrite,
+	adminv1.AdminService_StreamStats_FullMethodName:         config.PermStatsRead,
+}
+
+// adminServer implements the gRPC admin API on top of the same components
+// as the HTTP admin routes
+type adminServer struct {
+	adminv1.UnimplementedAdminServiceServer
+	g *Gateway
+}
+
+// NewAdminGRPCServer creates a gRPC server exposing the admin API. Every
+// call must carry an admin JWT in the authorization metadata.
+func (g *Gateway) NewAdminGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.UnaryInterceptor(g.adminUnaryAuth),
+		grpc.StreamInterceptor(g.adminStreamAuth),
+	)
+	server := grpc.NewServer(opts...)
+	adminv1.RegisterAdminServiceServer(server, &adminServer{g: g})
+	return server
+}
+
+// adminUnaryAuth authenticates unary admin calls
+func (g *Gateway) adminUnaryAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := g.authorizeAdminCall(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// adminStreamAuth authenticates streaming admin calls
+func (g *Gateway) adminStreamAuth(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := g.authorizeAdminCall(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// authorizeAdminCall validates the bearer token and requires the admin
+// permission of the method
+func (g *Gateway) authorizeAdminCall(ctx context.Context, method string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return status.Error(codes.Unauthenticated, "authorization metadata required")
+	}
+
+	token, err := g.jwtAuth.ExtractTokenFromHeader(values[0])
+	if err != nil {
+		return status.Error(codes.Unauthenticated, "invalid authorization metadata")
+	}
+	claims, err := g.jwtAuth.ValidateToken(token)
+	if err != nil {
+		return status.Error(codes.Unauthenticated, "invalid token")
+	}
+	permission, ok := adminGRPCPermissions[method]
+	if !ok {
+		return status.Error(codes.PermissionDenied, "unknown admin method")
+	}
+	var clientIP string
+	if p, ok := peer.FromContext(ctx); ok {
+		clientIP, _, _ = net.SplitHostPort(p.Addr.String())
+	}
+	if !g.middlewareManager.AuthorizeAdmin(claims, permission, method, clientIP) {
+		return status.Errorf(codes.PermissionDenied, "%s permission required", permission)
+	}
+
+	g.logger.Info("Admin API call",
+		zap.String("method", method),
+		zap.String("user", claims.Username))
+	return nil
+}
+
+// ListServices returns the registered services with their targets
+func (s *adminServer) ListServices(ctx context.Context, req *adminv1.ListServicesRequest) (*adminv1.ListServicesResponse, error) {
+	return &adminv1.ListServicesResponse{Services: s.services()}, nil
+}
+
+// UpsertService creates or replaces a service
+func (s *adminServer) UpsertService(ctx context.Context, req *adminv1.UpsertServiceRequest) (*adminv1.UpsertServiceResponse, error) {
+	if req.GetName() == "" || req.GetConfig() == nil {
+		return nil, status.Error(codes.InvalidArgument, "name and config are required")
+	}
+
+	serviceConfig := serviceConfigFromProto(req.GetConfig())
+	if err := s.g.proxyManager.UpdateService(req.GetName(), &serviceConfig); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	s.g.announceServiceUpdate(req.GetName(), &serviceConfig)
+	return &adminv1.UpsertServiceResponse{}, nil
+}
+
+// DeleteService removes a service
+func (s *adminServer) DeleteService(ctx context.Context, req *adminv1.DeleteServiceRequest) (*adminv1.DeleteServiceResponse, error) {
+	if s.g.proxyManager.GetProxy(req.GetName()) == nil {
+		return nil, status.Errorf(codes.NotFound, "service not found: %s", req.GetName())
+	}
+	s.g.proxyManager.RemoveService(req.GetName())
+	s.g.announceServiceDelete(req.GetName())
+	return &adminv1.DeleteServiceResponse{}, nil
+}
+
+// SetTargetWeight changes the load balancing weight of a service target
+func (s *adminServer) SetTargetWeight(ctx context.Context, req *adminv1.SetTargetWeightRequest) (*adminv1.SetTargetWeightResponse, error) {
+	if req.GetWeight() < 1 {
+		return nil, status.Error(codes.InvalidArgument, "weight must be at least 1")
+	}
+
+	serviceProxy := s.g.proxyManager.GetProxy(req.GetService())
+	if serviceProxy == nil {
+		return nil, status.Errorf(codes.NotFound, "service not found: %s", req.GetService())
+	}
+
+	if err := serviceProxy.SetTargetWeight(req.GetUrl(), int(req.GetWeight())); err != nil {
+		code := codes.InvalidArgument
+		switch {
+		case errors.Is(err, proxy.ErrTargetNotFound):
+			code = codes.NotFound
+		case errors.Is(err, proxy.ErrWeightsUnsupported):
+			code = codes.FailedPrecondition
+		}
+		return nil, status.Error(code, err.Error())
+	}
+	s.g.announceTargetWeight(req.GetService(), req.GetUrl(), int(req.GetWeight()))
+
+	return &adminv1.SetTargetWeightResponse{Targets: targetsToProto(serviceProxy.Targets())}, nil
+}
+
+// ListCircuitBreakers returns the state of every circuit breaker
+func (s *adminServer) ListCircuitBreakers(ctx context.Context, req *adminv1.ListCircuitBreakersRequest) (*adminv1.ListCircuitBreakersResponse, error) {
+	return &adminv1.ListCircuitBreakersResponse{CircuitBreakers: s.circuitBreakers()}, nil
+}
+
+// ResetCircuitBreaker closes a circuit breaker
+func (s *adminServer) ResetCircuitBreaker(ctx context.Context, req *adminv1.ResetCircuitBreakerRequest) (*adminv1.ResetCircuitBreakerResponse, error) {
+	if err := s.g.circuitManager.ResetBreaker(req.GetName()); err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	s.g.announceBreakerReset(req.GetName())
+	return &adminv1.ResetCircuitBreakerResponse{}, nil
+}
+
+// ResetRateLimit clears the rate limit state of a key
+func (s *adminServer) ResetRateLimit(ctx context.Context, req *adminv1.ResetRateLimitRequest) (*adminv1.ResetRateLimitResponse, error) {
+	if req.GetKey() == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+	if err := s.g.rateLimiter.Reset(req.GetKey()); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &adminv1.ResetRateLimitResponse{}, nil
+}
+
+// ReloadConfig reloads the configuration file
+func (s *adminServer) ReloadConfig(ctx context.Context, req *adminv1.ReloadConfigRequest) (*adminv1.ReloadConfigResponse, error) {
+	if err := s.g.configManager.Reload(); err != nil {
+		s.g.logger.Error("Failed to reload configuration", zap.Error(err))
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	s.g.config.Store(s.g.configManager.Get())
+	s.g.announce(cluster.TypeConfigReload, "config", nil)
+	version, _ := s.g.configManager.Version()
+	return &adminv1.ReloadConfigResponse{Version: version}, nil
+}
+
+// PushConfig validates and applies a configuration document
+func (s *adminServer) PushConfig(ctx context.Context, req *adminv1.PushConfigRequest) (*adminv1.PushConfigResponse, error) {
+	if len(req.GetConfig()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "config is required")
+	}
+	if err := s.g.configManager.Apply(req.GetConfig()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	s.g.config.Store(s.g.configManager.Get())
+	s.g.announce(cluster.TypeConfigPush, "config", configAnnouncement{Config: req.GetConfig()})
+	version, _ := s.g.configManager.Version()
+	return &adminv1.PushConfigResponse{Version: version}, nil
+}
+
+// StreamStats sends stats snapshots until the client goes away
+func (s *adminServer) StreamStats(req *adminv1.StreamStatsRequest, stream adminv1.AdminService_StreamStatsServer) error {
+	interval := defaultStatsInterval
+	if req.GetInterval() != nil {
+		interval = max(req.GetInterval().AsDuration(), minStatsInterval)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		snapshot, err := s.snapshot()
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		if err := stream.Send(snapshot); err != nil {
+			return err
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// snapshot collects the current gateway stats
+func (s *adminServer) snapshot() (*adminv1.StatsSnapshot, error) {
+	rateLimiter, err := structpb.NewStruct(s.g.rateLimiter.GetStats())
+	if err != nil {
+		return nil, err
+	}
+
+	uptime, _ := s.g.metricsManager.GetStats()["uptime_seconds"].(float64)
+	version, _ := s.g.configManager.Version()
+	return &adminv1.StatsSnapshot{
+		Timestamp:       timestamppb.Now(),
+		UptimeSeconds:   uptime,
+		ConfigVersion:   version,
+		Services:        s.services(),
+		CircuitBreakers: s.circuitBreakers(),
+		RateLimiter:     rateLimiter,
+	}, nil
+}
+
+// services returns the registered services sorted by name
+func (s *adminServer) services() []*adminv1.Service {
+	names := s.g.proxyManager.ListServices()
+	sort.Strings(names)
+
+	services := make([]*adminv1.Service, 0, len(names))
+	for _, name := range names {
+		service := &adminv1.Service{Name: name}
+		if serviceProxy := s.g.proxyManager.GetProxy(name); serviceProxy != nil {
+			service.Targets = targetsToProto(serviceProxy.Targets())
+		}
+		services = append(services, service)
+	}
+	return services
+}
+
+// circuitBreakers returns the circuit breakers sorted by name
+func (s *adminServer) circuitBreakers() []*adminv1.CircuitBreaker {
+	states := s.g.circuitManager.GetAllStates()
+
+	breakers := make([]*adminv1.CircuitBreaker, 0, len(states))
+	for _, info := range states {
+		breakers = append(breakers, &adminv1.CircuitBreaker{
+			Name:                 info.Name,
+			State:                info.State,
+			Requests:             info.Requests,
+			TotalSuccesses:       info.TotalSuccesses,
+			TotalFailures:        info.TotalFailures,
+			ConsecutiveSuccesses: info.ConsecutiveSuccesses,
+			ConsecutiveFailures:  info.ConsecutiveFailures,
+		})
+	}
+	sort.Slice(breakers, func(i, j int) bool { return breakers[i].Name < breakers[j].Name })
+	return breakers
+}
+
+// targetsToProto converts target statuses to their API form
+func targetsToProto(targets []proxy.TargetStatus) []*adminv1.TargetStatus {
+	out := make([]*adminv1.TargetStatus, 0, len(targets))
+	for _, target := range targets {
+		out = append(out, &adminv1.TargetStatus{
+			Url:     target.URL,
+			Healthy: target.Healthy,
+			Weight:  int32(target.Weight),
+		})
+	}
+	return out
+}
+
+// serviceConfigFromProto converts an API service definition to configuration
+func serviceConfigFromProto(pb *adminv1.ServiceConfig) config.ServiceConfig {
+	cfg := config.ServiceConfig{
+		URLs:            pb.GetUrls(),
+		LoadBalancer:    strings.ToLower(pb.GetLoadBalancer()),
+		Timeout:         pb.GetTimeout().AsDuration(),
+		Retries:         int(pb.GetRetries()),
+		ForwardedHeader: pb.GetForwardedHeader(),
+	}
+
+	for _, target := range pb.GetTargets() {
+		cfg.Targets = append(cfg.Targets, config.TargetConfig{
+			URL:      target.GetUrl(),
+			Zone:     target.GetZone(),
+			Priority: int(target.GetPriority()),
+			Weight:   int(target.GetWeight()),
+		})
+	}
+
+	if cb := pb.GetCircuitBreaker(); cb != nil {
+		cfg.CircuitBreaker = config.CircuitBreakerConfig{
+			Enabled:            cb.GetEnabled(),
+			Strategy:           cb.GetStrategy(),
+			FailureThreshold:   int(cb.GetFailureThreshold()),
+			RecoveryTimeout:    cb.GetRecoveryTimeout().AsDuration(),
+			HalfOpenRequests:   int(cb.GetHalfOpenRequests()),
+			ErrorRateThreshold: cb.GetErrorRateThreshold(),
+			MinimumRequests:    int(cb.GetMinimumRequests()),
+			Interval:           cb.GetInterval().AsDuration(),
+		}
+	}
+	return cfg
+}
@@ -0,0 +1,76 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/auth"
+	"github.com/max/api-gateway/internal/middleware"
+)
+
+// adminServerRealm is the Basic authentication realm of the admin server
+const adminServerRealm = "gateway-admin"
+
+// AdminServerHandler returns the handler of the dedicated admin server. It
+// serves the admin routes, health checks and metrics of the router, and
+// other paths from extra, such as pprof. Holders of a verified client
+// certificate and users of the server's htpasswd file reach the admin
+// routes without a JWT.
+func (g *Gateway) AdminServerHandler(extra http.Handler) (http.Handler, error) {
+	var htpasswd *auth.HtpasswdAuthenticator
+	if file := g.config.Load().Server.Admin.HtpasswdFile; file != "" {
+		var err error
+		if htpasswd, err = auth.LoadHtpasswd(file); err != nil {
+			return nil, err
+		}
+	}
+
+	return g.limitHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !g.isAdminServerPath(r.URL.Path) {
+			extra.ServeHTTP(w, r)
+			return
+		}
+
+		if claims := clientCertUser(r); claims != nil {
+			r = middleware.WithAdminUser(r, claims)
+		} else if username, password, ok := r.BasicAuth(); ok && htpasswd != nil {
+			claims, err := htpasswd.Authenticate(r.Context(), username, password)
+			if err != nil {
+				if !errors.Is(err, auth.ErrInvalidCredentials) {
+					g.logger.Error("Admin server authentication failed", zap.Error(err), zap.String("username", username))
+				}
+				w.Header().Set("WWW-Authenticate", `Basic realm="`+adminServerRealm+`"`)
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":"Invalid credentials"}`))
+				return
+			}
+			r = middleware.WithAdminUser(r, claims)
+		}
+		g.router.ServeHTTP(w, r)
+	})), nil
+}
+
+// clientCertUser returns an admin for a request with a client certificate
+// verified against the admin server's client CA
+func clientCertUser(r *http.Request) *auth.Claims {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	claims := &auth.Claims{UserID: name, Username: name, Roles: []string{"admin"}}
+	claims.Subject = name
+	return claims
+}
+
+// isAdminServerPath reports whether a path belongs on the admin server
+// rather than the gateway listeners when the admin server is enabled
+func (g *Gateway) isAdminServerPath(path string) bool {
+	prometheus := g.config.Load().Monitoring.Prometheus
+	return isAdminPath(path) ||
+		path == "/health" || strings.HasPrefix(path, "/health/") ||
+		(prometheus.Enabled && !prometheus.InternalOnly && path == "/metrics")
+}
@@ -0,0 +1,37 @@
+package gateway
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/max/api-gateway/internal/analytics"
+)
+
+// maxAnalyticsEntries bounds the values returned per dimension
+const maxAnalyticsEntries = 100
+
+// getAnalyticsTop returns the most frequent paths, clients, user agents and
+// status codes seen by this instance over the last 1, 5 or 15 minutes
+func (g *Gateway) getAnalyticsTop(c *gin.Context) {
+	if !g.config.Load().Analytics.Enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Analytics are not enabled"})
+		return
+	}
+
+	window, err := time.ParseDuration(c.DefaultQuery("window", "5m"))
+	if err != nil || !slices.Contains(analytics.Windows, window) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "window must be 1m, 5m or 15m"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 || limit > maxAnalyticsEntries {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
+
+	c.JSON(http.StatusOK, g.middlewareManager.Analyzer().Top(window, limit))
+}
@@ -0,0 +1,120 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/auth"
+	"github.com/max/api-gateway/internal/chaos"
+)
+
+// chaosInjector returns the fault injector, or nil when chaos injection is
+// not configured and the middleware is not installed
+func (g *Gateway) chaosInjector() *chaos.Injector {
+	if !g.config.Load().Chaos.Enabled {
+		return nil
+	}
+	return g.middlewareManager.ChaosInjector()
+}
+
+// getChaos returns the fault injection state and rules
+func (g *Gateway) getChaos(c *gin.Context) {
+	injector := g.chaosInjector()
+	if injector == nil {
+		c.JSON(http.StatusOK, gin.H{"configured": false, "enabled": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"configured":  true,
+		"enabled":     injector.Enabled(),
+		"allowed":     injector.Allowed(),
+		"environment": g.config.Load().Server.Environment,
+		"rules":       injector.Rules(),
+	})
+}
+
+// setChaosEnabled switches fault injection on or off
+func (g *Gateway) setChaosEnabled(c *gin.Context) {
+	injector := g.chaosInjector()
+	if injector == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chaos injection is not configured"})
+		return
+	}
+
+	var req struct {
+		Enabled *bool `json:"enabled" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := injector.SetEnabled(*req.Enabled); err != nil {
+		if errors.Is(err, chaos.ErrNotAllowed) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	g.logger.Warn("Chaos injection toggled",
+		zap.Bool("enabled", *req.Enabled),
+		zap.String("user", adminUser(c)))
+	c.JSON(http.StatusOK, gin.H{"enabled": *req.Enabled})
+}
+
+// setChaosRule adds or replaces a fault injection rule
+func (g *Gateway) setChaosRule(c *gin.Context) {
+	injector := g.chaosInjector()
+	if injector == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chaos injection is not configured"})
+		return
+	}
+
+	var rule chaos.Rule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule.Name = c.Param("name")
+	if err := injector.SetRule(rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	g.logger.Warn("Chaos rule updated",
+		zap.String("rule", rule.Name),
+		zap.String("path_prefix", rule.PathPrefix),
+		zap.Float64("percentage", rule.Percentage),
+		zap.String("fault", rule.Fault()),
+		zap.String("user", adminUser(c)))
+	c.JSON(http.StatusOK, rule)
+}
+
+// deleteChaosRule removes a fault injection rule
+func (g *Gateway) deleteChaosRule(c *gin.Context) {
+	injector := g.chaosInjector()
+	if injector == nil || !injector.RemoveRule(c.Param("name")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chaos rule not found"})
+		return
+	}
+
+	g.logger.Warn("Chaos rule removed",
+		zap.String("rule", c.Param("name")),
+		zap.String("user", adminUser(c)))
+	c.JSON(http.StatusOK, gin.H{"message": "Chaos rule removed successfully"})
+}
+
+// adminUser returns the name of the admin making the request
+func adminUser(c *gin.Context) string {
+	value, _ := c.Get("user")
+	claims, _ := value.(*auth.Claims)
+	if claims == nil {
+		return ""
+	}
+	return claims.Username
+}
@@ -0,0 +1,216 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/cluster"
+	"github.com/max/api-gateway/internal/config"
+)
+
+// announceTimeout bounds broadcasting a change to the other replicas
+const announceTimeout = 5 * time.Second
+
+// serviceAnnouncement carries a service change; a nil config removes it
+type serviceAnnouncement struct {
+	Name   string                `json:"name"`
+	Config *config.ServiceConfig `json:"config,omitempty"`
+}
+
+// weightAnnouncement carries a target weight change
+type weightAnnouncement struct {
+	Service string `json:"service"`
+	URL     string `json:"url"`
+	Weight  int    `json:"weight"`
+}
+
+// breakerAnnouncement carries a circuit breaker reset
+type breakerAnnouncement struct {
+	Name string `json:"name"`
+}
+
+// configAnnouncement carries a pushed configuration document
+type configAnnouncement struct {
+	Config []byte `json:"config"`
+}
+
+// healthAnnouncement carries the health of a service's targets, by URL
+type healthAnnouncement struct {
+	Service string          `json:"service"`
+	Targets map[string]bool `json:"targets"`
+}
+
+// JoinCluster makes admin changes on this replica reach the others, and
+// applies theirs here
+func (g *Gateway) JoinCluster(c *cluster.Cluster) {
+	g.cluster = c
+
+	c.Handle(cluster.TypeServiceUpsert, func(a cluster.Announcement) error {
+		var msg serviceAnnouncement
+		if err := json.Unmarshal(a.Payload, &msg); err != nil {
+			return err
+		}
+		if msg.Config == nil {
+			return fmt.Errorf("service %s: missing config", msg.Name)
+		}
+		return g.proxyManager.UpdateService(msg.Name, msg.Config)
+	})
+
+	c.Handle(cluster.TypeServiceDelete, func(a cluster.Announcement) error {
+		var msg serviceAnnouncement
+		if err := json.Unmarshal(a.Payload, &msg); err != nil {
+			return err
+		}
+		g.proxyManager.RemoveService(msg.Name)
+		return nil
+	})
+
+	c.Handle(cluster.TypeTargetWeight, func(a cluster.Announcement) error {
+		var msg weightAnnouncement
+		if err := json.Unmarshal(a.Payload, &msg); err != nil {
+			return err
+		}
+		serviceProxy := g.proxyManager.GetProxy(msg.Service)
+		if serviceProxy == nil {
+			return fmt.Errorf("service not found: %s", msg.Service)
+		}
+		return serviceProxy.SetTargetWeight(msg.URL, msg.Weight)
+	})
+
+	c.Handle(cluster.TypeBreakerReset, func(a cluster.Announcement) error {
+		var msg breakerAnnouncement
+		if err := json.Unmarshal(a.Payload, &msg); err != nil {
+			return err
+		}
+		return g.circuitManager.ResetBreaker(msg.Name)
+	})
+
+	c.Handle(cluster.TypeConfigPush, func(a cluster.Announcement) error {
+		var msg configAnnouncement
+		if err := json.Unmarshal(a.Payload, &msg); err != nil {
+			return err
+		}
+		if err := g.configManager.Apply(msg.Config); err != nil {
+			return err
+		}
+		g.config.Store(g.configManager.Get())
+		return nil
+	})
+
+	c.Handle(cluster.TypeConfigReload, func(a cluster.Announcement) error {
+		if err := g.configManager.Reload(); err != nil {
+			return err
+		}
+		g.config.Store(g.configManager.Get())
+		return nil
+	})
+
+	c.Handle(cluster.TypeRegistration, func(a cluster.Announcement) error {
+		if g.registry == nil {
+			return nil
+		}
+		var msg registrationAnnouncement
+		if err := json.Unmarshal(a.Payload, &msg); err != nil {
+			return err
+		}
+		return g.registry.Apply(msg.Registration, msg.Removed)
+	})
+
+	if g.config.Load().Cluster.ShareHealth {
+		c.Handle(cluster.TypeTargetHealth, func(a cluster.Announcement) error {
+			var msg healthAnnouncement
+			if err := json.Unmarshal(a.Payload, &msg); err != nil {
+				return err
+			}
+			g.proxyManager.ApplyTargetHealth(msg.Service, msg.Targets)
+			return nil
+		})
+		g.proxyManager.SetHealthSharing(clusterHealth{g})
+	}
+}
+
+// clusterHealth lets one replica at a time probe each service, holding a
+// lease in Redis, and share the results over the cluster channel
+type clusterHealth struct {
+	g *Gateway
+}
+
+// Lead reports whether this replica probes the service. While Redis is
+// unreachable every replica probes on its own.
+func (h clusterHealth) Lead(service string, ttl time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), announceTimeout)
+	defer cancel()
+
+	led, err := h.g.cluster.Lead(ctx, "health:"+service, ttl)
+	if err != nil {
+		h.g.logger.Warn("Failed to acquire health check lease, probing locally",
+			zap.String("service", service),
+			zap.Error(err))
+		return true
+	}
+	return led
+}
+
+// Publish shares the health of a service's targets with the other replicas
+func (h clusterHealth) Publish(service string, health map[string]bool) {
+	h.g.announce(cluster.TypeTargetHealth, "health:"+service, healthAnnouncement{Service: service, Targets: health})
+}
+
+// announce broadcasts a change made on this replica. Failures are logged:
+// the change is already applied locally.
+func (g *Gateway) announce(announcementType, key string, payload interface{}) {
+	if g.cluster == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), announceTimeout)
+	defer cancel()
+
+	if err := g.cluster.Announce(ctx, announcementType, key, payload); err != nil {
+		g.logger.Error("Failed to announce change to the cluster",
+			zap.String("type", announcementType),
+			zap.String("key", key),
+			zap.Error(err))
+	}
+}
+
+// announceServiceUpdate broadcasts a service being added or replaced
+func (g *Gateway) announceServiceUpdate(name string, cfg *config.ServiceConfig) {
+	g.announce(cluster.TypeServiceUpsert, "service:"+name, serviceAnnouncement{Name: name, Config: cfg})
+}
+
+// announceServiceDelete broadcasts a service being removed
+func (g *Gateway) announceServiceDelete(name string) {
+	g.announce(cluster.TypeServiceDelete, "service:"+name, serviceAnnouncement{Name: name})
+}
+
+// announceTargetWeight broadcasts a target weight change
+func (g *Gateway) announceTargetWeight(service, url string, weight int) {
+	g.announce(cluster.TypeTargetWeight, "weight:"+service+":"+url,
+		weightAnnouncement{Service: service, URL: url, Weight: weight})
+}
+
+// announceBreakerReset broadcasts a circuit breaker reset
+func (g *Gateway) announceBreakerReset(name string) {
+	g.announce(cluster.TypeBreakerReset, "", breakerAnnouncement{Name: name})
+}
+
+// getCluster returns this replica's node ID and the live peers
+func (g *Gateway) getCluster(c *gin.Context) {
+	if g.cluster == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": true,
+		"node":    g.cluster.Node(),
+		"peers":   g.cluster.Peers(),
+	})
+}
@@ -0,0 +1,261 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/auth"
+	"github.com/max/api-gateway/internal/circuit"
+	"github.com/max/api-gateway/internal/composite"
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/internal/featureflags"
+	"github.com/max/api-gateway/internal/middleware"
+	"github.com/max/api-gateway/internal/proxy"
+)
+
+const (
+	// defaultCompositeTimeout bounds a composite request when none is set
+	defaultCompositeTimeout = 10 * time.Second
+	// defaultCompositeMaxBody limits each branch response when none is set
+	defaultCompositeMaxBody = 1 << 20
+)
+
+// errBranchTooLarge is returned when a branch response exceeds the limit
+var errBranchTooLarge = errors.New("response too large")
+
+// setupCompositeRoutes registers the composite routes. Routes that clash
+// with the gateway's own are reported as an error.
+func (g *Gateway) setupCompositeRoutes() (err error) {
+	// gin panics on conflicting routes
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid composite route: %v", r)
+		}
+	}()
+
+	names := make([]string, 0, len(g.config.Load().Routing.Composites))
+	for name := range g.config.Load().Routing.Composites {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		cfg := g.config.Load().Routing.Composites[name]
+		method := strings.ToUpper(cfg.Method)
+		if method == "" {
+			method = http.MethodGet
+		}
+
+		var handlers []gin.HandlerFunc
+		if cfg.RequireAuth {
+			handlers = append(handlers, g.middlewareManager.JWTAuth())
+		}
+		handlers = append(handlers, g.compositeHandler(name, cfg))
+		g.router.Handle(method, cfg.Path, handlers...)
+
+		g.logger.Info("Composite route added",
+			zap.String("composite", name),
+			zap.String("method", method),
+			zap.String("path", cfg.Path),
+			zap.Int("branches", len(cfg.Branches)))
+	}
+	return nil
+}
+
+// compositeHandler calls the branches of a composite route in parallel and
+// responds with their merged JSON. A failed required branch fails the
+// request with 502; other failed branches use their fallback and are listed
+// in the X-Partial-Response header.
+func (g *Gateway) compositeHandler(name string, cfg config.CompositeConfig) gin.HandlerFunc {
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultCompositeTimeout
+	}
+	maxBody := cfg.MaxBodyBytes
+	if maxBody == 0 {
+		maxBody = defaultCompositeMaxBody
+	}
+
+	return func(c *gin.Context) {
+		if cfg.Flag != "" && !featureflags.Enabled(c.Request.Context(), cfg.Flag) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+			return
+		}
+
+		ctx := g.forwardingContext(c)
+		if start, ok := c.Get(string(middleware.StartTimeKey)); ok {
+			if startTime, ok := start.(time.Time); ok {
+				ctx = proxy.WithStartTime(ctx, startTime)
+			}
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		g.propagateClaims(c)
+		claims := g.middlewareManager.RequestClaims(c)
+
+		bodies := make([]interface{}, len(cfg.Branches))
+		errs := make([]error, len(cfg.Branches))
+		var wg sync.WaitGroup
+		for i, branch := range cfg.Branches {
+			wg.Add(1)
+			go func(i int, branch config.CompositeBranchConfig) {
+				defer wg.Done()
+				bodies[i], errs[i] = g.callBranch(ctx, c, branch, claims, maxBody)
+			}(i, branch)
+		}
+		wg.Wait()
+
+		results := make(map[string]interface{}, len(cfg.Branches))
+		var failed []string
+		for i, branch := range cfg.Branches {
+			if errs[i] == nil {
+				results[branch.Name] = bodies[i]
+				continue
+			}
+
+			g.logger.Warn("Composite branch failed",
+				zap.String("composite", name),
+				zap.String("branch", branch.Name),
+				zap.String("service", branch.Service),
+				zap.Error(errs[i]))
+
+			if branch.Required {
+				c.JSON(http.StatusBadGateway, gin.H{
+					"error":  "Composite request failed",
+					"branch": branch.Name,
+				})
+				return
+			}
+
+			failed = append(failed, branch.Name)
+			var fallback interface{}
+			if branch.Fallback != "" {
+				json.Unmarshal([]byte(branch.Fallback), &fallback)
+			}
+			results[branch.Name] = fallback
+		}
+
+		if len(failed) > 0 {
+			c.Header("X-Partial-Response", strings.Join(failed, ","))
+		}
+		c.JSON(http.StatusOK, composite.Merge(cfg.Mapping, results))
+	}
+}
+
+// callBranch calls one branch through the service's proxy and circuit
+// breaker and decodes its JSON response
+func (g *Gateway) callBranch(ctx context.Context, c *gin.Context, branch config.CompositeBranchConfig, claims *auth.Claims, maxBody int64) (interface{}, error) {
+	serviceProxy := g.proxyManager.GetProxy(branch.Service)
+	if serviceProxy == nil {
+		return nil, fmt.Errorf("service not found: %s", branch.Service)
+	}
+
+	target := composite.ExpandPath(branch.Path, c.Param)
+	if branch.ForwardQuery && c.Request.URL.RawQuery != "" {
+		separator := "?"
+		if strings.Contains(target, "?") {
+			separator = "&"
+		}
+		target += separator + c.Request.URL.RawQuery
+	}
+
+	method := strings.ToUpper(branch.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid branch request: %w", err)
+	}
+	req.Header = c.Request.Header.Clone()
+	req.Header.Del("Content-Length")
+	req.Header.Del("Content-Type")
+	// Let the transport negotiate compression so the body arrives decoded
+	req.Header.Del("Accept-Encoding")
+	req.Header.Set("Accept", "application/json")
+	req.RemoteAddr = c.Request.RemoteAddr
+
+	if g.internalTokens != nil {
+		if err := g.setInternalToken(req.Header, claims, branch.Service); err != nil {
+			return nil, fmt.Errorf("failed to issue internal token: %w", err)
+		}
+	}
+
+	release, err := serviceProxy.Admit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	w := &branchResponse{header: make(http.Header), status: http.StatusOK, limit: maxBody}
+	forward := func() (int, error) {
+		return serviceProxy.Forward(w, req)
+	}
+
+	status := 0
+	if breaker := g.circuitManager.GetBreaker(branch.Service); breaker != nil {
+		err = breaker.CallHTTP(func() (int, error) {
+			var forwardErr error
+			status, forwardErr = forward()
+			return status, forwardErr
+		})
+		if circuit.IsRejected(err) {
+			return nil, err
+		}
+	} else {
+		status, err = forward()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if w.overflow {
+		return nil, errBranchTooLarge
+	}
+	if status >= http.StatusBadRequest {
+		return nil, fmt.Errorf("upstream status %d", status)
+	}
+
+	var body interface{}
+	if err := json.Unmarshal(w.body.Bytes(), &body); err != nil {
+		return nil, fmt.Errorf("invalid JSON response: %w", err)
+	}
+	return body, nil
+}
+
+// branchResponse buffers a branch response up to a size limit
+type branchResponse struct {
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (b *branchResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *branchResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *branchResponse) Write(p []byte) (int, error) {
+	if int64(b.body.Len()+len(p)) > b.limit {
+		b.overflow = true
+		return 0, errBranchTooLarge
+	}
+	return b.body.Write(p)
+}
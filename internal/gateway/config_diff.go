@@ -0,0 +1,108 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// maxConfigServerResponse bounds the config server responses read for a diff
+const maxConfigServerResponse = 8 << 20
+
+// configComparison compares the running configuration with another version
+type configComparison struct {
+	Source   string          `json:"source"`
+	Revision string          `json:"revision,omitempty"`
+	InSync   bool            `json:"in_sync"`
+	Changes  []config.Change `json:"changes"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// getConfigDiff compares the running configuration with the configuration
+// file and the config server's latest version, so drift shows before a
+// reload
+func (g *Gateway) getConfigDiff(c *gin.Context) {
+	running := g.configManager.Get()
+	resp := gin.H{"revision": g.configManager.Revision()}
+
+	file := &configComparison{Source: g.configManager.FilePath()}
+	if other, revision, err := g.configManager.FileConfig(); err != nil {
+		file.Error = err.Error()
+	} else {
+		file.compare(running, other, revision)
+	}
+	resp["file"] = file
+
+	if url := running.ConfigServer.URL; url != "" {
+		server := &configComparison{Source: url}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), running.ConfigServer.Timeout)
+		defer cancel()
+		if other, revision, err := fetchServerConfig(ctx, url); err != nil {
+			g.logger.Warn("Failed to fetch config server configuration", zap.String("url", url), zap.Error(err))
+			server.Error = err.Error()
+		} else {
+			server.compare(running, other, revision)
+		}
+		resp["config_server"] = server
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// compare records the changes from the running configuration
+func (cc *configComparison) compare(running, other *config.Config, revision string) {
+	cc.Revision = revision
+	cc.Changes = config.Diff(running, other)
+	cc.InSync = len(cc.Changes) == 0
+}
+
+// fetchServerConfig reads the config server's current configuration and
+// revision
+func fetchServerConfig(ctx context.Context, baseURL string) (*config.Config, string, error) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	var current struct {
+		Config *config.Config `json:"config"`
+	}
+	if err := getServerJSON(ctx, baseURL+"/api/v1/config", &current); err != nil {
+		return nil, "", err
+	}
+	if current.Config == nil {
+		return nil, "", fmt.Errorf("config server returned no configuration")
+	}
+
+	// The revision is informational, so a failure leaves it out
+	var history struct {
+		Current string `json:"current"`
+	}
+	getServerJSON(ctx, baseURL+"/api/v1/config/history", &history)
+	return current.Config, history.Current, nil
+}
+
+// getServerJSON decodes a JSON response of the config server
+func getServerJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach config server: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("config server returned %s", resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxConfigServerResponse)).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode config server response: %w", err)
+	}
+	return nil
+}
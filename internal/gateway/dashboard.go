@@ -0,0 +1,195 @@
+package gateway
+
+import (
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/auth"
+	"github.com/max/api-gateway/internal/config"
+)
+
+//go:embed ui
+var dashboardAssets embed.FS
+
+const (
+	// dashboardPushInterval is how often the live feed pushes a snapshot
+	dashboardPushInterval = 2 * time.Second
+	// dashboardWriteTimeout bounds a single WebSocket write
+	dashboardWriteTimeout = 5 * time.Second
+	// liveTicketTTL is how long a live feed ticket can be redeemed
+	liveTicketTTL = 30 * time.Second
+)
+
+var dashboardUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// setupDashboardRoutes sets up the embedded admin dashboard.
+// The static assets are public; the data they display is fetched from the
+// admin API and the live feed, both of which require an admin token.
+func (g *Gateway) setupDashboardRoutes() {
+	assets, err := fs.Sub(dashboardAssets, "ui")
+	if err != nil {
+		g.logger.Error("Failed to load dashboard assets", zap.Error(err))
+		return
+	}
+
+	g.router.StaticFS("/admin/ui", http.FS(assets))
+
+	// Browsers cannot set headers on WebSocket upgrades, so the live feed
+	// also accepts a single-use ticket issued by POST /admin/live/ticket
+	// instead of going through the admin chain
+	g.router.GET("/admin/live", g.dashboardLiveFeed)
+}
+
+// liveTickets holds the single-use tickets that open the live feed. A
+// ticket stands in for the admin's token in the WebSocket URL, which ends up
+// in access logs.
+type liveTickets struct {
+	mu      sync.Mutex
+	tickets map[string]liveTicket
+}
+
+// liveTicket is the caller a ticket was issued to
+type liveTicket struct {
+	claims  *auth.Claims
+	expires time.Time
+}
+
+// issue returns a new ticket for the caller, dropping expired ones
+func (t *liveTickets) issue(claims *auth.Claims) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	ticket := hex.EncodeToString(b)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if t.tickets == nil {
+		t.tickets = make(map[string]liveTicket)
+	}
+	for id, issued := range t.tickets {
+		if now.After(issued.expires) {
+			delete(t.tickets, id)
+		}
+	}
+	t.tickets[ticket] = liveTicket{claims: claims, expires: now.Add(liveTicketTTL)}
+	return ticket, nil
+}
+
+// redeem returns the caller of an unexpired ticket and invalidates it
+func (t *liveTickets) redeem(ticket string) *auth.Claims {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	issued, ok := t.tickets[ticket]
+	if !ok {
+		return nil
+	}
+	delete(t.tickets, ticket)
+	if time.Now().After(issued.expires) {
+		return nil
+	}
+	return issued.claims
+}
+
+// issueLiveTicket returns a ticket that opens the live feed once
+func (g *Gateway) issueLiveTicket(c *gin.Context) {
+	ticket, err := g.liveTickets.issue(g.middlewareManager.RequestClaims(c))
+	if err != nil {
+		g.logger.Error("Failed to issue live feed ticket", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue ticket"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"ticket":     ticket,
+		"expires_in": int(liveTicketTTL.Seconds()),
+	})
+}
+
+// getDashboard returns a single dashboard snapshot
+func (g *Gateway) getDashboard(c *gin.Context) {
+	c.JSON(http.StatusOK, g.dashboardSnapshot())
+}
+
+// dashboardLiveFeed streams dashboard snapshots over a WebSocket
+func (g *Gateway) dashboardLiveFeed(c *gin.Context) {
+	// Admin server users and clients that can send an Authorization header
+	// are authenticated as usual; browsers redeem a ticket
+	claims := g.middlewareManager.RequestClaims(c)
+	if claims == nil {
+		if claims = g.liveTickets.redeem(c.Query("ticket")); claims == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired ticket"})
+			return
+		}
+	}
+	if !g.middlewareManager.AuthorizeAdmin(claims, config.PermStatsRead, c.Request.Method+" "+c.Request.URL.Path, c.ClientIP()) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	conn, err := dashboardUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		g.logger.Warn("Dashboard WebSocket upgrade failed", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	// Drain client frames so close and ping control messages are processed
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(dashboardPushInterval)
+	defer ticker.Stop()
+
+	for {
+		conn.SetWriteDeadline(time.Now().Add(dashboardWriteTimeout))
+		if err := conn.WriteJSON(g.dashboardSnapshot()); err != nil {
+			g.logger.Debug("Dashboard live feed closed", zap.Error(err))
+			return
+		}
+
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dashboardSnapshot collects the data rendered by the dashboard
+func (g *Gateway) dashboardSnapshot() map[string]interface{} {
+	version, loadedAt := g.configManager.Version()
+
+	return map[string]interface{}{
+		"timestamp":        time.Now().UTC(),
+		"uptime_seconds":   g.metricsManager.GetStats()["uptime_seconds"],
+		"services":         g.metricsManager.GetServiceStats(),
+		"targets":          g.proxyManager.GetTargetHealth(),
+		"circuit_breakers": g.circuitManager.GetAllStates(),
+		"rate_limiter":     g.rateLimiter.GetStats(),
+		"config": map[string]interface{}{
+			"version":   version,
+			"revision":  g.configManager.Revision(),
+			"loaded_at": loadedAt,
+		},
+	}
+}
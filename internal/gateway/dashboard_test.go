@@ -0,0 +1,125 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/auth"
+	"github.com/max/api-gateway/internal/circuit"
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/internal/middleware"
+	"github.com/max/api-gateway/internal/proxy"
+	"github.com/max/api-gateway/internal/ratelimit"
+	"github.com/max/api-gateway/pkg/metrics"
+)
+
+func TestDashboardLiveFeed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zap.NewNop()
+	cfg := &config.Config{}
+	jwtAuth := auth.NewJWTAuth("test-secret", time.Hour, 24*time.Hour, "gateway", "gateway", "HS256", logger)
+	metricsManager := metrics.NewManager(logger)
+	rateLimiter := ratelimit.NewManager(&cfg.RateLimit, nil, logger)
+	middlewareManager := middleware.NewManager(cfg, jwtAuth, rateLimiter, nil, metricsManager, logger)
+	gw := NewGateway(cfg, config.NewManager(logger), jwtAuth, rateLimiter,
+		circuit.NewManager(logger, metricsManager), proxy.NewProxyManager(logger, metricsManager),
+		middlewareManager, metricsManager, logger)
+
+	router := gin.New()
+	router.POST("/admin/live/ticket", middlewareManager.RequirePermission(config.PermStatsRead), gw.issueLiveTicket)
+	router.GET("/admin/live", gw.dashboardLiveFeed)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	feed := "ws" + strings.TrimPrefix(server.URL, "http") + "/admin/live"
+
+	token := func(roles ...string) string {
+		token, err := jwtAuth.GenerateToken("1", "tester", "tester@example.com", roles, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	ticket := func(token string) (string, int) {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/admin/live/ticket", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body struct {
+			Ticket string `json:"ticket"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return body.Ticket, resp.StatusCode
+	}
+	dial := func(url string, header http.Header) int {
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if err != nil {
+			if resp == nil {
+				t.Fatal(err)
+			}
+			return resp.StatusCode
+		}
+		defer conn.Close()
+		var snapshot map[string]interface{}
+		if err := conn.ReadJSON(&snapshot); err != nil || snapshot["services"] == nil {
+			t.Errorf("snapshot = %v (%v), want dashboard data", snapshot, err)
+		}
+		return http.StatusSwitchingProtocols
+	}
+
+	if _, code := ticket(token("user")); code != http.StatusForbidden {
+		t.Errorf("ticket for a non-admin = %d, want 403", code)
+	}
+
+	issued, code := ticket(token("admin"))
+	if code != http.StatusOK || issued == "" {
+		t.Fatalf("ticket for an admin = %d %q", code, issued)
+	}
+	if code := dial(feed+"?ticket="+issued, nil); code != http.StatusSwitchingProtocols {
+		t.Errorf("live feed with a ticket = %d, want 101", code)
+	}
+	if code := dial(feed+"?ticket="+issued, nil); code != http.StatusUnauthorized {
+		t.Errorf("live feed with a used ticket = %d, want 401", code)
+	}
+
+	// Tokens are not accepted in the URL
+	if code := dial(feed+"?token="+token("admin"), nil); code != http.StatusUnauthorized {
+		t.Errorf("live feed with a token in the URL = %d, want 401", code)
+	}
+	header := http.Header{"Authorization": {"Bearer " + token("admin")}}
+	if code := dial(feed, header); code != http.StatusSwitchingProtocols {
+		t.Errorf("live feed with an Authorization header = %d, want 101", code)
+	}
+	header = http.Header{"Authorization": {"Bearer " + token("user")}}
+	if code := dial(feed, header); code != http.StatusForbidden {
+		t.Errorf("live feed for a non-admin = %d, want 403", code)
+	}
+}
+
+func TestLiveTicketExpires(t *testing.T) {
+	var tickets liveTickets
+	claims := &auth.Claims{UserID: "1"}
+	ticket, err := tickets.issue(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := tickets.tickets[ticket]
+	expired.expires = time.Now().Add(-time.Second)
+	tickets.tickets[ticket] = expired
+	if tickets.redeem(ticket) != nil {
+		t.Error("expired ticket redeemed")
+	}
+	if tickets.redeem("unknown") != nil {
+		t.Error("unknown ticket redeemed")
+	}
+}
@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/proxy"
+	"github.com/max/api-gateway/pkg/tracing"
+)
+
+// Debug override headers. They are honored for trusted requests only and
+// never sent upstream.
+const (
+	debugTargetHeader      = "X-Gateway-Target"       // host:port of the target to pin the request to
+	debugCacheBypassHeader = "X-Gateway-Cache-Bypass" // true to skip cached fallback responses
+	debugTraceHeader       = "X-Gateway-Trace"        // force to sample the trace
+	debugSecretHeader      = "X-Gateway-Debug-Secret"
+)
+
+var debugHeaders = []string{debugTargetHeader, debugCacheBypassHeader, debugTraceHeader, debugSecretHeader}
+
+// applyDebugOverrides strips the debug headers from the request and, when
+// it is trusted, applies them. It writes an error response and returns
+// false if the pinned target is not one of the service's.
+func (g *Gateway) applyDebugOverrides(c *gin.Context, serviceName string, serviceProxy *proxy.ReverseProxy) bool {
+	cfg := g.config.Load().Server.DebugHeaders
+	if !cfg.Enabled {
+		return true
+	}
+
+	target := c.GetHeader(debugTargetHeader)
+	bypass, _ := strconv.ParseBool(c.GetHeader(debugCacheBypassHeader))
+	forceTrace := strings.EqualFold(c.GetHeader(debugTraceHeader), "force")
+	trusted := g.debugTrusted(c)
+	for _, header := range debugHeaders {
+		c.Request.Header.Del(header)
+	}
+	if !trusted || (target == "" && !bypass && !forceTrace) {
+		return true
+	}
+
+	ctx := c.Request.Context()
+	if target != "" {
+		if serviceProxy.Target(target) == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown upstream target: " + target})
+			return false
+		}
+		ctx = proxy.WithPinnedTarget(ctx, target)
+	}
+	if bypass {
+		ctx = proxy.WithCacheBypass(ctx)
+	}
+	if span, ok := tracing.FromContext(ctx); ok && forceTrace && !span.Sampled {
+		span.Sampled = true
+		ctx = tracing.WithSpanContext(ctx, span)
+		c.Request.Header.Set(tracing.TraceparentHeader, span.Traceparent())
+	}
+	c.Request = c.Request.WithContext(ctx)
+
+	g.logger.Info("Debug overrides applied",
+		zap.String("service", serviceName),
+		zap.String("target", target),
+		zap.Bool("cache_bypass", bypass),
+		zap.Bool("force_trace", forceTrace),
+		zap.String("client_ip", c.ClientIP()))
+	return true
+}
+
+// debugTrusted reports whether the request may use the debug headers
+func (g *Gateway) debugTrusted(c *gin.Context) bool {
+	cfg := g.config.Load().Server.DebugHeaders
+	if secret := c.GetHeader(debugSecretHeader); cfg.Secret != "" && secret != "" &&
+		subtle.ConstantTimeCompare([]byte(secret), []byte(cfg.Secret)) == 1 {
+		return true
+	}
+	if cfg.Role == "" {
+		return false
+	}
+	claims := g.middlewareManager.RequestClaims(c)
+	return claims != nil && g.jwtAuth.HasRole(claims, cfg.Role)
+}
@@ -1,24 +1,44 @@
 package gateway
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 
 	"github.com/max/api-gateway/internal/auth"
+	"github.com/max/api-gateway/internal/cache"
 	"github.com/max/api-gateway/internal/circuit"
+	"github.com/max/api-gateway/internal/cluster"
 	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/internal/cors"
+	"github.com/max/api-gateway/internal/featureflags"
+	"github.com/max/api-gateway/internal/health"
+	"github.com/max/api-gateway/internal/identity"
 	"github.com/max/api-gateway/internal/middleware"
 	"github.com/max/api-gateway/internal/proxy"
 	"github.com/max/api-gateway/internal/ratelimit"
+	"github.com/max/api-gateway/internal/registry"
+	"github.com/max/api-gateway/internal/synthetics"
+	"github.com/max/api-gateway/internal/webhook"
 	"github.com/max/api-gateway/pkg/metrics"
+	"github.com/max/api-gateway/pkg/proxyproto"
+	"github.com/max/api-gateway/pkg/servertiming"
 )
 
 // Gateway represents the main API gateway
 type Gateway struct {
-	config            *config.Config
+	config            atomic.Pointer[config.Config] // Replaced on reloads while requests read it
 	configManager     *config.Manager
 	router            *gin.Engine
 	jwtAuth           *auth.JWTAuth
@@ -28,8 +48,28 @@ type Gateway struct {
 	middlewareManager *middleware.Manager
 	metricsManager    *metrics.Manager
 	logger            *zap.Logger
+	internalTokens    *auth.InternalTokenIssuer
+	health            *health.Checker
+	cluster           *cluster.Cluster
+	synthetics        *synthetics.Runner
+	webhooks          *webhook.Dispatcher
+	users             *identity.Store
+	challenges        *identity.ChallengeStore
+	tenantCache       *cache.TenantCache
+	registry          *registry.Registry
+	liveTickets       liveTickets
+	trustedProxies    []*net.IPNet
 }
 
+// gatewayVersion is reported by the info and health endpoints
+const gatewayVersion = "1.0.0"
+
+// maxRateLimitOffenders bounds the offenders returned at once
+const maxRateLimitOffenders = 1000
+
+// maxPushedConfig bounds a configuration document pushed to PUT /admin/config
+const maxPushedConfig = 4 << 20
+
 // NewGateway creates a new API gateway instance
 func NewGateway(
 	cfg *config.Config,
@@ -51,8 +91,12 @@ func NewGateway(
 
 	router := gin.New()
 
-	return &Gateway{
-		config:            cfg,
+	var internalTokens *auth.InternalTokenIssuer
+	if cfg.Auth.Internal.Enabled {
+		internalTokens = auth.NewInternalTokenIssuer(cfg.Auth.Internal.Secret, cfg.Auth.Internal.Issuer, cfg.Auth.Internal.TTL)
+	}
+
+	g := &Gateway{
 		configManager:     configManager,
 		router:            router,
 		jwtAuth:           jwtAuth,
@@ -62,11 +106,37 @@ func NewGateway(
 		middlewareManager: middlewareManager,
 		metricsManager:    metricsManager,
 		logger:            logger,
+		internalTokens:    internalTokens,
+		health:            health.NewChecker(gatewayVersion, cfg.Monitoring.Health.Timeout, cfg.Monitoring.Health.Critical),
 	}
+	g.config.Store(cfg)
+
+	g.health.Register("config", g.checkConfig)
+	g.health.Register("services", g.checkServices)
+	return g
+}
+
+// Health returns the health checker so dependency checks can be registered
+func (g *Gateway) Health() *health.Checker {
+	return g.health
 }
 
 // SetupRoutes sets up all the routes for the gateway
 func (g *Gateway) SetupRoutes() error {
+	// Only trust X-Forwarded-For and X-Real-IP from configured proxies
+	trustedProxies := g.config.Load().Server.TrustedProxies
+	if err := g.router.SetTrustedProxies(trustedProxies); err != nil {
+		return fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	networks, err := proxyproto.ParseCIDRs(trustedProxies)
+	if err != nil {
+		return fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	g.trustedProxies = networks
+
+	// Proxied requests use their service's CORS policy
+	g.middlewareManager.SetServiceCORS(g.serviceCORS)
+
 	// Apply default middleware chain
 	defaultChain := g.middlewareManager.CreateDefaultChain()
 	g.router.Use(defaultChain.Build()...)
@@ -80,9 +150,20 @@ func (g *Gateway) SetupRoutes() error {
 	// Admin routes (authentication + admin role required)
 	g.setupAdminRoutes()
 
+	// Admin dashboard UI and live feed
+	g.setupDashboardRoutes()
+
 	// Protected API routes (authentication required)
 	g.setupProtectedRoutes()
 
+	// Developer portal (authentication required)
+	g.setupPortalRoutes()
+
+	// Composite routes fanning out to several services
+	if err := g.setupCompositeRoutes(); err != nil {
+		return err
+	}
+
 	// Catch-all route for proxying to services
 	g.setupProxyRoutes()
 
@@ -94,12 +175,14 @@ func (g *Gateway) SetupRoutes() error {
 func (g *Gateway) setupPublicRoutes() {
 	public := g.router.Group("/")
 
-	// Health check endpoint
+	// Health check endpoints: full report, liveness and readiness
 	public.GET("/health", g.healthCheck)
+	public.GET("/health/live", g.liveness)
+	public.GET("/health/ready", g.readiness)
 
-	// Metrics endpoint (if enabled and public)
-	if g.config.Monitoring.Prometheus.Enabled {
-		public.GET("/metrics", g.metricsManager.GinHandler())
+	// Metrics endpoint, unless only served on the metrics port
+	if prometheus := g.config.Load().Monitoring.Prometheus; prometheus.Enabled && !prometheus.InternalOnly {
+		public.GET("/metrics", g.metricsAccess(), g.metricsManager.GinHandler())
 	}
 
 	// Gateway info endpoint
@@ -113,12 +196,22 @@ func (g *Gateway) setupAuthRoutes() {
 	// Login endpoint (would typically integrate with external auth service)
 	auth.POST("/login", g.login)
 
+	// Second factor: the code step of a login and authenticator enrollment
+	auth.POST("/login/totp", g.loginTOTP)
+	auth.POST("/totp/enroll", g.enrollTOTP)
+	auth.POST("/totp/activate", g.activateTOTP)
+
 	// Token refresh endpoint
 	authChain := g.middlewareManager.CreateAuthChain()
 	auth.POST("/refresh", authChain.Build()[len(authChain.Build())-1], g.refreshToken)
 
 	// Logout endpoint
 	auth.POST("/logout", authChain.Build()[len(authChain.Build())-1], g.logout)
+
+	// CSRF token endpoint for cookie-authenticated clients
+	if g.config.Load().Auth.CSRF.Enabled {
+		auth.GET("/csrf", g.issueCSRFToken)
+	}
 }
 
 // setupAdminRoutes sets up admin routes
@@ -126,26 +219,67 @@ func (g *Gateway) setupAdminRoutes() {
 	adminChain := g.middlewareManager.CreateAdminChain()
 	admin := g.router.Group("/admin", adminChain.Build()...)
 
+	allow := g.middlewareManager.RequirePermission
+
 	// Configuration management
-	admin.GET("/config", g.getConfig)
-	admin.POST("/config/reload", g.reloadConfig)
+	admin.GET("/config", allow(config.PermConfigRead), g.getConfig)
+	admin.PUT("/config", allow(config.PermConfigWrite), g.pushConfig)
+	admin.POST("/config/reload", allow(config.PermConfigWrite), g.reloadConfig)
+	admin.GET("/config/diff", allow(config.PermConfigRead), g.getConfigDiff)
+
+	// Cluster membership
+	admin.GET("/cluster", allow(config.PermClusterRead), g.getCluster)
 
 	// Service management
-	admin.GET("/services", g.getServices)
-	admin.POST("/services/:name", g.updateService)
-	admin.DELETE("/services/:name", g.deleteService)
+	admin.GET("/services", allow(config.PermServicesRead), g.getServices)
+	admin.POST("/services/:name", allow(config.PermServicesWrite), g.updateService)
+	admin.DELETE("/services/:name", allow(config.PermServicesWrite), g.deleteService)
+	admin.PUT("/services/:name/weights", allow(config.PermServicesWrite), g.updateTargetWeight)
+
+	// Service registry
+	admin.GET("/registry/services", allow(config.PermRegistryRead), g.listRegistrations)
+	admin.POST("/registry/services", allow(config.PermRegistryWrite), g.registerService)
+	admin.PUT("/registry/services/:id/heartbeat", allow(config.PermRegistryWrite), g.heartbeatService)
+	admin.DELETE("/registry/services/:id", allow(config.PermRegistryWrite), g.deregisterService)
 
 	// Statistics and monitoring
-	admin.GET("/stats", g.getStats)
-	admin.GET("/metrics/detailed", g.getDetailedMetrics)
+	admin.GET("/stats", allow(config.PermStatsRead), g.getStats)
+	admin.GET("/analytics/top", allow(config.PermStatsRead), g.getAnalyticsTop)
+	admin.GET("/metrics/detailed", allow(config.PermStatsRead), g.getDetailedMetrics)
+	admin.GET("/dashboard", allow(config.PermStatsRead), g.getDashboard)
+	admin.POST("/live/ticket", allow(config.PermStatsRead), g.issueLiveTicket)
+	admin.GET("/synthetics", allow(config.PermStatsRead), g.getSynthetics)
+
+	// Tenant cache usage and purging
+	admin.GET("/cache/tenants", allow(config.PermCacheRead), g.getCacheTenants)
+	admin.DELETE("/cache/tenants/:tenant", allow(config.PermCachePurge), g.purgeCacheTenant)
 
 	// Circuit breaker management
-	admin.GET("/circuit-breakers", g.getCircuitBreakers)
-	admin.POST("/circuit-breakers/:name/reset", g.resetCircuitBreaker)
+	admin.GET("/circuit-breakers", allow(config.PermBreakersRead), g.getCircuitBreakers)
+	admin.POST("/circuit-breakers/:name/reset", allow(config.PermBreakersReset), g.resetCircuitBreaker)
 
 	// Rate limiting management
-	admin.GET("/rate-limits", g.getRateLimits)
-	admin.POST("/rate-limits/:key/reset", g.resetRateLimit)
+	admin.GET("/rate-limits", allow(config.PermRateLimitsRead), g.getRateLimits)
+	admin.GET("/rate-limits/top", allow(config.PermRateLimitsRead), g.getRateLimitTop)
+	admin.POST("/rate-limits/:key/reset", allow(config.PermRateLimitsReset), g.resetRateLimit)
+
+	// Fault injection
+	admin.GET("/chaos", allow(config.PermChaosRead), g.getChaos)
+	admin.PUT("/chaos", allow(config.PermChaosWrite), g.setChaosEnabled)
+	admin.PUT("/chaos/rules/:name", allow(config.PermChaosWrite), g.setChaosRule)
+	admin.DELETE("/chaos/rules/:name", allow(config.PermChaosWrite), g.deleteChaosRule)
+
+	// Webhook deliveries
+	admin.GET("/webhooks", allow(config.PermWebhooksRead), g.getWebhooks)
+	admin.GET("/webhooks/deliveries", allow(config.PermWebhooksRead), g.getWebhookDeliveries)
+
+	// User management
+	admin.GET("/users", allow(config.PermUsersRead), g.listUsers)
+	admin.POST("/users", allow(config.PermUsersWrite), g.createUser)
+	admin.GET("/users/:id", allow(config.PermUsersRead), g.getUser)
+	admin.PATCH("/users/:id", allow(config.PermUsersWrite), g.updateUser)
+	admin.DELETE("/users/:id", allow(config.PermUsersWrite), g.deleteUser)
+	admin.DELETE("/users/:id/totp", allow(config.PermUsersWrite), g.resetUserTOTP)
 }
 
 // setupProtectedRoutes sets up protected API routes
@@ -161,12 +295,90 @@ func (g *Gateway) setupProtectedRoutes() {
 	api.GET("/validate", g.validateToken)
 }
 
+// authenticateRoute applies the authentication mode of a proxied request
+// and stores the token claims as the request's user. Optional routes
+// admit anonymous requests but still reject invalid tokens. Services with
+// their own audiences only accept bearer tokens issued for them. The error
+// response is written when it returns false.
+func (g *Gateway) authenticateRoute(c *gin.Context, mode string, audiences []string) bool {
+	if mode == config.AuthNone {
+		return true
+	}
+
+	// Sessions, Basic credentials and API keys authenticated earlier in
+	// the chain are accepted unless the service requires its own audiences
+	if len(audiences) == 0 {
+		if _, exists := c.Get(string(middleware.UserContextKey)); exists {
+			return true
+		}
+		if claims := middleware.AdminUser(c.Request); claims != nil {
+			c.Set("user", claims)
+			c.Set(string(middleware.UserContextKey), claims)
+			return true
+		}
+	}
+
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		if mode == config.AuthOptional {
+			return true
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+		return false
+	}
+	token, err := g.jwtAuth.ExtractTokenFromHeader(authHeader)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header"})
+		g.middlewareManager.ReportSecurityEvent(c, middleware.SecurityAuthFailure, "invalid_authorization_header", nil)
+		return false
+	}
+	var claims *auth.Claims
+	stop := servertiming.FromContext(c.Request.Context()).Start(servertiming.Auth)
+	if len(audiences) > 0 {
+		claims, err = g.jwtAuth.ValidateTokenFor(token, audiences)
+	} else {
+		claims, err = g.jwtAuth.ValidateToken(token)
+	}
+	stop()
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		g.middlewareManager.ReportSecurityEvent(c, middleware.SecurityAuthFailure, "invalid_token", nil)
+		return false
+	}
+
+	c.Set("user", claims)
+	c.Set(string(middleware.UserContextKey), claims)
+	return true
+}
+
+// serviceCORS returns the CORS policy of the service a proxied request
+// addresses, if it has its own
+func (g *Gateway) serviceCORS(r *http.Request) (*cors.Policy, bool) {
+	serviceName, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	serviceProxy := g.proxyManager.GetProxy(serviceName)
+	if serviceProxy == nil {
+		return nil, false
+	}
+	return serviceProxy.CORSPolicy(r.URL.Path)
+}
+
 // setupProxyRoutes sets up proxy routes for services
 func (g *Gateway) setupProxyRoutes() {
 	// Catch-all proxy route
 	g.router.NoRoute(g.proxyRequest)
 }
 
+// SetSynthetics registers the synthetic probe runner reported on
+// /admin/synthetics
+func (g *Gateway) SetSynthetics(runner *synthetics.Runner) {
+	g.synthetics = runner
+}
+
+// SetWebhooks registers the webhook dispatcher reported on /admin/webhooks
+func (g *Gateway) SetWebhooks(dispatcher *webhook.Dispatcher) {
+	g.webhooks = dispatcher
+}
+
 // Router returns the Gin router
 func (g *Gateway) Router() *gin.Engine {
 	return g.router
@@ -174,23 +386,100 @@ func (g *Gateway) Router() *gin.Engine {
 
 // Route handlers
 
-// healthCheck handles health check requests
+// healthResponse is the health report with per-service status
+type healthResponse struct {
+	health.Report
+	Services map[string]string `json:"services"`
+}
+
+// healthCheck reports the status of every dependency. It responds 503 when
+// a critical dependency fails.
 func (g *Gateway) healthCheck(c *gin.Context) {
-	health := map[string]interface{}{
-		"status":    "healthy",
-		"timestamp": "2024-01-01T00:00:00Z", // Would use actual timestamp
-		"version":   "1.0.0",
-		"services":  g.getServiceHealth(),
+	report := g.health.Run(c.Request.Context())
+
+	status := http.StatusOK
+	if report.Status == health.StatusFail {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, healthResponse{Report: report, Services: g.getServiceHealth()})
+}
+
+// liveness reports that the process is up and serving requests. It checks
+// no dependencies so an outage elsewhere does not get the gateway restarted.
+func (g *Gateway) liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"schema_version": health.SchemaVersion,
+		"status":         health.StatusPass,
+	})
+}
+
+// readiness reports whether the gateway should receive traffic, based on the
+// critical dependency checks
+func (g *Gateway) readiness(c *gin.Context) {
+	report := g.health.Run(c.Request.Context())
+
+	status := http.StatusOK
+	if report.Status == health.StatusFail {
+		status = http.StatusServiceUnavailable
 	}
+	c.JSON(status, report)
+}
+
+// checkConfig warns when the configuration file changed but could not be
+// reloaded, so the gateway runs an outdated configuration
+func (g *Gateway) checkConfig(ctx context.Context) error {
+	if g.configManager == nil {
+		return nil
+	}
+	if err := g.configManager.ReloadError(); err != nil {
+		version, loadedAt := g.configManager.Version()
+		return health.Degraded(fmt.Errorf("running config version %d (revision %s) loaded at %s: %w",
+			version, g.configManager.Revision(), loadedAt.Format(time.RFC3339), err))
+	}
+	return nil
+}
+
+// checkServices warns when any service has an open circuit breaker
+func (g *Gateway) checkServices(ctx context.Context) error {
+	var open []string
+	for service, status := range g.getServiceHealth() {
+		if status != "healthy" {
+			open = append(open, service)
+		}
+	}
+	if len(open) > 0 {
+		sort.Strings(open)
+		return health.Degraded(fmt.Errorf("circuit open for: %s", strings.Join(open, ", ")))
+	}
+	return nil
+}
 
-	c.JSON(http.StatusOK, health)
+// getSynthetics returns the latest synthetic probe results
+func (g *Gateway) getSynthetics(c *gin.Context) {
+	if g.synthetics == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+
+	results := g.synthetics.Results()
+	failing := 0
+	for _, result := range results {
+		if !result.Passed {
+			failing++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": true,
+		"failing": failing,
+		"probes":  results,
+	})
 }
 
 // gatewayInfo returns gateway information
 func (g *Gateway) gatewayInfo(c *gin.Context) {
 	info := map[string]interface{}{
 		"name":        "API Gateway",
-		"version":     "1.0.0",
+		"version":     gatewayVersion,
 		"description": "Production-grade API Gateway",
 		"build_date":  "2024-01-01",
 		"go_version":  "1.21",
@@ -212,6 +501,7 @@ func (g *Gateway) login(c *gin.Context) {
 	var loginReq struct {
 		Username string `json:"username" binding:"required"`
 		Password string `json:"password" binding:"required"`
+		Session  bool   `json:"session"` // Use a session cookie instead of a bearer token
 	}
 
 	if err := c.ShouldBindJSON(&loginReq); err != nil {
@@ -219,31 +509,99 @@ func (g *Gateway) login(c *gin.Context) {
 		return
 	}
 
-	// TODO: Integrate with actual authentication service
-	// For now, accept any credentials for demo purposes
-	if loginReq.Username == "admin" && loginReq.Password == "password" {
-		token, err := g.jwtAuth.GenerateToken(
-			"user123",
-			loginReq.Username,
-			"admin@example.com",
-			[]string{"admin", "user"},
-			map[string]string{"department": "engineering"},
-		)
-		if err != nil {
-			g.logger.Error("Failed to generate token", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
-			return
+	protected := g.config.Load().Auth.LoginProtection.Enabled
+	if protected && !g.admitLogin(c, loginReq.Username) {
+		return
+	}
+
+	subject, err := g.checkCredentials(c.Request.Context(), loginReq.Username, loginReq.Password)
+	switch {
+	case err == nil && subject.user != nil && g.users.MFARequired(subject.user):
+		// The password is right; the token waits for the second factor
+		g.challengeLogin(c, subject.user, loginReq.Session)
+	case err == nil:
+		if protected {
+			g.recordLogin(c, loginReq.Username, true)
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"token":   token,
-			"type":    "Bearer",
-			"expires": g.config.Auth.JWT.ExpirationTime.String(),
-		})
-		return
+		if body, ok := g.issueLogin(c, subject, loginReq.Session); ok {
+			c.JSON(http.StatusOK, body)
+		}
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		if protected {
+			g.recordLogin(c, loginReq.Username, false)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		g.middlewareManager.ReportSecurityEvent(c, middleware.SecurityAuthFailure, "invalid_credentials",
+			map[string]string{"user": loginReq.Username})
+	case errors.Is(err, identity.ErrDisabled), errors.Is(err, identity.ErrEmailUnverified):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		g.logger.Error("Failed to check credentials", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Authentication unavailable"})
+	}
+}
+
+// loginIdentity is the subject of a token issued by /auth/login
+type loginIdentity struct {
+	id       string
+	username string
+	email    string
+	roles    []string
+	metadata map[string]string
+	// user is set for users of the user store
+	user *identity.User
+}
+
+// userIdentity returns the login identity of a user store user
+func userIdentity(user *identity.User) *loginIdentity {
+	return &loginIdentity{id: user.ID, username: user.Username, email: user.Email, roles: user.Roles, user: user}
+}
+
+// issueLogin issues a token for a successful login and returns the response
+// body. With session set and sessions enabled, the token is kept in a
+// server-side session and the session cookie is set instead. On failure the
+// error response is written and ok is false.
+func (g *Gateway) issueLogin(c *gin.Context, subject *loginIdentity, session bool) (body gin.H, ok bool) {
+	token, err := g.jwtAuth.GenerateToken(subject.id, subject.username, subject.email, subject.roles, subject.metadata)
+	if err != nil {
+		g.logger.Error("Failed to generate token", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return nil, false
+	}
+
+	if session && g.config.Load().Auth.Session.Enabled {
+		return g.startSession(c, token)
+	}
+
+	return gin.H{
+		"token":   token,
+		"type":    "Bearer",
+		"expires": g.config.Load().Auth.JWT.ExpirationTime.String(),
+	}, true
+}
+
+// checkCredentials authenticates a login against the user store, or
+// against the demo credentials when there is none
+func (g *Gateway) checkCredentials(ctx context.Context, username, password string) (*loginIdentity, error) {
+	if g.users == nil {
+		if username == "admin" && password == "password" {
+			return &loginIdentity{
+				id:       "user123",
+				username: username,
+				email:    "admin@example.com",
+				roles:    []string{"admin", "user"},
+				metadata: map[string]string{"department": "engineering"},
+			}, nil
+		}
+		return nil, auth.ErrInvalidCredentials
 	}
 
-	c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+	user, err := g.users.Authenticate(ctx, username, password)
+	if err != nil {
+		return nil, err
+	}
+	return userIdentity(user), nil
 }
 
 // refreshToken handles token refresh requests
@@ -258,27 +616,78 @@ func (g *Gateway) refreshToken(c *gin.Context) {
 	newToken, err := g.jwtAuth.RefreshToken(token)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token refresh failed"})
+		g.middlewareManager.ReportSecurityEvent(c, middleware.SecurityAuthFailure, "refresh_failed", nil)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"token":   newToken,
 		"type":    "Bearer",
-		"expires": g.config.Auth.JWT.ExpirationTime.String(),
+		"expires": g.config.Load().Auth.JWT.ExpirationTime.String(),
+	})
+}
+
+// startSession stores the claims of a freshly issued token in a server-side
+// session and sets the session cookie instead of returning the token
+func (g *Gateway) startSession(c *gin.Context, token string) (gin.H, bool) {
+	claims, err := g.jwtAuth.ValidateToken(token)
+	if err != nil {
+		g.logger.Error("Failed to read claims for session", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return nil, false
+	}
+
+	session, err := g.middlewareManager.Sessions().Create(c.Request.Context(), claims)
+	if err != nil {
+		g.logger.Error("Failed to create session", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return nil, false
+	}
+
+	g.middlewareManager.SetSessionCookie(c, session)
+	return gin.H{
+		"type":    "Session",
+		"expires": g.config.Load().Auth.Session.AbsoluteTimeout.String(),
+	}, true
+}
+
+// issueCSRFToken sets a CSRF cookie and returns the token for the header
+func (g *Gateway) issueCSRFToken(c *gin.Context) {
+	token, err := g.middlewareManager.IssueCSRFToken(c)
+	if err != nil {
+		g.logger.Error("Failed to issue CSRF token", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue CSRF token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":  token,
+		"header": g.config.Load().Auth.CSRF.HeaderName,
 	})
 }
 
 // logout handles logout requests
 func (g *Gateway) logout(c *gin.Context) {
+	if sessionID := c.GetString(string(middleware.SessionIDKey)); sessionID != "" {
+		if err := g.middlewareManager.Sessions().Delete(c.Request.Context(), sessionID); err != nil {
+			g.logger.Error("Failed to delete session", zap.Error(err))
+		}
+		g.middlewareManager.ClearSessionCookie(c)
+	}
+
 	// In a production system, you might want to blacklist the token
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+	if c.GetString(string(middleware.SessionIDKey)) != "" {
+		g.middlewareManager.ReportSecurityEvent(c, middleware.SecurityTokenRevoked, "session_logout", nil)
+	}
 }
 
 // Admin handlers
 
-// getConfig returns the current configuration
+// getConfig returns the current configuration with its secrets redacted
 func (g *Gateway) getConfig(c *gin.Context) {
-	c.JSON(http.StatusOK, g.config)
+	c.Header("X-Config-Revision", g.configManager.Revision())
+	c.JSON(http.StatusOK, config.Redacted(g.config.Load()))
 }
 
 // reloadConfig reloads the configuration
@@ -289,14 +698,47 @@ func (g *Gateway) reloadConfig(c *gin.Context) {
 		return
 	}
 
-	g.config = g.configManager.Get()
+	g.config.Store(g.configManager.Get())
+	g.announce(cluster.TypeConfigReload, "config", nil)
 	c.JSON(http.StatusOK, gin.H{"message": "Configuration reloaded successfully"})
 }
 
-// getServices returns all registered services
+// pushConfig validates and applies a YAML configuration document, like
+// the gRPC PushConfig
+func (g *Gateway) pushConfig(c *gin.Context) {
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxPushedConfig))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read configuration"})
+		return
+	}
+	if len(data) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Configuration is required"})
+		return
+	}
+	if err := g.configManager.Apply(data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	g.config.Store(g.configManager.Get())
+	g.announce(cluster.TypeConfigPush, "config", configAnnouncement{Config: data})
+	version, _ := g.configManager.Version()
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Configuration applied successfully",
+		"version":  version,
+		"revision": g.configManager.Revision(),
+	})
+}
+
+// getServices returns all registered services and the health of their
+// targets
 func (g *Gateway) getServices(c *gin.Context) {
 	services := g.proxyManager.ListServices()
-	c.JSON(http.StatusOK, gin.H{"services": services})
+	sort.Strings(services)
+	c.JSON(http.StatusOK, gin.H{
+		"services": services,
+		"targets":  g.proxyManager.GetTargetHealth(),
+	})
 }
 
 // updateService updates a service configuration
@@ -313,6 +755,7 @@ func (g *Gateway) updateService(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	g.announceServiceUpdate(name, &serviceConfig)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Service updated successfully"})
 }
@@ -321,9 +764,45 @@ func (g *Gateway) updateService(c *gin.Context) {
 func (g *Gateway) deleteService(c *gin.Context) {
 	name := c.Param("name")
 	g.proxyManager.RemoveService(name)
+	g.announceServiceDelete(name)
 	c.JSON(http.StatusOK, gin.H{"message": "Service removed successfully"})
 }
 
+// updateTargetWeight changes the load balancing weight of a service target
+func (g *Gateway) updateTargetWeight(c *gin.Context) {
+	name := c.Param("name")
+
+	var req struct {
+		URL    string `json:"url" binding:"required"`
+		Weight int    `json:"weight" binding:"min=1"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	serviceProxy := g.proxyManager.GetProxy(name)
+	if serviceProxy == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		return
+	}
+
+	if err := serviceProxy.SetTargetWeight(req.URL, req.Weight); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, proxy.ErrTargetNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	g.announceTargetWeight(name, req.URL, req.Weight)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Target weight updated successfully",
+		"targets": serviceProxy.Targets(),
+	})
+}
+
 // getStats returns gateway statistics
 func (g *Gateway) getStats(c *gin.Context) {
 	stats := map[string]interface{}{
@@ -355,6 +834,7 @@ func (g *Gateway) resetCircuitBreaker(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
+	g.announceBreakerReset(name)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Circuit breaker reset successfully"})
 }
@@ -365,6 +845,22 @@ func (g *Gateway) getRateLimits(c *gin.Context) {
 	c.JSON(http.StatusOK, info)
 }
 
+// getRateLimitTop returns the keys most often rejected by the rate limiter
+// on this instance
+func (g *Gateway) getRateLimitTop(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > maxRateLimitOffenders {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+		return
+	}
+
+	offenders := g.rateLimiter.Offenders()
+	c.JSON(http.StatusOK, gin.H{
+		"window":    offenders.Window().String(),
+		"offenders": offenders.Top(limit),
+	})
+}
+
 // resetRateLimit resets rate limiting for a key
 func (g *Gateway) resetRateLimit(c *gin.Context) {
 	key := c.Param("key")
@@ -425,26 +921,121 @@ func (g *Gateway) proxyRequest(c *gin.Context) {
 
 	serviceName := parts[0]
 
+	// Let the proxy account for time already spent in the gateway
+	if start, ok := c.Get(string(middleware.StartTimeKey)); ok {
+		if startTime, ok := start.(time.Time); ok {
+			c.Request = c.Request.WithContext(proxy.WithStartTime(c.Request.Context(), startTime))
+		}
+	}
+
+	// Pass the client address resolved through trusted proxies upstream
+	c.Request = c.Request.WithContext(g.forwardingContext(c))
+
 	// Get proxy for service
 	serviceProxy := g.proxyManager.GetProxy(serviceName)
 	if serviceProxy == nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found: " + serviceName})
 		return
 	}
+	// Services behind a flag that is off are not routed
+	if !flagEnabled(c, serviceProxy) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found: " + serviceName})
+		return
+	}
+
+	version, err := serviceProxy.ResolveVersion(c.Request)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unsupported API version", "details": err.Error()})
+		return
+	}
+	if version.Deprecated {
+		version.SetHeaders(c.Writer.Header())
+		g.metricsManager.RecordDeprecatedCall(serviceName, version.Version)
+	}
+	// The version may be served by another service's pool
+	if version.Service != "" && version.Service != serviceName {
+		serviceName = version.Service
+		serviceProxy = g.proxyManager.GetProxy(serviceName)
+		if serviceProxy == nil || !flagEnabled(c, serviceProxy) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Service unavailable: " + serviceName})
+			return
+		}
+	}
+
+	if !g.authenticateRoute(c, serviceProxy.AuthMode(c.Request), serviceProxy.Audiences()) {
+		return
+	}
+	g.tagTenant(c)
+
+	// Internal users may be routed to a dark-launched preview service
+	var claim func(string) string
+	if claims := g.middlewareManager.RequestClaims(c); claims != nil {
+		claim = claims.Value
+	}
+	if preview := serviceProxy.PreviewService(c.Request, claim); preview != "" {
+		if previewProxy := g.proxyManager.GetProxy(preview); previewProxy == nil {
+			g.logger.Warn("Dark-launched service not found, using the stable service",
+				zap.String("service", serviceName),
+				zap.String("preview", preview))
+		} else if flagEnabled(c, previewProxy) {
+			// The preview applies its own authentication
+			if !g.authenticateRoute(c, previewProxy.AuthMode(c.Request), previewProxy.Audiences()) {
+				return
+			}
+			g.logger.Debug("Routing to dark-launched service",
+				zap.String("service", serviceName),
+				zap.String("preview", preview))
+			serviceName, serviceProxy = preview, previewProxy
+		}
+	}
+
+	if !g.applyDebugOverrides(c, serviceName, serviceProxy) {
+		return
+	}
+
+	if violations := serviceProxy.Validate(c.Request); len(violations) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "Request validation failed",
+			"violations": violations,
+		})
+		return
+	}
+
+	g.propagateClaims(c)
+	if err := g.attachInternalToken(c, serviceName); err != nil {
+		g.logger.Error("Failed to issue internal token", zap.Error(err), zap.String("service", serviceName))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	// Wait for a concurrency slot when the service is saturated
+	release, err := serviceProxy.Admit(c.Request.Context())
+	if err != nil {
+		g.rejectAdmission(c, serviceProxy, serviceName, err)
+		return
+	}
+	defer release()
 
 	// Execute with circuit breaker if configured
 	circuitBreaker := g.circuitManager.GetBreaker(serviceName)
 	if circuitBreaker != nil {
-		err := circuitBreaker.Call(func() error {
-			serviceProxy.ServeHTTP(c.Writer, c.Request)
-			return nil
+		err := circuitBreaker.CallHTTP(func() (int, error) {
+			return serviceProxy.Forward(c.Writer, c.Request)
 		})
 
-		if err != nil {
+		if circuit.IsRejected(err) {
 			g.logger.Error("Circuit breaker error", zap.Error(err), zap.String("service", serviceName))
+			if serviceProxy.ServeFallback(c.Writer, c.Request) {
+				return
+			}
 			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Service temporarily unavailable"})
 			return
 		}
+
+		// The upstream response (or error response) has already been written
+		if err != nil {
+			g.logger.Debug("Upstream call counted as failure", zap.Error(err), zap.String("service", serviceName))
+		}
 	} else {
 		serviceProxy.ServeHTTP(c.Writer, c.Request)
 	}
@@ -452,6 +1043,105 @@ func (g *Gateway) proxyRequest(c *gin.Context) {
 
 // Helper methods
 
+// flagEnabled reports whether the feature flag gating a service, if any, is
+// on for the request
+func flagEnabled(c *gin.Context, serviceProxy *proxy.ReverseProxy) bool {
+	flag := serviceProxy.Flag()
+	return flag == "" || featureflags.Enabled(c.Request.Context(), flag)
+}
+
+// rejectAdmission answers a request shed by the service's admission queue
+// with the service's fallback or a 503
+func (g *Gateway) rejectAdmission(c *gin.Context, serviceProxy *proxy.ReverseProxy, serviceName string, err error) {
+	if c.Request.Context().Err() != nil {
+		// The client gave up while queued
+		c.Status(proxy.StatusClientClosedRequest)
+		return
+	}
+
+	g.logger.Warn("Request shed by admission queue", zap.Error(err), zap.String("service", serviceName))
+	if serviceProxy.ServeFallback(c.Writer, c.Request) {
+		return
+	}
+	c.Header("Retry-After", "1")
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Service overloaded, retry later"})
+}
+
+// attachInternalToken replaces the client's credentials on the proxied
+// request with a short-lived token signed by the gateway for the service
+func (g *Gateway) attachInternalToken(c *gin.Context, serviceName string) error {
+	if g.internalTokens == nil {
+		return nil
+	}
+
+	// Resolve the client identity before its token is removed
+	return g.setInternalToken(c.Request.Header, g.middlewareManager.RequestClaims(c), serviceName)
+}
+
+// setInternalToken replaces the credentials in the headers with an internal
+// token for the service
+func (g *Gateway) setInternalToken(h http.Header, claims *auth.Claims, serviceName string) error {
+	token, err := g.internalTokens.Issue(claims, serviceName)
+	if err != nil {
+		return err
+	}
+
+	h.Del("Authorization")
+	header := g.config.Load().Auth.Internal.Header
+	if strings.EqualFold(header, "Authorization") {
+		h.Set("Authorization", "Bearer "+token)
+	} else {
+		h.Set(header, token)
+	}
+	return nil
+}
+
+// defaultClaimHeaders maps claims to upstream headers when none are configured
+var defaultClaimHeaders = map[string]string{
+	"user_id": "X-User-Id",
+	"roles":   "X-User-Roles",
+}
+
+// forwardingContext records for the proxy the client address resolved
+// through trusted proxies, and whether the peer is one whose forwarding
+// headers may be passed on
+func (g *Gateway) forwardingContext(c *gin.Context) context.Context {
+	ctx := proxy.WithClientIP(c.Request.Context(), c.ClientIP())
+	if proxyproto.Contains(g.trustedProxies, &net.TCPAddr{IP: net.ParseIP(c.RemoteIP())}) {
+		ctx = proxy.WithTrustedPeer(ctx)
+	}
+	return ctx
+}
+
+// propagateClaims replaces the identity headers on the proxied request with
+// values from the validated JWT, dropping any the client sent itself
+func (g *Gateway) propagateClaims(c *gin.Context) {
+	cfg := g.config.Load().Auth.ClaimHeaders
+	if !cfg.Enabled {
+		return
+	}
+
+	headers := cfg.Headers
+	if len(headers) == 0 {
+		headers = defaultClaimHeaders
+	}
+
+	for _, header := range headers {
+		c.Request.Header.Del(header)
+	}
+
+	claims := g.middlewareManager.RequestClaims(c)
+	if claims == nil {
+		return
+	}
+
+	for claim, header := range headers {
+		if value := claims.Value(claim); value != "" {
+			c.Request.Header.Set(header, value)
+		}
+	}
+}
+
 // getServiceHealth returns health status of all services
 func (g *Gateway) getServiceHealth() map[string]string {
 	services := g.proxyManager.ListServices()
@@ -472,4 +1162,3 @@ func (g *Gateway) getServiceHealth() map[string]string {
 
 	return health
 }
-
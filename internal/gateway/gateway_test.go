@@ -0,0 +1,141 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/auth"
+	"github.com/max/api-gateway/internal/circuit"
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/internal/middleware"
+	"github.com/max/api-gateway/internal/proxy"
+	"github.com/max/api-gateway/internal/ratelimit"
+	"github.com/max/api-gateway/pkg/metrics"
+)
+
+// TestPushConfigDuringRequests pushes configurations while other requests
+// read the current one; run with -race
+func TestPushConfigDuringRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zap.NewNop()
+	cfg := &config.Config{}
+	jwtAuth := auth.NewJWTAuth("test-secret", time.Hour, 24*time.Hour, "gateway", "gateway", "HS256", logger)
+	metricsManager := metrics.NewManager(logger)
+	rateLimiter := ratelimit.NewManager(&cfg.RateLimit, nil, logger)
+	gw := NewGateway(cfg, config.NewManager(logger), jwtAuth, rateLimiter,
+		circuit.NewManager(logger, metricsManager), proxy.NewProxyManager(logger, metricsManager),
+		middleware.NewManager(cfg, jwtAuth, rateLimiter, nil, metricsManager, logger), metricsManager, logger)
+
+	router := gin.New()
+	router.POST("/admin/config", gw.pushConfig)
+	router.GET("/admin/config", gw.getConfig)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				body := fmt.Sprintf("server:\n  port: %d\nauth:\n  jwt:\n    secret: push-secret-%d\n", 9000+j, i)
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/config", strings.NewReader(body)))
+				if w.Code != http.StatusOK {
+					t.Errorf("push = %d %s", w.Code, w.Body.String())
+					return
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/config", nil))
+				if w.Code != http.StatusOK {
+					t.Errorf("get = %d", w.Code)
+					return
+				}
+				gw.isAdminServerPath("/metrics")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := gw.config.Load().Server.Port; got != 9019 {
+		t.Errorf("port after the pushes = %d, want the last pushed 9019", got)
+	}
+}
+
+func TestPropagateClaims(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zap.NewNop()
+	jwtAuth := auth.NewJWTAuth("test-secret", time.Hour, 24*time.Hour, "gateway", "gateway", "HS256", logger)
+	token, err := jwtAuth.GenerateToken("alice", "alice", "", []string{"admin", "ops"}, map[string]string{"tenant_id": "acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		enabled bool
+		headers map[string]string
+		token   bool
+		want    map[string]string
+	}{
+		{
+			name:    "default headers",
+			enabled: true,
+			token:   true,
+			want:    map[string]string{"X-User-Id": "alice", "X-User-Roles": "admin,ops"},
+		},
+		{
+			name:    "custom mapping",
+			enabled: true,
+			headers: map[string]string{"tenant_id": "X-Tenant-Id", "user_id": "X-User-Id"},
+			token:   true,
+			want:    map[string]string{"X-Tenant-Id": "acme", "X-User-Id": "alice", "X-User-Roles": "spoofed"},
+		},
+		{
+			name:    "spoofed headers without a token",
+			enabled: true,
+			want:    map[string]string{"X-User-Id": "", "X-User-Roles": ""},
+		},
+		{
+			name:  "disabled",
+			token: true,
+			want:  map[string]string{"X-User-Id": "spoofed", "X-User-Roles": "spoofed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Auth.ClaimHeaders = config.ClaimHeadersConfig{Enabled: tt.enabled, Headers: tt.headers}
+			g := &Gateway{middlewareManager: middleware.NewManager(cfg, jwtAuth, nil, nil, nil, logger), logger: logger}
+			g.config.Store(cfg)
+
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/orders", nil)
+			for _, header := range []string{"X-User-Id", "X-User-Roles", "X-Tenant-Id"} {
+				c.Request.Header.Set(header, "spoofed")
+			}
+			if tt.token {
+				c.Request.Header.Set("Authorization", "Bearer "+token)
+			}
+
+			g.propagateClaims(c)
+			for header, want := range tt.want {
+				if got := c.Request.Header.Get(header); got != want {
+					t.Errorf("%s = %q, want %q", header, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package gateway
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// Header limits, as reported in metrics
+const (
+	limitHeaderBytes = "header_bytes"
+	limitHeaderCount = "header_count"
+	limitCookieBytes = "cookie_bytes"
+)
+
+// headerLimitBody is the response to requests over a header limit
+const headerLimitBody = `{"error":"Request header fields too large"}`
+
+// limitHeaders rejects requests over the server's header limits with 431
+// before they reach the router. The HTTP server enforces MaxHeaderBytes as
+// well, with some slack, for requests too large to be parsed.
+func (g *Gateway) limitHeaders(next http.Handler) http.Handler {
+	cfg := g.config.Load().Server
+	if cfg.MaxHeaderBytes <= 0 && cfg.MaxHeaders <= 0 && cfg.MaxCookieBytes <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limit := exceededHeaderLimit(r, cfg.MaxHeaderBytes, cfg.MaxHeaders, cfg.MaxCookieBytes); limit != "" {
+			g.logger.Warn("Request header limit exceeded",
+				zap.String("limit", limit),
+				zap.String("remote_addr", r.RemoteAddr),
+				zap.String("path", r.URL.Path))
+			if g.metricsManager != nil {
+				g.metricsManager.RecordHeaderLimitRejection(limit)
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(http.StatusRequestHeaderFieldsTooLarge)
+			w.Write([]byte(headerLimitBody))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// exceededHeaderLimit returns the first limit the request's headers exceed,
+// or "" if none. Sizes count each line as sent over HTTP/1.1, including the
+// request line and Host.
+func exceededHeaderLimit(r *http.Request, maxBytes, maxHeaders, maxCookieBytes int) string {
+	size := len(r.Method) + len(r.RequestURI) + len(r.Proto) + 4
+	size += len("Host: ") + len(r.Host) + 2
+	count, cookieBytes := 0, 0
+	for name, values := range r.Header {
+		for _, value := range values {
+			size += len(name) + len(value) + 4
+			count++
+		}
+		if name == "Cookie" {
+			for _, value := range values {
+				cookieBytes += len(value)
+			}
+		}
+	}
+
+	switch {
+	case maxBytes > 0 && size > maxBytes:
+		return limitHeaderBytes
+	case maxHeaders > 0 && count > maxHeaders:
+		return limitHeaderCount
+	case maxCookieBytes > 0 && cookieBytes > maxCookieBytes:
+		return limitCookieBytes
+	}
+	return ""
+}
@@ -0,0 +1,29 @@
+package gateway
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExceededHeaderLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{"within limits", map[string]string{"Accept": "*/*", "Cookie": "a=1"}, ""},
+		{"header bytes", map[string]string{"X-Large": strings.Repeat("x", 300)}, limitHeaderBytes},
+		{"header count", map[string]string{"A": "1", "B": "1", "C": "1", "D": "1"}, limitHeaderCount},
+		{"cookie bytes", map[string]string{"Cookie": "session=" + strings.Repeat("x", 60)}, limitCookieBytes},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "http://example.com/orders", nil)
+		for name, value := range tt.headers {
+			req.Header.Set(name, value)
+		}
+		if got := exceededHeaderLimit(req, 256, 3, 64); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
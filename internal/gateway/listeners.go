@@ -0,0 +1,90 @@
+package gateway
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// ListenerHandler returns the handler of a listener. Redirect listeners
+// send every request to HTTPS. When an admin listener is configured, the
+// /admin routes are served only there; the admin server also takes the
+// health checks and metrics. Header limits apply to every listener.
+func (g *Gateway) ListenerHandler(listener config.ListenerConfig) http.Handler {
+	return g.limitHeaders(g.listenerHandler(listener))
+}
+
+// listenerHandler returns the handler of a listener before header limits
+func (g *Gateway) listenerHandler(listener config.ListenerConfig) http.Handler {
+	switch listener.Role {
+	case config.ListenerRedirect:
+		return redirectHTTPS(listener.RedirectPort)
+	case config.ListenerAdmin:
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isAdminPath(r.URL.Path) {
+				http.NotFound(w, r)
+				return
+			}
+			g.router.ServeHTTP(w, r)
+		})
+	}
+
+	var hidden func(string) bool
+	switch {
+	case g.config.Load().Server.Admin.Enabled:
+		hidden = g.isAdminServerPath
+	case separateAdmin(g.config.Load().Server.Listeners):
+		hidden = isAdminPath
+	default:
+		return g.router
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hidden(r.URL.Path) {
+			http.NotFound(w, r)
+			return
+		}
+		g.router.ServeHTTP(w, r)
+	})
+}
+
+// separateAdmin reports whether the admin routes have their own listener
+func separateAdmin(listeners []config.ListenerConfig) bool {
+	for _, listener := range listeners {
+		if listener.Role == config.ListenerAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// isAdminPath reports whether a path is routed to the admin group
+func isAdminPath(path string) bool {
+	return path == "/admin" || strings.HasPrefix(path, "/admin/")
+}
+
+// redirectHTTPS redirects requests to the same URL over HTTPS on port, or
+// the default HTTPS port when port is 0
+func redirectHTTPS(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != 0 && port != 443 {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(port))
+		} else if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
+			host = "[" + host + "]"
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		status := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			// Keep the method and body of other requests
+			status = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, target, status)
+	})
+}
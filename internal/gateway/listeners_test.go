@@ -0,0 +1,28 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectHTTPS(t *testing.T) {
+	tests := []struct {
+		method, host string
+		port         int
+		status       int
+		location     string
+	}{
+		{http.MethodGet, "example.com", 0, http.StatusMovedPermanently, "https://example.com/orders?id=1"},
+		{http.MethodGet, "example.com:8080", 8443, http.StatusMovedPermanently, "https://example.com:8443/orders?id=1"},
+		{http.MethodPost, "[::1]:80", 443, http.StatusPermanentRedirect, "https://[::1]/orders?id=1"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "http://"+tt.host+"/orders?id=1", nil)
+		rec := httptest.NewRecorder()
+		redirectHTTPS(tt.port).ServeHTTP(rec, req)
+		if rec.Code != tt.status || rec.Header().Get("Location") != tt.location {
+			t.Errorf("%s %s: %d %s, want %d %s", tt.method, tt.host, rec.Code, rec.Header().Get("Location"), tt.status, tt.location)
+		}
+	}
+}
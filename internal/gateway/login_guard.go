@@ -0,0 +1,69 @@
+package gateway
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// admitLogin rejects attempts from locked out usernames and client IPs, and
+// attempts without a valid CAPTCHA once one is required. Errors from the
+// failure store let the attempt through so that a Redis outage does not
+// lock everyone out.
+func (g *Gateway) admitLogin(c *gin.Context, username string) bool {
+	guard := g.middlewareManager.LoginGuard()
+	ctx, clientIP := c.Request.Context(), c.ClientIP()
+
+	decision, err := guard.Check(ctx, username, clientIP)
+	if err != nil {
+		g.logger.Error("Failed to check login failures", zap.Error(err))
+		return true
+	}
+
+	if decision.RetryAfter > 0 {
+		seconds := int(math.Ceil(decision.RetryAfter.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":       "Too many failed login attempts",
+			"retry_after": seconds,
+		})
+		return false
+	}
+
+	if decision.CaptchaRequired {
+		ok, err := guard.VerifyCaptcha(ctx, c.GetHeader(guard.CaptchaHeader()), clientIP)
+		if err != nil {
+			g.logger.Error("Failed to verify CAPTCHA", zap.Error(err))
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "CAPTCHA verification unavailable"})
+			return false
+		}
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":            "CAPTCHA required",
+				"captcha_required": true,
+			})
+			return false
+		}
+	}
+	return true
+}
+
+// recordLogin counts a failed login, or clears the username's failures
+// after a successful one
+func (g *Gateway) recordLogin(c *gin.Context, username string, success bool) {
+	guard := g.middlewareManager.LoginGuard()
+	ctx, clientIP := c.Request.Context(), c.ClientIP()
+
+	var err error
+	if success {
+		err = guard.Success(ctx, username, clientIP)
+	} else {
+		err = guard.Failure(ctx, username, clientIP)
+	}
+	if err != nil {
+		g.logger.Error("Failed to record login attempt", zap.Error(err))
+	}
+}
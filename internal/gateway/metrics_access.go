@@ -0,0 +1,48 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/pkg/proxyproto"
+)
+
+// metricsAccess restricts the metrics endpoint of the gateway listeners to
+// scrapers presenting the configured bearer token or coming from the
+// allowed CIDRs, when either is set. The client IP is resolved through the
+// trusted proxies.
+func (g *Gateway) metricsAccess() gin.HandlerFunc {
+	cfg := g.config.Load().Monitoring.Prometheus
+	allowed, _ := proxyproto.ParseCIDRs(cfg.AllowedCIDRs)
+
+	return func(c *gin.Context) {
+		if cfg.Token == "" && len(allowed) == 0 {
+			c.Next()
+			return
+		}
+
+		bearer := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if cfg.Token != "" && subtle.ConstantTimeCompare([]byte(bearer), []byte(cfg.Token)) == 1 {
+			c.Next()
+			return
+		}
+		if ip := net.ParseIP(c.ClientIP()); ip != nil {
+			for _, network := range allowed {
+				if network.Contains(ip) {
+					c.Next()
+					return
+				}
+			}
+		}
+
+		g.logger.Warn("Rejected metrics request",
+			zap.String("client_ip", c.ClientIP()),
+			zap.String("path", c.Request.URL.Path))
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+	}
+}
@@ -0,0 +1,45 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestMetricsAccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{}
+	cfg.Monitoring.Prometheus = config.PrometheusConfig{Token: "scrape", AllowedCIDRs: []string{"10.0.0.0/8"}}
+	g := &Gateway{logger: zap.NewNop()}
+	g.config.Store(cfg)
+
+	router := gin.New()
+	router.GET("/metrics", g.metricsAccess(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name, remote, token string
+		status              int
+	}{
+		{"allowed network", "10.1.2.3:5000", "", http.StatusOK},
+		{"valid token", "192.0.2.1:5000", "scrape", http.StatusOK},
+		{"wrong token", "192.0.2.1:5000", "guess", http.StatusForbidden},
+		{"neither", "192.0.2.1:5000", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.RemoteAddr = tt.remote
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.status)
+		}
+	}
+}
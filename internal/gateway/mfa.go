@@ -0,0 +1,244 @@
+package gateway
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/identity"
+	"github.com/max/api-gateway/internal/middleware"
+)
+
+// challengeLogin answers a login whose password was accepted with a
+// challenge for the second factor, or for enrollment when the user must
+// use TOTP but has not enrolled yet
+func (g *Gateway) challengeLogin(c *gin.Context, user *identity.User, session bool) {
+	challenge, err := g.challenges.Create(c.Request.Context(), user.ID, !user.TOTPEnabled, session)
+	if err != nil {
+		g.logger.Error("Failed to create login challenge", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Authentication unavailable"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"mfa_required":        true,
+		"mfa_token":           challenge.ID,
+		"enrollment_required": challenge.Enroll,
+		"expires":             g.challenges.TTL().String(),
+	})
+}
+
+// loginTOTP completes a login challenge with a TOTP or backup code
+func (g *Gateway) loginTOTP(c *gin.Context) {
+	if !g.requireUsers(c) {
+		return
+	}
+
+	var req struct {
+		MFAToken string `json:"mfa_token" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	challenge, user, ok := g.loadChallenge(c, req.MFAToken)
+	if !ok {
+		return
+	}
+	if challenge.Enroll {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "TOTP enrollment required", "enrollment_required": true})
+		return
+	}
+
+	protected := g.config.Load().Auth.LoginProtection.Enabled
+	if protected && !g.admitLogin(c, user.Username) {
+		return
+	}
+
+	err := g.users.VerifySecondFactor(ctx, user, req.Code)
+	if errors.Is(err, identity.ErrInvalidCode) {
+		if err := g.challenges.Fail(ctx, challenge); err != nil {
+			g.logger.Error("Failed to record login challenge attempt", zap.Error(err))
+		}
+		if protected {
+			g.recordLogin(c, user.Username, false)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authentication code"})
+		g.middlewareManager.ReportSecurityEvent(c, middleware.SecurityAuthFailure, "invalid_mfa_code",
+			map[string]string{"user": user.Username})
+		return
+	}
+	if err != nil {
+		g.userError(c, err)
+		return
+	}
+
+	g.challenges.Delete(ctx, challenge.ID)
+	if protected {
+		g.recordLogin(c, user.Username, true)
+	}
+	if body, ok := g.issueLogin(c, userIdentity(user), challenge.Session); ok {
+		c.JSON(http.StatusOK, body)
+	}
+}
+
+// totpRequest is the body of the enrollment endpoints. Users enrolling
+// during login pass their enrollment challenge; others authenticate with
+// their bearer token.
+type totpRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+// enrollTOTP creates a TOTP secret and returns its provisioning URI. The
+// secret is used once activated with a code from the authenticator app.
+func (g *Gateway) enrollTOTP(c *gin.Context) {
+	if !g.requireUsers(c) {
+		return
+	}
+
+	var req totpRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	_, user, ok := g.totpUser(c, req.MFAToken)
+	if !ok {
+		return
+	}
+
+	secret, uri, err := g.users.EnrollTOTP(c.Request.Context(), user)
+	if errors.Is(err, identity.ErrTOTPEnabled) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		g.userError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"secret": secret, "provisioning_uri": uri})
+}
+
+// activateTOTP enables TOTP with a first code and returns the backup codes.
+// Activating during login also completes the login.
+func (g *Gateway) activateTOTP(c *gin.Context) {
+	if !g.requireUsers(c) {
+		return
+	}
+
+	var req totpRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	challenge, user, ok := g.totpUser(c, req.MFAToken)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	codes, err := g.users.ActivateTOTP(ctx, user.ID, req.Code)
+	switch {
+	case errors.Is(err, identity.ErrInvalidCode):
+		if challenge != nil {
+			if err := g.challenges.Fail(ctx, challenge); err != nil {
+				g.logger.Error("Failed to record login challenge attempt", zap.Error(err))
+			}
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authentication code"})
+		return
+	case errors.Is(err, identity.ErrTOTPEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, identity.ErrTOTPNotEnrolling):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		g.userError(c, err)
+		return
+	}
+
+	g.logger.Info("User enrolled TOTP", zap.String("user_id", user.ID), zap.String("username", user.Username))
+	if challenge == nil {
+		c.JSON(http.StatusOK, gin.H{"backup_codes": codes})
+		return
+	}
+
+	g.challenges.Delete(ctx, challenge.ID)
+	if g.config.Load().Auth.LoginProtection.Enabled {
+		g.recordLogin(c, user.Username, true)
+	}
+	body, ok := g.issueLogin(c, userIdentity(user), challenge.Session)
+	if !ok {
+		return
+	}
+	body["backup_codes"] = codes
+	c.JSON(http.StatusOK, body)
+}
+
+// totpUser returns the user enrolling TOTP: the user of an enrollment
+// challenge, or else of the bearer token. The error response is written
+// when ok is false.
+func (g *Gateway) totpUser(c *gin.Context, mfaToken string) (challenge *identity.Challenge, user *identity.User, ok bool) {
+	if mfaToken != "" {
+		challenge, user, ok = g.loadChallenge(c, mfaToken)
+		if ok && !challenge.Enroll {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Login challenge is not for enrollment"})
+			return nil, nil, false
+		}
+		return challenge, user, ok
+	}
+
+	token, err := g.jwtAuth.ExtractTokenFromHeader(c.GetHeader("Authorization"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return nil, nil, false
+	}
+	claims, err := g.jwtAuth.ValidateToken(token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return nil, nil, false
+	}
+
+	user, err = g.users.Get(c.Request.Context(), claims.UserID)
+	if err != nil {
+		g.userError(c, err)
+		return nil, nil, false
+	}
+	return nil, user, true
+}
+
+// loadChallenge returns a pending login challenge and its user. The error
+// response is written when ok is false.
+func (g *Gateway) loadChallenge(c *gin.Context, id string) (*identity.Challenge, *identity.User, bool) {
+	ctx := c.Request.Context()
+	challenge, err := g.challenges.Get(ctx, id)
+	if errors.Is(err, identity.ErrChallengeNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login challenge expired, log in again"})
+		return nil, nil, false
+	}
+	if err != nil {
+		g.logger.Error("Failed to load login challenge", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Authentication unavailable"})
+		return nil, nil, false
+	}
+
+	user, err := g.users.Get(ctx, challenge.UserID)
+	if err != nil {
+		g.userError(c, err)
+		return nil, nil, false
+	}
+	if user.Disabled {
+		g.challenges.Delete(ctx, challenge.ID)
+		c.JSON(http.StatusForbidden, gin.H{"error": identity.ErrDisabled.Error()})
+		return nil, nil, false
+	}
+	return challenge, user, true
+}
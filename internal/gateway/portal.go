@@ -0,0 +1,247 @@
+package gateway
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/auth"
+	"github.com/max/api-gateway/internal/middleware"
+	"github.com/max/api-gateway/internal/usage"
+)
+
+//go:embed portal_ui
+var portalAssets embed.FS
+
+// defaultUsageWindow is the usage period reported when none is requested
+const defaultUsageWindow = time.Hour
+
+// portalKey is an API key as shown to its owner
+type portalKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Prefix    string    `json:"prefix"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// keyUsage is the usage of one API key over the requested window
+type keyUsage struct {
+	portalKey
+	Usage     usage.Counts `json:"usage"`
+	ErrorRate float64      `json:"error_rate"`
+}
+
+// setupPortalRoutes sets up the developer portal, where authenticated
+// developers manage their own API keys and view their usage
+func (g *Gateway) setupPortalRoutes() {
+	if !g.config.Load().Portal.Enabled {
+		return
+	}
+
+	authChain := g.middlewareManager.CreateAuthChain()
+	portal := g.router.Group("/portal", authChain.Build()...)
+	portal.Use(g.requireInteractiveLogin)
+
+	portal.GET("/keys", g.listAPIKeys)
+	portal.POST("/keys", g.createAPIKey)
+	portal.DELETE("/keys/:id", g.revokeAPIKey)
+	portal.GET("/keys/:id/usage", g.getAPIKeyUsage)
+	portal.GET("/usage", g.getPortalUsage)
+
+	if g.config.Load().Portal.UI {
+		assets, err := fs.Sub(portalAssets, "portal_ui")
+		if err != nil {
+			g.logger.Error("Failed to load portal assets", zap.Error(err))
+			return
+		}
+		// The page is public; the data it shows needs a token
+		g.router.StaticFS("/portal/ui", http.FS(assets))
+	}
+}
+
+// requireInteractiveLogin rejects requests authenticated with an API key,
+// so a leaked key cannot be used to mint or revoke others
+func (g *Gateway) requireInteractiveLogin(c *gin.Context) {
+	if _, exists := c.Get(string(middleware.APIKeyIDKey)); exists {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API keys cannot manage API keys"})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// portalOwner returns the developer the request is authenticated as
+func portalOwner(c *gin.Context) string {
+	value, _ := c.Get("user")
+	claims, _ := value.(*auth.Claims)
+	if claims == nil {
+		return ""
+	}
+	return claims.UserID
+}
+
+// listAPIKeys returns the caller's API keys
+func (g *Gateway) listAPIKeys(c *gin.Context) {
+	keys, err := g.middlewareManager.APIKeys().List(c.Request.Context(), portalOwner(c))
+	if err != nil {
+		g.logger.Error("Failed to list API keys", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list API keys"})
+		return
+	}
+
+	views := make([]portalKey, 0, len(keys))
+	for _, key := range keys {
+		views = append(views, newPortalKey(key))
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": views})
+}
+
+// createAPIKey issues an API key to the caller. The secret is only returned
+// in this response.
+func (g *Gateway) createAPIKey(c *gin.Context) {
+	var req struct {
+		Name string `json:"name" binding:"required,max=64"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	owner := portalOwner(c)
+	store := g.middlewareManager.APIKeys()
+
+	existing, err := store.List(c.Request.Context(), owner)
+	if err != nil {
+		g.logger.Error("Failed to list API keys", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+	if len(existing) >= g.config.Load().Portal.MaxKeysPerUser {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "API key limit reached",
+			"limit": g.config.Load().Portal.MaxKeysPerUser,
+		})
+		return
+	}
+
+	key, secret, err := store.Create(c.Request.Context(), owner, strings.TrimSpace(req.Name), g.config.Load().Portal.KeyTTL)
+	if err != nil {
+		g.logger.Error("Failed to create API key", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	g.logger.Info("API key created", zap.String("owner", owner), zap.String("key_id", key.ID))
+	c.JSON(http.StatusCreated, gin.H{
+		"key":    newPortalKey(key),
+		"secret": secret,
+		"header": g.config.Load().Auth.API.Header,
+	})
+}
+
+// revokeAPIKey revokes one of the caller's API keys
+func (g *Gateway) revokeAPIKey(c *gin.Context) {
+	id := c.Param("id")
+	owner := portalOwner(c)
+
+	if err := g.middlewareManager.APIKeys().Revoke(c.Request.Context(), owner, id); err != nil {
+		if errors.Is(err, auth.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+		g.logger.Error("Failed to revoke API key", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+	g.middlewareManager.Usage().Forget(id)
+
+	g.logger.Info("API key revoked", zap.String("owner", owner), zap.String("key_id", id))
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+	g.middlewareManager.ReportSecurityEvent(c, middleware.SecurityTokenRevoked, "api_key_revoked",
+		map[string]string{"user": owner, "key_id": id})
+}
+
+// getAPIKeyUsage returns the usage history of one of the caller's keys
+func (g *Gateway) getAPIKeyUsage(c *gin.Context) {
+	id := c.Param("id")
+	keys, err := g.middlewareManager.APIKeys().List(c.Request.Context(), portalOwner(c))
+	if err != nil {
+		g.logger.Error("Failed to list API keys", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load usage"})
+		return
+	}
+
+	for _, key := range keys {
+		if key.ID == id {
+			c.JSON(http.StatusOK, gin.H{
+				"key":      newPortalKey(key),
+				"interval": g.config.Load().Portal.UsageInterval.String(),
+				"buckets":  g.middlewareManager.Usage().Buckets(id),
+			})
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+}
+
+// getPortalUsage returns the caller's usage per key over a window, with
+// the rate limit that applies to them
+func (g *Gateway) getPortalUsage(c *gin.Context) {
+	window := defaultUsageWindow
+	if raw := c.Query("window"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid window"})
+			return
+		}
+		window = min(parsed, g.config.Load().Portal.UsageRetention)
+	}
+
+	owner := portalOwner(c)
+	keys, err := g.middlewareManager.APIKeys().List(c.Request.Context(), owner)
+	if err != nil {
+		g.logger.Error("Failed to list API keys", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load usage"})
+		return
+	}
+
+	tracker := g.middlewareManager.Usage()
+	var total usage.Counts
+	perKey := make([]keyUsage, 0, len(keys))
+	for _, key := range keys {
+		counts := tracker.Total(key.ID, window)
+		total.Add(counts)
+		perKey = append(perKey, keyUsage{portalKey: newPortalKey(key), Usage: counts, ErrorRate: counts.ErrorRate()})
+	}
+
+	limit, err := g.rateLimiter.GetLimitInfo(owner)
+	if err != nil {
+		g.logger.Warn("Failed to load rate limit", zap.Error(err))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"window":     window.String(),
+		"total":      total,
+		"error_rate": total.ErrorRate(),
+		"keys":       perKey,
+		"rate_limit": limit,
+	})
+}
+
+// newPortalKey hides the stored digest of a key
+func newPortalKey(key *auth.APIKey) portalKey {
+	return portalKey{
+		ID:        key.ID,
+		Name:      key.Name,
+		Prefix:    key.Prefix,
+		CreatedAt: key.CreatedAt,
+		ExpiresAt: key.ExpiresAt,
+	}
+}
@@ -0,0 +1,115 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/max/api-gateway/internal/cluster"
+	"github.com/max/api-gateway/internal/registry"
+)
+
+// registrationAnnouncement carries a registration made or removed on a
+// replica
+type registrationAnnouncement struct {
+	Registration registry.Registration `json:"registration"`
+	Removed      bool                  `json:"removed,omitempty"`
+}
+
+// SetRegistry registers the service registry backends use at
+// /admin/registry/services. Registrations are shared with the cluster
+// joined afterwards.
+func (g *Gateway) SetRegistry(reg *registry.Registry) {
+	g.registry = reg
+	reg.SetNotifier(func(r registry.Registration, removed bool) {
+		g.announce(cluster.TypeRegistration, "registration:"+r.ID,
+			registrationAnnouncement{Registration: r, Removed: removed})
+	})
+}
+
+// listRegistrations returns the live registrations
+func (g *Gateway) listRegistrations(c *gin.Context) {
+	if g.registry == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":       true,
+		"registrations": g.registry.List(),
+	})
+}
+
+// registerService registers a backend, or renews its registration
+func (g *Gateway) registerService(c *gin.Context) {
+	if g.registry == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service registry is not enabled"})
+		return
+	}
+
+	var req struct {
+		ID         string   `json:"id"`
+		Name       string   `json:"name" binding:"required"`
+		URLs       []string `json:"urls" binding:"required"`
+		HealthPath string   `json:"health_path"`
+		TTLSeconds int      `json:"ttl_seconds"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	reg, err := g.registry.Register(registry.Registration{
+		ID:         req.ID,
+		Service:    req.Name,
+		URLs:       req.URLs,
+		HealthPath: req.HealthPath,
+		TTLSeconds: req.TTLSeconds,
+	})
+	if err != nil {
+		c.JSON(registryErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, reg)
+}
+
+// heartbeatService renews a registration
+func (g *Gateway) heartbeatService(c *gin.Context) {
+	if g.registry == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service registry is not enabled"})
+		return
+	}
+
+	reg, err := g.registry.Heartbeat(c.Param("id"))
+	if err != nil {
+		c.JSON(registryErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, reg)
+}
+
+// deregisterService removes a registration
+func (g *Gateway) deregisterService(c *gin.Context) {
+	if g.registry == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service registry is not enabled"})
+		return
+	}
+
+	if err := g.registry.Deregister(c.Param("id")); err != nil {
+		c.JSON(registryErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Registration removed successfully"})
+}
+
+// registryErrorStatus maps a registry error to its HTTP status
+func registryErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, registry.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, registry.ErrConflict):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
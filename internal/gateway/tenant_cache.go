@@ -0,0 +1,60 @@
+package gateway
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/cache"
+)
+
+// SetTenantCache registers the tenant cache reported and purged on
+// /admin/cache/tenants
+func (g *Gateway) SetTenantCache(tenantCache *cache.TenantCache) {
+	g.tenantCache = tenantCache
+}
+
+// tagTenant puts the tenant of the request's token in its context, so
+// cached responses are kept in the tenant's namespace
+func (g *Gateway) tagTenant(c *gin.Context) {
+	if g.tenantCache == nil {
+		return
+	}
+	claims := g.middlewareManager.RequestClaims(c)
+	if claims == nil {
+		return
+	}
+	if tenant := claims.Value(g.config.Load().Cache.Tenants.Claim); tenant != "" {
+		c.Request = c.Request.WithContext(cache.WithTenant(c.Request.Context(), tenant))
+	}
+}
+
+// getCacheTenants returns the cache usage and quota of every tenant
+func (g *Gateway) getCacheTenants(c *gin.Context) {
+	if g.tenantCache == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": true,
+		"tenants": g.tenantCache.Usage(),
+	})
+}
+
+// purgeCacheTenant deletes every cached response of a tenant
+func (g *Gateway) purgeCacheTenant(c *gin.Context) {
+	if g.tenantCache == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tenant cache is not enabled"})
+		return
+	}
+
+	tenant := c.Param("tenant")
+	deleted, err := g.tenantCache.Purge(c.Request.Context(), tenant)
+	if err != nil {
+		g.logger.Error("Failed to purge tenant cache", zap.String("tenant", tenant), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge tenant cache"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tenant": tenant, "keys_deleted": deleted})
+}
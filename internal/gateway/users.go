@@ -0,0 +1,172 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/identity"
+)
+
+// maxUsersPage bounds the users listed per request
+const maxUsersPage = 500
+
+// SetUsers registers the user store that /auth/login authenticates against
+// and /admin/users manages, with the store of logins waiting for their
+// second factor
+func (g *Gateway) SetUsers(store *identity.Store, challenges *identity.ChallengeStore) {
+	g.users = store
+	g.challenges = challenges
+}
+
+// listUsers returns a page of users
+func (g *Gateway) listUsers(c *gin.Context) {
+	if !g.requireUsers(c) {
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > maxUsersPage {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must not be negative"})
+		return
+	}
+
+	users, err := g.users.List(c.Request.Context(), limit, offset)
+	if err != nil {
+		g.userError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"users": users, "limit": limit, "offset": offset})
+}
+
+// getUser returns one user
+func (g *Gateway) getUser(c *gin.Context) {
+	if !g.requireUsers(c) {
+		return
+	}
+
+	user, err := g.users.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		g.userError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// createUser adds a user
+func (g *Gateway) createUser(c *gin.Context) {
+	if !g.requireUsers(c) {
+		return
+	}
+
+	var req identity.NewUser
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := g.users.Create(c.Request.Context(), req)
+	if err != nil {
+		g.userError(c, err)
+		return
+	}
+
+	g.logger.Info("User created",
+		zap.String("user_id", user.ID),
+		zap.String("username", user.Username),
+		zap.Strings("roles", user.Roles),
+		zap.String("admin", adminUser(c)))
+	c.JSON(http.StatusCreated, user)
+}
+
+// updateUser changes the fields present in the request
+func (g *Gateway) updateUser(c *gin.Context) {
+	if !g.requireUsers(c) {
+		return
+	}
+
+	var req identity.UserUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := g.users.Update(c.Request.Context(), c.Param("id"), req)
+	if err != nil {
+		g.userError(c, err)
+		return
+	}
+
+	g.logger.Info("User updated",
+		zap.String("user_id", user.ID),
+		zap.String("username", user.Username),
+		zap.Bool("password_changed", req.Password != nil),
+		zap.Bool("disabled", user.Disabled),
+		zap.String("admin", adminUser(c)))
+	c.JSON(http.StatusOK, user)
+}
+
+// deleteUser removes a user
+func (g *Gateway) deleteUser(c *gin.Context) {
+	if !g.requireUsers(c) {
+		return
+	}
+
+	if err := g.users.Delete(c.Request.Context(), c.Param("id")); err != nil {
+		g.userError(c, err)
+		return
+	}
+
+	g.logger.Info("User deleted",
+		zap.String("user_id", c.Param("id")),
+		zap.String("admin", adminUser(c)))
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+}
+
+// resetUserTOTP removes a user's authenticator and backup codes, so that a
+// user who lost them can enroll again
+func (g *Gateway) resetUserTOTP(c *gin.Context) {
+	if !g.requireUsers(c) {
+		return
+	}
+
+	if err := g.users.DisableTOTP(c.Request.Context(), c.Param("id")); err != nil {
+		g.userError(c, err)
+		return
+	}
+
+	g.logger.Warn("User TOTP reset",
+		zap.String("user_id", c.Param("id")),
+		zap.String("admin", adminUser(c)))
+	c.JSON(http.StatusOK, gin.H{"message": "TOTP reset successfully"})
+}
+
+// requireUsers answers 404 when no user store is configured
+func (g *Gateway) requireUsers(c *gin.Context) bool {
+	if g.users == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User store is not configured"})
+		return false
+	}
+	return true
+}
+
+// userError answers a user store error
+func (g *Gateway) userError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, identity.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case errors.Is(err, identity.ErrExists):
+		c.JSON(http.StatusConflict, gin.H{"error": identity.ErrExists.Error()})
+	case errors.Is(err, identity.ErrInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		g.logger.Error("User store request failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "User store unavailable"})
+	}
+}
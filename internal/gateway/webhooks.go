@@ -0,0 +1,41 @@
+package gateway
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxWebhookDeliveries bounds the deliveries returned at once
+const maxWebhookDeliveries = 1000
+
+// getWebhooks returns the state of every webhook endpoint
+func (g *Gateway) getWebhooks(c *gin.Context) {
+	if g.webhooks == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":   true,
+		"endpoints": g.webhooks.Endpoints(),
+	})
+}
+
+// getWebhookDeliveries returns recent webhook delivery attempts, newest
+// first, optionally of one endpoint
+func (g *Gateway) getWebhookDeliveries(c *gin.Context) {
+	if g.webhooks == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhooks are not enabled"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > maxWebhookDeliveries {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"deliveries": g.webhooks.Deliveries(c.Query("endpoint"), limit),
+	})
+}
@@ -0,0 +1,169 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// SchemaVersion is the version of the health report format. It changes
+// whenever fields are renamed or removed.
+const SchemaVersion = "1"
+
+// Status is the outcome of a check or of the whole report
+type Status string
+
+const (
+	// StatusPass means the dependency is healthy
+	StatusPass Status = "pass"
+	// StatusWarn means the gateway works in a degraded mode
+	StatusWarn Status = "warn"
+	// StatusFail means the dependency is unavailable
+	StatusFail Status = "fail"
+)
+
+// CheckFunc probes a dependency. A nil error passes; errors wrapped with
+// Degraded warn; any other error fails the check.
+type CheckFunc func(ctx context.Context) error
+
+// degradedError marks a check error as a warning
+type degradedError struct {
+	err error
+}
+
+func (e *degradedError) Error() string { return e.err.Error() }
+func (e *degradedError) Unwrap() error { return e.err }
+
+// Degraded wraps err so the check reports a warning instead of a failure
+func Degraded(err error) error {
+	return &degradedError{err: err}
+}
+
+// Result is the outcome of a single check
+type Result struct {
+	Status    Status  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the aggregated health of the gateway
+type Report struct {
+	SchemaVersion string            `json:"schema_version"`
+	Status        Status            `json:"status"`
+	Version       string            `json:"version"`
+	Timestamp     time.Time         `json:"timestamp"`
+	UptimeSeconds float64           `json:"uptime_seconds"`
+	Checks        map[string]Result `json:"checks"`
+}
+
+// check is a registered dependency check
+type check struct {
+	name     string
+	critical bool
+	fn       CheckFunc
+}
+
+// Checker runs dependency checks. Critical checks decide readiness; the
+// others only degrade the reported status.
+type Checker struct {
+	version   string
+	timeout   time.Duration
+	critical  map[string]bool
+	startTime time.Time
+
+	mu     sync.RWMutex
+	checks []check
+}
+
+// NewChecker creates a checker. Checks named in critical fail readiness
+// when they fail; each check is bounded by timeout.
+func NewChecker(version string, timeout time.Duration, critical []string) *Checker {
+	criticalSet := make(map[string]bool, len(critical))
+	for _, name := range critical {
+		criticalSet[name] = true
+	}
+
+	return &Checker{
+		version:   version,
+		timeout:   timeout,
+		critical:  criticalSet,
+		startTime: time.Now(),
+	}
+}
+
+// Register adds a dependency check
+func (c *Checker) Register(name string, fn CheckFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, check{name: name, critical: c.critical[name], fn: fn})
+}
+
+// Run executes all checks concurrently and aggregates the results
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mu.RLock()
+	checks := make([]check, len(c.checks))
+	copy(checks, c.checks)
+	c.mu.RUnlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, chk := range checks {
+		wg.Add(1)
+		go func(i int, chk check) {
+			defer wg.Done()
+			results[i] = c.runCheck(ctx, chk)
+		}(i, chk)
+	}
+	wg.Wait()
+
+	report := Report{
+		SchemaVersion: SchemaVersion,
+		Status:        StatusPass,
+		Version:       c.version,
+		Timestamp:     time.Now().UTC(),
+		UptimeSeconds: time.Since(c.startTime).Seconds(),
+		Checks:        make(map[string]Result, len(checks)),
+	}
+	for i, chk := range checks {
+		result := results[i]
+		report.Checks[chk.name] = result
+
+		switch {
+		case result.Status == StatusFail && result.Critical:
+			report.Status = StatusFail
+		case result.Status != StatusPass && report.Status == StatusPass:
+			report.Status = StatusWarn
+		}
+	}
+	return report
+}
+
+// runCheck executes a single check with the timeout applied
+func (c *Checker) runCheck(ctx context.Context, chk check) Result {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := chk.fn(ctx)
+	result := Result{
+		Status:    StatusPass,
+		Critical:  chk.critical,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+		var degraded *degradedError
+		if errors.As(err, &degraded) {
+			result.Status = StatusWarn
+		} else {
+			result.Status = StatusFail
+		}
+	}
+	return result
+}
@@ -0,0 +1,43 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChecker_AggregatesStatus(t *testing.T) {
+	checker := NewChecker("1.0.0", time.Second, []string{"redis"})
+	checker.Register("redis", func(ctx context.Context) error { return nil })
+	checker.Register("events", func(ctx context.Context) error { return errors.New("broker unreachable") })
+
+	report := checker.Run(context.Background())
+	if report.Status != StatusWarn {
+		t.Errorf("Expected non-critical failure to warn, got %s", report.Status)
+	}
+	if report.Checks["events"].Status != StatusFail || report.Checks["events"].Error == "" {
+		t.Errorf("Expected events check to fail with an error, got %+v", report.Checks["events"])
+	}
+	if !report.Checks["redis"].Critical || report.SchemaVersion != SchemaVersion {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+
+	checker.Register("config", func(ctx context.Context) error { return Degraded(errors.New("reload failed")) })
+	if status := checker.Run(context.Background()).Checks["config"].Status; status != StatusWarn {
+		t.Errorf("Expected degraded check to warn, got %s", status)
+	}
+}
+
+func TestChecker_CriticalFailureAndTimeout(t *testing.T) {
+	checker := NewChecker("1.0.0", 10*time.Millisecond, []string{"redis"})
+	checker.Register("redis", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	report := checker.Run(context.Background())
+	if report.Status != StatusFail {
+		t.Errorf("Expected critical timeout to fail the report, got %s", report.Status)
+	}
+}
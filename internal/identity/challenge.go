@@ -0,0 +1,124 @@
+package identity
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/max/api-gateway/internal/cache"
+)
+
+// ErrChallengeNotFound is returned for unknown, expired or exhausted
+// second-factor challenges
+var ErrChallengeNotFound = errors.New("second factor challenge not found")
+
+// Challenge is a login waiting for its second factor. The password was
+// checked; the token is only issued once a TOTP or backup code is given.
+type Challenge struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	// Enroll is set when TOTP is required but the user has not enrolled
+	Enroll    bool      `json:"enroll"`
+	Session   bool      `json:"session"`
+	Attempts  int       `json:"attempts"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ChallengeStore keeps pending challenges in a cache, typically Redis, so
+// that the second step can reach any gateway instance
+type ChallengeStore struct {
+	store       cache.Cache
+	ttl         time.Duration
+	maxAttempts int
+}
+
+// NewChallengeStore creates a challenge store. A challenge expires after ttl
+// or after maxAttempts wrong codes.
+func NewChallengeStore(store cache.Cache, ttl time.Duration, maxAttempts int) *ChallengeStore {
+	return &ChallengeStore{store: store, ttl: ttl, maxAttempts: maxAttempts}
+}
+
+// Create starts a challenge for a user whose password was accepted
+func (s *ChallengeStore) Create(ctx context.Context, userID string, enroll, session bool) (*Challenge, error) {
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate challenge id: %w", err)
+	}
+
+	challenge := &Challenge{
+		ID:        base64.RawURLEncoding.EncodeToString(id),
+		UserID:    userID,
+		Enroll:    enroll,
+		Session:   session,
+		ExpiresAt: time.Now().Add(s.ttl),
+	}
+	if err := s.save(ctx, challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// Get returns a pending challenge
+func (s *ChallengeStore) Get(ctx context.Context, id string) (*Challenge, error) {
+	data, err := s.store.Get(ctx, challengeKey(id))
+	if err != nil {
+		if errors.Is(err, cache.ErrCacheMiss) {
+			return nil, ErrChallengeNotFound
+		}
+		return nil, fmt.Errorf("failed to load challenge: %w", err)
+	}
+
+	var challenge Challenge
+	if err := json.Unmarshal(data, &challenge); err != nil {
+		return nil, fmt.Errorf("failed to decode challenge: %w", err)
+	}
+	if !time.Now().Before(challenge.ExpiresAt) {
+		return nil, ErrChallengeNotFound
+	}
+	return &challenge, nil
+}
+
+// Fail counts a wrong code, dropping the challenge after the last attempt
+func (s *ChallengeStore) Fail(ctx context.Context, challenge *Challenge) error {
+	challenge.Attempts++
+	if challenge.Attempts >= s.maxAttempts {
+		return s.Delete(ctx, challenge.ID)
+	}
+	return s.save(ctx, challenge)
+}
+
+// Delete removes a challenge once it is completed
+func (s *ChallengeStore) Delete(ctx context.Context, id string) error {
+	return s.store.Delete(ctx, challengeKey(id))
+}
+
+// TTL returns how long challenges live
+func (s *ChallengeStore) TTL() time.Duration {
+	return s.ttl
+}
+
+// save stores a challenge until it expires
+func (s *ChallengeStore) save(ctx context.Context, challenge *Challenge) error {
+	ttl := time.Until(challenge.ExpiresAt)
+	if ttl <= 0 {
+		return ErrChallengeNotFound
+	}
+
+	data, err := json.Marshal(challenge)
+	if err != nil {
+		return fmt.Errorf("failed to encode challenge: %w", err)
+	}
+	if err := s.store.Set(ctx, challengeKey(challenge.ID), data, ttl); err != nil {
+		return fmt.Errorf("failed to store challenge: %w", err)
+	}
+	return nil
+}
+
+// challengeKey builds the cache key for a challenge
+func challengeKey(id string) string {
+	return "mfa_challenge:" + id
+}
@@ -0,0 +1,164 @@
+package identity
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Second factor errors
+var (
+	ErrInvalidCode      = errors.New("invalid authentication code")
+	ErrTOTPEnabled      = errors.New("TOTP is already enabled")
+	ErrTOTPNotEnrolling = errors.New("TOTP enrollment was not started")
+)
+
+// MFARequired reports whether logins of the user need a second factor:
+// the user enrolled, was marked totp_required or has a required role
+func (s *Store) MFARequired(user *User) bool {
+	if user.TOTPEnabled || user.TOTPRequired {
+		return true
+	}
+	for _, required := range s.cfg.TOTP.RequiredRoles {
+		for _, role := range user.Roles {
+			if role == required {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// EnrollTOTP stores a new secret for the user and returns it with its
+// provisioning URI. The secret takes effect once ActivateTOTP confirms a
+// code generated from it.
+func (s *Store) EnrollTOTP(ctx context.Context, user *User) (secret, uri string, err error) {
+	secret, err = GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE gateway_users SET totp_secret = $1, updated_at = now() WHERE id = $2 AND NOT totp_enabled`,
+		secret, user.ID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to store TOTP secret: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return "", "", ErrTOTPEnabled
+	}
+	return secret, TOTPURI(s.cfg.TOTP.Issuer, user.Username, secret), nil
+}
+
+// ActivateTOTP enables TOTP once a code from the enrolled secret is given
+// and returns new backup codes. The codes are only stored hashed.
+func (s *Store) ActivateTOTP(ctx context.Context, userID, code string) ([]string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var secret sql.NullString
+	var enabled bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT totp_secret, totp_enabled FROM gateway_users WHERE id::text = $1 FOR UPDATE`, userID).Scan(&secret, &enabled)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, ErrNotFound
+	case err != nil:
+		return nil, fmt.Errorf("failed to load TOTP secret: %w", err)
+	case enabled:
+		return nil, ErrTOTPEnabled
+	case !secret.Valid:
+		return nil, ErrTOTPNotEnrolling
+	}
+
+	step, ok := VerifyTOTP(secret.String, strings.TrimSpace(code), time.Now(), 0)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE gateway_users SET totp_enabled = TRUE, totp_last_step = $1, updated_at = now() WHERE id::text = $2`,
+		step, userID); err != nil {
+		return nil, fmt.Errorf("failed to enable TOTP: %w", err)
+	}
+
+	codes, err := generateBackupCodes(s.cfg.TOTP.BackupCodes)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM gateway_user_backup_codes WHERE user_id::text = $1`, userID); err != nil {
+		return nil, fmt.Errorf("failed to replace backup codes: %w", err)
+	}
+	for _, code := range codes {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO gateway_user_backup_codes (user_id, code_hash) VALUES ($1, $2)`,
+			userID, hashBackupCode(code)); err != nil {
+			return nil, fmt.Errorf("failed to store backup codes: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to enable TOTP: %w", err)
+	}
+	return codes, nil
+}
+
+// VerifySecondFactor checks a TOTP code or an unused backup code. Each is
+// accepted once.
+func (s *Store) VerifySecondFactor(ctx context.Context, user *User, code string) error {
+	code = strings.TrimSpace(code)
+	if !user.TOTPEnabled || code == "" {
+		return ErrInvalidCode
+	}
+
+	if step, ok := VerifyTOTP(user.totpSecret.String, code, time.Now(), user.totpLastStep); ok {
+		// The condition makes concurrent uses of the same code fail
+		return s.consume(ctx, `UPDATE gateway_users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1`,
+			step, user.ID)
+	}
+	return s.consume(ctx, `UPDATE gateway_user_backup_codes SET used_at = now()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		user.ID, hashBackupCode(code))
+}
+
+// consume runs an update marking a code used, returning ErrInvalidCode when
+// it was already used
+func (s *Store) consume(ctx context.Context, query string, args ...interface{}) error {
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to record authentication code: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// DisableTOTP removes the user's authenticator and backup codes, for users
+// who lost their device
+func (s *Store) DisableTOTP(ctx context.Context, userID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE gateway_users
+		SET totp_secret = NULL, totp_enabled = FALSE, totp_last_step = 0, updated_at = now()
+		WHERE id::text = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to disable TOTP: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM gateway_user_backup_codes WHERE user_id::text = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete backup codes: %w", err)
+	}
+	return tx.Commit()
+}
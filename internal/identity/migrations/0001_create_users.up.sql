@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS gateway_users (
+    id                UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    username          TEXT NOT NULL,
+    email             TEXT NOT NULL,
+    password_hash     TEXT NOT NULL,
+    roles             TEXT[] NOT NULL DEFAULT '{}',
+    email_verified_at TIMESTAMPTZ,
+    disabled          BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at        TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS gateway_users_username_key ON gateway_users (lower(username));
+CREATE UNIQUE INDEX IF NOT EXISTS gateway_users_email_key ON gateway_users (lower(email));
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS gateway_user_backup_codes;
+
+ALTER TABLE gateway_users
+    DROP COLUMN IF EXISTS totp_secret,
+    DROP COLUMN IF EXISTS totp_enabled,
+    DROP COLUMN IF EXISTS totp_required,
+    DROP COLUMN IF EXISTS totp_last_step;
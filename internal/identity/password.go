@@ -0,0 +1,70 @@
+package identity
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// Sizes of argon2id salts and keys
+const (
+	saltLength = 16
+	keyLength  = 32
+)
+
+// errMalformedHash is returned for stored hashes that are not argon2id
+var errMalformedHash = errors.New("malformed password hash")
+
+// HashPassword hashes a password with argon2id, in the PHC string format
+// $argon2id$v=19$m=<KiB>,t=<iterations>,p=<parallelism>$<salt>$<key>
+func HashPassword(password string, params config.Argon2Config) (string, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.MemoryKiB, params.Parallelism, keyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.MemoryKiB, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// VerifyPassword checks a password against an argon2id hash. stale reports
+// a matching hash made with other parameters than params, which should be
+// replaced.
+func VerifyPassword(password, encoded string, params config.Argon2Config) (match, stale bool, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return false, false, errMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, errMalformedHash
+	}
+	var stored config.Argon2Config
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &stored.MemoryKiB, &stored.Iterations, &stored.Parallelism); err != nil {
+		return false, false, errMalformedHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, errMalformedHash
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(want) == 0 {
+		return false, false, errMalformedHash
+	}
+
+	key := argon2.IDKey([]byte(password), salt, stored.Iterations, stored.MemoryKiB, stored.Parallelism, uint32(len(want)))
+	if subtle.ConstantTimeCompare(key, want) != 1 {
+		return false, false, nil
+	}
+	return true, stored != params || len(salt) != saltLength || len(want) != keyLength, nil
+}
@@ -0,0 +1,42 @@
+package identity
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestPasswordHashing(t *testing.T) {
+	params := config.Argon2Config{MemoryKiB: 64, Iterations: 1, Parallelism: 1}
+
+	hash, err := HashPassword("correct horse", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("unexpected hash format: %s", hash)
+	}
+	if other, _ := HashPassword("correct horse", params); other == hash {
+		t.Fatal("hashes of the same password share a salt")
+	}
+
+	if match, stale, err := VerifyPassword("correct horse", hash, params); err != nil || !match || stale {
+		t.Fatalf("VerifyPassword(correct) = %v, %v, %v", match, stale, err)
+	}
+	if match, _, err := VerifyPassword("wrong horse", hash, params); err != nil || match {
+		t.Fatalf("VerifyPassword(wrong) = %v, %v", match, err)
+	}
+
+	// A hash made with weaker parameters still verifies but is stale
+	stronger := config.Argon2Config{MemoryKiB: 128, Iterations: 2, Parallelism: 1}
+	if match, stale, err := VerifyPassword("correct horse", hash, stronger); err != nil || !match || !stale {
+		t.Fatalf("VerifyPassword(stronger params) = %v, %v, %v", match, stale, err)
+	}
+
+	for _, malformed := range []string{"", "$2a$10$abc", "$argon2i$v=19$m=64,t=1,p=1$c2FsdA$a2V5", "$argon2id$v=19$m=64$c2FsdA$a2V5"} {
+		if _, _, err := VerifyPassword("x", malformed, params); err == nil {
+			t.Errorf("VerifyPassword accepted %q", malformed)
+		}
+	}
+}
@@ -0,0 +1,381 @@
+package identity
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/auth"
+	"github.com/max/api-gateway/internal/config"
+)
+
+// Store errors
+var (
+	ErrNotFound = errors.New("user not found")
+	ErrExists   = errors.New("username or email already in use")
+	// ErrInvalid wraps rejected user fields
+	ErrInvalid = errors.New("invalid user")
+	// ErrDisabled is returned when a disabled user logs in
+	ErrDisabled = errors.New("user is disabled")
+	// ErrEmailUnverified is returned when an unverified user logs in and
+	// require_verified_email is set
+	ErrEmailUnverified = errors.New("email address is not verified")
+)
+
+// uniqueViolation is the Postgres error code of a duplicate key
+const uniqueViolation = "23505"
+
+// User is an account of the user store
+type User struct {
+	ID              string     `json:"id"`
+	Username        string     `json:"username"`
+	Email           string     `json:"email"`
+	Roles           []string   `json:"roles"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	Disabled        bool       `json:"disabled"`
+	// TOTPEnabled is set once the user enrolled an authenticator
+	TOTPEnabled bool `json:"totp_enabled"`
+	// TOTPRequired makes the user enroll on the next login
+	TOTPRequired bool      `json:"totp_required"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	passwordHash string
+	totpSecret   sql.NullString
+	totpLastStep int64
+}
+
+// EmailVerified reports whether the user's email address was verified
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// NewUser holds the fields of a user to create
+type NewUser struct {
+	Username      string   `json:"username"`
+	Email         string   `json:"email"`
+	Password      string   `json:"password"`
+	Roles         []string `json:"roles"`
+	EmailVerified bool     `json:"email_verified"`
+	Disabled      bool     `json:"disabled"`
+	TOTPRequired  bool     `json:"totp_required"`
+}
+
+// UserUpdate holds the fields to change; nil fields are kept. Changing the
+// email address clears its verification unless EmailVerified is set.
+type UserUpdate struct {
+	Email         *string   `json:"email"`
+	Password      *string   `json:"password"`
+	Roles         *[]string `json:"roles"`
+	EmailVerified *bool     `json:"email_verified"`
+	Disabled      *bool     `json:"disabled"`
+	TOTPRequired  *bool     `json:"totp_required"`
+}
+
+// userColumns are the columns scanned by scanUser
+const userColumns = `id, username, email, password_hash, roles, email_verified_at, disabled,
+	totp_secret, totp_enabled, totp_required, totp_last_step, created_at, updated_at`
+
+// Store keeps users in Postgres, with argon2id password hashes
+type Store struct {
+	db     *sql.DB
+	cfg    config.UsersConfig
+	logger *zap.Logger
+
+	// dummyHash is verified for unknown usernames so that they take as long
+	// as wrong passwords
+	dummyHash string
+}
+
+// NewStore connects to the database and applies the schema migrations
+func NewStore(ctx context.Context, dbCfg config.DatabaseConfig, cfg config.UsersConfig, logger *zap.Logger) (*Store, error) {
+	db, err := sql.Open("postgres", dbCfg.DSN())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := Migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate user store: %w", err)
+	}
+
+	dummyHash, err := HashPassword("", cfg.Argon2)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db, cfg: cfg, logger: logger, dummyHash: dummyHash}, nil
+}
+
+// Close closes the database connection
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Bootstrap creates the configured admin user when the store is empty
+func (s *Store) Bootstrap(ctx context.Context) error {
+	admin := s.cfg.BootstrapAdmin
+	if admin.Username == "" {
+		return nil
+	}
+
+	var count int
+	if err := s.db.QueryRowContext(ctx, `SELECT count(*) FROM gateway_users`).Scan(&count); err != nil {
+		return fmt.Errorf("failed to count users: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	email := admin.Email
+	if email == "" {
+		email = admin.Username + "@localhost"
+	}
+	_, err := s.Create(ctx, NewUser{
+		Username:      admin.Username,
+		Email:         email,
+		Password:      admin.Password,
+		Roles:         []string{"admin", "user"},
+		EmailVerified: true,
+	})
+	if errors.Is(err, ErrExists) {
+		// Another gateway created it first
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.logger.Info("Created bootstrap admin user", zap.String("username", admin.Username))
+	return nil
+}
+
+// Create adds a user
+func (s *Store) Create(ctx context.Context, user NewUser) (*User, error) {
+	user.Username = strings.TrimSpace(user.Username)
+	user.Email = strings.TrimSpace(user.Email)
+	if user.Username == "" {
+		return nil, fmt.Errorf("%w: username is required", ErrInvalid)
+	}
+	if err := validateEmail(user.Email); err != nil {
+		return nil, err
+	}
+	hash, err := s.hash(user.Password)
+	if err != nil {
+		return nil, err
+	}
+	if user.Roles == nil {
+		user.Roles = []string{}
+	}
+
+	var verifiedAt *time.Time
+	if user.EmailVerified {
+		now := time.Now().UTC()
+		verifiedAt = &now
+	}
+
+	row := s.db.QueryRowContext(ctx, `INSERT INTO gateway_users
+		(username, email, password_hash, roles, email_verified_at, disabled, totp_required)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+userColumns,
+		user.Username, user.Email, hash, pq.Array(user.Roles), verifiedAt, user.Disabled, user.TOTPRequired)
+	created, err := scanUser(row)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	return created, nil
+}
+
+// Get returns a user by ID
+func (s *Store) Get(ctx context.Context, id string) (*User, error) {
+	user, err := scanUser(s.db.QueryRowContext(ctx,
+		`SELECT `+userColumns+` FROM gateway_users WHERE id::text = $1`, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return user, nil
+}
+
+// List returns users ordered by username
+func (s *Store) List(ctx context.Context, limit, offset int) ([]*User, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+userColumns+` FROM gateway_users ORDER BY lower(username) LIMIT $1 OFFSET $2`, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list users: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	return users, nil
+}
+
+// Update changes the given fields of a user
+func (s *Store) Update(ctx context.Context, id string, update UserUpdate) (*User, error) {
+	var sets []string
+	var args []interface{}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+
+	if update.Email != nil {
+		email := strings.TrimSpace(*update.Email)
+		if err := validateEmail(email); err != nil {
+			return nil, err
+		}
+		set("email", email)
+		if update.EmailVerified == nil {
+			// A new address is unverified unless it was the old one
+			args = append(args, email)
+			sets = append(sets, fmt.Sprintf("email_verified_at = CASE WHEN lower(email) = lower($%d) THEN email_verified_at END", len(args)))
+		}
+	}
+	if update.Password != nil {
+		hash, err := s.hash(*update.Password)
+		if err != nil {
+			return nil, err
+		}
+		set("password_hash", hash)
+	}
+	if update.Roles != nil {
+		roles := *update.Roles
+		if roles == nil {
+			roles = []string{}
+		}
+		set("roles", pq.Array(roles))
+	}
+	if update.EmailVerified != nil {
+		if *update.EmailVerified {
+			sets = append(sets, "email_verified_at = COALESCE(email_verified_at, now())")
+		} else {
+			sets = append(sets, "email_verified_at = NULL")
+		}
+	}
+	if update.Disabled != nil {
+		set("disabled", *update.Disabled)
+	}
+	if update.TOTPRequired != nil {
+		set("totp_required", *update.TOTPRequired)
+	}
+	if len(sets) == 0 {
+		return s.Get(ctx, id)
+	}
+
+	args = append(args, id)
+	user, err := scanUser(s.db.QueryRowContext(ctx, fmt.Sprintf(
+		`UPDATE gateway_users SET %s, updated_at = now() WHERE id::text = $%d RETURNING %s`,
+		strings.Join(sets, ", "), len(args), userColumns), args...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to update user: %w", err)
+	}
+	return user, nil
+}
+
+// Delete removes a user
+func (s *Store) Delete(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM gateway_users WHERE id::text = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Authenticate checks a username and password. Unknown users and wrong
+// passwords both return auth.ErrInvalidCredentials. Hashes made with
+// outdated parameters are replaced after a successful check.
+func (s *Store) Authenticate(ctx context.Context, username, password string) (*User, error) {
+	user, err := scanUser(s.db.QueryRowContext(ctx,
+		`SELECT `+userColumns+` FROM gateway_users WHERE lower(username) = lower($1)`, strings.TrimSpace(username)))
+	if errors.Is(err, ErrNotFound) {
+		VerifyPassword(password, s.dummyHash, s.cfg.Argon2)
+		return nil, auth.ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+
+	match, stale, err := VerifyPassword(password, user.passwordHash, s.cfg.Argon2)
+	if err != nil {
+		return nil, fmt.Errorf("user %s: %w", user.ID, err)
+	}
+	if !match {
+		return nil, auth.ErrInvalidCredentials
+	}
+	if user.Disabled {
+		return nil, ErrDisabled
+	}
+	if s.cfg.RequireVerifiedEmail && !user.EmailVerified() {
+		return nil, ErrEmailUnverified
+	}
+
+	if stale {
+		if hash, err := HashPassword(password, s.cfg.Argon2); err == nil {
+			if _, err := s.db.ExecContext(ctx,
+				`UPDATE gateway_users SET password_hash = $1 WHERE id = $2 AND password_hash = $3`,
+				hash, user.ID, user.passwordHash); err != nil {
+				s.logger.Warn("Failed to upgrade password hash", zap.String("user_id", user.ID), zap.Error(err))
+			}
+		}
+	}
+	return user, nil
+}
+
+// hash checks a new password against the policy and hashes it
+func (s *Store) hash(password string) (string, error) {
+	if len(password) < s.cfg.MinPasswordLength {
+		return "", fmt.Errorf("%w: password must be at least %d characters", ErrInvalid, s.cfg.MinPasswordLength)
+	}
+	return HashPassword(password, s.cfg.Argon2)
+}
+
+// validateEmail checks that an email address is a bare address
+func validateEmail(email string) error {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return fmt.Errorf("%w: invalid email address %q", ErrInvalid, email)
+	}
+	return nil
+}
+
+// rowScanner is a *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser reads a user selected with userColumns, translating missing
+// rows and duplicate keys into ErrNotFound and ErrExists
+func scanUser(row rowScanner) (*User, error) {
+	var user User
+	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.passwordHash, pq.Array(&user.Roles),
+		&user.EmailVerifiedAt, &user.Disabled, &user.totpSecret, &user.TOTPEnabled, &user.TOTPRequired, &user.totpLastStep,
+		&user.CreatedAt, &user.UpdatedAt)
+
+	var pqErr *pq.Error
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, ErrNotFound
+	case errors.As(err, &pqErr) && pqErr.Code == uniqueViolation:
+		return nil, ErrExists
+	case err != nil:
+		return nil, err
+	}
+	return &user, nil
+}
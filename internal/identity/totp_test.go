@@ -0,0 +1,77 @@
+package identity
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestVerifyTOTP(t *testing.T) {
+	// RFC 6238 test secret, truncated to six digits
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		at   int64
+		code string
+	}{
+		{at: 59, code: "287082"},
+		{at: 1111111109, code: "081804"},
+		{at: 1234567890, code: "005924"},
+	}
+	for _, tt := range tests {
+		step, ok := VerifyTOTP(secret, tt.code, time.Unix(tt.at, 0), 0)
+		if !ok || step != tt.at/totpPeriod {
+			t.Errorf("VerifyTOTP(%s at %d) = %d, %v", tt.code, tt.at, step, ok)
+		}
+	}
+
+	// One period of drift is tolerated, two are not
+	at := time.Unix(1111111109, 0)
+	if _, ok := VerifyTOTP(secret, "081804", at.Add(totpPeriod*time.Second), 0); !ok {
+		t.Error("code of the previous period rejected")
+	}
+	if _, ok := VerifyTOTP(secret, "081804", at.Add(2*totpPeriod*time.Second), 0); ok {
+		t.Error("code of two periods ago accepted")
+	}
+
+	// A used step is not accepted again
+	if _, ok := VerifyTOTP(secret, "081804", at, 1111111109/totpPeriod); ok {
+		t.Error("replayed code accepted")
+	}
+	if _, ok := VerifyTOTP(secret, "000000", at, 0); ok {
+		t.Error("wrong code accepted")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(TOTPURI("API Gateway", "alice@example.com", "JBSWY3DPEHPK3PXP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/API Gateway:alice@example.com" {
+		t.Fatalf("unexpected URI: %s", uri)
+	}
+	if query := uri.Query(); query.Get("secret") != "JBSWY3DPEHPK3PXP" || query.Get("issuer") != "API Gateway" {
+		t.Fatalf("unexpected query: %s", uri.RawQuery)
+	}
+}
+
+func TestBackupCodes(t *testing.T) {
+	codes, err := generateBackupCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != backupCodeLength || seen[code] {
+			t.Fatalf("bad or duplicate backup code %q", code)
+		}
+		seen[code] = true
+	}
+
+	code := codes[0]
+	formatted := "  " + code[:5] + "-" + code[5:] + " "
+	if hashBackupCode(formatted) != hashBackupCode(code) {
+		t.Error("formatting changes the backup code hash")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package loadshed
+
+import "time"
+
+// processCPUTime is not measured on Windows, so the CPU signal stays at 0
+func processCPUTime() (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,192 @@
+package loadshed
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/pkg/metrics"
+)
+
+// SignalMemory is the memory signal of the degradation mode
+const SignalMemory = "memory"
+
+// Degradation describes the degradation mode engaging or disengaging
+type Degradation struct {
+	Timestamp time.Time
+	Engaged   bool
+	// Signal is the signal furthest over its threshold, with its value as
+	// a fraction of the threshold
+	Signal string
+	Ratio  float64
+	CPU    float64 // Process CPU as a fraction of GOMAXPROCS
+	Memory uint64  // Bytes held from the OS
+	// Features are the features switched off while engaged
+	Features []string
+	// AdmissionScale multiplies the admission limits; 1 when disengaged
+	AdmissionScale float64
+}
+
+// DegradationListener is notified when the degradation mode engages or
+// disengages
+type DegradationListener func(change Degradation)
+
+// Degrader watches the gateway's own CPU and memory use and, past a
+// threshold, switches off expensive optional features until use drops
+// below the recovery ratio of every threshold
+type Degrader struct {
+	cfg      config.DegradationConfig
+	features map[string]bool
+	metrics  *metrics.Manager
+	logger   *zap.Logger
+
+	engaged atomic.Bool
+
+	// Sampling state, only touched by check
+	engagedAt time.Time
+	sampledAt time.Time
+	cpuTime   time.Duration
+
+	listenerMu sync.RWMutex
+	listeners  []DegradationListener
+
+	now        func() time.Time
+	processCPU func() (time.Duration, bool)
+	memory     func() uint64
+}
+
+// NewDegrader creates a degradation monitor. metrics may be nil.
+func NewDegrader(cfg config.DegradationConfig, metricsManager *metrics.Manager, logger *zap.Logger) *Degrader {
+	d := &Degrader{
+		cfg:        cfg,
+		features:   make(map[string]bool, len(cfg.Features)),
+		metrics:    metricsManager,
+		logger:     logger,
+		now:        time.Now,
+		processCPU: processCPUTime,
+		memory:     runtimeMemory,
+	}
+	for _, feature := range cfg.Features {
+		d.features[feature] = true
+	}
+	return d
+}
+
+// OnChange registers a listener for the degradation mode engaging or
+// disengaging
+func (d *Degrader) OnChange(listener DegradationListener) {
+	d.listenerMu.Lock()
+	defer d.listenerMu.Unlock()
+	d.listeners = append(d.listeners, listener)
+}
+
+// Engaged reports whether the degradation mode is engaged
+func (d *Degrader) Engaged() bool {
+	return d.engaged.Load()
+}
+
+// Disabled reports whether a feature is switched off by the degradation
+// mode
+func (d *Degrader) Disabled(feature string) bool {
+	return d.engaged.Load() && d.features[feature]
+}
+
+// Start checks CPU and memory every check interval until ctx is done
+func (d *Degrader) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(d.cfg.CheckInterval)
+		defer ticker.Stop()
+
+		d.check()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				d.check()
+			}
+		}
+	}()
+}
+
+// check measures CPU and memory and engages or disengages the mode. The
+// first CPU sample only sets the baseline.
+func (d *Degrader) check() {
+	now := d.now()
+	var cpu float64
+	if d.cfg.CPUThreshold > 0 {
+		if cpuTime, ok := d.processCPU(); ok {
+			if !d.sampledAt.IsZero() && now.After(d.sampledAt) {
+				cpu = float64(cpuTime-d.cpuTime) / float64(now.Sub(d.sampledAt)) / float64(runtime.GOMAXPROCS(0))
+			}
+			d.cpuTime, d.sampledAt = cpuTime, now
+		}
+	}
+	var memory uint64
+	if d.cfg.MemoryThreshold > 0 {
+		memory = d.memory()
+	}
+
+	signal, worst := SignalCPU, ratio(cpu, d.cfg.CPUThreshold)
+	if memoryRatio := ratio(float64(memory), float64(d.cfg.MemoryThreshold)); memoryRatio > worst {
+		signal, worst = SignalMemory, memoryRatio
+	}
+
+	engaged := d.engaged.Load()
+	switch {
+	case !engaged && worst >= 1:
+		d.engagedAt = now
+	case engaged && worst < d.cfg.RecoveryRatio && now.Sub(d.engagedAt) >= d.cfg.MinDuration:
+		// Recovered
+	default:
+		return
+	}
+	engaged = !engaged
+	d.engaged.Store(engaged)
+
+	change := Degradation{
+		Timestamp:      now.UTC(),
+		Engaged:        engaged,
+		Signal:         signal,
+		Ratio:          worst,
+		CPU:            cpu,
+		Memory:         memory,
+		AdmissionScale: 1,
+	}
+	if engaged {
+		change.Features = d.cfg.Features
+		change.AdmissionScale = d.cfg.AdmissionScale
+		d.logger.Warn("Gateway overloaded, degrading",
+			zap.String("signal", signal),
+			zap.Float64("ratio", worst),
+			zap.Strings("features", d.cfg.Features),
+			zap.Float64("admission_scale", d.cfg.AdmissionScale))
+	} else {
+		d.logger.Info("Gateway load recovered, features restored",
+			zap.Duration("degraded_for", now.Sub(d.engagedAt)))
+	}
+	if d.metrics != nil {
+		d.metrics.SetDegraded(engaged)
+	}
+
+	d.listenerMu.RLock()
+	listeners := make([]DegradationListener, len(d.listeners))
+	copy(listeners, d.listeners)
+	d.listenerMu.RUnlock()
+	for _, listener := range listeners {
+		listener(change)
+	}
+}
+
+// runtimeMemory returns the memory the Go runtime holds from the OS, close
+// to the resident set of the process
+func runtimeMemory() uint64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.Sys - stats.HeapReleased
+}
@@ -0,0 +1,78 @@
+package loadshed
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestDegrader(t *testing.T) {
+	d := NewDegrader(config.DegradationConfig{
+		CPUThreshold:    0.5,
+		MemoryThreshold: 1000,
+		RecoveryRatio:   0.8,
+		MinDuration:     time.Minute,
+		Features:        []string{config.DegradeCapture, config.DegradeAnalytics},
+		AdmissionScale:  0.5,
+	}, nil, zap.NewNop())
+
+	now := time.Unix(1000, 0)
+	var cpuTime time.Duration
+	var memory uint64
+	d.now = func() time.Time { return now }
+	d.processCPU = func() (time.Duration, bool) { return cpuTime, true }
+	d.memory = func() uint64 { return memory }
+
+	var changes []Degradation
+	d.OnChange(func(change Degradation) { changes = append(changes, change) })
+
+	// check advances the clock and uses a share of every CPU in the meantime
+	check := func(elapsed time.Duration, cpu float64) {
+		now = now.Add(elapsed)
+		cpuTime += time.Duration(float64(runtime.GOMAXPROCS(0)) * cpu * float64(elapsed))
+		d.check()
+	}
+
+	check(0, 0)
+	check(5*time.Second, 0.3)
+	if d.Engaged() || len(changes) != 0 {
+		t.Fatalf("engaged below the thresholds")
+	}
+
+	memory = 1500
+	check(5*time.Second, 0.3)
+	if !d.Disabled(config.DegradeCapture) || d.Disabled(config.DegradeServerTiming) {
+		t.Fatalf("Disabled() does not follow the configured features once engaged")
+	}
+	if len(changes) != 1 || changes[0].Signal != SignalMemory || changes[0].AdmissionScale != 0.5 {
+		t.Fatalf("changes = %+v, want one engagement by memory", changes)
+	}
+
+	// Recovery needs every signal under 80% of its threshold and the
+	// minimum duration to pass
+	memory = 500
+	check(20*time.Second, 0.1)
+	if !d.Engaged() {
+		t.Fatal("disengaged before the minimum duration")
+	}
+	check(45*time.Second, 0.45)
+	if !d.Engaged() {
+		t.Fatal("disengaged above the recovery ratio")
+	}
+	check(5*time.Second, 0.1)
+	if d.Engaged() || d.Disabled(config.DegradeCapture) {
+		t.Fatal("still engaged after recovering")
+	}
+	if len(changes) != 2 || changes[1].Engaged || changes[1].AdmissionScale != 1 {
+		t.Fatalf("changes = %+v, want the engagement and a recovery", changes)
+	}
+
+	check(5*time.Second, 0.6)
+	if len(changes) != 3 || changes[2].Signal != SignalCPU {
+		t.Errorf("changes = %+v, want an engagement by CPU", changes)
+	}
+}
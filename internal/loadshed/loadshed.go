@@ -0,0 +1,202 @@
+package loadshed
+
+import (
+	"math"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/pkg/metrics"
+)
+
+// Overload signals
+const (
+	SignalCPU        = "cpu"
+	SignalInFlight   = "in_flight"
+	SignalGoroutines = "goroutines"
+)
+
+// rank orders the priority classes, lowest first
+var rank = map[string]int{
+	config.PriorityBatch:    0,
+	config.PriorityNormal:   1,
+	config.PriorityCritical: 2,
+}
+
+// route is a compiled priority route
+type route struct {
+	pathPrefix string
+	methods    map[string]bool
+	class      string
+}
+
+// Shedder classifies requests by priority and sheds the lower classes
+// while the gateway is overloaded. CPU and goroutines are measured at most
+// once per sample interval, on the request path; in-flight requests are
+// counted as they come and go.
+type Shedder struct {
+	cfg     config.LoadSheddingConfig
+	routes  []route
+	metrics *metrics.Manager
+
+	inFlight atomic.Int64
+
+	// The last sample, read without locking
+	sampledAt  atomic.Int64 // Unix nanoseconds
+	cpu        atomic.Uint64
+	goroutines atomic.Int64
+
+	// sampleMu is held while sampling; cpuTime is the process CPU time at
+	// the last sample
+	sampleMu sync.Mutex
+	cpuTime  time.Duration
+
+	now        func() time.Time
+	processCPU func() (time.Duration, bool)
+}
+
+// New creates a load shedder. metrics may be nil.
+func New(cfg config.LoadSheddingConfig, metricsManager *metrics.Manager) *Shedder {
+	s := &Shedder{
+		cfg:        cfg,
+		metrics:    metricsManager,
+		now:        time.Now,
+		processCPU: processCPUTime,
+	}
+	for _, r := range cfg.Routes {
+		compiled := route{pathPrefix: r.PathPrefix, class: r.Class}
+		if len(r.Methods) > 0 {
+			compiled.methods = make(map[string]bool, len(r.Methods))
+			for _, method := range r.Methods {
+				compiled.methods[strings.ToUpper(method)] = true
+			}
+		}
+		s.routes = append(s.routes, compiled)
+	}
+	return s
+}
+
+// Classify returns the priority class of a request: the class of the first
+// matching route, or the default class, lowered by the priority header
+func (s *Shedder) Classify(r *http.Request) string {
+	class := s.cfg.DefaultClass
+	for _, route := range s.routes {
+		if !strings.HasPrefix(r.URL.Path, route.pathPrefix) {
+			continue
+		}
+		if route.methods != nil && !route.methods[r.Method] {
+			continue
+		}
+		class = route.class
+		break
+	}
+
+	if s.cfg.Header != "" {
+		requested := strings.ToLower(strings.TrimSpace(r.Header.Get(s.cfg.Header)))
+		if requestedRank, ok := rank[requested]; ok && requestedRank < rank[class] {
+			class = requested
+		}
+	}
+	return class
+}
+
+// Admit decides whether a request of a class is served. An admitted
+// request is counted in flight until release is called. A shed request
+// gets the signal that shed it instead.
+func (s *Shedder) Admit(class string) (release func(), signal string) {
+	inFlight := s.inFlight.Add(1)
+	s.sample(inFlight)
+
+	if rank[class] < rank[config.PriorityCritical] {
+		signal, ratio := s.overload(inFlight)
+		if ratio >= 1 && (class == config.PriorityBatch || ratio >= s.cfg.NormalOverload) {
+			s.inFlight.Add(-1)
+			if s.metrics != nil {
+				s.metrics.RecordLoadShed(class, signal)
+			}
+			return nil, signal
+		}
+	}
+	return func() { s.inFlight.Add(-1) }, ""
+}
+
+// RetryAfter returns how long shed clients are asked to wait
+func (s *Shedder) RetryAfter() time.Duration {
+	return s.cfg.RetryAfter
+}
+
+// overload returns the signal furthest over its threshold, with its value
+// as a fraction of the threshold
+func (s *Shedder) overload(inFlight int64) (string, float64) {
+	var signal string
+	var worst float64
+	for _, candidate := range []struct {
+		name  string
+		ratio float64
+	}{
+		{SignalCPU, ratio(math.Float64frombits(s.cpu.Load()), s.cfg.CPUThreshold)},
+		{SignalInFlight, ratio(float64(inFlight), float64(s.cfg.InFlightThreshold))},
+		{SignalGoroutines, ratio(float64(s.goroutines.Load()), float64(s.cfg.GoroutineThreshold))},
+	} {
+		if candidate.ratio > worst {
+			signal, worst = candidate.name, candidate.ratio
+		}
+	}
+	return signal, worst
+}
+
+// sample measures CPU and goroutines when the sample interval has passed.
+// Requests arriving while another one samples use the previous values.
+func (s *Shedder) sample(inFlight int64) {
+	now := s.now()
+	if now.UnixNano()-s.sampledAt.Load() < int64(s.cfg.SampleInterval) || !s.sampleMu.TryLock() {
+		return
+	}
+	defer s.sampleMu.Unlock()
+
+	previous := s.sampledAt.Load()
+	if now.UnixNano()-previous < int64(s.cfg.SampleInterval) {
+		return
+	}
+	s.sampledAt.Store(now.UnixNano())
+
+	if s.cfg.CPUThreshold > 0 {
+		if cpuTime, ok := s.processCPU(); ok {
+			// The first sample only sets the baseline
+			if previous != 0 {
+				elapsed := time.Duration(now.UnixNano() - previous)
+				usage := float64(cpuTime-s.cpuTime) / float64(elapsed) / float64(runtime.GOMAXPROCS(0))
+				s.cpu.Store(math.Float64bits(usage))
+			}
+			s.cpuTime = cpuTime
+		}
+	}
+	if s.cfg.GoroutineThreshold > 0 {
+		s.goroutines.Store(int64(runtime.NumGoroutine()))
+	}
+
+	if s.metrics != nil {
+		if s.cfg.CPUThreshold > 0 {
+			s.metrics.SetLoadOverload(SignalCPU, ratio(math.Float64frombits(s.cpu.Load()), s.cfg.CPUThreshold))
+		}
+		if s.cfg.InFlightThreshold > 0 {
+			s.metrics.SetLoadOverload(SignalInFlight, ratio(float64(inFlight), float64(s.cfg.InFlightThreshold)))
+		}
+		if s.cfg.GoroutineThreshold > 0 {
+			s.metrics.SetLoadOverload(SignalGoroutines, ratio(float64(s.goroutines.Load()), float64(s.cfg.GoroutineThreshold)))
+		}
+	}
+}
+
+// ratio returns value as a fraction of threshold, or 0 for a disabled
+// signal
+func ratio(value, threshold float64) float64 {
+	if threshold <= 0 {
+		return 0
+	}
+	return value / threshold
+}
@@ -0,0 +1,118 @@
+package loadshed
+
+import (
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestClassify(t *testing.T) {
+	s := New(config.LoadSheddingConfig{
+		DefaultClass: config.PriorityNormal,
+		Header:       "X-Priority",
+		Routes: []config.PriorityRouteConfig{
+			{PathPrefix: "/payments/", Methods: []string{"post"}, Class: config.PriorityCritical},
+			{PathPrefix: "/reports/", Class: config.PriorityBatch},
+		},
+	}, nil)
+
+	tests := []struct {
+		method, path, header, want string
+	}{
+		{"POST", "/payments/charge", "", config.PriorityCritical},
+		{"GET", "/payments/charge", "", config.PriorityNormal},
+		{"GET", "/reports/daily", "", config.PriorityBatch},
+		{"POST", "/payments/charge", "batch", config.PriorityBatch},
+		// The header cannot raise the class
+		{"GET", "/reports/daily", "critical", config.PriorityBatch},
+		{"GET", "/orders", "Critical", config.PriorityNormal},
+		{"GET", "/orders", "unknown", config.PriorityNormal},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.header != "" {
+			r.Header.Set("X-Priority", tt.header)
+		}
+		if got := s.Classify(r); got != tt.want {
+			t.Errorf("Classify(%s %s, %q) = %s, want %s", tt.method, tt.path, tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestAdmitInFlight(t *testing.T) {
+	s := New(config.LoadSheddingConfig{
+		InFlightThreshold: 4,
+		NormalOverload:    1.5,
+		SampleInterval:    time.Second,
+	}, nil)
+
+	var releases []func()
+	admit := func(class string) string {
+		release, signal := s.Admit(class)
+		if release != nil {
+			releases = append(releases, release)
+		}
+		return signal
+	}
+	for i := 0; i < 3; i++ {
+		if signal := admit(config.PriorityNormal); signal != "" {
+			t.Fatalf("request %d shed by %s below the threshold", i, signal)
+		}
+	}
+
+	// Batch requests are shed from the threshold, normal ones from 1.5x
+	if signal := admit(config.PriorityBatch); signal != SignalInFlight {
+		t.Errorf("batch request at the threshold shed by %q, want %s", signal, SignalInFlight)
+	}
+	if signal := admit(config.PriorityNormal); signal != "" {
+		t.Errorf("normal request at the threshold shed by %s", signal)
+	}
+	if signal := admit(config.PriorityNormal); signal != "" {
+		t.Errorf("normal request at 1.25x shed by %s", signal)
+	}
+	if signal := admit(config.PriorityNormal); signal != SignalInFlight {
+		t.Errorf("normal request at 1.5x shed by %q, want %s", signal, SignalInFlight)
+	}
+	if signal := admit(config.PriorityCritical); signal != "" {
+		t.Errorf("critical request shed by %s", signal)
+	}
+
+	for _, release := range releases {
+		release()
+	}
+	if signal := admit(config.PriorityBatch); signal != "" {
+		t.Errorf("batch request shed by %s after the load dropped", signal)
+	}
+}
+
+func TestAdmitCPU(t *testing.T) {
+	s := New(config.LoadSheddingConfig{
+		CPUThreshold:   0.5,
+		NormalOverload: 1.25,
+		SampleInterval: time.Second,
+	}, nil)
+	now := time.Unix(1000, 0)
+	var cpuTime time.Duration
+	s.now = func() time.Time { return now }
+	s.processCPU = func() (time.Duration, bool) { return cpuTime, true }
+
+	// The first sample is the baseline
+	if _, signal := s.Admit(config.PriorityBatch); signal != "" {
+		t.Fatalf("batch request shed by %s before any CPU was measured", signal)
+	}
+
+	// 80% of every CPU over the next second
+	now = now.Add(time.Second)
+	cpuTime += time.Duration(float64(runtime.GOMAXPROCS(0)) * 0.8 * float64(time.Second))
+	if _, signal := s.Admit(config.PriorityNormal); signal != SignalCPU {
+		t.Errorf("normal request at 1.6x CPU shed by %q, want %s", signal, SignalCPU)
+	}
+	if release, signal := s.Admit(config.PriorityCritical); signal != "" {
+		t.Errorf("critical request shed by %s", signal)
+	} else {
+		release()
+	}
+}
@@ -0,0 +1,53 @@
+package loginguard
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// captchaVerifier checks CAPTCHA tokens with a siteverify endpoint
+type captchaVerifier struct {
+	cfg    config.CaptchaConfig
+	client *http.Client
+}
+
+// verify reports whether the provider accepts token
+func (v *captchaVerifier) verify(ctx context.Context, token, clientIP string) (bool, error) {
+	if token == "" {
+		return false, nil
+	}
+
+	form := url.Values{"secret": {v.cfg.Secret}, "response": {token}}
+	if clientIP != "" {
+		form.Set("remoteip", clientIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.cfg.VerifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("captcha verification failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("captcha verification failed: status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result); err != nil {
+		return false, fmt.Errorf("invalid captcha verification response: %w", err)
+	}
+	return result.Success, nil
+}
@@ -0,0 +1,179 @@
+package loginguard
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// Kinds of throttled keys
+const (
+	KindUsername = "username"
+	KindIP       = "ip"
+)
+
+// Decision is the verdict on a login attempt before credentials are checked
+type Decision struct {
+	// RetryAfter is set while the username or client IP is locked out
+	RetryAfter time.Duration
+	// CaptchaRequired is set once failures reach captcha_after
+	CaptchaRequired bool
+}
+
+// Lockout is a username or client IP locked out after repeated failures
+type Lockout struct {
+	Timestamp time.Time
+	Kind      string
+	Username  string
+	ClientIP  string
+	Failures  int64
+	Duration  time.Duration
+}
+
+// LockoutListener is notified of lockouts, for security events. Listeners
+// run on the request path and must not block.
+type LockoutListener func(lockout Lockout)
+
+// Guard throttles password guessing. Failures are counted per username and
+// per client IP; a key reaching its limit is locked out for base_lockout,
+// doubled for each further failure up to max_lockout.
+type Guard struct {
+	cfg     config.LoginProtectionConfig
+	store   Store
+	captcha *captchaVerifier
+	logger  *zap.Logger
+
+	listenerMu sync.RWMutex
+	listeners  []LockoutListener
+}
+
+// NewGuard creates a guard keeping its counters in store
+func NewGuard(cfg config.LoginProtectionConfig, store Store, logger *zap.Logger) *Guard {
+	g := &Guard{cfg: cfg, store: store, logger: logger}
+	if cfg.CaptchaAfter > 0 {
+		g.captcha = &captchaVerifier{cfg: cfg.Captcha, client: &http.Client{Timeout: cfg.Captcha.Timeout}}
+	}
+	return g
+}
+
+// OnLockout registers a listener for lockouts
+func (g *Guard) OnLockout(listener LockoutListener) {
+	g.listenerMu.Lock()
+	defer g.listenerMu.Unlock()
+	g.listeners = append(g.listeners, listener)
+}
+
+// CaptchaHeader returns the request header carrying CAPTCHA tokens
+func (g *Guard) CaptchaHeader() string {
+	return g.cfg.Captcha.Header
+}
+
+// throttledKey is a counter key with its limit
+type throttledKey struct {
+	kind  string
+	key   string
+	limit int
+}
+
+// keys returns the counters of an attempt. Usernames are hashed so that
+// Redis holds no account names.
+func (g *Guard) keys(username, clientIP string) []throttledKey {
+	sum := sha256.Sum256([]byte(strings.ToLower(username)))
+	return []throttledKey{
+		{kind: KindUsername, key: "user:" + hex.EncodeToString(sum[:]), limit: g.cfg.MaxFailures},
+		{kind: KindIP, key: "ip:" + clientIP, limit: g.cfg.MaxIPFailures},
+	}
+}
+
+// Check returns whether an attempt is locked out or needs a CAPTCHA
+func (g *Guard) Check(ctx context.Context, username, clientIP string) (Decision, error) {
+	var decision Decision
+	for _, k := range g.keys(username, clientIP) {
+		remaining, err := g.store.LockedFor(ctx, k.key)
+		if err != nil {
+			return Decision{}, err
+		}
+		decision.RetryAfter = max(decision.RetryAfter, remaining)
+
+		if g.cfg.CaptchaAfter > 0 && !decision.CaptchaRequired {
+			failures, err := g.store.Failures(ctx, k.key)
+			if err != nil {
+				return Decision{}, err
+			}
+			decision.CaptchaRequired = failures >= int64(g.cfg.CaptchaAfter)
+		}
+	}
+	return decision, nil
+}
+
+// VerifyCaptcha reports whether a CAPTCHA token is valid
+func (g *Guard) VerifyCaptcha(ctx context.Context, token, clientIP string) (bool, error) {
+	if g.captcha == nil {
+		return true, nil
+	}
+	return g.captcha.verify(ctx, token, clientIP)
+}
+
+// Failure counts a failed attempt and locks out the username or client IP
+// when it reaches its limit
+func (g *Guard) Failure(ctx context.Context, username, clientIP string) error {
+	for _, k := range g.keys(username, clientIP) {
+		failures, err := g.store.Fail(ctx, k.key, g.cfg.Window)
+		if err != nil {
+			return err
+		}
+		if failures < int64(k.limit) {
+			continue
+		}
+
+		lockout := Lockout{
+			Timestamp: time.Now().UTC(),
+			Kind:      k.kind,
+			Username:  username,
+			ClientIP:  clientIP,
+			Failures:  failures,
+			Duration:  g.lockoutFor(failures - int64(k.limit)),
+		}
+		if err := g.store.Lock(ctx, k.key, lockout.Duration); err != nil {
+			return err
+		}
+		g.logger.Warn("Login locked out after repeated failures",
+			zap.String("kind", k.kind),
+			zap.String("username", username),
+			zap.String("client_ip", clientIP),
+			zap.Int64("failures", failures),
+			zap.Duration("duration", lockout.Duration))
+
+		g.listenerMu.RLock()
+		listeners := make([]LockoutListener, len(g.listeners))
+		copy(listeners, g.listeners)
+		g.listenerMu.RUnlock()
+		for _, listener := range listeners {
+			listener(lockout)
+		}
+	}
+	return nil
+}
+
+// Success forgets the failures of the username. The client IP keeps its
+// count so that one valid account cannot clear it for guessing others.
+func (g *Guard) Success(ctx context.Context, username, clientIP string) error {
+	return g.store.Reset(ctx, g.keys(username, clientIP)[0].key)
+}
+
+// lockoutFor returns the lockout after excess failures beyond the limit
+func (g *Guard) lockoutFor(excess int64) time.Duration {
+	d := g.cfg.BaseLockout
+	for i := int64(0); i < excess && d < g.cfg.MaxLockout; i++ {
+		d *= 2
+	}
+	return min(d, g.cfg.MaxLockout)
+}
@@ -0,0 +1,140 @@
+package loginguard
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func newTestGuard(cfg config.LoginProtectionConfig) (*Guard, *MemoryStore, *time.Time) {
+	store := NewMemoryStore()
+	now := time.Unix(1_700_000_000, 0)
+	store.now = func() time.Time { return now }
+	return NewGuard(cfg, store, zap.NewNop()), store, &now
+}
+
+func TestGuardLockout(t *testing.T) {
+	cfg := config.LoginProtectionConfig{
+		Enabled:       true,
+		MaxFailures:   3,
+		MaxIPFailures: 100,
+		Window:        15 * time.Minute,
+		BaseLockout:   time.Minute,
+		MaxLockout:    5 * time.Minute,
+	}
+	guard, _, now := newTestGuard(cfg)
+	ctx := context.Background()
+
+	var lockouts []Lockout
+	guard.OnLockout(func(l Lockout) { lockouts = append(lockouts, l) })
+
+	for i := 0; i < 2; i++ {
+		if err := guard.Failure(ctx, "Alice", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d, _ := guard.Check(ctx, "alice", "10.0.0.2"); d.RetryAfter != 0 {
+		t.Fatalf("locked out before the limit: %v", d.RetryAfter)
+	}
+
+	// Each failure past the limit doubles the lockout, up to the maximum
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute} {
+		if err := guard.Failure(ctx, "alice", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+		d, err := guard.Check(ctx, "ALICE", "10.0.0.3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.RetryAfter != want {
+			t.Fatalf("RetryAfter = %v, want %v", d.RetryAfter, want)
+		}
+	}
+	if len(lockouts) != 4 || lockouts[0].Kind != KindUsername || lockouts[0].Failures != 3 {
+		t.Fatalf("unexpected lockouts: %+v", lockouts)
+	}
+
+	*now = now.Add(5 * time.Minute)
+	if d, _ := guard.Check(ctx, "alice", "10.0.0.1"); d.RetryAfter != 0 {
+		t.Fatalf("still locked out after expiry: %v", d.RetryAfter)
+	}
+	if d, _ := guard.Check(ctx, "bob", "10.0.0.1"); d.RetryAfter != 0 {
+		t.Fatalf("other user locked out: %v", d.RetryAfter)
+	}
+}
+
+func TestGuardIPLockoutSurvivesSuccess(t *testing.T) {
+	cfg := config.LoginProtectionConfig{
+		Enabled:       true,
+		MaxFailures:   100,
+		MaxIPFailures: 3,
+		Window:        15 * time.Minute,
+		BaseLockout:   time.Minute,
+		MaxLockout:    time.Hour,
+	}
+	guard, _, _ := newTestGuard(cfg)
+	ctx := context.Background()
+
+	for _, user := range []string{"a", "b"} {
+		if err := guard.Failure(ctx, user, "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := guard.Success(ctx, "c", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := guard.Failure(ctx, "d", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := guard.Check(ctx, "e", "10.0.0.1"); d.RetryAfter != time.Minute {
+		t.Fatalf("RetryAfter = %v, want 1m", d.RetryAfter)
+	}
+}
+
+func TestGuardCaptcha(t *testing.T) {
+	verifier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		ok := r.PostForm.Get("secret") == "s3cret" && r.PostForm.Get("response") == "good"
+		json.NewEncoder(w).Encode(map[string]bool{"success": ok})
+	}))
+	defer verifier.Close()
+
+	cfg := config.LoginProtectionConfig{
+		Enabled:       true,
+		MaxFailures:   10,
+		MaxIPFailures: 10,
+		Window:        15 * time.Minute,
+		BaseLockout:   time.Minute,
+		MaxLockout:    time.Hour,
+		CaptchaAfter:  2,
+		Captcha:       config.CaptchaConfig{VerifyURL: verifier.URL, Secret: "s3cret", Timeout: time.Second},
+	}
+	guard, _, _ := newTestGuard(cfg)
+	ctx := context.Background()
+
+	guard.Failure(ctx, "alice", "10.0.0.1")
+	if d, _ := guard.Check(ctx, "alice", "10.0.0.1"); d.CaptchaRequired {
+		t.Fatal("captcha required after one failure")
+	}
+	guard.Failure(ctx, "alice", "10.0.0.1")
+	if d, _ := guard.Check(ctx, "alice", "10.0.0.9"); !d.CaptchaRequired {
+		t.Fatal("captcha not required after two failures")
+	}
+
+	if ok, err := guard.VerifyCaptcha(ctx, "good", "10.0.0.1"); err != nil || !ok {
+		t.Fatalf("VerifyCaptcha(good) = %v, %v", ok, err)
+	}
+	if ok, err := guard.VerifyCaptcha(ctx, "bad", "10.0.0.1"); err != nil || ok {
+		t.Fatalf("VerifyCaptcha(bad) = %v, %v", ok, err)
+	}
+	if ok, _ := guard.VerifyCaptcha(ctx, "", "10.0.0.1"); ok {
+		t.Fatal("empty token accepted")
+	}
+}
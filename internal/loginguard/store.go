@@ -0,0 +1,190 @@
+package loginguard
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store keeps failure counters and lockouts. Keys identify a username or a
+// client IP.
+type Store interface {
+	// Fail counts a failure for key and returns the failures within window
+	Fail(ctx context.Context, key string, window time.Duration) (int64, error)
+	// Failures returns the failures counted within the window
+	Failures(ctx context.Context, key string) (int64, error)
+	// Lock locks key out for d
+	Lock(ctx context.Context, key string, d time.Duration) error
+	// LockedFor returns how long key stays locked out, or 0
+	LockedFor(ctx context.Context, key string) (time.Duration, error)
+	// Reset forgets the failures of key
+	Reset(ctx context.Context, key string) error
+}
+
+// redisPrefix namespaces the keys of login protection
+const redisPrefix = "login_guard:"
+
+// failScript increments a counter and starts its window on the first
+// failure, atomically so that a counter never outlives its window
+var failScript = redis.NewScript(`
+local n = redis.call("INCR", KEYS[1])
+if n == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return n
+`)
+
+// RedisStore keeps counters in Redis so that all instances share them
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a store on a Redis client
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+// Fail increments the counter, starting its window on the first failure
+func (s *RedisStore) Fail(ctx context.Context, key string, window time.Duration) (int64, error) {
+	n, err := failScript.Run(ctx, s.client, []string{redisPrefix + "failures:" + key}, window.Milliseconds()).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count login failure: %w", err)
+	}
+	return n, nil
+}
+
+// Failures returns the current counter
+func (s *RedisStore) Failures(ctx context.Context, key string) (int64, error) {
+	n, err := s.client.Get(ctx, redisPrefix+"failures:"+key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read login failures: %w", err)
+	}
+	return n, nil
+}
+
+// Lock sets a lock key expiring with the lockout
+func (s *RedisStore) Lock(ctx context.Context, key string, d time.Duration) error {
+	if err := s.client.Set(ctx, redisPrefix+"lock:"+key, 1, d).Err(); err != nil {
+		return fmt.Errorf("failed to lock out login: %w", err)
+	}
+	return nil
+}
+
+// LockedFor returns the remaining time to live of the lock key
+func (s *RedisStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.client.PTTL(ctx, redisPrefix+"lock:"+key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read login lockout: %w", err)
+	}
+	// Negative values mean the key does not exist or has no expiry
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// Reset deletes the counter; a running lockout stays in place
+func (s *RedisStore) Reset(ctx context.Context, key string) error {
+	if err := s.client.Del(ctx, redisPrefix+"failures:"+key).Err(); err != nil {
+		return fmt.Errorf("failed to reset login failures: %w", err)
+	}
+	return nil
+}
+
+// memoryCounter is a failure counter with the end of its window
+type memoryCounter struct {
+	failures int64
+	expires  time.Time
+}
+
+// MemoryStore keeps counters in process, for single instances and tests
+type MemoryStore struct {
+	mu       sync.Mutex
+	counters map[string]memoryCounter
+	locks    map[string]time.Time
+	now      func() time.Time
+}
+
+// NewMemoryStore creates an in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		counters: make(map[string]memoryCounter),
+		locks:    make(map[string]time.Time),
+		now:      time.Now,
+	}
+}
+
+// Fail increments the counter, starting its window on the first failure
+func (s *MemoryStore) Fail(ctx context.Context, key string, window time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+	counter, ok := s.counters[key]
+	if !ok {
+		counter.expires = now.Add(window)
+	}
+	counter.failures++
+	s.counters[key] = counter
+	return counter.failures, nil
+}
+
+// Failures returns the current counter
+func (s *MemoryStore) Failures(ctx context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counter, ok := s.counters[key]
+	if !ok || !s.now().Before(counter.expires) {
+		return 0, nil
+	}
+	return counter.failures, nil
+}
+
+// Lock locks key out until now plus d
+func (s *MemoryStore) Lock(ctx context.Context, key string, d time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.locks[key] = s.now().Add(d)
+	return nil
+}
+
+// LockedFor returns the remaining lockout
+func (s *MemoryStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if remaining := s.locks[key].Sub(s.now()); remaining > 0 {
+		return remaining, nil
+	}
+	return 0, nil
+}
+
+// Reset forgets the counter; a running lockout stays in place
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.counters, key)
+	return nil
+}
+
+// sweep drops expired counters and lockouts
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, counter := range s.counters {
+		if !now.Before(counter.expires) {
+			delete(s.counters, key)
+		}
+	}
+	for key, until := range s.locks {
+		if !now.Before(until) {
+			delete(s.locks, key)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package metering
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+)
+
+// KafkaExporter publishes usage records to a Kafka topic, keyed by interval
+// key so consumers and compacted topics can drop re-sent records
+type KafkaExporter struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+// NewKafkaExporter creates an idempotent producer for the usage topic
+func NewKafkaExporter(brokers []string, topic string) (*KafkaExporter, error) {
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V2_1_0_0
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Idempotent = true
+	cfg.Producer.Retry.Max = 5
+	cfg.Producer.Return.Successes = true
+	cfg.Net.MaxOpenRequests = 1
+
+	producer, err := sarama.NewSyncProducer(brokers, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka producer: %w", err)
+	}
+	return &KafkaExporter{producer: producer, topic: topic}, nil
+}
+
+// Export publishes the records in one batch
+func (e *KafkaExporter) Export(ctx context.Context, records []Record) error {
+	messages := make([]*sarama.ProducerMessage, 0, len(records))
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to encode usage record: %w", err)
+		}
+		messages = append(messages, &sarama.ProducerMessage{
+			Topic: e.topic,
+			Key:   sarama.StringEncoder(record.IntervalKey),
+			Value: sarama.ByteEncoder(data),
+		})
+	}
+
+	if err := e.producer.SendMessages(messages); err != nil {
+		return fmt.Errorf("failed to send usage records to Kafka: %w", err)
+	}
+	return nil
+}
+
+// Close closes the producer
+func (e *KafkaExporter) Close() error {
+	return e.producer.Close()
+}
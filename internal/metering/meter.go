@@ -0,0 +1,251 @@
+package metering
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// Sample is a completed request to be metered
+type Sample struct {
+	APIKey        string
+	Tenant        string
+	Service       string
+	Route         string
+	Status        int
+	RequestBytes  int64
+	ResponseBytes int64
+}
+
+// Record is the usage of one API key and tenant on one route and status
+// class during a period. IntervalKey is derived from the node, period and
+// dimensions, so re-exporting a record after a failure yields the same key
+// and consumers can drop the duplicate.
+type Record struct {
+	IntervalKey   string    `json:"interval_key"`
+	Node          string    `json:"node"`
+	PeriodStart   time.Time `json:"period_start"`
+	PeriodEnd     time.Time `json:"period_end"`
+	APIKey        string    `json:"api_key,omitempty"`
+	Tenant        string    `json:"tenant,omitempty"`
+	Service       string    `json:"service"`
+	Route         string    `json:"route"`
+	StatusClass   string    `json:"status_class"`
+	Requests      int64     `json:"requests"`
+	RequestBytes  int64     `json:"request_bytes"`
+	ResponseBytes int64     `json:"response_bytes"`
+}
+
+// Exporter delivers usage records to the billing pipeline
+type Exporter interface {
+	Export(ctx context.Context, records []Record) error
+	Close() error
+}
+
+// dimensions identify an aggregate within a period
+type dimensions struct {
+	apiKey      string
+	tenant      string
+	service     string
+	route       string
+	statusClass string
+}
+
+// totals are the aggregated counters of a dimension set
+type totals struct {
+	requests      int64
+	requestBytes  int64
+	responseBytes int64
+}
+
+// Meter aggregates requests per API key, tenant, route and status class
+// and exports the totals once per interval
+type Meter struct {
+	cfg      config.MeteringConfig
+	node     string
+	exporter Exporter
+	logger   *zap.Logger
+
+	mu          sync.Mutex
+	periodStart time.Time
+	current     map[dimensions]*totals
+	pending     []Record
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewMeter creates a meter exporting through exporter. The node ID defaults
+// to the host name with a random suffix.
+func NewMeter(cfg config.MeteringConfig, exporter Exporter, logger *zap.Logger) *Meter {
+	node := cfg.NodeID
+	if node == "" {
+		node = defaultNodeID()
+	}
+	return &Meter{
+		cfg:         cfg,
+		node:        node,
+		exporter:    exporter,
+		logger:      logger.With(zap.String("node", node)),
+		periodStart: time.Now(),
+		current:     make(map[dimensions]*totals),
+	}
+}
+
+// defaultNodeID returns the host name with a random suffix
+func defaultNodeID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "gateway"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return host + "-" + hex.EncodeToString(suffix)
+}
+
+// Observe counts a request. Anonymous requests, with neither an API key nor
+// a tenant, are not billable and are skipped.
+func (m *Meter) Observe(s Sample) {
+	if s.APIKey == "" && s.Tenant == "" {
+		return
+	}
+
+	dims := dimensions{
+		apiKey:      s.APIKey,
+		tenant:      s.Tenant,
+		service:     s.Service,
+		route:       s.Route,
+		statusClass: statusClass(s.Status),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.current[dims]
+	if !ok {
+		t = &totals{}
+		m.current[dims] = t
+	}
+	t.requests++
+	t.requestBytes += max(s.RequestBytes, 0)
+	t.responseBytes += max(s.ResponseBytes, 0)
+}
+
+// Start exports at the end of every interval, aligned to the wall clock,
+// until Close
+func (m *Meter) Start(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	m.cancel = cancel
+	m.done = make(chan struct{})
+
+	go func() {
+		defer close(m.done)
+		for {
+			now := time.Now()
+			timer := time.NewTimer(now.Truncate(m.cfg.Interval).Add(m.cfg.Interval).Sub(now))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				m.Flush(ctx)
+			}
+		}
+	}()
+}
+
+// Close stops the periodic export and flushes the partial interval
+func (m *Meter) Close(ctx context.Context) error {
+	if m.cancel != nil {
+		m.cancel()
+		<-m.done
+	}
+	if err := m.Flush(ctx); err != nil {
+		return err
+	}
+	return m.exporter.Close()
+}
+
+// Flush closes the current period and exports it along with any records
+// whose export failed before
+func (m *Meter) Flush(ctx context.Context) error {
+	m.mu.Lock()
+	now := time.Now()
+	for dims, t := range m.current {
+		m.pending = append(m.pending, m.record(dims, t, m.periodStart, now))
+	}
+	m.current = make(map[dimensions]*totals)
+	m.periodStart = now
+
+	// Drop the oldest records when the exporter has been down too long
+	if dropped := len(m.pending) - m.cfg.MaxPending; dropped > 0 {
+		m.logger.Error("Dropping unexported usage records", zap.Int("dropped", dropped))
+		m.pending = m.pending[dropped:]
+	}
+	batch := m.pending
+	m.pending = nil
+	m.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	if err := m.exporter.Export(ctx, batch); err != nil {
+		m.mu.Lock()
+		m.pending = append(batch, m.pending...)
+		m.mu.Unlock()
+
+		m.logger.Warn("Failed to export usage records, retrying next interval",
+			zap.Int("records", len(batch)),
+			zap.Error(err))
+		return fmt.Errorf("failed to export usage records: %w", err)
+	}
+
+	m.logger.Debug("Usage records exported", zap.Int("records", len(batch)))
+	return nil
+}
+
+// record builds the usage record of an aggregate
+func (m *Meter) record(dims dimensions, t *totals, start, end time.Time) Record {
+	return Record{
+		IntervalKey:   intervalKey(m.node, start, dims),
+		Node:          m.node,
+		PeriodStart:   start.UTC(),
+		PeriodEnd:     end.UTC(),
+		APIKey:        dims.apiKey,
+		Tenant:        dims.tenant,
+		Service:       dims.service,
+		Route:         dims.route,
+		StatusClass:   dims.statusClass,
+		Requests:      t.requests,
+		RequestBytes:  t.requestBytes,
+		ResponseBytes: t.responseBytes,
+	}
+}
+
+// intervalKey identifies a record for deduplication
+func intervalKey(node string, start time.Time, dims dimensions) string {
+	h := sha256.New()
+	for _, part := range []string{node, start.UTC().Format(time.RFC3339Nano), dims.apiKey, dims.tenant, dims.service, dims.route, dims.statusClass} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// statusClass groups a status code as 2xx, 4xx, ...
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return fmt.Sprintf("%dxx", status/100)
+}
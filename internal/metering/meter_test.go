@@ -0,0 +1,63 @@
+package metering
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+type fakeExporter struct {
+	fail    bool
+	batches [][]Record
+}
+
+func (f *fakeExporter) Export(ctx context.Context, records []Record) error {
+	if f.fail {
+		return errors.New("exporter down")
+	}
+	f.batches = append(f.batches, records)
+	return nil
+}
+
+func (f *fakeExporter) Close() error { return nil }
+
+func TestMeterAggregatesAndRetries(t *testing.T) {
+	exporter := &fakeExporter{fail: true}
+	meter := NewMeter(config.MeteringConfig{NodeID: "node-1", Interval: time.Minute, MaxPending: 100}, exporter, zap.NewNop())
+
+	meter.Observe(Sample{APIKey: "key-1", Service: "orders", Route: "/orders/*", Status: 200, RequestBytes: 10, ResponseBytes: 100})
+	meter.Observe(Sample{APIKey: "key-1", Service: "orders", Route: "/orders/*", Status: 201, RequestBytes: 5, ResponseBytes: 50})
+	meter.Observe(Sample{APIKey: "key-1", Service: "orders", Route: "/orders/*", Status: 503})
+	meter.Observe(Sample{Service: "orders", Route: "/orders/*", Status: 200}) // anonymous
+
+	if err := meter.Flush(context.Background()); err == nil {
+		t.Fatal("Flush() error = nil, want exporter failure")
+	}
+
+	exporter.fail = false
+	if err := meter.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if len(exporter.batches) != 1 || len(exporter.batches[0]) != 2 {
+		t.Fatalf("exported batches = %v, want one batch of 2 records", exporter.batches)
+	}
+
+	byClass := make(map[string]Record)
+	for _, r := range exporter.batches[0] {
+		byClass[r.StatusClass] = r
+	}
+	if r := byClass["2xx"]; r.Requests != 2 || r.RequestBytes != 15 || r.ResponseBytes != 150 {
+		t.Errorf("2xx record = %+v, want 2 requests, 15 and 150 bytes", r)
+	}
+	if r := byClass["5xx"]; r.Requests != 1 {
+		t.Errorf("5xx record = %+v, want 1 request", r)
+	}
+	if byClass["2xx"].IntervalKey == byClass["5xx"].IntervalKey {
+		t.Error("records share an interval key")
+	}
+}
@@ -0,0 +1,86 @@
+package metering
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	// Registers the postgres driver
+	_ "github.com/lib/pq"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// PostgresExporter writes usage records to a table whose primary key is the
+// interval key, so re-sent records are ignored
+type PostgresExporter struct {
+	db    *sql.DB
+	table string
+}
+
+// NewPostgresExporter connects to the database and creates the usage table
+// if needed. The table name must already be validated.
+func NewPostgresExporter(ctx context.Context, dbCfg config.DatabaseConfig, table string) (*PostgresExporter, error) {
+	db, err := sql.Open("postgres", dbCfg.DSN())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	schema := `CREATE TABLE IF NOT EXISTS ` + table + ` (
+		interval_key   TEXT PRIMARY KEY,
+		node           TEXT NOT NULL,
+		period_start   TIMESTAMPTZ NOT NULL,
+		period_end     TIMESTAMPTZ NOT NULL,
+		api_key        TEXT NOT NULL,
+		tenant         TEXT NOT NULL,
+		service        TEXT NOT NULL,
+		route          TEXT NOT NULL,
+		status_class   TEXT NOT NULL,
+		requests       BIGINT NOT NULL,
+		request_bytes  BIGINT NOT NULL,
+		response_bytes BIGINT NOT NULL,
+		exported_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create usage table: %w", err)
+	}
+
+	return &PostgresExporter{db: db, table: table}, nil
+}
+
+// Export inserts the records in one transaction, skipping interval keys
+// already stored
+func (e *PostgresExporter) Export(ctx context.Context, records []Record) error {
+	tx, err := e.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO `+e.table+` (interval_key, node, period_start, period_end,
+		api_key, tenant, service, route, status_class, requests, request_bytes, response_bytes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (interval_key) DO NOTHING`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, r := range records {
+		if _, err := stmt.ExecContext(ctx, r.IntervalKey, r.Node, r.PeriodStart, r.PeriodEnd,
+			r.APIKey, r.Tenant, r.Service, r.Route, r.StatusClass, r.Requests, r.RequestBytes, r.ResponseBytes); err != nil {
+			return fmt.Errorf("failed to insert usage record: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit usage records: %w", err)
+	}
+	return nil
+}
+
+// Close closes the database connection
+func (e *PostgresExporter) Close() error {
+	return e.db.Close()
+}
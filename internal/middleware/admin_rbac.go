@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/auth"
+)
+
+// AdminDenial is an admin operation refused for lack of permission
+type AdminDenial struct {
+	Timestamp  time.Time
+	User       string
+	Roles      []string
+	Permission string
+	// Operation is the HTTP method and path or the gRPC method
+	Operation string
+	ClientIP  string
+}
+
+// AdminDenialListener is notified of refused admin operations, for audit.
+// Listeners run on the request path and must not block.
+type AdminDenialListener func(denial AdminDenial)
+
+// OnAdminDenial registers a listener for refused admin operations
+func (m *Manager) OnAdminDenial(listener AdminDenialListener) {
+	m.adminListenerMu.Lock()
+	defer m.adminListenerMu.Unlock()
+	m.adminListeners = append(m.adminListeners, listener)
+}
+
+// AuthorizeAdmin reports whether a user holds an admin permission. Without
+// admin RBAC the admin role holds all of them. Refusals are logged and
+// passed to the denial listeners.
+func (m *Manager) AuthorizeAdmin(claims *auth.Claims, permission, operation, clientIP string) bool {
+	if claims != nil && m.hasAdminPermission(claims, permission) {
+		return true
+	}
+
+	denial := AdminDenial{
+		Timestamp:  time.Now().UTC(),
+		Permission: permission,
+		Operation:  operation,
+		ClientIP:   clientIP,
+	}
+	if claims != nil {
+		denial.User = claims.Username
+		denial.Roles = claims.Roles
+	}
+	m.logger.Warn("Admin operation denied",
+		zap.String("user", denial.User),
+		zap.Strings("roles", denial.Roles),
+		zap.String("permission", permission),
+		zap.String("operation", operation),
+		zap.String("client_ip", clientIP))
+
+	m.adminListenerMu.RLock()
+	listeners := make([]AdminDenialListener, len(m.adminListeners))
+	copy(listeners, m.adminListeners)
+	m.adminListenerMu.RUnlock()
+	for _, listener := range listeners {
+		listener(denial)
+	}
+
+	m.notifySecurityEvent(SecurityEvent{
+		Timestamp: denial.Timestamp,
+		Kind:      SecurityForbidden,
+		Reason:    "admin_permission",
+		User:      denial.User,
+		ClientIP:  clientIP,
+		Path:      operation,
+		Status:    http.StatusForbidden,
+		Details:   map[string]string{"permission": permission, "roles": strings.Join(denial.Roles, ",")},
+	})
+	return false
+}
+
+// RequirePermission middleware requires an admin permission of the
+// authenticated user
+func (m *Manager) RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		operation := c.Request.Method + " " + c.Request.URL.Path
+		if !m.AuthorizeAdmin(m.RequestClaims(c), permission, operation, c.ClientIP()) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":      "Insufficient permissions",
+				"permission": permission,
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// hasAdminPermission reports whether any role of the user grants the
+// permission. The admin role keeps every permission unless admin RBAC
+// gives it an entry of its own.
+func (m *Manager) hasAdminPermission(claims *auth.Claims, permission string) bool {
+	rbac := m.config.Auth.AdminRBAC
+	if !rbac.Enabled {
+		return m.jwtAuth.HasRole(claims, "admin")
+	}
+
+	for _, role := range claims.Roles {
+		granted, ok := rbac.Roles[role]
+		if !ok && role == "admin" {
+			return true
+		}
+		for _, grant := range granted {
+			if grantsPermission(grant, permission) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// grantsPermission reports whether a granted permission, possibly a
+// wildcard, covers permission
+func grantsPermission(grant, permission string) bool {
+	if grant == "*" || grant == permission {
+		return true
+	}
+	area, ok := strings.CutSuffix(grant, ":*")
+	return ok && strings.HasPrefix(permission, area+":")
+}
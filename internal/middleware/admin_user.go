@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/max/api-gateway/internal/auth"
+)
+
+// adminUserKey carries a user authenticated by the admin server
+type adminUserKey struct{}
+
+// WithAdminUser marks a request as authenticated by the dedicated admin
+// server, by client certificate or its own credentials. JWTAuth accepts
+// the user instead of a token. Only the admin server sets it, so requests
+// on gateway listeners cannot carry it.
+func WithAdminUser(r *http.Request, claims *auth.Claims) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), adminUserKey{}, claims))
+}
+
+// AdminUser returns the user the admin server authenticated, if any
+func AdminUser(r *http.Request) *auth.Claims {
+	claims, _ := r.Context().Value(adminUserKey{}).(*auth.Claims)
+	return claims
+}
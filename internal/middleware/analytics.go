@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/max/api-gateway/internal/analytics"
+	"github.com/max/api-gateway/internal/config"
+)
+
+// Analyzer returns the realtime analytics of recent requests
+func (m *Manager) Analyzer() *analytics.Analyzer {
+	m.analyzerOnce.Do(func() {
+		cfg := m.config.Analytics
+		m.analyzer = analytics.NewAnalyzer(cfg.TopK, cfg.SketchWidth, cfg.SketchDepth)
+	})
+	return m.analyzer
+}
+
+// Analytics middleware counts each request's path, client, user agent and
+// status for the realtime top-N report
+func (m *Manager) Analytics() gin.HandlerFunc {
+	analyzer := m.Analyzer()
+
+	return func(c *gin.Context) {
+		c.Next()
+
+		if m.degraded(config.DegradeAnalytics) {
+			return
+		}
+		analyzer.Observe(analytics.Request{
+			Path:      c.Request.URL.Path,
+			ClientIP:  c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			Status:    c.Writer.Status(),
+		})
+	}
+}
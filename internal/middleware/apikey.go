@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/auth"
+	"github.com/max/api-gateway/internal/cache"
+	"github.com/max/api-gateway/internal/usage"
+	"github.com/max/api-gateway/pkg/servertiming"
+)
+
+// APIKeys returns the API key store, creating it on first use. Keys live in
+// Redis when available and in memory otherwise.
+func (m *Manager) APIKeys() *auth.APIKeyStore {
+	m.apiKeysOnce.Do(func() {
+		var store cache.Cache
+		if m.redisClient != nil {
+			store = cache.NewRedisCache(m.redisClient, sessionCachePrefix, 0, m.logger)
+		} else {
+			m.logger.Warn("Redis unavailable, API keys are kept in memory and lost on restart")
+			store = cache.NewMemoryCache(0, 0, m.logger)
+		}
+		m.apiKeys = auth.NewAPIKeyStore(store)
+	})
+	return m.apiKeys
+}
+
+// Usage returns the per API key usage tracker, creating it on first use.
+// Usage is counted on each replica.
+func (m *Manager) Usage() *usage.Tracker {
+	m.usageOnce.Do(func() {
+		m.usage = usage.NewTracker(m.config.Portal.UsageInterval, m.config.Portal.UsageRetention)
+	})
+	return m.usage
+}
+
+// APIKeyAuth middleware authenticates requests carrying a portal-issued API
+// key as the key's owner and counts their usage. Requests without the
+// header continue unauthenticated.
+func (m *Manager) APIKeyAuth() gin.HandlerFunc {
+	keys := m.APIKeys()
+	tracker := m.Usage()
+
+	return func(c *gin.Context) {
+		secret := c.GetHeader(m.config.Auth.API.Header)
+		if secret == "" {
+			c.Next()
+			return
+		}
+
+		stop := servertiming.FromContext(c.Request.Context()).Start(servertiming.Auth)
+		key, err := keys.Authenticate(c.Request.Context(), secret)
+		stop()
+		if err != nil {
+			if !errors.Is(err, auth.ErrAPIKeyNotFound) {
+				m.logger.Error("API key lookup failed", zap.Error(err))
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Authentication backend unavailable"})
+			} else {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+				m.ReportSecurityEvent(c, SecurityAuthFailure, "invalid_api_key", nil)
+			}
+			c.Abort()
+			return
+		}
+
+		claims := &auth.Claims{
+			UserID:   key.Owner,
+			Username: key.Owner,
+			Metadata: map[string]string{"api_key_id": key.ID},
+		}
+		c.Set("user", claims)
+		c.Set(string(UserContextKey), claims)
+		c.Set(string(APIKeyIDKey), key.ID)
+
+		c.Next()
+
+		tracker.Record(key.ID, c.Writer.Status())
+	}
+}
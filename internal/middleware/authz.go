@@ -0,0 +1,288 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/cache"
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/pkg/servertiming"
+)
+
+// authzInput is the request metadata sent to the external authorizer
+type authzInput struct {
+	Method   string            `json:"method"`
+	Path     string            `json:"path"`
+	Query    string            `json:"query,omitempty"`
+	Headers  map[string]string `json:"headers"`
+	ClientIP string            `json:"client_ip"`
+	User     *authzUser        `json:"user,omitempty"`
+}
+
+// authzUser carries the validated JWT claims of the caller
+type authzUser struct {
+	UserID   string            `json:"user_id"`
+	Username string            `json:"username,omitempty"`
+	Roles    []string          `json:"roles,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// authzDecision is the authorizer's verdict for a request
+type authzDecision struct {
+	Allowed bool              `json:"allowed"`
+	Status  int               `json:"status,omitempty"`
+	Reason  string            `json:"reason,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// opaResponse is the OPA data API response. The result is either a boolean
+// or an object with allow, reason and headers.
+type opaResponse struct {
+	Result json.RawMessage `json:"result"`
+}
+
+// authorizer calls an external policy service
+type authorizer struct {
+	cfg    config.AuthzConfig
+	client *http.Client
+	cache  cache.Cache
+	logger *zap.Logger
+}
+
+// ExternalAuthz middleware asks an external policy service (OPA or a generic
+// HTTP ext_authz service) whether to allow each request. Decisions are
+// cached briefly per distinct authorizer input, and headers returned by the
+// authorizer are added to the request.
+func (m *Manager) ExternalAuthz() gin.HandlerFunc {
+	cfg := m.config.Auth.Authz
+	az := &authorizer{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		logger: m.logger,
+	}
+	if cfg.CacheTTL > 0 {
+		az.cache = cache.NewMemoryCache(0, cfg.CacheTTL, m.logger)
+	}
+
+	return func(c *gin.Context) {
+		input := m.authzInput(c)
+
+		stop := servertiming.FromContext(c.Request.Context()).Start(servertiming.Auth)
+		decision, err := az.decide(c.Request.Context(), input)
+		stop()
+		if err != nil {
+			m.logger.Error("External authorization failed", zap.Error(err), zap.String("path", input.Path))
+			if !cfg.FailOpen {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Authorization service unavailable"})
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if !decision.Allowed {
+			status := decision.Status
+			if status == 0 {
+				status = http.StatusForbidden
+			}
+			body := gin.H{"error": "Access denied"}
+			if decision.Reason != "" {
+				body["reason"] = decision.Reason
+			}
+			c.JSON(status, body)
+			c.Abort()
+			m.ReportSecurityEvent(c, SecurityForbidden, "external_authz",
+				map[string]string{"authz_reason": decision.Reason})
+			return
+		}
+
+		for name, value := range decision.Headers {
+			c.Request.Header.Set(name, value)
+		}
+
+		c.Next()
+	}
+}
+
+// authzInput collects the request metadata for the authorizer. Credentials
+// are not forwarded; the validated claims describe the caller instead.
+func (m *Manager) authzInput(c *gin.Context) *authzInput {
+	headers := make(map[string]string, len(c.Request.Header))
+	for name, values := range c.Request.Header {
+		lower := strings.ToLower(name)
+		if lower == "authorization" || lower == "cookie" {
+			continue
+		}
+		headers[lower] = strings.Join(values, ", ")
+	}
+
+	input := &authzInput{
+		Method:   c.Request.Method,
+		Path:     c.Request.URL.Path,
+		Query:    c.Request.URL.RawQuery,
+		Headers:  headers,
+		ClientIP: c.ClientIP(),
+	}
+
+	if claims := m.RequestClaims(c); claims != nil {
+		input.User = &authzUser{
+			UserID:   claims.UserID,
+			Username: claims.Username,
+			Roles:    claims.Roles,
+			Metadata: claims.Metadata,
+		}
+	}
+
+	return input
+}
+
+// decide returns the cached decision for the request or asks the authorizer.
+// The cache key hashes the whole input, since a policy may decide on the
+// query, any header, the client IP or the caller's roles.
+func (a *authorizer) decide(ctx context.Context, input *authzInput) (*authzDecision, error) {
+	var key string
+	if a.cache != nil {
+		data, err := json.Marshal(input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode authz input: %w", err)
+		}
+		sum := sha256.Sum256(data)
+		key = hex.EncodeToString(sum[:])
+
+		if data, err := a.cache.Get(ctx, key); err == nil {
+			var decision authzDecision
+			if err := json.Unmarshal(data, &decision); err == nil {
+				return &decision, nil
+			}
+		}
+	}
+
+	var decision *authzDecision
+	var err error
+	switch a.cfg.Type {
+	case "opa":
+		decision, err = a.queryOPA(ctx, input)
+	default:
+		decision, err = a.queryHTTP(ctx, input)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if a.cache != nil {
+		if data, err := json.Marshal(decision); err == nil {
+			a.cache.Set(ctx, key, data, a.cfg.CacheTTL)
+		}
+	}
+
+	return decision, nil
+}
+
+// queryOPA evaluates the policy through the OPA data API
+func (a *authorizer) queryOPA(ctx context.Context, input *authzInput) (*authzDecision, error) {
+	resp, err := a.post(ctx, map[string]interface{}{"input": input})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("opa returned status %d", resp.StatusCode)
+	}
+
+	var result opaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode opa response: %w", err)
+	}
+
+	// An undefined result means no rule matched, which denies the request
+	if len(result.Result) == 0 {
+		return &authzDecision{Allowed: false}, nil
+	}
+
+	var allowed bool
+	if err := json.Unmarshal(result.Result, &allowed); err == nil {
+		return &authzDecision{Allowed: allowed}, nil
+	}
+
+	var object struct {
+		Allow   bool              `json:"allow"`
+		Status  int               `json:"status"`
+		Reason  string            `json:"reason"`
+		Headers map[string]string `json:"headers"`
+	}
+	if err := json.Unmarshal(result.Result, &object); err != nil {
+		return nil, fmt.Errorf("unexpected opa result: %s", result.Result)
+	}
+
+	return &authzDecision{
+		Allowed: object.Allow,
+		Status:  object.Status,
+		Reason:  object.Reason,
+		Headers: object.Headers,
+	}, nil
+}
+
+// queryHTTP asks a generic ext_authz service. A 2xx response allows the
+// request and the configured response headers are passed upstream; any
+// other status denies it with that status.
+func (a *authorizer) queryHTTP(ctx context.Context, input *authzInput) (*authzDecision, error) {
+	resp, err := a.post(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("authorizer returned status %d", resp.StatusCode)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		reason, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &authzDecision{
+			Allowed: false,
+			Status:  resp.StatusCode,
+			Reason:  strings.TrimSpace(string(reason)),
+		}, nil
+	}
+
+	headers := make(map[string]string, len(a.cfg.UpstreamHeaders))
+	for _, name := range a.cfg.UpstreamHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			headers[name] = value
+		}
+	}
+
+	return &authzDecision{Allowed: true, Headers: headers}, nil
+}
+
+// post sends the payload as JSON to the authorizer
+func (a *authorizer) post(ctx context.Context, payload interface{}) (*http.Response, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode authorization request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorization request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("authorization request failed: %w", err)
+	}
+	return resp, nil
+}
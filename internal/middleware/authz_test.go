@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestExternalAuthzCachesPerInput(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var calls atomic.Int32
+	policy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var input authzInput
+		json.NewDecoder(r.Body).Decode(&input)
+		if input.Headers["x-tenant-id"] != "acme" || input.Query != "" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer policy.Close()
+
+	cfg := &config.Config{}
+	cfg.Auth.Authz = config.AuthzConfig{
+		Enabled:  true,
+		Type:     "http",
+		URL:      policy.URL,
+		Timeout:  time.Second,
+		CacheTTL: time.Minute,
+	}
+	router := gin.New()
+	router.Use(NewManager(cfg, nil, nil, nil, nil, zap.NewNop()).ExternalAuthz())
+	router.Any("/*path", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	send := func(target, tenant string) int {
+		r := httptest.NewRequest("GET", target, nil)
+		r.Header.Set("X-Tenant-ID", tenant)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := send("/orders", "acme"); code != http.StatusOK {
+		t.Fatalf("allowed tenant = %d, want 200", code)
+	}
+	if code := send("/orders", "acme"); code != http.StatusOK || calls.Load() != 1 {
+		t.Errorf("repeated request = %d after %d calls, want a cached 200", code, calls.Load())
+	}
+	if code := send("/orders", "globex"); code != http.StatusForbidden {
+		t.Errorf("other tenant on the same path = %d, want 403", code)
+	}
+	if code := send("/orders?all=1", "acme"); code != http.StatusForbidden {
+		t.Errorf("same path with a query = %d, want 403", code)
+	}
+}
+
+func TestExternalAuthzOPA(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	results := map[string]string{
+		"/allowed":   `{"result": true}`,
+		"/denied":    `{"result": false}`,
+		"/undefined": `{}`,
+		"/object":    `{"result": {"allow": true, "headers": {"X-User-Tier": "gold"}}}`,
+		"/reason":    `{"result": {"allow": false, "status": 401, "reason": "mfa required"}}`,
+	}
+	policy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input authzInput `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(results[body.Input.Path]))
+	}))
+	defer policy.Close()
+
+	cfg := &config.Config{}
+	cfg.Auth.Authz = config.AuthzConfig{Enabled: true, Type: "opa", URL: policy.URL, Timeout: time.Second}
+	router := gin.New()
+	router.Use(NewManager(cfg, nil, nil, nil, nil, zap.NewNop()).ExternalAuthz())
+	router.Any("/*path", func(c *gin.Context) { c.String(http.StatusOK, c.GetHeader("X-User-Tier")) })
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/allowed", http.StatusOK, ""},
+		{"/denied", http.StatusForbidden, `{"error":"Access denied"}`},
+		{"/undefined", http.StatusForbidden, `{"error":"Access denied"}`},
+		{"/object", http.StatusOK, "gold"},
+		{"/reason", http.StatusUnauthorized, `{"error":"Access denied","reason":"mfa required"}`},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.status || w.Body.String() != tt.body {
+			t.Errorf("%s = %d %s, want %d %s", tt.path, w.Code, w.Body.String(), tt.status, tt.body)
+		}
+	}
+}
+
+func TestExternalAuthzHTTP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var input authzInput
+	policy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&input)
+		w.Header().Set("X-Tenant", "acme")
+		w.Header().Set("X-Internal", "secret")
+		if input.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("read only"))
+		}
+	}))
+	defer policy.Close()
+
+	cfg := &config.Config{}
+	cfg.Auth.Authz = config.AuthzConfig{
+		Enabled:         true,
+		Type:            "http",
+		URL:             policy.URL,
+		Timeout:         time.Second,
+		UpstreamHeaders: []string{"X-Tenant"},
+	}
+	m := NewManager(cfg, nil, nil, nil, nil, zap.NewNop())
+	events := recordSecurityEvents(m)
+	router := gin.New()
+	router.Use(m.ExternalAuthz())
+	router.Any("/*path", func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetHeader("X-Tenant")+"|"+c.GetHeader("X-Internal"))
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	r.Header.Set("Cookie", "session=abc")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "acme|" {
+		t.Errorf("allowed request = %d %q, want 200 with only the configured upstream header", w.Code, w.Body.String())
+	}
+	if _, ok := input.Headers["authorization"]; ok {
+		t.Error("authorizer received the Authorization header")
+	}
+	if _, ok := input.Headers["cookie"]; ok {
+		t.Error("authorizer received the Cookie header")
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/orders", nil))
+	if w.Code != http.StatusForbidden || w.Body.String() != `{"error":"Access denied","reason":"read only"}` {
+		t.Errorf("denied request = %d %s, want 403 with the authorizer's reason", w.Code, w.Body.String())
+	}
+	if got := events(); len(got) != 1 || got[0].Kind != SecurityForbidden || got[0].Reason != "external_authz" ||
+		got[0].Status != http.StatusForbidden || got[0].Details["authz_reason"] != "read only" {
+		t.Errorf("security events = %+v, want one external_authz denial", got)
+	}
+}
+
+func TestExternalAuthzFailure(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	policy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer policy.Close()
+
+	for _, failOpen := range []bool{false, true} {
+		cfg := &config.Config{}
+		cfg.Auth.Authz = config.AuthzConfig{
+			Enabled:  true,
+			Type:     "http",
+			URL:      policy.URL,
+			Timeout:  time.Second,
+			FailOpen: failOpen,
+		}
+		router := gin.New()
+		router.Use(NewManager(cfg, nil, nil, nil, nil, zap.NewNop()).ExternalAuthz())
+		router.Any("/*path", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+		want := http.StatusServiceUnavailable
+		if failOpen {
+			want = http.StatusOK
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders", nil))
+		if w.Code != want {
+			t.Errorf("fail_open=%v with a failing authorizer = %d, want %d", failOpen, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gocache "github.com/patrickmn/go-cache"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/auth"
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/pkg/servertiming"
+)
+
+// basicRoute is a path prefix protected by Basic authentication
+type basicRoute struct {
+	prefix        string
+	realm         string
+	authenticator auth.BasicAuthenticator
+}
+
+// BasicAuth middleware authenticates requests on the configured path
+// prefixes with HTTP Basic credentials, checked against an htpasswd file or
+// an LDAP bind. Authenticated users are stored as Claims like JWT users, so
+// role checks further down the chain apply unchanged. Failures are counted
+// by the login guard per username and client IP, and locked out keys are
+// refused with 429 before the credentials are checked, since the rate
+// limiter only runs after authentication.
+func (m *Manager) BasicAuth() gin.HandlerFunc {
+	cfg := m.config.Auth.Basic
+	authenticators := m.basicAuthenticators(cfg)
+	guard := m.LoginGuard()
+
+	routes := make([]basicRoute, 0, len(cfg.Routes))
+	for _, route := range cfg.Routes {
+		realm := route.Realm
+		if realm == "" {
+			realm = "api-gateway"
+		}
+		routes = append(routes, basicRoute{
+			prefix:        route.PathPrefix,
+			realm:         realm,
+			authenticator: authenticators[route.Provider],
+		})
+	}
+
+	var verified *gocache.Cache
+	if cfg.CacheTTL > 0 {
+		verified = gocache.New(cfg.CacheTTL, time.Minute)
+	}
+
+	return func(c *gin.Context) {
+		var route *basicRoute
+		for i := range routes {
+			if strings.HasPrefix(c.Request.URL.Path, routes[i].prefix) {
+				route = &routes[i]
+				break
+			}
+		}
+		if route == nil {
+			c.Next()
+			return
+		}
+
+		if route.authenticator == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Authentication backend unavailable"})
+			c.Abort()
+			return
+		}
+
+		username, password, ok := c.Request.BasicAuth()
+		if !ok {
+			c.Header("WWW-Authenticate", `Basic realm="`+route.realm+`"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Basic authentication required"})
+			c.Abort()
+			return
+		}
+
+		// Cache successful logins by a digest of the credentials so LDAP is
+		// not hit on every request
+		sum := sha256.Sum256([]byte(route.prefix + "\x00" + username + "\x00" + password))
+		cacheKey := hex.EncodeToString(sum[:])
+		if verified != nil {
+			if cached, found := verified.Get(cacheKey); found {
+				c.Set("user", cached)
+				c.Set(string(UserContextKey), cached)
+				c.Next()
+				return
+			}
+		}
+
+		// Errors from the failure store let the attempt through so that a
+		// Redis outage does not lock everyone out
+		ctx, clientIP := c.Request.Context(), c.ClientIP()
+		decision, err := guard.Check(ctx, username, clientIP)
+		if err != nil {
+			m.logger.Error("Failed to check login failures", zap.Error(err))
+		}
+		if decision.RetryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(decision.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts"})
+			c.Abort()
+			return
+		}
+
+		stop := servertiming.FromContext(ctx).Start(servertiming.Auth)
+		claims, err := route.authenticator.Authenticate(ctx, username, password)
+		stop()
+		if err != nil {
+			if !errors.Is(err, auth.ErrInvalidCredentials) {
+				m.logger.Error("Basic authentication failed", zap.Error(err), zap.String("username", username))
+			}
+			c.Header("WWW-Authenticate", `Basic realm="`+route.realm+`"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			c.Abort()
+			if errors.Is(err, auth.ErrInvalidCredentials) {
+				if err := guard.Failure(ctx, username, clientIP); err != nil {
+					m.logger.Error("Failed to record login attempt", zap.Error(err))
+				}
+				m.ReportSecurityEvent(c, SecurityAuthFailure, "invalid_credentials", map[string]string{"user": username})
+			}
+			return
+		}
+		if err := guard.Success(ctx, username, clientIP); err != nil {
+			m.logger.Error("Failed to record login attempt", zap.Error(err))
+		}
+
+		if verified != nil {
+			verified.SetDefault(cacheKey, claims)
+		}
+
+		c.Set("user", claims)
+		c.Set(string(UserContextKey), claims)
+		c.Next()
+	}
+}
+
+// basicAuthenticators creates the configured credential backends. A backend
+// that fails to initialize is left out, and its routes reject requests.
+func (m *Manager) basicAuthenticators(cfg config.BasicAuthConfig) map[string]auth.BasicAuthenticator {
+	authenticators := make(map[string]auth.BasicAuthenticator)
+
+	if cfg.HtpasswdFile != "" {
+		htpasswd, err := auth.LoadHtpasswd(cfg.HtpasswdFile)
+		if err != nil {
+			m.logger.Error("Failed to load htpasswd file", zap.Error(err), zap.String("file", cfg.HtpasswdFile))
+		} else {
+			authenticators["htpasswd"] = htpasswd
+		}
+	}
+
+	if cfg.LDAP.URL != "" {
+		ldap, err := auth.NewLDAPAuthenticator(cfg.LDAP.URL, cfg.LDAP.UserDNTemplate, cfg.LDAP.Roles, cfg.LDAP.Timeout)
+		if err != nil {
+			m.logger.Error("Failed to configure LDAP authentication", zap.Error(err))
+		} else {
+			authenticators["ldap"] = ldap
+		}
+	}
+
+	return authenticators
+}
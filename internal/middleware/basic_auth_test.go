@@ -0,0 +1,282 @@
+package middleware
+
+import (
+	"crypto/sha1"
+	"encoding/asn1"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/max/api-gateway/internal/auth"
+	"github.com/max/api-gateway/internal/config"
+)
+
+// fakeLDAP accepts binds with password, counts them and keeps the last DN
+type fakeLDAP struct {
+	password string
+	binds    atomic.Int32
+	dn       atomic.Value
+}
+
+// bindRequest and bindResponse mirror the LDAP bind messages
+type bindRequest struct {
+	MessageID   int
+	BindRequest struct {
+		Version  int
+		Name     []byte
+		Password asn1.RawValue
+	} `asn1:"application,tag:0"`
+}
+
+type bindResponse struct {
+	MessageID    int
+	BindResponse struct {
+		ResultCode        asn1.Enumerated
+		MatchedDN         []byte
+		DiagnosticMessage []byte
+	} `asn1:"application,tag:1"`
+}
+
+// serve answers one bind per connection
+func (l *fakeLDAP) serve(t *testing.T, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			buf := make([]byte, 4096)
+			n, err := conn.Read(buf)
+			if err != nil && err != io.EOF {
+				return
+			}
+			var request bindRequest
+			if _, err := asn1.Unmarshal(buf[:n], &request); err != nil {
+				t.Errorf("malformed bind request: %v", err)
+				return
+			}
+			l.binds.Add(1)
+			l.dn.Store(string(request.BindRequest.Name))
+
+			var response bindResponse
+			response.MessageID = request.MessageID
+			if string(request.BindRequest.Password.Bytes) != l.password {
+				response.BindResponse.ResultCode = 49
+			}
+			packet, _ := asn1.Marshal(response)
+			conn.Write(packet)
+		}()
+	}
+}
+
+func TestBasicAuthThrottlesFailures(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	sum := sha1.Sum([]byte("right"))
+	htpasswd := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(htpasswd, []byte("alice:{SHA}"+base64.StdEncoding.EncodeToString(sum[:])+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	ldap := &fakeLDAP{password: "right"}
+	go ldap.serve(t, listener)
+
+	cfg := &config.Config{}
+	cfg.Auth.Basic = config.BasicAuthConfig{
+		Enabled:      true,
+		HtpasswdFile: htpasswd,
+		LDAP: config.LDAPConfig{
+			URL:            "ldap://" + listener.Addr().String(),
+			UserDNTemplate: "uid=%s,dc=example",
+			Timeout:        time.Second,
+		},
+		Routes: []config.BasicAuthRouteConfig{
+			{PathPrefix: "/files", Provider: "htpasswd"},
+			{PathPrefix: "/directory", Provider: "ldap"},
+		},
+	}
+	cfg.Auth.LoginProtection = config.LoginProtectionConfig{
+		MaxFailures:   3,
+		MaxIPFailures: 100,
+		Window:        time.Minute,
+		BaseLockout:   time.Minute,
+		MaxLockout:    time.Hour,
+	}
+	router := gin.New()
+	router.Use(NewManager(cfg, nil, nil, nil, nil, zap.NewNop()).BasicAuth())
+	router.Any("/*path", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	send := func(path, username, password, clientIP string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = clientIP + ":1234"
+		r.SetBasicAuth(username, password)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, tt := range []struct{ name, path, username string }{
+		{"htpasswd", "/files/report", "alice"},
+		{"ldap", "/directory/me", "bob"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := send(tt.path, tt.username, "right", "10.0.0.1"); w.Code != http.StatusOK {
+				t.Fatalf("valid credentials = %d, want 200", w.Code)
+			}
+			for i := 0; i < 3; i++ {
+				if w := send(tt.path, tt.username, "wrong", "10.0.0.1"); w.Code != http.StatusUnauthorized {
+					t.Fatalf("failure %d = %d, want 401", i, w.Code)
+				}
+			}
+
+			binds := ldap.binds.Load()
+			w := send(tt.path, tt.username, "guess", "10.0.0.2")
+			if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+				t.Errorf("attempt on a locked out username = %d, want 429 with Retry-After", w.Code)
+			}
+			if ldap.binds.Load() != binds {
+				t.Error("locked out attempt reached the LDAP server")
+			}
+		})
+	}
+
+	// The client IP is locked out across usernames
+	cfg.Auth.LoginProtection.MaxIPFailures = 2
+	router = gin.New()
+	router.Use(NewManager(cfg, nil, nil, nil, nil, zap.NewNop()).BasicAuth())
+	router.Any("/*path", func(c *gin.Context) { c.Status(http.StatusOK) })
+	send("/files/report", "carol", "wrong", "10.0.0.3")
+	send("/directory/me", "dave", "wrong", "10.0.0.3")
+	if w := send("/directory/me", "erin", "wrong", "10.0.0.3"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("attempt from a locked out client IP = %d, want 429", w.Code)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("right"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	htpasswd := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(htpasswd, []byte("# users\nalice:"+string(hash)+":ops,admin\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	ldap := &fakeLDAP{password: "right"}
+	go ldap.serve(t, listener)
+
+	cfg := &config.Config{}
+	cfg.Auth.Basic = config.BasicAuthConfig{
+		Enabled:      true,
+		HtpasswdFile: htpasswd,
+		CacheTTL:     time.Minute,
+		LDAP: config.LDAPConfig{
+			URL:            "ldap://" + listener.Addr().String(),
+			UserDNTemplate: "uid=%s,dc=example",
+			Roles:          []string{"staff"},
+			Timeout:        time.Second,
+		},
+		Routes: []config.BasicAuthRouteConfig{
+			{PathPrefix: "/files", Provider: "htpasswd", Realm: "files"},
+			{PathPrefix: "/directory", Provider: "ldap"},
+			{PathPrefix: "/legacy", Provider: "missing"},
+		},
+	}
+	m := NewManager(cfg, nil, nil, nil, nil, zap.NewNop())
+	events := recordSecurityEvents(m)
+	router := gin.New()
+	router.Use(m.BasicAuth())
+	router.Any("/*path", func(c *gin.Context) {
+		claims, _ := c.Get(string(UserContextKey))
+		if claims, ok := claims.(*auth.Claims); ok {
+			c.String(http.StatusOK, claims.Username+":"+strings.Join(claims.Roles, ","))
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+
+	send := func(path, username, password string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		if username != "" {
+			r.SetBasicAuth(username, password)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("htpasswd", func(t *testing.T) {
+		w := send("/files/report", "", "")
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != `Basic realm="files"` {
+			t.Errorf("no credentials = %d %q, want 401 with the route's realm", w.Code, w.Header().Get("WWW-Authenticate"))
+		}
+		if w := send("/files/report", "alice", "right"); w.Code != http.StatusOK || w.Body.String() != "alice:ops,admin" {
+			t.Errorf("valid credentials = %d %q, want 200 as alice with the file's roles", w.Code, w.Body.String())
+		}
+		events()
+		if w := send("/files/report", "alice", "wrong"); w.Code != http.StatusUnauthorized {
+			t.Errorf("wrong password = %d, want 401", w.Code)
+		}
+		if got := events(); len(got) != 1 || got[0].Kind != SecurityAuthFailure || got[0].User != "alice" ||
+			got[0].Reason != "invalid_credentials" || got[0].Details["user"] != "" {
+			t.Errorf("security events = %+v, want one invalid_credentials failure for alice", got)
+		}
+		if w := send("/files/report", "mallory", "right"); w.Code != http.StatusUnauthorized {
+			t.Errorf("unknown user = %d, want 401", w.Code)
+		}
+	})
+
+	t.Run("ldap", func(t *testing.T) {
+		if w := send("/directory/me", "bob,ou=admins", "right"); w.Code != http.StatusOK || w.Body.String() != "bob,ou=admins:staff" {
+			t.Errorf("valid credentials = %d %q, want 200 with the configured roles", w.Code, w.Body.String())
+		}
+		if dn := ldap.dn.Load(); dn != `uid=bob\,ou\=admins,dc=example` {
+			t.Errorf("bind DN = %v, want the username escaped", dn)
+		}
+
+		// Successful logins are cached, failed ones are not
+		binds := ldap.binds.Load()
+		send("/directory/me", "bob,ou=admins", "right")
+		if ldap.binds.Load() != binds {
+			t.Error("cached login reached the LDAP server")
+		}
+		if w := send("/directory/me", "bob,ou=admins", "wrong"); w.Code != http.StatusUnauthorized {
+			t.Errorf("wrong password after a cached login = %d, want 401", w.Code)
+		}
+		if ldap.binds.Load() != binds+1 {
+			t.Error("wrong password was answered from the cache")
+		}
+	})
+
+	if w := send("/legacy/export", "alice", "right"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("route without a backend = %d, want 503", w.Code)
+	}
+	if w := send("/public", "", ""); w.Code != http.StatusOK {
+		t.Errorf("route without basic auth = %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,210 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/bot"
+	"github.com/max/api-gateway/pkg/tlsfingerprint"
+)
+
+// botChallengeCookie holds the token set by the JS challenge page
+const botChallengeCookie = "gateway_bot_challenge"
+
+// Bot mitigation actions
+const (
+	BotActionBlock     = "block"
+	BotActionTarpit    = "tarpit"
+	BotActionChallenge = "challenge"
+	BotActionAllow     = "allow"
+)
+
+// BotDecision describes how a suspected bot request was handled
+type BotDecision struct {
+	Action    string
+	Score     int
+	Reasons   []string
+	Crawler   string
+	ClientIP  string
+	Method    string
+	Path      string
+	UserAgent string
+	JA3       string
+	JA4       string
+}
+
+// BotDecisionListener is notified of every bot mitigation decision and of
+// verified crawlers. Listeners run on the request path and must not block.
+type BotDecisionListener func(decision BotDecision)
+
+// OnBotDecision registers a listener for bot mitigation decisions
+func (m *Manager) OnBotDecision(listener BotDecisionListener) {
+	m.botListenerMu.Lock()
+	defer m.botListenerMu.Unlock()
+	m.botListeners = append(m.botListeners, listener)
+}
+
+// notifyBotDecision passes a decision to the registered listeners
+func (m *Manager) notifyBotDecision(decision BotDecision) {
+	m.botListenerMu.RLock()
+	listeners := make([]BotDecisionListener, len(m.botListeners))
+	copy(listeners, m.botListeners)
+	m.botListenerMu.RUnlock()
+
+	for _, listener := range listeners {
+		listener(decision)
+	}
+}
+
+// BotDetection middleware scores requests with bot heuristics and blocks,
+// tarpits or challenges those at or above the threshold. Allowlisted
+// networks and crawlers verified by reverse DNS pass untouched.
+func (m *Manager) BotDetection() gin.HandlerFunc {
+	cfg := m.config.Security.Bot
+
+	detector, err := bot.NewDetector(cfg, nil)
+	if err != nil {
+		m.logger.Error("Failed to create bot detector, bot detection is disabled", zap.Error(err))
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	return func(c *gin.Context) {
+		clientIP := c.ClientIP()
+		verdict := detector.Inspect(c.Request.Context(), c.Request, clientIP)
+
+		decision := BotDecision{
+			Score:     verdict.Score,
+			Reasons:   verdict.Reasons,
+			Crawler:   verdict.Crawler,
+			ClientIP:  clientIP,
+			Method:    c.Request.Method,
+			Path:      c.Request.URL.Path,
+			UserAgent: c.Request.UserAgent(),
+		}
+		if fp, ok := tlsfingerprint.FromContext(c.Request.Context()); ok {
+			decision.JA3, decision.JA4 = fp.JA3, fp.JA4
+		}
+
+		if verdict.Allowed {
+			if verdict.Crawler != "" {
+				decision.Action = BotActionAllow
+				m.notifyBotDecision(decision)
+			}
+			c.Next()
+			return
+		}
+
+		if verdict.Score < cfg.Threshold {
+			c.Next()
+			return
+		}
+
+		action := cfg.Action
+		if action == BotActionChallenge {
+			if m.validBotChallenge(c, clientIP) {
+				c.Next()
+				return
+			}
+			// Only browsers navigating to a page can run the challenge
+			if c.Request.Method != http.MethodGet || !strings.Contains(c.GetHeader("Accept"), "text/html") {
+				action = BotActionBlock
+			}
+		}
+
+		decision.Action = action
+		m.notifyBotDecision(decision)
+		m.logger.Info("Bot mitigation applied",
+			zap.String("action", action),
+			zap.Int("score", verdict.Score),
+			zap.Strings("reasons", verdict.Reasons),
+			zap.String("ip", clientIP),
+			zap.String("path", c.Request.URL.Path))
+
+		switch action {
+		case BotActionTarpit:
+			select {
+			case <-time.After(cfg.TarpitDelay):
+			case <-c.Request.Context().Done():
+				c.Abort()
+				return
+			}
+			c.Next()
+		case BotActionChallenge:
+			m.serveBotChallenge(c, clientIP)
+		default:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Request blocked"})
+			c.Abort()
+		}
+	}
+}
+
+// botChallengePage sets the challenge cookie with JavaScript and reloads, so
+// it filters out clients that do not execute scripts
+var botChallengePage = template.Must(template.New("challenge").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Checking your browser</title></head>
+<body>
+<noscript>Please enable JavaScript to continue.</noscript>
+<script>
+document.cookie = {{.Cookie}} + "=" + {{.Token}} + "; path=/; max-age=" + {{.MaxAge}} + "; SameSite=Lax";
+window.location.reload();
+</script>
+</body>
+</html>
+`))
+
+// serveBotChallenge responds with the JS challenge page
+func (m *Manager) serveBotChallenge(c *gin.Context, clientIP string) {
+	cfg := m.config.Security.Bot
+	expires := time.Now().Add(cfg.ChallengeTTL).Unix()
+
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusForbidden)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := botChallengePage.Execute(c.Writer, map[string]interface{}{
+		"Cookie": botChallengeCookie,
+		"Token":  fmt.Sprintf("%d.%s", expires, m.botChallengeSignature(clientIP, c.Request.UserAgent(), expires)),
+		"MaxAge": int(cfg.ChallengeTTL.Seconds()),
+	}); err != nil {
+		m.logger.Error("Failed to render bot challenge", zap.Error(err))
+	}
+	c.Abort()
+}
+
+// validBotChallenge reports whether the request carries an unexpired
+// challenge token issued to the same client IP and user agent
+func (m *Manager) validBotChallenge(c *gin.Context, clientIP string) bool {
+	token, err := c.Cookie(botChallengeCookie)
+	if err != nil {
+		return false
+	}
+
+	expiresRaw, signature, found := strings.Cut(token, ".")
+	if !found {
+		return false
+	}
+	expires, err := strconv.ParseInt(expiresRaw, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	expected := m.botChallengeSignature(clientIP, c.Request.UserAgent(), expires)
+	return hmac.Equal([]byte(signature), []byte(expected))
+}
+
+// botChallengeSignature signs a challenge token
+func (m *Manager) botChallengeSignature(clientIP, userAgent string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(m.config.Security.Bot.ChallengeSecret))
+	fmt.Fprintf(mac, "%s|%s|%d", clientIP, userAgent, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
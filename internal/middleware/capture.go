@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/max/api-gateway/internal/capture"
+	"github.com/max/api-gateway/internal/config"
+)
+
+// captureEvaluatedKey marks requests already considered for capture, since
+// route groups repeat the default chain
+const captureEvaluatedKey = "capture_evaluated"
+
+// SetRecorder sets the recorder captured exchanges are sent to
+func (m *Manager) SetRecorder(recorder *capture.Recorder) {
+	m.recorder.Store(recorder)
+}
+
+// Capture middleware records sanitized request/response pairs of the
+// configured routes for replay
+func (m *Manager) Capture() gin.HandlerFunc {
+	cfg := m.config.Capture
+	sanitizer := capture.NewSanitizer(cfg.RedactHeaders, cfg.RedactQuery, cfg.RedactFields)
+
+	return func(c *gin.Context) {
+		if _, evaluated := c.Get(captureEvaluatedKey); evaluated {
+			c.Next()
+			return
+		}
+		c.Set(captureEvaluatedKey, true)
+
+		recorder := m.recorder.Load()
+		if recorder == nil || m.degraded(config.DegradeCapture) || !captureSelected(cfg.Routes, c.Request.Method, c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		exchange := capture.Exchange{
+			Timestamp: start.UTC(),
+			Method:    c.Request.Method,
+			URL:       sanitizer.URL(c.Request.URL),
+			Host:      c.Request.Host,
+			Request:   capture.Message{Header: sanitizer.Header(c.Request.Header)},
+		}
+
+		// Keep the start of the request body and hand the whole body on
+		if c.Request.Body != nil && c.Request.Body != http.NoBody {
+			body := c.Request.Body
+			head, _ := io.ReadAll(io.LimitReader(body, cfg.MaxBodyBytes+1))
+			c.Request.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(head), body), body}
+			exchange.Request.Body, exchange.Request.Truncated = truncate(head, cfg.MaxBodyBytes)
+		}
+
+		writer := &captureWriter{ResponseWriter: c.Writer, limit: cfg.MaxBodyBytes}
+		c.Writer = writer
+		c.Next()
+
+		exchange.RequestID = c.GetString(string(RequestIDKey))
+		exchange.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+		exchange.Request.Body = sanitizer.Body(exchange.Request.Body, c.Request.Header.Get("Content-Type"))
+		exchange.Response = capture.Message{
+			Status:    writer.Status(),
+			Header:    sanitizer.Header(writer.Header()),
+			Body:      writer.body.Bytes(),
+			Truncated: writer.truncated,
+		}
+		exchange.Response.Body = sanitizer.Body(exchange.Response.Body, writer.Header().Get("Content-Type"))
+		recorder.Record(exchange)
+	}
+}
+
+// captureSelected reports whether a request matches a capture route and
+// is sampled by its percentage
+func captureSelected(routes []config.CaptureRouteConfig, method, path string) bool {
+	for _, route := range routes {
+		if !strings.HasPrefix(path, route.PathPrefix) {
+			continue
+		}
+		if len(route.Methods) > 0 && !containsFold(route.Methods, method) {
+			continue
+		}
+		return rand.Float64()*100 < route.Percentage
+	}
+	return false
+}
+
+// truncate returns at most limit bytes of data and whether it was cut
+func truncate(data []byte, limit int64) ([]byte, bool) {
+	if int64(len(data)) > limit {
+		return data[:limit], true
+	}
+	return data, false
+}
+
+// containsFold reports whether slice contains item, ignoring case
+func containsFold(slice []string, item string) bool {
+	for _, s := range slice {
+		if strings.EqualFold(s, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// captureWriter keeps the start of the response body while writing it
+type captureWriter struct {
+	gin.ResponseWriter
+	body      bytes.Buffer
+	limit     int64
+	truncated bool
+}
+
+func (w *captureWriter) Write(data []byte) (int, error) {
+	w.keep(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	w.keep([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// keep buffers data up to the limit
+func (w *captureWriter) keep(data []byte) {
+	remaining := w.limit - int64(w.body.Len())
+	if int64(len(data)) > remaining {
+		w.truncated = true
+		data = data[:max(remaining, 0)]
+	}
+	w.body.Write(data)
+}
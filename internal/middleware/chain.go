@@ -2,15 +2,32 @@ package middleware
 
 import (
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 
+	"github.com/max/api-gateway/internal/analytics"
 	"github.com/max/api-gateway/internal/auth"
+	"github.com/max/api-gateway/internal/capture"
+	"github.com/max/api-gateway/internal/chaos"
 	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/internal/featureflags"
+	"github.com/max/api-gateway/internal/loadshed"
+	"github.com/max/api-gateway/internal/loginguard"
+	"github.com/max/api-gateway/internal/metering"
 	"github.com/max/api-gateway/internal/ratelimit"
+	"github.com/max/api-gateway/internal/usage"
 	"github.com/max/api-gateway/pkg/metrics"
+	"github.com/max/api-gateway/pkg/servertiming"
+	"github.com/max/api-gateway/pkg/tlsfingerprint"
+	"github.com/max/api-gateway/pkg/tracing"
 )
 
 // ContextKey represents a context key type
@@ -23,6 +40,10 @@ const (
 	RequestIDKey ContextKey = "request_id"
 	// StartTimeKey is the context key for request start time
 	StartTimeKey ContextKey = "start_time"
+	// SessionIDKey is the context key for the session ID of cookie-authenticated requests
+	SessionIDKey ContextKey = "session_id"
+	// APIKeyIDKey is the context key for the ID of the API key a request authenticated with
+	APIKeyIDKey ContextKey = "api_key_id"
 )
 
 // Chain represents a middleware chain
@@ -55,16 +76,62 @@ type Manager struct {
 	config      *config.Config
 	jwtAuth     *auth.JWTAuth
 	rateLimiter *ratelimit.Manager
+	redisClient *redis.Client
 	metrics     *metrics.Manager
 	logger      *zap.Logger
+
+	sessions     *auth.SessionStore
+	sessionsOnce sync.Once
+
+	apiKeys     *auth.APIKeyStore
+	apiKeysOnce sync.Once
+	usage       *usage.Tracker
+	usageOnce   sync.Once
+
+	meter    atomic.Pointer[metering.Meter]
+	recorder atomic.Pointer[capture.Recorder]
+
+	chaos     *chaos.Injector
+	chaosOnce sync.Once
+
+	analyzer     *analytics.Analyzer
+	analyzerOnce sync.Once
+
+	loginGuard     *loginguard.Guard
+	loginGuardOnce sync.Once
+
+	botListeners  []BotDecisionListener
+	botListenerMu sync.RWMutex
+
+	adminListeners  []AdminDenialListener
+	adminListenerMu sync.RWMutex
+
+	rateLimitListeners  []RateLimitListener
+	rateLimitListenerMu sync.RWMutex
+
+	securityListeners  []SecurityEventListener
+	securityListenerMu sync.RWMutex
+
+	loadShedder     *loadshed.Shedder
+	loadShedderOnce sync.Once
+	degrader        *loadshed.Degrader
+	degraderOnce    sync.Once
+
+	flags          *featureflags.Client
+	flagsOnce      sync.Once
+	flagListeners  []FlagEvaluationListener
+	flagListenerMu sync.RWMutex
+
+	serviceCORS ServiceCORSFunc
 }
 
 // NewManager creates a new middleware manager
-func NewManager(cfg *config.Config, jwtAuth *auth.JWTAuth, rateLimiter *ratelimit.Manager, metrics *metrics.Manager, logger *zap.Logger) *Manager {
+func NewManager(cfg *config.Config, jwtAuth *auth.JWTAuth, rateLimiter *ratelimit.Manager, redisClient *redis.Client, metrics *metrics.Manager, logger *zap.Logger) *Manager {
 	return &Manager{
 		config:      cfg,
 		jwtAuth:     jwtAuth,
 		rateLimiter: rateLimiter,
+		redisClient: redisClient,
 		metrics:     metrics,
 		logger:      logger,
 	}
@@ -76,13 +143,77 @@ func (m *Manager) CreateDefaultChain() *Chain {
 
 	// Core middlewares (always applied)
 	chain.Use(m.RequestID())
+	if m.config.Server.ServerTiming.Enabled || len(m.config.Server.ServerTiming.Routes) > 0 {
+		chain.Use(m.ServerTiming())
+	}
+	if m.config.Monitoring.Tracing.Enabled {
+		chain.Use(m.TraceContext())
+	}
+	if m.config.Server.ErrorPages.Enabled {
+		chain.Use(m.ErrorPages())
+	}
 	chain.Use(m.Logger())
 	chain.Use(m.Recovery())
 	chain.Use(m.Metrics())
 
-	// CORS middleware if enabled
-	if m.config.Server.CORS.Enabled {
-		chain.Use(m.CORS())
+	// Realtime analytics see every request, including gateway rejections
+	if m.config.Analytics.Enabled {
+		chain.Use(m.Analytics())
+	}
+
+	// Load shedding before any other work on the request
+	if m.config.Server.LoadShedding.Enabled {
+		chain.Use(m.LoadShedding())
+	}
+
+	// Traffic capture sees requests as clients sent them and the final
+	// responses, including gateway rejections
+	if m.config.Capture.Enabled {
+		chain.Use(m.Capture())
+	}
+
+	// CORS, always installed since services may enable their own policy
+	chain.Use(m.CORS())
+
+	// WAF screening before any authentication work
+	if m.config.Security.WAF.Enabled {
+		chain.Use(m.WAF())
+	}
+
+	// TLS fingerprint blocklist
+	if m.config.Security.TLSFingerprint.Enabled {
+		chain.Use(m.TLSFingerprintACL())
+	}
+
+	// Bot mitigation
+	if m.config.Security.Bot.Enabled {
+		chain.Use(m.BotDetection())
+	}
+
+	// Session cookie authentication for browser clients
+	if m.config.Auth.Session.Enabled {
+		chain.Use(m.SessionAuth())
+	}
+
+	// CSRF protection for cookie-authenticated routes
+	if m.config.Auth.CSRF.Enabled {
+		chain.Use(m.CSRF())
+	}
+
+	// Basic authentication for legacy routes, before rate limiting so
+	// limits apply per user
+	if m.config.Auth.Basic.Enabled {
+		chain.Use(m.BasicAuth())
+	}
+
+	// Portal-issued API keys, before rate limiting so limits apply per owner
+	if m.config.Portal.Enabled {
+		chain.Use(m.APIKeyAuth())
+	}
+
+	// Usage metering, before rate limiting so rejected calls are reported too
+	if m.config.Metering.Enabled {
+		chain.Use(m.Metering())
 	}
 
 	// Rate limiting middleware if enabled
@@ -90,6 +221,33 @@ func (m *Manager) CreateDefaultChain() *Chain {
 		chain.Use(m.RateLimit())
 	}
 
+	// Request signature verification if enabled
+	if m.config.Auth.HMAC.Enabled {
+		chain.Use(m.HMACSignature())
+	}
+
+	// External authorization if enabled
+	if m.config.Auth.Authz.Enabled {
+		chain.Use(m.ExternalAuthz())
+	}
+
+	// Fault injection for requests that got through, so faults look like
+	// upstream failures to clients
+	if m.config.Chaos.Enabled {
+		chain.Use(m.Chaos())
+	}
+
+	// Feature flags for the routes and experiments of requests that got
+	// through
+	if m.config.FeatureFlags.Enabled {
+		chain.Use(m.FeatureFlags())
+	}
+
+	// A/B experiment assignment for requests that got through
+	if m.config.Experiments.Enabled {
+		chain.Use(m.Experiments())
+	}
+
 	// Authentication middleware (applied to protected routes)
 	// This is typically applied selectively in routing
 
@@ -107,7 +265,10 @@ func (m *Manager) CreateAuthChain() *Chain {
 func (m *Manager) CreateAdminChain() *Chain {
 	chain := m.CreateDefaultChain()
 	chain.Use(m.JWTAuth())
-	chain.Use(m.RequireRole("admin"))
+	// With admin RBAC, each route requires its own permission instead
+	if !m.config.Auth.AdminRBAC.Enabled {
+		chain.Use(m.RequireRole("admin"))
+	}
 	return chain
 }
 
@@ -121,6 +282,51 @@ func (m *Manager) RequestID() gin.HandlerFunc {
 	}
 }
 
+// TraceContext middleware continues the W3C trace of the incoming request,
+// or starts one, and propagates it to the upstream as a child of the
+// gateway's span
+func (m *Manager) TraceContext() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		parent, err := tracing.ParseTraceparent(c.GetHeader(tracing.TraceparentHeader))
+		if err != nil {
+			parent = tracing.NewTrace()
+		}
+		span := parent.Child()
+
+		c.Request.Header.Set(tracing.TraceparentHeader, span.Traceparent())
+		c.Request = c.Request.WithContext(tracing.WithSpanContext(c.Request.Context(), span))
+		c.Next()
+	}
+}
+
+// credentialParams are query parameters that can carry credentials, such
+// as tokens of clients unable to send headers
+var credentialParams = []string{"token", "access_token", "ticket", "api_key"}
+
+// loggedQuery returns a raw query for the access log with the values of
+// credential parameters redacted
+func loggedQuery(raw string) string {
+	if raw == "" {
+		return raw
+	}
+	// A malformed parameter could hide a credential from the check
+	query, err := url.ParseQuery(raw)
+	if err != nil {
+		return capture.Redacted
+	}
+	redacted := false
+	for _, name := range credentialParams {
+		if _, ok := query[name]; ok {
+			query.Set(name, capture.Redacted)
+			redacted = true
+		}
+	}
+	if !redacted {
+		return raw
+	}
+	return query.Encode()
+}
+
 // Logger middleware logs HTTP requests
 func (m *Manager) Logger() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -132,15 +338,23 @@ func (m *Manager) Logger() gin.HandlerFunc {
 
 		// Log request
 		duration := time.Since(start)
-		m.logger.Info("HTTP Request",
+		fields := []zap.Field{
 			zap.String("method", c.Request.Method),
 			zap.String("path", c.Request.URL.Path),
-			zap.String("query", c.Request.URL.RawQuery),
+			zap.String("query", loggedQuery(c.Request.URL.RawQuery)),
 			zap.Int("status", c.Writer.Status()),
 			zap.Duration("duration", duration),
 			zap.String("client_ip", c.ClientIP()),
 			zap.String("user_agent", c.Request.UserAgent()),
-			zap.String("request_id", c.GetString(string(RequestIDKey))))
+			zap.String("request_id", c.GetString(string(RequestIDKey))),
+		}
+		if fp, ok := tlsfingerprint.FromContext(c.Request.Context()); ok {
+			fields = append(fields, zap.String("ja3", fp.JA3), zap.String("ja4", fp.JA4))
+		}
+		if span, ok := tracing.FromContext(c.Request.Context()); ok {
+			fields = append(fields, zap.String("trace_id", span.TraceID))
+		}
+		m.logger.Info("HTTP Request", fields...)
 	}
 }
 
@@ -149,6 +363,11 @@ func (m *Manager) Recovery() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		defer func() {
 			if err := recover(); err != nil {
+				// Aborted responses, such as upstream bodies failing
+				// mid-stream, must not be completed with an error payload
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
 				m.logger.Error("Panic recovered",
 					zap.Any("error", err),
 					zap.String("path", c.Request.URL.Path),
@@ -178,6 +397,7 @@ func (m *Manager) Metrics() gin.HandlerFunc {
 		duration := time.Since(start)
 		if m.metrics != nil {
 			m.metrics.RecordHTTPRequest(
+				c.Request.Context(),
 				c.Request.Method,
 				c.Request.URL.Path,
 				c.Writer.Status(),
@@ -187,39 +407,46 @@ func (m *Manager) Metrics() gin.HandlerFunc {
 	}
 }
 
-// CORS middleware handles Cross-Origin Resource Sharing
-func (m *Manager) CORS() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		corsConfig := m.config.Server.CORS
-
-		// Set CORS headers
-		if len(corsConfig.AllowedOrigins) > 0 {
-			origin := c.Request.Header.Get("Origin")
-			if origin != "" && contains(corsConfig.AllowedOrigins, origin) {
-				c.Header("Access-Control-Allow-Origin", origin)
-			} else if contains(corsConfig.AllowedOrigins, "*") {
-				c.Header("Access-Control-Allow-Origin", "*")
-			}
-		}
-
-		if len(corsConfig.AllowedMethods) > 0 {
-			c.Header("Access-Control-Allow-Methods", joinStrings(corsConfig.AllowedMethods, ", "))
-		}
+// RateLimitExceeded describes a request rejected by the rate limiter.
+// KeyType is the key template, or "user" or "ip" for the default key.
+// Count is -1 when the algorithm does not count requests, and Rejections
+// is the key's rejections over the offenders window.
+type RateLimitExceeded struct {
+	Timestamp  time.Time
+	Key        string
+	KeyType    string
+	Rule       string
+	Limit      int
+	Window     time.Duration
+	Count      int
+	Rejections int
+	ClientIP   string
+	Method     string
+	Path       string
+	Service    string
+	Route      string
+}
 
-		if len(corsConfig.AllowedHeaders) > 0 {
-			c.Header("Access-Control-Allow-Headers", joinStrings(corsConfig.AllowedHeaders, ", "))
-		}
+// RateLimitListener is notified of every request rejected by the rate
+// limiter. Listeners run on the request path and must not block.
+type RateLimitListener func(exceeded RateLimitExceeded)
 
-		c.Header("Access-Control-Allow-Credentials", "true")
-		c.Header("Access-Control-Max-Age", "86400")
+// OnRateLimitExceeded registers a listener for rate-limited requests
+func (m *Manager) OnRateLimitExceeded(listener RateLimitListener) {
+	m.rateLimitListenerMu.Lock()
+	defer m.rateLimitListenerMu.Unlock()
+	m.rateLimitListeners = append(m.rateLimitListeners, listener)
+}
 
-		// Handle preflight requests
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusNoContent)
-			return
-		}
+// notifyRateLimitExceeded passes a rejected request to the listeners
+func (m *Manager) notifyRateLimitExceeded(exceeded RateLimitExceeded) {
+	m.rateLimitListenerMu.RLock()
+	listeners := make([]RateLimitListener, len(m.rateLimitListeners))
+	copy(listeners, m.rateLimitListeners)
+	m.rateLimitListenerMu.RUnlock()
 
-		c.Next()
+	for _, listener := range listeners {
+		listener(exceeded)
 	}
 }
 
@@ -227,22 +454,13 @@ func (m *Manager) CORS() gin.HandlerFunc {
 func (m *Manager) RateLimit() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Determine rate limit key
-		var key string
-
-		// Try to get user ID from JWT token
-		if claims, exists := c.Get("user"); exists {
-			if userClaims, ok := claims.(*auth.Claims); ok {
-				key = userClaims.UserID
-			}
-		}
-
-		// Fall back to IP address
-		if key == "" {
-			key = c.ClientIP()
-		}
+		template := m.config.RateLimit.Key
+		key := m.rateLimitKey(c, template)
 
 		// Check rate limit
-		allowed, err := m.rateLimiter.CheckLimit(key)
+		stop := servertiming.FromContext(c.Request.Context()).Start(servertiming.RateLimit)
+		decision, err := m.rateLimiter.Check(key)
+		stop()
 		if err != nil {
 			m.logger.Error("Rate limit check failed",
 				zap.Error(err),
@@ -254,12 +472,38 @@ func (m *Manager) RateLimit() gin.HandlerFunc {
 			return
 		}
 
-		if !allowed {
+		if !decision.Allowed {
+			service, route := requestRoute(c)
+			exceeded := RateLimitExceeded{
+				Timestamp: time.Now().UTC(),
+				Key:       key,
+				KeyType:   m.rateLimitKeyType(c, template),
+				Rule:      decision.Rule,
+				Limit:     decision.Limit,
+				Window:    decision.Window,
+				Count:     decision.Count,
+				ClientIP:  c.ClientIP(),
+				Method:    c.Request.Method,
+				Path:      c.Request.URL.Path,
+				Service:   service,
+				Route:     route,
+			}
+			exceeded.Rejections = m.rateLimiter.Offenders().Record(ratelimit.Rejection{
+				Key:      key,
+				KeyType:  exceeded.KeyType,
+				Rule:     decision.Rule,
+				Route:    route,
+				ClientIP: exceeded.ClientIP,
+			})
 			m.logger.Warn("Rate limit exceeded",
 				zap.String("key", key),
+				zap.String("key_type", exceeded.KeyType),
+				zap.String("rule", decision.Rule),
+				zap.String("route", route),
 				zap.String("ip", c.ClientIP()))
+			m.notifyRateLimitExceeded(exceeded)
 
-			c.Header("X-RateLimit-Limit", "100") // This should come from config
+			c.Header("X-RateLimit-Limit", strconv.Itoa(decision.Limit))
 			c.Header("X-RateLimit-Remaining", "0")
 			c.Header("Retry-After", "60")
 
@@ -278,6 +522,20 @@ func (m *Manager) RateLimit() gin.HandlerFunc {
 // JWTAuth middleware validates JWT tokens
 func (m *Manager) JWTAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// Requests already authenticated earlier in the chain, with a session
+		// cookie, Basic credentials or a token validated for rate limiting,
+		// pass through
+		if _, exists := c.Get(string(UserContextKey)); exists {
+			c.Next()
+			return
+		}
+		if claims := AdminUser(c.Request); claims != nil {
+			c.Set("user", claims)
+			c.Set(string(UserContextKey), claims)
+			c.Next()
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{
@@ -293,15 +551,19 @@ func (m *Manager) JWTAuth() gin.HandlerFunc {
 				"error": "Invalid authorization header",
 			})
 			c.Abort()
+			m.ReportSecurityEvent(c, SecurityAuthFailure, "invalid_authorization_header", nil)
 			return
 		}
 
+		stop := servertiming.FromContext(c.Request.Context()).Start(servertiming.Auth)
 		claims, err := m.jwtAuth.ValidateToken(token)
+		stop()
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error": "Invalid token",
 			})
 			c.Abort()
+			m.ReportSecurityEvent(c, SecurityAuthFailure, "invalid_token", nil)
 			return
 		}
 
@@ -339,6 +601,7 @@ func (m *Manager) RequireRole(requiredRole string) gin.HandlerFunc {
 				"error": "Insufficient permissions",
 			})
 			c.Abort()
+			m.ReportSecurityEvent(c, SecurityForbidden, "missing_role", map[string]string{"required_role": requiredRole})
 			return
 		}
 
@@ -372,6 +635,8 @@ func (m *Manager) RequireAnyRole(requiredRoles []string) gin.HandlerFunc {
 				"error": "Insufficient permissions",
 			})
 			c.Abort()
+			m.ReportSecurityEvent(c, SecurityForbidden, "missing_role",
+				map[string]string{"required_role": strings.Join(requiredRoles, ",")})
 			return
 		}
 
@@ -394,25 +659,3 @@ func randomString(length int) string {
 	}
 	return string(b)
 }
-
-func contains(slice []string, item string) bool {
-	for _, s := range slice {
-		if s == item {
-			return true
-		}
-	}
-	return false
-}
-
-func joinStrings(slice []string, separator string) string {
-	if len(slice) == 0 {
-		return ""
-	}
-
-	result := slice[0]
-	for i := 1; i < len(slice); i++ {
-		result += separator + slice[i]
-	}
-	return result
-}
-
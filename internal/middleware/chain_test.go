@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/auth"
+	"github.com/max/api-gateway/internal/config"
+)
+
+// recordSecurityEvents collects the security events reported by m
+func recordSecurityEvents(m *Manager) func() []SecurityEvent {
+	var mu sync.Mutex
+	var recorded []SecurityEvent
+	m.OnSecurityEvent(func(event SecurityEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		recorded = append(recorded, event)
+	})
+	return func() []SecurityEvent {
+		mu.Lock()
+		defer mu.Unlock()
+		events := recorded
+		recorded = nil
+		return events
+	}
+}
+
+func TestLoggedQuery(t *testing.T) {
+	tests := []struct{ raw, want string }{
+		{"", ""},
+		{"page=2&sort=name", "page=2&sort=name"},
+		{"token=eyJhbGciOi&page=2", "page=2&token=REDACTED"},
+		{"ticket=abc", "ticket=REDACTED"},
+		{"token=%zz", "REDACTED"},
+	}
+	for _, tt := range tests {
+		if got := loggedQuery(tt.raw); got != tt.want {
+			t.Errorf("loggedQuery(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestSecurityEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	jwtAuth := auth.NewJWTAuth("test-secret", time.Hour, 24*time.Hour, "gateway", "gateway", "HS256", zap.NewNop())
+	m := NewManager(&config.Config{}, jwtAuth, nil, nil, nil, zap.NewNop())
+	events := recordSecurityEvents(m)
+
+	router := gin.New()
+	router.Use(m.JWTAuth(), m.RequireRole("admin"))
+	router.Any("/*path", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	send := func(authorization string) int {
+		r := httptest.NewRequest(http.MethodDelete, "/admin/users/7", nil)
+		r.RemoteAddr = "203.0.113.9:4321"
+		r.Header.Set("User-Agent", "probe/1.0")
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+	token := func(roles ...string) string {
+		token, err := jwtAuth.GenerateToken("1", "tester", "tester@example.com", roles, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + token
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+		want          *SecurityEvent
+	}{
+		{"malformed header", "Token abc", http.StatusUnauthorized,
+			&SecurityEvent{Kind: SecurityAuthFailure, Reason: "invalid_authorization_header"}},
+		{"invalid token", "Bearer junk", http.StatusUnauthorized,
+			&SecurityEvent{Kind: SecurityAuthFailure, Reason: "invalid_token"}},
+		{"missing role", token("user"), http.StatusForbidden,
+			&SecurityEvent{Kind: SecurityForbidden, Reason: "missing_role", User: "tester",
+				Details: map[string]string{"required_role": "admin"}}},
+		{"allowed", token("admin"), http.StatusOK, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := send(tt.authorization); code != tt.status {
+				t.Fatalf("status = %d, want %d", code, tt.status)
+			}
+			got := events()
+			if tt.want == nil {
+				if len(got) != 0 {
+					t.Errorf("events = %+v, want none", got)
+				}
+				return
+			}
+			if len(got) != 1 {
+				t.Fatalf("got %d events, want 1", len(got))
+			}
+			event := got[0]
+			if event.Kind != tt.want.Kind || event.Reason != tt.want.Reason || event.User != tt.want.User {
+				t.Errorf("event = %s/%s by %q, want %s/%s by %q",
+					event.Kind, event.Reason, event.User, tt.want.Kind, tt.want.Reason, tt.want.User)
+			}
+			if event.Status != tt.status || event.ClientIP != "203.0.113.9" || event.Method != http.MethodDelete ||
+				event.Path != "/admin/users/7" || event.UserAgent != "probe/1.0" || event.Timestamp.IsZero() {
+				t.Errorf("event request = %+v, want the rejected request", event)
+			}
+			for key, value := range tt.want.Details {
+				if event.Details[key] != value {
+					t.Errorf("event detail %s = %q, want %q", key, event.Details[key], value)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/chaos"
+)
+
+// chaosExemptPrefixes are never faulted, so injection can always be
+// inspected and switched off
+var chaosExemptPrefixes = []string{"/admin", "/health", "/metrics"}
+
+// chaosEvaluatedKey marks requests already considered for a fault, since
+// route groups repeat the default chain
+const chaosEvaluatedKey = "chaos_evaluated"
+
+// ChaosInjector returns the fault injector shared by the Chaos middleware
+// and the admin API
+func (m *Manager) ChaosInjector() *chaos.Injector {
+	m.chaosOnce.Do(func() {
+		m.chaos = chaos.NewInjector(m.config.Chaos, m.config.Server.Environment)
+	})
+	return m.chaos
+}
+
+// Chaos middleware injects latency, error responses and dropped
+// connections into requests matching the fault injection rules
+func (m *Manager) Chaos() gin.HandlerFunc {
+	injector := m.ChaosInjector()
+	if !injector.Allowed() {
+		m.logger.Warn("Chaos injection is disabled in production, set chaos.allow_production to override")
+	}
+
+	return func(c *gin.Context) {
+		if _, evaluated := c.Get(chaosEvaluatedKey); evaluated {
+			c.Next()
+			return
+		}
+		c.Set(chaosEvaluatedKey, true)
+
+		for _, prefix := range chaosExemptPrefixes {
+			if strings.HasPrefix(c.Request.URL.Path, prefix) {
+				c.Next()
+				return
+			}
+		}
+
+		rule, faulted := injector.Match(c.Request)
+		if !faulted {
+			c.Next()
+			return
+		}
+
+		if m.metrics != nil {
+			m.metrics.RecordChaosFault(rule.Name, rule.Fault())
+		}
+		m.logger.Info("Injecting fault",
+			zap.String("rule", rule.Name),
+			zap.String("fault", rule.Fault()),
+			zap.Duration("latency", rule.Latency),
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+			zap.String("request_id", c.GetString(string(RequestIDKey))))
+
+		if rule.Latency > 0 {
+			timer := time.NewTimer(rule.Latency)
+			select {
+			case <-timer.C:
+			case <-c.Request.Context().Done():
+				timer.Stop()
+				c.Abort()
+				return
+			}
+		}
+
+		switch {
+		case rule.Drop:
+			// Recovery re-panics this so the server closes the
+			// connection without a response
+			panic(http.ErrAbortHandler)
+		case rule.AbortStatus != 0:
+			c.JSON(rule.AbortStatus, gin.H{"error": "Fault injected", "rule": rule.Name})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/max/api-gateway/internal/cors"
+)
+
+// ServiceCORSFunc returns the CORS policy of the service a proxied request
+// addresses, and false when the service has none of its own
+type ServiceCORSFunc func(r *http.Request) (*cors.Policy, bool)
+
+// SetServiceCORS sets how proxied requests find their service's CORS
+// policy. It must be called before the routes are served.
+func (m *Manager) SetServiceCORS(resolve ServiceCORSFunc) {
+	m.serviceCORS = resolve
+}
+
+// CORS middleware handles Cross-Origin Resource Sharing. It runs after
+// route matching: gateway routes use the gateway-wide policy and its route
+// policies, proxied requests the policy of their service if it has one.
+// Preflight requests are answered here, before authentication.
+func (m *Manager) CORS() gin.HandlerFunc {
+	resolver := cors.NewResolver(m.config.Server.CORS)
+
+	return func(c *gin.Context) {
+		policy := resolver.Policy(c.Request.URL.Path)
+		// Proxied requests match no gateway route
+		if c.FullPath() == "" && m.serviceCORS != nil {
+			if servicePolicy, ok := m.serviceCORS(c.Request); ok {
+				policy = servicePolicy
+			}
+		}
+		if policy == nil {
+			c.Next()
+			return
+		}
+
+		policy.Apply(c.Writer.Header(), c.Request)
+		if cors.IsPreflight(c.Request) {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Request = c.Request.WithContext(cors.WithHandled(c.Request.Context()))
+		c.Next()
+	}
+}
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/auth"
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestCSRFBearerExemption(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{}
+	cfg.Auth.Session = config.SessionConfig{
+		Enabled:         true,
+		CookieName:      "gw_session",
+		Path:            "/",
+		IdleTimeout:     time.Minute,
+		AbsoluteTimeout: time.Hour,
+	}
+	cfg.Auth.CSRF = config.CSRFConfig{
+		Enabled:    true,
+		CookieName: "gw_csrf",
+		HeaderName: "X-CSRF-Token",
+		Routes:     []string{"/account/"},
+	}
+	jwtAuth := auth.NewJWTAuth("test-secret", time.Hour, 24*time.Hour, "gateway", "gateway", "HS256", zap.NewNop())
+	m := NewManager(cfg, jwtAuth, nil, nil, nil, zap.NewNop())
+
+	router := gin.New()
+	router.Use(m.SessionAuth(), m.CSRF())
+	router.Any("/*path", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	session, err := m.Sessions().Create(context.Background(), &auth.Claims{UserID: "1", Username: "tester"})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	token, err := jwtAuth.GenerateToken("1", "tester", "tester@example.com", []string{"user"}, nil)
+	if err != nil {
+		t.Fatalf("GenerateToken() error = %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		session       bool
+		authorization string
+		want          int
+	}{
+		{"cookie without token", true, "", http.StatusForbidden},
+		{"cookie with junk bearer", true, "Bearer junk", http.StatusForbidden},
+		{"cookie with valid bearer", true, "Bearer " + token, http.StatusOK},
+		{"bearer only", false, "Bearer " + token, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/account/email", nil)
+			if tt.session {
+				r.AddCookie(&http.Cookie{Name: "gw_session", Value: session.ID})
+			}
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+
+	t.Run("cookie with matching csrf header", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/account/email", nil)
+		r.AddCookie(&http.Cookie{Name: "gw_session", Value: session.ID})
+		r.AddCookie(&http.Cookie{Name: "gw_csrf", Value: "t0ken"})
+		r.Header.Set("X-CSRF-Token", "t0ken")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("status = %d, want 200", w.Code)
+		}
+	})
+}
@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/errorpage"
+)
+
+// maxHeldErrorBody caps the gateway error body kept for the message
+const maxHeldErrorBody = 64 << 10
+
+// ErrorPages middleware renders the error responses generated by the gateway
+// itself from the configured templates. Responses relayed from upstream
+// services are passed through unchanged.
+func (m *Manager) ErrorPages() gin.HandlerFunc {
+	renderer, err := errorpage.NewRenderer(m.config.Server.ErrorPages)
+	if err != nil {
+		m.logger.Error("Failed to load error page templates", zap.Error(err))
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	return func(c *gin.Context) {
+		w := &errorPageWriter{ResponseWriter: c.Writer, renderer: renderer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		if w.status == 0 {
+			return
+		}
+
+		service, _, _ := strings.Cut(strings.TrimPrefix(c.Request.URL.Path, "/"), "/")
+		header := w.ResponseWriter.Header()
+		contentType, body, err := renderer.Render(c.GetHeader("Accept"), errorpage.Data{
+			Status:     w.status,
+			Message:    errorpage.Message(w.body.Bytes(), w.status),
+			RequestID:  c.GetString(string(RequestIDKey)),
+			Service:    service,
+			RetryAfter: header.Get("Retry-After"),
+			Method:     c.Request.Method,
+			Path:       c.Request.URL.Path,
+		})
+		if err != nil {
+			m.logger.Warn("Failed to render error page", zap.Error(err), zap.Int("status", w.status))
+		}
+		if body == nil {
+			body = w.body.Bytes()
+		} else {
+			header.Set("Content-Type", contentType)
+		}
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+
+		w.ResponseWriter.WriteHeader(w.status)
+		w.ResponseWriter.Write(body)
+	}
+}
+
+// errorPageWriter holds back error responses that have a template until the
+// handlers are done, so they can be rendered
+type errorPageWriter struct {
+	gin.ResponseWriter
+	renderer *errorpage.Renderer
+	upstream bool
+	status   int
+	body     bytes.Buffer
+}
+
+// MarkUpstream marks the response as relayed from an upstream service
+func (w *errorPageWriter) MarkUpstream() {
+	w.upstream = true
+}
+
+func (w *errorPageWriter) WriteHeader(code int) {
+	if w.body.Len() > 0 {
+		return
+	}
+	if code >= 400 && !w.upstream && !w.ResponseWriter.Written() && w.renderer.Has(code) {
+		w.status = code
+		return
+	}
+	w.status = 0
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *errorPageWriter) WriteHeaderNow() {
+	if w.status == 0 {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *errorPageWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		return w.ResponseWriter.Write(data)
+	}
+	if room := maxHeldErrorBody - w.body.Len(); room > 0 {
+		w.body.Write(data[:min(len(data), room)])
+	}
+	return len(data), nil
+}
+
+func (w *errorPageWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *errorPageWriter) Status() int {
+	if w.status != 0 {
+		return w.status
+	}
+	return w.ResponseWriter.Status()
+}
+
+func (w *errorPageWriter) Size() int {
+	if w.status != 0 {
+		return w.body.Len()
+	}
+	return w.ResponseWriter.Size()
+}
+
+func (w *errorPageWriter) Written() bool {
+	return w.status != 0 || w.ResponseWriter.Written()
+}
+
+func (w *errorPageWriter) Flush() {
+	if w.status == 0 {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *errorPageWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.ResponseWriter.Hijack()
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *errorPageWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
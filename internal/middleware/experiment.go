@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/max/api-gateway/internal/experiment"
+	"github.com/max/api-gateway/internal/featureflags"
+)
+
+// Experiments middleware assigns users to the variants of the experiments
+// that apply to the requested service, passes the assignments upstream as
+// X-Experiment-<name> headers and records per-variant metrics. Users are
+// identified by user ID, or by a visitor ID kept in the experiment cookie.
+func (m *Manager) Experiments() gin.HandlerFunc {
+	cfg := m.config.Experiments
+	set := experiment.NewSet(cfg.Definitions)
+
+	return func(c *gin.Context) {
+		// Only the gateway assigns variants
+		for name := range c.Request.Header {
+			if strings.HasPrefix(name, experiment.HeaderPrefix) {
+				c.Request.Header.Del(name)
+			}
+		}
+
+		service, _, _ := strings.Cut(strings.TrimPrefix(c.Request.URL.Path, "/"), "/")
+		if !set.Applies(service) {
+			c.Next()
+			return
+		}
+
+		cookie, _ := c.Cookie(cfg.CookieName)
+		state := set.ParseState(cookie)
+
+		var unitID string
+		if claims := m.RequestClaims(c); claims != nil && claims.UserID != "" {
+			unitID = "user:" + claims.UserID
+		} else {
+			if state.VisitorID == "" {
+				state.SetVisitorID(newVisitorID())
+			}
+			unitID = "visitor:" + state.VisitorID
+		}
+
+		active := func(flag string) bool { return featureflags.Enabled(c.Request.Context(), flag) }
+		assignments := set.Assign(service, unitID, state, active)
+		for _, assignment := range assignments {
+			c.Request.Header.Set(experiment.HeaderPrefix+assignment.Experiment, assignment.Variant)
+		}
+		if state.Changed() {
+			c.SetSameSite(http.SameSiteLaxMode)
+			c.SetCookie(cfg.CookieName, state.Encode(), int(cfg.CookieTTL.Seconds()), "/", "", cfg.Secure, true)
+		}
+
+		start := time.Now()
+		c.Next()
+
+		if m.metrics != nil {
+			duration := time.Since(start)
+			for _, assignment := range assignments {
+				m.metrics.RecordExperimentRequest(assignment.Experiment, assignment.Variant, c.Writer.Status(), duration)
+			}
+		}
+	}
+}
+
+// newVisitorID generates a random visitor ID
+func newVisitorID() string {
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	return hex.EncodeToString(raw)
+}
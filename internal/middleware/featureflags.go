@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/featureflags"
+)
+
+// FlagEvaluations describes the feature flags a request evaluated
+type FlagEvaluations struct {
+	Timestamp   time.Time
+	UserID      string
+	ClientIP    string
+	Method      string
+	Path        string
+	Service     string
+	StatusCode  int
+	Latency     time.Duration
+	Evaluations []featureflags.Evaluation
+}
+
+// FlagEvaluationListener is notified after each request that evaluated
+// feature flags. Listeners run on the request path and must not block.
+type FlagEvaluationListener func(evaluations FlagEvaluations)
+
+// OnFlagEvaluations registers a listener for the flags requests evaluated
+func (m *Manager) OnFlagEvaluations(listener FlagEvaluationListener) {
+	m.flagListenerMu.Lock()
+	defer m.flagListenerMu.Unlock()
+	m.flagListeners = append(m.flagListeners, listener)
+}
+
+// notifyFlagEvaluations passes a request's evaluations to the listeners
+func (m *Manager) notifyFlagEvaluations(evaluations FlagEvaluations) {
+	m.flagListenerMu.RLock()
+	listeners := make([]FlagEvaluationListener, len(m.flagListeners))
+	copy(listeners, m.flagListeners)
+	m.flagListenerMu.RUnlock()
+
+	for _, listener := range listeners {
+		listener(evaluations)
+	}
+}
+
+// FeatureFlagClient returns the feature flag client of the FeatureFlags
+// middleware, or nil if the provider could not be created
+func (m *Manager) FeatureFlagClient() *featureflags.Client {
+	m.flagsOnce.Do(func() {
+		client, err := featureflags.New(m.config.FeatureFlags, m.logger)
+		if err != nil {
+			m.logger.Error("Failed to create feature flag client, flags are off", zap.Error(err))
+			return
+		}
+		m.flags = client
+	})
+	return m.flags
+}
+
+// FeatureFlags middleware lets the routes, SOAP conversions and experiments
+// of a request evaluate feature flags, each at most once. Flags are
+// evaluated for the user ID, or the client IP, and the request's method,
+// path and service.
+func (m *Manager) FeatureFlags() gin.HandlerFunc {
+	client := m.FeatureFlagClient()
+
+	return func(c *gin.Context) {
+		if client == nil || featureflags.HasContext(c.Request.Context()) {
+			c.Next()
+			return
+		}
+
+		service, _, _ := strings.Cut(strings.TrimPrefix(c.Request.URL.Path, "/"), "/")
+		ec := featureflags.EvaluationContext{
+			TargetingKey: "ip:" + c.ClientIP(),
+			Attributes: map[string]string{
+				"method":    c.Request.Method,
+				"path":      c.Request.URL.Path,
+				"service":   service,
+				"client_ip": c.ClientIP(),
+			},
+		}
+		var userID string
+		if claims := m.RequestClaims(c); claims != nil && claims.UserID != "" {
+			userID = claims.UserID
+			ec.TargetingKey = "user:" + userID
+			ec.Attributes["user_id"] = userID
+			ec.Attributes["roles"] = strings.Join(claims.Roles, ",")
+		}
+
+		ctx := featureflags.NewContext(c.Request.Context(), client, ec)
+		c.Request = c.Request.WithContext(ctx)
+
+		start := time.Now()
+		c.Next()
+
+		evaluations := featureflags.Evaluations(ctx)
+		if len(evaluations) == 0 || !m.config.FeatureFlags.Events {
+			return
+		}
+		m.notifyFlagEvaluations(FlagEvaluations{
+			Timestamp:   start.UTC(),
+			UserID:      userID,
+			ClientIP:    c.ClientIP(),
+			Method:      c.Request.Method,
+			Path:        c.Request.URL.Path,
+			Service:     service,
+			StatusCode:  c.Writer.Status(),
+			Latency:     time.Since(start),
+			Evaluations: evaluations,
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/pkg/hmacsign"
+)
+
+func TestHMACSignature(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{}
+	cfg.Auth.HMAC = config.HMACConfig{
+		Enabled: true,
+		Routes: []config.HMACRouteConfig{{
+			PathPrefix: "/payments/",
+			MaxSkew:    time.Minute,
+			Clients:    map[string]string{"partner": "s3cret"},
+		}},
+	}
+	router := gin.New()
+	router.Use(NewManager(cfg, nil, nil, nil, nil, zap.NewNop()).HMACSignature())
+	router.Any("/*path", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	type signed struct {
+		method, target, body, timestamp, nonce, signature string
+	}
+	sign := func(method, target, body string, at time.Time, nonce string) signed {
+		timestamp := strconv.FormatInt(at.Unix(), 10)
+		return signed{method, target, body, timestamp, nonce,
+			"sha256=" + hmacsign.Sign("s3cret", timestamp, nonce, method, target, []byte(body))}
+	}
+	send := func(s signed) int {
+		r := httptest.NewRequest(s.method, s.target, strings.NewReader(s.body))
+		r.Header.Set("X-Client-Id", "partner")
+		r.Header.Set("X-Timestamp", s.timestamp)
+		r.Header.Set("X-Signature", s.signature)
+		if s.nonce != "" {
+			r.Header.Set("X-Nonce", s.nonce)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	original := sign("POST", "/payments/charge?amount=10", `{"id":1}`, time.Now(), "n1")
+	if code := send(original); code != http.StatusOK {
+		t.Fatalf("signed request = %d, want 200", code)
+	}
+	if code := send(original); code != http.StatusUnauthorized {
+		t.Errorf("request with a reused nonce = %d, want 401", code)
+	}
+
+	freshNonce := original
+	freshNonce.nonce = "n2"
+	if code := send(freshNonce); code != http.StatusUnauthorized {
+		t.Errorf("replay with a fresh nonce = %d, want 401", code)
+	}
+
+	otherPath := original
+	otherPath.nonce, otherPath.target = "n3", "/payments/refund?amount=10"
+	otherPath.signature = sign("POST", "/payments/charge?amount=10", `{"id":1}`, time.Now(), "n3").signature
+	if code := send(otherPath); code != http.StatusUnauthorized {
+		t.Errorf("replay on another path = %d, want 401", code)
+	}
+
+	otherMethod := sign("POST", "/payments/charge", "", time.Now(), "n4")
+	otherMethod.method = "DELETE"
+	if code := send(otherMethod); code != http.StatusUnauthorized {
+		t.Errorf("replay with another method = %d, want 401", code)
+	}
+
+	if code := send(sign("POST", "/payments/charge", "", time.Now().Add(-2*time.Minute), "n5")); code != http.StatusUnauthorized {
+		t.Errorf("request outside the skew window = %d, want 401", code)
+	}
+
+	// Without a nonce the signature itself may only be used once
+	noNonce := sign("POST", "/payments/charge", `{"id":2}`, time.Now(), "")
+	if code := send(noNonce); code != http.StatusOK {
+		t.Fatalf("signed request without a nonce = %d, want 200", code)
+	}
+	if code := send(noNonce); code != http.StatusUnauthorized {
+		t.Errorf("replay without a nonce = %d, want 401", code)
+	}
+
+	if code := send(signed{method: "GET", target: "/orders"}); code != http.StatusOK {
+		t.Errorf("request outside the signed routes = %d, want 200", code)
+	}
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/loadshed"
+)
+
+// loadShedExemptPrefixes are never shed, so an overloaded gateway can still
+// be inspected and probed
+var loadShedExemptPrefixes = []string{"/admin", "/health", "/metrics"}
+
+// loadShedClassKey holds the priority class of admitted requests, since
+// route groups repeat the default chain
+const loadShedClassKey = "priority_class"
+
+// LoadShedder returns the load shedder of the LoadShedding middleware
+func (m *Manager) LoadShedder() *loadshed.Shedder {
+	m.loadShedderOnce.Do(func() {
+		m.loadShedder = loadshed.New(m.config.Server.LoadShedding, m.metrics)
+	})
+	return m.loadShedder
+}
+
+// Degrader returns the degradation monitor that switches middleware
+// features off while the gateway is overloaded. It is started by the caller.
+func (m *Manager) Degrader() *loadshed.Degrader {
+	m.degraderOnce.Do(func() {
+		m.degrader = loadshed.NewDegrader(m.config.Server.Degradation, m.metrics, m.logger)
+	})
+	return m.degrader
+}
+
+// degraded reports whether a feature is switched off by the degradation
+// mode
+func (m *Manager) degraded(feature string) bool {
+	return m.config.Server.Degradation.Enabled && m.Degrader().Disabled(feature)
+}
+
+// LoadShedding middleware sheds low-priority requests with 503 and
+// Retry-After while the gateway is overloaded, keeping capacity for
+// critical traffic
+func (m *Manager) LoadShedding() gin.HandlerFunc {
+	shedder := m.LoadShedder()
+	retryAfter := strconv.Itoa(int(math.Ceil(shedder.RetryAfter().Seconds())))
+
+	return func(c *gin.Context) {
+		if _, admitted := c.Get(loadShedClassKey); admitted {
+			c.Next()
+			return
+		}
+		for _, prefix := range loadShedExemptPrefixes {
+			if strings.HasPrefix(c.Request.URL.Path, prefix) {
+				c.Next()
+				return
+			}
+		}
+
+		class := shedder.Classify(c.Request)
+		release, signal := shedder.Admit(class)
+		if signal != "" {
+			m.logger.Debug("Request shed under overload",
+				zap.String("class", class),
+				zap.String("signal", signal),
+				zap.String("path", c.Request.URL.Path))
+			c.Header("Retry-After", retryAfter)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Gateway overloaded, retry later"})
+			return
+		}
+		defer release()
+
+		c.Set(loadShedClassKey, class)
+		c.Next()
+	}
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"github.com/max/api-gateway/internal/loginguard"
+)
+
+// LoginGuard returns the login brute-force guard, creating it on first use.
+// Counters are kept in Redis when available so that every instance sees the
+// same failures.
+func (m *Manager) LoginGuard() *loginguard.Guard {
+	m.loginGuardOnce.Do(func() {
+		var store loginguard.Store
+		if m.redisClient != nil {
+			store = loginguard.NewRedisStore(m.redisClient)
+		} else {
+			m.logger.Warn("Redis unavailable, login failures are counted per instance")
+			store = loginguard.NewMemoryStore()
+		}
+		m.loginGuard = loginguard.NewGuard(m.config.Auth.LoginProtection, store, m.logger)
+	})
+	return m.loginGuard
+}
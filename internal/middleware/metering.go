@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/max/api-gateway/internal/metering"
+)
+
+// SetMeter sets the meter usage is reported to
+func (m *Manager) SetMeter(meter *metering.Meter) {
+	m.meter.Store(meter)
+}
+
+// Metering middleware reports each request's API key, tenant, route,
+// status and bytes to the meter for billing
+func (m *Manager) Metering() gin.HandlerFunc {
+	tenantClaim := m.config.Metering.TenantClaim
+
+	return func(c *gin.Context) {
+		c.Next()
+
+		meter := m.meter.Load()
+		if meter == nil {
+			return
+		}
+
+		sample := metering.Sample{
+			APIKey:        c.GetString(string(APIKeyIDKey)),
+			Status:        c.Writer.Status(),
+			RequestBytes:  c.Request.ContentLength,
+			ResponseBytes: int64(c.Writer.Size()),
+		}
+		if claims := m.RequestClaims(c); claims != nil && tenantClaim != "" {
+			sample.Tenant = claims.Value(tenantClaim)
+		}
+
+		sample.Service, sample.Route = requestRoute(c)
+
+		meter.Observe(sample)
+	}
+}
+
+// requestRoute returns the service named by the first path segment and the
+// route of the request. Gateway routes are reported by route template,
+// proxied requests by service so raw paths do not explode the number of
+// records.
+func requestRoute(c *gin.Context) (service, route string) {
+	service, _, _ = strings.Cut(strings.TrimPrefix(c.Request.URL.Path, "/"), "/")
+	route = c.FullPath()
+	if route == "" {
+		route = "/" + service + "/*"
+	}
+	return service, route
+}
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/auth"
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/internal/ratelimit"
+)
+
+func TestRateLimitKeyCannotNameRules(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{}
+	cfg.RateLimit = config.RateLimitConfig{
+		Enabled:   true,
+		Algorithm: "fixed_window",
+		Key:       "header.X-Tenant-ID",
+		Default:   config.RateLimitRule{Requests: 100, Window: time.Minute},
+		PerUser: map[string]config.RateLimitRule{
+			"alice": {Requests: 1, Window: time.Hour},
+		},
+		Offenders: config.OffendersConfig{Window: time.Hour, MaxKeys: 10},
+	}
+	limiter := ratelimit.NewManager(&cfg.RateLimit, nil, zap.NewNop())
+	router := gin.New()
+	router.Use(NewManager(cfg, nil, limiter, nil, nil, zap.NewNop()).RateLimit())
+	router.Any("/*path", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest("GET", "/orders", nil)
+		r.Header.Set("X-Tenant-ID", "user:alice")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d with tenant user:alice = %d, want 200 from the default rule", i, w.Code)
+		}
+	}
+
+	// alice's own rule still applies to her bucket
+	limiter.Check("user:alice")
+	if decision, _ := limiter.Check("user:alice"); decision.Allowed {
+		t.Error("second request in alice's bucket allowed")
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{}
+	cfg.Auth.API.Header = "X-API-Key"
+	jwtAuth := auth.NewJWTAuth("test-secret", time.Hour, 24*time.Hour, "gateway", "gateway", "HS256", zap.NewNop())
+	m := NewManager(cfg, jwtAuth, nil, nil, nil, zap.NewNop())
+	token, err := jwtAuth.GenerateToken("alice", "alice", "", nil, map[string]string{"tenant_id": "acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		token    bool
+		want     string
+		wantType string
+	}{
+		{name: "default anonymous", want: "192.0.2.1", wantType: "ip"},
+		{name: "default authenticated", token: true, want: "alice", wantType: "user"},
+		{name: "ip", template: "ip", want: "key:192.0.2.1"},
+		{name: "header", template: "header.X-Tenant-ID", want: "key:globex"},
+		{name: "api key", template: "api_key", want: "key:k-123"},
+		{name: "claim", template: "claim.tenant_id", token: true, want: "key:acme"},
+		{name: "path param", template: "param.tenant", want: "key:initech"},
+		{name: "tenant per endpoint", template: "{claim.tenant_id}:{method}:{path}", token: true,
+			want: "key:acme:GET:/tenants/:tenant/orders"},
+		{name: "missing claim falls back to ip", template: "{claim.tenant_id}:{path}",
+			want: "key:192.0.2.1:/tenants/:tenant/orders"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var key, keyType string
+			router := gin.New()
+			router.GET("/tenants/:tenant/orders", func(c *gin.Context) {
+				key = m.rateLimitKey(c, tt.template)
+				keyType = m.rateLimitKeyType(c, tt.template)
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/tenants/initech/orders", nil)
+			r.RemoteAddr = "192.0.2.1:40000"
+			r.Header.Set("X-Tenant-ID", "globex")
+			r.Header.Set("X-API-Key", "k-123")
+			if tt.token {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+			router.ServeHTTP(httptest.NewRecorder(), r)
+
+			if key != tt.want {
+				t.Errorf("rateLimitKey() = %q, want %q", key, tt.want)
+			}
+			wantType := tt.wantType
+			if wantType == "" {
+				wantType = tt.template
+			}
+			if keyType != wantType {
+				t.Errorf("rateLimitKeyType() = %q, want %q", keyType, wantType)
+			}
+		})
+	}
+}
+
+func TestRateLimitPerTenantPerEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{}
+	cfg.RateLimit = config.RateLimitConfig{
+		Enabled:   true,
+		Algorithm: "fixed_window",
+		Key:       "{header.X-Tenant-ID}:{path}",
+		Default:   config.RateLimitRule{Requests: 1, Window: time.Hour},
+		Offenders: config.OffendersConfig{Window: time.Hour, MaxKeys: 10},
+	}
+	limiter := ratelimit.NewManager(&cfg.RateLimit, nil, zap.NewNop())
+	router := gin.New()
+	router.Use(NewManager(cfg, nil, limiter, nil, nil, zap.NewNop()).RateLimit())
+	router.GET("/orders", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/users", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	request := func(tenant, path string) int {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("X-Tenant-ID", tenant)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := request("acme", "/orders"); code != http.StatusOK {
+		t.Fatalf("first acme request = %d, want 200", code)
+	}
+	if code := request("acme", "/orders"); code != http.StatusTooManyRequests {
+		t.Errorf("second acme request to /orders = %d, want 429", code)
+	}
+	if code := request("acme", "/users"); code != http.StatusOK {
+		t.Errorf("acme request to /users = %d, want 200 from its own bucket", code)
+	}
+	if code := request("globex", "/orders"); code != http.StatusOK {
+		t.Errorf("globex request to /orders = %d, want 200 from its own bucket", code)
+	}
+}
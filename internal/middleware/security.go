@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/max/api-gateway/internal/auth"
+)
+
+// Security event kinds
+const (
+	SecurityAuthFailure  = "auth_failure"
+	SecurityForbidden    = "forbidden"
+	SecurityWAFBlock     = "waf_block"
+	SecurityACLBlock     = "acl_block"
+	SecurityTokenRevoked = "token_revoked"
+)
+
+// SecurityEvent is a failed authentication, a refused authorization, a
+// request blocked by the WAF or an ACL, or a revoked credential. Reason is
+// a short machine-readable cause such as invalid_token.
+type SecurityEvent struct {
+	Timestamp time.Time
+	Kind      string
+	Reason    string
+	User      string // Authenticated or claimed user, if known
+	ClientIP  string
+	Method    string
+	Path      string
+	Status    int
+	UserAgent string
+	Details   map[string]string
+}
+
+// SecurityEventListener is notified of every security event. Listeners run
+// on the request path and must not block.
+type SecurityEventListener func(event SecurityEvent)
+
+// OnSecurityEvent registers a listener for security events
+func (m *Manager) OnSecurityEvent(listener SecurityEventListener) {
+	m.securityListenerMu.Lock()
+	defer m.securityListenerMu.Unlock()
+	m.securityListeners = append(m.securityListeners, listener)
+}
+
+// ReportSecurityEvent passes a security event about the current request to
+// the listeners. It is called after the response status is written. The
+// user is taken from the authenticated claims unless details names one.
+func (m *Manager) ReportSecurityEvent(c *gin.Context, kind, reason string, details map[string]string) {
+	event := SecurityEvent{
+		Timestamp: time.Now().UTC(),
+		Kind:      kind,
+		Reason:    reason,
+		ClientIP:  c.ClientIP(),
+		Method:    c.Request.Method,
+		Path:      c.Request.URL.Path,
+		Status:    c.Writer.Status(),
+		UserAgent: c.Request.UserAgent(),
+		Details:   details,
+	}
+	if user, ok := details["user"]; ok {
+		event.User = user
+		delete(details, "user")
+	} else if value, exists := c.Get("user"); exists {
+		if claims, ok := value.(*auth.Claims); ok {
+			event.User = claims.Username
+		}
+	}
+	m.notifySecurityEvent(event)
+}
+
+// notifySecurityEvent passes an event to the registered listeners
+func (m *Manager) notifySecurityEvent(event SecurityEvent) {
+	m.securityListenerMu.RLock()
+	listeners := make([]SecurityEventListener, len(m.securityListeners))
+	copy(listeners, m.securityListeners)
+	m.securityListenerMu.RUnlock()
+
+	for _, listener := range listeners {
+		listener(event)
+	}
+}
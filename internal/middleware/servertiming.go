@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/pkg/servertiming"
+)
+
+// serverTimingEnabled reports whether a request path gets the Server-Timing
+// header: the first matching route decides, then the gateway-wide setting
+func (m *Manager) serverTimingEnabled(path string) bool {
+	cfg := m.config.Server.ServerTiming
+	for _, route := range cfg.Routes {
+		if strings.HasPrefix(path, route.PathPrefix) {
+			return route.Enabled
+		}
+	}
+	return cfg.Enabled
+}
+
+// ServerTiming middleware times the phases of requests on enabled routes,
+// such as authentication and the upstream call, and sends them in a
+// Server-Timing header along with the total time spent until the response
+// headers
+func (m *Manager) ServerTiming() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !m.serverTimingEnabled(c.Request.URL.Path) || m.degraded(config.DegradeServerTiming) {
+			c.Next()
+			return
+		}
+
+		timings := servertiming.New(time.Now())
+		c.Request = c.Request.WithContext(servertiming.NewContext(c.Request.Context(), timings))
+		w := &serverTimingWriter{ResponseWriter: c.Writer, timings: timings}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+	}
+}
+
+// serverTimingWriter adds the Server-Timing header just before the
+// response headers are written
+type serverTimingWriter struct {
+	gin.ResponseWriter
+	timings *servertiming.Timings
+	done    bool
+}
+
+// setHeader adds the header once, unless the headers are already out
+func (w *serverTimingWriter) setHeader() {
+	if w.done || w.ResponseWriter.Written() {
+		return
+	}
+	w.done = true
+	w.Header().Set(servertiming.HeaderName, w.timings.Header())
+}
+
+func (w *serverTimingWriter) WriteHeader(code int) {
+	w.setHeader()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *serverTimingWriter) WriteHeaderNow() {
+	w.setHeader()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *serverTimingWriter) Write(data []byte) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *serverTimingWriter) WriteString(s string) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *serverTimingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/auth"
+	"github.com/max/api-gateway/internal/cache"
+	"github.com/max/api-gateway/pkg/servertiming"
+)
+
+// sessionCachePrefix namespaces session keys in Redis
+const sessionCachePrefix = "gateway"
+
+// Sessions returns the session store, creating it on first use. Sessions
+// live in Redis when available and in memory otherwise.
+func (m *Manager) Sessions() *auth.SessionStore {
+	m.sessionsOnce.Do(func() {
+		cfg := m.config.Auth.Session
+
+		var store cache.Cache
+		if m.redisClient != nil {
+			store = cache.NewRedisCache(m.redisClient, sessionCachePrefix, cfg.IdleTimeout, m.logger)
+		} else {
+			m.logger.Warn("Redis unavailable, sessions are kept in memory and not shared between instances")
+			store = cache.NewMemoryCache(0, cfg.IdleTimeout, m.logger)
+		}
+
+		m.sessions = auth.NewSessionStore(store, cfg.IdleTimeout, cfg.AbsoluteTimeout)
+	})
+	return m.sessions
+}
+
+// SessionAuth middleware authenticates requests carrying a session cookie,
+// as an alternative to the Authorization header. Requests without a valid
+// session continue unauthenticated.
+func (m *Manager) SessionAuth() gin.HandlerFunc {
+	cookieName := m.config.Auth.Session.CookieName
+
+	return func(c *gin.Context) {
+		id, err := c.Cookie(cookieName)
+		if err != nil || id == "" {
+			c.Next()
+			return
+		}
+
+		stop := servertiming.FromContext(c.Request.Context()).Start(servertiming.Auth)
+		session, err := m.Sessions().Get(c.Request.Context(), id)
+		stop()
+		if err != nil {
+			if !errors.Is(err, auth.ErrSessionNotFound) {
+				m.logger.Error("Failed to load session", zap.Error(err))
+			}
+			m.ClearSessionCookie(c)
+			c.Next()
+			return
+		}
+
+		c.Set("user", session.Claims)
+		c.Set(string(UserContextKey), session.Claims)
+		c.Set(string(SessionIDKey), session.ID)
+		c.Next()
+	}
+}
+
+// SetSessionCookie writes the cookie for a new session
+func (m *Manager) SetSessionCookie(c *gin.Context, session *auth.Session) {
+	cfg := m.config.Auth.Session
+	c.SetSameSite(sameSiteMode(cfg.SameSite))
+	c.SetCookie(cfg.CookieName, session.ID, int(m.Sessions().MaxAge().Seconds()), cfg.Path, cfg.Domain, cfg.Secure, true)
+}
+
+// ClearSessionCookie removes the session cookie from the client
+func (m *Manager) ClearSessionCookie(c *gin.Context) {
+	cfg := m.config.Auth.Session
+	c.SetSameSite(sameSiteMode(cfg.SameSite))
+	c.SetCookie(cfg.CookieName, "", -1, cfg.Path, cfg.Domain, cfg.Secure, true)
+}
+
+// sameSiteMode converts the configured SameSite value
+func sameSiteMode(value string) http.SameSite {
+	switch value {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/auth"
+	"github.com/max/api-gateway/internal/config"
+)
+
+func newSessionConfig() *config.Config {
+	cfg := &config.Config{}
+	cfg.Auth.Session = config.SessionConfig{
+		Enabled:         true,
+		CookieName:      "gw_session",
+		Domain:          "example.com",
+		Path:            "/",
+		Secure:          true,
+		SameSite:        "strict",
+		IdleTimeout:     time.Minute,
+		AbsoluteTimeout: time.Hour,
+	}
+	return cfg
+}
+
+func TestSetSessionCookieFlags(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := NewManager(newSessionConfig(), nil, nil, nil, nil, zap.NewNop())
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/auth/session", nil)
+	m.SetSessionCookie(c, &auth.Session{ID: "abc"})
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies, want 1", len(cookies))
+	}
+	cookie := cookies[0]
+	if cookie.Name != "gw_session" || cookie.Value != "abc" {
+		t.Errorf("cookie = %s=%s, want gw_session=abc", cookie.Name, cookie.Value)
+	}
+	if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteStrictMode {
+		t.Errorf("cookie HttpOnly=%v Secure=%v SameSite=%v, want HttpOnly, Secure and SameSite=Strict",
+			cookie.HttpOnly, cookie.Secure, cookie.SameSite)
+	}
+	if cookie.MaxAge != int(time.Hour.Seconds()) {
+		t.Errorf("cookie MaxAge = %d, want the absolute timeout", cookie.MaxAge)
+	}
+	if cookie.Domain != "example.com" || cookie.Path != "/" {
+		t.Errorf("cookie scope = %s%s, want example.com/", cookie.Domain, cookie.Path)
+	}
+}
+
+func TestSessionAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	backends := map[string]*redis.Client{"memory": nil, "redis": client}
+	for name, redisClient := range backends {
+		t.Run(name, func(t *testing.T) {
+			m := NewManager(newSessionConfig(), nil, nil, redisClient, nil, zap.NewNop())
+			router := gin.New()
+			router.Use(m.SessionAuth())
+			router.Any("/*path", func(c *gin.Context) {
+				c.String(http.StatusOK, c.GetString(string(SessionIDKey)))
+			})
+			send := func(id string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(http.MethodGet, "/me", nil)
+				r.AddCookie(&http.Cookie{Name: "gw_session", Value: id})
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, r)
+				return w
+			}
+
+			session, err := m.Sessions().Create(context.Background(), &auth.Claims{UserID: "1"})
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			if w := send(session.ID); w.Body.String() != session.ID {
+				t.Errorf("session id in context = %q, want %q", w.Body.String(), session.ID)
+			}
+
+			// Logging out deletes the session, after which the cookie is cleared
+			if err := m.Sessions().Delete(context.Background(), session.ID); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			w := send(session.ID)
+			if w.Body.String() != "" {
+				t.Errorf("deleted session still authenticates as %q", w.Body.String())
+			}
+			cookies := w.Result().Cookies()
+			if len(cookies) != 1 || cookies[0].Name != "gw_session" || cookies[0].MaxAge >= 0 {
+				t.Errorf("cookies = %v, want the session cookie cleared", cookies)
+			}
+		})
+	}
+}
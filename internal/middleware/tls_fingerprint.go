@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/pkg/tlsfingerprint"
+)
+
+// TLSFingerprintACL middleware rejects clients whose JA3 or JA4 fingerprint
+// is on the configured blocklists
+func (m *Manager) TLSFingerprintACL() gin.HandlerFunc {
+	cfg := m.config.Security.TLSFingerprint
+
+	blocked := make(map[string]bool, len(cfg.BlockedJA3)+len(cfg.BlockedJA4))
+	for _, fp := range cfg.BlockedJA3 {
+		blocked[fp] = true
+	}
+	for _, fp := range cfg.BlockedJA4 {
+		blocked[fp] = true
+	}
+
+	return func(c *gin.Context) {
+		fp, ok := tlsfingerprint.FromContext(c.Request.Context())
+		if !ok || (!blocked[fp.JA3] && !blocked[fp.JA4]) {
+			c.Next()
+			return
+		}
+
+		m.logger.Warn("Blocked TLS fingerprint",
+			zap.String("ja3", fp.JA3),
+			zap.String("ja4", fp.JA4),
+			zap.String("ip", c.ClientIP()),
+			zap.String("path", c.Request.URL.Path))
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "Request blocked"})
+		c.Abort()
+		m.ReportSecurityEvent(c, SecurityACLBlock, "tls_fingerprint",
+			map[string]string{"ja3": fp.JA3, "ja4": fp.JA4})
+	}
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/waf"
+)
+
+// WAF middleware screens requests against the WAF rules before they reach
+// authentication or the upstream. Matching block rules reject the request;
+// log rules are only recorded.
+func (m *Manager) WAF() gin.HandlerFunc {
+	engine, err := waf.NewEngine(m.config.Security.WAF)
+	if err != nil {
+		// Validation compiles the same rules, so this only happens for
+		// configs that bypassed it
+		m.logger.Error("Failed to create WAF engine, requests are not screened", zap.Error(err))
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	return func(c *gin.Context) {
+		var blockedBy []string
+		for _, match := range engine.Evaluate(c.Request) {
+			action := "logged"
+			if match.Action == waf.ActionBlock {
+				action = "blocked"
+				blockedBy = append(blockedBy, match.RuleID)
+			}
+
+			if m.metrics != nil {
+				m.metrics.RecordWAFMatch(match.RuleID, action)
+			}
+			m.logger.Warn("WAF rule matched",
+				zap.String("rule", match.RuleID),
+				zap.String("action", action),
+				zap.String("target", match.Target),
+				zap.String("method", c.Request.Method),
+				zap.String("path", c.Request.URL.Path),
+				zap.String("ip", c.ClientIP()))
+		}
+
+		if len(blockedBy) > 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Request blocked"})
+			c.Abort()
+			m.ReportSecurityEvent(c, SecurityWAFBlock, "waf_rule",
+				map[string]string{"rules": strings.Join(blockedBy, ",")})
+			return
+		}
+
+		c.Next()
+	}
+}
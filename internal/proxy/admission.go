@@ -0,0 +1,462 @@
+package proxy
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/pkg/metrics"
+)
+
+// Adaptive limit defaults
+const (
+	defaultAdaptiveInterval   = 5 * time.Second
+	defaultAdaptiveMinSamples = 20
+	defaultAdaptiveDecrease   = 0.5
+	defaultGradientTolerance  = 1.5
+	defaultGradientSmoothing  = 0.2
+	defaultGradientWindow     = 12
+	defaultVegasProbe         = time.Minute
+	// maxAdaptiveSamples bounds the latencies kept per interval
+	maxAdaptiveSamples = 10000
+)
+
+// ErrQueueFull is returned when a request arrives with the admission queue
+// already full
+var ErrQueueFull = errors.New("admission queue full")
+
+// ErrQueueTimeout is returned when a queued request waited too long for a
+// concurrency slot
+var ErrQueueTimeout = errors.New("admission queue timeout")
+
+// admission limits concurrent upstream requests, queueing the excess
+type admission struct {
+	service string
+	depth   int
+	timeout time.Duration
+	metrics *metrics.Manager
+	logger  *zap.Logger
+
+	mu       sync.Mutex
+	limit    int
+	scale    float64 // Multiplies the limit while the gateway is degraded
+	inFlight int
+	waiters  list.List // of chan struct{}, closed when granted a slot
+
+	adaptive *adaptiveLimit
+}
+
+// adaptiveLimit tracks upstream health over an interval to adjust the limit
+type adaptiveLimit struct {
+	cfg  config.AdaptiveLimitConfig
+	max  int
+	next time.Time
+
+	latencies    []time.Duration
+	latencySum   time.Duration
+	requests     int
+	errors       int
+	peakInFlight int
+
+	// estimate is the unrounded limit, so that small steps add up
+	estimate float64
+	// The last interval's latency, as judged by the algorithm, and error rate
+	latency   time.Duration
+	errorRate float64
+
+	// Gradient2 state
+	longLatency float64
+	gradient    float64
+
+	// Vegas state
+	noLoadLatency time.Duration
+	probeAt       time.Time
+	queueSize     float64
+}
+
+// newAdmission creates the admission queue of a service; it returns nil if
+// admission control is disabled
+func newAdmission(service string, cfg config.AdmissionConfig, metricsMgr *metrics.Manager, logger *zap.Logger) *admission {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	a := &admission{
+		service: service,
+		depth:   cfg.QueueDepth,
+		timeout: cfg.QueueTimeout,
+		metrics: metricsMgr,
+		logger:  logger,
+		limit:   cfg.MaxConcurrent,
+		scale:   1,
+	}
+
+	if cfg.Adaptive.Enabled {
+		adaptive := cfg.Adaptive
+		if adaptive.Algorithm == "" {
+			adaptive.Algorithm = config.AdaptiveAIMD
+		}
+		if adaptive.MinConcurrent <= 0 {
+			adaptive.MinConcurrent = 1
+		}
+		if adaptive.Interval <= 0 {
+			adaptive.Interval = defaultAdaptiveInterval
+		}
+		if adaptive.MinSamples <= 0 {
+			adaptive.MinSamples = defaultAdaptiveMinSamples
+		}
+		if adaptive.Increase <= 0 {
+			adaptive.Increase = 1
+		}
+		if adaptive.DecreaseFactor <= 0 {
+			adaptive.DecreaseFactor = defaultAdaptiveDecrease
+		}
+		if adaptive.Tolerance <= 0 {
+			adaptive.Tolerance = defaultGradientTolerance
+		}
+		if adaptive.Smoothing <= 0 {
+			adaptive.Smoothing = defaultGradientSmoothing
+		}
+		if adaptive.LongWindow <= 0 {
+			adaptive.LongWindow = defaultGradientWindow
+		}
+		if adaptive.ProbeInterval <= 0 {
+			adaptive.ProbeInterval = defaultVegasProbe
+		}
+		a.adaptive = &adaptiveLimit{
+			cfg:      adaptive,
+			max:      cfg.MaxConcurrent,
+			next:     time.Now().Add(adaptive.Interval),
+			estimate: float64(cfg.MaxConcurrent),
+			gradient: 1,
+		}
+	}
+
+	if metricsMgr != nil {
+		metricsMgr.SetAdmissionLimit(service, a.limit)
+	}
+	return a
+}
+
+// acquire takes a concurrency slot, waiting in the queue if none is free.
+// Waiters are served in arrival order.
+func (a *admission) acquire(ctx context.Context) error {
+	a.mu.Lock()
+	if a.inFlight < a.capacityLocked() && a.waiters.Len() == 0 {
+		a.inFlight++
+		a.trackPeakLocked()
+		a.reportLocked()
+		a.mu.Unlock()
+		return nil
+	}
+	if a.waiters.Len() >= a.depth {
+		a.reportLocked()
+		a.mu.Unlock()
+		a.shed("queue_full")
+		return ErrQueueFull
+	}
+	ready := make(chan struct{})
+	waiter := a.waiters.PushBack(ready)
+	a.reportLocked()
+	a.mu.Unlock()
+
+	start := time.Now()
+	timer := time.NewTimer(a.timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-ready:
+		if a.metrics != nil {
+			a.metrics.RecordAdmissionWait(a.service, time.Since(start))
+		}
+		return nil
+	case <-timer.C:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	a.mu.Lock()
+	select {
+	case <-ready:
+		// Granted a slot while giving up; hand it on
+		a.inFlight--
+		a.grantLocked()
+	default:
+		a.waiters.Remove(waiter)
+	}
+	a.reportLocked()
+	a.mu.Unlock()
+
+	if errors.Is(err, ErrQueueTimeout) {
+		a.shed("timeout")
+	}
+	return err
+}
+
+// release frees a concurrency slot
+func (a *admission) release() {
+	a.mu.Lock()
+	a.inFlight--
+	a.grantLocked()
+	a.reportLocked()
+	a.mu.Unlock()
+}
+
+// grantLocked hands free slots to the waiters in arrival order
+func (a *admission) grantLocked() {
+	for a.inFlight < a.capacityLocked() && a.waiters.Len() > 0 {
+		front := a.waiters.Front()
+		a.waiters.Remove(front)
+		a.inFlight++
+		close(front.Value.(chan struct{}))
+	}
+	a.trackPeakLocked()
+}
+
+// capacityLocked returns the limit as scaled by the degradation mode
+func (a *admission) capacityLocked() int {
+	return max(1, int(float64(a.limit)*a.scale))
+}
+
+// setScale scales the limit while the gateway is degraded; 1 restores it
+func (a *admission) setScale(scale float64) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if scale == a.scale {
+		return
+	}
+	a.scale = scale
+	a.grantLocked()
+	a.reportLocked()
+	if a.metrics != nil {
+		a.metrics.SetAdmissionLimit(a.service, a.capacityLocked())
+	}
+}
+
+// trackPeakLocked records the most requests in flight this interval, which
+// tells the adaptive limit whether the limit was actually reached
+func (a *admission) trackPeakLocked() {
+	if a.adaptive != nil && a.inFlight > a.adaptive.peakInFlight {
+		a.adaptive.peakInFlight = a.inFlight
+	}
+}
+
+// shed records a rejected request
+func (a *admission) shed(reason string) {
+	if a.metrics != nil {
+		a.metrics.RecordAdmissionShed(a.service, reason)
+	}
+}
+
+// reportLocked publishes the queue depth and in-flight requests
+func (a *admission) reportLocked() {
+	if a.metrics != nil {
+		a.metrics.SetAdmissionState(a.service, a.waiters.Len(), a.inFlight)
+	}
+}
+
+// state returns the queued and in-flight requests
+func (a *admission) state() (int, int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.waiters.Len(), a.inFlight
+}
+
+// stats returns the queue, the limit and what the adaptive limit last
+// measured, for tuning
+func (a *admission) stats() map[string]interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stats := map[string]interface{}{
+		"limit":     a.limit,
+		"scale":     a.scale,
+		"in_flight": a.inFlight,
+		"queued":    a.waiters.Len(),
+	}
+	ad := a.adaptive
+	if ad == nil {
+		return stats
+	}
+	stats["algorithm"] = ad.cfg.Algorithm
+	stats["estimate"] = ad.estimate
+	stats["error_rate"] = ad.errorRate
+	switch ad.cfg.Algorithm {
+	case config.AdaptiveGradient2:
+		stats["mean_latency"] = ad.latency.String()
+		stats["long_latency"] = time.Duration(ad.longLatency).String()
+		stats["gradient"] = ad.gradient
+	case config.AdaptiveVegas:
+		stats["min_latency"] = ad.latency.String()
+		stats["no_load_latency"] = ad.noLoadLatency.String()
+		stats["queue_size"] = ad.queueSize
+	default:
+		stats["p95_latency"] = ad.latency.String()
+	}
+	return stats
+}
+
+// observe feeds an upstream response to the adaptive limit. At the end of
+// each interval with enough requests the limit is cut if the error rate
+// crossed its threshold, and otherwise adjusted by the algorithm.
+func (a *admission) observe(status int, err error, duration time.Duration) {
+	if a == nil || a.adaptive == nil || status == StatusClientClosedRequest {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ad := a.adaptive
+	ad.requests++
+	if err != nil || status >= http.StatusInternalServerError {
+		ad.errors++
+	}
+	if len(ad.latencies) < maxAdaptiveSamples {
+		ad.latencies = append(ad.latencies, duration)
+	}
+	ad.latencySum += duration
+
+	now := time.Now()
+	if now.Before(ad.next) {
+		return
+	}
+	ad.next = now.Add(ad.cfg.Interval)
+	if ad.requests < ad.cfg.MinSamples {
+		return
+	}
+
+	sort.Slice(ad.latencies, func(i, j int) bool { return ad.latencies[i] < ad.latencies[j] })
+	p95 := ad.latencies[(len(ad.latencies)*95-1)/100]
+	minLatency := ad.latencies[0]
+	meanLatency := ad.latencySum / time.Duration(ad.requests)
+	ad.errorRate = float64(ad.errors) / float64(ad.requests)
+	peak := ad.peakInFlight
+	ad.latencies, ad.latencySum, ad.requests, ad.errors, ad.peakInFlight = ad.latencies[:0], 0, 0, 0, a.inFlight
+
+	estimate := ad.estimate
+	switch {
+	case ad.cfg.ErrorRateThreshold > 0 && ad.errorRate > ad.cfg.ErrorRateThreshold:
+		estimate = math.Floor(estimate * ad.cfg.DecreaseFactor)
+	case ad.cfg.Algorithm == config.AdaptiveGradient2:
+		ad.latency = meanLatency
+		estimate = ad.gradient2(estimate, meanLatency, peak)
+	case ad.cfg.Algorithm == config.AdaptiveVegas:
+		ad.latency = minLatency
+		estimate = ad.vegas(estimate, minLatency, peak, now)
+	default:
+		ad.latency = p95
+		if ad.cfg.LatencyThreshold > 0 && p95 > ad.cfg.LatencyThreshold {
+			estimate = math.Floor(estimate * ad.cfg.DecreaseFactor)
+		} else {
+			estimate += float64(ad.cfg.Increase)
+		}
+	}
+	ad.estimate = max(min(estimate, float64(ad.max)), float64(ad.cfg.MinConcurrent))
+
+	limit := int(ad.estimate)
+	if limit == a.limit {
+		return
+	}
+
+	a.logger.Info("Adaptive concurrency limit changed",
+		zap.String("service", a.service),
+		zap.String("algorithm", ad.cfg.Algorithm),
+		zap.Int("from", a.limit),
+		zap.Int("to", limit),
+		zap.Duration("latency", ad.latency),
+		zap.Float64("error_rate", ad.errorRate))
+	a.limit = limit
+	a.grantLocked()
+	a.reportLocked()
+	if a.metrics != nil {
+		a.metrics.SetAdmissionLimit(a.service, a.capacityLocked())
+	}
+}
+
+// gradient2 scales the limit by the ratio of the long-term to the recent
+// mean latency, which falls below one as requests queue upstream, and adds
+// the square root of the limit as headroom to keep probing upwards
+func (ad *adaptiveLimit) gradient2(limit float64, latency time.Duration, peak int) float64 {
+	short := float64(latency)
+	if short <= 0 {
+		return limit
+	}
+	if ad.longLatency == 0 {
+		ad.longLatency = short
+	} else {
+		ad.longLatency += (short - ad.longLatency) / float64(ad.cfg.LongWindow)
+	}
+	// Let the average catch up quickly when latency drops for good
+	if ad.longLatency/short > 2 {
+		ad.longLatency *= 0.95
+	}
+	ad.gradient = max(0.5, min(1, ad.cfg.Tolerance*ad.longLatency/short))
+
+	// An interval that never came close to the limit says nothing about it
+	if float64(peak) < limit/2 {
+		return limit
+	}
+	next := limit*ad.gradient + math.Sqrt(limit)
+	return limit*(1-ad.cfg.Smoothing) + next*ad.cfg.Smoothing
+}
+
+// vegas estimates the requests queued upstream from how far the interval's
+// minimum latency exceeds the lowest latency seen. The limit grows quickly
+// while the queue is short, slowly while it is moderate, and shrinks once
+// it is long.
+func (ad *adaptiveLimit) vegas(limit float64, latency time.Duration, peak int, now time.Time) float64 {
+	if !now.Before(ad.probeAt) {
+		// Measure the baseline afresh in case the upstream changed for good
+		ad.noLoadLatency, ad.probeAt = 0, now.Add(ad.cfg.ProbeInterval)
+	}
+	if ad.noLoadLatency == 0 || latency < ad.noLoadLatency {
+		ad.noLoadLatency = latency
+	}
+	if latency <= 0 {
+		return limit
+	}
+	ad.queueSize = math.Ceil(limit * (1 - float64(ad.noLoadLatency)/float64(latency)))
+
+	if float64(peak) < limit/2 {
+		return limit
+	}
+	step := max(1, math.Log10(limit))
+	switch {
+	case ad.queueSize <= step:
+		return limit + 6*step
+	case ad.queueSize < 3*step:
+		return limit + step
+	case ad.queueSize > 6*step:
+		return limit - step
+	}
+	return limit
+}
+
+// Admit waits for the service's admission queue to let the request through.
+// The returned function must be called once the upstream call is done. It
+// fails with ErrQueueFull or ErrQueueTimeout when the request is shed, or
+// the context's error if the client gives up first.
+func (rp *ReverseProxy) Admit(ctx context.Context) (func(), error) {
+	if rp.admission == nil {
+		return func() {}, nil
+	}
+	if err := rp.admission.acquire(ctx); err != nil {
+		return nil, err
+	}
+	return rp.admission.release, nil
+}
@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestAdmissionQueue(t *testing.T) {
+	a := newAdmission("orders", config.AdmissionConfig{
+		Enabled:       true,
+		MaxConcurrent: 1,
+		QueueDepth:    1,
+		QueueTimeout:  time.Second,
+	}, nil, zap.NewNop())
+	ctx := context.Background()
+
+	if err := a.acquire(ctx); err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+
+	// The second request waits for the slot, the third overflows the queue
+	admitted := make(chan error, 1)
+	go func() { admitted <- a.acquire(ctx) }()
+	for queued, _ := a.state(); queued != 1; queued, _ = a.state() {
+		time.Sleep(time.Millisecond)
+	}
+	if err := a.acquire(ctx); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("acquire() with a full queue = %v, want ErrQueueFull", err)
+	}
+
+	a.release()
+	if err := <-admitted; err != nil {
+		t.Fatalf("queued acquire() error = %v", err)
+	}
+
+	// A queued request gives up after the queue timeout
+	a.timeout = 10 * time.Millisecond
+	if err := a.acquire(ctx); !errors.Is(err, ErrQueueTimeout) {
+		t.Errorf("acquire() past the queue timeout = %v, want ErrQueueTimeout", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	a.timeout = time.Second
+	if err := a.acquire(canceled); !errors.Is(err, context.Canceled) {
+		t.Errorf("acquire() with a canceled context = %v, want context.Canceled", err)
+	}
+	if queued, inFlight := a.state(); queued != 0 || inFlight != 1 {
+		t.Errorf("queued = %d, in flight = %d, want 0 and 1", queued, inFlight)
+	}
+}
+
+func TestAdaptiveLimit(t *testing.T) {
+	a := newAdmission("orders", config.AdmissionConfig{
+		Enabled:       true,
+		MaxConcurrent: 8,
+		QueueDepth:    1,
+		QueueTimeout:  time.Second,
+		Adaptive: config.AdaptiveLimitConfig{
+			Enabled:          true,
+			MinConcurrent:    2,
+			MinSamples:       10,
+			LatencyThreshold: 100 * time.Millisecond,
+		},
+	}, nil, zap.NewNop())
+
+	// feed ends an interval with the given upstream latency
+	feed := func(latency time.Duration) int {
+		for i := 0; i < 10; i++ {
+			a.observe(http.StatusOK, nil, latency)
+		}
+		a.adaptive.next = time.Time{}
+		a.observe(http.StatusOK, nil, latency)
+		return a.limit
+	}
+
+	if limit := feed(10 * time.Millisecond); limit != 8 {
+		t.Errorf("limit of a healthy upstream = %d, want the maximum 8", limit)
+	}
+	if limit := feed(time.Second); limit != 4 {
+		t.Errorf("limit after slow responses = %d, want 4", limit)
+	}
+	feed(time.Second)
+	if limit := feed(time.Second); limit != 2 {
+		t.Errorf("limit after repeated slow responses = %d, want the minimum 2", limit)
+	}
+	if limit := feed(10 * time.Millisecond); limit != 3 {
+		t.Errorf("limit after recovery = %d, want 3", limit)
+	}
+
+	// Errors cut the limit when an error rate threshold is set
+	a.adaptive.cfg.ErrorRateThreshold = 0.1
+	for i := 0; i < 10; i++ {
+		a.observe(http.StatusBadGateway, errors.New("connection refused"), time.Millisecond)
+	}
+	a.adaptive.next = time.Time{}
+	a.observe(http.StatusOK, nil, time.Millisecond)
+	if a.limit != 2 {
+		t.Errorf("limit after upstream errors = %d, want 2", a.limit)
+	}
+}
+
+func TestLatencyProbingLimits(t *testing.T) {
+	newLimit := func(algorithm string) (*admission, func(latency time.Duration, peak int) int) {
+		a := newAdmission("orders", config.AdmissionConfig{
+			Enabled:       true,
+			MaxConcurrent: 100,
+			Adaptive: config.AdaptiveLimitConfig{
+				Enabled:    true,
+				Algorithm:  algorithm,
+				MinSamples: 10,
+			},
+		}, nil, zap.NewNop())
+
+		// feed ends an interval with the given latency and peak in flight
+		feed := func(latency time.Duration, peak int) int {
+			for i := 0; i < 10; i++ {
+				a.observe(http.StatusOK, nil, latency)
+			}
+			a.adaptive.peakInFlight = peak
+			a.adaptive.next = time.Time{}
+			a.observe(http.StatusOK, nil, latency)
+			return a.limit
+		}
+		return a, feed
+	}
+
+	t.Run("gradient2", func(t *testing.T) {
+		a, feed := newLimit(config.AdaptiveGradient2)
+		if limit := feed(10*time.Millisecond, 100); limit != 100 {
+			t.Fatalf("limit with flat latency = %d, want the maximum 100", limit)
+		}
+		queued := feed(40*time.Millisecond, 100)
+		if queued >= 100 {
+			t.Fatalf("limit after latency grew = %d, want below 100", queued)
+		}
+		if limit := feed(40*time.Millisecond, queued); limit >= queued {
+			t.Errorf("limit after sustained queueing = %d, want below %d", limit, queued)
+		}
+		if limit := feed(40*time.Millisecond, 1); limit != a.limit {
+			t.Errorf("limit changed to %d by an interval far below it", limit)
+		}
+		before := a.limit
+		if limit := feed(10*time.Millisecond, before); limit <= before {
+			t.Errorf("limit after latency recovered = %d, want above %d", limit, before)
+		}
+	})
+
+	t.Run("vegas", func(t *testing.T) {
+		a, feed := newLimit(config.AdaptiveVegas)
+		if limit := feed(10*time.Millisecond, 100); limit != 100 {
+			t.Fatalf("limit without queueing = %d, want the maximum 100", limit)
+		}
+		if limit := feed(20*time.Millisecond, 100); limit != 98 {
+			t.Errorf("limit with half the requests queued = %d, want 98", limit)
+		}
+		if a.adaptive.queueSize != 50 {
+			t.Errorf("queue size = %v, want 50", a.adaptive.queueSize)
+		}
+		if limit := feed(10*time.Millisecond, 98); limit != 100 {
+			t.Errorf("limit after the queue drained = %d, want 100", limit)
+		}
+	})
+}
+
+func TestAdmissionScale(t *testing.T) {
+	a := newAdmission("orders", config.AdmissionConfig{
+		Enabled:       true,
+		MaxConcurrent: 4,
+		QueueDepth:    0,
+	}, nil, zap.NewNop())
+
+	a.setScale(0.5)
+	for i := 0; i < 2; i++ {
+		if err := a.acquire(context.Background()); err != nil {
+			t.Fatalf("acquire %d under the scaled limit: %v", i, err)
+		}
+	}
+	if err := a.acquire(context.Background()); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("acquire over the scaled limit = %v, want ErrQueueFull", err)
+	}
+
+	a.setScale(1)
+	if err := a.acquire(context.Background()); err != nil {
+		t.Errorf("acquire after restoring the limit: %v", err)
+	}
+}
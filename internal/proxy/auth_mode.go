@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// authPolicy resolves the authentication mode of a request from the route
+// and service settings
+type authPolicy struct {
+	mode   string
+	routes []routeAuth
+}
+
+// routeAuth is a compiled route authentication override
+type routeAuth struct {
+	pathPrefix string
+	methods    map[string]bool
+	mode       string
+}
+
+// newAuthPolicy compiles the authentication modes of a service
+func newAuthPolicy(cfg *config.ServiceConfig) authPolicy {
+	policy := authPolicy{mode: cfg.AuthMode()}
+	for _, route := range cfg.RouteAuth {
+		compiled := routeAuth{pathPrefix: route.PathPrefix, mode: route.Mode}
+		if len(route.Methods) > 0 {
+			compiled.methods = make(map[string]bool, len(route.Methods))
+			for _, method := range route.Methods {
+				compiled.methods[strings.ToUpper(method)] = true
+			}
+		}
+		policy.routes = append(policy.routes, compiled)
+	}
+	return policy
+}
+
+// AuthMode returns the authentication mode of a request: the first matching
+// route override, or the service mode
+func (rp *ReverseProxy) AuthMode(r *http.Request) string {
+	for _, route := range rp.auth.routes {
+		if !strings.HasPrefix(r.URL.Path, route.pathPrefix) {
+			continue
+		}
+		if route.methods != nil && !route.methods[r.Method] {
+			continue
+		}
+		return route.mode
+	}
+	return rp.auth.mode
+}
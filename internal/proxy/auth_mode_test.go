@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestAuthMode(t *testing.T) {
+	rp := &ReverseProxy{auth: newAuthPolicy(&config.ServiceConfig{
+		Audiences: []string{"catalog-api"},
+		RouteAuth: []config.RouteAuthConfig{
+			{PathPrefix: "/catalog/products", Methods: []string{"get"}, Mode: config.AuthOptional},
+			{PathPrefix: "/catalog/health", Mode: config.AuthNone},
+		},
+	})}
+
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{method: http.MethodGet, path: "/catalog/products/1", want: config.AuthOptional},
+		{method: http.MethodPost, path: "/catalog/products", want: config.AuthRequired},
+		{method: http.MethodGet, path: "/catalog/health", want: config.AuthNone},
+		{method: http.MethodGet, path: "/catalog/orders", want: config.AuthRequired},
+	}
+	for _, tt := range tests {
+		if got := rp.AuthMode(httptest.NewRequest(tt.method, tt.path, nil)); got != tt.want {
+			t.Errorf("%s %s: mode = %q, want %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+
+	if got := (&ReverseProxy{auth: newAuthPolicy(&config.ServiceConfig{})}).AuthMode(httptest.NewRequest(http.MethodGet, "/", nil)); got != config.AuthNone {
+		t.Errorf("default mode = %q, want %q", got, config.AuthNone)
+	}
+}
@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func BenchmarkForward(b *testing.B) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer upstream.Close()
+
+	rp, err := NewReverseProxy("orders", &config.ServiceConfig{URLs: []string{upstream.URL}}, "", nil, zap.NewNop())
+	if err != nil {
+		b.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/orders/1", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if code, err := rp.Forward(httptest.NewRecorder(), req); err != nil || code != http.StatusOK {
+			b.Fatalf("status %d, err %v", code, err)
+		}
+	}
+}
+
+func BenchmarkGatewayTime(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		gatewayTime()
+	}
+}
@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// cacheKeyRule builds the cache keys of a set of requests
+type cacheKeyRule struct {
+	disabled bool
+	headers  []string        // Canonical names
+	cookies  []string        // Sorted
+	query    map[string]bool // nil keeps every parameter
+}
+
+// newCacheKeyRule compiles a cache key configuration
+func newCacheKeyRule(cfg config.CacheKeyConfig) cacheKeyRule {
+	rule := cacheKeyRule{cookies: append([]string(nil), cfg.Cookies...)}
+	for _, name := range cfg.Headers {
+		rule.headers = append(rule.headers, http.CanonicalHeaderKey(name))
+	}
+	sort.Strings(rule.headers)
+	sort.Strings(rule.cookies)
+	if len(cfg.QueryParams) > 0 {
+		rule.query = make(map[string]bool, len(cfg.QueryParams))
+		for _, name := range cfg.QueryParams {
+			rule.query[name] = true
+		}
+	}
+	return rule
+}
+
+// covers reports whether header is part of the key
+func (rule *cacheKeyRule) covers(header string) bool {
+	for _, name := range rule.headers {
+		if name == header {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the cache key of r, and false when r must not be cached
+// because it carries credentials the key does not cover
+func (rule *cacheKeyRule) key(r *http.Request) (string, bool) {
+	if rule.disabled {
+		return "", false
+	}
+	if r.Header.Get("Authorization") != "" && !rule.covers("Authorization") {
+		return "", false
+	}
+	if r.Header.Get("Cookie") != "" && len(rule.cookies) == 0 && !rule.covers("Cookie") {
+		return "", false
+	}
+
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteByte(' ')
+	b.WriteString(r.URL.EscapedPath())
+	if query := rule.filterQuery(r.URL); query != "" {
+		b.WriteByte('?')
+		b.WriteString(query)
+	}
+
+	for _, name := range rule.headers {
+		value := strings.Join(r.Header.Values(name), ",")
+		if (name == "Authorization" || name == "Cookie") && value != "" {
+			value = hashValue(value)
+		}
+		b.WriteString("\n")
+		b.WriteString(name)
+		b.WriteString(": ")
+		b.WriteString(value)
+	}
+	for _, name := range rule.cookies {
+		value := ""
+		if cookie, err := r.Cookie(name); err == nil {
+			value = hashValue(cookie.Value)
+		}
+		b.WriteString("\ncookie ")
+		b.WriteString(name)
+		b.WriteString(": ")
+		b.WriteString(value)
+	}
+	return b.String(), true
+}
+
+// filterQuery returns the query string of the key: the raw query when every
+// parameter is kept, else the allowed parameters in a stable order
+func (rule *cacheKeyRule) filterQuery(u *url.URL) string {
+	if rule.query == nil {
+		return u.RawQuery
+	}
+	values := u.Query()
+	for name := range values {
+		if !rule.query[name] {
+			delete(values, name)
+		}
+	}
+	return values.Encode()
+}
+
+// cacheable reports whether an upstream response may be shared by the
+// requests of the same key. Responses setting cookies, marked private or
+// no-store, or varying on headers outside the key are never cached.
+func (rule *cacheKeyRule) cacheable(resp *http.Response) bool {
+	if len(resp.Header.Values("Set-Cookie")) > 0 {
+		return false
+	}
+	for _, directive := range strings.Split(strings.Join(resp.Header.Values("Cache-Control"), ","), ",") {
+		directive, _, _ = strings.Cut(strings.TrimSpace(directive), "=")
+		if strings.EqualFold(directive, "private") || strings.EqualFold(directive, "no-store") {
+			return false
+		}
+	}
+	for _, vary := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(vary, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			// An unencoded body suits every client
+			if name == "" || name == "Accept-Encoding" && resp.Header.Get("Content-Encoding") == "" {
+				continue
+			}
+			if name == "*" || !rule.covers(name) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// hashValue keeps credentials out of the cache keys
+func hashValue(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheKeyPolicy holds the cache key rules of a service
+type cacheKeyPolicy struct {
+	base   cacheKeyRule
+	routes []cacheKeyRoute
+}
+
+// cacheKeyRoute is the rule of the requests under a path prefix
+type cacheKeyRoute struct {
+	prefix string
+	rule   cacheKeyRule
+}
+
+// newCacheKeyPolicy compiles the cache key settings of a fallback
+func newCacheKeyPolicy(cfg config.FallbackConfig) cacheKeyPolicy {
+	policy := cacheKeyPolicy{base: newCacheKeyRule(cfg.CacheKey)}
+	for _, route := range cfg.CacheRoutes {
+		rule := newCacheKeyRule(route.Key)
+		rule.disabled = route.Disabled
+		policy.routes = append(policy.routes, cacheKeyRoute{prefix: route.PathPrefix, rule: rule})
+	}
+	return policy
+}
+
+// rule returns the rule of a request path
+func (p *cacheKeyPolicy) rule(path string) *cacheKeyRule {
+	for i := range p.routes {
+		if strings.HasPrefix(path, p.routes[i].prefix) {
+			return &p.routes[i].rule
+		}
+	}
+	return &p.base
+}
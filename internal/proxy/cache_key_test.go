@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestCacheKeyRule(t *testing.T) {
+	policy := newCacheKeyPolicy(config.FallbackConfig{
+		CacheKey: config.CacheKeyConfig{Headers: []string{"accept-language"}, QueryParams: []string{"page"}},
+		CacheRoutes: []config.CacheRouteConfig{
+			{PathPrefix: "/orders/me", Key: config.CacheKeyConfig{Headers: []string{"Authorization"}}},
+			{PathPrefix: "/orders/cart", Key: config.CacheKeyConfig{Cookies: []string{"session"}}},
+			{PathPrefix: "/orders/admin", Disabled: true},
+		},
+	})
+	key := func(target string, headers map[string]string) (string, bool) {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		return policy.rule(r.URL.Path).key(r)
+	}
+
+	// Parameters outside the allowlist do not split the cache
+	a, _ := key("/orders/list?page=2&utm=x", map[string]string{"Accept-Language": "de"})
+	b, _ := key("/orders/list?page=2", map[string]string{"Accept-Language": "de"})
+	c, _ := key("/orders/list?page=2", map[string]string{"Accept-Language": "fr"})
+	if a != b || a == c {
+		t.Errorf("keys by query and language: %q, %q, %q", a, b, c)
+	}
+
+	// Credentials the key does not cover are never cached
+	if _, ok := key("/orders/list", map[string]string{"Authorization": "Bearer alice"}); ok {
+		t.Error("request with uncovered Authorization was cacheable")
+	}
+	if _, ok := key("/orders/list", map[string]string{"Cookie": "session=alice"}); ok {
+		t.Error("request with uncovered cookies was cacheable")
+	}
+	if _, ok := key("/orders/admin/users", nil); ok {
+		t.Error("request on a disabled route was cacheable")
+	}
+
+	// Covered credentials separate users and stay out of the key
+	alice, ok := key("/orders/me", map[string]string{"Authorization": "Bearer alice"})
+	bob, _ := key("/orders/me", map[string]string{"Authorization": "Bearer bob"})
+	if !ok || alice == bob {
+		t.Errorf("per-user keys = %q, %q", alice, bob)
+	}
+	cartAlice, _ := key("/orders/cart", map[string]string{"Cookie": "session=alice; theme=dark"})
+	cartBob, _ := key("/orders/cart", map[string]string{"Cookie": "session=bob; theme=dark"})
+	if cartAlice == cartBob {
+		t.Error("session cookie did not split the cache")
+	}
+	for _, k := range []string{alice, cartAlice} {
+		if strings.Contains(k, "alice") {
+			t.Errorf("key %q exposes a credential", k)
+		}
+	}
+}
+
+func TestCacheKeyRuleCacheable(t *testing.T) {
+	rule := newCacheKeyRule(config.CacheKeyConfig{Headers: []string{"Accept-Language"}})
+	tests := []struct {
+		name   string
+		header http.Header
+		want   bool
+	}{
+		{"plain", http.Header{}, true},
+		{"set-cookie", http.Header{"Set-Cookie": {"session=1"}}, false},
+		{"private", http.Header{"Cache-Control": {"max-age=60, private"}}, false},
+		{"no-store", http.Header{"Cache-Control": {"no-store"}}, false},
+		{"vary covered", http.Header{"Vary": {"Accept-Language, Accept-Encoding"}}, true},
+		{"vary encoded", http.Header{"Vary": {"Accept-Encoding"}, "Content-Encoding": {"gzip"}}, false},
+		{"vary uncovered", http.Header{"Vary": {"X-Tenant"}}, false},
+		{"vary any", http.Header{"Vary": {"*"}}, false},
+	}
+	for _, tt := range tests {
+		if got := rule.cacheable(&http.Response{Header: tt.header}); got != tt.want {
+			t.Errorf("%s: cacheable() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// PreviewService returns the service dark-launched for the request, or ""
+// if the request is for the stable service. A request is a preview request
+// when it carries the configured header or claim. claim looks up a claim of
+// the authenticated user and is nil for anonymous requests.
+func (rp *ReverseProxy) PreviewService(r *http.Request, claim func(name string) string) string {
+	cfg := rp.darkLaunch
+	if !cfg.Enabled {
+		return ""
+	}
+
+	if cfg.Header != "" {
+		value := r.Header.Get(cfg.Header)
+		if value != "" && (cfg.Value == "" || subtle.ConstantTimeCompare([]byte(value), []byte(cfg.Value)) == 1) {
+			return cfg.Service
+		}
+	}
+
+	if cfg.Claim != "" && claim != nil {
+		value := claim(cfg.Claim)
+		if value == "" {
+			return ""
+		}
+		if cfg.ClaimValue == "" {
+			return cfg.Service
+		}
+		// List claims such as roles are comma-separated
+		for _, element := range strings.Split(value, ",") {
+			if strings.TrimSpace(element) == cfg.ClaimValue {
+				return cfg.Service
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestPreviewService(t *testing.T) {
+	rp := &ReverseProxy{darkLaunch: config.DarkLaunchConfig{
+		Enabled:    true,
+		Service:    "orders_preview",
+		Header:     "X-Internal-Preview",
+		Value:      "s3cret",
+		Claim:      "roles",
+		ClaimValue: "preview",
+	}}
+	claims := func(roles string) func(string) string {
+		return func(name string) string {
+			if name == "roles" {
+				return roles
+			}
+			return ""
+		}
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		claim  func(string) string
+		want   string
+	}{
+		{"no header or claim", "", nil, ""},
+		{"matching header", "s3cret", nil, "orders_preview"},
+		{"wrong header value", "guess", nil, ""},
+		{"claim list element", "", claims("user,preview"), "orders_preview"},
+		{"other roles", "", claims("user,admin"), ""},
+		{"empty claim", "", claims(""), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/orders/items", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Internal-Preview", tt.header)
+			}
+			if got := rp.PreviewService(r, tt.claim); got != tt.want {
+				t.Errorf("PreviewService() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Any claim value is accepted when no value is configured
+	rp.darkLaunch.ClaimValue = ""
+	if got := rp.PreviewService(httptest.NewRequest(http.MethodGet, "/orders", nil), claims("")); got != "" {
+		t.Errorf("PreviewService() with an empty claim = %q, want stable", got)
+	}
+	if got := rp.PreviewService(httptest.NewRequest(http.MethodGet, "/orders", nil), claims("user")); got != "orders_preview" {
+		t.Errorf("PreviewService() with any claim value = %q, want orders_preview", got)
+	}
+}
@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+const (
+	// RequestTimeoutHeader carries the remaining time budget in milliseconds.
+	// Clients may send it to tighten the budget; upstreams receive the
+	// budget left after gateway processing.
+	RequestTimeoutHeader = "X-Request-Timeout-Ms"
+	// grpcTimeoutHeader carries the remaining budget for gRPC upstreams
+	grpcTimeoutHeader = "grpc-timeout"
+)
+
+type contextKey string
+
+const startTimeContextKey contextKey = "proxy_start_time"
+
+// WithStartTime records when the gateway received the request so the
+// upstream deadline accounts for time already spent in the gateway
+func WithStartTime(ctx context.Context, start time.Time) context.Context {
+	return context.WithValue(ctx, startTimeContextKey, start)
+}
+
+// requestStartTime returns the recorded start time, or now if none was set
+func requestStartTime(ctx context.Context) time.Time {
+	if start, ok := ctx.Value(startTimeContextKey).(time.Time); ok && !start.IsZero() {
+		return start
+	}
+	return time.Now()
+}
+
+// timeoutPolicy resolves the timeout of a request from the route, service
+// and gateway-wide settings
+type timeoutPolicy struct {
+	service   time.Duration
+	routes    []routeTimeout
+	maxClient time.Duration
+}
+
+// routeTimeout is a compiled route timeout override
+type routeTimeout struct {
+	pathPrefix string
+	methods    map[string]bool
+	timeout    time.Duration
+}
+
+// newTimeoutPolicy compiles the timeouts of a service
+func newTimeoutPolicy(cfg *config.ServiceConfig) timeoutPolicy {
+	policy := timeoutPolicy{service: cfg.Timeout}
+	for _, route := range cfg.RouteTimeouts {
+		compiled := routeTimeout{pathPrefix: route.PathPrefix, timeout: route.Timeout}
+		if len(route.Methods) > 0 {
+			compiled.methods = make(map[string]bool, len(route.Methods))
+			for _, method := range route.Methods {
+				compiled.methods[strings.ToUpper(method)] = true
+			}
+		}
+		policy.routes = append(policy.routes, compiled)
+	}
+	return policy
+}
+
+// applyDefaults applies the gateway-wide timeout settings
+func (p *timeoutPolicy) applyDefaults(cfg config.TimeoutsConfig) {
+	if p.service == 0 {
+		p.service = cfg.Default
+	}
+	p.maxClient = cfg.MaxClient
+}
+
+// timeout returns the configured timeout of a request: the first matching
+// route timeout, or the service timeout. 0 means none.
+func (p timeoutPolicy) timeout(r *http.Request) time.Duration {
+	for _, route := range p.routes {
+		if !strings.HasPrefix(r.URL.Path, route.pathPrefix) {
+			continue
+		}
+		if route.methods != nil && !route.methods[r.Method] {
+			continue
+		}
+		return route.timeout
+	}
+	return p.service
+}
+
+// requestDeadline returns the deadline for the upstream call: the request's
+// timeout measured from when the gateway received the request. A budget sent
+// by the client replaces the timeout, capped by maxClient, or only tightens
+// it when clients may not extend timeouts. It returns false if no deadline
+// applies.
+func requestDeadline(r *http.Request, policy timeoutPolicy) (time.Time, bool) {
+	start := requestStartTime(r.Context())
+	timeout := policy.timeout(r)
+
+	if ms, err := strconv.ParseInt(r.Header.Get(RequestTimeoutHeader), 10, 64); err == nil && ms > 0 {
+		client := time.Duration(ms) * time.Millisecond
+		switch {
+		case policy.maxClient > 0:
+			timeout = min(client, policy.maxClient)
+		case timeout == 0 || client < timeout:
+			timeout = client
+		}
+	}
+
+	if timeout <= 0 {
+		return time.Time{}, false
+	}
+	return start.Add(timeout), true
+}
+
+// setTimeoutHeaders advertises the remaining budget of the request context
+// to the upstream. Without a deadline, budgets the client sent are removed.
+func setTimeoutHeaders(req *http.Request) {
+	deadline, ok := req.Context().Deadline()
+	if !ok {
+		req.Header.Del(RequestTimeoutHeader)
+		req.Header.Del(grpcTimeoutHeader)
+		return
+	}
+
+	remaining := time.Until(deadline).Milliseconds()
+	if remaining < 1 {
+		remaining = 1
+	}
+
+	req.Header.Set(RequestTimeoutHeader, strconv.FormatInt(remaining, 10))
+	req.Header.Set(grpcTimeoutHeader, strconv.FormatInt(remaining, 10)+"m")
+}
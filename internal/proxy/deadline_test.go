@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestRequestDeadline(t *testing.T) {
+	policy := newTimeoutPolicy(&config.ServiceConfig{
+		RouteTimeouts: []config.RouteTimeoutConfig{
+			{PathPrefix: "/reports/export", Methods: []string{"post"}, Timeout: 2 * time.Minute},
+		},
+	})
+	policy.applyDefaults(config.TimeoutsConfig{Default: 10 * time.Second})
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		budget    string
+		maxClient time.Duration
+		want      time.Duration
+	}{
+		{name: "gateway default", method: http.MethodGet, path: "/reports/list", want: 10 * time.Second},
+		{name: "route override", method: http.MethodPost, path: "/reports/export", want: 2 * time.Minute},
+		{name: "route method mismatch", method: http.MethodGet, path: "/reports/export", want: 10 * time.Second},
+		{name: "client shortens", method: http.MethodGet, path: "/reports/list", budget: "500", want: 500 * time.Millisecond},
+		{name: "client cannot extend", method: http.MethodGet, path: "/reports/list", budget: "60000", want: 10 * time.Second},
+		{name: "client extends up to max", method: http.MethodGet, path: "/reports/list", budget: "20000", maxClient: 30 * time.Second, want: 20 * time.Second},
+		{name: "client capped by max", method: http.MethodGet, path: "/reports/list", budget: "60000", maxClient: 30 * time.Second, want: 30 * time.Second},
+	}
+
+	start := time.Now()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			r = r.WithContext(WithStartTime(r.Context(), start))
+			if tt.budget != "" {
+				r.Header.Set(RequestTimeoutHeader, tt.budget)
+			}
+
+			p := policy
+			p.maxClient = tt.maxClient
+			deadline, ok := requestDeadline(r, p)
+			if !ok || deadline.Sub(start) != tt.want {
+				t.Errorf("timeout = %v (%v), want %v", deadline.Sub(start), ok, tt.want)
+			}
+		})
+	}
+
+	if _, ok := requestDeadline(httptest.NewRequest(http.MethodGet, "/reports", nil), timeoutPolicy{}); ok {
+		t.Error("deadline set without any timeout")
+	}
+}
+
+func TestSetTimeoutHeadersWithoutDeadline(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/orders.Orders/Get", nil)
+	r.Header.Set(RequestTimeoutHeader, "60000")
+	r.Header.Set(grpcTimeoutHeader, "1H")
+
+	setTimeoutHeaders(r)
+	if got := r.Header.Get(RequestTimeoutHeader); got != "" {
+		t.Errorf("%s = %q, want it removed", RequestTimeoutHeader, got)
+	}
+	if got := r.Header.Get(grpcTimeoutHeader); got != "" {
+		t.Errorf("%s = %q, want it removed", grpcTimeoutHeader, got)
+	}
+}
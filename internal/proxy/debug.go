@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	pinnedTargetContextKey contextKey = "proxy_pinned_target"
+	cacheBypassContextKey  contextKey = "proxy_cache_bypass"
+)
+
+// WithPinnedTarget pins the request to the service target with the given
+// host, bypassing load balancing, health and retries
+func WithPinnedTarget(ctx context.Context, host string) context.Context {
+	return context.WithValue(ctx, pinnedTargetContextKey, host)
+}
+
+// WithCacheBypass makes the request skip cached fallback responses: none
+// is served for it and its response is not recorded
+func WithCacheBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheBypassContextKey, true)
+}
+
+// cacheBypassed reports whether the request skips cached responses
+func cacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(cacheBypassContextKey).(bool)
+	return bypass
+}
+
+// Target returns the service target with the given host, or nil
+func (rp *ReverseProxy) Target(host string) *url.URL {
+	for _, target := range rp.loadBalancer.GetTargets() {
+		if strings.EqualFold(target.Host, host) {
+			return target
+		}
+	}
+	return nil
+}
+
+// nextTarget returns the target the request is pinned to, if any, or else
+// the load balancer's choice
+func (rp *ReverseProxy) nextTarget(r *http.Request) (target *url.URL, pinned bool) {
+	if host, ok := r.Context().Value(pinnedTargetContextKey).(string); ok {
+		return rp.Target(host), true
+	}
+	return rp.loadBalancer.NextTarget(), false
+}
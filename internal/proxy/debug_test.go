@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestPinnedTarget(t *testing.T) {
+	var hits [2]int
+	var urls []string
+	for i := range hits {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[i]++
+		}))
+		defer upstream.Close()
+		urls = append(urls, upstream.URL)
+	}
+
+	rp, err := NewReverseProxy("orders", &config.ServiceConfig{URLs: urls}, "", nil, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, _ := url.Parse(urls[0])
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		r = r.WithContext(WithPinnedTarget(r.Context(), host.Host))
+		if code, err := rp.Forward(httptest.NewRecorder(), r); err != nil || code != http.StatusOK {
+			t.Fatalf("status %d, err %v", code, err)
+		}
+	}
+	if hits[0] != 3 || hits[1] != 0 {
+		t.Errorf("hits %v, want all requests on the pinned target", hits)
+	}
+	if rp.Target("unknown:80") != nil {
+		t.Error("unknown target resolved")
+	}
+}
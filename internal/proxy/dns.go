@@ -0,0 +1,407 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/pkg/loadbalancer"
+)
+
+// Defaults for upstream DNS re-resolution
+const (
+	defaultDNSRefreshInterval = 30 * time.Second
+	defaultDNSMinTTL          = 5 * time.Second
+	defaultDNSMaxTTL          = 5 * time.Minute
+	dnsQueryTimeout           = 5 * time.Second
+)
+
+// lookupFunc resolves a hostname, returning its addresses and how long they
+// may be used
+type lookupFunc func(ctx context.Context, host string) ([]net.IP, time.Duration, error)
+
+// resolvedHost is a configured target with a hostname and the targets it
+// currently expands to
+type resolvedHost struct {
+	target    loadbalancer.PriorityTarget
+	weight    int
+	expanded  map[string]*url.URL // By address
+	refreshAt time.Time
+}
+
+// dnsRefresher re-resolves target hostnames and keeps the load balancer's
+// targets in sync with their A/AAAA records
+type dnsRefresher struct {
+	service string
+	lb      loadbalancer.LoadBalancer
+	lookup  lookupFunc
+	minTTL  time.Duration
+	maxTTL  time.Duration
+	hosts   []*resolvedHost
+	logger  *zap.Logger
+	cancel  context.CancelFunc
+
+	mu sync.RWMutex
+	// origins maps expanded targets (ip:port) to the configured host, sent
+	// as the Host header and verified in TLS handshakes
+	origins map[string]string
+}
+
+// newDNSRefresher returns a refresher for the targets with hostnames, or
+// nil if re-resolution is disabled or all targets are IP addresses
+func newDNSRefresher(service string, cfg config.DNSConfig, targets []loadbalancer.PriorityTarget, lb loadbalancer.LoadBalancer, logger *zap.Logger) *dnsRefresher {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	d := &dnsRefresher{
+		service: service,
+		lb:      lb,
+		minTTL:  cfg.MinTTL,
+		maxTTL:  cfg.MaxTTL,
+		logger:  logger,
+		origins: make(map[string]string),
+	}
+	if d.minTTL == 0 {
+		d.minTTL = defaultDNSMinTTL
+	}
+	if d.maxTTL == 0 {
+		d.maxTTL = max(defaultDNSMaxTTL, d.minTTL)
+	}
+	if cfg.Resolver != "" {
+		d.lookup = serverLookup(cfg.Resolver)
+	} else {
+		interval := cfg.RefreshInterval
+		if interval == 0 {
+			interval = defaultDNSRefreshInterval
+		}
+		d.lookup = systemLookup(interval)
+	}
+
+	for _, target := range targets {
+		host := target.URL.Hostname()
+		if host == "" || net.ParseIP(host) != nil {
+			continue
+		}
+		d.hosts = append(d.hosts, &resolvedHost{target: target})
+	}
+	if len(d.hosts) == 0 {
+		return nil
+	}
+	return d
+}
+
+// start re-resolves the hostnames in the background until stop is called
+func (d *dnsRefresher) start() {
+	if d == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	go d.run(ctx)
+}
+
+// stop ends re-resolution
+func (d *dnsRefresher) stop() {
+	if d == nil || d.cancel == nil {
+		return
+	}
+	d.cancel()
+}
+
+// run resolves each hostname whenever its records expire
+func (d *dnsRefresher) run(ctx context.Context) {
+	for {
+		now := time.Now()
+		next := now.Add(d.maxTTL)
+		for _, h := range d.hosts {
+			if !now.Before(h.refreshAt) {
+				d.refresh(ctx, h, now)
+			}
+			if h.refreshAt.Before(next) {
+				next = h.refreshAt
+			}
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// refresh resolves a hostname and updates the load balancer. On failure the
+// current targets are kept, as stale addresses beat having none.
+func (d *dnsRefresher) refresh(ctx context.Context, h *resolvedHost, now time.Time) {
+	lookupCtx, cancel := context.WithTimeout(ctx, dnsQueryTimeout)
+	ips, ttl, err := d.lookup(lookupCtx, h.target.URL.Hostname())
+	cancel()
+	if err == nil && len(ips) == 0 {
+		err = errors.New("no A or AAAA records")
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			d.logger.Warn("Failed to re-resolve upstream host",
+				zap.String("service", d.service),
+				zap.String("host", h.target.URL.Hostname()),
+				zap.Error(err))
+		}
+		h.refreshAt = now.Add(d.minTTL)
+		return
+	}
+	h.refreshAt = now.Add(min(max(ttl, d.minTTL), d.maxTTL))
+
+	first := h.expanded == nil
+	if first {
+		// Expanded targets inherit the weight of the configured target
+		h.weight = 1
+		if weighter, ok := d.lb.(loadbalancer.Weighter); ok {
+			if weight := weighter.GetWeight(h.target.URL); weight > 0 {
+				h.weight = weight
+			}
+		}
+	}
+
+	port := h.target.URL.Port()
+	if port == "" {
+		port = "80"
+		if h.target.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	expanded := make(map[string]*url.URL, len(ips))
+	var added, removed []string
+	for _, ip := range ips {
+		addr := ip.String()
+		if target, ok := h.expanded[addr]; ok {
+			expanded[addr] = target
+			continue
+		}
+		if _, ok := expanded[addr]; ok {
+			continue
+		}
+
+		target := *h.target.URL
+		target.Host = net.JoinHostPort(addr, port)
+		expanded[addr] = &target
+		d.setOrigin(target.Host, h.target.URL.Host)
+		d.add(h, &target)
+		added = append(added, target.Host)
+	}
+
+	// Remove stale targets after adding the new ones, so the balancer is
+	// never left empty
+	for addr, target := range h.expanded {
+		if _, ok := expanded[addr]; !ok {
+			d.lb.RemoveTarget(target)
+			removed = append(removed, target.Host)
+		}
+	}
+	if first {
+		d.lb.RemoveTarget(h.target.URL)
+	}
+	h.expanded = expanded
+
+	if len(added) > 0 || len(removed) > 0 {
+		d.logger.Info("Upstream host re-resolved",
+			zap.String("service", d.service),
+			zap.String("host", h.target.URL.Hostname()),
+			zap.Strings("added", added),
+			zap.Strings("removed", removed),
+			zap.Duration("ttl", h.refreshAt.Sub(now)))
+	}
+}
+
+// add adds an expanded target to the load balancer with the zone, priority
+// and weight of its configured target
+func (d *dnsRefresher) add(h *resolvedHost, target *url.URL) {
+	if priority, ok := d.lb.(*loadbalancer.Priority); ok {
+		priority.AddPriorityTarget(loadbalancer.PriorityTarget{
+			URL:      target,
+			Zone:     h.target.Zone,
+			Priority: h.target.Priority,
+		})
+	} else {
+		d.lb.AddTarget(target)
+	}
+	if weighter, ok := d.lb.(loadbalancer.Weighter); ok {
+		weighter.SetWeight(target, h.weight)
+	}
+}
+
+func (d *dnsRefresher) setOrigin(addr, host string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.origins[addr] = host
+}
+
+// origin returns the configured host of a target host, which is the host
+// itself unless it was expanded from a hostname
+func (d *dnsRefresher) origin(host string) string {
+	if d == nil {
+		return host
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if origin, ok := d.origins[host]; ok {
+		return origin
+	}
+	return host
+}
+
+// transport returns a transport that verifies expanded https targets
+// against their configured hostname, or nil if no target needs it
+func (d *dnsRefresher) transport() http.RoundTripper {
+	if d == nil {
+		return nil
+	}
+	secure := false
+	for _, h := range d.hosts {
+		secure = secure || h.target.URL.Scheme == "https"
+	}
+	if !secure {
+		return nil
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		serverName := (&url.URL{Host: d.origin(addr)}).Hostname()
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+	return transport
+}
+
+// systemLookup resolves with the system resolver, which does not report
+// TTLs, so addresses are used for the refresh interval
+func systemLookup(interval time.Duration) lookupFunc {
+	return func(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, 0, err
+		}
+		ips := make([]net.IP, 0, len(addrs))
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+		return ips, interval, nil
+	}
+}
+
+// serverLookup queries a DNS server for A and AAAA records, using the
+// lowest record TTL
+func serverLookup(server string) lookupFunc {
+	return func(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+		var ips []net.IP
+		var ttl uint32
+		var errs []error
+		for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+			found, foundTTL, err := queryDNS(ctx, server, host, qtype)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if len(found) > 0 && (len(ips) == 0 || foundTTL < ttl) {
+				ttl = foundTTL
+			}
+			ips = append(ips, found...)
+		}
+		if len(errs) == 2 {
+			return nil, 0, errors.Join(errs...)
+		}
+		return ips, time.Duration(ttl) * time.Second, nil
+	}
+}
+
+// queryDNS sends a single query over UDP and returns the addresses of the
+// answer with their lowest TTL
+func queryDNS(ctx context.Context, server, host string, qtype dnsmessage.Type) ([]net.IP, uint32, error) {
+	name, err := dnsmessage.NewName(host + ".")
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid hostname %s: %w", host, err)
+	}
+	id := uint16(rand.Intn(1 << 16))
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(packed); err != nil {
+		return nil, 0, err
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, 0, err
+		}
+		var resp dnsmessage.Message
+		if err := resp.Unpack(buf[:n]); err != nil || resp.Header.ID != id || !resp.Header.Response {
+			continue // Not the answer to this query
+		}
+		switch resp.Header.RCode {
+		case dnsmessage.RCodeSuccess:
+		case dnsmessage.RCodeNameError:
+			return nil, 0, nil
+		default:
+			return nil, 0, fmt.Errorf("dns query for %s failed: %s", host, resp.Header.RCode)
+		}
+
+		var ips []net.IP
+		var ttl uint32
+		for _, answer := range resp.Answers {
+			var ip net.IP
+			switch body := answer.Body.(type) {
+			case *dnsmessage.AResource:
+				ip = net.IP(body.A[:])
+			case *dnsmessage.AAAAResource:
+				ip = net.IP(body.AAAA[:])
+			default:
+				continue // CNAMEs are followed by the server
+			}
+			if len(ips) == 0 || answer.Header.TTL < ttl {
+				ttl = answer.Header.TTL
+			}
+			ips = append(ips, ip)
+		}
+		return ips, ttl, nil
+	}
+}
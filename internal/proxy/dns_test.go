@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/pkg/loadbalancer"
+)
+
+func TestDNSRefresh(t *testing.T) {
+	configured, _ := url.Parse("http://orders.internal:8002")
+	static, _ := url.Parse("http://10.0.0.1:8002")
+	lb := loadbalancer.NewWeightedRoundRobin([]*url.URL{configured, static}, []int{3, 1})
+
+	d := newDNSRefresher("orders", config.DNSConfig{Enabled: true}, []loadbalancer.PriorityTarget{
+		{URL: configured}, {URL: static},
+	}, lb, zap.NewNop())
+	if d == nil || len(d.hosts) != 1 {
+		t.Fatalf("expected one hostname to re-resolve")
+	}
+
+	records := []net.IP{net.ParseIP("10.1.0.1"), net.ParseIP("10.1.0.2")}
+	d.lookup = func(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+		return records, time.Hour, nil
+	}
+	targets := func() []string {
+		var hosts []string
+		for _, target := range lb.GetTargets() {
+			hosts = append(hosts, target.Host)
+		}
+		sort.Strings(hosts)
+		return hosts
+	}
+
+	now := time.Now()
+	d.refresh(context.Background(), d.hosts[0], now)
+	if got := targets(); len(got) != 3 || got[0] != "10.0.0.1:8002" || got[1] != "10.1.0.1:8002" || got[2] != "10.1.0.2:8002" {
+		t.Fatalf("targets = %v, want the hostname expanded", got)
+	}
+	expanded, _ := url.Parse("http://10.1.0.2:8002")
+	if weight := lb.GetWeight(expanded); weight != 3 {
+		t.Errorf("expanded weight = %d, want 3", weight)
+	}
+	if host := d.origin("10.1.0.2:8002"); host != "orders.internal:8002" {
+		t.Errorf("origin = %s, want orders.internal:8002", host)
+	}
+	if at := d.hosts[0].refreshAt.Sub(now); at != defaultDNSMaxTTL {
+		t.Errorf("refresh in %v, want the TTL capped at %v", at, defaultDNSMaxTTL)
+	}
+
+	// Changed records replace the stale addresses; failures keep them
+	records = []net.IP{net.ParseIP("10.1.0.2"), net.ParseIP("10.1.0.3")}
+	d.refresh(context.Background(), d.hosts[0], now)
+	records = nil
+	d.refresh(context.Background(), d.hosts[0], now)
+	if got := targets(); len(got) != 3 || got[1] != "10.1.0.2:8002" || got[2] != "10.1.0.3:8002" {
+		t.Errorf("targets = %v, want 10.1.0.1 replaced by 10.1.0.3", got)
+	}
+}
+
+func TestServerLookup(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if query.Unpack(buf[:n]) != nil {
+				continue
+			}
+			question := query.Questions[0]
+			resp := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.Header.ID, Response: true},
+				Questions: query.Questions,
+			}
+			header := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET}
+			if question.Type == dnsmessage.TypeA {
+				header.TTL = 60
+				resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AResource{A: [4]byte{10, 2, 0, 1}}})
+				header.TTL = 20
+				resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AResource{A: [4]byte{10, 2, 0, 2}}})
+			}
+			packed, _ := resp.Pack()
+			conn.WriteTo(packed, addr)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	ips, ttl, err := serverLookup(conn.LocalAddr().String())(ctx, "orders.internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 2 || ttl != 20*time.Second {
+		t.Errorf("ips = %v, ttl = %v, want two addresses with the lowest TTL", ips, ttl)
+	}
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// defaultDrainTimeout bounds the wait for in-flight requests when no drain
+// timeout is configured
+const defaultDrainTimeout = 30 * time.Second
+
+// drainer counts the in-flight requests of a proxy so it can be torn down
+// once they are done
+type drainer struct {
+	mu       sync.Mutex
+	inflight int
+	closed   bool          // New requests are refused
+	idle     chan struct{} // Closed when the last request is done, while waited on
+}
+
+// acquire counts a request in, returning false once the proxy is closed
+func (d *drainer) acquire() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return false
+	}
+	d.inflight++
+	return true
+}
+
+// release counts a request out
+func (d *drainer) release() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inflight--
+	if d.inflight == 0 && d.idle != nil {
+		close(d.idle)
+		d.idle = nil
+	}
+}
+
+// close refuses new requests
+func (d *drainer) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+}
+
+// wait waits up to timeout for the in-flight requests and returns those
+// still in flight
+func (d *drainer) wait(timeout time.Duration) int {
+	d.mu.Lock()
+	if d.inflight == 0 {
+		d.mu.Unlock()
+		return 0
+	}
+	if d.idle == nil {
+		d.idle = make(chan struct{})
+	}
+	idle := d.idle
+	d.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-idle:
+	case <-timer.C:
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.inflight
+}
+
+// refuseDrained answers a request that reached a removed service
+func (rp *ReverseProxy) refuseDrained(w http.ResponseWriter, r *http.Request) {
+	rp.logger.Debug("Request refused by removed service",
+		zap.String("service", rp.serviceName),
+		zap.String("path", r.URL.Path))
+	w.Header().Set("Retry-After", "1")
+	writeJSONError(w, http.StatusServiceUnavailable, "Service removed: "+rp.serviceName)
+}
+
+// retire tears down a removed or replaced proxy once its in-flight requests
+// are done or the drain timeout passes
+func (pm *ProxyManager) retire(name string, rp *ReverseProxy) {
+	if rp == nil {
+		return
+	}
+	timeout := pm.timeouts.Drain
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
+	}
+
+	start := time.Now()
+	if remaining := rp.drain.wait(timeout); remaining > 0 {
+		pm.logger.Warn("Service drain timed out, closing connections",
+			zap.String("service", name),
+			zap.Int("in_flight", remaining),
+			zap.Duration("timeout", timeout))
+	} else {
+		pm.logger.Info("Service drained",
+			zap.String("service", name),
+			zap.Duration("duration", time.Since(start)))
+	}
+	rp.release()
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestRemoveServiceDrainsInFlightRequests(t *testing.T) {
+	arrived, finish := make(chan struct{}), make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-finish
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	pm := NewProxyManager(zap.NewNop(), nil)
+	if err := pm.AddService("orders", &config.ServiceConfig{URLs: []string{upstream.URL}}); err != nil {
+		t.Fatalf("AddService() error = %v", err)
+	}
+	rp := pm.GetProxy("orders")
+
+	inFlight := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		rp.Forward(inFlight, httptest.NewRequest(http.MethodGet, "/orders/1", nil))
+		close(done)
+	}()
+	<-arrived
+
+	pm.RemoveService("orders")
+	if pm.GetProxy("orders") != nil {
+		t.Fatal("removed service is still routed")
+	}
+
+	// Requests reaching the removed proxy are refused while it drains
+	late := httptest.NewRecorder()
+	if status, _ := rp.Forward(late, httptest.NewRequest(http.MethodGet, "/orders/2", nil)); status != http.StatusServiceUnavailable {
+		t.Errorf("Forward() on a draining service = %d, want 503", status)
+	}
+
+	close(finish)
+	<-done
+	if inFlight.Code != http.StatusOK {
+		t.Errorf("in-flight request status = %d, want 200", inFlight.Code)
+	}
+}
+
+func TestDrainerWaitTimesOut(t *testing.T) {
+	var d drainer
+	if !d.acquire() {
+		t.Fatal("acquire() refused before draining")
+	}
+	d.close()
+	if remaining := d.wait(10 * time.Millisecond); remaining != 1 {
+		t.Errorf("wait() = %d, want 1 request still in flight", remaining)
+	}
+	if d.acquire() {
+		t.Error("acquire() allowed after the drain started")
+	}
+	d.release()
+	if remaining := d.wait(time.Second); remaining != 0 {
+		t.Errorf("wait() = %d after the last request, want 0", remaining)
+	}
+}
@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/cache"
+	"github.com/max/api-gateway/internal/config"
+)
+
+const (
+	defaultFallbackCacheSize    = 100
+	defaultFallbackCacheTTL     = 10 * time.Minute
+	defaultFallbackMaxBodyBytes = 1 << 20
+
+	// fallbackContextKey marks a request already served by a fallback
+	fallbackContextKey contextKey = "proxy_fallback"
+)
+
+// Fallback serves degraded responses when a service is unavailable
+type Fallback struct {
+	service string
+	cfg     config.FallbackConfig
+	cache   cache.Cache
+	keys    cacheKeyPolicy
+	shared  string // Key prefix of the service's entries in a shared cache
+	manager *ProxyManager
+	logger  *zap.Logger
+}
+
+// NewFallback creates a fallback handler, or returns nil if none is configured
+func NewFallback(service string, cfg config.FallbackConfig, manager *ProxyManager, logger *zap.Logger) *Fallback {
+	if cfg.Type == "" {
+		return nil
+	}
+
+	fb := &Fallback{
+		service: service,
+		cfg:     cfg,
+		manager: manager,
+		logger:  logger,
+	}
+
+	if cfg.Type == "cache" {
+		if fb.cfg.CacheSize <= 0 {
+			fb.cfg.CacheSize = defaultFallbackCacheSize
+		}
+		if fb.cfg.CacheTTL <= 0 {
+			fb.cfg.CacheTTL = defaultFallbackCacheTTL
+		}
+		if fb.cfg.MaxBodyBytes <= 0 {
+			fb.cfg.MaxBodyBytes = defaultFallbackMaxBodyBytes
+		}
+		fb.cache = manager.sharedCache
+		if fb.cache == nil {
+			fb.cache = cache.NewLRUCache(fb.cfg.CacheSize, logger)
+		} else {
+			fb.shared = service + " "
+		}
+		fb.keys = newCacheKeyPolicy(cfg)
+	}
+
+	return fb
+}
+
+// Record remembers a successful response as the last-known-good response for
+// the client request in. The response body is buffered and replaced so it
+// can still be streamed to the client.
+func (f *Fallback) Record(in *http.Request, resp *http.Response) {
+	if f == nil || f.cache == nil {
+		return
+	}
+	if in.Method != http.MethodGet || cacheBypassed(resp.Request.Context()) {
+		return
+	}
+	// Partial content is no stand-in for the whole resource
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 || resp.StatusCode == http.StatusPartialContent {
+		return
+	}
+	rule := f.keys.rule(in.URL.Path)
+	key, ok := rule.key(in)
+	if !ok || !rule.cacheable(resp) {
+		return
+	}
+	key = f.shared + key
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.cfg.MaxBodyBytes+1))
+	// Whatever was read must be handed back to the client, followed by the rest
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+	if err != nil || int64(len(body)) > f.cfg.MaxBodyBytes {
+		return
+	}
+
+	data, err := json.Marshal(&cache.CachedResponse{
+		StatusCode: resp.StatusCode,
+		Headers:    resp.Header.Clone(),
+		Body:       body,
+		Timestamp:  time.Now(),
+	})
+	if err != nil {
+		return
+	}
+
+	// The request context carries the tenant of a shared cache
+	if err := f.cache.Set(context.WithoutCancel(in.Context()), key, data, f.cfg.CacheTTL); err != nil {
+		f.logger.Debug("Failed to store fallback response", zap.Error(err))
+	}
+}
+
+// Serve writes the fallback response. It returns false if no fallback could
+// be served, in which case the caller should write its own error response.
+// A request is served by at most one fallback, so a failing fallback
+// service does not hand it on to its own fallback.
+func (f *Fallback) Serve(w http.ResponseWriter, r *http.Request) bool {
+	if f == nil || r.Context().Value(fallbackContextKey) != nil {
+		return false
+	}
+
+	switch f.cfg.Type {
+	case "static":
+		markUpstream(w)
+		f.serveStatic(w)
+		return true
+	case "cache":
+		return f.serveCached(w, r)
+	case "service":
+		return f.serveService(w, r)
+	}
+
+	return false
+}
+
+// serveStatic writes the configured static payload
+func (f *Fallback) serveStatic(w http.ResponseWriter) {
+	for name, value := range f.cfg.Headers {
+		w.Header().Set(name, value)
+	}
+	if f.cfg.ContentType != "" {
+		w.Header().Set("Content-Type", f.cfg.ContentType)
+	}
+	w.Header().Set("X-Gateway-Fallback", "static")
+
+	status := f.cfg.StatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	io.WriteString(w, f.cfg.Body)
+}
+
+// serveCached writes the last-known-good response for the request, if any
+func (f *Fallback) serveCached(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet || cacheBypassed(r.Context()) {
+		return false
+	}
+
+	key, ok := f.keys.rule(r.URL.Path).key(r)
+	if !ok {
+		return false
+	}
+	data, err := f.cache.Get(r.Context(), f.shared+key)
+	if err != nil {
+		return false
+	}
+
+	var cached cache.CachedResponse
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return false
+	}
+
+	for name, values := range cached.Headers {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	markUpstream(w)
+	w.Header().Set("X-Gateway-Fallback", "cache")
+	w.Header().Set("Age", strconv.FormatInt(int64(time.Since(cached.Timestamp)/time.Second), 10))
+	w.WriteHeader(cached.StatusCode)
+	w.Write(cached.Body)
+	return true
+}
+
+// serveService routes the request to the degraded-mode service, with the
+// service segment of its path renamed to that service
+func (f *Fallback) serveService(w http.ResponseWriter, r *http.Request) bool {
+	degraded := f.manager.GetProxy(f.cfg.Service)
+	if degraded == nil {
+		f.logger.Warn("Fallback service not found", zap.String("service", f.cfg.Service))
+		return false
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), fallbackContextKey, true))
+	target := *r.URL
+	if rest, ok := strings.CutPrefix(target.Path, "/"+f.service); ok && (rest == "" || rest[0] == '/') {
+		target.Path = "/" + f.cfg.Service + rest
+		target.RawPath = ""
+	}
+	r.URL = &target
+
+	w.Header().Set("X-Gateway-Fallback", "service")
+	degraded.ServeHTTP(w, r)
+	return true
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestFallbackServiceCycle(t *testing.T) {
+	var paths []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.RequestURI())
+	}))
+	defer upstream.Close()
+
+	pm := NewProxyManager(zap.NewNop(), nil)
+	down := "http://127.0.0.1:1"
+	if err := pm.AddService("a", &config.ServiceConfig{
+		URLs:     []string{down},
+		Fallback: config.FallbackConfig{Type: "service", Service: "b"},
+	}); err != nil {
+		t.Fatalf("AddService(a) error = %v", err)
+	}
+	if err := pm.AddService("b", &config.ServiceConfig{URLs: []string{upstream.URL}}); err != nil {
+		t.Fatalf("AddService(b) error = %v", err)
+	}
+
+	// The fallback service receives the request under its own name
+	w := httptest.NewRecorder()
+	pm.GetProxy("a").ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/a/items?page=2", nil))
+	if w.Code != http.StatusOK || w.Header().Get("X-Gateway-Fallback") != "service" {
+		t.Fatalf("fallback response = %d %v, want 200 from b", w.Code, w.Header())
+	}
+	if len(paths) != 1 || paths[0] != "/b/items?page=2" {
+		t.Errorf("fallback upstream paths = %v, want [/b/items?page=2]", paths)
+	}
+
+	// b falling back to a would close the cycle
+	err := pm.UpdateService("b", &config.ServiceConfig{
+		URLs:     []string{down},
+		Fallback: config.FallbackConfig{Type: "service", Service: "a"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "fallback cycle: b -> a -> b") {
+		t.Fatalf("UpdateService(b) error = %v, want a fallback cycle", err)
+	}
+
+	// Even if a cycle slips in, a request is served by one fallback at most
+	if err := pm.UpdateService("b", &config.ServiceConfig{URLs: []string{down}}); err != nil {
+		t.Fatalf("UpdateService(b) error = %v", err)
+	}
+	pm.GetProxy("b").fallback = NewFallback("b", config.FallbackConfig{Type: "service", Service: "a"}, pm, zap.NewNop())
+	w = httptest.NewRecorder()
+	pm.GetProxy("a").ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/a/items", nil))
+	if w.Code < 500 {
+		t.Errorf("request with both services down = %d, want an error status", w.Code)
+	}
+}
+
+func TestValidateFallbackChain(t *testing.T) {
+	fallbacks := map[string]config.FallbackConfig{
+		"b": {Type: "service", Service: "c"},
+		"c": {Type: "service", Service: "a"},
+		"d": {Type: "static"},
+	}
+	fallbackOf := func(service string) config.FallbackConfig { return fallbacks[service] }
+
+	if err := config.ValidateFallbackChain("a", config.FallbackConfig{Type: "service", Service: "b"}, fallbackOf); err == nil {
+		t.Error("a -> b -> c -> a accepted")
+	}
+	if err := config.ValidateFallbackChain("a", config.FallbackConfig{Type: "service", Service: "d"}, fallbackOf); err != nil {
+		t.Errorf("a -> d rejected: %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/max/api-gateway/internal/soap"
+	"github.com/max/api-gateway/internal/upstreamerr"
+	"github.com/max/api-gateway/pkg/servertiming"
+)
+
+// Allocation savings on the forwarding path, measured by BenchmarkForward.
+// Per-target proxies are built once and get each attempt's state from the
+// request context; response writers, copy buffers and attempt state are
+// pooled.
+
+const forwardStateContextKey contextKey = "proxy_forward_state"
+
+// maxTargetProxies bounds the per-target proxy cache; targets re-resolved
+// by DNS can otherwise accumulate
+const maxTargetProxies = 1024
+
+// forwardState is one forwarding attempt, shared with the hooks of the
+// target's proxy
+type forwardState struct {
+	in        *http.Request
+	w         *captureResponseWriter
+	target    *url.URL
+	soapRoute *soap.Route
+	body      *requestBody
+	canRetry  bool
+	start     time.Time // When the attempt was sent, for Server-Timing
+
+	proxyErr *upstreamerr.Error
+	retry    bool
+	respBody *responseBody
+}
+
+// copyBufferSize matches the buffer httputil allocates per response
+const copyBufferSize = 32 << 10
+
+var (
+	copyBuffers       bufferPool
+	forwardStatePool  = sync.Pool{New: func() any { return new(forwardState) }}
+	captureWriterPool = sync.Pool{New: func() any { return new(captureResponseWriter) }}
+)
+
+// bufferPool reuses response copy buffers across requests
+type bufferPool struct {
+	pool sync.Pool
+}
+
+func (p *bufferPool) Get() []byte {
+	if buf, ok := p.pool.Get().(*[]byte); ok {
+		return *buf
+	}
+	return make([]byte, copyBufferSize)
+}
+
+func (p *bufferPool) Put(buf []byte) {
+	p.pool.Put(&buf)
+}
+
+// newCaptureWriter returns a pooled writer wrapping w
+func newCaptureWriter(w http.ResponseWriter) *captureResponseWriter {
+	cw := captureWriterPool.Get().(*captureResponseWriter)
+	*cw = captureResponseWriter{ResponseWriter: w, status: http.StatusOK}
+	return cw
+}
+
+// releaseCaptureWriter returns a writer to the pool once nothing uses it
+func releaseCaptureWriter(cw *captureResponseWriter) {
+	*cw = captureResponseWriter{}
+	captureWriterPool.Put(cw)
+}
+
+// forwardStateFrom returns the attempt state of a proxied request
+func forwardStateFrom(ctx context.Context) *forwardState {
+	state, _ := ctx.Value(forwardStateContextKey).(*forwardState)
+	return state
+}
+
+// targetProxyKey identifies a target without formatting its URL
+type targetProxyKey struct {
+	scheme, host, path string
+}
+
+// targetProxies caches a service's per-target proxies
+type targetProxies struct {
+	mu      sync.RWMutex
+	proxies map[targetProxyKey]*httputil.ReverseProxy
+}
+
+// targetProxy returns the proxy for target, building it on first use
+func (rp *ReverseProxy) targetProxy(target *url.URL) *httputil.ReverseProxy {
+	key := targetProxyKey{scheme: target.Scheme, host: target.Host, path: target.Path}
+
+	rp.proxies.mu.RLock()
+	proxy, ok := rp.proxies.proxies[key]
+	rp.proxies.mu.RUnlock()
+	if ok {
+		return proxy
+	}
+
+	rp.proxies.mu.Lock()
+	defer rp.proxies.mu.Unlock()
+	if proxy, ok := rp.proxies.proxies[key]; ok {
+		return proxy
+	}
+	if rp.proxies.proxies == nil || len(rp.proxies.proxies) >= maxTargetProxies {
+		rp.proxies.proxies = make(map[targetProxyKey]*httputil.ReverseProxy)
+	}
+	proxy = rp.newTargetProxy(target)
+	rp.proxies.proxies[key] = proxy
+	return proxy
+}
+
+// newTargetProxy builds the proxy for a target. Rewrite strips inbound
+// forwarding headers so modifyRequest controls exactly what is sent
+// upstream.
+func (rp *ReverseProxy) newTargetProxy(target *url.URL) *httputil.ReverseProxy {
+	// Unix socket and h2c targets need their own transport
+	transport := rp.transports.transport(target)
+	if transport == nil {
+		transport = rp.transport
+	}
+	upstream := upstreamURL(target)
+
+	return &httputil.ReverseProxy{
+		Transport:  transport,
+		BufferPool: &copyBuffers,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(upstream)
+			if state := forwardStateFrom(pr.In.Context()); state != nil && state.soapRoute != nil && state.soapRoute.UpstreamPath() != "" {
+				pr.Out.URL.Path = strings.TrimSuffix(upstream.Path, "/") + state.soapRoute.UpstreamPath()
+				pr.Out.URL.RawPath = ""
+			}
+			rp.modifyRequest(pr, target)
+		},
+		ErrorHandler:   rp.proxyError,
+		ModifyResponse: rp.proxyResponse,
+	}
+}
+
+// proxyError handles a failed attempt: retryable failures of untouched
+// requests are left to the caller, others are answered
+func (rp *ReverseProxy) proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	state := forwardStateFrom(r.Context())
+	servertiming.FromContext(r.Context()).Add(servertiming.Upstream, time.Since(state.start))
+	state.proxyErr = rp.classify(state.target, err)
+	if state.canRetry && state.proxyErr.Kind.Retryable() && state.body.read.Load() == 0 && r.Context().Err() == nil {
+		state.retry = true
+		rp.upstreamFailed(r, state.proxyErr, state.target)
+		return
+	}
+	rp.handleProxyError(w, r, state.proxyErr, state.target, state.body)
+}
+
+// proxyResponse prepares an upstream response to be relayed
+func (rp *ReverseProxy) proxyResponse(resp *http.Response) error {
+	state := forwardStateFrom(resp.Request.Context())
+	timings := servertiming.FromContext(resp.Request.Context())
+	timings.Add(servertiming.Upstream, time.Since(state.start))
+	state.respBody = &responseBody{ReadCloser: resp.Body}
+	resp.Body = state.respBody
+	if limit := rp.responseLimit(state.in); limit > 0 {
+		if resp.ContentLength > limit {
+			return ErrResponseTooLarge
+		}
+		in := state.in
+		resp.Body = &limitedBody{
+			ReadCloser: resp.Body,
+			remaining:  limit,
+			exceeded:   func() { rp.responseTooLarge(in) },
+		}
+	}
+	markUpstream(state.w)
+	if state.soapRoute != nil {
+		stop := timings.Start(servertiming.Transform)
+		state.soapRoute.RewriteResponse(resp)
+		stop()
+	}
+	return rp.modifyResponse(resp)
+}
+
+// formattedTime is a time formatted for one second
+type formattedTime struct {
+	unix  int64
+	value string
+}
+
+var gatewayTimeCache atomic.Pointer[formattedTime]
+
+// gatewayTime returns the current time for the X-Gateway-Time header,
+// formatting it at most once per second
+func gatewayTime() string {
+	now := time.Now()
+	if cached := gatewayTimeCache.Load(); cached != nil && cached.unix == now.Unix() {
+		return cached.value
+	}
+	value := now.Format(time.RFC3339)
+	gatewayTimeCache.Store(&formattedTime{unix: now.Unix(), value: value})
+	return value
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	clientIPContextKey    contextKey = "proxy_client_ip"
+	trustedPeerContextKey contextKey = "proxy_trusted_peer"
+)
+
+// WithClientIP records the client address resolved through trusted proxies,
+// which is sent upstream as X-Real-IP
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPContextKey, ip)
+}
+
+// WithTrustedPeer marks the connection peer as a trusted proxy, whose
+// X-Forwarded-For and Forwarded headers are extended rather than dropped
+func WithTrustedPeer(ctx context.Context) context.Context {
+	return context.WithValue(ctx, trustedPeerContextKey, true)
+}
+
+// trustedPeer reports whether the request came from a trusted proxy
+func trustedPeer(r *http.Request) bool {
+	trusted, _ := r.Context().Value(trustedPeerContextKey).(bool)
+	return trusted
+}
+
+// clientIP returns the recorded client address, or the peer address if none
+// was set
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPContextKey).(string); ok && ip != "" {
+		return ip
+	}
+	return peerIP(r)
+}
+
+// peerIP returns the address of the connection peer without the port
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// setForwardedHeaders sets the forwarding headers on the outbound request.
+// The inbound X-Forwarded-For chain is extended with the peer address, and
+// an RFC 7239 Forwarded header is appended when enabled. Headers sent by a
+// peer that is not a trusted proxy are client-supplied and dropped.
+func setForwardedHeaders(out, in *http.Request, forwarded bool) {
+	peer := peerIP(in)
+	trusted := trustedPeer(in)
+
+	var chain []string
+	if trusted {
+		chain = in.Header.Values("X-Forwarded-For")
+	}
+	chain = append(chain, peer)
+	out.Header.Set("X-Forwarded-For", strings.Join(chain, ", "))
+	out.Header.Set("X-Real-IP", clientIP(in))
+	out.Header.Set("X-Forwarded-Host", in.Host)
+
+	proto := "http"
+	if in.TLS != nil {
+		proto = "https"
+	}
+	out.Header.Set("X-Forwarded-Proto", proto)
+
+	if !forwarded {
+		if !trusted {
+			out.Header.Del("Forwarded")
+		}
+		return
+	}
+
+	element := "for=" + forwardedNode(peer) + ";host=" + quoteForwarded(in.Host) + ";proto=" + proto
+	if prior := in.Header.Values("Forwarded"); trusted && len(prior) > 0 {
+		element = strings.Join(prior, ", ") + ", " + element
+	}
+	out.Header.Set("Forwarded", element)
+}
+
+// forwardedNode formats an address as an RFC 7239 node; IPv6 addresses
+// must be bracketed and quoted
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+	return ip
+}
+
+// quoteForwarded quotes a value unless it is a valid RFC 7239 token
+func quoteForwarded(value string) string {
+	for _, r := range value {
+		if !isTokenChar(r) {
+			return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+		}
+	}
+	return value
+}
+
+// isTokenChar reports whether r may appear in an RFC 7230 token
+func isTokenChar(r rune) bool {
+	if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", r)
+}
@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSetForwardedHeaders(t *testing.T) {
+	tests := []struct {
+		name      string
+		remote    string
+		host      string
+		tls       bool
+		trusted   bool
+		clientIP  string
+		forwarded bool
+		want      map[string]string
+	}{
+		{
+			name:   "direct client",
+			remote: "203.0.113.7:51000",
+			host:   "api.example.com",
+			want: map[string]string{
+				"X-Forwarded-For":   "203.0.113.7",
+				"X-Real-IP":         "203.0.113.7",
+				"X-Forwarded-Host":  "api.example.com",
+				"X-Forwarded-Proto": "http",
+				"Forwarded":         "",
+			},
+		},
+		{
+			name:     "trusted proxy",
+			remote:   "10.0.0.2:40000",
+			host:     "api.example.com",
+			tls:      true,
+			trusted:  true,
+			clientIP: "198.51.100.4",
+			want: map[string]string{
+				"X-Forwarded-For":   "198.51.100.4, 10.0.0.2",
+				"X-Real-IP":         "198.51.100.4",
+				"X-Forwarded-Proto": "https",
+				"Forwarded":         "for=198.51.100.4",
+			},
+		},
+		{
+			name:      "trusted proxy with forwarded",
+			remote:    "10.0.0.2:40000",
+			host:      "api.example.com:8443",
+			trusted:   true,
+			clientIP:  "198.51.100.4",
+			forwarded: true,
+			want: map[string]string{
+				"Forwarded": `for=198.51.100.4, for=10.0.0.2;host="api.example.com:8443";proto=http`,
+			},
+		},
+		{
+			name:      "untrusted peer",
+			remote:    "203.0.113.7:51000",
+			host:      "api.example.com",
+			forwarded: true,
+			want: map[string]string{
+				"X-Forwarded-For": "203.0.113.7",
+				"X-Real-IP":       "203.0.113.7",
+				"Forwarded":       "for=203.0.113.7;host=api.example.com;proto=http",
+			},
+		},
+		{
+			name:      "ipv6 client",
+			remote:    "[2001:db8::1]:51000",
+			host:      "api.example.com",
+			forwarded: true,
+			want: map[string]string{
+				"X-Forwarded-For": "2001:db8::1",
+				"Forwarded":       `for="[2001:db8::1]";host=api.example.com;proto=http`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := httptest.NewRequest(http.MethodGet, "/orders", nil)
+			in.RemoteAddr = tt.remote
+			in.Host = tt.host
+			if tt.tls {
+				in.TLS = &tls.ConnectionState{}
+			}
+			// Sent by every client; only kept when the peer is trusted
+			in.Header.Set("X-Forwarded-For", "198.51.100.4")
+			in.Header.Set("Forwarded", "for=198.51.100.4")
+			ctx := in.Context()
+			if tt.clientIP != "" {
+				ctx = WithClientIP(ctx, tt.clientIP)
+			}
+			if tt.trusted {
+				ctx = WithTrustedPeer(ctx)
+			}
+			in = in.WithContext(ctx)
+
+			out := in.Clone(in.Context())
+			setForwardedHeaders(out, in, tt.forwarded)
+			for name, want := range tt.want {
+				if got := out.Header.Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/internal/transcode"
+	"github.com/max/api-gateway/internal/upstreamerr"
+	"github.com/max/api-gateway/pkg/loadbalancer"
+	"github.com/max/api-gateway/pkg/servertiming"
+)
+
+// grpcCloseDelay lets in-flight calls finish before the connections of a
+// replaced service are closed
+const grpcCloseDelay = time.Minute
+
+// grpcSkippedHeaders are not converted between headers and gRPC metadata
+var grpcSkippedHeaders = map[string]bool{
+	"accept":            true,
+	"accept-encoding":   true,
+	"connection":        true,
+	"content-length":    true,
+	"content-type":      true,
+	"host":              true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"te":                true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+// grpcUpstream transcodes REST requests for a service whose targets are
+// gRPC servers
+type grpcUpstream struct {
+	transcoder *transcode.Transcoder
+
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+// newGRPCUpstream loads the service's descriptors; it returns nil if
+// transcoding is disabled
+func newGRPCUpstream(cfg config.GRPCTranscodingConfig) (*grpcUpstream, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	transcoder, err := transcode.Load(cfg.DescriptorSet, cfg.Services)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcUpstream{
+		transcoder: transcoder,
+		conns:      make(map[string]*grpc.ClientConn),
+	}, nil
+}
+
+// conn returns the connection to a target, creating it on first use
+func (u *grpcUpstream) conn(target *url.URL) (*grpc.ClientConn, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	addr := target.Host
+	if target.Scheme == schemeUnix {
+		addr = "unix://" + target.Path
+	}
+	key := target.Scheme + "://" + addr
+	if conn, ok := u.conns[key]; ok {
+		return conn, nil
+	}
+
+	creds := insecure.NewCredentials()
+	if target.Scheme == "https" {
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	u.conns[key] = conn
+	return conn, nil
+}
+
+// close closes the connections to the targets
+func (u *grpcUpstream) close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for key, conn := range u.conns {
+		conn.Close()
+		delete(u.conns, key)
+	}
+}
+
+// release stops re-resolving target hostnames and closes the proxy's
+// upstream connections once in-flight calls had time to finish
+func (rp *ReverseProxy) release() {
+	if rp == nil {
+		return
+	}
+	rp.dns.stop()
+	rp.health.stop()
+	if rp.transports != nil {
+		time.AfterFunc(grpcCloseDelay, rp.transports.closeIdle)
+	}
+	if transport, ok := rp.transport.(*http.Transport); ok {
+		time.AfterFunc(grpcCloseDelay, transport.CloseIdleConnections)
+	}
+	if rp.grpc == nil {
+		return
+	}
+	time.AfterFunc(grpcCloseDelay, rp.grpc.close)
+}
+
+// forwardGRPC transcodes the request to a gRPC call on the next target and
+// writes the JSON response. It reports the outcome like Forward.
+func (rp *ReverseProxy) forwardGRPC(w http.ResponseWriter, r *http.Request, clientCtx context.Context) (int, error) {
+	start := time.Now()
+
+	call, ok := rp.grpc.transcoder.Match(r.Method, transcodedPath(r))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "No gRPC method bound to "+r.Method+" "+r.URL.Path)
+		return http.StatusNotFound, nil
+	}
+
+	timings := servertiming.FromContext(r.Context())
+	stop := timings.Start(servertiming.Transform)
+	in, err := call.Request(r)
+	stop()
+	if err != nil {
+		code := http.StatusBadRequest
+		if errors.Is(err, transcode.ErrBodyTooLarge) {
+			code = http.StatusRequestEntityTooLarge
+		}
+		writeJSONError(w, code, err.Error())
+		return code, nil
+	}
+
+	target, _ := rp.nextTarget(r)
+	if target == nil {
+		rp.logger.Error("No available targets")
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return http.StatusServiceUnavailable, ErrNoTargets
+	}
+
+	conn, err := rp.grpc.conn(target)
+	if err != nil {
+		rp.logger.Error("Failed to create gRPC connection", zap.Error(err), zap.String("target", target.String()))
+		if !rp.ServeFallback(w, r) {
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		}
+		return http.StatusBadGateway, err
+	}
+
+	ctx := metadata.NewOutgoingContext(r.Context(), rp.grpcMetadata(r))
+	out := call.NewResponse()
+	var header metadata.MD
+	stop = timings.Start(servertiming.Upstream)
+	err = conn.Invoke(ctx, call.FullMethod(), in, out, grpc.Header(&header))
+	stop()
+
+	var (
+		code        int
+		upstreamErr error
+	)
+	if err == nil {
+		code = rp.writeGRPCResponse(w, r, call, out, header)
+	} else {
+		code, upstreamErr = rp.handleGRPCError(w, r, err, target, clientCtx)
+	}
+
+	duration := time.Since(start)
+	rp.logger.Info("Proxy request completed",
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.Path),
+		zap.String("target", target.String()),
+		zap.String("grpc_method", call.FullMethod()),
+		zap.Duration("duration", duration),
+		zap.Int("status", code))
+
+	if rp.metrics != nil {
+		rp.metrics.RecordUpstreamRequest(r.Context(), rp.serviceName, r.Method, code, duration)
+	}
+	rp.admission.observe(code, upstreamErr, duration)
+	return code, upstreamErr
+}
+
+// writeGRPCResponse writes a successful gRPC response as JSON, with the
+// response metadata as Grpc-Metadata-* headers
+func (rp *ReverseProxy) writeGRPCResponse(w http.ResponseWriter, r *http.Request, call *transcode.Call, out proto.Message, header metadata.MD) int {
+	stop := servertiming.FromContext(r.Context()).Start(servertiming.Transform)
+	body, err := call.Response(out)
+	stop()
+	if err != nil {
+		rp.logger.Error("Failed to encode gRPC response", zap.Error(err), zap.String("grpc_method", call.FullMethod()))
+		writeJSONError(w, http.StatusBadGateway, "Invalid upstream response")
+		return http.StatusBadGateway
+	}
+	if limit := rp.responseLimits.limit(r); limit > 0 && int64(len(body)) > limit {
+		rp.responseTooLarge(r)
+		writeJSONError(w, http.StatusBadGateway, "Upstream response too large")
+		return http.StatusBadGateway
+	}
+
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    r,
+	}
+	for key, values := range header {
+		if grpcSkippedHeaders[key] || strings.HasSuffix(key, "-bin") {
+			continue
+		}
+		for _, value := range values {
+			resp.Header.Add("Grpc-Metadata-"+key, value)
+		}
+	}
+	resp.Header.Set("Content-Type", "application/json")
+	rp.modifyResponse(resp)
+
+	markUpstream(w)
+	for key, values := range resp.Header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, resp.Body)
+	return http.StatusOK
+}
+
+// handleGRPCError writes the error response of a failed gRPC call. As with
+// HTTP upstreams, unreachable targets and timeouts are reported as transport
+// errors and served the fallback; other statuses are the service's answer.
+func (rp *ReverseProxy) handleGRPCError(w http.ResponseWriter, r *http.Request, err error, target *url.URL, clientCtx context.Context) (int, error) {
+	st := status.Convert(err)
+
+	if clientCtx.Err() != nil {
+		rp.logger.Debug("Client canceled proxied request",
+			zap.String("target", target.String()),
+			zap.String("path", r.URL.Path))
+		w.WriteHeader(StatusClientClosedRequest)
+		return StatusClientClosedRequest, nil
+	}
+
+	switch st.Code() {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		rp.logger.Error("Proxy error",
+			zap.Error(err),
+			zap.String("target", target.String()),
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path))
+
+		// gRPC reports connection failures as Unavailable without their
+		// cause, so they are not classified further
+		code, kind := http.StatusBadGateway, upstreamerr.Other
+		if st.Code() == codes.DeadlineExceeded {
+			code, kind = http.StatusGatewayTimeout, upstreamerr.Timeout
+		} else if healthChecker, ok := rp.loadBalancer.(loadbalancer.HealthChecker); ok {
+			healthChecker.MarkUnhealthy(target)
+		}
+		if !rp.ServeFallback(w, r) {
+			http.Error(w, http.StatusText(code), code)
+		}
+		if rp.metrics != nil {
+			rp.metrics.RecordUpstreamError(rp.serviceName, string(kind))
+		}
+		return code, &upstreamerr.Error{Kind: kind, Target: target.String(), Err: err}
+	}
+
+	code := transcode.HTTPStatus(st.Code())
+	markUpstream(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": st.Message(),
+		"code":  st.Code().String(),
+	})
+	return code, nil
+}
+
+// grpcMetadata converts the request headers to gRPC metadata, adding the
+// forwarding headers sent to HTTP upstreams
+func (rp *ReverseProxy) grpcMetadata(r *http.Request) metadata.MD {
+	md := make(metadata.MD, len(r.Header))
+	for name, values := range r.Header {
+		key := strings.ToLower(name)
+		if grpcSkippedHeaders[key] || strings.HasPrefix(key, "grpc-") {
+			continue
+		}
+		md.Append(key, values...)
+	}
+
+	out := &http.Request{Header: make(http.Header)}
+	setForwardedHeaders(out, r, rp.forwardedHeader)
+	out.Header.Set("X-Gateway", "api-gateway")
+	for name, values := range out.Header {
+		md.Set(strings.ToLower(name), values...)
+	}
+	return md
+}
+
+// writeJSONError writes an error response in the gateway's JSON format
+func writeJSONError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// transcodedPath returns the escaped request path without the service
+// segment, which is matched against the HTTP bindings
+func transcodedPath(r *http.Request) string {
+	path := strings.TrimPrefix(r.URL.EscapedPath(), "/")
+	if i := strings.Index(path, "/"); i >= 0 {
+		return path[i:]
+	}
+	return "/"
+}
@@ -0,0 +1,285 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/pkg/loadbalancer"
+)
+
+// Defaults for active health checks
+const (
+	defaultHealthCheckPath     = "/health"
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 2 * time.Second
+	defaultHealthyThreshold    = 2
+	defaultUnhealthyThreshold  = 3
+	// healthLeaseIntervals is how many probe intervals a prober's lease
+	// outlives its last renewal
+	healthLeaseIntervals = 3
+)
+
+// HealthSharing lets one replica probe a service's targets for all of them
+type HealthSharing interface {
+	// Lead reports whether this replica probes the service, holding the
+	// lease for ttl
+	Lead(service string, ttl time.Duration) bool
+	// Publish shares the health of the service's targets, by URL
+	Publish(service string, health map[string]bool)
+}
+
+// healthChecker probes the targets of a service and takes failing ones out
+// of the rotation
+type healthChecker struct {
+	service            string
+	path               string
+	interval           time.Duration
+	timeout            time.Duration
+	expectStatus       int
+	healthyThreshold   int
+	unhealthyThreshold int
+	rp                 *ReverseProxy
+	marker             loadbalancer.HealthChecker
+	sharing            func() HealthSharing
+	logger             *zap.Logger
+	cancel             context.CancelFunc
+
+	mu sync.Mutex
+	// streaks counts the consecutive passed (positive) or failed
+	// (negative) probes of each target
+	streaks   map[string]int
+	unhealthy map[string]bool
+}
+
+// newHealthChecker returns the health checker of a proxy, or nil if health
+// checks are disabled or its load balancer does not track health
+func newHealthChecker(service string, cfg config.HealthCheckConfig, rp *ReverseProxy, sharing func() HealthSharing, logger *zap.Logger) *healthChecker {
+	marker, ok := rp.loadBalancer.(loadbalancer.HealthChecker)
+	if !cfg.Enabled || !ok {
+		return nil
+	}
+
+	h := &healthChecker{
+		service:            service,
+		path:               cfg.Path,
+		interval:           cfg.Interval,
+		timeout:            cfg.Timeout,
+		expectStatus:       cfg.ExpectStatus,
+		healthyThreshold:   cfg.HealthyThreshold,
+		unhealthyThreshold: cfg.UnhealthyThreshold,
+		rp:                 rp,
+		marker:             marker,
+		sharing:            sharing,
+		logger:             logger,
+		streaks:            make(map[string]int),
+		unhealthy:          make(map[string]bool),
+	}
+	if h.path == "" {
+		h.path = defaultHealthCheckPath
+	}
+	if h.interval <= 0 {
+		h.interval = defaultHealthCheckInterval
+	}
+	if h.timeout <= 0 {
+		h.timeout = defaultHealthCheckTimeout
+	}
+	if h.healthyThreshold <= 0 {
+		h.healthyThreshold = defaultHealthyThreshold
+	}
+	if h.unhealthyThreshold <= 0 {
+		h.unhealthyThreshold = defaultUnhealthyThreshold
+	}
+	return h
+}
+
+// start probes the targets in the background until stop is called
+func (h *healthChecker) start() {
+	if h == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h.cancel = cancel
+	go func() {
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+		for {
+			h.check(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// stop ends the probes
+func (h *healthChecker) stop() {
+	if h == nil || h.cancel == nil {
+		return
+	}
+	h.cancel()
+}
+
+// check probes every target, unless another replica probes the service and
+// shares its results
+func (h *healthChecker) check(ctx context.Context) {
+	sharing := h.sharing()
+	if sharing != nil && !sharing.Lead(h.service, healthLeaseIntervals*h.interval) {
+		return
+	}
+
+	targets := h.rp.loadBalancer.GetTargets()
+	passed := make([]bool, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			passed[i] = h.probe(ctx, target)
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	health := make(map[string]bool, len(targets))
+	for i, target := range targets {
+		health[target.String()] = h.observe(target, passed[i])
+	}
+	if sharing != nil {
+		sharing.Publish(h.service, health)
+	}
+}
+
+// probe reports whether a target answered its health check
+func (h *healthChecker) probe(ctx context.Context, target *url.URL) bool {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	probeURL := *upstreamURL(target)
+	probeURL.Path = strings.TrimSuffix(probeURL.Path, "/") + h.path
+	probeURL.RawPath, probeURL.RawQuery = "", ""
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probeURL.String(), nil)
+	if err != nil {
+		return false
+	}
+	req.Host = h.rp.dns.origin(target.Host)
+	req.Header.Set("User-Agent", "api-gateway-health-check")
+
+	transport := h.rp.transports.transport(target)
+	if transport == nil {
+		transport = h.rp.transport
+	}
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		h.logger.Debug("Health check failed",
+			zap.String("service", h.service),
+			zap.String("target", target.String()),
+			zap.Error(err))
+		return false
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	if h.expectStatus != 0 {
+		return resp.StatusCode == h.expectStatus
+	}
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// observe records a probe result and returns whether the target is healthy.
+// A target leaves the rotation after unhealthyThreshold failed probes, and
+// returns after healthyThreshold passed ones, including when it was marked
+// unhealthy by a failed request.
+func (h *healthChecker) observe(target *url.URL, passed bool) bool {
+	key := target.String()
+	h.mu.Lock()
+	streak := h.streaks[key]
+	switch {
+	case passed && streak < 0, !passed && streak > 0:
+		streak = 0
+	}
+	if passed {
+		streak++
+	} else {
+		streak--
+	}
+	h.streaks[key] = streak
+
+	wasUnhealthy := h.unhealthy[key]
+	unhealthy := wasUnhealthy
+	if streak <= -h.unhealthyThreshold {
+		unhealthy = true
+	} else if streak >= h.healthyThreshold {
+		unhealthy = false
+	}
+	h.unhealthy[key] = unhealthy
+	h.mu.Unlock()
+
+	if unhealthy != wasUnhealthy {
+		h.logger.Info("Target health changed",
+			zap.String("service", h.service),
+			zap.String("target", key),
+			zap.Bool("healthy", !unhealthy))
+	}
+	switch {
+	case unhealthy:
+		h.marker.MarkUnhealthy(target)
+	case streak >= h.healthyThreshold && !h.marker.IsHealthy(target):
+		h.marker.MarkHealthy(target)
+	}
+	return !unhealthy && h.marker.IsHealthy(target)
+}
+
+// SetHealthSharing makes the replicas take turns probing each service:
+// the lead replica probes and publishes the results, which the others
+// apply with ApplyTargetHealth
+func (pm *ProxyManager) SetHealthSharing(sharing HealthSharing) {
+	pm.health.Store(&sharing)
+}
+
+// healthSharing returns the health sharing, or nil when each replica
+// probes on its own
+func (pm *ProxyManager) healthSharing() HealthSharing {
+	if sharing := pm.health.Load(); sharing != nil {
+		return *sharing
+	}
+	return nil
+}
+
+// ApplyTargetHealth applies the health of a service's targets probed by
+// another replica. Targets and services this replica does not know are
+// ignored.
+func (pm *ProxyManager) ApplyTargetHealth(service string, health map[string]bool) {
+	rp := pm.GetProxy(service)
+	if rp == nil {
+		return
+	}
+	marker, ok := rp.loadBalancer.(loadbalancer.HealthChecker)
+	if !ok {
+		return
+	}
+	for _, target := range rp.loadBalancer.GetTargets() {
+		healthy, ok := health[target.String()]
+		switch {
+		case !ok:
+		case !healthy:
+			marker.MarkUnhealthy(target)
+		case !marker.IsHealthy(target):
+			marker.MarkHealthy(target)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// fakeSharing records the published health of the services it leads
+type fakeSharing struct {
+	lead atomic.Bool
+
+	mu        sync.Mutex
+	published map[string]map[string]bool
+}
+
+func (s *fakeSharing) Lead(service string, ttl time.Duration) bool {
+	return s.lead.Load()
+}
+
+func (s *fakeSharing) Publish(service string, health map[string]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.published[service] = health
+}
+
+// healthTarget serves health checks answering status, counting them
+func healthTarget(t *testing.T, status *atomic.Int32, probes *atomic.Int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			probes.Add(1)
+		}
+		w.WriteHeader(int(status.Load()))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHealthCheck(t *testing.T) {
+	var goodStatus, badStatus, probes atomic.Int32
+	goodStatus.Store(http.StatusOK)
+	badStatus.Store(http.StatusServiceUnavailable)
+	good := healthTarget(t, &goodStatus, &probes)
+	bad := healthTarget(t, &badStatus, &probes)
+
+	sharing := &fakeSharing{published: map[string]map[string]bool{}}
+	sharing.lead.Store(true)
+	pm := NewProxyManager(zap.NewNop(), nil)
+	pm.SetHealthSharing(sharing)
+	err := pm.AddService("orders", &config.ServiceConfig{
+		URLs:         []string{good.URL, bad.URL},
+		LoadBalancer: "least_connections",
+		HealthCheck: config.HealthCheckConfig{
+			Enabled:            true,
+			Path:               "/healthz",
+			Interval:           time.Hour,
+			HealthyThreshold:   1,
+			UnhealthyThreshold: 1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("AddService() error = %v", err)
+	}
+	rp := pm.GetProxy("orders")
+	defer rp.release()
+
+	goodURL, _ := url.Parse(good.URL)
+	badURL, _ := url.Parse(bad.URL)
+	rp.health.check(context.Background())
+	if !rp.health.marker.IsHealthy(goodURL) || rp.health.marker.IsHealthy(badURL) {
+		t.Fatal("failing target was not taken out of the rotation")
+	}
+	sharing.mu.Lock()
+	published := sharing.published["orders"]
+	sharing.mu.Unlock()
+	if !published[good.URL] || published[bad.URL] {
+		t.Errorf("published health = %v, want only %s healthy", published, good.URL)
+	}
+
+	badStatus.Store(http.StatusOK)
+	rp.health.check(context.Background())
+	if !rp.health.marker.IsHealthy(badURL) {
+		t.Error("recovered target was not put back in the rotation")
+	}
+
+	// Replicas that do not lead apply the shared results without probing
+	sharing.lead.Store(false)
+	before := probes.Load()
+	rp.health.check(context.Background())
+	if probes.Load() != before {
+		t.Error("replica probed a service another replica leads")
+	}
+	pm.ApplyTargetHealth("orders", map[string]bool{good.URL: true, bad.URL: false})
+	if rp.health.marker.IsHealthy(badURL) {
+		t.Error("ApplyTargetHealth() did not mark the target unhealthy")
+	}
+}
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestProxyManagerConcurrentChanges(t *testing.T) {
+	pm := NewProxyManager(zap.NewNop(), nil)
+	cfg := &config.ServiceConfig{URLs: []string{"http://127.0.0.1:1"}}
+	if err := pm.AddService("orders", cfg); err != nil {
+		t.Fatalf("AddService() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("users-%d", i)
+			for j := 0; j < 20; j++ {
+				if err := pm.UpdateService("orders", cfg); err != nil {
+					t.Errorf("UpdateService() error = %v", err)
+				}
+				pm.AddService(name, cfg)
+				pm.RemoveService(name)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if pm.GetProxy("orders") == nil {
+					t.Error("GetProxy() lost a service during an update")
+				}
+				pm.ListServices()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if services := pm.ListServices(); len(services) != 1 || services[0] != "orders" {
+		t.Errorf("ListServices() = %v, want [orders]", services)
+	}
+}
+
+func TestSetTargetWeight(t *testing.T) {
+	pm := NewProxyManager(zap.NewNop(), nil)
+	err := pm.AddService("orders", &config.ServiceConfig{
+		LoadBalancer: "weighted_round_robin",
+		Targets: []config.TargetConfig{
+			{URL: "http://10.0.0.1:8080", Weight: 2},
+			{URL: "http://10.0.0.2:8080"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("AddService() error = %v", err)
+	}
+	rp := pm.GetProxy("orders")
+
+	weights := func() map[string]int {
+		got := make(map[string]int)
+		for _, target := range rp.Targets() {
+			got[target.URL] = target.Weight
+		}
+		return got
+	}
+	if got := weights(); got["http://10.0.0.1:8080"] != 2 || got["http://10.0.0.2:8080"] != 1 {
+		t.Errorf("configured weights = %v, want 2 and the default 1", got)
+	}
+
+	if err := rp.SetTargetWeight("http://10.0.0.2:8080", 5); err != nil {
+		t.Fatalf("SetTargetWeight() error = %v", err)
+	}
+	if got := weights()["http://10.0.0.2:8080"]; got != 5 {
+		t.Errorf("weight after SetTargetWeight() = %d, want 5", got)
+	}
+
+	if err := rp.SetTargetWeight("http://10.0.0.2:8080", 0); err == nil {
+		t.Error("SetTargetWeight() with weight 0 succeeded, want an error")
+	}
+	if err := rp.SetTargetWeight("http://10.0.0.3:8080", 1); !errors.Is(err, ErrTargetNotFound) {
+		t.Errorf("SetTargetWeight() of an unknown target error = %v, want ErrTargetNotFound", err)
+	}
+}
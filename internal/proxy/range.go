@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// rangePolicy holds the Range passthrough settings of a service
+type rangePolicy struct {
+	passthrough bool
+	limits      responseLimits
+}
+
+// newRangePolicy compiles the Range settings of a service
+func newRangePolicy(cfg config.RangeConfig) rangePolicy {
+	return rangePolicy{
+		passthrough: cfg.Passthrough,
+		limits:      responseLimits{max: cfg.MaxBytes, routes: cfg.Routes},
+	}
+}
+
+// rangePassthrough reports whether a request for part of a resource is
+// streamed under the service's passthrough policy
+func (rp *ReverseProxy) rangePassthrough(r *http.Request) bool {
+	return rp.ranges.passthrough && r.Header.Get("Range") != "" &&
+		(r.Method == http.MethodGet || r.Method == http.MethodHead)
+}
+
+// responseLimit returns the response size limit of a request, 0 for none.
+// Passthrough Range requests use the Range limits instead of the response
+// limits.
+func (rp *ReverseProxy) responseLimit(r *http.Request) int64 {
+	if rp.rangePassthrough(r) {
+		return rp.ranges.limits.limit(r)
+	}
+	return rp.responseLimits.limit(r)
+}
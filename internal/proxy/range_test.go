@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestRangePassthrough(t *testing.T) {
+	content := strings.NewReader(strings.Repeat("x", 4096))
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, content)
+	}))
+	defer upstream.Close()
+
+	rp, err := NewReverseProxy("files", &config.ServiceConfig{
+		URLs:             []string{upstream.URL},
+		MaxResponseBytes: 1024,
+		Range: config.RangeConfig{
+			Passthrough: true,
+			MaxBytes:    2048,
+			Routes:      []config.ResponseLimitConfig{{PathPrefix: "/files/large", MaxBytes: 0}},
+		},
+	}, "", nil, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  string
+		rng   string
+		want  int
+		bytes int
+	}{
+		{path: "/files/a", want: http.StatusBadGateway},
+		{path: "/files/a", rng: "bytes=0-1999", want: http.StatusPartialContent, bytes: 2000},
+		{path: "/files/a", rng: "bytes=0-2999", want: http.StatusBadGateway},
+		{path: "/files/large", rng: "bytes=0-", want: http.StatusPartialContent, bytes: 4096},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.rng != "" {
+			r.Header.Set("Range", tt.rng)
+		}
+		w := httptest.NewRecorder()
+		rp.Forward(w, r)
+		if w.Code != tt.want || (tt.bytes > 0 && w.Body.Len() != tt.bytes) {
+			t.Errorf("%s %q: status = %d with %d bytes, want %d", tt.path, tt.rng, w.Code, w.Body.Len(), tt.want)
+		}
+		if tt.rng != "" && tt.want == http.StatusPartialContent && !w.Flushed {
+			t.Errorf("%s %q: passthrough response not flushed", tt.path, tt.rng)
+		}
+	}
+}
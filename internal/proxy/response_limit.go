@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// ErrResponseTooLarge is reported when an upstream response exceeds the
+// size limit of its route
+var ErrResponseTooLarge = errors.New("upstream response too large")
+
+// responseLimits holds the response size limits of a service
+type responseLimits struct {
+	max    int64
+	routes []config.ResponseLimitConfig
+}
+
+// limit returns the response size limit of a request, 0 for none
+func (l responseLimits) limit(r *http.Request) int64 {
+	for _, route := range l.routes {
+		if strings.HasPrefix(r.URL.Path, route.PathPrefix) {
+			return route.MaxBytes
+		}
+	}
+	return l.max
+}
+
+// limitedBody fails reads with ErrResponseTooLarge once the body grows past
+// the limit, so the response is aborted instead of silently truncated.
+// exceeded is called the first time.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  func()
+	over      bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.over {
+		return 0, ErrResponseTooLarge
+	}
+
+	// Read one byte past the limit to tell a body of exactly the limit
+	// from a larger one
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		n = int(b.remaining)
+		b.remaining, b.over = 0, true
+		if b.exceeded != nil {
+			b.exceeded()
+		}
+		return n, ErrResponseTooLarge
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestResponseLimit(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("x", 100))
+	}))
+	defer upstream.Close()
+
+	rp, err := NewReverseProxy("reports", &config.ServiceConfig{
+		URLs:             []string{upstream.URL},
+		MaxResponseBytes: 1000,
+		ResponseLimits: []config.ResponseLimitConfig{
+			{PathPrefix: "/reports/export", MaxBytes: 0},
+			{PathPrefix: "/reports/summary", MaxBytes: 50},
+		},
+	}, "", nil, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]int{
+		"/reports/list":    http.StatusOK,
+		"/reports/export":  http.StatusOK,
+		"/reports/summary": http.StatusBadGateway,
+	} {
+		w := httptest.NewRecorder()
+		code, err := rp.Forward(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if code != want || w.Code != want {
+			t.Errorf("%s: status = %d (written %d), want %d", path, code, w.Code, want)
+		}
+		if want == http.StatusBadGateway && !errors.Is(err, ErrResponseTooLarge) {
+			t.Errorf("%s: err = %v, want ErrResponseTooLarge", path, err)
+		}
+	}
+
+	// Bodies without a Content-Length fail once they pass the limit
+	exceeded := 0
+	body := &limitedBody{
+		ReadCloser: io.NopCloser(strings.NewReader(strings.Repeat("x", 100))),
+		remaining:  60,
+		exceeded:   func() { exceeded++ },
+	}
+	data, err := io.ReadAll(body)
+	if len(data) != 60 || !errors.Is(err, ErrResponseTooLarge) || exceeded != 1 {
+		t.Errorf("read %d bytes, err = %v, exceeded %d times", len(data), err, exceeded)
+	}
+
+	body = &limitedBody{ReadCloser: io.NopCloser(strings.NewReader(strings.Repeat("x", 60))), remaining: 60}
+	if data, err := io.ReadAll(body); len(data) != 60 || err != nil {
+		t.Errorf("body at the limit: read %d bytes, err = %v", len(data), err)
+	}
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/internal/upstreamerr"
+)
+
+func TestRetryRefusedTarget(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := "http://" + listener.Addr().String()
+	listener.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer upstream.Close()
+
+	// Round robin sends the first request to the second target
+	rp, err := NewReverseProxy("orders", &config.ServiceConfig{URLs: []string{upstream.URL, refused}, Retries: 1}, "", nil, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	code, err := rp.Forward(w, httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("order")))
+	if err != nil || code != http.StatusOK || w.Body.String() != "order" {
+		t.Errorf("status %d, err %v, body %q, want the request retried", code, err, w.Body.String())
+	}
+
+	// Without retries the classified failure is reported
+	rp, _ = NewReverseProxy("orders", &config.ServiceConfig{URLs: []string{refused}}, "", nil, zap.NewNop())
+	w = httptest.NewRecorder()
+	code, err = rp.Forward(w, httptest.NewRequest(http.MethodGet, "/orders", nil))
+	var upstreamErr *upstreamerr.Error
+	if code != http.StatusBadGateway || !errors.As(err, &upstreamErr) || upstreamErr.Kind != upstreamerr.ConnectionRefused {
+		t.Errorf("status %d, err %v, want a connection_refused failure", code, err)
+	}
+}
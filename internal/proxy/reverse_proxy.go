@@ -1,40 +1,150 @@
 package proxy
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"net"
+	"io"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/max/api-gateway/internal/cache"
 	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/internal/cors"
+	"github.com/max/api-gateway/internal/soap"
+	"github.com/max/api-gateway/internal/upstreamauth"
+	"github.com/max/api-gateway/internal/upstreamerr"
+	"github.com/max/api-gateway/internal/validation"
+	"github.com/max/api-gateway/pkg/egress"
 	"github.com/max/api-gateway/pkg/loadbalancer"
 	"github.com/max/api-gateway/pkg/metrics"
+	"github.com/max/api-gateway/pkg/servertiming"
 )
 
+// StatusClientClosedRequest is the non-standard status recorded when the
+// client disconnects before the upstream responds
+const StatusClientClosedRequest = 499
+
+// ErrNoTargets is returned when a service has no available upstream targets
+var ErrNoTargets = errors.New("no available targets")
+
+// ErrTargetNotFound is returned when a target is not part of a service
+var ErrTargetNotFound = errors.New("target not found")
+
+// ErrWeightsUnsupported is returned when a service's load balancer does not
+// use target weights
+var ErrWeightsUnsupported = errors.New("load balancer does not support weights")
+
 // ReverseProxy handles reverse proxy functionality
 type ReverseProxy struct {
 	loadBalancer loadbalancer.LoadBalancer
-	timeout      time.Duration
+	timeouts     timeoutPolicy
 	retries      int
 	logger       *zap.Logger
 	metrics      *metrics.Manager
 	serviceName  string
+	fallback     *Fallback
+	health       *healthChecker
+
+	// forwardedHeader enables the RFC 7239 Forwarded header
+	forwardedHeader bool
+	validator       *validation.Validator
+	versions        *versionRouter
+	// grpc transcodes requests when the targets are gRPC servers
+	grpc       *grpcUpstream
+	soap       *soap.Bridge
+	admission  *admission
+	darkLaunch config.DarkLaunchConfig
+	// audiences, if set, replace the token audiences accepted gateway-wide
+	audiences []string
+	auth      authPolicy
+	// flag, if set, is the feature flag the service is routed behind
+	flag string
+	// cors replaces the gateway-wide CORS policy when the service has one
+	cors *cors.Resolver
+	// signer adds the credentials the upstreams expect
+	signer upstreamauth.Signer
+	// responseLimits caps the upstream response bodies, and ranges those
+	// of Range requests streamed as is
+	responseLimits responseLimits
+	ranges         rangePolicy
+	// dns re-resolves target hostnames. transport, if set, verifies the
+	// expanded https targets against their hostname or sends requests
+	// through the outbound proxy.
+	dns       *dnsRefresher
+	transport http.RoundTripper
+	// transports serve unix and h2c targets
+	transports *upstreamTransports
+	// proxies are built once per target
+	proxies targetProxies
+	// drain lets in-flight requests finish before the proxy is torn down
+	drain drainer
 }
 
 // NewReverseProxy creates a new reverse proxy
-func NewReverseProxy(serviceName string, cfg *config.ServiceConfig, metricsMgr *metrics.Manager, logger *zap.Logger) (*ReverseProxy, error) {
-	// Parse URLs
-	targets := make([]*url.URL, 0, len(cfg.URLs))
+func NewReverseProxy(serviceName string, cfg *config.ServiceConfig, localZone string, metricsMgr *metrics.Manager, logger *zap.Logger) (*ReverseProxy, error) {
+	// Parse URLs; plain URLs belong to the primary pool with no zone
+	placed := make([]loadbalancer.PriorityTarget, 0, len(cfg.URLs)+len(cfg.Targets))
 	for _, urlStr := range cfg.URLs {
 		target, err := url.Parse(urlStr)
 		if err != nil {
 			return nil, fmt.Errorf("invalid target URL %s: %w", urlStr, err)
 		}
-		targets = append(targets, target)
+		placed = append(placed, loadbalancer.PriorityTarget{URL: target})
+	}
+	for _, targetCfg := range cfg.Targets {
+		target, err := url.Parse(targetCfg.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target URL %s: %w", targetCfg.URL, err)
+		}
+		placed = append(placed, loadbalancer.PriorityTarget{
+			URL:      target,
+			Zone:     targetCfg.Zone,
+			Priority: targetCfg.Priority,
+		})
+	}
+
+	targets := make([]*url.URL, 0, len(placed))
+	weights := make([]int, 0, len(placed))
+	for _, target := range placed {
+		targets = append(targets, target.URL)
+	}
+	for range cfg.URLs {
+		weights = append(weights, 1)
+	}
+	for _, targetCfg := range cfg.Targets {
+		weights = append(weights, targetCfg.Weight)
+	}
+
+	validator, err := validation.NewValidator(cfg.Validation)
+	if err != nil {
+		return nil, fmt.Errorf("invalid validation rules: %w", err)
+	}
+
+	versions, err := newVersionRouter(cfg.Versioning)
+	if err != nil {
+		return nil, fmt.Errorf("invalid versioning: %w", err)
+	}
+
+	grpcUp, err := newGRPCUpstream(cfg.GRPC)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gRPC transcoding: %w", err)
+	}
+
+	bridge, err := soap.NewBridge(cfg.SOAP)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SOAP routes: %w", err)
+	}
+
+	signer, err := upstreamauth.New(cfg.UpstreamAuth, logger)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream auth: %w", err)
 	}
 
 	// Create load balancer
@@ -43,63 +153,180 @@ func NewReverseProxy(serviceName string, cfg *config.ServiceConfig, metricsMgr *
 	case "round_robin":
 		lb = loadbalancer.NewRoundRobin(targets)
 	case "weighted_round_robin":
-		lb = loadbalancer.NewWeightedRoundRobin(targets, nil) // Equal weights by default
+		lb = loadbalancer.NewWeightedRoundRobin(targets, weights)
 	case "least_connections":
 		lb = loadbalancer.NewLeastConnections(targets)
 	case "random":
 		lb = loadbalancer.NewRandom(targets)
+	case "priority":
+		lb = loadbalancer.NewPriority(placed, localZone, cfg.Failover.MinHealthyPercent)
 	default:
 		lb = loadbalancer.NewRoundRobin(targets) // Default to round robin
 	}
 
+	dns := newDNSRefresher(serviceName, cfg.DNS, placed, lb, logger)
+	transport := dns.transport()
+	if cfg.OutboundProxy != "" {
+		proxyURL, err := url.Parse(cfg.OutboundProxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid outbound proxy: %w", err)
+		}
+		transport = proxiedTransport(proxyURL)
+	}
+
 	return &ReverseProxy{
 		loadBalancer: lb,
-		timeout:      cfg.Timeout,
+		timeouts:     newTimeoutPolicy(cfg),
 		retries:      cfg.Retries,
 		logger:       logger,
 		metrics:      metricsMgr,
 		serviceName:  serviceName,
+
+		forwardedHeader: cfg.ForwardedHeader,
+		validator:       validator,
+		versions:        versions,
+		grpc:            grpcUp,
+		soap:            bridge,
+		admission:       newAdmission(serviceName, cfg.Admission, metricsMgr, logger),
+		darkLaunch:      cfg.DarkLaunch,
+		audiences:       cfg.Audiences,
+		flag:            cfg.Flag,
+		auth:            newAuthPolicy(cfg),
+		cors:            serviceCORS(cfg.CORS),
+		signer:          signer,
+		responseLimits:  responseLimits{max: cfg.MaxResponseBytes, routes: cfg.ResponseLimits},
+		ranges:          newRangePolicy(cfg.Range),
+		dns:             dns,
+		transport:       transport,
+		transports:      newUpstreamTransports(),
 	}, nil
 }
 
 // ServeHTTP handles the HTTP request
 func (rp *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rp.Forward(w, r)
+}
+
+// Forward proxies the request and reports the outcome: the status code
+// written to the client and, if the upstream could not be reached or timed
+// out, the transport error. Requests abandoned by the client report no error.
+func (rp *ReverseProxy) Forward(w http.ResponseWriter, r *http.Request) (int, error) {
 	start := time.Now()
 
-	// Get target from load balancer
-	target := rp.loadBalancer.NextTarget()
-	if target == nil {
-		rp.logger.Error("No available targets")
-		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
-		return
+	if !rp.drain.acquire() {
+		rp.refuseDrained(w, r)
+		return http.StatusServiceUnavailable, nil
 	}
+	defer rp.drain.release()
+
+	// Apply the remaining time budget to the upstream request. The context
+	// derives from the client's, so a client disconnect cancels the upstream
+	// call as well.
+	clientCtx := r.Context()
+	if deadline, ok := requestDeadline(r, rp.timeouts); ok {
+		if !time.Now().Before(deadline) {
+			rp.logger.Warn("Request timeout budget exhausted before proxying",
+				zap.String("service", rp.serviceName),
+				zap.String("path", r.URL.Path))
+			if !rp.ServeFallback(w, r) {
+				http.Error(w, "Gateway Timeout", http.StatusGatewayTimeout)
+			}
+			if rp.metrics != nil {
+				rp.metrics.RecordUpstreamError(rp.serviceName, string(upstreamerr.Timeout))
+			}
+			return http.StatusGatewayTimeout, context.DeadlineExceeded
+		}
 
-	// Create reverse proxy for the target
-	proxy := httputil.NewSingleHostReverseProxy(target)
+		ctx, cancel := context.WithDeadline(clientCtx, deadline)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
 
-	// Customize the proxy
-	originalDirector := proxy.Director
-	proxy.Director = func(req *http.Request) {
-		originalDirector(req)
-		rp.modifyRequest(req, target)
+	if rp.grpc != nil {
+		return rp.forwardGRPC(w, r, clientCtx)
 	}
 
-	// Set up error handling
-	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		rp.handleProxyError(w, r, err, target)
+	// Partial downloads are relayed untouched: never cached as fallbacks
+	// nor transformed
+	passthrough := rp.rangePassthrough(r)
+	if passthrough {
+		r = r.WithContext(WithCacheBypass(r.Context()))
 	}
 
-	// Set up response modification
-	proxy.ModifyResponse = func(resp *http.Response) error {
-		return rp.modifyResponse(resp)
+	// Convert JSON requests for SOAP operations to envelopes
+	var soapRoute *soap.Route
+	if !passthrough {
+		soapRoute = rp.soap.Match(r)
+	}
+	if soapRoute != nil {
+		stop := servertiming.FromContext(r.Context()).Start(servertiming.Transform)
+		soapReq, err := soapRoute.RewriteRequest(r)
+		stop()
+		if err != nil {
+			code := http.StatusBadRequest
+			if errors.Is(err, soap.ErrBodyTooLarge) {
+				code = http.StatusRequestEntityTooLarge
+			}
+			writeJSONError(w, code, err.Error())
+			return code, nil
+		}
+		r = soapReq
 	}
 
-	// Set timeout and capture response
-	cw := &captureResponseWriter{ResponseWriter: w, status: http.StatusOK}
-	if rp.timeout > 0 {
-		http.TimeoutHandler(proxy, rp.timeout, "Gateway Timeout").ServeHTTP(cw, r)
-	} else {
-		proxy.ServeHTTP(cw, r)
+	// Upstreams may need credentials added by the gateway
+	if rp.signer != nil {
+		signed, err := rp.signer.Prepare(r)
+		if errors.Is(err, upstreamauth.ErrBodyTooLarge) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, err.Error())
+			return http.StatusRequestEntityTooLarge, nil
+		}
+		if err != nil {
+			rp.logger.Error("Failed to prepare upstream credentials",
+				zap.String("service", rp.serviceName),
+				zap.Error(err))
+			writeJSONError(w, http.StatusBadGateway, "Failed to authenticate to upstream")
+			return http.StatusBadGateway, nil
+		}
+		r = signed
+	}
+
+	// Track what the transport reads of the request body, to tell client
+	// failures from upstream ones and to retry only untouched requests
+	body := &requestBody{ReadCloser: r.Body}
+	if r.Body != nil && r.Body != http.NoBody {
+		r = r.WithContext(r.Context()) // Shallow copy, keeping the caller's body
+		r.Body = body
+	}
+
+	// Failures that never reached the upstream are retried on the next
+	// target, up to the configured retries
+	cw := newCaptureWriter(w)
+	defer releaseCaptureWriter(cw)
+	cw.flush = passthrough
+	var (
+		target   *url.URL
+		proxyErr *upstreamerr.Error
+	)
+	for attempt := 0; ; attempt++ {
+		// Get target from load balancer
+		var pinned bool
+		target, pinned = rp.nextTarget(r)
+		if target == nil {
+			rp.logger.Error("No available targets")
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return http.StatusServiceUnavailable, ErrNoTargets
+		}
+
+		var retry bool
+		proxyErr, retry = rp.forwardTo(cw, r, target, soapRoute, body, attempt < rp.retries && !pinned)
+		if !retry {
+			break
+		}
+		rp.logger.Warn("Retrying upstream request",
+			zap.String("service", rp.serviceName),
+			zap.String("target", target.String()),
+			zap.String("error_type", string(proxyErr.Kind)),
+			zap.Int("attempt", attempt+1))
 	}
 
 	// Log the request
@@ -107,34 +334,93 @@ func (rp *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	rp.logger.Info("Proxy request completed",
 		zap.String("method", r.Method),
 		zap.String("path", r.URL.Path),
-		zap.String("target", target.String()),
+		zap.Stringer("target", target),
 		zap.Duration("duration", duration),
 		zap.Int("status", cw.status))
 
 	// Record upstream metrics
 	if rp.metrics != nil {
-		rp.metrics.RecordUpstreamRequest(rp.serviceName, r.Method, cw.status, duration)
+		rp.metrics.RecordUpstreamRequest(r.Context(), rp.serviceName, r.Method, cw.status, duration)
 	}
-}
+	if proxyErr == nil {
+		rp.admission.observe(cw.status, nil, duration)
+		return cw.status, nil
+	}
+	rp.admission.observe(cw.status, proxyErr, duration)
 
-// modifyRequest modifies the outgoing request
-func (rp *ReverseProxy) modifyRequest(req *http.Request, target *url.URL) {
-	// Set the Host header to the target host
-	req.Host = target.Host
+	if clientCtx.Err() != nil || body.readErr() != nil {
+		// The client went away or sent a broken body; this says nothing
+		// about upstream health
+		return cw.status, nil
+	}
+	return cw.status, proxyErr
+}
 
-	// Add X-Forwarded headers
-	if req.Header.Get("X-Forwarded-For") == "" {
-		req.Header.Set("X-Forwarded-For", req.RemoteAddr)
+// forwardTo proxies the request to one target. It returns the upstream
+// failure, if any, and whether the request should be retried on another
+// target instead, in which case nothing was written.
+func (rp *ReverseProxy) forwardTo(w *captureResponseWriter, r *http.Request, target *url.URL, soapRoute *soap.Route, body *requestBody, canRetry bool) (*upstreamerr.Error, bool) {
+	state := forwardStatePool.Get().(*forwardState)
+	*state = forwardState{w: w, target: target, soapRoute: soapRoute, body: body, canRetry: canRetry}
+	defer func() {
+		*state = forwardState{}
+		forwardStatePool.Put(state)
+	}()
+	r = r.WithContext(context.WithValue(r.Context(), forwardStateContextKey, state))
+	state.in = r
+
+	state.start = time.Now()
+	rp.targetProxy(target).ServeHTTP(w, r)
+	proxyErr, respBody := state.proxyErr, state.respBody
+
+	// The upstream broke off while the response was streaming
+	if proxyErr == nil && respBody != nil && respBody.err != nil && r.Context().Err() != context.Canceled {
+		proxyErr = &upstreamerr.Error{Kind: upstreamerr.BodyRead, Target: target.String(), Err: respBody.err}
+		if upstreamerr.Classify(respBody.err) == upstreamerr.Timeout {
+			proxyErr.Kind = upstreamerr.Timeout
+		}
+		rp.logger.Warn("Upstream response body failed",
+			zap.String("service", rp.serviceName),
+			zap.String("target", target.String()),
+			zap.String("error_type", string(proxyErr.Kind)),
+			zap.Error(respBody.err))
+		if rp.metrics != nil {
+			rp.metrics.RecordUpstreamError(rp.serviceName, string(proxyErr.Kind))
+		}
 	}
-	req.Header.Set("X-Forwarded-Host", req.Header.Get("Host"))
-	req.Header.Set("X-Forwarded-Proto", "http")
-	if req.TLS != nil {
-		req.Header.Set("X-Forwarded-Proto", "https")
+	return proxyErr, state.retry
+}
+
+// classify wraps an error from the upstream at target with its kind
+func (rp *ReverseProxy) classify(target *url.URL, err error) *upstreamerr.Error {
+	if errors.Is(err, ErrResponseTooLarge) {
+		return &upstreamerr.Error{Kind: upstreamerr.ResponseTooLarge, Target: target.String(), Err: err}
 	}
+	return upstreamerr.New(target.String(), err)
+}
+
+// modifyRequest modifies the outgoing request
+func (rp *ReverseProxy) modifyRequest(pr *httputil.ProxyRequest, target *url.URL) {
+	req := pr.Out
+
+	// Set the Host header to the target host, as configured rather than
+	// resolved
+	req.Host = rp.dns.origin(target.Host)
+
+	// Add forwarding headers
+	setForwardedHeaders(req, pr.In, rp.forwardedHeader)
 
 	// Add gateway identification
 	req.Header.Set("X-Gateway", "api-gateway")
-	req.Header.Set("X-Gateway-Time", time.Now().Format(time.RFC3339))
+	req.Header.Set("X-Gateway-Time", gatewayTime())
+
+	// Advertise the remaining time budget
+	setTimeoutHeaders(req)
+
+	// Sign last, so the credentials cover the request as sent
+	if rp.signer != nil {
+		rp.signer.Sign(req)
+	}
 
 	rp.logger.Debug("Request modified",
 		zap.String("target", target.String()),
@@ -144,14 +430,16 @@ func (rp *ReverseProxy) modifyRequest(req *http.Request, target *url.URL) {
 
 // modifyResponse modifies the incoming response
 func (rp *ReverseProxy) modifyResponse(resp *http.Response) error {
-	// Add CORS headers if needed
-	if resp.Header.Get("Access-Control-Allow-Origin") == "" {
+	// The gateway's CORS policy replaces the upstream's
+	if cors.Handled(resp.Request.Context()) {
+		cors.StripHeaders(resp.Header)
+	} else if resp.Header.Get("Access-Control-Allow-Origin") == "" {
 		resp.Header.Set("Access-Control-Allow-Origin", "*")
 	}
 
 	// Add gateway headers
 	resp.Header.Set("X-Gateway", "api-gateway")
-	resp.Header.Set("X-Gateway-Time", time.Now().Format(time.RFC3339))
+	resp.Header.Set("X-Gateway-Time", gatewayTime())
 
 	// Remove sensitive headers
 	resp.Header.Del("Server")
@@ -160,13 +448,86 @@ func (rp *ReverseProxy) modifyResponse(resp *http.Response) error {
 		zap.Int("status", resp.StatusCode),
 		zap.String("content_type", resp.Header.Get("Content-Type")))
 
+	// Remember the response in case the service becomes unavailable
+	in := resp.Request
+	if state := forwardStateFrom(resp.Request.Context()); state != nil {
+		in = state.in
+	}
+	rp.fallback.Record(in, resp)
+
 	return nil
 }
 
+// Validate checks the request against the service's validation rules
+func (rp *ReverseProxy) Validate(r *http.Request) []validation.Violation {
+	return rp.validator.Validate(r)
+}
+
+// ServeFallback serves the configured fallback response for the service.
+// It returns false if the service has no usable fallback.
+func (rp *ReverseProxy) ServeFallback(w http.ResponseWriter, r *http.Request) bool {
+	stop := servertiming.FromContext(r.Context()).Start(servertiming.Cache)
+	served := rp.fallback.Serve(w, r)
+	stop()
+	if !served {
+		return false
+	}
+
+	rp.logger.Info("Served fallback response",
+		zap.String("service", rp.serviceName),
+		zap.String("path", r.URL.Path))
+	return true
+}
+
 // handleProxyError handles proxy errors
-func (rp *ReverseProxy) handleProxyError(w http.ResponseWriter, r *http.Request, err error, target *url.URL) {
+func (rp *ReverseProxy) handleProxyError(w http.ResponseWriter, r *http.Request, err *upstreamerr.Error, target *url.URL, body *requestBody) {
+	if errors.Is(err, context.Canceled) {
+		rp.logger.Debug("Client canceled proxied request",
+			zap.String("target", target.String()),
+			zap.String("path", r.URL.Path))
+		w.WriteHeader(StatusClientClosedRequest)
+		return
+	}
+
+	// The client's request body could not be read
+	if bodyErr := body.readErr(); bodyErr != nil && errors.Is(err, bodyErr) {
+		rp.logger.Debug("Failed to read client request body",
+			zap.String("path", r.URL.Path),
+			zap.Error(bodyErr))
+		var maxBytes *http.MaxBytesError
+		if errors.As(bodyErr, &maxBytes) {
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Declared too large by Content-Length, before anything was written
+	if err.Kind == upstreamerr.ResponseTooLarge {
+		rp.responseTooLarge(r)
+		writeJSONError(w, http.StatusBadGateway, "Upstream response too large")
+		return
+	}
+
+	rp.upstreamFailed(r, err, target)
+
+	// Return appropriate error response
+	code := http.StatusBadGateway
+	if err.Kind == upstreamerr.Timeout {
+		code = http.StatusGatewayTimeout
+	}
+	if !rp.ServeFallback(w, r) {
+		http.Error(w, http.StatusText(code), code)
+	}
+}
+
+// upstreamFailed logs and records a failure of the upstream at target and
+// marks the target unhealthy
+func (rp *ReverseProxy) upstreamFailed(r *http.Request, err *upstreamerr.Error, target *url.URL) {
 	rp.logger.Error("Proxy error",
-		zap.Error(err),
+		zap.Error(err.Err),
+		zap.String("error_type", string(err.Kind)),
 		zap.String("target", target.String()),
 		zap.String("method", r.Method),
 		zap.String("path", r.URL.Path))
@@ -175,26 +536,73 @@ func (rp *ReverseProxy) handleProxyError(w http.ResponseWriter, r *http.Request,
 	if healthChecker, ok := rp.loadBalancer.(loadbalancer.HealthChecker); ok {
 		healthChecker.MarkUnhealthy(target)
 	}
+	if rp.metrics != nil {
+		rp.metrics.RecordUpstreamError(rp.serviceName, string(err.Kind))
+	}
+}
 
-	// Return appropriate error response
-	if ne, ok := err.(net.Error); ok && ne.Timeout() {
-		http.Error(w, "Gateway Timeout", http.StatusGatewayTimeout)
-		if rp.metrics != nil {
-			rp.metrics.RecordUpstreamError(rp.serviceName, "timeout")
+// responseTooLarge reports an upstream response over the size limit. Once
+// the body is streaming, the response can only be aborted.
+func (rp *ReverseProxy) responseTooLarge(r *http.Request) {
+	rp.logger.Warn("Upstream response exceeds size limit",
+		zap.String("service", rp.serviceName),
+		zap.String("path", r.URL.Path),
+		zap.Int64("max_bytes", rp.responseLimit(r)))
+	if rp.metrics != nil {
+		rp.metrics.RecordUpstreamError(rp.serviceName, string(upstreamerr.ResponseTooLarge))
+	}
+}
+
+// requestBody counts the bytes read of a client request body and records
+// the first read error. The transport reads it from its own goroutine.
+type requestBody struct {
+	io.ReadCloser
+	read atomic.Int64
+	mu   sync.Mutex
+	err  error
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read.Add(int64(n))
+	if err != nil && err != io.EOF {
+		b.mu.Lock()
+		if b.err == nil {
+			b.err = err
 		}
-		return
+		b.mu.Unlock()
 	}
-	http.Error(w, "Bad Gateway", http.StatusBadGateway)
-	if rp.metrics != nil {
-		rp.metrics.RecordUpstreamError(rp.serviceName, "bad_gateway")
+	return n, err
+}
+
+// readErr returns the first error reading the body
+func (b *requestBody) readErr() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// responseBody records the first error reading an upstream response body
+type responseBody struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
 	}
+	return n, err
 }
 
-// captureResponseWriter wraps ResponseWriter to capture status and size
+// captureResponseWriter wraps ResponseWriter to capture status and size.
+// With flush, every write is flushed to the client immediately.
 type captureResponseWriter struct {
 	http.ResponseWriter
 	status int
 	size   int
+	flush  bool
 }
 
 func (c *captureResponseWriter) WriteHeader(code int) {
@@ -205,74 +613,350 @@ func (c *captureResponseWriter) WriteHeader(code int) {
 func (c *captureResponseWriter) Write(b []byte) (int, error) {
 	n, err := c.ResponseWriter.Write(b)
 	c.size += n
+	if c.flush && err == nil {
+		http.NewResponseController(c.ResponseWriter).Flush()
+	}
 	return n, err
 }
 
+// Unwrap returns the wrapped writer for http.ResponseController
+func (c *captureResponseWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// upstreamMarker is implemented by writers that treat responses relayed
+// from upstream services differently from the gateway's own responses
+type upstreamMarker interface {
+	MarkUpstream()
+}
+
+// markUpstream marks the response written to w as relayed from upstream
+func markUpstream(w http.ResponseWriter) {
+	for {
+		if marker, ok := w.(upstreamMarker); ok {
+			marker.MarkUpstream()
+			return
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return
+		}
+		w = unwrapper.Unwrap()
+	}
+}
+
 // ProxyManager manages multiple reverse proxies
 type ProxyManager struct {
-	proxies map[string]*ReverseProxy
-	logger  *zap.Logger
-	metrics *metrics.Manager
+	// proxies is an immutable snapshot of the service proxies, replaced as
+	// a whole on every change so requests read it without locking. mu
+	// serializes the changes.
+	proxies atomic.Pointer[map[string]*ReverseProxy]
+	mu      sync.Mutex
+
+	localZone string
+	timeouts  config.TimeoutsConfig
+	egress    *egress.Allowlist
+	logger    *zap.Logger
+	metrics   *metrics.Manager
+
+	// sharedCache, if set, holds the cached fallback responses of every
+	// service
+	sharedCache cache.Cache
+	// health, if set, shares active health check results between replicas
+	health atomic.Pointer[HealthSharing]
+	// admissionScale multiplies the admission limits while the gateway is
+	// degraded; guarded by mu
+	admissionScale float64
 }
 
 // NewProxyManager creates a new proxy manager
 func NewProxyManager(logger *zap.Logger, metricsMgr *metrics.Manager) *ProxyManager {
-	return &ProxyManager{
-		proxies: make(map[string]*ReverseProxy),
-		logger:  logger,
-		metrics: metricsMgr,
+	pm := &ProxyManager{
+		logger:         logger,
+		metrics:        metricsMgr,
+		admissionScale: 1,
 	}
+	pm.proxies.Store(&map[string]*ReverseProxy{})
+	return pm
+}
+
+// snapshot returns the current service proxies, which must not be modified
+func (pm *ProxyManager) snapshot() map[string]*ReverseProxy {
+	return *pm.proxies.Load()
+}
+
+// swap publishes a snapshot with name served by proxy, or removed when proxy
+// is nil, and returns the proxy it replaces
+func (pm *ProxyManager) swap(name string, proxy *ReverseProxy) *ReverseProxy {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	current := pm.snapshot()
+	next := make(map[string]*ReverseProxy, len(current)+1)
+	for service, rp := range current {
+		next[service] = rp
+	}
+	if proxy != nil {
+		proxy.admission.setScale(pm.admissionScale)
+		next[name] = proxy
+	} else {
+		delete(next, name)
+	}
+	pm.proxies.Store(&next)
+	return current[name]
+}
+
+// SetAdmissionScale multiplies the admission limits of every service, now
+// and as services are added, to tighten admission while the gateway is
+// degraded. 1 restores the configured limits. Services without an admission
+// queue are unaffected.
+func (pm *ProxyManager) SetAdmissionScale(scale float64) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	pm.admissionScale = scale
+	for _, proxy := range pm.snapshot() {
+		proxy.admission.setScale(scale)
+	}
+}
+
+// SetLocalZone sets the zone this gateway runs in, used by zone-aware load
+// balancing for services added afterwards
+func (pm *ProxyManager) SetLocalZone(zone string) {
+	pm.localZone = zone
+}
+
+// SetTimeouts sets the gateway-wide timeout settings, used by services added
+// afterwards
+func (pm *ProxyManager) SetTimeouts(cfg config.TimeoutsConfig) {
+	pm.timeouts = cfg
+}
+
+// SetSharedCache stores the cached fallback responses of services added
+// afterwards in store instead of a cache of their own
+func (pm *ProxyManager) SetSharedCache(store cache.Cache) {
+	pm.sharedCache = store
+}
+
+// SetEgress restricts the hosts services added afterwards may point to
+func (pm *ProxyManager) SetEgress(allowlist *egress.Allowlist) {
+	pm.egress = allowlist
+}
+
+// checkEgress rejects targets and outbound proxies outside the egress
+// allowlist
+func (pm *ProxyManager) checkEgress(cfg *config.ServiceConfig) error {
+	if pm.egress == nil {
+		return nil
+	}
+
+	urls := append([]string{}, cfg.URLs...)
+	for _, target := range cfg.Targets {
+		urls = append(urls, target.URL)
+	}
+	if cfg.OutboundProxy != "" {
+		urls = append(urls, cfg.OutboundProxy)
+	}
+	for _, rawURL := range urls {
+		if err := pm.egress.CheckURL(rawURL); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // AddService adds a service proxy
 func (pm *ProxyManager) AddService(name string, cfg *config.ServiceConfig) error {
-	proxy, err := NewReverseProxy(name, cfg, pm.metrics, pm.logger)
+	if err := pm.checkEgress(cfg); err != nil {
+		return fmt.Errorf("failed to create proxy for service %s: %w", name, err)
+	}
+	if err := config.ValidateFallbackChain(name, cfg.Fallback, pm.fallbackOf); err != nil {
+		return fmt.Errorf("failed to create proxy for service %s: %w", name, err)
+	}
+	proxy, err := NewReverseProxy(name, cfg, pm.localZone, pm.metrics, pm.logger)
 	if err != nil {
 		return fmt.Errorf("failed to create proxy for service %s: %w", name, err)
 	}
+	proxy.fallback = NewFallback(name, cfg.Fallback, pm, pm.logger)
+	proxy.health = newHealthChecker(name, cfg.HealthCheck, proxy, pm.healthSharing, pm.logger)
+	proxy.timeouts.applyDefaults(pm.timeouts)
+	proxy.dns.start()
+	proxy.health.start()
 
-	pm.proxies[name] = proxy
+	go pm.retire(name, pm.swap(name, proxy))
 	pm.logger.Info("Service proxy added", zap.String("service", name))
 	return nil
 }
 
+// fallbackOf returns the fallback configured for a running service
+func (pm *ProxyManager) fallbackOf(service string) config.FallbackConfig {
+	if proxy := pm.GetProxy(service); proxy != nil && proxy.fallback != nil {
+		return proxy.fallback.cfg
+	}
+	return config.FallbackConfig{}
+}
+
 // GetProxy returns a proxy for a service
 func (pm *ProxyManager) GetProxy(service string) *ReverseProxy {
-	return pm.proxies[service]
+	return pm.snapshot()[service]
 }
 
-// RemoveService removes a service proxy
+// RemoveService stops routing requests to a service proxy. Its in-flight
+// requests are drained in the background before its connections are closed.
 func (pm *ProxyManager) RemoveService(name string) {
-	delete(pm.proxies, name)
+	proxy := pm.swap(name, nil)
+
+	// Requests that picked the proxy up before the removal are refused; a
+	// replaced proxy instead serves them
+	if proxy != nil {
+		proxy.drain.close()
+	}
+	go pm.retire(name, proxy)
 	pm.logger.Info("Service proxy removed", zap.String("service", name))
 }
 
 // UpdateService updates a service proxy configuration
 func (pm *ProxyManager) UpdateService(name string, cfg *config.ServiceConfig) error {
-	proxy, err := NewReverseProxy(name, cfg, pm.metrics, pm.logger)
+	if err := pm.checkEgress(cfg); err != nil {
+		return fmt.Errorf("failed to update proxy for service %s: %w", name, err)
+	}
+	if err := config.ValidateFallbackChain(name, cfg.Fallback, pm.fallbackOf); err != nil {
+		return fmt.Errorf("failed to update proxy for service %s: %w", name, err)
+	}
+	proxy, err := NewReverseProxy(name, cfg, pm.localZone, pm.metrics, pm.logger)
 	if err != nil {
 		return fmt.Errorf("failed to update proxy for service %s: %w", name, err)
 	}
+	proxy.fallback = NewFallback(name, cfg.Fallback, pm, pm.logger)
+	proxy.health = newHealthChecker(name, cfg.HealthCheck, proxy, pm.healthSharing, pm.logger)
+	proxy.timeouts.applyDefaults(pm.timeouts)
+	proxy.dns.start()
+	proxy.health.start()
 
-	pm.proxies[name] = proxy
+	go pm.retire(name, pm.swap(name, proxy))
 	pm.logger.Info("Service proxy updated", zap.String("service", name))
 	return nil
 }
 
 // ListServices returns all registered services
 func (pm *ProxyManager) ListServices() []string {
-	services := make([]string, 0, len(pm.proxies))
-	for name := range pm.proxies {
+	proxies := pm.snapshot()
+	services := make([]string, 0, len(proxies))
+	for name := range proxies {
 		services = append(services, name)
 	}
 	return services
 }
 
+// serviceCORS compiles the CORS policy of a service, or returns nil when
+// it uses the gateway-wide policy
+func serviceCORS(cfg config.CORSConfig) *cors.Resolver {
+	if !cfg.Enabled {
+		return nil
+	}
+	return cors.NewResolver(cfg)
+}
+
+// CORSPolicy returns the CORS policy of a request path, and false when the
+// service uses the gateway-wide policy
+func (rp *ReverseProxy) CORSPolicy(path string) (*cors.Policy, bool) {
+	if rp.cors == nil {
+		return nil, false
+	}
+	return rp.cors.Policy(path), true
+}
+
+// Audiences returns the token audiences the service requires, or nil when
+// it accepts the gateway-wide audiences
+func (rp *ReverseProxy) Audiences() []string {
+	return rp.audiences
+}
+
+// Flag returns the feature flag the service is routed behind, or ""
+func (rp *ReverseProxy) Flag() string {
+	return rp.flag
+}
+
+// TargetStatus describes the health of a single upstream target
+type TargetStatus struct {
+	URL     string `json:"url"`
+	Healthy bool   `json:"healthy"`
+	Weight  int    `json:"weight,omitempty"`
+}
+
+// Targets returns the health of every target behind this proxy
+func (rp *ReverseProxy) Targets() []TargetStatus {
+	healthChecker, hasHealth := rp.loadBalancer.(loadbalancer.HealthChecker)
+	weighter, hasWeights := rp.loadBalancer.(loadbalancer.Weighter)
+
+	targets := rp.loadBalancer.GetTargets()
+	statuses := make([]TargetStatus, 0, len(targets))
+	for _, target := range targets {
+		status := TargetStatus{URL: target.String(), Healthy: true}
+		if hasHealth {
+			status.Healthy = healthChecker.IsHealthy(target)
+		}
+		if hasWeights {
+			status.Weight = weighter.GetWeight(target)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// SetTargetWeight changes the weight of a target at runtime. Weights start
+// at 1 like in the configuration, where 0 stands for the default.
+func (rp *ReverseProxy) SetTargetWeight(target string, weight int) error {
+	weighter, ok := rp.loadBalancer.(loadbalancer.Weighter)
+	if !ok {
+		return ErrWeightsUnsupported
+	}
+	if weight < 1 {
+		return fmt.Errorf("weight must be at least 1, got %d", weight)
+	}
+
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("invalid target URL %s: %w", target, err)
+	}
+
+	if !weighter.SetWeight(targetURL, weight) {
+		return fmt.Errorf("%w: %s", ErrTargetNotFound, target)
+	}
+
+	rp.logger.Info("Target weight updated",
+		zap.String("service", rp.serviceName),
+		zap.String("target", target),
+		zap.Int("weight", weight))
+	return nil
+}
+
+// GetTargetHealth returns target health for all registered services
+func (pm *ProxyManager) GetTargetHealth() map[string][]TargetStatus {
+	proxies := pm.snapshot()
+	health := make(map[string][]TargetStatus, len(proxies))
+	for name, proxy := range proxies {
+		health[name] = proxy.Targets()
+	}
+	return health
+}
+
 // GetStats returns proxy statistics
 func (pm *ProxyManager) GetStats() map[string]interface{} {
+	services := pm.ListServices()
 	stats := map[string]interface{}{
-		"services_count": len(pm.proxies),
-		"services":       pm.ListServices(),
+		"services_count": len(services),
+		"services":       services,
+	}
+
+	admission := make(map[string]interface{})
+	for name, proxy := range pm.snapshot() {
+		if proxy.admission != nil {
+			admission[name] = proxy.admission.stats()
+		}
+	}
+	if len(admission) > 0 {
+		stats["admission"] = admission
 	}
 
 	return stats
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"golang.org/x/net/http2"
+)
+
+// Target schemes besides http and https
+const (
+	// schemeUnix targets a Unix domain socket: unix:///run/app.sock
+	schemeUnix = "unix"
+	// schemeH2C targets a server speaking HTTP/2 without TLS: h2c://host:port
+	schemeH2C = "h2c"
+)
+
+// unixSocketHost is the host requests to Unix socket targets are sent to
+const unixSocketHost = "localhost"
+
+// upstreamTransports holds the transports of targets that cannot use the
+// default transport
+type upstreamTransports struct {
+	mu   sync.Mutex
+	unix map[string]*http.Transport // By socket path
+	h2c  *http2.Transport
+}
+
+// newUpstreamTransports creates the transports for unix and h2c targets
+func newUpstreamTransports() *upstreamTransports {
+	return &upstreamTransports{
+		unix: make(map[string]*http.Transport),
+		h2c: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, addr)
+			},
+		},
+	}
+}
+
+// transport returns the transport for a target, or nil if the default
+// transport applies. Each socket gets its own transport so connections to
+// different sockets are never pooled together.
+func (t *upstreamTransports) transport(target *url.URL) http.RoundTripper {
+	switch target.Scheme {
+	case schemeH2C:
+		return t.h2c
+	case schemeUnix:
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		socket := target.Path
+		if transport, ok := t.unix[socket]; ok {
+			return transport
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		t.unix[socket] = transport
+		return transport
+	}
+	return nil
+}
+
+// closeIdle closes the idle connections of the transports
+func (t *upstreamTransports) closeIdle() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, transport := range t.unix {
+		transport.CloseIdleConnections()
+	}
+	t.h2c.CloseIdleConnections()
+}
+
+// proxiedTransport returns a transport sending requests through an HTTP or
+// SOCKS5 proxy
+func proxiedTransport(proxyURL *url.URL) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	return transport
+}
+
+// upstreamURL returns the URL requests to a target are sent to
+func upstreamURL(target *url.URL) *url.URL {
+	switch target.Scheme {
+	case schemeUnix:
+		return &url.URL{Scheme: "http", Host: unixSocketHost}
+	case schemeH2C:
+		upstream := *target
+		upstream.Scheme = "http"
+		return &upstream
+	}
+	return target
+}
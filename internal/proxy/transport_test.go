@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/pkg/egress"
+)
+
+func TestUnixAndH2CTargets(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s %s", r.Proto, r.Host, r.URL.Path)
+	})
+
+	socket := filepath.Join(t.TempDir(), "app.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unixServer := &http.Server{Handler: handler}
+	go unixServer.Serve(listener)
+	defer unixServer.Close()
+
+	h2cServer := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer h2cServer.Close()
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{target: "unix://" + socket, want: "HTTP/1.1 localhost /sidecar/status"},
+		{target: "h2c://" + h2cServer.Listener.Addr().String(), want: "HTTP/2.0 " + h2cServer.Listener.Addr().String() + " /sidecar/status"},
+	}
+	for _, tt := range tests {
+		rp, err := NewReverseProxy("sidecar", &config.ServiceConfig{URLs: []string{tt.target}}, "", nil, zap.NewNop())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		code, err := rp.Forward(w, httptest.NewRequest(http.MethodGet, "/sidecar/status", nil))
+		if err != nil || code != http.StatusOK || w.Body.String() != tt.want {
+			t.Errorf("%s: status %d, err %v, body %q, want %q", tt.target, code, err, w.Body.String(), tt.want)
+		}
+		rp.transports.closeIdle()
+	}
+}
+
+func TestOutboundProxy(t *testing.T) {
+	// An HTTP proxy receives requests with the absolute upstream URL
+	var proxied string
+	outbound := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer outbound.Close()
+
+	rp, err := NewReverseProxy("partner", &config.ServiceConfig{
+		URLs:          []string{"http://partner.example.com"},
+		OutboundProxy: outbound.URL,
+	}, "", nil, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	if code, err := rp.Forward(w, httptest.NewRequest(http.MethodGet, "/partner/quotes", nil)); err != nil || code != http.StatusOK {
+		t.Fatalf("status %d, err %v", code, err)
+	}
+	if proxied != "http://partner.example.com/partner/quotes" {
+		t.Errorf("proxy received %q", proxied)
+	}
+
+	pm := NewProxyManager(zap.NewNop(), nil)
+	allowlist, _ := egress.NewAllowlist([]string{"*.internal"})
+	pm.SetEgress(allowlist)
+	if err := pm.AddService("partner", &config.ServiceConfig{URLs: []string{"http://partner.example.com"}}); err == nil {
+		t.Error("service outside the egress allowlist was added")
+	}
+}
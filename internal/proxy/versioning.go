@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// defaultVersionHeader is read by the header versioning strategy
+const defaultVersionHeader = "X-API-Version"
+
+// ErrUnsupportedVersion is returned when a request names a version the
+// service does not serve
+var ErrUnsupportedVersion = errors.New("unsupported API version")
+
+// vendorVersion matches the version in vendor media types such as
+// application/vnd.example.v2+json
+var vendorVersion = regexp.MustCompile(`^application/vnd\.[^+;]*\.(v\d+)(\+|$)`)
+
+// VersionRoute is the backend and lifecycle of a resolved API version
+type VersionRoute struct {
+	Version string
+	// Service serves the version; empty means the requested service
+	Service    string
+	Deprecated bool
+
+	deprecatedAt time.Time
+	sunset       time.Time
+	link         string
+}
+
+// versionRouter resolves the API version of requests to a service
+type versionRouter struct {
+	cfg    config.VersioningConfig
+	header string
+	routes map[string]VersionRoute
+}
+
+// newVersionRouter builds the version routes of a service, or returns nil
+// when versioning is disabled
+func newVersionRouter(cfg config.VersioningConfig) (*versionRouter, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	vr := &versionRouter{cfg: cfg, header: cfg.Header, routes: make(map[string]VersionRoute, len(cfg.Versions))}
+	if vr.header == "" {
+		vr.header = defaultVersionHeader
+	}
+
+	for version, v := range cfg.Versions {
+		route := VersionRoute{Version: version, Service: v.Service, Deprecated: v.Deprecated, link: v.Link}
+		for _, field := range []struct {
+			value  string
+			target *time.Time
+		}{{v.DeprecatedAt, &route.deprecatedAt}, {v.Sunset, &route.sunset}} {
+			if field.value == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, field.value)
+			if err != nil {
+				return nil, fmt.Errorf("version %s: %w", version, err)
+			}
+			*field.target = t
+		}
+		vr.routes[strings.ToLower(version)] = route
+	}
+	return vr, nil
+}
+
+// resolve finds the route for the version named by the request
+func (vr *versionRouter) resolve(r *http.Request, serviceName string) (VersionRoute, error) {
+	version := vr.requestedVersion(r, serviceName)
+	if version == "" {
+		if vr.cfg.Default == "" {
+			return VersionRoute{}, nil
+		}
+		version = vr.cfg.Default
+	}
+
+	route, ok := vr.routes[strings.ToLower(version)]
+	if !ok {
+		return VersionRoute{}, fmt.Errorf("%w: %s", ErrUnsupportedVersion, version)
+	}
+	return route, nil
+}
+
+// requestedVersion extracts the version from the request per the strategy
+func (vr *versionRouter) requestedVersion(r *http.Request, serviceName string) string {
+	switch vr.cfg.Strategy {
+	case "path":
+		// /service/v1/...
+		rest := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"), serviceName)
+		segment, _, _ := strings.Cut(strings.TrimPrefix(rest, "/"), "/")
+		if isVersion(segment) {
+			return segment
+		}
+	case "accept":
+		for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+			if err != nil {
+				continue
+			}
+			if v := params["version"]; v != "" {
+				return normalizeVersion(v)
+			}
+			if m := vendorVersion.FindStringSubmatch(mediaType); m != nil {
+				return m[1]
+			}
+		}
+	case "header":
+		if v := strings.TrimSpace(r.Header.Get(vr.header)); v != "" {
+			return normalizeVersion(v)
+		}
+	}
+	return ""
+}
+
+// isVersion reports whether a path segment looks like v1, v2, ...
+func isVersion(segment string) bool {
+	if len(segment) < 2 || (segment[0] != 'v' && segment[0] != 'V') {
+		return false
+	}
+	for _, c := range segment[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeVersion turns a bare number such as "2" into "v2"
+func normalizeVersion(v string) string {
+	if isVersion("v" + v) {
+		return "v" + v
+	}
+	return v
+}
+
+// SetHeaders adds the Deprecation, Sunset and Link headers of a deprecated
+// version (RFC 9745 and RFC 8594)
+func (vr VersionRoute) SetHeaders(h http.Header) {
+	if !vr.Deprecated {
+		return
+	}
+
+	if vr.deprecatedAt.IsZero() {
+		h.Set("Deprecation", "true")
+	} else {
+		h.Set("Deprecation", fmt.Sprintf("@%d", vr.deprecatedAt.Unix()))
+	}
+	if !vr.sunset.IsZero() {
+		h.Set("Sunset", vr.sunset.UTC().Format(http.TimeFormat))
+	}
+	if vr.link != "" {
+		h.Add("Link", fmt.Sprintf(`<%s>; rel="deprecation"`, vr.link))
+	}
+}
+
+// ResolveVersion returns the API version route of the request. Services
+// without versioning return an empty route.
+func (rp *ReverseProxy) ResolveVersion(r *http.Request) (VersionRoute, error) {
+	if rp.versions == nil {
+		return VersionRoute{}, nil
+	}
+	return rp.versions.resolve(r, rp.serviceName)
+}
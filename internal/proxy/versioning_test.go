@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestVersionRouterResolve(t *testing.T) {
+	versions := map[string]config.APIVersionConfig{
+		"v1": {Deprecated: true, Sunset: "2026-01-01T00:00:00Z", Link: "https://docs.example.com/v2"},
+		"v2": {Service: "orders_v2"},
+	}
+
+	tests := []struct {
+		name     string
+		strategy string
+		path     string
+		header   string
+		value    string
+		want     string
+		wantErr  bool
+	}{
+		{"path", "path", "/orders/v2/items", "", "", "v2", false},
+		{"path without version uses default", "path", "/orders/items", "", "", "v1", false},
+		{"accept parameter", "accept", "/orders", "Accept", "application/json; version=2", "v2", false},
+		{"accept vendor type", "accept", "/orders", "Accept", "application/vnd.orders.v1+json", "v1", false},
+		{"header", "header", "/orders", "X-API-Version", "2", "v2", false},
+		{"unknown version", "header", "/orders", "X-API-Version", "v9", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vr, err := newVersionRouter(config.VersioningConfig{
+				Enabled: true, Strategy: tt.strategy, Default: "v1", Versions: versions,
+			})
+			if err != nil {
+				t.Fatalf("newVersionRouter() error = %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+
+			route, err := vr.resolve(req, "orders")
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnsupportedVersion) {
+					t.Fatalf("resolve() error = %v, want ErrUnsupportedVersion", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolve() error = %v", err)
+			}
+			if route.Version != tt.want {
+				t.Errorf("resolve() version = %q, want %q", route.Version, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersionRouteSetHeaders(t *testing.T) {
+	vr, err := newVersionRouter(config.VersioningConfig{
+		Enabled:  true,
+		Strategy: "header",
+		Versions: map[string]config.APIVersionConfig{
+			"v1": {Deprecated: true, DeprecatedAt: "2025-01-01T00:00:00Z", Sunset: "2026-01-01T00:00:00Z", Link: "https://docs.example.com/v2"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("newVersionRouter() error = %v", err)
+	}
+
+	h := http.Header{}
+	vr.routes["v1"].SetHeaders(h)
+
+	if got := h.Get("Deprecation"); got != "@1735689600" {
+		t.Errorf("Deprecation = %q, want @1735689600", got)
+	}
+	if got := h.Get("Sunset"); got != "Thu, 01 Jan 2026 00:00:00 GMT" {
+		t.Errorf("Sunset = %q", got)
+	}
+	if got := h.Get("Link"); got != `<https://docs.example.com/v2>; rel="deprecation"` {
+		t.Errorf("Link = %q", got)
+	}
+}
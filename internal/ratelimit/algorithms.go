@@ -3,6 +3,7 @@ package ratelimit
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
@@ -131,6 +132,28 @@ func (sw *SlidingWindow) Allow(key string) (bool, error) {
 	return true, nil
 }
 
+// Count returns the requests allowed for a key in the current window
+func (sw *SlidingWindow) Count(key string) (int, error) {
+	sw.mu.RLock()
+	window, exists := sw.windows[key]
+	sw.mu.RUnlock()
+	if !exists {
+		return 0, nil
+	}
+
+	window.mu.RLock()
+	defer window.mu.RUnlock()
+
+	cutoff := time.Now().Add(-sw.window)
+	count := 0
+	for _, t := range window.requests {
+		if !t.Before(cutoff) {
+			count++
+		}
+	}
+	return count, nil
+}
+
 // Reset resets the rate limiter for a key
 func (sw *SlidingWindow) Reset(key string) error {
 	sw.mu.Lock()
@@ -208,6 +231,24 @@ func (fw *FixedWindow) Allow(key string) (bool, error) {
 	return true, nil
 }
 
+// Count returns the requests allowed for a key in the current window
+func (fw *FixedWindow) Count(key string) (int, error) {
+	fw.mu.RLock()
+	counter, exists := fw.counters[key]
+	fw.mu.RUnlock()
+	if !exists {
+		return 0, nil
+	}
+
+	counter.mu.RLock()
+	defer counter.mu.RUnlock()
+
+	if time.Now().Truncate(fw.window).After(counter.window) {
+		return 0, nil
+	}
+	return counter.count, nil
+}
+
 // Reset resets the rate limiter for a key
 func (fw *FixedWindow) Reset(key string) error {
 	fw.mu.Lock()
@@ -217,6 +258,59 @@ func (fw *FixedWindow) Reset(key string) error {
 	return nil
 }
 
+// SpikeArrest paces requests strictly: a key may make one request per
+// interval, so a limit of 100 per second allows one request every 10ms with
+// no bursts
+type SpikeArrest struct {
+	last     map[string]time.Time
+	interval time.Duration
+	mu       sync.Mutex
+	logger   *zap.Logger
+}
+
+// NewSpikeArrest creates a new spike arrest rate limiter spreading limit
+// requests evenly over the window
+func NewSpikeArrest(limit int, window time.Duration, logger *zap.Logger) *SpikeArrest {
+	var interval time.Duration
+	if limit > 0 {
+		interval = window / time.Duration(limit)
+	}
+	return &SpikeArrest{
+		last:     make(map[string]time.Time),
+		interval: interval,
+		logger:   logger,
+	}
+}
+
+// Allow checks if a request is allowed
+func (sa *SpikeArrest) Allow(key string) (bool, error) {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+
+	now := time.Now()
+	if last, exists := sa.last[key]; exists {
+		if elapsed := now.Sub(last); elapsed < sa.interval {
+			sa.logger.Debug("Spike arrest limit exceeded",
+				zap.String("key", key),
+				zap.Duration("elapsed", elapsed),
+				zap.Duration("interval", sa.interval))
+			return false, nil
+		}
+	}
+
+	sa.last[key] = now
+	return true, nil
+}
+
+// Reset resets the rate limiter for a key
+func (sa *SpikeArrest) Reset(key string) error {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+
+	delete(sa.last, key)
+	return nil
+}
+
 // DistributedRateLimit implements distributed rate limiting using Redis
 type DistributedRateLimit struct {
 	client *redis.Client
@@ -291,6 +385,16 @@ func (drl *DistributedRateLimit) Allow(key string) (bool, error) {
 	return allowed, nil
 }
 
+// Count returns the requests allowed for a key in the current window
+func (drl *DistributedRateLimit) Count(key string) (int, error) {
+	since := time.Now().Add(-drl.window).UnixMilli()
+	count, err := drl.client.ZCount(context.Background(), key, strconv.FormatInt(since, 10), "+inf").Result()
+	if err != nil {
+		return 0, fmt.Errorf("rate limit count error: %w", err)
+	}
+	return int(count), nil
+}
+
 // Reset resets the rate limiter for a key
 func (drl *DistributedRateLimit) Reset(key string) error {
 	return drl.client.Del(context.Background(), key).Err()
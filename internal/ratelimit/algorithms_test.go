@@ -84,6 +84,32 @@ func TestFixedWindow_Allow(t *testing.T) {
 	}
 }
 
+func TestSpikeArrest_Allow(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	sa := NewSpikeArrest(20, 1*time.Second, logger) // one request every 50ms
+
+	allowed, err := sa.Allow("test-key")
+	if err != nil {
+		t.Fatalf("Spike arrest error: %v", err)
+	}
+	if !allowed {
+		t.Error("Expected first request to be allowed")
+	}
+
+	// Test no burst within the interval, even with quota left
+	if allowed, _ := sa.Allow("test-key"); allowed {
+		t.Error("Expected request within the interval to be denied")
+	}
+	if allowed, _ := sa.Allow("other-key"); !allowed {
+		t.Error("Expected request for another key to be allowed")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if allowed, _ := sa.Allow("test-key"); !allowed {
+		t.Error("Expected request after the interval to be allowed")
+	}
+}
+
 func TestRateLimitManager_CheckLimit(t *testing.T) {
 	logger, _ := zap.NewDevelopment()
 
@@ -120,3 +146,54 @@ func TestRateLimitManager_CheckLimit(t *testing.T) {
 		t.Error("Expected request to be denied after limit")
 	}
 }
+
+func TestRateLimitManager_CheckDescribesRejection(t *testing.T) {
+	logger := zap.NewNop()
+	manager := NewManager(&config.RateLimitConfig{
+		Enabled:   true,
+		Algorithm: "fixed_window",
+		Default:   config.RateLimitRule{Requests: 2, Window: time.Minute},
+		PerUser: map[string]config.RateLimitRule{
+			"alice": {Requests: 1, Window: time.Hour},
+		},
+		Offenders: config.OffendersConfig{Window: time.Hour, MaxKeys: 2},
+	}, nil, logger)
+
+	manager.Check("user:alice")
+	decision, err := manager.Check("user:alice")
+	if err != nil {
+		t.Fatalf("Rate limit check error: %v", err)
+	}
+	if decision.Allowed || decision.Rule != "user:alice" || decision.Limit != 1 || decision.Window != time.Hour || decision.Count != 1 {
+		t.Errorf("unexpected decision %+v", decision)
+	}
+
+	decision, _ = manager.Check("10.0.0.1")
+	if !decision.Allowed || decision.Rule != "default" || decision.Limit != 2 {
+		t.Errorf("unexpected decision %+v", decision)
+	}
+}
+
+func TestOffenders_Top(t *testing.T) {
+	offenders := NewOffenders(time.Hour, 2)
+
+	offenders.Record(Rejection{Key: "a", KeyType: "ip", Route: "/orders/*"})
+	if n := offenders.Record(Rejection{Key: "b", KeyType: "user", Route: "/users/*"}); n != 1 {
+		t.Errorf("expected 1 rejection, got %d", n)
+	}
+	if n := offenders.Record(Rejection{Key: "b", KeyType: "user", Route: "/admin/stats"}); n != 2 {
+		t.Errorf("expected 2 rejections, got %d", n)
+	}
+	// Both tracked keys are active, so a third is not tracked
+	if n := offenders.Record(Rejection{Key: "c"}); n != 0 {
+		t.Errorf("expected an untracked key, got %d rejections", n)
+	}
+
+	top := offenders.Top(10)
+	if len(top) != 2 || top[0].Key != "b" || top[0].Rejections != 2 || top[0].LastRoute != "/admin/stats" || top[1].Key != "a" {
+		t.Fatalf("unexpected offenders %+v", top)
+	}
+	if top := offenders.Top(1); len(top) != 1 || top[0].Key != "b" {
+		t.Errorf("expected only the top offender, got %+v", top)
+	}
+}
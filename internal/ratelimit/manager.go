@@ -2,6 +2,9 @@ package ratelimit
 
 import (
 	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -12,17 +15,25 @@ import (
 
 // Manager manages rate limiting for the gateway
 type Manager struct {
-	algorithms map[string]Algorithm
-	config     *config.RateLimitConfig
-	logger     *zap.Logger
+	algorithms  map[string]Algorithm
+	config      *config.RateLimitConfig
+	redisClient *redis.Client
+	offenders   *Offenders
+	logger      *zap.Logger
+	mu          sync.RWMutex
+
+	allowedCount int64
+	deniedCount  int64
 }
 
 // NewManager creates a new rate limit manager
 func NewManager(cfg *config.RateLimitConfig, redisClient *redis.Client, logger *zap.Logger) *Manager {
 	manager := &Manager{
-		algorithms: make(map[string]Algorithm),
-		config:     cfg,
-		logger:     logger,
+		algorithms:  make(map[string]Algorithm),
+		config:      cfg,
+		redisClient: redisClient,
+		offenders:   NewOffenders(cfg.Offenders.Window, cfg.Offenders.MaxKeys),
+		logger:      logger,
 	}
 
 	// Initialize algorithms based on configuration
@@ -54,6 +65,12 @@ func (m *Manager) initializeAlgorithms(redisClient *redis.Client) {
 			m.config.Default.Window,
 			m.logger,
 		)
+	case "spike_arrest":
+		m.algorithms["default"] = NewSpikeArrest(
+			m.config.Default.Requests,
+			m.config.Default.Window,
+			m.logger,
+		)
 	case "distributed":
 		if redisClient != nil {
 			m.algorithms["default"] = NewDistributedRateLimit(
@@ -89,6 +106,8 @@ func (m *Manager) initializeAlgorithms(redisClient *redis.Client) {
 			m.algorithms[key] = NewSlidingWindow(rule.Requests, rule.Window, m.logger)
 		case "fixed_window":
 			m.algorithms[key] = NewFixedWindow(rule.Requests, rule.Window, m.logger)
+		case "spike_arrest":
+			m.algorithms[key] = NewSpikeArrest(rule.Requests, rule.Window, m.logger)
 		case "distributed":
 			if redisClient != nil {
 				m.algorithms[key] = NewDistributedRateLimit(redisClient, rule.Requests, rule.Window, m.logger)
@@ -106,6 +125,8 @@ func (m *Manager) initializeAlgorithms(redisClient *redis.Client) {
 			m.algorithms[key] = NewSlidingWindow(rule.Requests, rule.Window, m.logger)
 		case "fixed_window":
 			m.algorithms[key] = NewFixedWindow(rule.Requests, rule.Window, m.logger)
+		case "spike_arrest":
+			m.algorithms[key] = NewSpikeArrest(rule.Requests, rule.Window, m.logger)
 		case "distributed":
 			if redisClient != nil {
 				m.algorithms[key] = NewDistributedRateLimit(redisClient, rule.Requests, rule.Window, m.logger)
@@ -118,25 +139,84 @@ func (m *Manager) initializeAlgorithms(redisClient *redis.Client) {
 		zap.Int("algorithms_count", len(m.algorithms)))
 }
 
+// Decision is the outcome of a rate limit check
+type Decision struct {
+	Allowed bool
+	// Rule is the rule applied: "default", "user:<id>" or "service:<id>"
+	Rule   string
+	Limit  int
+	Window time.Duration
+	// Count is the number of requests counted against the key in the
+	// current window, or -1 when the algorithm does not count requests
+	Count int
+}
+
+// counter is implemented by algorithms that count requests per window
+type counter interface {
+	Count(key string) (int, error)
+}
+
 // CheckLimit checks if a request is allowed for the given key
 func (m *Manager) CheckLimit(key string) (bool, error) {
-	if !m.config.Enabled {
-		return true, nil
-	}
+	decision, err := m.Check(key)
+	return decision.Allowed, err
+}
 
+// Check checks if a request is allowed for the given key and describes the
+// rule that decided. The count is only looked up for rejected requests.
+func (m *Manager) Check(key string) (Decision, error) {
+	m.mu.RLock()
+	enabled := m.config.Enabled
 	// Try to find specific algorithm for the key
+	decision := Decision{Allowed: true, Rule: key, Count: -1}
 	algorithm, exists := m.algorithms[key]
 	if !exists {
 		// Fall back to default algorithm
 		algorithm = m.algorithms["default"]
+		decision.Rule = "default"
+	}
+	rule := m.config.Default
+	if id, ok := strings.CutPrefix(decision.Rule, "user:"); ok {
+		rule = m.config.PerUser[id]
+	} else if id, ok := strings.CutPrefix(decision.Rule, "service:"); ok {
+		rule = m.config.PerService[id]
+	}
+	decision.Limit = rule.Requests
+	decision.Window = rule.Window
+	m.mu.RUnlock()
+
+	if !enabled {
+		return decision, nil
 	}
 
 	if algorithm == nil {
 		m.logger.Warn("No rate limiting algorithm available", zap.String("key", key))
-		return true, nil
+		return decision, nil
 	}
 
-	return algorithm.Allow(key)
+	allowed, err := algorithm.Allow(key)
+	if err != nil {
+		decision.Allowed = false
+		return decision, err
+	}
+	decision.Allowed = allowed
+	if allowed {
+		atomic.AddInt64(&m.allowedCount, 1)
+		return decision, nil
+	}
+
+	atomic.AddInt64(&m.deniedCount, 1)
+	if c, ok := algorithm.(counter); ok {
+		if count, err := c.Count(key); err == nil {
+			decision.Count = count
+		}
+	}
+	return decision, nil
+}
+
+// Offenders returns the tracker of rejected keys
+func (m *Manager) Offenders() *Offenders {
+	return m.offenders
 }
 
 // CheckUserLimit checks rate limit for a specific user
@@ -165,6 +245,9 @@ func (m *Manager) CheckAPIKeyLimit(apiKey string) (bool, error) {
 
 // Reset resets the rate limiter for a key
 func (m *Manager) Reset(key string) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	algorithm, exists := m.algorithms[key]
 	if !exists {
 		algorithm = m.algorithms["default"]
@@ -179,6 +262,9 @@ func (m *Manager) Reset(key string) error {
 
 // GetLimitInfo returns rate limit information for a key
 func (m *Manager) GetLimitInfo(key string) (*LimitInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	if !m.config.Enabled {
 		return &LimitInfo{
 			Limit:     -1,
@@ -216,7 +302,11 @@ type LimitInfo struct {
 
 // UpdateConfig updates the rate limiting configuration
 func (m *Manager) UpdateConfig(cfg *config.RateLimitConfig, redisClient *redis.Client) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	m.config = cfg
+	m.redisClient = redisClient
 
 	// Clear existing algorithms
 	m.algorithms = make(map[string]Algorithm)
@@ -229,13 +319,28 @@ func (m *Manager) UpdateConfig(cfg *config.RateLimitConfig, redisClient *redis.C
 	m.logger.Info("Rate limiting configuration updated")
 }
 
+// SetConfig replaces the rate limiting configuration, keeping the Redis
+// client. Counters start over under the new rules.
+func (m *Manager) SetConfig(cfg *config.RateLimitConfig) {
+	m.mu.RLock()
+	redisClient := m.redisClient
+	m.mu.RUnlock()
+
+	m.UpdateConfig(cfg, redisClient)
+}
+
 // IsEnabled returns whether rate limiting is enabled
 func (m *Manager) IsEnabled() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	return m.config.Enabled
 }
 
 // GetStats returns rate limiting statistics
 func (m *Manager) GetStats() map[string]interface{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	stats := map[string]interface{}{
 		"enabled":           m.config.Enabled,
 		"algorithm":         m.config.Algorithm,
@@ -244,6 +349,8 @@ func (m *Manager) GetStats() map[string]interface{} {
 		"default_window":    m.config.Default.Window.String(),
 		"per_user_rules":    len(m.config.PerUser),
 		"per_service_rules": len(m.config.PerService),
+		"allowed_total":     atomic.LoadInt64(&m.allowedCount),
+		"denied_total":      atomic.LoadInt64(&m.deniedCount),
 	}
 
 	return stats
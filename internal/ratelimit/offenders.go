@@ -0,0 +1,157 @@
+package ratelimit
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// offenderBuckets is the number of slices the offender window is counted
+// in, so old rejections age out gradually
+const offenderBuckets = 60
+
+// Rejection is a request rejected by the rate limiter
+type Rejection struct {
+	Key      string
+	KeyType  string
+	Rule     string
+	Route    string
+	ClientIP string
+}
+
+// Offender is a key's rate limit rejections over the offender window
+type Offender struct {
+	Key          string    `json:"key"`
+	KeyType      string    `json:"key_type"`
+	Rule         string    `json:"rule"`
+	Rejections   int       `json:"rejections"`
+	LastRoute    string    `json:"last_route"`
+	LastClientIP string    `json:"last_client_ip"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// offender is a tracked key with its rejections per bucket
+type offender struct {
+	Offender
+	counts [offenderBuckets]int
+	epochs [offenderBuckets]int64
+}
+
+// rejections returns the offender's rejections in the window ending at epoch
+func (o *offender) rejections(epoch int64) int {
+	total := 0
+	for i, count := range o.counts {
+		if o.epochs[i] > epoch-offenderBuckets {
+			total += count
+		}
+	}
+	return total
+}
+
+// Offenders counts rate limit rejections per key over a rolling window to
+// find the top offenders. Only rejections on this instance are counted.
+// Once maxKeys keys are tracked, new keys are ignored until others have
+// had no rejections for a whole window.
+type Offenders struct {
+	window  time.Duration
+	maxKeys int
+	mu      sync.Mutex
+	keys    map[string]*offender
+	pruned  int64 // Epoch of the last prune
+}
+
+// NewOffenders creates a tracker of rejections over window
+func NewOffenders(window time.Duration, maxKeys int) *Offenders {
+	if window < offenderBuckets {
+		window = offenderBuckets
+	}
+	return &Offenders{
+		window:  window,
+		maxKeys: maxKeys,
+		keys:    make(map[string]*offender),
+	}
+}
+
+// Window returns the window rejections are counted over
+func (o *Offenders) Window() time.Duration {
+	return o.window
+}
+
+// epoch returns the bucket number of t
+func (o *Offenders) epoch(t time.Time) int64 {
+	return t.UnixNano() / int64(o.window/offenderBuckets)
+}
+
+// Record counts a rejection and returns the key's rejections in the window,
+// 0 when the key is not tracked
+func (o *Offenders) Record(rejection Rejection) int {
+	now := time.Now()
+	epoch := o.epoch(now)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	off, exists := o.keys[rejection.Key]
+	if !exists {
+		if len(o.keys) >= o.maxKeys && o.pruned != epoch {
+			o.prune(epoch)
+		}
+		if len(o.keys) >= o.maxKeys {
+			return 0
+		}
+		off = &offender{Offender: Offender{Key: rejection.Key}}
+		o.keys[rejection.Key] = off
+	}
+
+	off.KeyType = rejection.KeyType
+	off.Rule = rejection.Rule
+	off.LastRoute = rejection.Route
+	off.LastClientIP = rejection.ClientIP
+	off.LastSeen = now
+
+	i := epoch % offenderBuckets
+	if off.epochs[i] != epoch {
+		off.epochs[i] = epoch
+		off.counts[i] = 0
+	}
+	off.counts[i]++
+	return off.rejections(epoch)
+}
+
+// prune drops keys without rejections in the window ending at epoch
+func (o *Offenders) prune(epoch int64) {
+	o.pruned = epoch
+	for key, off := range o.keys {
+		if off.rejections(epoch) == 0 {
+			delete(o.keys, key)
+		}
+	}
+}
+
+// Top returns up to n keys with the most rejections in the window, most
+// rejected first
+func (o *Offenders) Top(n int) []Offender {
+	epoch := o.epoch(time.Now())
+
+	o.mu.Lock()
+	offenders := make([]Offender, 0, len(o.keys))
+	for _, off := range o.keys {
+		if rejections := off.rejections(epoch); rejections > 0 {
+			entry := off.Offender
+			entry.Rejections = rejections
+			offenders = append(offenders, entry)
+		}
+	}
+	o.mu.Unlock()
+
+	sort.Slice(offenders, func(i, j int) bool {
+		if offenders[i].Rejections != offenders[j].Rejections {
+			return offenders[i].Rejections > offenders[j].Rejections
+		}
+		return offenders[i].Key < offenders[j].Key
+	})
+	if len(offenders) > n {
+		offenders = offenders[:n]
+	}
+	return offenders
+}
@@ -0,0 +1,372 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/internal/proxy"
+)
+
+// ErrNotFound is returned for a registration that does not exist or expired
+var ErrNotFound = errors.New("registration not found")
+
+// ErrConflict is returned when a registration names a service that was not
+// registered, such as a configured one
+var ErrConflict = errors.New("service is not managed by the registry")
+
+// ErrInvalid is returned for a malformed registration
+var ErrInvalid = errors.New("invalid registration")
+
+// serviceName restricts registered names to a single path segment
+var serviceName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Registration is a backend instance registered for a service
+type Registration struct {
+	// ID identifies the instance; it defaults to a hash of the service and
+	// URLs, so registering again renews the same registration
+	ID         string    `json:"id"`
+	Service    string    `json:"service"`
+	URLs       []string  `json:"urls"`
+	HealthPath string    `json:"health_path,omitempty"`
+	TTLSeconds int       `json:"ttl_seconds"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// ttl returns how long a heartbeat keeps the registration alive
+func (r *Registration) ttl() time.Duration {
+	return time.Duration(r.TTLSeconds) * time.Second
+}
+
+// Notifier is told about registrations made or removed on this replica
+type Notifier func(r Registration, removed bool)
+
+// Registry keeps the services of registered backends in the proxy manager.
+// A service lists the URLs of every live registration, and is removed when
+// its last registration expires or is removed.
+type Registry struct {
+	cfg      config.RegistryConfig
+	proxies  *proxy.ProxyManager
+	logger   *zap.Logger
+	notifier Notifier
+
+	mu      sync.Mutex
+	entries map[string]*Registration
+	// owned maps the services the registry created to their applied
+	// configuration, so renewals do not rebuild the proxy
+	owned map[string]string
+	stop  chan struct{}
+}
+
+// New creates a service registry
+func New(cfg config.RegistryConfig, proxies *proxy.ProxyManager, logger *zap.Logger) *Registry {
+	return &Registry{
+		cfg:     cfg,
+		proxies: proxies,
+		logger:  logger,
+		entries: make(map[string]*Registration),
+		owned:   make(map[string]string),
+	}
+}
+
+// SetNotifier reports the registrations made or removed on this replica
+// to notifier, e.g. to share them with the other replicas
+func (r *Registry) SetNotifier(notifier Notifier) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notifier = notifier
+}
+
+// Register adds or renews a registration and returns it
+func (r *Registry) Register(reg Registration) (Registration, error) {
+	if err := r.normalize(&reg); err != nil {
+		return Registration{}, err
+	}
+
+	r.mu.Lock()
+	if _, owned := r.owned[reg.Service]; !owned && r.proxies.GetProxy(reg.Service) != nil {
+		r.mu.Unlock()
+		return Registration{}, fmt.Errorf("%w: %s", ErrConflict, reg.Service)
+	}
+	previous := r.entries[reg.ID]
+	entry := reg
+	r.entries[reg.ID] = &entry
+	if err := r.syncLocked(reg.Service); err != nil {
+		if previous != nil {
+			r.entries[reg.ID] = previous
+		} else {
+			delete(r.entries, reg.ID)
+		}
+		r.mu.Unlock()
+		return Registration{}, err
+	}
+	if previous != nil && previous.Service != reg.Service {
+		r.resyncLocked(previous.Service)
+	}
+	notifier := r.notifier
+	r.mu.Unlock()
+
+	if previous == nil {
+		r.logger.Info("Backend registered",
+			zap.String("service", reg.Service),
+			zap.String("id", reg.ID),
+			zap.Strings("urls", reg.URLs))
+	}
+	if notifier != nil {
+		notifier(reg, false)
+	}
+	return reg, nil
+}
+
+// Heartbeat renews a registration for its TTL
+func (r *Registry) Heartbeat(id string) (Registration, error) {
+	r.mu.Lock()
+	entry, ok := r.entries[id]
+	if !ok || !entry.ExpiresAt.After(time.Now()) {
+		r.mu.Unlock()
+		return Registration{}, ErrNotFound
+	}
+	entry.ExpiresAt = time.Now().Add(entry.ttl())
+	reg := *entry
+	notifier := r.notifier
+	r.mu.Unlock()
+
+	if notifier != nil {
+		notifier(reg, false)
+	}
+	return reg, nil
+}
+
+// Deregister removes a registration
+func (r *Registry) Deregister(id string) error {
+	r.mu.Lock()
+	entry, ok := r.entries[id]
+	if !ok {
+		r.mu.Unlock()
+		return ErrNotFound
+	}
+	reg := *entry
+	r.removeLocked(reg)
+	notifier := r.notifier
+	r.mu.Unlock()
+
+	r.logger.Info("Backend deregistered", zap.String("service", reg.Service), zap.String("id", id))
+	if notifier != nil {
+		notifier(reg, true)
+	}
+	return nil
+}
+
+// Apply applies a registration made or removed on another replica
+func (r *Registry) Apply(reg Registration, removed bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if removed || !reg.ExpiresAt.After(time.Now()) {
+		if _, ok := r.entries[reg.ID]; ok {
+			r.removeLocked(reg)
+		}
+		return nil
+	}
+	if _, owned := r.owned[reg.Service]; !owned && r.proxies.GetProxy(reg.Service) != nil {
+		return fmt.Errorf("%w: %s", ErrConflict, reg.Service)
+	}
+	previous := r.entries[reg.ID]
+	r.entries[reg.ID] = &reg
+	if previous != nil && previous.Service != reg.Service {
+		r.resyncLocked(previous.Service)
+	}
+	return r.syncLocked(reg.Service)
+}
+
+// List returns the live registrations by service and ID
+func (r *Registry) List() []Registration {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	regs := make([]Registration, 0, len(r.entries))
+	for _, entry := range r.entries {
+		if entry.ExpiresAt.After(now) {
+			regs = append(regs, *entry)
+		}
+	}
+	sort.Slice(regs, func(i, j int) bool {
+		if regs[i].Service != regs[j].Service {
+			return regs[i].Service < regs[j].Service
+		}
+		return regs[i].ID < regs[j].ID
+	})
+	return regs
+}
+
+// Start removes expired registrations every sweep interval until Stop
+func (r *Registry) Start() {
+	r.mu.Lock()
+	if r.stop != nil {
+		r.mu.Unlock()
+		return
+	}
+	r.stop = make(chan struct{})
+	stop := r.stop
+	r.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(r.cfg.SweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				r.sweep()
+			}
+		}
+	}()
+}
+
+// Stop stops removing expired registrations
+func (r *Registry) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+}
+
+// sweep removes the registrations whose heartbeats stopped. Every replica
+// sweeps on its own, so expiries are not announced.
+func (r *Registry) sweep() {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, entry := range r.entries {
+		if entry.ExpiresAt.After(now) {
+			continue
+		}
+		r.logger.Warn("Backend registration expired",
+			zap.String("service", entry.Service),
+			zap.String("id", entry.ID))
+		r.removeLocked(*entry)
+	}
+}
+
+// removeLocked drops a registration and updates its service. The caller
+// must hold mu.
+func (r *Registry) removeLocked(reg Registration) {
+	delete(r.entries, reg.ID)
+	r.resyncLocked(reg.Service)
+}
+
+// resyncLocked updates a service that lost a registration. The caller must
+// hold mu.
+func (r *Registry) resyncLocked(service string) {
+	if err := r.syncLocked(service); err != nil {
+		r.logger.Error("Failed to update registered service",
+			zap.String("service", service),
+			zap.Error(err))
+	}
+}
+
+// syncLocked points a service at the URLs of its live registrations, or
+// removes it when none is left. The caller must hold mu.
+func (r *Registry) syncLocked(service string) error {
+	now := time.Now()
+	var ids []string
+	for id, entry := range r.entries {
+		if entry.Service == service && entry.ExpiresAt.After(now) {
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) == 0 {
+		if _, owned := r.owned[service]; owned {
+			delete(r.owned, service)
+			r.proxies.RemoveService(service)
+		}
+		return nil
+	}
+
+	// Apply registrations in a stable order so every replica builds the
+	// same configuration
+	sort.Strings(ids)
+	cfg := &config.ServiceConfig{LoadBalancer: "least_connections"}
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		entry := r.entries[id]
+		for _, target := range entry.URLs {
+			if !seen[target] {
+				seen[target] = true
+				cfg.URLs = append(cfg.URLs, target)
+			}
+		}
+		if entry.HealthPath != "" && !cfg.HealthCheck.Enabled {
+			cfg.HealthCheck = config.HealthCheckConfig{Enabled: true, Path: entry.HealthPath}
+		}
+	}
+
+	fingerprint := cfg.HealthCheck.Path + " " + strings.Join(cfg.URLs, " ")
+	if applied, owned := r.owned[service]; owned && applied == fingerprint {
+		return nil
+	}
+	if err := r.proxies.UpdateService(service, cfg); err != nil {
+		return err
+	}
+	r.owned[service] = fingerprint
+	return nil
+}
+
+// normalize validates a registration and fills in its ID, TTL and expiry
+func (r *Registry) normalize(reg *Registration) error {
+	if !serviceName.MatchString(reg.Service) {
+		return fmt.Errorf("%w: service name must be letters, digits, - or _", ErrInvalid)
+	}
+	if reg.ID != "" && !serviceName.MatchString(reg.ID) {
+		return fmt.Errorf("%w: id must be letters, digits, - or _", ErrInvalid)
+	}
+	if len(reg.URLs) == 0 {
+		return fmt.Errorf("%w: at least one URL is required", ErrInvalid)
+	}
+	for _, rawURL := range reg.URLs {
+		u, err := url.Parse(rawURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: %q is not an http or https URL", ErrInvalid, rawURL)
+		}
+	}
+	if reg.HealthPath != "" && !strings.HasPrefix(reg.HealthPath, "/") {
+		return fmt.Errorf("%w: health_path must start with /", ErrInvalid)
+	}
+	if reg.TTLSeconds < 0 {
+		return fmt.Errorf("%w: ttl_seconds must not be negative", ErrInvalid)
+	}
+
+	ttl := reg.ttl()
+	if ttl == 0 {
+		ttl = r.cfg.DefaultTTL
+	}
+	if ttl > r.cfg.MaxTTL {
+		ttl = r.cfg.MaxTTL
+	}
+	reg.TTLSeconds = int((ttl + time.Second - 1) / time.Second)
+	reg.ExpiresAt = time.Now().Add(reg.ttl())
+
+	if reg.ID == "" {
+		urls := append([]string{}, reg.URLs...)
+		sort.Strings(urls)
+		sum := sha256.Sum256([]byte(reg.Service + "\n" + strings.Join(urls, "\n")))
+		reg.ID = reg.Service + "-" + hex.EncodeToString(sum[:6])
+	}
+	return nil
+}
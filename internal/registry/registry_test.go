@@ -0,0 +1,121 @@
+package registry
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/internal/proxy"
+)
+
+func newTestRegistry(t *testing.T) (*Registry, *proxy.ProxyManager) {
+	pm := proxy.NewProxyManager(zap.NewNop(), nil)
+	if err := pm.AddService("users", &config.ServiceConfig{URLs: []string{"http://users:8080"}}); err != nil {
+		t.Fatalf("AddService() error = %v", err)
+	}
+	cfg := config.RegistryConfig{DefaultTTL: 30 * time.Second, MaxTTL: time.Minute, SweepInterval: time.Second}
+	return New(cfg, pm, zap.NewNop()), pm
+}
+
+func targetURLs(rp *proxy.ReverseProxy) []string {
+	var urls []string
+	for _, target := range rp.Targets() {
+		urls = append(urls, target.URL)
+	}
+	return urls
+}
+
+func TestRegistry(t *testing.T) {
+	r, pm := newTestRegistry(t)
+
+	first, err := r.Register(Registration{Service: "orders", URLs: []string{"http://orders-1:8080"}, TTLSeconds: 600})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if first.ID == "" || first.TTLSeconds != 60 {
+		t.Errorf("Register() = %+v, want an ID and the TTL capped at 60s", first)
+	}
+	if _, err := r.Register(Registration{ID: "orders-2", Service: "orders", URLs: []string{"http://orders-2:8080"}, HealthPath: "/ready"}); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	rp := pm.GetProxy("orders")
+	if rp == nil {
+		t.Fatal("registered service is not routed")
+	}
+	if urls := targetURLs(rp); len(urls) != 2 {
+		t.Errorf("targets = %v, want both instances", urls)
+	}
+
+	// Renewals keep the proxy
+	if _, err := r.Register(Registration{Service: "orders", URLs: []string{"http://orders-1:8080"}}); err != nil {
+		t.Fatalf("Register() again error = %v", err)
+	}
+	if _, err := r.Heartbeat("orders-2"); err != nil {
+		t.Fatalf("Heartbeat() error = %v", err)
+	}
+	if pm.GetProxy("orders") != rp {
+		t.Error("renewals rebuilt the service proxy")
+	}
+	if len(r.List()) != 2 {
+		t.Errorf("List() = %v, want two registrations", r.List())
+	}
+
+	if _, err := r.Register(Registration{Service: "users", URLs: []string{"http://evil:8080"}}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Register() over a configured service error = %v, want ErrConflict", err)
+	}
+	if _, err := r.Register(Registration{Service: "orders", URLs: []string{"ftp://orders"}}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Register() with an ftp URL error = %v, want ErrInvalid", err)
+	}
+	if _, err := r.Heartbeat("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Heartbeat(missing) error = %v, want ErrNotFound", err)
+	}
+
+	// Expired registrations leave the service, and the last one removes it
+	r.mu.Lock()
+	r.entries[first.ID].ExpiresAt = time.Now().Add(-time.Second)
+	r.mu.Unlock()
+	r.sweep()
+	if urls := targetURLs(pm.GetProxy("orders")); len(urls) != 1 || urls[0] != "http://orders-2:8080" {
+		t.Errorf("targets after expiry = %v, want orders-2 only", urls)
+	}
+	if err := r.Deregister("orders-2"); err != nil {
+		t.Fatalf("Deregister() error = %v", err)
+	}
+	if pm.GetProxy("orders") != nil {
+		t.Error("service without registrations is still routed")
+	}
+	if pm.GetProxy("users") == nil {
+		t.Error("configured service was removed")
+	}
+}
+
+func TestRegistryApply(t *testing.T) {
+	r, pm := newTestRegistry(t)
+
+	var notified []Registration
+	r.SetNotifier(func(reg Registration, removed bool) { notified = append(notified, reg) })
+
+	reg := Registration{ID: "a", Service: "orders", URLs: []string{"http://orders:8080"}, TTLSeconds: 30, ExpiresAt: time.Now().Add(30 * time.Second)}
+	if err := r.Apply(reg, false); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if pm.GetProxy("orders") == nil {
+		t.Error("registration from another replica is not routed")
+	}
+	if len(notified) != 0 {
+		t.Error("applied registration was announced again")
+	}
+	if _, err := r.Heartbeat("a"); err != nil {
+		t.Errorf("Heartbeat() of a shared registration error = %v", err)
+	}
+
+	if err := r.Apply(reg, true); err != nil {
+		t.Fatalf("Apply(removed) error = %v", err)
+	}
+	if pm.GetProxy("orders") != nil {
+		t.Error("removed registration is still routed")
+	}
+}
@@ -0,0 +1,209 @@
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// RateLimiter receives the rate limits in effect
+type RateLimiter interface {
+	SetConfig(cfg *config.RateLimitConfig)
+}
+
+// Services receives the service configurations in effect
+type Services interface {
+	UpdateService(name string, cfg *config.ServiceConfig) error
+}
+
+// schedule is a scheduled override with its parsed expression
+type schedule struct {
+	cfg      config.ScheduleConfig
+	cron     *Cron
+	location *time.Location
+}
+
+// Scheduler applies scheduled overrides on top of the configured rate
+// limits and services, checking the schedules at the start of every minute
+type Scheduler struct {
+	schedules   []schedule
+	rateLimit   config.RateLimitConfig
+	services    map[string]config.ServiceConfig
+	rateLimiter RateLimiter
+	proxies     Services
+	logger      *zap.Logger
+
+	mu     sync.Mutex
+	active []bool
+}
+
+// NewScheduler creates a scheduler overriding the rate limits and services
+// of cfg
+func NewScheduler(cfg *config.Config, rateLimiter RateLimiter, proxies Services, logger *zap.Logger) (*Scheduler, error) {
+	s := &Scheduler{
+		schedules:   make([]schedule, 0, len(cfg.Schedules)),
+		rateLimit:   cfg.RateLimit,
+		services:    cfg.Routing.Services,
+		rateLimiter: rateLimiter,
+		proxies:     proxies,
+		logger:      logger,
+		active:      make([]bool, len(cfg.Schedules)),
+	}
+
+	for _, scheduleCfg := range cfg.Schedules {
+		cron, err := ParseCron(scheduleCfg.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %s: %w", scheduleCfg.Name, err)
+		}
+		location := time.Local
+		if scheduleCfg.Timezone != "" {
+			if location, err = time.LoadLocation(scheduleCfg.Timezone); err != nil {
+				return nil, fmt.Errorf("schedule %s: %w", scheduleCfg.Name, err)
+			}
+		}
+		s.schedules = append(s.schedules, schedule{cfg: scheduleCfg, cron: cron, location: location})
+	}
+	return s, nil
+}
+
+// Start applies the schedules active now and then re-evaluates them every
+// minute until the context is canceled
+func (s *Scheduler) Start(ctx context.Context) {
+	s.evaluate(time.Now())
+
+	go func() {
+		for {
+			now := time.Now()
+			timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case now = <-timer.C:
+				s.evaluate(now)
+			}
+		}
+	}()
+}
+
+// Active returns the names of the active schedules
+func (s *Scheduler) Active() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := []string{}
+	for i, active := range s.active {
+		if active {
+			names = append(names, s.schedules[i].cfg.Name)
+		}
+	}
+	return names
+}
+
+// evaluate updates the active schedules and reapplies the rate limits and
+// services whose overrides changed
+func (s *Scheduler) evaluate(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rateLimitChanged := false
+	changedServices := make(map[string]bool)
+	for i, sched := range s.schedules {
+		active := sched.cron.Matches(now.In(sched.location))
+		if active == s.active[i] {
+			continue
+		}
+		s.active[i] = active
+
+		if active {
+			s.logger.Info("Schedule activated", zap.String("schedule", sched.cfg.Name))
+		} else {
+			s.logger.Info("Schedule deactivated", zap.String("schedule", sched.cfg.Name))
+		}
+		if hasRateLimit(sched.cfg.RateLimit) {
+			rateLimitChanged = true
+		}
+		for name := range sched.cfg.Services {
+			changedServices[name] = true
+		}
+	}
+
+	var active []config.ScheduleConfig
+	for i, sched := range s.schedules {
+		if s.active[i] {
+			active = append(active, sched.cfg)
+		}
+	}
+
+	if rateLimitChanged {
+		rateLimit := RateLimit(s.rateLimit, active)
+		s.rateLimiter.SetConfig(&rateLimit)
+	}
+	for name := range changedServices {
+		service := Service(name, s.services[name], active)
+		if err := s.proxies.UpdateService(name, &service); err != nil {
+			s.logger.Error("Failed to apply scheduled service override",
+				zap.String("service", name),
+				zap.Error(err))
+		}
+	}
+}
+
+// hasRateLimit reports whether a schedule overrides any rate limit rule
+func hasRateLimit(cfg config.ScheduledRateLimitConfig) bool {
+	return cfg.Default != nil || len(cfg.PerUser) > 0 || len(cfg.PerService) > 0
+}
+
+// RateLimit returns the rate limits in effect with the active schedules
+func RateLimit(base config.RateLimitConfig, active []config.ScheduleConfig) config.RateLimitConfig {
+	result := base
+	result.PerUser = make(map[string]config.RateLimitRule, len(base.PerUser))
+	for user, rule := range base.PerUser {
+		result.PerUser[user] = rule
+	}
+	result.PerService = make(map[string]config.RateLimitRule, len(base.PerService))
+	for service, rule := range base.PerService {
+		result.PerService[service] = rule
+	}
+
+	for _, sched := range active {
+		if sched.RateLimit.Default != nil {
+			result.Default = *sched.RateLimit.Default
+		}
+		for user, rule := range sched.RateLimit.PerUser {
+			result.PerUser[user] = rule
+		}
+		for service, rule := range sched.RateLimit.PerService {
+			result.PerService[service] = rule
+		}
+	}
+	return result
+}
+
+// Service returns a service's configuration in effect with the active
+// schedules. Overriding urls or targets replaces the whole upstream pool.
+func Service(name string, base config.ServiceConfig, active []config.ScheduleConfig) config.ServiceConfig {
+	result := base
+	for _, sched := range active {
+		override, exists := sched.Services[name]
+		if !exists {
+			continue
+		}
+		if len(override.URLs) > 0 || len(override.Targets) > 0 {
+			result.URLs = override.URLs
+			result.Targets = override.Targets
+		}
+		if override.LoadBalancer != "" {
+			result.LoadBalancer = override.LoadBalancer
+		}
+		if override.Timeout > 0 {
+			result.Timeout = override.Timeout
+		}
+	}
+	return result
+}
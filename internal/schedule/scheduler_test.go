@@ -0,0 +1,118 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestCronMatches(t *testing.T) {
+	tests := []struct {
+		expr string
+		time string
+		want bool
+	}{
+		{"* 1-4 * * *", "2024-03-05T02:30:00Z", true},
+		{"* 1-4 * * *", "2024-03-05T05:00:00Z", false},
+		{"*/15 * * * *", "2024-03-05T10:45:00Z", true},
+		{"*/15 * * * *", "2024-03-05T10:46:00Z", false},
+		{"* 9-17 * * 1-5", "2024-03-08T12:00:00Z", true},  // Friday
+		{"* 9-17 * * 1-5", "2024-03-09T12:00:00Z", false}, // Saturday
+		{"0 0 * * 7", "2024-03-10T00:00:00Z", true},       // Sunday as 7
+		{"0 0 1 * 1", "2024-03-04T00:00:00Z", true},       // Monday, either day field matches
+		{"0 0 1,15 6 *", "2024-06-15T00:00:00Z", true},
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%q) error = %v", tt.expr, err)
+		}
+		at, _ := time.Parse(time.RFC3339, tt.time)
+		if got := c.Matches(at); got != tt.want {
+			t.Errorf("%q matches %s = %v, want %v", tt.expr, tt.time, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+type fakeRateLimiter struct{ cfg *config.RateLimitConfig }
+
+func (f *fakeRateLimiter) SetConfig(cfg *config.RateLimitConfig) { f.cfg = cfg }
+
+type fakeServices map[string]config.ServiceConfig
+
+func (f fakeServices) UpdateService(name string, cfg *config.ServiceConfig) error {
+	f[name] = *cfg
+	return nil
+}
+
+func TestSchedulerEvaluate(t *testing.T) {
+	cfg := &config.Config{
+		RateLimit: config.RateLimitConfig{
+			Enabled: true,
+			Default: config.RateLimitRule{Requests: 100, Window: time.Minute},
+		},
+		Routing: config.RoutingConfig{Services: map[string]config.ServiceConfig{
+			"reports": {URLs: []string{"http://reports:8080"}, Timeout: time.Second},
+		}},
+		Schedules: []config.ScheduleConfig{
+			{
+				Name: "nightly_batch",
+				Cron: "* 1-4 * * *",
+				RateLimit: config.ScheduledRateLimitConfig{
+					Default: &config.RateLimitRule{Requests: 10, Window: time.Minute},
+				},
+			},
+			{
+				Name:     "business_hours",
+				Cron:     "* 9-17 * * 1-5",
+				Timezone: "Europe/Berlin",
+				Services: map[string]config.ScheduledServiceConfig{
+					"reports": {URLs: []string{"http://reports-pool:8080"}},
+				},
+			},
+		},
+	}
+
+	rateLimiter := &fakeRateLimiter{}
+	services := fakeServices{}
+	s, err := NewScheduler(cfg, rateLimiter, services, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewScheduler() error = %v", err)
+	}
+
+	// 02:00 UTC is 03:00 in Berlin: only the nightly window is active
+	s.evaluate(time.Date(2024, 3, 5, 2, 0, 0, 0, time.UTC))
+	if rateLimiter.cfg == nil || rateLimiter.cfg.Default.Requests != 10 {
+		t.Fatalf("rate limit during the nightly window = %+v, want 10 requests", rateLimiter.cfg)
+	}
+	if len(services) != 0 {
+		t.Errorf("services updated outside business hours: %v", services)
+	}
+
+	// 09:00 UTC is 10:00 in Berlin
+	s.evaluate(time.Date(2024, 3, 5, 9, 0, 0, 0, time.UTC))
+	if rateLimiter.cfg.Default.Requests != 100 {
+		t.Errorf("rate limit after the nightly window = %d, want 100", rateLimiter.cfg.Default.Requests)
+	}
+	reports := services["reports"]
+	if len(reports.URLs) != 1 || reports.URLs[0] != "http://reports-pool:8080" || reports.Timeout != time.Second {
+		t.Errorf("reports during business hours = %+v", reports)
+	}
+	if active := s.Active(); len(active) != 1 || active[0] != "business_hours" {
+		t.Errorf("Active() = %v, want [business_hours]", active)
+	}
+
+	s.evaluate(time.Date(2024, 3, 5, 17, 0, 0, 0, time.UTC))
+	if reports := services["reports"]; reports.URLs[0] != "http://reports:8080" {
+		t.Errorf("reports after business hours = %+v", reports)
+	}
+}
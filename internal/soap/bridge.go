@@ -0,0 +1,369 @@
+package soap
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/internal/featureflags"
+)
+
+// defaultMaxBodyBytes limits request and response bodies when no limit is set
+const defaultMaxBodyBytes = 1 << 20
+
+// Envelope namespaces
+const (
+	namespace11 = "http://schemas.xmlsoap.org/soap/envelope/"
+	namespace12 = "http://www.w3.org/2003/05/soap-envelope"
+)
+
+// Field types of response mappings
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeNumber = "number"
+	TypeBool   = "bool"
+	TypeObject = "object"
+	TypeList   = "list"
+)
+
+// ErrBodyTooLarge is returned when a request body exceeds the route's limit
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// Route is a compiled SOAP route
+type Route struct {
+	cfg     config.SOAPRouteConfig
+	methods map[string]bool
+	body    *template.Template
+	header  *template.Template
+	maxBody int64
+}
+
+// Bridge converts JSON requests to SOAP calls for the routes of a service
+type Bridge struct {
+	routes []*Route
+}
+
+// NewBridge compiles SOAP routes; it returns nil if there are none
+func NewBridge(routes []config.SOAPRouteConfig) (*Bridge, error) {
+	if len(routes) == 0 {
+		return nil, nil
+	}
+
+	b := &Bridge{routes: make([]*Route, 0, len(routes))}
+	for _, cfg := range routes {
+		route, err := newRoute(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("soap route %s: %w", cfg.PathPrefix, err)
+		}
+		b.routes = append(b.routes, route)
+	}
+	return b, nil
+}
+
+// newRoute compiles a route's templates and checks its mappings
+func newRoute(cfg config.SOAPRouteConfig) (*Route, error) {
+	switch cfg.Version {
+	case "", "1.1", "1.2":
+	default:
+		return nil, fmt.Errorf("unknown SOAP version: %s", cfg.Version)
+	}
+	if cfg.Body == "" {
+		return nil, fmt.Errorf("body template is required")
+	}
+
+	route := &Route{cfg: cfg, maxBody: cfg.MaxBodyBytes}
+	if route.maxBody <= 0 {
+		route.maxBody = defaultMaxBodyBytes
+	}
+	if len(cfg.Methods) > 0 {
+		route.methods = make(map[string]bool, len(cfg.Methods))
+		for _, method := range cfg.Methods {
+			route.methods[strings.ToUpper(method)] = true
+		}
+	}
+
+	var err error
+	if route.body, err = template.New("body").Option("missingkey=zero").Parse(cfg.Body); err != nil {
+		return nil, fmt.Errorf("invalid body template: %w", err)
+	}
+	if cfg.Header != "" {
+		if route.header, err = template.New("header").Option("missingkey=zero").Parse(cfg.Header); err != nil {
+			return nil, fmt.Errorf("invalid header template: %w", err)
+		}
+	}
+
+	for _, field := range cfg.Response {
+		if field.Field == "" || field.Path == "" {
+			return nil, fmt.Errorf("response mappings need a field and a path")
+		}
+		switch field.Type {
+		case "", TypeString, TypeInt, TypeNumber, TypeBool, TypeObject, TypeList:
+		default:
+			return nil, fmt.Errorf("response field %s: unknown type: %s", field.Field, field.Type)
+		}
+	}
+	return route, nil
+}
+
+// Match returns the first route matching the request, or nil
+func (b *Bridge) Match(r *http.Request) *Route {
+	if b == nil {
+		return nil
+	}
+	for _, route := range b.routes {
+		if !strings.HasPrefix(r.URL.Path, route.cfg.PathPrefix) {
+			continue
+		}
+		if route.methods != nil && !route.methods[r.Method] {
+			continue
+		}
+		if route.cfg.Flag != "" && !featureflags.Enabled(r.Context(), route.cfg.Flag) {
+			continue
+		}
+		return route
+	}
+	return nil
+}
+
+// UpstreamPath returns the path the SOAP service listens on, or "" to keep
+// the request path
+func (rt *Route) UpstreamPath() string {
+	return rt.cfg.UpstreamPath
+}
+
+// RewriteRequest returns the request converted to a SOAP POST. The envelope
+// is rendered with the JSON body, or the query parameters if there is none.
+func (rt *Route) RewriteRequest(r *http.Request) (*http.Request, error) {
+	data, err := rt.templateData(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ns := namespace11
+	if rt.cfg.Version == "1.2" {
+		ns = namespace12
+	}
+
+	var envelope bytes.Buffer
+	envelope.WriteString(xml.Header)
+	envelope.WriteString(`<soap:Envelope xmlns:soap="` + ns + `">`)
+	if rt.header != nil {
+		envelope.WriteString("<soap:Header>")
+		if err := rt.header.Execute(&envelope, data); err != nil {
+			return nil, fmt.Errorf("failed to render SOAP header: %w", err)
+		}
+		envelope.WriteString("</soap:Header>")
+	}
+	envelope.WriteString("<soap:Body>")
+	if err := rt.body.Execute(&envelope, data); err != nil {
+		return nil, fmt.Errorf("failed to render SOAP body: %w", err)
+	}
+	envelope.WriteString("</soap:Body></soap:Envelope>")
+
+	out := r.Clone(r.Context())
+	out.Method = http.MethodPost
+	out.Body = io.NopCloser(&envelope)
+	out.ContentLength = int64(envelope.Len())
+	out.Header.Del("Content-Length")
+	out.Header.Del("Accept-Encoding")
+	out.Header.Del("SOAPAction")
+
+	if rt.cfg.Version == "1.2" {
+		contentType := "application/soap+xml; charset=utf-8"
+		if rt.cfg.Action != "" {
+			contentType += `; action="` + rt.cfg.Action + `"`
+		}
+		out.Header.Set("Content-Type", contentType)
+		out.Header.Set("Accept", "application/soap+xml, text/xml")
+	} else {
+		out.Header.Set("Content-Type", "text/xml; charset=utf-8")
+		out.Header.Set("Accept", "text/xml")
+		out.Header.Set("SOAPAction", strconv.Quote(rt.cfg.Action))
+	}
+	return out, nil
+}
+
+// templateData decodes the JSON body with its strings XML-escaped, falling
+// back to the query parameters
+func (rt *Route) templateData(r *http.Request) (interface{}, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(io.LimitReader(r.Body, rt.maxBody+1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read body: %w", err)
+		}
+		if int64(len(body)) > rt.maxBody {
+			return nil, ErrBodyTooLarge
+		}
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		query := make(map[string]interface{})
+		for key, values := range r.URL.Query() {
+			query[key] = escape(values[len(values)-1])
+		}
+		return query, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var data interface{}
+	if err := decoder.Decode(&data); err != nil {
+		return nil, fmt.Errorf("invalid JSON body: %w", err)
+	}
+	return escapeValues(data), nil
+}
+
+// escapeValues XML-escapes the strings of a decoded JSON value
+func escapeValues(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return escape(v)
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = escapeValues(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = escapeValues(item)
+		}
+	}
+	return value
+}
+
+// escape XML-escapes a string
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// RewriteResponse converts the SOAP response to JSON. Faults become JSON
+// errors, 400 for client faults and 502 otherwise; responses that are not
+// valid SOAP become 502 errors.
+func (rt *Route) RewriteResponse(resp *http.Response) {
+	data, err := io.ReadAll(io.LimitReader(resp.Body, rt.maxBody+1))
+	resp.Body.Close()
+
+	status := resp.StatusCode
+	var payload interface{}
+	switch {
+	case err != nil:
+		status, payload = http.StatusBadGateway, errorBody("Failed to read SOAP response", "")
+	case int64(len(data)) > rt.maxBody:
+		status, payload = http.StatusBadGateway, errorBody("SOAP response too large", "")
+	default:
+		payload, status = rt.convert(data, status)
+	}
+
+	encoded, _ := json.Marshal(payload)
+	resp.StatusCode = status
+	resp.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+	resp.Body = io.NopCloser(bytes.NewReader(encoded))
+	resp.ContentLength = int64(len(encoded))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(encoded)))
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("SOAPAction")
+}
+
+// convert maps a SOAP envelope to the JSON payload and status
+func (rt *Route) convert(data []byte, status int) (interface{}, int) {
+	root, err := parseXML(data)
+	if err != nil || root.name != "Envelope" {
+		return errorBody("Invalid SOAP response", ""), http.StatusBadGateway
+	}
+	body := root.child("Body")
+	if body == nil {
+		return errorBody("Invalid SOAP response", ""), http.StatusBadGateway
+	}
+
+	if fault := body.child("Fault"); fault != nil {
+		message, code := faultDetails(fault)
+		if strings.HasSuffix(code, "Client") || strings.HasSuffix(code, "Sender") {
+			return errorBody(message, code), http.StatusBadRequest
+		}
+		return errorBody(message, code), http.StatusBadGateway
+	}
+	if status >= http.StatusBadRequest {
+		return errorBody("SOAP request failed", ""), http.StatusBadGateway
+	}
+
+	if len(rt.cfg.Response) == 0 {
+		return body.contents(), status
+	}
+
+	result := make(map[string]interface{})
+	for _, field := range rt.cfg.Response {
+		setPath(result, field.Field, body.extract(field.Path, field.Type))
+	}
+	return result, status
+}
+
+// faultDetails returns the message and code of a SOAP 1.1 or 1.2 fault
+func faultDetails(fault *node) (string, string) {
+	// SOAP 1.1
+	if code := fault.child("faultcode"); code != nil {
+		message := ""
+		if s := fault.child("faultstring"); s != nil {
+			message = s.text
+		}
+		return message, localName(code.text)
+	}
+
+	// SOAP 1.2
+	var message, code string
+	if reason := fault.child("Reason"); reason != nil {
+		if text := reason.child("Text"); text != nil {
+			message = text.text
+		}
+	}
+	if c := fault.child("Code"); c != nil {
+		if value := c.child("Value"); value != nil {
+			code = localName(value.text)
+		}
+	}
+	return message, code
+}
+
+// errorBody builds an error response in the gateway's format
+func errorBody(message, code string) map[string]interface{} {
+	body := map[string]interface{}{"error": message}
+	if code != "" {
+		body["code"] = code
+	}
+	return body
+}
+
+// localName strips the namespace prefix of a qualified name
+func localName(name string) string {
+	name = strings.TrimSpace(name)
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// setPath assigns a value at a dotted path, creating intermediate objects
+func setPath(target map[string]interface{}, path string, value interface{}) {
+	segments := strings.Split(path, ".")
+	for _, segment := range segments[:len(segments)-1] {
+		next, ok := target[segment].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			target[segment] = next
+		}
+		target = next
+	}
+	target[segments[len(segments)-1]] = value
+}
@@ -0,0 +1,142 @@
+package soap
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func newTestRoute(t *testing.T, cfg config.SOAPRouteConfig) *Route {
+	t.Helper()
+	bridge, err := NewBridge([]config.SOAPRouteConfig{cfg})
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	return bridge.routes[0]
+}
+
+func TestRewriteRequest(t *testing.T) {
+	route := newTestRoute(t, config.SOAPRouteConfig{
+		PathPrefix: "/billing/invoices",
+		Action:     "urn:CreateInvoice",
+		Body:       `<CreateInvoice xmlns="urn:billing"><Customer>{{.customer}}</Customer><Amount>{{.amount}}</Amount></CreateInvoice>`,
+	})
+
+	r := httptest.NewRequest(http.MethodPut, "/billing/invoices", strings.NewReader(`{"customer": "Tom & <Jerry>", "amount": 12.50}`))
+	out, err := route.RewriteRequest(r)
+	if err != nil {
+		t.Fatalf("RewriteRequest() error = %v", err)
+	}
+
+	if out.Method != http.MethodPost {
+		t.Errorf("Method = %s, want POST", out.Method)
+	}
+	if got := out.Header.Get("SOAPAction"); got != `"urn:CreateInvoice"` {
+		t.Errorf("SOAPAction = %s", got)
+	}
+	if got := out.Header.Get("Content-Type"); got != "text/xml; charset=utf-8" {
+		t.Errorf("Content-Type = %s", got)
+	}
+
+	body, _ := io.ReadAll(out.Body)
+	want := `<soap:Body><CreateInvoice xmlns="urn:billing"><Customer>Tom &amp; &lt;Jerry&gt;</Customer><Amount>12.50</Amount></CreateInvoice></soap:Body>`
+	if !strings.Contains(string(body), want) {
+		t.Errorf("envelope = %s, want it to contain %s", body, want)
+	}
+	if out.ContentLength != int64(len(body)) {
+		t.Errorf("ContentLength = %d, want %d", out.ContentLength, len(body))
+	}
+}
+
+func soapResponse(status int, body string) *http.Response {
+	envelope := `<?xml version="1.0"?><soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>` + body + `</soap:Body></soap:Envelope>`
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": {"text/xml"}},
+		Body:       io.NopCloser(strings.NewReader(envelope)),
+	}
+}
+
+func decode(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	var v interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		t.Fatalf("response is not JSON: %v", err)
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func TestRewriteResponse(t *testing.T) {
+	body := `<m:GetUserResponse xmlns:m="urn:users"><m:User id="7"><m:Name>Ada</m:Name><m:Age>36</m:Age><m:Active>true</m:Active>` +
+		`<m:Role>admin</m:Role><m:Role>dev</m:Role></m:User></m:GetUserResponse>`
+
+	mapped := newTestRoute(t, config.SOAPRouteConfig{
+		PathPrefix: "/users",
+		Body:       "<GetUser/>",
+		Response: []config.SOAPFieldConfig{
+			{Field: "user.name", Path: "GetUserResponse/User/Name"},
+			{Field: "user.age", Path: "GetUserResponse/User/Age", Type: TypeInt},
+			{Field: "user.active", Path: "GetUserResponse/User/Active", Type: TypeBool},
+			{Field: "roles", Path: "GetUserResponse/User/Role", Type: TypeList},
+			{Field: "email", Path: "GetUserResponse/User/Email"},
+		},
+	})
+	resp := soapResponse(http.StatusOK, body)
+	mapped.RewriteResponse(resp)
+	want := `{"email":null,"roles":["admin","dev"],"user":{"active":true,"age":36,"name":"Ada"}}`
+	if got := decode(t, resp); got != want {
+		t.Errorf("mapped response = %s, want %s", got, want)
+	}
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type = %s", resp.Header.Get("Content-Type"))
+	}
+
+	generic := newTestRoute(t, config.SOAPRouteConfig{PathPrefix: "/users", Body: "<GetUser/>"})
+	resp = soapResponse(http.StatusOK, body)
+	generic.RewriteResponse(resp)
+	want = `{"GetUserResponse":{"User":{"@id":"7","Active":"true","Age":"36","Name":"Ada","Role":["admin","dev"]}}}`
+	if got := decode(t, resp); got != want {
+		t.Errorf("generic response = %s, want %s", got, want)
+	}
+}
+
+func TestRewriteResponseFault(t *testing.T) {
+	route := newTestRoute(t, config.SOAPRouteConfig{PathPrefix: "/users", Body: "<GetUser/>"})
+
+	tests := []struct {
+		fault  string
+		status int
+		want   string
+	}{
+		{
+			`<soap:Fault><faultcode>soap:Client</faultcode><faultstring>Unknown user</faultstring></soap:Fault>`,
+			http.StatusBadRequest, `{"code":"Client","error":"Unknown user"}`,
+		},
+		{
+			`<soap:Fault><faultcode>soap:Server</faultcode><faultstring>Database down</faultstring></soap:Fault>`,
+			http.StatusBadGateway, `{"code":"Server","error":"Database down"}`,
+		},
+	}
+	for _, tt := range tests {
+		resp := soapResponse(http.StatusInternalServerError, tt.fault)
+		route.RewriteResponse(resp)
+		if resp.StatusCode != tt.status {
+			t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+		}
+		if got := decode(t, resp); got != tt.want {
+			t.Errorf("fault response = %s, want %s", got, tt.want)
+		}
+	}
+
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("<html>oops"))}
+	route.RewriteResponse(resp)
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("status for invalid XML = %d, want 502", resp.StatusCode)
+	}
+}
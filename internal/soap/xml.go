@@ -0,0 +1,185 @@
+package soap
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// xsiNamespace is the XML Schema instance namespace, whose nil attribute
+// marks null values
+const xsiNamespace = "http://www.w3.org/2001/XMLSchema-instance"
+
+// node is an XML element with namespaces reduced to local names
+type node struct {
+	name     string
+	attrs    []xml.Attr
+	children []*node
+	text     string
+}
+
+// parseXML parses a document into its root element
+func parseXML(data []byte) (*node, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+
+	var stack []*node
+	var root *node
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			n := &node{name: t.Name.Local, attrs: t.Attr}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			} else if root == nil {
+				root = n
+			}
+			stack = append(stack, n)
+			text.Reset()
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			n := stack[len(stack)-1]
+			if len(n.children) == 0 {
+				n.text = strings.TrimSpace(text.String())
+			}
+			stack = stack[:len(stack)-1]
+			text.Reset()
+		}
+	}
+
+	if root == nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return root, nil
+}
+
+// child returns the first child element with a local name
+func (n *node) child(name string) *node {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// isNil reports whether the element is marked xsi:nil
+func (n *node) isNil() bool {
+	for _, attr := range n.attrs {
+		if attr.Name.Space == xsiNamespace && attr.Name.Local == "nil" {
+			return attr.Value == "true" || attr.Value == "1"
+		}
+	}
+	return false
+}
+
+// contents converts the child elements to a JSON object, with repeated
+// elements as arrays
+func (n *node) contents() map[string]interface{} {
+	counts := make(map[string]int)
+	for _, c := range n.children {
+		counts[c.name]++
+	}
+
+	object := make(map[string]interface{}, len(counts))
+	for _, c := range n.children {
+		if counts[c.name] > 1 {
+			list, _ := object[c.name].([]interface{})
+			object[c.name] = append(list, c.value())
+			continue
+		}
+		object[c.name] = c.value()
+	}
+	return object
+}
+
+// value converts an element to JSON: leaves without attributes become
+// strings, other elements objects with "@attribute" keys. Leaves with
+// attributes keep their text under "#text".
+func (n *node) value() interface{} {
+	if n.isNil() {
+		return nil
+	}
+
+	var attrs []xml.Attr
+	for _, attr := range n.attrs {
+		if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" || attr.Name.Space == xsiNamespace {
+			continue
+		}
+		attrs = append(attrs, attr)
+	}
+	if len(attrs) == 0 && len(n.children) == 0 {
+		return n.text
+	}
+
+	object := n.contents()
+	for _, attr := range attrs {
+		object["@"+attr.Name.Local] = attr.Value
+	}
+	if n.text != "" {
+		object["#text"] = n.text
+	}
+	return object
+}
+
+// extract returns the value of the elements at a slash-separated path of
+// local names, converted to a mapping type; missing elements are null
+func (n *node) extract(path, typ string) interface{} {
+	steps := strings.Split(strings.Trim(path, "/"), "/")
+	current := n
+	for _, step := range steps[:len(steps)-1] {
+		if current = current.child(step); current == nil {
+			return nil
+		}
+	}
+	last := steps[len(steps)-1]
+
+	if typ == TypeList {
+		list := []interface{}{}
+		for _, c := range current.children {
+			if c.name == last {
+				list = append(list, c.value())
+			}
+		}
+		return list
+	}
+
+	element := current.child(last)
+	if element == nil || element.isNil() {
+		return nil
+	}
+
+	switch typ {
+	case TypeObject:
+		return element.value()
+	case TypeInt:
+		if v, err := strconv.ParseInt(element.text, 10, 64); err == nil {
+			return v
+		}
+		return nil
+	case TypeNumber:
+		if v, err := strconv.ParseFloat(element.text, 64); err == nil {
+			return v
+		}
+		return nil
+	case TypeBool:
+		if v, err := strconv.ParseBool(element.text); err == nil {
+			return v
+		}
+		return nil
+	default:
+		return element.text
+	}
+}
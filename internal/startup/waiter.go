@@ -0,0 +1,159 @@
+package startup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// CheckFunc probes a dependency; a nil error means it is ready
+type CheckFunc func(ctx context.Context) error
+
+// dependency is a registered startup dependency
+type dependency struct {
+	name     string
+	required bool
+	check    CheckFunc
+}
+
+// Waiter blocks startup until the registered dependencies are ready.
+// Required dependencies abort startup when they do not become ready within
+// the timeout; the others are logged and the gateway starts degraded.
+type Waiter struct {
+	cfg      config.StartupConfig
+	required map[string]bool
+	logger   *zap.Logger
+	deps     []dependency
+}
+
+// NewWaiter creates a startup waiter
+func NewWaiter(cfg config.StartupConfig, logger *zap.Logger) *Waiter {
+	required := make(map[string]bool, len(cfg.Required))
+	for _, name := range cfg.Required {
+		required[name] = true
+	}
+	return &Waiter{cfg: cfg, required: required, logger: logger}
+}
+
+// Add registers a dependency. It is a hard requirement when listed in the
+// required config.
+func (w *Waiter) Add(name string, check CheckFunc) {
+	w.deps = append(w.deps, dependency{name: name, required: w.required[name], check: check})
+}
+
+// Wait checks all dependencies concurrently, retrying with exponential
+// backoff. It returns an error naming the required dependencies that did
+// not become ready.
+func (w *Waiter) Wait(ctx context.Context) error {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []error
+	)
+
+	for _, dep := range w.deps {
+		wg.Add(1)
+		go func(dep dependency) {
+			defer wg.Done()
+
+			timeout := w.cfg.SoftTimeout
+			if dep.required {
+				timeout = w.cfg.Timeout
+			}
+			err := w.waitFor(ctx, dep, timeout)
+			if err == nil {
+				return
+			}
+			if !dep.required {
+				w.logger.Warn("Optional dependency not ready, starting degraded",
+					zap.String("dependency", dep.name), zap.Error(err))
+				return
+			}
+
+			mu.Lock()
+			failed = append(failed, fmt.Errorf("%s: %w", dep.name, err))
+			mu.Unlock()
+		}(dep)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return fmt.Errorf("required dependencies not ready: %w", errors.Join(failed...))
+	}
+	return nil
+}
+
+// waitFor retries a dependency check until it passes or the timeout expires
+func (w *Waiter) waitFor(ctx context.Context, dep dependency, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	backoff := w.cfg.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		attemptCtx, attemptCancel := context.WithTimeout(ctx, w.cfg.AttemptTimeout)
+		err := dep.check(attemptCtx)
+		attemptCancel()
+		if err == nil {
+			if attempt > 1 {
+				w.logger.Info("Dependency ready",
+					zap.String("dependency", dep.name), zap.Int("attempts", attempt))
+			}
+			return nil
+		}
+
+		w.logger.Info("Waiting for dependency",
+			zap.String("dependency", dep.name),
+			zap.Int("attempt", attempt),
+			zap.Duration("retry_in", backoff),
+			zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("not ready after %d attempts: %w", attempt, err)
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, w.cfg.MaxBackoff)
+	}
+}
+
+// TCPCheck returns a check that passes when any of the addresses accepts a
+// TCP connection
+func TCPCheck(addresses ...string) CheckFunc {
+	return func(ctx context.Context) error {
+		if len(addresses) == 0 {
+			return fmt.Errorf("no addresses configured")
+		}
+		var dialer net.Dialer
+		var lastErr error
+		for _, address := range addresses {
+			conn, err := dialer.DialContext(ctx, "tcp", address)
+			if err == nil {
+				return conn.Close()
+			}
+			lastErr = err
+		}
+		return lastErr
+	}
+}
+
+// DNSCheck returns a check that passes when every host name resolves
+func DNSCheck(resolver *net.Resolver, hosts []string) CheckFunc {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return func(ctx context.Context) error {
+		for _, host := range hosts {
+			if _, err := resolver.LookupHost(ctx, host); err != nil {
+				return fmt.Errorf("resolve %s: %w", host, err)
+			}
+		}
+		return nil
+	}
+}
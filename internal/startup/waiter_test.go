@@ -0,0 +1,66 @@
+package startup
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func testConfig(required ...string) config.StartupConfig {
+	return config.StartupConfig{
+		Enabled:        true,
+		Timeout:        200 * time.Millisecond,
+		SoftTimeout:    50 * time.Millisecond,
+		AttemptTimeout: 50 * time.Millisecond,
+		InitialBackoff: 5 * time.Millisecond,
+		MaxBackoff:     20 * time.Millisecond,
+		Required:       required,
+	}
+}
+
+func TestWaitRetriesUntilReady(t *testing.T) {
+	w := NewWaiter(testConfig("redis"), zap.NewNop())
+
+	attempts := 0
+	w.Add("redis", func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+
+	if err := w.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+}
+
+func TestWaitRequiredVsOptional(t *testing.T) {
+	down := func(ctx context.Context) error { return errors.New("down") }
+
+	w := NewWaiter(testConfig(), zap.NewNop())
+	w.Add("redis", down)
+	if err := w.Wait(context.Background()); err != nil {
+		t.Errorf("optional dependency failed startup: %v", err)
+	}
+
+	w = NewWaiter(testConfig("database"), zap.NewNop())
+	w.Add("redis", down)
+	w.Add("database", down)
+	err := w.Wait(context.Background())
+	if err == nil {
+		t.Fatal("expected error for required dependency")
+	}
+	if got := err.Error(); !strings.Contains(got, "database") || strings.Contains(got, "redis") {
+		t.Errorf("error = %q, want only the required dependency", got)
+	}
+}
@@ -0,0 +1,265 @@
+package synthetics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/pkg/metrics"
+)
+
+// ProbeHeader marks requests sent by synthetic probes; its value is the
+// probe name
+const ProbeHeader = "X-Synthetic-Probe"
+
+// maxBodyBytes bounds how much of a response body is kept for matching
+const maxBodyBytes = 1 << 20
+
+// Result is the outcome of a probe's latest run
+type Result struct {
+	Probe               string     `json:"probe"`
+	Method              string     `json:"method"`
+	Path                string     `json:"path"`
+	Passed              bool       `json:"passed"`
+	Status              int        `json:"status,omitempty"`
+	Error               string     `json:"error,omitempty"`
+	DurationMs          float64    `json:"duration_ms"`
+	CheckedAt           time.Time  `json:"checked_at"`
+	LastPassed          *time.Time `json:"last_passed,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+}
+
+// probe is a configured probe with its defaults applied
+type probe struct {
+	cfg        config.SyntheticProbeConfig
+	expectBody *regexp.Regexp
+}
+
+// Runner sends synthetic probes through a handler, normally the gateway
+// router, so they pass the same middleware and routing as client requests
+type Runner struct {
+	handler http.Handler
+	probes  []*probe
+	metrics *metrics.Manager
+	logger  *zap.Logger
+
+	mu      sync.RWMutex
+	results map[string]Result
+}
+
+// NewRunner creates a runner for the configured probes
+func NewRunner(cfg config.SyntheticsConfig, handler http.Handler, metricsManager *metrics.Manager, logger *zap.Logger) (*Runner, error) {
+	r := &Runner{
+		handler: handler,
+		metrics: metricsManager,
+		logger:  logger,
+		results: make(map[string]Result),
+	}
+
+	for _, probeCfg := range cfg.Probes {
+		p := &probe{cfg: probeCfg}
+		if p.cfg.Method == "" {
+			p.cfg.Method = http.MethodGet
+		}
+		p.cfg.Method = strings.ToUpper(p.cfg.Method)
+		if p.cfg.ExpectStatus == 0 {
+			p.cfg.ExpectStatus = http.StatusOK
+		}
+		if p.cfg.Interval <= 0 {
+			p.cfg.Interval = cfg.Interval
+		}
+		if p.cfg.Timeout <= 0 {
+			p.cfg.Timeout = cfg.Timeout
+		}
+		if p.cfg.ExpectBody != "" {
+			var err error
+			if p.expectBody, err = regexp.Compile(p.cfg.ExpectBody); err != nil {
+				return nil, fmt.Errorf("synthetic probe %s: invalid expect_body: %w", p.cfg.Name, err)
+			}
+		}
+		r.probes = append(r.probes, p)
+	}
+	return r, nil
+}
+
+// Start runs every probe immediately and then at its interval until ctx
+// is done
+func (r *Runner) Start(ctx context.Context) {
+	for _, p := range r.probes {
+		go func(p *probe) {
+			ticker := time.NewTicker(p.cfg.Interval)
+			defer ticker.Stop()
+			for {
+				r.run(ctx, p)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(p)
+	}
+}
+
+// Results returns the latest result of every probe that has run, by name
+func (r *Runner) Results() []Result {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	results := make([]Result, 0, len(r.results))
+	for _, result := range r.results {
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Probe < results[j].Probe })
+	return results
+}
+
+// run executes a probe and records its result
+func (r *Runner) run(ctx context.Context, p *probe) {
+	start := time.Now()
+	status, err := r.check(ctx, p)
+	duration := time.Since(start)
+	if ctx.Err() != nil {
+		// Shutting down; the probe did not fail
+		return
+	}
+
+	result := Result{
+		Probe:      p.cfg.Name,
+		Method:     p.cfg.Method,
+		Path:       p.cfg.Path,
+		Passed:     err == nil,
+		Status:     status,
+		DurationMs: float64(duration.Microseconds()) / 1000,
+		CheckedAt:  start.UTC(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	previous, ran := r.results[p.cfg.Name]
+	result.LastPassed = previous.LastPassed
+	if result.Passed {
+		result.LastPassed = &result.CheckedAt
+	} else {
+		result.ConsecutiveFailures = previous.ConsecutiveFailures + 1
+	}
+	r.results[p.cfg.Name] = result
+	r.mu.Unlock()
+
+	if r.metrics != nil {
+		r.metrics.RecordSyntheticCheck(p.cfg.Name, result.Passed, duration)
+	}
+
+	switch {
+	case !result.Passed && (!ran || previous.Passed):
+		r.logger.Warn("Synthetic probe failed",
+			zap.String("probe", p.cfg.Name),
+			zap.String("method", p.cfg.Method),
+			zap.String("path", p.cfg.Path),
+			zap.Int("status", status),
+			zap.Error(err))
+	case result.Passed && ran && !previous.Passed:
+		r.logger.Info("Synthetic probe recovered",
+			zap.String("probe", p.cfg.Name),
+			zap.Int("failures", previous.ConsecutiveFailures))
+	}
+}
+
+// check sends a probe request and compares the response with the
+// expectations. It returns the response status, if any.
+func (r *Runner) check(ctx context.Context, p *probe) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, p.cfg.Method, p.cfg.Path, strings.NewReader(p.cfg.Body))
+	if err != nil {
+		return 0, fmt.Errorf("invalid request: %w", err)
+	}
+	req.RequestURI = p.cfg.Path
+	req.RemoteAddr = "127.0.0.1:0"
+	req.Host = "localhost"
+	for name, value := range p.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set(ProbeHeader, p.cfg.Name)
+
+	w := newResponseWriter()
+	if !r.serve(w, req) {
+		return 0, errors.New("connection dropped")
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return w.status, fmt.Errorf("timed out after %s", p.cfg.Timeout)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status != p.cfg.ExpectStatus {
+		return w.status, fmt.Errorf("status %d, want %d", w.status, p.cfg.ExpectStatus)
+	}
+	if p.expectBody != nil && !p.expectBody.Match(w.body.Bytes()) {
+		return w.status, fmt.Errorf("body does not match %q", p.cfg.ExpectBody)
+	}
+	return w.status, nil
+}
+
+// serve passes a probe request to the handler. It returns false if the
+// handler aborted the response, which a server answers by closing the
+// connection.
+func (r *Runner) serve(w http.ResponseWriter, req *http.Request) (completed bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			if err != http.ErrAbortHandler {
+				panic(err)
+			}
+			completed = false
+		}
+	}()
+	r.handler.ServeHTTP(w, req)
+	return true
+}
+
+// responseWriter captures a probe response, keeping the start of the body
+type responseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseWriter() *responseWriter {
+	return &responseWriter{header: make(http.Header)}
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *responseWriter) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if remaining := maxBodyBytes - w.body.Len(); remaining > 0 {
+		w.body.Write(data[:min(len(data), remaining)])
+	}
+	return len(data), nil
+}
+
+// Flush lets streaming handlers flush; the response is only kept in memory
+func (w *responseWriter) Flush() {
+	w.WriteHeader(http.StatusOK)
+}
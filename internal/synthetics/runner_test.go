@@ -0,0 +1,57 @@
+package synthetics
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestRunnerChecksExpectations(t *testing.T) {
+	healthy := true
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/health", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(ProbeHeader) != "users" {
+			t.Errorf("probe header %q", r.Header.Get(ProbeHeader))
+		}
+		if !healthy {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		w.Write([]byte(`{"status":"ok"}`))
+	})
+
+	runner, err := NewRunner(config.SyntheticsConfig{
+		Interval: time.Minute,
+		Timeout:  time.Second,
+		Probes: []config.SyntheticProbeConfig{
+			{Name: "users", Path: "/users/health", ExpectBody: `"status":"ok"`},
+			{Name: "orders", Path: "/orders/health"},
+		},
+	}, mux, nil, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, p := range runner.probes {
+		runner.run(ctx, p)
+	}
+	healthy = false
+	runner.run(ctx, runner.probes[0])
+
+	results := runner.Results()
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	orders, users := results[0], results[1]
+	if orders.Passed || orders.Status != http.StatusNotFound || orders.Error != "status 404, want 200" {
+		t.Errorf("orders: %+v, want a status mismatch", orders)
+	}
+	if users.Passed || users.Status != http.StatusBadGateway || users.ConsecutiveFailures != 1 || users.LastPassed == nil {
+		t.Errorf("users: %+v, want a failure after a pass", users)
+	}
+}
@@ -0,0 +1,174 @@
+package transcode
+
+import (
+	"fmt"
+	"net/http"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// httpOptionField is the field number of the google.api.http method option
+const httpOptionField = 72295728
+
+// Field numbers of google.api.HttpRule
+const (
+	ruleGet                = 2
+	rulePut                = 3
+	rulePost               = 4
+	ruleDelete             = 5
+	rulePatch              = 6
+	ruleBody               = 7
+	ruleCustom             = 8
+	ruleAdditionalBindings = 11
+	ruleResponseBody       = 12
+)
+
+// httpRule is one HTTP binding of a method
+type httpRule struct {
+	method       string
+	pattern      string
+	body         string
+	responseBody string
+}
+
+// httpRules returns the bindings declared by the google.api.http option of
+// a method, including its additional bindings. The option is read from the
+// raw options so the annotations package does not need to be linked in.
+func httpRules(method protoreflect.MethodDescriptor) ([]httpRule, error) {
+	raw, err := proto.MarshalOptions{Deterministic: true}.Marshal(method.Options())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read options: %w", err)
+	}
+
+	var rules []httpRule
+	for len(raw) > 0 {
+		num, typ, n := protowire.ConsumeTag(raw)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		raw = raw[n:]
+
+		if num == httpOptionField && typ == protowire.BytesType {
+			value, n := protowire.ConsumeBytes(raw)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			parsed, err := parseHTTPRule(value, true)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, parsed...)
+			raw = raw[n:]
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, raw)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		raw = raw[n:]
+	}
+	return rules, nil
+}
+
+// parseHTTPRule decodes an HttpRule. Additional bindings are only honoured
+// at the top level, as in the annotation's specification.
+func parseHTTPRule(b []byte, top bool) ([]httpRule, error) {
+	var rule httpRule
+	var additional []httpRule
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+
+		value, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch num {
+		case ruleGet:
+			rule.method, rule.pattern = http.MethodGet, string(value)
+		case rulePut:
+			rule.method, rule.pattern = http.MethodPut, string(value)
+		case rulePost:
+			rule.method, rule.pattern = http.MethodPost, string(value)
+		case ruleDelete:
+			rule.method, rule.pattern = http.MethodDelete, string(value)
+		case rulePatch:
+			rule.method, rule.pattern = http.MethodPatch, string(value)
+		case ruleCustom:
+			method, pattern, err := parseCustomPattern(value)
+			if err != nil {
+				return nil, err
+			}
+			rule.method, rule.pattern = method, pattern
+		case ruleBody:
+			rule.body = string(value)
+		case ruleResponseBody:
+			rule.responseBody = string(value)
+		case ruleAdditionalBindings:
+			if !top {
+				continue
+			}
+			nested, err := parseHTTPRule(value, false)
+			if err != nil {
+				return nil, err
+			}
+			additional = append(additional, nested...)
+		}
+	}
+
+	if rule.pattern == "" {
+		return additional, nil
+	}
+	return append([]httpRule{rule}, additional...), nil
+}
+
+// parseCustomPattern decodes a CustomHttpPattern
+func parseCustomPattern(b []byte) (string, string, error) {
+	var method, pattern string
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if typ == protowire.BytesType && (num == 1 || num == 2) {
+			value, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return "", "", protowire.ParseError(n)
+			}
+			if num == 1 {
+				method = string(value)
+			} else {
+				pattern = string(value)
+			}
+			b = b[n:]
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return method, pattern, nil
+}
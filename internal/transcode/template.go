@@ -0,0 +1,208 @@
+package transcode
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// segmentKind is the kind of a path template segment
+type segmentKind int
+
+const (
+	segmentLiteral segmentKind = iota
+	segmentWildcard
+	segmentDeepWildcard
+)
+
+// segment is one segment of a path template
+type segment struct {
+	kind    segmentKind
+	literal string
+}
+
+// variable binds the segments [start, end) of a template to a field
+type variable struct {
+	field      string
+	start, end int
+}
+
+// pathTemplate is a parsed google.api.http path template, e.g.
+// /v1/{name=shelves/*}/books/{book_id}:publish
+type pathTemplate struct {
+	segments  []segment
+	variables []variable
+	verb      string
+}
+
+// parseTemplate parses a path template
+func parseTemplate(tmpl string) (*pathTemplate, error) {
+	if !strings.HasPrefix(tmpl, "/") {
+		return nil, fmt.Errorf("template must start with /: %q", tmpl)
+	}
+
+	t := &pathTemplate{}
+	rest := tmpl[1:]
+	// The verb follows the last segment, outside any variable
+	if i := strings.LastIndex(rest, ":"); i >= 0 && !strings.ContainsAny(rest[i:], "/}") {
+		t.verb = rest[i+1:]
+		rest = rest[:i]
+	}
+	if rest == "" {
+		return t, nil
+	}
+
+	parts, err := splitTemplate(rest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template %q: %w", tmpl, err)
+	}
+
+	for _, part := range parts {
+		if !strings.HasPrefix(part, "{") {
+			seg, err := parseSegment(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid template %q: %w", tmpl, err)
+			}
+			t.segments = append(t.segments, seg)
+			continue
+		}
+
+		field, pattern, found := strings.Cut(part[1:len(part)-1], "=")
+		if !found {
+			pattern = "*"
+		}
+		if field == "" {
+			return nil, fmt.Errorf("invalid template %q: empty variable", tmpl)
+		}
+
+		v := variable{field: field, start: len(t.segments)}
+		for _, sub := range strings.Split(pattern, "/") {
+			seg, err := parseSegment(sub)
+			if err != nil {
+				return nil, fmt.Errorf("invalid template %q: %w", tmpl, err)
+			}
+			t.segments = append(t.segments, seg)
+		}
+		v.end = len(t.segments)
+		t.variables = append(t.variables, v)
+	}
+
+	for i, seg := range t.segments {
+		if seg.kind == segmentDeepWildcard && i != len(t.segments)-1 {
+			return nil, fmt.Errorf("invalid template %q: ** must be the last segment", tmpl)
+		}
+	}
+	return t, nil
+}
+
+// splitTemplate splits a template on the slashes outside variables
+func splitTemplate(s string) ([]string, error) {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			if depth > 0 || i != start {
+				return nil, fmt.Errorf("unexpected {")
+			}
+			depth++
+		case '}':
+			if depth == 0 || (i+1 < len(s) && s[i+1] != '/') {
+				return nil, fmt.Errorf("unexpected }")
+			}
+			depth--
+		case '/':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unterminated variable")
+	}
+	return append(parts, s[start:]), nil
+}
+
+// parseSegment parses a literal or wildcard segment
+func parseSegment(s string) (segment, error) {
+	switch {
+	case s == "*":
+		return segment{kind: segmentWildcard}, nil
+	case s == "**":
+		return segment{kind: segmentDeepWildcard}, nil
+	case s == "" || strings.ContainsAny(s, "{}*"):
+		return segment{}, fmt.Errorf("invalid segment %q", s)
+	default:
+		return segment{kind: segmentLiteral, literal: s}, nil
+	}
+}
+
+// literals returns the number of literal segments, used to prefer the most
+// specific template when several match
+func (t *pathTemplate) literals() int {
+	n := 0
+	for _, seg := range t.segments {
+		if seg.kind == segmentLiteral {
+			n++
+		}
+	}
+	if t.verb != "" {
+		n++
+	}
+	return n
+}
+
+// match matches an escaped request path and returns the unescaped values
+// of the template variables
+func (t *pathTemplate) match(path string) (map[string]string, bool) {
+	if t.verb != "" {
+		if !strings.HasSuffix(path, ":"+t.verb) {
+			return nil, false
+		}
+		path = strings.TrimSuffix(path, ":"+t.verb)
+	}
+
+	var parts []string
+	if trimmed := strings.TrimPrefix(path, "/"); trimmed != "" {
+		parts = strings.Split(trimmed, "/")
+	}
+	for i, part := range parts {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			return nil, false
+		}
+		parts[i] = unescaped
+	}
+
+	end := 0
+	for _, seg := range t.segments {
+		switch seg.kind {
+		case segmentDeepWildcard:
+			end = len(parts)
+		case segmentWildcard:
+			if end >= len(parts) || parts[end] == "" {
+				return nil, false
+			}
+			end++
+		case segmentLiteral:
+			if end >= len(parts) || parts[end] != seg.literal {
+				return nil, false
+			}
+			end++
+		}
+	}
+	if end != len(parts) {
+		return nil, false
+	}
+
+	vars := make(map[string]string, len(t.variables))
+	for _, v := range t.variables {
+		last := v.end
+		if t.segments[v.end-1].kind == segmentDeepWildcard {
+			last = len(parts)
+		}
+		vars[v.field] = strings.Join(parts[v.start:last], "/")
+	}
+	return vars, true
+}
@@ -0,0 +1,477 @@
+package transcode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// maxBodyBytes matches the default gRPC message size limit
+const maxBodyBytes = 4 << 20
+
+// ErrBodyTooLarge is returned when a request body exceeds the message limit
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// errUnknownField is returned when a field path does not exist
+var errUnknownField = errors.New("unknown field")
+
+// Transcoder maps REST calls onto the unary gRPC methods of a descriptor set
+// using their google.api.http annotations
+type Transcoder struct {
+	routes    []*route
+	marshal   protojson.MarshalOptions
+	unmarshal protojson.UnmarshalOptions
+}
+
+// route is one HTTP binding of a method
+type route struct {
+	httpMethod   string
+	template     *pathTemplate
+	method       protoreflect.MethodDescriptor
+	fullMethod   string
+	body         string // "", "*" or a field path
+	responseBody string
+}
+
+// Load reads a FileDescriptorSet, as written by protoc with
+// --include_imports --descriptor_set_out, and creates a transcoder for the
+// named services, or every annotated service if none are named
+func Load(path string, services []string) (*Transcoder, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read descriptor set: %w", err)
+	}
+
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse descriptor set: %w", err)
+	}
+
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set: %w", err)
+	}
+	return New(files, services)
+}
+
+// New creates a transcoder for the named services of files, or every
+// annotated service if none are named. Streaming methods are not exposed.
+func New(files *protoregistry.Files, services []string) (*Transcoder, error) {
+	wanted := make(map[string]bool, len(services))
+	for _, name := range services {
+		wanted[name] = true
+	}
+
+	types := dynamicpb.NewTypes(files)
+	t := &Transcoder{
+		marshal:   protojson.MarshalOptions{Resolver: types, EmitUnpopulated: true},
+		unmarshal: protojson.UnmarshalOptions{Resolver: types},
+	}
+
+	found := make(map[string]bool)
+	var rangeErr error
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		for i := 0; i < fd.Services().Len(); i++ {
+			sd := fd.Services().Get(i)
+			name := string(sd.FullName())
+			if len(wanted) > 0 && !wanted[name] {
+				continue
+			}
+			found[name] = true
+
+			if rangeErr = t.addService(sd); rangeErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if rangeErr != nil {
+		return nil, rangeErr
+	}
+
+	for name := range wanted {
+		if !found[name] {
+			return nil, fmt.Errorf("service not found in descriptor set: %s", name)
+		}
+	}
+	if len(t.routes) == 0 {
+		return nil, fmt.Errorf("no methods with HTTP bindings found")
+	}
+
+	// Prefer the most specific template when several match
+	sort.SliceStable(t.routes, func(i, j int) bool {
+		return t.routes[i].template.literals() > t.routes[j].template.literals()
+	})
+	return t, nil
+}
+
+// addService adds the HTTP bindings of a service's unary methods
+func (t *Transcoder) addService(sd protoreflect.ServiceDescriptor) error {
+	for i := 0; i < sd.Methods().Len(); i++ {
+		md := sd.Methods().Get(i)
+		if md.IsStreamingClient() || md.IsStreamingServer() {
+			continue
+		}
+
+		rules, err := httpRules(md)
+		if err != nil {
+			return fmt.Errorf("method %s: invalid HTTP rule: %w", md.FullName(), err)
+		}
+
+		for _, rule := range rules {
+			r, err := newRoute(md, rule)
+			if err != nil {
+				return fmt.Errorf("method %s: %w", md.FullName(), err)
+			}
+			t.routes = append(t.routes, r)
+		}
+	}
+	return nil
+}
+
+// newRoute validates a binding against the method's messages
+func newRoute(md protoreflect.MethodDescriptor, rule httpRule) (*route, error) {
+	tmpl, err := parseTemplate(rule.pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range tmpl.variables {
+		if _, err := resolveField(md.Input(), v.field); err != nil {
+			return nil, fmt.Errorf("path variable %s: %w", v.field, err)
+		}
+	}
+	if rule.body != "" && rule.body != "*" {
+		if _, err := resolveField(md.Input(), rule.body); err != nil {
+			return nil, fmt.Errorf("body %s: %w", rule.body, err)
+		}
+	}
+	if rule.responseBody != "" {
+		if _, err := resolveField(md.Output(), rule.responseBody); err != nil {
+			return nil, fmt.Errorf("response body %s: %w", rule.responseBody, err)
+		}
+	}
+
+	return &route{
+		httpMethod:   strings.ToUpper(rule.method),
+		template:     tmpl,
+		method:       md,
+		fullMethod:   fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name()),
+		body:         rule.body,
+		responseBody: rule.responseBody,
+	}, nil
+}
+
+// Call is a REST request matched to a gRPC method
+type Call struct {
+	transcoder *Transcoder
+	route      *route
+	vars       map[string]string
+}
+
+// Match finds the method bound to an HTTP method and escaped path
+func (t *Transcoder) Match(method, path string) (*Call, bool) {
+	for _, r := range t.routes {
+		if r.httpMethod != method && r.httpMethod != "*" {
+			continue
+		}
+		if vars, ok := r.template.match(path); ok {
+			return &Call{transcoder: t, route: r, vars: vars}, true
+		}
+	}
+	return nil, false
+}
+
+// FullMethod returns the gRPC method name, e.g. /pkg.Service/Method
+func (c *Call) FullMethod() string {
+	return c.route.fullMethod
+}
+
+// Request builds the gRPC request from the body, path variables and query
+// parameters. Query parameters naming no field are ignored.
+func (c *Call) Request(r *http.Request) (proto.Message, error) {
+	msg := dynamicpb.NewMessage(c.route.method.Input())
+
+	if c.route.body != "" && r.Body != nil {
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read body: %w", err)
+		}
+		if len(data) > maxBodyBytes {
+			return nil, ErrBodyTooLarge
+		}
+		if len(bytes.TrimSpace(data)) > 0 {
+			if err := c.setBody(msg, data); err != nil {
+				return nil, fmt.Errorf("invalid body: %w", err)
+			}
+		}
+	}
+
+	for field, value := range c.vars {
+		if err := setField(c.transcoder.unmarshal, msg, field, []string{value}); err != nil {
+			return nil, fmt.Errorf("invalid path parameter %s: %w", field, err)
+		}
+	}
+
+	if c.route.body != "*" {
+		for key, values := range r.URL.Query() {
+			if _, bound := c.vars[key]; bound || key == c.route.body {
+				continue
+			}
+			err := setField(c.transcoder.unmarshal, msg, key, values)
+			if err != nil && !errors.Is(err, errUnknownField) {
+				return nil, fmt.Errorf("invalid query parameter %s: %w", key, err)
+			}
+		}
+	}
+	return msg, nil
+}
+
+// setBody decodes the body into the whole message or the body field
+func (c *Call) setBody(msg *dynamicpb.Message, data []byte) error {
+	if c.route.body == "*" {
+		return c.transcoder.unmarshal.Unmarshal(data, msg)
+	}
+
+	parent, fd, err := mutableField(msg, c.route.body)
+	if err != nil {
+		return err
+	}
+	// Decode the value as the field of an otherwise empty message
+	wrapped := parent.New()
+	if err := c.transcoder.unmarshal.Unmarshal(wrapField(fd, data), wrapped.Interface()); err != nil {
+		return err
+	}
+	parent.Set(fd, wrapped.Get(fd))
+	return nil
+}
+
+// NewResponse returns an empty response message for the method
+func (c *Call) NewResponse() proto.Message {
+	return dynamicpb.NewMessage(c.route.method.Output())
+}
+
+// Response encodes the gRPC response, or its response body field, as JSON
+func (c *Call) Response(msg proto.Message) ([]byte, error) {
+	if c.route.responseBody == "" {
+		return c.transcoder.marshal.Marshal(msg)
+	}
+
+	parent := msg.ProtoReflect()
+	names := strings.Split(c.route.responseBody, ".")
+	for _, name := range names[:len(names)-1] {
+		fd := findField(parent.Descriptor(), name)
+		parent = parent.Get(fd).Message()
+	}
+	fd := findField(parent.Descriptor(), names[len(names)-1])
+
+	// Encode a message holding only the field and extract its value
+	only := parent.New()
+	if parent.Has(fd) {
+		only.Set(fd, parent.Get(fd))
+	}
+	data, err := c.transcoder.marshal.Marshal(only.Interface())
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if value, ok := fields[fd.JSONName()]; ok {
+		return value, nil
+	}
+	return []byte("null"), nil
+}
+
+// HTTPStatus maps a gRPC status code to the HTTP status returned to REST
+// clients
+func HTTPStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499 // Client closed request
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// findField looks a field up by its proto or JSON name
+func findField(md protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	fields := md.Fields()
+	if fd := fields.ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+	return fields.ByJSONName(name)
+}
+
+// resolveField checks that a dotted field path exists, with every element
+// but the last naming a singular message field
+func resolveField(md protoreflect.MessageDescriptor, path string) (protoreflect.FieldDescriptor, error) {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		fd := findField(md, name)
+		if fd == nil {
+			return nil, fmt.Errorf("%w: %s", errUnknownField, path)
+		}
+		if i == len(names)-1 {
+			return fd, nil
+		}
+		if fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+			return nil, fmt.Errorf("%s is not a message field", name)
+		}
+		md = fd.Message()
+	}
+	return nil, fmt.Errorf("%w: %s", errUnknownField, path)
+}
+
+// mutableField returns the message holding the last field of a dotted path,
+// creating the intermediate messages
+func mutableField(msg protoreflect.Message, path string) (protoreflect.Message, protoreflect.FieldDescriptor, error) {
+	if _, err := resolveField(msg.Descriptor(), path); err != nil {
+		return nil, nil, err
+	}
+
+	names := strings.Split(path, ".")
+	for _, name := range names[:len(names)-1] {
+		msg = msg.Mutable(findField(msg.Descriptor(), name)).Message()
+	}
+	return msg, findField(msg.Descriptor(), names[len(names)-1]), nil
+}
+
+// setField sets the field at a dotted path from its string form. Repeated
+// fields take every value, singular fields the last.
+func setField(opts protojson.UnmarshalOptions, msg protoreflect.Message, path string, values []string) error {
+	parent, fd, err := mutableField(msg, path)
+	if err != nil {
+		return err
+	}
+	if fd.IsMap() {
+		return fmt.Errorf("map fields cannot be set from the URL")
+	}
+
+	if fd.IsList() {
+		list := parent.Mutable(fd).List()
+		for _, value := range values {
+			v, err := parseValue(opts, fd, list.NewElement(), value)
+			if err != nil {
+				return err
+			}
+			list.Append(v)
+		}
+		return nil
+	}
+
+	var field protoreflect.Value
+	if fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind {
+		field = parent.NewField(fd)
+	}
+	v, err := parseValue(opts, fd, field, values[len(values)-1])
+	if err != nil {
+		return err
+	}
+	parent.Set(fd, v)
+	return nil
+}
+
+// parseValue parses a scalar from its string form. Message fields accept
+// the JSON string form of well-known types such as timestamps and wrappers;
+// empty is a new message to decode into.
+func parseValue(opts protojson.UnmarshalOptions, fd protoreflect.FieldDescriptor, empty protoreflect.Value, s string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(b), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		n, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(n)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(n), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		n, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(n)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(n), err
+	case protoreflect.FloatKind:
+		f, err := strconv.ParseFloat(s, 32)
+		return protoreflect.ValueOfFloat32(float32(f)), err
+	case protoreflect.DoubleKind:
+		f, err := strconv.ParseFloat(s, 64)
+		return protoreflect.ValueOfFloat64(f), err
+	case protoreflect.BytesKind:
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			b, err = base64.URLEncoding.DecodeString(s)
+		}
+		return protoreflect.ValueOfBytes(b), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("unknown enum value %q", s)
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+	default:
+		m := empty.Message().Interface()
+		if err := opts.Unmarshal([]byte(strconv.Quote(s)), m); err != nil {
+			// Wrappers of numbers and booleans take the bare value
+			proto.Reset(m)
+			if err := opts.Unmarshal([]byte(s), m); err != nil {
+				return protoreflect.Value{}, err
+			}
+		}
+		return empty, nil
+	}
+}
+
+// wrapField returns a JSON object holding data as the field's value
+func wrapField(fd protoreflect.FieldDescriptor, data []byte) []byte {
+	wrapped := make([]byte, 0, len(data)+len(fd.JSONName())+5)
+	wrapped = append(wrapped, '{')
+	wrapped = strconv.AppendQuote(wrapped, fd.JSONName())
+	wrapped = append(wrapped, ':')
+	wrapped = append(wrapped, data...)
+	return append(wrapped, '}')
+}
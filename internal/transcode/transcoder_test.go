@@ -0,0 +1,237 @@
+package transcode
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// httpOption encodes a google.api.http option binding pattern with method
+func httpOption(methodField protowire.Number, pattern, body string) *descriptorpb.MethodOptions {
+	rule := protowire.AppendTag(nil, methodField, protowire.BytesType)
+	rule = protowire.AppendString(rule, pattern)
+	if body != "" {
+		rule = protowire.AppendTag(rule, ruleBody, protowire.BytesType)
+		rule = protowire.AppendString(rule, body)
+	}
+
+	raw := protowire.AppendTag(nil, httpOptionField, protowire.BytesType)
+	raw = protowire.AppendBytes(raw, rule)
+
+	opts := &descriptorpb.MethodOptions{}
+	opts.ProtoReflect().SetUnknown(raw)
+	return opts
+}
+
+func testFiles(t *testing.T) *protoregistry.Files {
+	t.Helper()
+
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, repeated bool, typeName string) *descriptorpb.FieldDescriptorProto {
+		label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		if repeated {
+			label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+		}
+		fd := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(number),
+			Type:     typ.Enum(),
+			Label:    label.Enum(),
+			JsonName: proto.String(jsonName(name)),
+		}
+		if typeName != "" {
+			fd.TypeName = proto.String(typeName)
+		}
+		return fd
+	}
+	str := descriptorpb.FieldDescriptorProto_TYPE_STRING
+	i32 := descriptorpb.FieldDescriptorProto_TYPE_INT32
+	msg := descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("library.proto"),
+		Package: proto.String("library.v1"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Book"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("name", 1, str, false, ""),
+				field("title", 2, str, false, ""),
+				field("page_count", 3, i32, false, ""),
+			}},
+			{Name: proto.String("GetBookRequest"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("name", 1, str, false, ""),
+				field("revision", 2, i32, false, ""),
+				field("tags", 3, str, true, ""),
+			}},
+			{Name: proto.String("CreateBookRequest"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("parent", 1, str, false, ""),
+				field("book", 2, msg, false, ".library.v1.Book"),
+			}},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Library"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{
+					Name:       proto.String("GetBook"),
+					InputType:  proto.String(".library.v1.GetBookRequest"),
+					OutputType: proto.String(".library.v1.Book"),
+					Options:    httpOption(ruleGet, "/v1/{name=shelves/*/books/*}", ""),
+				},
+				{
+					Name:       proto.String("CreateBook"),
+					InputType:  proto.String(".library.v1.CreateBookRequest"),
+					OutputType: proto.String(".library.v1.Book"),
+					Options:    httpOption(rulePost, "/v1/{parent=shelves/*}/books", "book"),
+				},
+				{
+					Name:       proto.String("PublishBook"),
+					InputType:  proto.String(".library.v1.GetBookRequest"),
+					OutputType: proto.String(".library.v1.Book"),
+					Options:    httpOption(rulePost, "/v1/{name=shelves/*/books/*}:publish", "*"),
+				},
+			},
+		}},
+	}
+
+	files, err := protodesc.NewFiles(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	if err != nil {
+		t.Fatalf("NewFiles() error = %v", err)
+	}
+	return files
+}
+
+// jsonName returns the lowerCamelCase JSON name of a field
+func jsonName(name string) string {
+	parts := strings.Split(name, "_")
+	for i := 1; i < len(parts); i++ {
+		parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+	}
+	return strings.Join(parts, "")
+}
+
+func TestTemplateMatch(t *testing.T) {
+	tests := []struct {
+		template string
+		path     string
+		match    bool
+		vars     map[string]string
+	}{
+		{"/v1/books/{id}", "/v1/books/42", true, map[string]string{"id": "42"}},
+		{"/v1/books/{id}", "/v1/books/a%2Fb", true, map[string]string{"id": "a/b"}},
+		{"/v1/books/{id}", "/v1/books", false, nil},
+		{"/v1/books/{id}", "/v1/books/42/pages", false, nil},
+		{"/v1/{name=shelves/*/books/*}", "/v1/shelves/1/books/2", true, map[string]string{"name": "shelves/1/books/2"}},
+		{"/v1/{name=files/**}", "/v1/files/a/b/c", true, map[string]string{"name": "files/a/b/c"}},
+		{"/v1/books/{id}:publish", "/v1/books/42:publish", true, map[string]string{"id": "42"}},
+		{"/v1/books/{id}:publish", "/v1/books/42", false, nil},
+	}
+
+	for _, tt := range tests {
+		tmpl, err := parseTemplate(tt.template)
+		if err != nil {
+			t.Fatalf("parseTemplate(%q) error = %v", tt.template, err)
+		}
+		vars, ok := tmpl.match(tt.path)
+		if ok != tt.match {
+			t.Errorf("%s match(%q) = %v, want %v", tt.template, tt.path, ok, tt.match)
+			continue
+		}
+		for name, want := range tt.vars {
+			if vars[name] != want {
+				t.Errorf("%s match(%q)[%s] = %q, want %q", tt.template, tt.path, name, vars[name], want)
+			}
+		}
+	}
+
+	for _, invalid := range []string{"v1/books", "/v1/**/books", "/v1/{id", "/v1/b{id}"} {
+		if _, err := parseTemplate(invalid); err == nil {
+			t.Errorf("parseTemplate(%q) succeeded, want error", invalid)
+		}
+	}
+}
+
+func TestTranscoderRequest(t *testing.T) {
+	transcoder, err := New(testFiles(t), nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		method, target, body string
+		fullMethod           string
+		want                 string
+	}{
+		{
+			http.MethodGet, "/v1/shelves/1/books/2?revision=3&tags=a&tags=b&unknown=x", "",
+			"/library.v1.Library/GetBook",
+			`{"name":"shelves/1/books/2","revision":3,"tags":["a","b"]}`,
+		},
+		{
+			http.MethodPost, "/v1/shelves/1/books", `{"title":"Dune","pageCount":412}`,
+			"/library.v1.Library/CreateBook",
+			`{"parent":"shelves/1","book":{"title":"Dune","pageCount":412}}`,
+		},
+		{
+			http.MethodPost, "/v1/shelves/1/books/2:publish", `{"revision":7}`,
+			"/library.v1.Library/PublishBook",
+			`{"name":"shelves/1/books/2","revision":7}`,
+		},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		call, ok := transcoder.Match(r.Method, r.URL.EscapedPath())
+		if !ok {
+			t.Fatalf("Match(%s %s) found no method", tt.method, tt.target)
+		}
+		if call.FullMethod() != tt.fullMethod {
+			t.Errorf("FullMethod() = %s, want %s", call.FullMethod(), tt.fullMethod)
+		}
+
+		msg, err := call.Request(r)
+		if err != nil {
+			t.Fatalf("Request(%s %s) error = %v", tt.method, tt.target, err)
+		}
+		want := dynamicpb.NewMessage(msg.ProtoReflect().Descriptor())
+		if err := protojson.Unmarshal([]byte(tt.want), want); err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(msg, want) {
+			t.Errorf("Request(%s %s) = %v, want %v", tt.method, tt.target, msg, want)
+		}
+	}
+
+	if _, ok := transcoder.Match(http.MethodDelete, "/v1/shelves/1/books/2"); ok {
+		t.Error("Match() found a method for an unbound HTTP method")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/shelves/1/books/2?revision=abc", nil)
+	call, _ := transcoder.Match(r.Method, r.URL.EscapedPath())
+	if _, err := call.Request(r); err == nil {
+		t.Error("Request() accepted an invalid integer")
+	}
+}
+
+func TestNewUnknownService(t *testing.T) {
+	if _, err := New(testFiles(t), []string{"library.v1.Archive"}); err == nil {
+		t.Error("New() succeeded for a service missing from the descriptors")
+	}
+}
+
+func TestHTTPStatus(t *testing.T) {
+	if got := HTTPStatus(codes.NotFound); got != http.StatusNotFound {
+		t.Errorf("HTTPStatus(NotFound) = %d", got)
+	}
+	if got := HTTPStatus(codes.Unauthenticated); got != http.StatusUnauthorized {
+		t.Errorf("HTTPStatus(Unauthenticated) = %d", got)
+	}
+}
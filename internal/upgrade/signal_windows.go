@@ -0,0 +1,8 @@
+//go:build windows
+
+package upgrade
+
+import "os"
+
+// Notify does nothing: listener handover is not supported on Windows
+func Notify(c chan<- os.Signal) {}
@@ -0,0 +1,321 @@
+package upgrade
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// The environment a new process is started with. Inherited listeners are
+// passed as file descriptors from 3 up in the order of listenersEnv, which
+// holds name=address pairs; the descriptor after them is the pipe the new
+// process reports readiness on.
+const (
+	listenersEnv = "GATEWAY_UPGRADE_LISTENERS"
+	readyEnv     = "GATEWAY_UPGRADE_READY"
+)
+
+// firstInheritedFD is the descriptor of the first of exec.Cmd.ExtraFiles
+const firstInheritedFD = 3
+
+var (
+	// ErrDisabled is returned when upgrades are not enabled
+	ErrDisabled = errors.New("binary upgrades are disabled")
+	// ErrInProgress is returned while another upgrade is running
+	ErrInProgress = errors.New("upgrade already in progress")
+)
+
+// inherited is a listener handed over by the previous process
+type inherited struct {
+	address string
+	file    *os.File
+}
+
+// fileListener is a listener whose socket can be duplicated
+type fileListener interface {
+	net.Listener
+	File() (*os.File, error)
+}
+
+// listener is a listener of this process, handed over on upgrade
+type listener struct {
+	address string
+	ln      fileListener
+}
+
+// Upgrader hands the listening sockets of the gateway to a new process so
+// a binary can be replaced without refusing connections. The new process
+// inherits the sockets, reports ready once it serves, and the old process
+// then drains and exits.
+type Upgrader struct {
+	cfg    config.UpgradeConfig
+	logger *zap.Logger
+
+	mu        sync.Mutex
+	inherited map[string]inherited
+	listeners map[string]listener
+	parent    *os.File
+
+	upgrading atomic.Bool
+}
+
+// New creates an upgrader, taking over the listeners passed by a previous
+// process if this process was started by an upgrade
+func New(cfg config.UpgradeConfig, logger *zap.Logger) (*Upgrader, error) {
+	u := &Upgrader{
+		cfg:       cfg,
+		logger:    logger,
+		inherited: make(map[string]inherited),
+		listeners: make(map[string]listener),
+	}
+
+	pairs := os.Getenv(listenersEnv)
+	_, fromParent := os.LookupEnv(readyEnv)
+	// Processes started by this one must not see the handover
+	os.Unsetenv(listenersEnv)
+	os.Unsetenv(readyEnv)
+	if !fromParent {
+		return u, nil
+	}
+
+	fd := firstInheritedFD
+	if pairs != "" {
+		for _, pair := range strings.Split(pairs, ",") {
+			name, address, ok := strings.Cut(pair, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid inherited listener %q", pair)
+			}
+			u.inherited[name] = inherited{address: address, file: os.NewFile(uintptr(fd), name)}
+			fd++
+		}
+	}
+	u.parent = os.NewFile(uintptr(fd), "upgrade-ready")
+	logger.Info("Started by binary upgrade", zap.Int("inherited_listeners", len(u.inherited)))
+	return u, nil
+}
+
+// Upgraded reports whether this process was started by an upgrade
+func (u *Upgrader) Upgraded() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.parent != nil
+}
+
+// Listen returns a TCP listener for address, taking over the listener the
+// previous process had under name when its address is unchanged
+func (u *Upgrader) Listen(name, address string) (net.Listener, error) {
+	return u.ListenWith(name, address, func() (net.Listener, error) {
+		ln, err := net.Listen("tcp", address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", address, err)
+		}
+		return ln, nil
+	})
+}
+
+// ListenWith takes over the listener the previous process had under name
+// when it had the same address, and otherwise creates it with listen. The
+// address only identifies the socket and need not be a network address.
+func (u *Upgrader) ListenWith(name, address string, listen func() (net.Listener, error)) (net.Listener, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var ln net.Listener
+	if old, ok := u.inherited[name]; ok {
+		delete(u.inherited, name)
+		if old.address == address {
+			var err error
+			ln, err = net.FileListener(old.file)
+			old.file.Close()
+			if err != nil {
+				return nil, fmt.Errorf("failed to inherit %s listener: %w", name, err)
+			}
+		} else {
+			old.file.Close()
+		}
+	}
+	if ln == nil {
+		var err error
+		if ln, err = listen(); err != nil {
+			return nil, err
+		}
+	}
+
+	fl, ok := ln.(fileListener)
+	if !ok {
+		ln.Close()
+		return nil, fmt.Errorf("%s listener cannot be handed over", name)
+	}
+	u.listeners[name] = listener{address: address, ln: fl}
+	return fl, nil
+}
+
+// Ready reports to the previous process that this one serves, so it can
+// drain, and writes the PID file. Inherited listeners that were not taken
+// over are closed.
+func (u *Upgrader) Ready() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for name, old := range u.inherited {
+		old.file.Close()
+		delete(u.inherited, name)
+	}
+
+	if u.cfg.PIDFile != "" {
+		if err := writePIDFile(u.cfg.PIDFile, os.Getpid()); err != nil {
+			return err
+		}
+	}
+
+	if u.parent == nil {
+		return nil
+	}
+	_, err := u.parent.Write([]byte{1})
+	u.parent.Close()
+	u.parent = nil
+	if err != nil {
+		return fmt.Errorf("failed to notify previous process: %w", err)
+	}
+	return nil
+}
+
+// Upgrade starts the current executable with the listeners of this process
+// and waits until it is ready. On success the caller should stop accepting
+// connections and drain; on failure the new process is stopped and this
+// one keeps serving.
+func (u *Upgrader) Upgrade() error {
+	if !u.cfg.Enabled {
+		return ErrDisabled
+	}
+	if !u.upgrading.CompareAndSwap(false, true) {
+		return ErrInProgress
+	}
+	defer u.upgrading.Store(false)
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate executable: %w", err)
+	}
+
+	names, files, err := u.files()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create readiness pipe: %w", err)
+	}
+	defer readyR.Close()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), listenersEnv+"="+strings.Join(names, ","), readyEnv+"=1")
+	cmd.ExtraFiles = append(files, readyW)
+	if err := cmd.Start(); err != nil {
+		readyW.Close()
+		return fmt.Errorf("failed to start new process: %w", err)
+	}
+	readyW.Close()
+	u.logger.Info("Started new process", zap.Int("pid", cmd.Process.Pid), zap.String("executable", executable))
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	ready := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(readyR, make([]byte, 1))
+		ready <- err
+	}()
+
+	timer := time.NewTimer(u.cfg.ReadyTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-ready:
+		if err == nil {
+			return nil
+		}
+		// The pipe closed without a byte: the new process is gone or closed
+		// it; make sure it is gone
+		cmd.Process.Kill()
+		return fmt.Errorf("new process exited before becoming ready: %v", <-exited)
+	case err := <-exited:
+		return fmt.Errorf("new process exited before becoming ready: %v", err)
+	case <-timer.C:
+		cmd.Process.Kill()
+		return fmt.Errorf("new process not ready within %s", u.cfg.ReadyTimeout)
+	}
+}
+
+// files duplicates the descriptors of the listeners, ordered by name
+func (u *Upgrader) files() ([]string, []*os.File, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	names := make([]string, 0, len(u.listeners))
+	for name := range u.listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	files := make([]*os.File, 0, len(names))
+	for _, name := range names {
+		l := u.listeners[name]
+		f, err := l.ln.File()
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, nil, fmt.Errorf("failed to hand over %s listener: %w", name, err)
+		}
+		pairs = append(pairs, name+"="+l.address)
+		files = append(files, f)
+	}
+	return pairs, files, nil
+}
+
+// writePIDFile replaces the PID file atomically so readers never see it
+// empty
+func writePIDFile(path string, pid int) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return fmt.Errorf("failed to write PID file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(strconv.Itoa(pid) + "\n"); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write PID file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write PID file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("failed to write PID file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write PID file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package upgrade
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestListenInheritsUnchangedAddress(t *testing.T) {
+	previous, err := New(config.UpgradeConfig{Enabled: true}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := previous.Listen("http", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	address := ln.Addr().String()
+
+	// Hand the socket over as an upgrade would
+	file, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := New(config.UpgradeConfig{Enabled: true}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	next.inherited["http"] = inherited{address: address, file: file}
+
+	inheritedLn, err := next.Listen("http", address)
+	if err != nil {
+		t.Fatalf("listening on the address the previous process holds: %v", err)
+	}
+	defer inheritedLn.Close()
+	if inheritedLn.Addr().String() != address {
+		t.Fatalf("inherited listener on %s, want %s", inheritedLn.Addr(), address)
+	}
+
+	// The new process accepts connections queued on the shared socket
+	ln.Close()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	accepted, err := inheritedLn.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted.Close()
+}
+
+func TestReadyWritesPIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.pid")
+	u, err := New(config.UpgradeConfig{Enabled: true, PIDFile: path}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Ready(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid := strings.TrimSpace(string(data)); pid != strconv.Itoa(os.Getpid()) {
+		t.Fatalf("PID file holds %q, want %d", pid, os.Getpid())
+	}
+}
+
+func TestUpgradeDisabled(t *testing.T) {
+	u, err := New(config.UpgradeConfig{}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Upgrade(); err != ErrDisabled {
+		t.Fatalf("Upgrade() = %v, want ErrDisabled", err)
+	}
+}
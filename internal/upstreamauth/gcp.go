@@ -0,0 +1,154 @@
+package upstreamauth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+const (
+	// metadataHost serves ID tokens on GCE, GKE, Cloud Run and Cloud
+	// Functions; GCE_METADATA_HOST overrides it
+	metadataHost   = "metadata.google.internal"
+	jwtBearerGrant = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+)
+
+// serviceAccountKey is the part of a service account key file used here
+type serviceAccountKey struct {
+	Type        string `json:"type"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// gcpIDToken fetches Google-signed ID tokens for an audience
+type gcpIDToken struct {
+	audience   string
+	key        *serviceAccountKey
+	privateKey *rsa.PrivateKey
+	client     *http.Client
+}
+
+// newGCPIDToken creates an ID token signer, reading the service account
+// key if one is configured
+func newGCPIDToken(cfg config.GCPIDTokenConfig, logger *zap.Logger) (*tokenCache, error) {
+	g := &gcpIDToken{
+		audience: cfg.Audience,
+		client:   &http.Client{Timeout: tokenTimeout},
+	}
+	signer := &tokenCache{name: config.UpstreamAuthGCPIDToken, fetch: g.fetch, logger: logger}
+	if cfg.CredentialsFile == "" {
+		return signer, nil
+	}
+
+	data, err := os.ReadFile(cfg.CredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GCP credentials: %w", err)
+	}
+	var key serviceAccountKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("invalid GCP credentials: %w", err)
+	}
+	if key.Type != "service_account" || key.ClientEmail == "" || key.PrivateKey == "" {
+		return nil, fmt.Errorf("GCP credentials must be a service account key")
+	}
+	if key.TokenURI == "" {
+		key.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	if g.privateKey, err = jwt.ParseRSAPrivateKeyFromPEM([]byte(key.PrivateKey)); err != nil {
+		return nil, fmt.Errorf("invalid GCP service account private key: %w", err)
+	}
+	g.key = &key
+	return signer, nil
+}
+
+// fetch obtains a new token with its expiry
+func (g *gcpIDToken) fetch(ctx context.Context) (string, time.Time, error) {
+	var (
+		token string
+		err   error
+	)
+	if g.key != nil {
+		token, err = g.exchangeToken(ctx)
+	} else {
+		token, err = g.metadataToken(ctx)
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	claims := jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, &claims); err != nil || claims.ExpiresAt == nil {
+		return "", time.Time{}, fmt.Errorf("invalid GCP ID token: %v", err)
+	}
+	return token, claims.ExpiresAt.Time, nil
+}
+
+// metadataToken fetches a token for the instance's service account
+func (g *gcpIDToken) metadataToken(ctx context.Context) (string, error) {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = metadataHost
+	}
+	endpoint := "http://" + host + "/computeMetadata/v1/instance/service-accounts/default/identity?" +
+		url.Values{"audience": {g.audience}, "format": {"full"}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	body, err := doTokenRequest(g.client, req)
+	if err != nil {
+		return "", fmt.Errorf("metadata server: %w", err)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// exchangeToken trades a JWT signed with the service account key for an
+// ID token
+func (g *gcpIDToken) exchangeToken(ctx context.Context) (string, error) {
+	now := time.Now()
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":             g.key.ClientEmail,
+		"sub":             g.key.ClientEmail,
+		"aud":             g.key.TokenURI,
+		"iat":             now.Unix(),
+		"exp":             now.Add(time.Hour).Unix(),
+		"target_audience": g.audience,
+	}).SignedString(g.privateKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token request: %w", err)
+	}
+
+	form := url.Values{"grant_type": {jwtBearerGrant}, "assertion": {assertion}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.key.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	body, err := doTokenRequest(g.client, req)
+	if err != nil {
+		return "", fmt.Errorf("token endpoint: %w", err)
+	}
+	var result struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil || result.IDToken == "" {
+		return "", fmt.Errorf("token endpoint returned no id_token")
+	}
+	return result.IDToken, nil
+}
@@ -0,0 +1,81 @@
+package upstreamauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// defaultTokenLifetime is assumed for tokens issued without expires_in
+const defaultTokenLifetime = time.Hour
+
+// clientCredentials fetches access tokens with the OAuth2 client
+// credentials grant
+type clientCredentials struct {
+	cfg    config.OAuth2ClientCredentialsConfig
+	client *http.Client
+}
+
+// newClientCredentials creates a signer sending tokens of the client
+// credentials grant
+func newClientCredentials(cfg config.OAuth2ClientCredentialsConfig, logger *zap.Logger) *tokenCache {
+	c := &clientCredentials{cfg: cfg, client: &http.Client{Timeout: tokenTimeout}}
+	return &tokenCache{name: config.UpstreamAuthOAuth2, fetch: c.fetch, logger: logger}
+}
+
+// fetch requests a new access token from the token endpoint
+func (c *clientCredentials) fetch(ctx context.Context) (string, time.Time, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.cfg.Scopes, " "))
+	}
+	if c.cfg.Audience != "" {
+		form.Set("audience", c.cfg.Audience)
+	}
+	if c.cfg.AuthStyle == config.OAuth2AuthStyleParams {
+		form.Set("client_id", c.cfg.ClientID)
+		form.Set("client_secret", c.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.cfg.AuthStyle != config.OAuth2AuthStyleParams {
+		// RFC 6749 section 2.3.1 form-encodes the credentials first
+		req.SetBasicAuth(url.QueryEscape(c.cfg.ClientID), url.QueryEscape(c.cfg.ClientSecret))
+	}
+
+	issued := time.Now()
+	body, err := doTokenRequest(c.client, req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("token endpoint: %w", err)
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil || result.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("token endpoint returned no access_token")
+	}
+	if result.TokenType != "" && !strings.EqualFold(result.TokenType, "bearer") {
+		return "", time.Time{}, fmt.Errorf("unsupported token type %q", result.TokenType)
+	}
+
+	lifetime := defaultTokenLifetime
+	if result.ExpiresIn > 0 {
+		lifetime = time.Duration(result.ExpiresIn) * time.Second
+	}
+	return result.AccessToken, issued.Add(lifetime), nil
+}
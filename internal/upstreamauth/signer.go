@@ -0,0 +1,49 @@
+package upstreamauth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// ErrBodyTooLarge is returned for request bodies too large to be signed
+var ErrBodyTooLarge = errors.New("request body too large to sign")
+
+// tokenTimeout bounds fetching credentials from a token endpoint
+const tokenTimeout = 10 * time.Second
+
+// Signer adds credentials to the requests sent to a service
+type Signer interface {
+	// Prepare runs once per client request, before any attempt is sent.
+	// It may buffer the body or refresh cached tokens, and returns the
+	// request to forward.
+	Prepare(r *http.Request) (*http.Request, error)
+	// Sign adds credentials to one outgoing attempt. It runs last, so the
+	// request is signed as it is sent.
+	Sign(r *http.Request)
+}
+
+// New returns the signer of a service, or nil when its requests are sent
+// unsigned
+func New(cfg config.UpstreamAuthConfig, logger *zap.Logger) (Signer, error) {
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case config.UpstreamAuthBearer:
+		return &staticHeader{value: "Bearer " + cfg.Bearer.Token}, nil
+	case config.UpstreamAuthBasic:
+		return &staticHeader{value: basicAuth(cfg.Basic.Username, cfg.Basic.Password)}, nil
+	case config.UpstreamAuthOAuth2:
+		return newClientCredentials(cfg.OAuth2, logger), nil
+	case config.UpstreamAuthSigV4:
+		return newSigV4(cfg.SigV4)
+	case config.UpstreamAuthGCPIDToken:
+		return newGCPIDToken(cfg.GCP, logger)
+	}
+	return nil, fmt.Errorf("unknown upstream auth type %q", cfg.Type)
+}
@@ -0,0 +1,162 @@
+package upstreamauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestSigV4(t *testing.T) {
+	// The get-vanilla case of the AWS Signature Version 4 test suite
+	signer, err := newSigV4(config.SigV4Config{
+		Region:          "us-east-1",
+		Service:         "service",
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer.now = func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) }
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.amazonaws.com/", nil)
+	r.Header.Set("Authorization", "Bearer client-token")
+	signer.Sign(r)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := r.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %q, want %q", got, want)
+	}
+	if got := r.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("X-Amz-Date = %q", got)
+	}
+
+	// Bodies are buffered and hashed, and stay readable
+	signer.maxBodyBytes = 8
+	post := httptest.NewRequest(http.MethodPost, "http://example.amazonaws.com/", strings.NewReader("payload"))
+	prepared, err := signer.Prepare(post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prepared.Context().Value(payloadHashKey{}) != hashHex([]byte("payload")) || prepared.ContentLength != 7 {
+		t.Error("payload hash not recorded")
+	}
+	if _, err := signer.Prepare(httptest.NewRequest(http.MethodPost, "/", strings.NewReader("too large payload"))); err != ErrBodyTooLarge {
+		t.Errorf("err = %v, want ErrBodyTooLarge", err)
+	}
+}
+
+func TestGCPIDTokenMetadata(t *testing.T) {
+	fetches := 0
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" || r.URL.Query().Get("audience") != "https://orders.run.app" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fetches++
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}).SignedString([]byte("test"))
+		w.Write([]byte(token))
+	}))
+	defer metadata.Close()
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(metadata.URL, "http://"))
+
+	signer, err := New(config.UpstreamAuthConfig{
+		Type: config.UpstreamAuthGCPIDToken,
+		GCP:  config.GCPIDTokenConfig{Audience: "https://orders.run.app"},
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		r, err := signer.Prepare(httptest.NewRequest(http.MethodGet, "/orders", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer.Sign(r)
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ey") {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("token fetched %d times, want it cached", fetches)
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	fetches := 0
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "gateway" || secret != "s3cret" || r.FormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.FormValue("scope") != "orders.read orders.write" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fetches++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"at-1","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer tokens.Close()
+
+	signer, err := New(config.UpstreamAuthConfig{
+		Type: config.UpstreamAuthOAuth2,
+		OAuth2: config.OAuth2ClientCredentialsConfig{
+			TokenURL:     tokens.URL,
+			ClientID:     "gateway",
+			ClientSecret: "s3cret",
+			Scopes:       []string{"orders.read", "orders.write"},
+		},
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		r, err := signer.Prepare(httptest.NewRequest(http.MethodGet, "/orders", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Authorization", "Bearer client-token")
+		signer.Sign(r)
+		if got := r.Header.Get("Authorization"); got != "Bearer at-1" {
+			t.Errorf("Authorization = %q", got)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("token fetched %d times, want it cached", fetches)
+	}
+}
+
+func TestStaticCredentials(t *testing.T) {
+	tests := []struct {
+		cfg  config.UpstreamAuthConfig
+		want string
+	}{
+		{config.UpstreamAuthConfig{Type: config.UpstreamAuthBearer, Bearer: config.BearerTokenConfig{Token: "abc"}}, "Bearer abc"},
+		{config.UpstreamAuthConfig{Type: config.UpstreamAuthBasic, Basic: config.UpstreamBasicConfig{Username: "user", Password: "pass"}}, "Basic dXNlcjpwYXNz"},
+	}
+	for _, tt := range tests {
+		signer, err := New(tt.cfg, zap.NewNop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		signer.Sign(r)
+		if got := r.Header.Get("Authorization"); got != tt.want {
+			t.Errorf("%s: Authorization = %q, want %q", tt.cfg.Type, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package upstreamauth
+
+import (
+	"net/http"
+)
+
+// staticHeader sets a fixed Authorization header, such as a bearer token
+// or Basic credentials
+type staticHeader struct {
+	value string
+}
+
+// Prepare has nothing to do for fixed credentials
+func (s *staticHeader) Prepare(r *http.Request) (*http.Request, error) {
+	return r, nil
+}
+
+// Sign sets the Authorization header
+func (s *staticHeader) Sign(r *http.Request) {
+	r.Header.Set("Authorization", s.value)
+}
+
+// basicAuth returns the Authorization header of Basic credentials
+func basicAuth(username, password string) string {
+	r := &http.Request{Header: make(http.Header)}
+	r.SetBasicAuth(username, password)
+	return r.Header.Get("Authorization")
+}
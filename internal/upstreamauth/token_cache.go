@@ -0,0 +1,100 @@
+package upstreamauth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// tokenRefreshMargin renews tokens before they expire. Short-lived tokens
+// are renewed after half their lifetime instead.
+const tokenRefreshMargin = 5 * time.Minute
+
+// tokenCache holds a bearer token fetched from a token endpoint, refreshed
+// shortly before it expires
+type tokenCache struct {
+	name   string
+	fetch  func(ctx context.Context) (token string, expires time.Time, err error)
+	logger *zap.Logger
+
+	// refreshMu lets one request refresh the token while the others keep
+	// signing with the cached one
+	refreshMu sync.Mutex
+	mu        sync.RWMutex
+	token     string
+	expires   time.Time
+	refreshAt time.Time
+}
+
+// Prepare makes sure a valid token is cached
+func (c *tokenCache) Prepare(r *http.Request) (*http.Request, error) {
+	if c.fresh() {
+		return r, nil
+	}
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	if c.fresh() {
+		return r, nil
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), tokenTimeout)
+	defer cancel()
+	token, expires, err := c.fetch(ctx)
+	if err != nil {
+		// An unexpired token is still better than none
+		c.mu.RLock()
+		usable := c.token != "" && time.Now().Before(c.expires)
+		c.mu.RUnlock()
+		if usable {
+			c.logger.Warn("Failed to refresh upstream token, using the cached one",
+				zap.String("type", c.name),
+				zap.Error(err))
+			return r, nil
+		}
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.token, c.expires = token, expires
+	c.refreshAt = expires.Add(-min(tokenRefreshMargin, time.Until(expires)/2))
+	c.mu.Unlock()
+	return r, nil
+}
+
+// fresh reports whether the cached token is far enough from expiring
+func (c *tokenCache) fresh() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.token != "" && time.Now().Before(c.refreshAt)
+}
+
+// Sign adds the cached token as a bearer token
+func (c *tokenCache) Sign(r *http.Request) {
+	c.mu.RLock()
+	token := c.token
+	c.mu.RUnlock()
+	r.Header.Set("Authorization", "Bearer "+token)
+}
+
+// doTokenRequest sends a token request and returns the body of a 200 response
+func doTokenRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return body, nil
+}
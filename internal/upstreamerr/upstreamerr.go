@@ -0,0 +1,107 @@
+package upstreamerr
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+)
+
+// Kind classifies an upstream failure. Kinds are used as metric labels and
+// in event payloads.
+type Kind string
+
+// Upstream failure kinds
+const (
+	Timeout           Kind = "timeout"
+	ConnectionRefused Kind = "connection_refused"
+	DNSFailure        Kind = "dns_failure"
+	TLSError          Kind = "tls_error"
+	ConnectionReset   Kind = "connection_reset"
+	// BodyRead is a failure reading the upstream response body after the
+	// response headers were received
+	BodyRead Kind = "body_read_error"
+	// ResponseTooLarge is a response over the configured size limit
+	ResponseTooLarge Kind = "upstream_response_too_large"
+	// Other is any failure not classified more precisely
+	Other Kind = "bad_gateway"
+)
+
+// Retryable reports whether a request failing this way was never delivered
+// to the upstream, so it can be sent to another target
+func (k Kind) Retryable() bool {
+	switch k {
+	case ConnectionRefused, DNSFailure, TLSError:
+		return true
+	}
+	return false
+}
+
+// BreakerFailure reports whether the failure counts against the upstream's
+// circuit breaker. Oversized responses are a policy violation of one
+// response, not a sign of an unhealthy upstream.
+func (k Kind) BreakerFailure() bool {
+	return k != ResponseTooLarge
+}
+
+// Error is a classified upstream failure
+type Error struct {
+	Kind   Kind
+	Target string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("upstream %s: %s: %v", e.Target, e.Kind, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New classifies an error from the upstream at target
+func New(target string, err error) *Error {
+	return &Error{Kind: Classify(err), Target: target, Err: err}
+}
+
+// Classify returns the kind of a transport error
+func Classify(err error) Kind {
+	var (
+		dnsErr       *net.DNSError
+		certErr      *tls.CertificateVerificationError
+		recordErr    tls.RecordHeaderError
+		alertErr     tls.AlertError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+		netErr       net.Error
+	)
+	switch {
+	case errors.As(err, &dnsErr):
+		return DNSFailure
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ConnectionRefused
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &alertErr),
+		errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return TLSError
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return Timeout
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return ConnectionReset
+	}
+	return Other
+}
+
+// KindOf returns the kind of a classified error, classifying it if needed
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return Classify(err)
+}
@@ -0,0 +1,44 @@
+package upstreamerr
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	// A port nothing listens on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	_, refused := net.Dial("tcp", addr)
+
+	tests := []struct {
+		err  error
+		want Kind
+	}{
+		{err: refused, want: ConnectionRefused},
+		{err: &net.DNSError{Err: "no such host", Name: "orders.internal", IsNotFound: true}, want: DNSFailure},
+		{err: fmt.Errorf("read: %w", context.DeadlineExceeded), want: Timeout},
+		{err: io.ErrUnexpectedEOF, want: ConnectionReset},
+		{err: fmt.Errorf("something else"), want: Other},
+	}
+	for _, tt := range tests {
+		if got := Classify(tt.err); got != tt.want {
+			t.Errorf("Classify(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+
+	wrapped := fmt.Errorf("call: %w", &Error{Kind: ResponseTooLarge, Target: "http://orders", Err: io.EOF})
+	if kind := KindOf(wrapped); kind != ResponseTooLarge || kind.BreakerFailure() {
+		t.Errorf("KindOf = %s, want a classified kind not counted by breakers", kind)
+	}
+	if !ConnectionRefused.Retryable() || Timeout.Retryable() {
+		t.Error("only failures before the request is sent are retryable")
+	}
+}
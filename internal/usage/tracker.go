@@ -0,0 +1,125 @@
+package usage
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Counts are the requests seen in an interval
+type Counts struct {
+	Requests     int64 `json:"requests"`
+	ClientErrors int64 `json:"client_errors"`
+	ServerErrors int64 `json:"server_errors"`
+	RateLimited  int64 `json:"rate_limited"`
+}
+
+// record counts a response status
+func (c *Counts) record(status int) {
+	c.Requests++
+	switch {
+	case status == http.StatusTooManyRequests:
+		c.RateLimited++
+		c.ClientErrors++
+	case status >= 500:
+		c.ServerErrors++
+	case status >= 400:
+		c.ClientErrors++
+	}
+}
+
+// Add adds other to the counts
+func (c *Counts) Add(other Counts) {
+	c.Requests += other.Requests
+	c.ClientErrors += other.ClientErrors
+	c.ServerErrors += other.ServerErrors
+	c.RateLimited += other.RateLimited
+}
+
+// ErrorRate returns the share of requests that failed with a server error
+func (c Counts) ErrorRate() float64 {
+	if c.Requests == 0 {
+		return 0
+	}
+	return float64(c.ServerErrors) / float64(c.Requests)
+}
+
+// Bucket is the usage of a key during one interval
+type Bucket struct {
+	Start time.Time `json:"start"`
+	Counts
+}
+
+// Tracker keeps per-key request counts in fixed intervals, such as the
+// usage of each API key, for a limited retention
+type Tracker struct {
+	interval  time.Duration
+	retention time.Duration
+
+	mu      sync.Mutex
+	buckets map[string][]Bucket
+}
+
+// NewTracker creates a tracker counting in intervals and keeping them for
+// the retention period
+func NewTracker(interval, retention time.Duration) *Tracker {
+	return &Tracker{
+		interval:  interval,
+		retention: retention,
+		buckets:   make(map[string][]Bucket),
+	}
+}
+
+// Record counts a request of the key that completed with the status
+func (t *Tracker) Record(key string, status int) {
+	start := time.Now().Truncate(t.interval)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	buckets := t.buckets[key]
+	if n := len(buckets); n == 0 || !buckets[n-1].Start.Equal(start) {
+		buckets = append(t.prune(buckets), Bucket{Start: start})
+	}
+	buckets[len(buckets)-1].record(status)
+	t.buckets[key] = buckets
+}
+
+// Buckets returns the retained intervals of the key, oldest first
+func (t *Tracker) Buckets(key string) []Bucket {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	buckets := t.prune(t.buckets[key])
+	return append([]Bucket(nil), buckets...)
+}
+
+// Total returns the counts of the key over the last window
+func (t *Tracker) Total(key string, window time.Duration) Counts {
+	cutoff := time.Now().Add(-window)
+
+	var total Counts
+	for _, bucket := range t.Buckets(key) {
+		if !bucket.Start.Add(t.interval).Before(cutoff) {
+			total.Add(bucket.Counts)
+		}
+	}
+	return total
+}
+
+// Forget drops the usage of a key
+func (t *Tracker) Forget(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.buckets, key)
+}
+
+// prune drops buckets older than the retention
+func (t *Tracker) prune(buckets []Bucket) []Bucket {
+	cutoff := time.Now().Add(-t.retention)
+	i := 0
+	for i < len(buckets) && buckets[i].Start.Add(t.interval).Before(cutoff) {
+		i++
+	}
+	return buckets[i:]
+}
@@ -0,0 +1,30 @@
+package usage
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTrackerTotal(t *testing.T) {
+	tracker := NewTracker(time.Minute, time.Hour)
+
+	for _, status := range []int{http.StatusOK, http.StatusOK, http.StatusNotFound, http.StatusTooManyRequests, http.StatusBadGateway} {
+		tracker.Record("key-1", status)
+	}
+	tracker.Record("key-2", http.StatusOK)
+
+	got := tracker.Total("key-1", time.Hour)
+	want := Counts{Requests: 5, ClientErrors: 2, ServerErrors: 1, RateLimited: 1}
+	if got != want {
+		t.Errorf("Total() = %+v, want %+v", got, want)
+	}
+	if rate := got.ErrorRate(); rate != 0.2 {
+		t.Errorf("ErrorRate() = %v, want 0.2", rate)
+	}
+
+	tracker.Forget("key-1")
+	if got := tracker.Total("key-1", time.Hour); got.Requests != 0 {
+		t.Errorf("Total() after Forget = %+v, want none", got)
+	}
+}
@@ -0,0 +1,245 @@
+package validation
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// Query parameter types
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeNumber = "number"
+	TypeBool   = "bool"
+	TypeEnum   = "enum"
+)
+
+// Violation locations
+const (
+	LocationHeader      = "header"
+	LocationQuery       = "query"
+	LocationContentType = "content_type"
+)
+
+// Violation describes why a request failed validation
+type Violation struct {
+	Location string `json:"location"`
+	Field    string `json:"field,omitempty"`
+	Message  string `json:"message"`
+}
+
+// rule is a compiled validation rule
+type rule struct {
+	cfg     config.ValidationRuleConfig
+	methods map[string]bool
+	params  []param
+}
+
+// param is a compiled query parameter rule
+type param struct {
+	cfg     config.QueryParamRuleConfig
+	pattern *regexp.Regexp
+	values  map[string]bool
+}
+
+// Validator checks requests against the rules of a service
+type Validator struct {
+	rules []rule
+}
+
+// NewValidator compiles validation rules
+func NewValidator(rules []config.ValidationRuleConfig) (*Validator, error) {
+	v := &Validator{rules: make([]rule, 0, len(rules))}
+	for _, cfg := range rules {
+		compiled := rule{cfg: cfg}
+		if len(cfg.Methods) > 0 {
+			compiled.methods = make(map[string]bool, len(cfg.Methods))
+			for _, method := range cfg.Methods {
+				compiled.methods[strings.ToUpper(method)] = true
+			}
+		}
+
+		for _, paramCfg := range cfg.QueryParams {
+			p := param{cfg: paramCfg}
+			switch paramCfg.Type {
+			case "", TypeString, TypeInt, TypeNumber, TypeBool:
+			case TypeEnum:
+				if len(paramCfg.Values) == 0 {
+					return nil, fmt.Errorf("query parameter %s: enum requires values", paramCfg.Name)
+				}
+				p.values = make(map[string]bool, len(paramCfg.Values))
+				for _, value := range paramCfg.Values {
+					p.values[value] = true
+				}
+			default:
+				return nil, fmt.Errorf("query parameter %s: unknown type: %s", paramCfg.Name, paramCfg.Type)
+			}
+			if paramCfg.Pattern != "" {
+				pattern, err := regexp.Compile(paramCfg.Pattern)
+				if err != nil {
+					return nil, fmt.Errorf("query parameter %s: invalid pattern: %w", paramCfg.Name, err)
+				}
+				p.pattern = pattern
+			}
+			compiled.params = append(compiled.params, p)
+		}
+
+		v.rules = append(v.rules, compiled)
+	}
+	return v, nil
+}
+
+// Validate returns the violations of every rule matching the request
+func (v *Validator) Validate(r *http.Request) []Violation {
+	var violations []Violation
+	for _, rule := range v.rules {
+		if !strings.HasPrefix(r.URL.Path, rule.cfg.PathPrefix) {
+			continue
+		}
+		if rule.methods != nil && !rule.methods[r.Method] {
+			continue
+		}
+		violations = append(violations, rule.validate(r)...)
+	}
+	return violations
+}
+
+// validate checks a request against a single rule
+func (rl *rule) validate(r *http.Request) []Violation {
+	var violations []Violation
+
+	if rl.cfg.MaxHeaders > 0 {
+		count := 0
+		for _, values := range r.Header {
+			count += len(values)
+		}
+		if count > rl.cfg.MaxHeaders {
+			violations = append(violations, Violation{
+				Location: LocationHeader,
+				Message:  fmt.Sprintf("too many headers: %d, maximum is %d", count, rl.cfg.MaxHeaders),
+			})
+		}
+	}
+
+	for _, name := range rl.cfg.RequiredHeaders {
+		if r.Header.Get(name) == "" {
+			violations = append(violations, Violation{
+				Location: LocationHeader,
+				Field:    http.CanonicalHeaderKey(name),
+				Message:  "required header is missing",
+			})
+		}
+	}
+
+	// Only requests with a body carry a content type
+	if len(rl.cfg.ContentTypes) > 0 && r.ContentLength != 0 {
+		if violation, ok := rl.checkContentType(r.Header.Get("Content-Type")); !ok {
+			violations = append(violations, violation)
+		}
+	}
+
+	query := r.URL.Query()
+	for _, p := range rl.params {
+		violations = append(violations, p.validate(query[p.cfg.Name])...)
+	}
+
+	return violations
+}
+
+// checkContentType checks the media type against the allowed ones, which
+// may use a "type/*" wildcard
+func (rl *rule) checkContentType(header string) (Violation, bool) {
+	violation := Violation{
+		Location: LocationContentType,
+		Message:  "content type must be one of: " + strings.Join(rl.cfg.ContentTypes, ", "),
+	}
+	if header == "" {
+		return violation, false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		violation.Message = "invalid content type"
+		return violation, false
+	}
+
+	for _, allowed := range rl.cfg.ContentTypes {
+		allowed = strings.ToLower(allowed)
+		if allowed == mediaType {
+			return Violation{}, true
+		}
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return Violation{}, true
+		}
+	}
+	return violation, false
+}
+
+// validate checks every value of a query parameter
+func (p *param) validate(values []string) []Violation {
+	name := p.cfg.Name
+	if len(values) == 0 {
+		if p.cfg.Required {
+			return []Violation{{Location: LocationQuery, Field: name, Message: "required parameter is missing"}}
+		}
+		return nil
+	}
+
+	var violations []Violation
+	fail := func(format string, args ...interface{}) {
+		violations = append(violations, Violation{Location: LocationQuery, Field: name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, value := range values {
+		if p.cfg.MaxLength > 0 && len(value) > p.cfg.MaxLength {
+			fail("must be at most %d characters", p.cfg.MaxLength)
+			continue
+		}
+		if p.pattern != nil && !p.pattern.MatchString(value) {
+			fail("must match %s", p.cfg.Pattern)
+			continue
+		}
+
+		switch p.cfg.Type {
+		case TypeInt:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				fail("must be an integer")
+				continue
+			}
+			p.checkRange(float64(n), fail)
+		case TypeNumber:
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				fail("must be a number")
+				continue
+			}
+			p.checkRange(n, fail)
+		case TypeBool:
+			if _, err := strconv.ParseBool(value); err != nil {
+				fail("must be a boolean")
+			}
+		case TypeEnum:
+			if !p.values[value] {
+				fail("must be one of: %s", strings.Join(p.cfg.Values, ", "))
+			}
+		}
+	}
+	return violations
+}
+
+// checkRange checks a numeric value against the configured bounds
+func (p *param) checkRange(n float64, fail func(format string, args ...interface{})) {
+	if p.cfg.Min != nil && n < *p.cfg.Min {
+		fail("must be at least %v", *p.cfg.Min)
+	}
+	if p.cfg.Max != nil && n > *p.cfg.Max {
+		fail("must be at most %v", *p.cfg.Max)
+	}
+}
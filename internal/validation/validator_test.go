@@ -0,0 +1,76 @@
+package validation
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func float(v float64) *float64 { return &v }
+
+func TestValidate(t *testing.T) {
+	v, err := NewValidator([]config.ValidationRuleConfig{
+		{
+			PathPrefix:      "/orders",
+			Methods:         []string{"post"},
+			RequiredHeaders: []string{"x-tenant-id"},
+			ContentTypes:    []string{"application/json", "text/*"},
+		},
+		{
+			PathPrefix: "/orders",
+			Methods:    []string{"GET"},
+			QueryParams: []config.QueryParamRuleConfig{
+				{Name: "limit", Type: TypeInt, Min: float(1), Max: float(100)},
+				{Name: "status", Type: TypeEnum, Values: []string{"open", "closed"}, Required: true},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewValidator() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		tenant      string
+		want        []string
+	}{
+		{"valid post", "POST", "/orders", "application/json; charset=utf-8", "t1", nil},
+		{"wildcard content type", "POST", "/orders", "text/plain", "t1", nil},
+		{"missing header and bad content type", "POST", "/orders", "application/xml", "", []string{"header:X-Tenant-Id", "content_type:"}},
+		{"valid get", "GET", "/orders?limit=10&status=open", "", "", nil},
+		{"bad query", "GET", "/orders?limit=500&status=lost", "", "", []string{"query:limit", "query:status"}},
+		{"missing required param", "GET", "/orders?limit=abc", "", "", []string{"query:limit", "query:status"}},
+		{"other path", "GET", "/users?limit=500", "", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body *strings.Reader
+			if tt.method == "POST" {
+				body = strings.NewReader("{}")
+			} else {
+				body = strings.NewReader("")
+			}
+			r := httptest.NewRequest(tt.method, tt.target, body)
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.tenant != "" {
+				r.Header.Set("X-Tenant-Id", tt.tenant)
+			}
+
+			var got []string
+			for _, violation := range v.Validate(r) {
+				got = append(got, violation.Location+":"+violation.Field)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Validate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
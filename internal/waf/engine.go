@@ -0,0 +1,230 @@
+package waf
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+// Action is what happens when a rule matches
+type Action string
+
+const (
+	// ActionBlock rejects the request
+	ActionBlock Action = "block"
+	// ActionLog only records the match
+	ActionLog Action = "log"
+)
+
+// Built-in rule IDs
+const (
+	RuleSQLInjection  = "sqli"
+	RuleXSS           = "xss"
+	RulePathTraversal = "path_traversal"
+	RuleHeaderSize    = "header_size"
+	RuleMethod        = "method"
+	RuleUserAgent     = "user_agent"
+)
+
+var (
+	sqlInjectionPattern = regexp.MustCompile(`(?i)(\bunion\b[\s(]+(all\s+)?select\b|['"]\s*(or|and)\s+['"]?\w+['"]?\s*=|\bor\s+\d+\s*=\s*\d+|['"]\s*(--|#|/\*)|;\s*(drop|delete|insert|update|alter|truncate)\b|\b(sleep|benchmark|pg_sleep)\s*\(|\bwaitfor\s+delay\b|\bdrop\s+(table|database)\b|\binformation_schema\b)`)
+	xssPattern          = regexp.MustCompile(`(?i)(<\s*/?\s*(script|iframe|object|embed|svg)\b|javascript\s*:|vbscript\s*:|\bon(error|load|click|mouseover|focus|blur|submit)\s*=|document\.(cookie|location)|\beval\s*\(|<\s*img[^>]+\bsrc\s*=)`)
+	traversalPattern    = regexp.MustCompile(`(?i)(^|[/\\])\.\.([/\\]|$)|%2e%2e|%252e|\.\.%2f|\.\.%5c`)
+)
+
+// Match is a rule that matched a request
+type Match struct {
+	RuleID string
+	Action Action
+	Target string
+}
+
+// rule is a compiled check against a request
+type rule struct {
+	id     string
+	action Action
+	check  func(r *http.Request) (string, bool)
+}
+
+// Engine evaluates requests against the configured WAF rules
+type Engine struct {
+	rules []rule
+}
+
+// NewEngine compiles the WAF rules from configuration. In log mode every
+// rule only records matches; otherwise custom rules may opt into logging.
+func NewEngine(cfg config.WAFConfig) (*Engine, error) {
+	mode := ActionBlock
+	if cfg.Mode == string(ActionLog) {
+		mode = ActionLog
+	}
+	actionFor := func(configured string) Action {
+		if mode == ActionLog || configured == string(ActionLog) {
+			return ActionLog
+		}
+		return ActionBlock
+	}
+
+	var rules []rule
+
+	if len(cfg.AllowedMethods) > 0 {
+		allowed := make(map[string]bool, len(cfg.AllowedMethods))
+		for _, method := range cfg.AllowedMethods {
+			allowed[strings.ToUpper(method)] = true
+		}
+		rules = append(rules, rule{id: RuleMethod, action: mode, check: func(r *http.Request) (string, bool) {
+			return "method", !allowed[r.Method]
+		}})
+	}
+
+	if cfg.MaxHeaderBytes > 0 {
+		limit := cfg.MaxHeaderBytes
+		rules = append(rules, rule{id: RuleHeaderSize, action: mode, check: func(r *http.Request) (string, bool) {
+			for name, values := range r.Header {
+				for _, value := range values {
+					if len(name)+len(value) > limit {
+						return "header:" + name, true
+					}
+				}
+			}
+			return "", false
+		}})
+	}
+
+	if len(cfg.BlockedUserAgents) > 0 {
+		blocked := make([]string, 0, len(cfg.BlockedUserAgents))
+		for _, agent := range cfg.BlockedUserAgents {
+			blocked = append(blocked, strings.ToLower(agent))
+		}
+		rules = append(rules, rule{id: RuleUserAgent, action: mode, check: func(r *http.Request) (string, bool) {
+			agent := strings.ToLower(r.UserAgent())
+			for _, b := range blocked {
+				if strings.Contains(agent, b) {
+					return "user_agent", true
+				}
+			}
+			return "", false
+		}})
+	}
+
+	if cfg.PathTraversal {
+		rules = append(rules, rule{id: RulePathTraversal, action: mode, check: func(r *http.Request) (string, bool) {
+			if traversalPattern.MatchString(r.URL.EscapedPath()) || traversalPattern.MatchString(r.URL.Path) {
+				return "path", true
+			}
+			return matchQuery(r, traversalPattern)
+		}})
+	}
+
+	if cfg.SQLInjection {
+		rules = append(rules, rule{id: RuleSQLInjection, action: mode, check: func(r *http.Request) (string, bool) {
+			return matchPathAndQuery(r, sqlInjectionPattern)
+		}})
+	}
+
+	if cfg.XSS {
+		rules = append(rules, rule{id: RuleXSS, action: mode, check: func(r *http.Request) (string, bool) {
+			return matchPathAndQuery(r, xssPattern)
+		}})
+	}
+
+	for _, custom := range cfg.Rules {
+		pattern, err := regexp.Compile(custom.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for waf rule %s: %w", custom.ID, err)
+		}
+		targets := custom.Targets
+		if len(targets) == 0 {
+			targets = []string{"path", "query"}
+		}
+		rules = append(rules, rule{id: custom.ID, action: actionFor(custom.Action), check: func(r *http.Request) (string, bool) {
+			return matchTargets(r, pattern, targets)
+		}})
+	}
+
+	return &Engine{rules: rules}, nil
+}
+
+// Evaluate returns every rule that matches the request
+func (e *Engine) Evaluate(r *http.Request) []Match {
+	var matches []Match
+	for _, rule := range e.rules {
+		if target, ok := rule.check(r); ok {
+			matches = append(matches, Match{RuleID: rule.id, Action: rule.action, Target: target})
+		}
+	}
+	return matches
+}
+
+// matchPathAndQuery checks the decoded path and query values
+func matchPathAndQuery(r *http.Request, pattern *regexp.Regexp) (string, bool) {
+	if pattern.MatchString(r.URL.Path) {
+		return "path", true
+	}
+	return matchQuery(r, pattern)
+}
+
+// matchQuery checks decoded query parameter names and values
+func matchQuery(r *http.Request, pattern *regexp.Regexp) (string, bool) {
+	values, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		// Malformed escapes are checked as they are
+		if pattern.MatchString(r.URL.RawQuery) {
+			return "query", true
+		}
+		return "", false
+	}
+
+	for name, vals := range values {
+		if pattern.MatchString(name) {
+			return "query:" + name, true
+		}
+		for _, value := range vals {
+			if pattern.MatchString(value) {
+				return "query:" + name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// matchTargets checks the request parts a custom rule applies to. Targets
+// are "path", "query", "user_agent", "headers" or "header.<Name>".
+func matchTargets(r *http.Request, pattern *regexp.Regexp, targets []string) (string, bool) {
+	for _, target := range targets {
+		switch {
+		case target == "path":
+			if pattern.MatchString(r.URL.Path) {
+				return "path", true
+			}
+		case target == "query":
+			if where, ok := matchQuery(r, pattern); ok {
+				return where, true
+			}
+		case target == "user_agent":
+			if pattern.MatchString(r.UserAgent()) {
+				return "user_agent", true
+			}
+		case target == "headers":
+			for name, values := range r.Header {
+				for _, value := range values {
+					if pattern.MatchString(value) {
+						return "header:" + name, true
+					}
+				}
+			}
+		case strings.HasPrefix(target, "header."):
+			name := strings.TrimPrefix(target, "header.")
+			for _, value := range r.Header.Values(name) {
+				if pattern.MatchString(value) {
+					return "header:" + name, true
+				}
+			}
+		}
+	}
+	return "", false
+}
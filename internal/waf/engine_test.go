@@ -0,0 +1,92 @@
+package waf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/max/api-gateway/internal/config"
+)
+
+func TestEngine_BuiltinRules(t *testing.T) {
+	engine, err := NewEngine(config.WAFConfig{
+		SQLInjection:      true,
+		XSS:               true,
+		PathTraversal:     true,
+		MaxHeaderBytes:    64,
+		AllowedMethods:    []string{"GET", "POST"},
+		BlockedUserAgents: []string{"sqlmap"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		header [2]string
+		rule   string
+	}{
+		{"clean", "GET", "/api/v1/users?name=o'brien&page=2", [2]string{}, ""},
+		{"sqli", "GET", "/api/v1/users?id=1%27%20OR%20%271%27%3D%271", [2]string{}, RuleSQLInjection},
+		{"union", "GET", "/api/v1/users?q=1+UNION+SELECT+password+FROM+users", [2]string{}, RuleSQLInjection},
+		{"xss", "GET", "/search?q=%3Cscript%3Ealert(1)%3C/script%3E", [2]string{}, RuleXSS},
+		{"traversal", "GET", "/static/..%2f..%2fetc/passwd", [2]string{}, RulePathTraversal},
+		{"method", "TRACE", "/api/v1/users", [2]string{}, RuleMethod},
+		{"user agent", "GET", "/", [2]string{"User-Agent", "sqlmap/1.7"}, RuleUserAgent},
+		{"header size", "GET", "/", [2]string{"X-Large", strings.Repeat("a", 100)}, RuleHeaderSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.header[0] != "" {
+				req.Header.Set(tt.header[0], tt.header[1])
+			}
+
+			matches := engine.Evaluate(req)
+			if tt.rule == "" {
+				if len(matches) != 0 {
+					t.Fatalf("Expected no matches, got %+v", matches)
+				}
+				return
+			}
+			if len(matches) == 0 || matches[0].RuleID != tt.rule {
+				t.Fatalf("Expected rule %s to match, got %+v", tt.rule, matches)
+			}
+			if matches[0].Action != ActionBlock {
+				t.Errorf("Expected block action, got %s", matches[0].Action)
+			}
+		})
+	}
+}
+
+func TestEngine_LogModeAndCustomRules(t *testing.T) {
+	engine, err := NewEngine(config.WAFConfig{
+		Mode:         "log",
+		SQLInjection: true,
+		Rules: []config.WAFRuleConfig{
+			{ID: "no-debug", Pattern: `(?i)^/debug`, Targets: []string{"path"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/pprof?id=1%20or%201=1", nil)
+	matches := engine.Evaluate(req)
+	if len(matches) != 2 {
+		t.Fatalf("Expected 2 matches, got %+v", matches)
+	}
+	for _, match := range matches {
+		if match.Action != ActionLog {
+			t.Errorf("Expected log-only action in log mode for %s", match.RuleID)
+		}
+	}
+
+	if _, err := NewEngine(config.WAFConfig{Rules: []config.WAFRuleConfig{{ID: "bad", Pattern: "("}}}); err == nil {
+		t.Error("Expected invalid pattern to be rejected")
+	}
+}
@@ -0,0 +1,316 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/circuit"
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/pkg/hmacsign"
+	"github.com/max/api-gateway/pkg/metrics"
+)
+
+// Headers sent with every delivery. The signature headers match those
+// verified by the HMAC signature middleware.
+const (
+	EventHeader     = "X-Webhook-Event"
+	IDHeader        = "X-Webhook-ID"
+	AttemptHeader   = "X-Webhook-Attempt"
+	SignatureHeader = "X-Signature"
+	TimestampHeader = "X-Timestamp"
+	NonceHeader     = "X-Nonce"
+)
+
+// Delivery results
+const (
+	ResultSuccess = "success"
+	ResultRetry   = "retry"
+	ResultFailed  = "failed"
+	ResultDropped = "dropped"
+)
+
+// maxResponseBytes bounds how much of a response body is read
+const maxResponseBytes = 64 << 10
+
+// Event is the JSON body of a webhook delivery. The ID is the same for
+// every attempt, so receivers can drop duplicates.
+type Event struct {
+	ID        string                 `json:"id"`
+	Type      string                 `json:"type"`
+	Timestamp time.Time              `json:"timestamp"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// endpoint is a configured endpoint with its defaults applied
+type endpoint struct {
+	cfg     config.WebhookEndpointConfig
+	events  map[string]bool // nil for all events
+	retries int
+	queue   chan *Event
+	breaker *circuit.CircuitBreaker
+	client  *http.Client
+}
+
+// Dispatcher delivers gateway events to the configured webhook endpoints.
+// Each endpoint has its own queue, worker and circuit breaker, so a slow or
+// failing endpoint does not delay the others.
+type Dispatcher struct {
+	endpoints      []*endpoint
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	log            *deliveryLog
+	metrics        *metrics.Manager
+	logger         *zap.Logger
+}
+
+// NewDispatcher creates a dispatcher for the configured endpoints
+func NewDispatcher(cfg config.WebhooksConfig, metricsManager *metrics.Manager, logger *zap.Logger) *Dispatcher {
+	d := &Dispatcher{
+		initialBackoff: cfg.InitialBackoff,
+		maxBackoff:     cfg.MaxBackoff,
+		log:            newDeliveryLog(cfg.LogSize),
+		metrics:        metricsManager,
+		logger:         logger,
+	}
+
+	for _, endpointCfg := range cfg.Endpoints {
+		ep := &endpoint{
+			cfg:     endpointCfg,
+			retries: cfg.MaxRetries,
+			queue:   make(chan *Event, cfg.QueueSize),
+		}
+		if ep.cfg.Timeout <= 0 {
+			ep.cfg.Timeout = cfg.Timeout
+		}
+		if ep.cfg.MaxRetries != nil {
+			ep.retries = *ep.cfg.MaxRetries
+		}
+		breakerCfg := cfg.CircuitBreaker
+		if ep.cfg.CircuitBreaker != nil {
+			breakerCfg = *ep.cfg.CircuitBreaker
+		}
+		ep.breaker = circuit.NewCircuitBreaker("webhook:"+ep.cfg.Name, breakerCfg, logger)
+		ep.client = &http.Client{
+			Timeout: ep.cfg.Timeout,
+			// A redirect could send signed events to another host
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		for _, event := range ep.cfg.Events {
+			if event == "*" {
+				ep.events = nil
+				break
+			}
+			if ep.events == nil {
+				ep.events = make(map[string]bool)
+			}
+			ep.events[event] = true
+		}
+		d.endpoints = append(d.endpoints, ep)
+	}
+	return d
+}
+
+// Start runs a delivery worker per endpoint until ctx is done. Events
+// still queued then are not delivered.
+func (d *Dispatcher) Start(ctx context.Context) {
+	for _, ep := range d.endpoints {
+		go func(ep *endpoint) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case event := <-ep.queue:
+					d.deliver(ctx, ep, event)
+				}
+			}
+		}(ep)
+	}
+}
+
+// Dispatch queues an event for every endpoint subscribed to its type. It
+// never blocks: when an endpoint's queue is full the event is dropped for
+// that endpoint.
+func (d *Dispatcher) Dispatch(eventType string, data map[string]interface{}) {
+	event := &Event{
+		ID:        newEventID(),
+		Type:      eventType,
+		Timestamp: time.Now().UTC(),
+		Data:      data,
+	}
+
+	for _, ep := range d.endpoints {
+		if ep.events != nil && !ep.events[eventType] {
+			continue
+		}
+		select {
+		case ep.queue <- event:
+		default:
+			d.logger.Warn("Webhook queue full, dropping event",
+				zap.String("endpoint", ep.cfg.Name),
+				zap.String("event_type", eventType))
+			d.log.add(Delivery{
+				Endpoint:  ep.cfg.Name,
+				EventID:   event.ID,
+				EventType: eventType,
+				Result:    ResultDropped,
+				Error:     "queue full",
+				Time:      time.Now(),
+			})
+			if d.metrics != nil {
+				d.metrics.RecordWebhookDropped(ep.cfg.Name)
+			}
+		}
+	}
+}
+
+// deliver sends an event to an endpoint, retrying with exponential backoff
+// while the endpoint is unreachable, answers 408, 429 or 5xx, or its
+// circuit breaker is open
+func (d *Dispatcher) deliver(ctx context.Context, ep *endpoint, event *Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		d.logger.Error("Failed to encode webhook event", zap.String("event_type", event.Type), zap.Error(err))
+		return
+	}
+
+	backoff := d.initialBackoff
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		status, err := d.send(ctx, ep, event, body, attempt)
+		duration := time.Since(start)
+
+		result := ResultSuccess
+		if err != nil {
+			result = ResultFailed
+			if retryable(status, err) && attempt <= ep.retries && ctx.Err() == nil {
+				result = ResultRetry
+			}
+		}
+		delivery := Delivery{
+			Endpoint:   ep.cfg.Name,
+			EventID:    event.ID,
+			EventType:  event.Type,
+			Attempt:    attempt,
+			Result:     result,
+			Status:     status,
+			DurationMs: float64(duration.Microseconds()) / 1000,
+			Time:       start,
+		}
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		d.log.add(delivery)
+		if d.metrics != nil {
+			d.metrics.RecordWebhookDelivery(ep.cfg.Name, result, duration)
+		}
+		if result != ResultRetry {
+			if result == ResultFailed {
+				d.logger.Warn("Webhook delivery failed",
+					zap.String("endpoint", ep.cfg.Name),
+					zap.String("event_type", event.Type),
+					zap.String("event_id", event.ID),
+					zap.Int("attempts", attempt),
+					zap.Error(err))
+			}
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, d.maxBackoff)
+	}
+}
+
+// statusError is a delivery answered with a non-2xx status
+type statusError struct {
+	status int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("endpoint returned status %d", e.status)
+}
+
+// send makes one delivery attempt through the endpoint's circuit breaker.
+// It returns the response status, 0 without a response.
+func (d *Dispatcher) send(ctx context.Context, ep *endpoint, event *Event, body []byte, attempt int) (int, error) {
+	var status int
+	err := ep.breaker.CallHTTP(func() (int, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.cfg.URL, bytes.NewReader(body))
+		if err != nil {
+			return 0, err
+		}
+		for name, value := range ep.cfg.Headers {
+			req.Header.Set(name, value)
+		}
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(EventHeader, event.Type)
+		req.Header.Set(IDHeader, event.ID)
+		req.Header.Set(AttemptHeader, strconv.Itoa(attempt))
+		// Each attempt gets a nonce of its own, so a receiver that saw a
+		// failed attempt still accepts the retry; the event ID stays the same
+		nonce := newEventID()
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(NonceHeader, nonce)
+		req.Header.Set(SignatureHeader, "sha256="+hmacsign.Sign(ep.cfg.Secret, timestamp, nonce, req.Method, req.URL.RequestURI(), body))
+
+		resp, err := ep.client.Do(req)
+		if err != nil {
+			// Leave out the URL, which may embed a token
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				return 0, urlErr.Err
+			}
+			return 0, err
+		}
+		defer resp.Body.Close()
+		// Reading the body lets the connection be reused
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBytes))
+		status = resp.StatusCode
+		return status, nil
+	})
+	if err != nil {
+		return status, err
+	}
+	// The breaker only counts its failure statuses; any other non-2xx
+	// status still fails the delivery
+	if status < 200 || status > 299 {
+		return status, &statusError{status: status}
+	}
+	return status, nil
+}
+
+// retryable reports whether a failed attempt may succeed later
+func retryable(status int, err error) bool {
+	var statusErr *circuit.StatusError
+	var deliveryErr *statusError
+	if !errors.As(err, &statusErr) && !errors.As(err, &deliveryErr) {
+		// Transport errors and an open circuit breaker
+		return true
+	}
+	return status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
+
+// newEventID returns a random event ID
+func newEventID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,128 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/max/api-gateway/internal/config"
+	"github.com/max/api-gateway/pkg/hmacsign"
+)
+
+func testConfig(url string, events ...string) config.WebhooksConfig {
+	return config.WebhooksConfig{
+		QueueSize:      10,
+		LogSize:        10,
+		Timeout:        time.Second,
+		MaxRetries:     2,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		Endpoints: []config.WebhookEndpointConfig{{
+			Name:   "ops",
+			URL:    url,
+			Secret: "s3cret",
+			Events: events,
+		}},
+	}
+}
+
+// waitFor polls until the log holds n deliveries
+func waitFor(t *testing.T, d *Dispatcher, n int) []Delivery {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if deliveries := d.Deliveries("", 0); len(deliveries) >= n {
+			return deliveries
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected %d deliveries, got %v", n, d.Deliveries("", 0))
+	return nil
+}
+
+func TestDispatcherSignsAndRetries(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		want := "sha256=" + hmacsign.Sign("s3cret", r.Header.Get(TimestampHeader), r.Header.Get(NonceHeader), r.Method, r.URL.RequestURI(), body)
+		if r.Header.Get(SignatureHeader) != want {
+			t.Errorf("bad signature %q", r.Header.Get(SignatureHeader))
+		}
+		var event Event
+		if err := json.Unmarshal(body, &event); err != nil || event.Type != config.WebhookEventBreakerOpen {
+			t.Errorf("unexpected event %s: %v", body, err)
+		}
+		if r.Header.Get(IDHeader) != event.ID || r.Header.Get(EventHeader) != event.Type {
+			t.Errorf("headers do not match the event: %v", r.Header)
+		}
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	d := NewDispatcher(testConfig(server.URL, config.WebhookEventBreakerOpen), nil, zap.NewNop())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.Start(ctx)
+
+	d.Dispatch(config.WebhookEventConfigReloaded, nil)
+	d.Dispatch(config.WebhookEventBreakerOpen, map[string]interface{}{"name": "users"})
+
+	deliveries := waitFor(t, d, 2)
+	if deliveries[0].Result != ResultSuccess || deliveries[0].Attempt != 2 {
+		t.Errorf("expected success on the second attempt, got %+v", deliveries[0])
+	}
+	if deliveries[1].Result != ResultRetry || deliveries[1].Status != http.StatusServiceUnavailable {
+		t.Errorf("expected a retry after 503, got %+v", deliveries[1])
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected 2 calls, the unsubscribed event must not be sent, got %d", calls.Load())
+	}
+}
+
+func TestDispatcherDoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	d := NewDispatcher(testConfig(server.URL, "*"), nil, zap.NewNop())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.Start(ctx)
+
+	d.Dispatch(config.WebhookEventRateLimitExceeded, nil)
+	deliveries := waitFor(t, d, 1)
+	time.Sleep(20 * time.Millisecond)
+	if deliveries[0].Result != ResultFailed || calls.Load() != 1 {
+		t.Errorf("expected one failed attempt, got %+v after %d calls", deliveries[0], calls.Load())
+	}
+}
+
+func TestDispatcherDropsWhenQueueFull(t *testing.T) {
+	cfg := testConfig("http://127.0.0.1:1", "*")
+	cfg.QueueSize = 1
+	// Not started, so nothing leaves the queue
+	d := NewDispatcher(cfg, nil, zap.NewNop())
+
+	d.Dispatch(config.WebhookEventConfigReloaded, nil)
+	d.Dispatch(config.WebhookEventConfigReloaded, nil)
+
+	deliveries := d.Deliveries("ops", 0)
+	if len(deliveries) != 1 || deliveries[0].Result != ResultDropped {
+		t.Fatalf("expected one dropped event, got %+v", deliveries)
+	}
+	if status := d.Endpoints()[0]; status.Queued != 1 || status.Host != "http://127.0.0.1:1" {
+		t.Errorf("unexpected endpoint status %+v", status)
+	}
+}
@@ -0,0 +1,106 @@
+package webhook
+
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Delivery is a delivery attempt, or an event dropped before one
+type Delivery struct {
+	Endpoint   string    `json:"endpoint"`
+	EventID    string    `json:"event_id"`
+	EventType  string    `json:"event_type"`
+	Attempt    int       `json:"attempt,omitempty"`
+	Result     string    `json:"result"`
+	Status     int       `json:"status,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs float64   `json:"duration_ms"`
+	Time       time.Time `json:"time"`
+}
+
+// deliveryLog keeps the most recent deliveries in a ring
+type deliveryLog struct {
+	mu      sync.Mutex
+	entries []Delivery
+	next    int
+	full    bool
+}
+
+// newDeliveryLog creates a log of at most size deliveries
+func newDeliveryLog(size int) *deliveryLog {
+	if size < 1 {
+		size = 1
+	}
+	return &deliveryLog{entries: make([]Delivery, size)}
+}
+
+// add records a delivery, replacing the oldest one when the log is full
+func (l *deliveryLog) add(delivery Delivery) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[l.next] = delivery
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// list returns up to limit deliveries, newest first, optionally only those
+// of one endpoint. A limit of 0 returns all of them.
+func (l *deliveryLog) list(endpoint string, limit int) []Delivery {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := l.next
+	if l.full {
+		n = len(l.entries)
+	}
+	deliveries := make([]Delivery, 0)
+	for i := 1; i <= n; i++ {
+		delivery := l.entries[(l.next-i+len(l.entries))%len(l.entries)]
+		if endpoint != "" && delivery.Endpoint != endpoint {
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+		if limit > 0 && len(deliveries) == limit {
+			break
+		}
+	}
+	return deliveries
+}
+
+// EndpointStatus is the state of a webhook endpoint. Only the scheme and
+// host of its URL are shown, as paths often embed tokens.
+type EndpointStatus struct {
+	Name    string   `json:"name"`
+	Host    string   `json:"host"`
+	Events  []string `json:"events"`
+	Queued  int      `json:"queued"`
+	Breaker string   `json:"breaker"`
+}
+
+// Deliveries returns up to limit recent deliveries, newest first,
+// optionally only those of one endpoint
+func (d *Dispatcher) Deliveries(endpoint string, limit int) []Delivery {
+	return d.log.list(endpoint, limit)
+}
+
+// Endpoints returns the state of every endpoint
+func (d *Dispatcher) Endpoints() []EndpointStatus {
+	statuses := make([]EndpointStatus, 0, len(d.endpoints))
+	for _, ep := range d.endpoints {
+		var host string
+		if u, err := url.Parse(ep.cfg.URL); err == nil {
+			host = u.Scheme + "://" + u.Host
+		}
+		statuses = append(statuses, EndpointStatus{
+			Name:    ep.cfg.Name,
+			Host:    host,
+			Events:  ep.cfg.Events,
+			Queued:  len(ep.queue),
+			Breaker: ep.breaker.State().String(),
+		})
+	}
+	return statuses
+}
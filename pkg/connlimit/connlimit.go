@@ -0,0 +1,233 @@
+// Package connlimit protects HTTP servers from slow and greedy clients. A
+// Tracker follows connections through http.Server.ConnState, caps the
+// concurrent connections of each client IP and closes connections that
+// have outlived their maximum lifetime.
+package connlimit
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Reasons a connection is dropped
+const (
+	DropPerIPLimit        = "per_ip_limit"
+	DropMaxLifetime       = "max_lifetime"
+	DropReadHeaderTimeout = "read_header_timeout"
+)
+
+// Limits of a Tracker; zero values disable a limit
+type Limits struct {
+	// MaxPerIP caps the concurrent connections of a client IP
+	MaxPerIP int
+	// MaxLifetime closes connections once idle after this long
+	MaxLifetime time.Duration
+	// ReadHeaderTimeout is the server's; connections closed without a
+	// request after it are counted as header timeout drops
+	ReadHeaderTimeout time.Duration
+	// Exempt lists the networks not subject to MaxPerIP, such as load
+	// balancers every client comes through
+	Exempt []*net.IPNet
+	// CountOnRequest counts connections against their IP at their first
+	// request rather than when accepted. It is needed with PROXY protocol
+	// listeners, where the client address is only known once the header
+	// is read and asking for it earlier would block the accept loop.
+	CountOnRequest bool
+}
+
+// conn is a tracked connection
+type conn struct {
+	ip      string
+	counted bool // Counted against ip
+	opened  time.Time
+	served  bool // A request was read
+	dropped bool // Closed by the tracker
+}
+
+// Tracker tracks the connections of one or more servers
+type Tracker struct {
+	limits Limits
+	onDrop func(reason string)
+	onOpen func(open int)
+
+	mu    sync.Mutex
+	conns map[net.Conn]*conn
+	perIP map[string]int
+}
+
+// New creates a tracker. onDrop is called with the reason of each dropped
+// connection and onOpen with the number of open connections whenever it
+// changes; either may be nil. Neither may block, and onOpen must not call
+// back into the tracker.
+func New(limits Limits, onDrop func(reason string), onOpen func(open int)) *Tracker {
+	return &Tracker{
+		limits: limits,
+		onDrop: onDrop,
+		onOpen: onOpen,
+		conns:  make(map[net.Conn]*conn),
+		perIP:  make(map[string]int),
+	}
+}
+
+// ConnState is the http.Server.ConnState hook
+func (t *Tracker) ConnState(c net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		t.opened(c)
+	case http.StateActive:
+		t.active(c)
+	case http.StateIdle:
+		t.idle(c)
+	case http.StateHijacked, http.StateClosed:
+		t.closed(c, state == http.StateClosed)
+	}
+}
+
+// opened tracks a new connection
+func (t *Tracker) opened(c net.Conn) {
+	t.mu.Lock()
+	t.conns[c] = &conn{opened: time.Now()}
+	t.notifyOpen()
+	t.mu.Unlock()
+
+	if !t.limits.CountOnRequest {
+		t.count(c)
+	}
+}
+
+// active marks a connection as serving a request
+func (t *Tracker) active(c net.Conn) {
+	t.mu.Lock()
+	tracked := t.conns[c]
+	first := tracked != nil && !tracked.served
+	if first {
+		tracked.served = true
+	}
+	t.mu.Unlock()
+
+	if first && t.limits.CountOnRequest {
+		t.count(c)
+	}
+}
+
+// count counts a connection against its IP, closing it when the IP is over
+// the limit. The server then reports the connection closed.
+func (t *Tracker) count(c net.Conn) {
+	ip := remoteIP(c)
+	exempt := t.exempt(ip)
+
+	t.mu.Lock()
+	tracked := t.conns[c]
+	if tracked == nil || tracked.counted {
+		t.mu.Unlock()
+		return
+	}
+	tracked.ip, tracked.counted = ip, true
+	t.perIP[ip]++
+	over := !exempt && t.limits.MaxPerIP > 0 && t.perIP[ip] > t.limits.MaxPerIP
+	if over {
+		tracked.dropped = true
+	}
+	t.mu.Unlock()
+
+	if over {
+		c.Close()
+		t.drop(DropPerIPLimit)
+	}
+}
+
+// idle closes a connection between requests once it is past its lifetime
+func (t *Tracker) idle(c net.Conn) {
+	if t.limits.MaxLifetime <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	tracked := t.conns[c]
+	expired := tracked != nil && time.Since(tracked.opened) >= t.limits.MaxLifetime
+	if expired {
+		tracked.dropped = true
+	}
+	t.mu.Unlock()
+
+	if expired {
+		c.Close()
+		t.drop(DropMaxLifetime)
+	}
+}
+
+// closed stops tracking a connection
+func (t *Tracker) closed(c net.Conn, closed bool) {
+	t.mu.Lock()
+	tracked := t.conns[c]
+	if tracked == nil {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.conns, c)
+	if tracked.counted {
+		if t.perIP[tracked.ip]--; t.perIP[tracked.ip] <= 0 {
+			delete(t.perIP, tracked.ip)
+		}
+	}
+	t.notifyOpen()
+	t.mu.Unlock()
+
+	// A connection that never sent a complete request header before the
+	// timeout is most likely a slow client
+	if closed && !tracked.served && !tracked.dropped && t.limits.ReadHeaderTimeout > 0 &&
+		time.Since(tracked.opened) >= t.limits.ReadHeaderTimeout {
+		t.drop(DropReadHeaderTimeout)
+	}
+}
+
+// Open returns the number of open connections of ip
+func (t *Tracker) Open(ip string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.perIP[ip]
+}
+
+// exempt reports whether ip is exempt from the per-IP limit
+func (t *Tracker) exempt(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range t.limits.Exempt {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Tracker) drop(reason string) {
+	if t.onDrop != nil {
+		t.onDrop(reason)
+	}
+}
+
+// notifyOpen reports the number of open connections. It is called with
+// the lock held so reports arrive in order.
+func (t *Tracker) notifyOpen() {
+	if t.onOpen != nil {
+		t.onOpen(len(t.conns))
+	}
+}
+
+// remoteIP returns the IP of the connection's peer, which is the client
+// announced by a PROXY protocol header when the listener handles them
+func remoteIP(c net.Conn) string {
+	addr := c.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,102 @@
+package connlimit
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// fakeConn is a connection from a fixed address
+type fakeConn struct {
+	net.Conn
+	addr   net.Addr
+	closed bool
+}
+
+func (c *fakeConn) RemoteAddr() net.Addr { return c.addr }
+func (c *fakeConn) Close() error         { c.closed = true; return nil }
+
+func newConn(ip string) *fakeConn {
+	return &fakeConn{addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}}
+}
+
+func TestTrackerLimitsConnectionsPerIP(t *testing.T) {
+	_, lb, _ := net.ParseCIDR("10.0.0.0/8")
+	var drops []string
+	open := 0
+	tracker := New(Limits{MaxPerIP: 2, Exempt: []*net.IPNet{lb}},
+		func(reason string) { drops = append(drops, reason) },
+		func(n int) { open = n })
+
+	conns := []*fakeConn{newConn("192.0.2.1"), newConn("192.0.2.1"), newConn("192.0.2.1")}
+	for _, c := range conns {
+		tracker.ConnState(c, http.StateNew)
+	}
+	if conns[1].closed || !conns[2].closed || len(drops) != 1 || drops[0] != DropPerIPLimit {
+		t.Fatalf("expected only the third connection dropped, got drops %v", drops)
+	}
+
+	// The dropped connection is reported closed by the server
+	tracker.ConnState(conns[2], http.StateClosed)
+	if tracker.Open("192.0.2.1") != 2 || open != 2 {
+		t.Errorf("expected 2 open connections, got %d (%d reported)", tracker.Open("192.0.2.1"), open)
+	}
+
+	// Closing one makes room for another
+	tracker.ConnState(conns[0], http.StateClosed)
+	next := newConn("192.0.2.1")
+	tracker.ConnState(next, http.StateNew)
+	if next.closed {
+		t.Error("expected a connection to be accepted after another closed")
+	}
+
+	for i := 0; i < 5; i++ {
+		c := newConn("10.1.2.3")
+		tracker.ConnState(c, http.StateNew)
+		if c.closed {
+			t.Fatal("expected exempt networks to be unlimited")
+		}
+	}
+}
+
+func TestTrackerLifetimeAndHeaderTimeout(t *testing.T) {
+	var drops []string
+	tracker := New(Limits{MaxLifetime: time.Millisecond, ReadHeaderTimeout: time.Millisecond},
+		func(reason string) { drops = append(drops, reason) }, nil)
+
+	served := newConn("192.0.2.1")
+	tracker.ConnState(served, http.StateNew)
+	tracker.ConnState(served, http.StateActive)
+	slow := newConn("192.0.2.2")
+	tracker.ConnState(slow, http.StateNew)
+	time.Sleep(2 * time.Millisecond)
+
+	tracker.ConnState(served, http.StateIdle)
+	if !served.closed {
+		t.Error("expected the connection to be closed past its lifetime")
+	}
+	tracker.ConnState(served, http.StateClosed)
+	tracker.ConnState(slow, http.StateClosed)
+
+	if len(drops) != 2 || drops[0] != DropMaxLifetime || drops[1] != DropReadHeaderTimeout {
+		t.Errorf("unexpected drops %v", drops)
+	}
+}
+
+func TestTrackerCountsOnRequest(t *testing.T) {
+	tracker := New(Limits{MaxPerIP: 1, CountOnRequest: true}, nil, nil)
+
+	first, second := newConn("192.0.2.1"), newConn("192.0.2.1")
+	tracker.ConnState(first, http.StateNew)
+	tracker.ConnState(second, http.StateNew)
+	if tracker.Open("192.0.2.1") != 0 {
+		t.Fatal("expected connections to be counted at their first request")
+	}
+
+	tracker.ConnState(first, http.StateActive)
+	tracker.ConnState(second, http.StateActive)
+	if first.closed || !second.closed {
+		t.Errorf("expected the second connection dropped at its first request")
+	}
+}
@@ -0,0 +1,88 @@
+package egress
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Allowlist holds the destination hosts the gateway may connect to. A nil
+// Allowlist allows every host.
+type Allowlist struct {
+	hosts    map[string]bool
+	suffixes []string
+	networks []*net.IPNet
+}
+
+// NewAllowlist parses allowlist entries: host names, wildcards such as
+// *.internal.example.com (matching subdomains only), IP addresses and CIDRs
+func NewAllowlist(entries []string) (*Allowlist, error) {
+	a := &Allowlist{hosts: make(map[string]bool)}
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			return nil, fmt.Errorf("empty egress host")
+		case strings.HasPrefix(entry, "*."):
+			a.suffixes = append(a.suffixes, entry[1:])
+		case strings.Contains(entry, "/"):
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid egress network %s: %w", entry, err)
+			}
+			a.networks = append(a.networks, network)
+		case strings.Contains(entry, "*"):
+			return nil, fmt.Errorf("invalid egress host %s: wildcards must be a leading *.", entry)
+		default:
+			if ip := net.ParseIP(entry); ip != nil {
+				entry = ip.String()
+			}
+			a.hosts[entry] = true
+		}
+	}
+	return a, nil
+}
+
+// Allows reports whether the gateway may connect to a host
+func (a *Allowlist) Allows(host string) bool {
+	if a == nil {
+		return true
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if ip := net.ParseIP(host); ip != nil {
+		if a.hosts[ip.String()] {
+			return true
+		}
+		for _, network := range a.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if a.hosts[host] {
+		return true
+	}
+	for _, suffix := range a.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckURL returns an error if the host of a URL is not allowed. URLs
+// without a network host, such as Unix sockets, are always allowed.
+func (a *Allowlist) CheckURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid url %s: %w", rawURL, err)
+	}
+	if host := u.Hostname(); host != "" && !a.Allows(host) {
+		return fmt.Errorf("%s is not in the egress allowlist", host)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+// Package hmacsign computes the request signatures checked by the gateway's
+// HMAC signature middleware and sent with webhook deliveries
+package hmacsign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Sign returns the hex HMAC-SHA256 with secret of
+// "<timestamp>.<nonce>.<METHOD>.<path and query>.<body>". Binding the
+// nonce, method and target to the signature keeps a captured request from
+// being replayed with a new nonce or against another route.
+func Sign(secret, timestamp, nonce, method, target string, body []byte) string {
+	return hex.EncodeToString(sum(secret, timestamp, nonce, method, target, body))
+}
+
+// Verify reports whether signature, in hex with an optional "sha256="
+// prefix, signs the request
+func Verify(signature, secret, timestamp, nonce, method, target string, body []byte) bool {
+	provided, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(provided, sum(secret, timestamp, nonce, method, target, body))
+}
+
+// sum computes the raw signature
+func sum(secret, timestamp, nonce, method, target string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	for _, part := range []string{timestamp, nonce, strings.ToUpper(method), target} {
+		mac.Write([]byte(part))
+		mac.Write([]byte("."))
+	}
+	mac.Write(body)
+	return mac.Sum(nil)
+}
@@ -23,6 +23,12 @@ type HealthChecker interface {
 	IsHealthy(target *url.URL) bool
 }
 
+// Weighter interface for load balancers with adjustable target weights
+type Weighter interface {
+	SetWeight(target *url.URL, weight int) bool
+	GetWeight(target *url.URL) int
+}
+
 // RoundRobin implements round-robin load balancing
 type RoundRobin struct {
 	targets []*url.URL
@@ -186,6 +192,39 @@ func (wrr *WeightedRoundRobin) GetTargets() []*url.URL {
 	return targets
 }
 
+// SetWeight changes the weight of a target. Weights below 1 default to 1,
+// as in NewWeightedRoundRobin. It returns false if the target is unknown.
+func (wrr *WeightedRoundRobin) SetWeight(target *url.URL, weight int) bool {
+	wrr.mu.Lock()
+	defer wrr.mu.Unlock()
+
+	if weight < 1 {
+		weight = 1
+	}
+
+	for _, t := range wrr.targets {
+		if t.URL.String() == target.String() {
+			t.Weight = weight
+			t.CurrentWeight = 0
+			return true
+		}
+	}
+	return false
+}
+
+// GetWeight returns the weight of a target, or 0 if the target is unknown
+func (wrr *WeightedRoundRobin) GetWeight(target *url.URL) int {
+	wrr.mu.RLock()
+	defer wrr.mu.RUnlock()
+
+	for _, t := range wrr.targets {
+		if t.URL.String() == target.String() {
+			return t.Weight
+		}
+	}
+	return 0
+}
+
 // Random implements random load balancing
 type Random struct {
 	targets []*url.URL
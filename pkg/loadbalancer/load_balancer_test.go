@@ -0,0 +1,56 @@
+package loadbalancer
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestWeightedRoundRobinWeights(t *testing.T) {
+	a := mustParse(t, "http://a")
+	b := mustParse(t, "http://b")
+	c := mustParse(t, "http://c")
+	wrr := NewWeightedRoundRobin([]*url.URL{a, b, c}, []int{3, 1, 0})
+
+	if got := wrr.GetWeight(c); got != 1 {
+		t.Errorf("GetWeight() of an unset weight = %d, want the default 1", got)
+	}
+	counts := pickWeighted(wrr, 50)
+	if counts["a"] != 30 || counts["b"] != 10 || counts["c"] != 10 {
+		t.Errorf("selection = %v, want a:b:c = 3:1:1", counts)
+	}
+
+	// Runtime changes take effect from the next selection
+	if !wrr.SetWeight(b, 4) {
+		t.Fatal("SetWeight() of a known target = false")
+	}
+	counts = pickWeighted(wrr, 80)
+	if counts["a"] != 30 || counts["b"] != 40 || counts["c"] != 10 {
+		t.Errorf("selection after SetWeight() = %v, want a:b:c = 3:4:1", counts)
+	}
+
+	// Like in the configuration, weights below 1 mean the default
+	wrr.SetWeight(a, 0)
+	if got := wrr.GetWeight(a); got != 1 {
+		t.Errorf("GetWeight() after SetWeight(0) = %d, want 1", got)
+	}
+	counts = pickWeighted(wrr, 60)
+	if counts["a"] != 10 || counts["b"] != 40 || counts["c"] != 10 {
+		t.Errorf("selection after SetWeight(0) = %v, want a:b:c = 1:4:1", counts)
+	}
+
+	if wrr.SetWeight(mustParse(t, "http://unknown"), 2) {
+		t.Error("SetWeight() of an unknown target = true")
+	}
+	if got := wrr.GetWeight(mustParse(t, "http://unknown")); got != 0 {
+		t.Errorf("GetWeight() of an unknown target = %d, want 0", got)
+	}
+}
+
+// pickWeighted returns how often each target was selected in n calls
+func pickWeighted(wrr *WeightedRoundRobin, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		counts[wrr.NextTarget().Host]++
+	}
+	return counts
+}
@@ -0,0 +1,233 @@
+package loadbalancer
+
+import (
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultUnhealthyCooldown is how long a target marked unhealthy is skipped
+// before it is given another chance
+const defaultUnhealthyCooldown = 30 * time.Second
+
+// PriorityTarget describes a target with its priority group and zone
+type PriorityTarget struct {
+	URL      *url.URL
+	Zone     string
+	Priority int // Lower values are preferred; 0 is the primary pool
+}
+
+// Priority implements zone-aware, priority-based load balancing.
+// Targets are grouped into tiers ordered by priority and, within a priority,
+// local zone before remote zones. Requests go to the first tier whose share
+// of healthy targets meets the threshold, round-robin within the tier.
+type Priority struct {
+	tiers             []*priorityTier
+	localZone         string
+	minHealthyPercent int
+	cooldown          time.Duration
+	mu                sync.RWMutex
+}
+
+type priorityTier struct {
+	priority int
+	local    bool
+	targets  []*priorityMember
+	current  uint64
+}
+
+type priorityMember struct {
+	target         PriorityTarget
+	unhealthyUntil time.Time
+}
+
+// NewPriority creates a new zone-aware priority load balancer. A tier is
+// used while at least minHealthyPercent of its targets are healthy; with 0,
+// traffic spills over only once a tier has no healthy targets left.
+func NewPriority(targets []PriorityTarget, localZone string, minHealthyPercent int) *Priority {
+	p := &Priority{
+		localZone:         localZone,
+		minHealthyPercent: minHealthyPercent,
+		cooldown:          defaultUnhealthyCooldown,
+	}
+
+	for _, target := range targets {
+		p.addLocked(target)
+	}
+
+	return p
+}
+
+// NextTarget returns the next target from the preferred usable tier
+func (p *Priority) NextTarget() *url.URL {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	now := time.Now()
+	var fallback *priorityTier
+
+	for _, tier := range p.tiers {
+		healthy := tier.healthyCount(now)
+		if healthy == 0 {
+			continue
+		}
+		if healthy*100 >= p.minHealthyPercent*len(tier.targets) {
+			return tier.next(now)
+		}
+		if fallback == nil {
+			fallback = tier
+		}
+	}
+
+	// No tier meets the threshold; use the most preferred one with any
+	// healthy target rather than failing the request
+	if fallback != nil {
+		return fallback.next(now)
+	}
+
+	return nil
+}
+
+// AddTarget adds a new target to the primary pool with no zone
+func (p *Priority) AddTarget(target *url.URL) {
+	p.AddPriorityTarget(PriorityTarget{URL: target})
+}
+
+// AddPriorityTarget adds a new target with zone and priority
+func (p *Priority) AddPriorityTarget(target PriorityTarget) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.addLocked(target)
+}
+
+// RemoveTarget removes a target
+func (p *Priority) RemoveTarget(target *url.URL) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, tier := range p.tiers {
+		for j, member := range tier.targets {
+			if member.target.URL.String() == target.String() {
+				tier.targets = append(tier.targets[:j], tier.targets[j+1:]...)
+				if len(tier.targets) == 0 {
+					p.tiers = append(p.tiers[:i], p.tiers[i+1:]...)
+				}
+				return
+			}
+		}
+	}
+}
+
+// GetTargets returns all targets in preference order
+func (p *Priority) GetTargets() []*url.URL {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	targets := make([]*url.URL, 0)
+	for _, tier := range p.tiers {
+		for _, member := range tier.targets {
+			targets = append(targets, member.target.URL)
+		}
+	}
+	return targets
+}
+
+// MarkHealthy marks a target as healthy
+func (p *Priority) MarkHealthy(target *url.URL) {
+	p.setUnhealthyUntil(target, time.Time{})
+}
+
+// MarkUnhealthy marks a target as unhealthy for the cooldown period
+func (p *Priority) MarkUnhealthy(target *url.URL) {
+	p.setUnhealthyUntil(target, time.Now().Add(p.cooldown))
+}
+
+// IsHealthy checks if a target is healthy
+func (p *Priority) IsHealthy(target *url.URL) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if member := p.findLocked(target); member != nil {
+		return member.healthy(time.Now())
+	}
+	return false
+}
+
+// setUnhealthyUntil updates the health state of a target
+func (p *Priority) setUnhealthyUntil(target *url.URL, until time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if member := p.findLocked(target); member != nil {
+		member.unhealthyUntil = until
+	}
+}
+
+// findLocked returns the member for a target. The caller must hold mu.
+func (p *Priority) findLocked(target *url.URL) *priorityMember {
+	for _, tier := range p.tiers {
+		for _, member := range tier.targets {
+			if member.target.URL.String() == target.String() {
+				return member
+			}
+		}
+	}
+	return nil
+}
+
+// addLocked inserts a target into its tier, keeping tiers in preference
+// order. The caller must hold mu.
+func (p *Priority) addLocked(target PriorityTarget) {
+	local := p.localZone == "" || target.Zone == "" || target.Zone == p.localZone
+	member := &priorityMember{target: target}
+
+	for _, tier := range p.tiers {
+		if tier.priority == target.Priority && tier.local == local {
+			tier.targets = append(tier.targets, member)
+			return
+		}
+	}
+
+	p.tiers = append(p.tiers, &priorityTier{
+		priority: target.Priority,
+		local:    local,
+		targets:  []*priorityMember{member},
+	})
+	sort.SliceStable(p.tiers, func(i, j int) bool {
+		if p.tiers[i].priority != p.tiers[j].priority {
+			return p.tiers[i].priority < p.tiers[j].priority
+		}
+		return p.tiers[i].local && !p.tiers[j].local
+	})
+}
+
+// healthyCount returns the number of healthy targets in the tier
+func (t *priorityTier) healthyCount(now time.Time) int {
+	count := 0
+	for _, member := range t.targets {
+		if member.healthy(now) {
+			count++
+		}
+	}
+	return count
+}
+
+// next returns the next healthy target in the tier using round-robin
+func (t *priorityTier) next(now time.Time) *url.URL {
+	n := uint64(len(t.targets))
+	start := atomic.AddUint64(&t.current, 1)
+	for i := uint64(0); i < n; i++ {
+		member := t.targets[(start+i)%n]
+		if member.healthy(now) {
+			return member.target.URL
+		}
+	}
+	return nil
+}
+
+// healthy reports whether the member is outside its unhealthy cooldown
+func (m *priorityMember) healthy(now time.Time) bool {
+	return !now.Before(m.unhealthyUntil)
+}
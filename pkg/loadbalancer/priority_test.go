@@ -0,0 +1,132 @@
+package loadbalancer
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func mustParse(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// pick returns how often each target was selected in n calls
+func pick(p *Priority, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		if target := p.NextTarget(); target != nil {
+			counts[target.Host]++
+		}
+	}
+	return counts
+}
+
+func TestPriorityTierSelection(t *testing.T) {
+	local1 := mustParse(t, "http://local-1")
+	local2 := mustParse(t, "http://local-2")
+	remote := mustParse(t, "http://remote")
+	backup := mustParse(t, "http://backup")
+	p := NewPriority([]PriorityTarget{
+		{URL: backup, Zone: "eu-1a", Priority: 1},
+		{URL: remote, Zone: "eu-1b"},
+		{URL: local1, Zone: "eu-1a"},
+		{URL: local2, Zone: "eu-1a"},
+	}, "eu-1a", 0)
+
+	counts := pick(p, 10)
+	if counts["local-1"] != 5 || counts["local-2"] != 5 {
+		t.Errorf("selection = %v, want round-robin over the local primary tier", counts)
+	}
+
+	want := []string{"local-1", "local-2", "remote", "backup"}
+	for i, target := range p.GetTargets() {
+		if target.Host != want[i] {
+			t.Errorf("GetTargets()[%d] = %s, want %s", i, target.Host, want[i])
+		}
+	}
+}
+
+func TestPriorityFailover(t *testing.T) {
+	primary1 := mustParse(t, "http://primary-1")
+	primary2 := mustParse(t, "http://primary-2")
+	backup := mustParse(t, "http://backup")
+	p := NewPriority([]PriorityTarget{
+		{URL: primary1},
+		{URL: primary2},
+		{URL: backup, Priority: 1},
+	}, "", 0)
+
+	p.MarkUnhealthy(primary1)
+	if counts := pick(p, 4); counts["primary-2"] != 4 {
+		t.Errorf("selection with one primary down = %v, want the remaining primary", counts)
+	}
+
+	p.MarkUnhealthy(primary2)
+	if counts := pick(p, 4); counts["backup"] != 4 {
+		t.Errorf("selection with the primary tier down = %v, want the backup tier", counts)
+	}
+
+	p.MarkUnhealthy(backup)
+	if target := p.NextTarget(); target != nil {
+		t.Errorf("NextTarget() with every target down = %v, want nil", target)
+	}
+}
+
+func TestPriorityMinHealthyPercent(t *testing.T) {
+	primary1 := mustParse(t, "http://primary-1")
+	primary2 := mustParse(t, "http://primary-2")
+	backup := mustParse(t, "http://backup")
+	p := NewPriority([]PriorityTarget{
+		{URL: primary1},
+		{URL: primary2},
+		{URL: backup, Priority: 1},
+	}, "", 75)
+
+	p.MarkUnhealthy(primary1)
+	if counts := pick(p, 4); counts["backup"] != 4 {
+		t.Errorf("selection with half the primary tier down = %v, want the backup tier", counts)
+	}
+
+	// Below the threshold everywhere, the most preferred tier still serves
+	p.MarkUnhealthy(backup)
+	if counts := pick(p, 4); counts["primary-2"] != 4 {
+		t.Errorf("selection with no tier above the threshold = %v, want the primary tier", counts)
+	}
+}
+
+func TestPriorityRecovery(t *testing.T) {
+	primary := mustParse(t, "http://primary")
+	backup := mustParse(t, "http://backup")
+	p := NewPriority([]PriorityTarget{
+		{URL: primary},
+		{URL: backup, Priority: 1},
+	}, "", 0)
+	p.cooldown = 50 * time.Millisecond
+
+	p.MarkUnhealthy(primary)
+	if target := p.NextTarget(); target != backup {
+		t.Fatalf("NextTarget() with the primary down = %v, want backup", target)
+	}
+
+	// Back to the primary tier once the cooldown passes
+	time.Sleep(60 * time.Millisecond)
+	if target := p.NextTarget(); target != primary {
+		t.Errorf("NextTarget() after the cooldown = %v, want primary", target)
+	}
+
+	// And immediately when a health check reports it healthy
+	p.cooldown = time.Hour
+	p.MarkUnhealthy(primary)
+	if !p.IsHealthy(backup) || p.IsHealthy(primary) {
+		t.Fatal("IsHealthy() does not reflect the marked state")
+	}
+	p.MarkHealthy(primary)
+	if target := p.NextTarget(); target != primary {
+		t.Errorf("NextTarget() after MarkHealthy() = %v, want primary", target)
+	}
+}
@@ -0,0 +1,22 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/max/api-gateway/pkg/tracing"
+)
+
+// observeWithTrace records a histogram observation, attaching the trace ID
+// of a sampled trace in ctx as an exemplar so dashboards can link a latency
+// bucket to an example trace
+func observeWithTrace(ctx context.Context, observer prometheus.Observer, value float64) {
+	if span, ok := tracing.FromContext(ctx); ok && span.Sampled {
+		if exemplarObserver, ok := observer.(prometheus.ExemplarObserver); ok {
+			exemplarObserver.ObserveWithExemplar(value, prometheus.Labels{"trace_id": span.TraceID})
+			return
+		}
+	}
+	observer.Observe(value)
+}
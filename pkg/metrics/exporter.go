@@ -0,0 +1,75 @@
+package metrics
+
+// Exporter pushes gateway metrics to a backend other than the Prometheus
+// registry, which stays the source for the /metrics endpoint. Every
+// recording on the Manager is forwarded to all registered exporters; each
+// exporter aggregates or ships the values as its backend expects.
+type Exporter interface {
+	// Counter adds value to a monotonic counter
+	Counter(name string, value float64, labels map[string]string)
+	// Gauge sets the current value of a gauge
+	Gauge(name string, value float64, labels map[string]string)
+	// Histogram records an observation in a distribution
+	Histogram(name string, value float64, labels map[string]string)
+	// Close flushes buffered metrics and stops the exporter
+	Close() error
+}
+
+// AddExporter registers an exporter. Exporters should be added before the
+// gateway starts serving traffic.
+func (m *Manager) AddExporter(exporter Exporter) {
+	m.exportersMu.Lock()
+	defer m.exportersMu.Unlock()
+	m.exporters = append(m.exporters, exporter)
+}
+
+// Close flushes and stops all exporters
+func (m *Manager) Close() error {
+	m.exportersMu.Lock()
+	exporters := m.exporters
+	m.exporters = nil
+	m.exportersMu.Unlock()
+
+	var firstErr error
+	for _, exporter := range exporters {
+		if err := exporter.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// metricKind selects the Exporter method a value is sent to
+type metricKind int
+
+const (
+	kindCounter metricKind = iota
+	kindGauge
+	kindHistogram
+)
+
+// export forwards a value to the exporters. Labels are given as key/value
+// pairs and only turned into a map when an exporter is registered.
+func (m *Manager) export(kind metricKind, name string, value float64, labelPairs ...string) {
+	m.exportersMu.RLock()
+	defer m.exportersMu.RUnlock()
+	if len(m.exporters) == 0 {
+		return
+	}
+
+	labels := make(map[string]string, len(labelPairs)/2)
+	for i := 0; i+1 < len(labelPairs); i += 2 {
+		labels[labelPairs[i]] = labelPairs[i+1]
+	}
+
+	for _, exporter := range m.exporters {
+		switch kind {
+		case kindCounter:
+			exporter.Counter(name, value, labels)
+		case kindGauge:
+			exporter.Gauge(name, value, labels)
+		case kindHistogram:
+			exporter.Histogram(name, value, labels)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestStatsDExporter_SendsTaggedLines(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	exporter, err := NewStatsDExporter(conn.LocalAddr().String(), "gw.", map[string]string{"env": "test"}, time.Hour, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to create exporter: %v", err)
+	}
+
+	exporter.Counter("requests", 1, map[string]string{"method": "GET"})
+	exporter.Histogram("latency", 0.25, nil)
+	if err := exporter.Close(); err != nil {
+		t.Fatalf("Failed to close exporter: %v", err)
+	}
+
+	buf := make([]byte, maxStatsDPacket)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Failed to read packet: %v", err)
+	}
+
+	lines := strings.Split(string(buf[:n]), "\n")
+	expected := []string{"gw.requests:1|c|#env:test,method:GET", "gw.latency:0.25|h|#env:test"}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got %q", len(expected), lines)
+	}
+	for i, line := range expected {
+		if lines[i] != line {
+			t.Errorf("Expected %q, got %q", line, lines[i])
+		}
+	}
+}
+
+func TestExponentialBucket(t *testing.T) {
+	base := math.Exp2(math.Exp2(-otlpHistogramScale))
+	for _, value := range []float64{0.0001, 0.003, 1, 1.5, 250, 1e6} {
+		index := exponentialBucket(value)
+		lower, upper := math.Pow(base, float64(index)), math.Pow(base, float64(index+1))
+		if !(value > lower*(1-1e-9) && value <= upper*(1+1e-9)) {
+			t.Errorf("Value %v not in bucket %d (%v, %v]", value, index, lower, upper)
+		}
+	}
+}
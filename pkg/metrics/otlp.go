@@ -0,0 +1,319 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// otlpHistogramScale is the resolution of exported exponential histograms;
+// scale 3 gives buckets about 9% wide
+const otlpHistogramScale = 3
+
+// OTLPExporter aggregates metrics in memory and pushes them periodically to
+// an OTLP/HTTP collector as JSON. Counters and histograms are cumulative.
+type OTLPExporter struct {
+	endpoint    string
+	headers     map[string]string
+	serviceName string
+	client      *http.Client
+	startTime   time.Time
+
+	mu     sync.Mutex
+	series map[string]*otlpSeries
+
+	done   chan struct{}
+	wg     sync.WaitGroup
+	logger *zap.Logger
+}
+
+// otlpSeries is the aggregate of one metric and label set
+type otlpSeries struct {
+	name   string
+	kind   metricKind
+	labels map[string]string
+
+	// Counter and gauge value
+	value float64
+
+	// Histogram state
+	count     uint64
+	sum       float64
+	min       float64
+	max       float64
+	zeroCount uint64
+	buckets   map[int]uint64
+}
+
+// NewOTLPExporter creates an exporter pushing to endpoint, the collector's
+// metrics URL (e.g. "http://otel-collector:4318/v1/metrics")
+func NewOTLPExporter(endpoint string, headers map[string]string, serviceName string, interval, timeout time.Duration, logger *zap.Logger) *OTLPExporter {
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	e := &OTLPExporter{
+		endpoint:    endpoint,
+		headers:     headers,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: timeout},
+		startTime:   time.Now(),
+		series:      make(map[string]*otlpSeries),
+		done:        make(chan struct{}),
+		logger:      logger,
+	}
+
+	e.wg.Add(1)
+	go e.pushLoop(interval)
+	return e
+}
+
+// Counter adds to a cumulative sum
+func (e *OTLPExporter) Counter(name string, value float64, labels map[string]string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.seriesLocked(name, kindCounter, labels).value += value
+}
+
+// Gauge records the latest value
+func (e *OTLPExporter) Gauge(name string, value float64, labels map[string]string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.seriesLocked(name, kindGauge, labels).value = value
+}
+
+// Histogram records an observation in an exponential histogram
+func (e *OTLPExporter) Histogram(name string, value float64, labels map[string]string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s := e.seriesLocked(name, kindHistogram, labels)
+	if s.count == 0 || value < s.min {
+		s.min = value
+	}
+	if s.count == 0 || value > s.max {
+		s.max = value
+	}
+	s.count++
+	s.sum += value
+
+	if value <= 0 {
+		s.zeroCount++
+		return
+	}
+	if s.buckets == nil {
+		s.buckets = make(map[int]uint64)
+	}
+	s.buckets[exponentialBucket(value)]++
+}
+
+// Close pushes the final values and stops the exporter
+func (e *OTLPExporter) Close() error {
+	close(e.done)
+	e.wg.Wait()
+	return e.push()
+}
+
+// seriesLocked returns the series for a metric and label set. The caller
+// must hold mu.
+func (e *OTLPExporter) seriesLocked(name string, kind metricKind, labels map[string]string) *otlpSeries {
+	key := seriesKey(name, labels)
+	s, exists := e.series[key]
+	if !exists {
+		s = &otlpSeries{name: name, kind: kind, labels: labels}
+		e.series[key] = s
+	}
+	return s
+}
+
+// pushLoop pushes metrics periodically
+func (e *OTLPExporter) pushLoop(interval time.Duration) {
+	defer e.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := e.push(); err != nil {
+				e.logger.Warn("Failed to push OTLP metrics", zap.Error(err))
+			}
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// push sends the current aggregates to the collector
+func (e *OTLPExporter) push() error {
+	body, err := json.Marshal(e.buildRequest(time.Now()))
+	if err != nil {
+		return fmt.Errorf("failed to encode OTLP metrics: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.client.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create OTLP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push OTLP metrics: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("OTLP collector returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// buildRequest converts the aggregates into an ExportMetricsServiceRequest
+// in the OTLP JSON encoding
+func (e *OTLPExporter) buildRequest(now time.Time) map[string]interface{} {
+	start := strconv.FormatInt(e.startTime.UnixNano(), 10)
+	timestamp := strconv.FormatInt(now.UnixNano(), 10)
+
+	e.mu.Lock()
+	byName := make(map[string][]map[string]interface{})
+	kinds := make(map[string]metricKind)
+	for _, s := range e.series {
+		point := map[string]interface{}{
+			"attributes":        otlpAttributes(s.labels),
+			"startTimeUnixNano": start,
+			"timeUnixNano":      timestamp,
+		}
+		switch s.kind {
+		case kindCounter, kindGauge:
+			point["asDouble"] = s.value
+		case kindHistogram:
+			offset, counts := denseBuckets(s.buckets)
+			point["count"] = strconv.FormatUint(s.count, 10)
+			point["sum"] = s.sum
+			point["min"] = s.min
+			point["max"] = s.max
+			point["scale"] = otlpHistogramScale
+			point["zeroCount"] = strconv.FormatUint(s.zeroCount, 10)
+			point["positive"] = map[string]interface{}{"offset": offset, "bucketCounts": counts}
+		}
+		byName[s.name] = append(byName[s.name], point)
+		kinds[s.name] = s.kind
+	}
+	e.mu.Unlock()
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	metrics := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		metric := map[string]interface{}{"name": name}
+		points := byName[name]
+		switch kinds[name] {
+		case kindCounter:
+			metric["sum"] = map[string]interface{}{"aggregationTemporality": 2, "isMonotonic": true, "dataPoints": points}
+		case kindGauge:
+			metric["gauge"] = map[string]interface{}{"dataPoints": points}
+		case kindHistogram:
+			metric["exponentialHistogram"] = map[string]interface{}{"aggregationTemporality": 2, "dataPoints": points}
+		}
+		metrics = append(metrics, metric)
+	}
+
+	return map[string]interface{}{
+		"resourceMetrics": []map[string]interface{}{{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]string{"service.name": e.serviceName}),
+			},
+			"scopeMetrics": []map[string]interface{}{{
+				"scope":   map[string]interface{}{"name": "github.com/max/api-gateway/pkg/metrics"},
+				"metrics": metrics,
+			}},
+		}},
+	}
+}
+
+// exponentialBucket returns the index of the exponential histogram bucket
+// for a positive value: bucket i covers (base^i, base^(i+1)]
+func exponentialBucket(value float64) int {
+	return int(math.Ceil(math.Log2(value)*math.Exp2(otlpHistogramScale))) - 1
+}
+
+// denseBuckets converts sparse bucket counts into an offset and contiguous
+// counts
+func denseBuckets(buckets map[int]uint64) (int, []string) {
+	if len(buckets) == 0 {
+		return 0, []string{}
+	}
+
+	low, high := math.MaxInt, math.MinInt
+	for index := range buckets {
+		low = min(low, index)
+		high = max(high, index)
+	}
+
+	counts := make([]string, high-low+1)
+	for i := range counts {
+		counts[i] = strconv.FormatUint(buckets[low+i], 10)
+	}
+	return low, counts
+}
+
+// otlpAttributes converts labels into sorted OTLP key-values
+func otlpAttributes(labels map[string]string) []map[string]interface{} {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attributes := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		attributes = append(attributes, map[string]interface{}{
+			"key":   key,
+			"value": map[string]interface{}{"stringValue": labels[key]},
+		})
+	}
+	return attributes
+}
+
+// seriesKey identifies a metric and label set
+func seriesKey(name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(name)
+	for _, key := range keys {
+		b.WriteByte('\x00')
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(labels[key])
+	}
+	return b.String()
+}
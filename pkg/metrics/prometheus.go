@@ -1,12 +1,15 @@
 package metrics
 
 import (
+	"context"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 )
@@ -36,16 +39,80 @@ type Manager struct {
 	cacheHits   *prometheus.CounterVec
 	cacheMisses *prometheus.CounterVec
 
+	// Security metrics
+	wafMatches *prometheus.CounterVec
+
+	// API lifecycle metrics
+	deprecatedCalls *prometheus.CounterVec
+
+	// Admission queue metrics
+	admissionQueued   *prometheus.GaugeVec
+	admissionInFlight *prometheus.GaugeVec
+	admissionWait     *prometheus.HistogramVec
+	admissionShed     *prometheus.CounterVec
+	admissionLimit    *prometheus.GaugeVec
+
+	// Experiment metrics
+	experimentRequests *prometheus.CounterVec
+	experimentDuration *prometheus.HistogramVec
+
+	// Synthetic probe metrics
+	syntheticChecks   *prometheus.CounterVec
+	syntheticDuration *prometheus.HistogramVec
+	syntheticUp       *prometheus.GaugeVec
+
+	// Fault injection metrics
+	chaosFaults *prometheus.CounterVec
+
+	// Load shedding metrics
+	loadShed     *prometheus.CounterVec
+	loadOverload *prometheus.GaugeVec
+	degraded     prometheus.Gauge
+
+	// Event consumer metrics
+	eventRetries       *prometheus.CounterVec
+	eventsDeadLettered *prometheus.CounterVec
+	deadLetterDepth    *prometheus.GaugeVec
+	eventBufferDepth   *prometheus.GaugeVec
+	eventsDropped      *prometheus.CounterVec
+
+	// Webhook metrics
+	webhookDeliveries *prometheus.CounterVec
+	webhookDuration   *prometheus.HistogramVec
+
+	// Requests rejected for oversized headers
+	headerRejections *prometheus.CounterVec
+
+	// Connections closed by the slow and idle client protection
+	connectionsDropped *prometheus.CounterVec
+
 	// System metrics
 	gatewayInfo       *prometheus.GaugeVec
 	gatewayUptime     prometheus.Gauge
 	activeConnections prometheus.Gauge
 
+	// Push exporters receiving every recording
+	exporters   []Exporter
+	exportersMu sync.RWMutex
+
+	// In-memory per-service aggregates for the admin dashboard
+	serviceStats map[string]*ServiceStats
+	statsMu      sync.RWMutex
+
 	registry  *prometheus.Registry
 	logger    *zap.Logger
 	startTime time.Time
 }
 
+// ServiceStats holds cumulative upstream counters for a single service
+type ServiceStats struct {
+	Requests       int64   `json:"requests"`
+	Errors         int64   `json:"errors"`
+	ServerErrors   int64   `json:"server_errors"`
+	TotalLatencyMs float64 `json:"total_latency_ms"`
+	AvgLatencyMs   float64 `json:"avg_latency_ms"`
+}
+
 // NewManager creates a new metrics manager
 func NewManager(logger *zap.Logger) *Manager {
 	registry := prometheus.NewRegistry()
@@ -163,6 +230,218 @@ func NewManager(logger *zap.Logger) *Manager {
 		[]string{"cache_type"},
 	)
 
+	// Security metrics
+	wafMatches := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_waf_matches_total",
+			Help: "Total number of WAF rule matches",
+		},
+		[]string{"rule", "action"},
+	)
+
+	// API lifecycle metrics
+	deprecatedCalls := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_deprecated_api_calls_total",
+			Help: "Total number of calls to deprecated API versions",
+		},
+		[]string{"service", "version"},
+	)
+
+	// Admission queue metrics
+	admissionQueued := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_admission_queue_depth",
+			Help: "Number of requests waiting for an upstream concurrency slot",
+		},
+		[]string{"service"},
+	)
+
+	admissionInFlight := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_admission_in_flight",
+			Help: "Number of admitted upstream requests in flight",
+		},
+		[]string{"service"},
+	)
+
+	admissionWait := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gateway_admission_wait_seconds",
+			Help:    "Time queued requests waited for a concurrency slot",
+			Buckets: []float64{.001, .005, .01, .05, .1, .25, .5, 1, 2.5, 5},
+		},
+		[]string{"service"},
+	)
+
+	admissionShed := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_admission_shed_total",
+			Help: "Total number of requests shed by the admission queue",
+		},
+		[]string{"service", "reason"},
+	)
+
+	admissionLimit := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_admission_limit",
+			Help: "Current upstream concurrency limit of the admission queue",
+		},
+		[]string{"service"},
+	)
+
+	// Experiment metrics
+	experimentRequests := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_experiment_requests_total",
+			Help: "Total number of requests per experiment variant",
+		},
+		[]string{"experiment", "variant", "status_code"},
+	)
+
+	experimentDuration := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gateway_experiment_request_duration_seconds",
+			Help:    "Request duration per experiment variant",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"experiment", "variant"},
+	)
+
+	// Synthetic probe metrics
+	syntheticChecks := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_synthetic_checks_total",
+			Help: "Total number of synthetic probe runs",
+		},
+		[]string{"probe", "result"},
+	)
+
+	syntheticDuration := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gateway_synthetic_check_duration_seconds",
+			Help:    "Synthetic probe duration",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"probe"},
+	)
+
+	syntheticUp := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_synthetic_check_up",
+			Help: "Whether the last run of a synthetic probe passed (1) or failed (0)",
+		},
+		[]string{"probe"},
+	)
+
+	// Fault injection metrics
+	chaosFaults := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_chaos_faults_total",
+			Help: "Total number of faults injected by chaos rules",
+		},
+		[]string{"rule", "fault"},
+	)
+
+	// Load shedding metrics
+	loadShed := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_load_shed_total",
+			Help: "Total number of requests shed under overload by priority class",
+		},
+		[]string{"class", "signal"},
+	)
+
+	loadOverload := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_load_overload_ratio",
+			Help: "Overload signal value as a fraction of its threshold",
+		},
+		[]string{"signal"},
+	)
+
+	degraded := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gateway_degraded",
+			Help: "1 while expensive features are off because of gateway CPU or memory use",
+		},
+	)
+
+	// Event consumer metrics
+	eventRetries := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_event_consumer_retries_total",
+			Help: "Total number of retried event handler failures",
+		},
+		[]string{"provider"},
+	)
+
+	eventsDeadLettered := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_events_dead_lettered_total",
+			Help: "Total number of events sent to the dead-letter topic or queue",
+		},
+		[]string{"provider", "reason"},
+	)
+
+	deadLetterDepth := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_event_dead_letter_depth",
+			Help: "Number of messages in the dead-letter topic or queue",
+		},
+		[]string{"provider", "destination"},
+	)
+
+	eventBufferDepth := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_event_buffer_depth",
+			Help: "Number of events waiting to be published, in memory or spilled to disk",
+		},
+		[]string{"storage"},
+	)
+
+	eventsDropped := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_events_dropped_total",
+			Help: "Total number of events dropped before being published",
+		},
+		[]string{"reason"},
+	)
+
+	// Webhook metrics
+	webhookDeliveries := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_webhook_deliveries_total",
+			Help: "Total number of webhook delivery attempts",
+		},
+		[]string{"endpoint", "result"},
+	)
+
+	webhookDuration := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gateway_webhook_delivery_duration_seconds",
+			Help:    "Webhook delivery attempt duration",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"endpoint"},
+	)
+
+	headerRejections := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_header_limit_rejections_total",
+			Help: "Total number of requests rejected for exceeding a header limit",
+		},
+		[]string{"limit"},
+	)
+
+	connectionsDropped := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_connections_dropped_total",
+			Help: "Total number of client connections dropped by connection limits",
+		},
+		[]string{"reason"},
+	)
+
 	// System metrics
 	gatewayInfo := prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -201,6 +480,31 @@ func NewManager(logger *zap.Logger) *Manager {
 		upstreamErrors,
 		cacheHits,
 		cacheMisses,
+		wafMatches,
+		deprecatedCalls,
+		admissionQueued,
+		admissionInFlight,
+		admissionWait,
+		admissionShed,
+		admissionLimit,
+		experimentRequests,
+		experimentDuration,
+		syntheticChecks,
+		syntheticDuration,
+		syntheticUp,
+		chaosFaults,
+		loadShed,
+		loadOverload,
+		degraded,
+		eventRetries,
+		eventsDeadLettered,
+		deadLetterDepth,
+		eventBufferDepth,
+		eventsDropped,
+		webhookDeliveries,
+		webhookDuration,
+		headerRejections,
+		connectionsDropped,
 		gatewayInfo,
 		gatewayUptime,
 		activeConnections,
@@ -220,9 +524,35 @@ func NewManager(logger *zap.Logger) *Manager {
 		upstreamErrors:      upstreamErrors,
 		cacheHits:           cacheHits,
 		cacheMisses:         cacheMisses,
+		wafMatches:          wafMatches,
+		deprecatedCalls:     deprecatedCalls,
+		admissionQueued:     admissionQueued,
+		admissionInFlight:   admissionInFlight,
+		admissionWait:       admissionWait,
+		admissionShed:       admissionShed,
+		admissionLimit:      admissionLimit,
+		experimentRequests:  experimentRequests,
+		experimentDuration:  experimentDuration,
+		syntheticChecks:     syntheticChecks,
+		syntheticDuration:   syntheticDuration,
+		syntheticUp:         syntheticUp,
+		chaosFaults:         chaosFaults,
+		loadShed:            loadShed,
+		loadOverload:        loadOverload,
+		degraded:            degraded,
+		eventRetries:        eventRetries,
+		eventsDeadLettered:  eventsDeadLettered,
+		deadLetterDepth:     deadLetterDepth,
+		eventBufferDepth:    eventBufferDepth,
+		eventsDropped:       eventsDropped,
+		webhookDeliveries:   webhookDeliveries,
+		webhookDuration:     webhookDuration,
+		headerRejections:    headerRejections,
+		connectionsDropped:  connectionsDropped,
 		gatewayInfo:         gatewayInfo,
 		gatewayUptime:       gatewayUptime,
 		activeConnections:   activeConnections,
+		serviceStats:        make(map[string]*ServiceStats),
 		registry:            registry,
 		logger:              logger,
 		startTime:           time.Now(),
@@ -238,75 +568,299 @@ func NewManager(logger *zap.Logger) *Manager {
 }
 
 // RecordHTTPRequest records an HTTP request metric
-func (m *Manager) RecordHTTPRequest(method, path string, statusCode int, duration time.Duration) {
+func (m *Manager) RecordHTTPRequest(ctx context.Context, method, path string, statusCode int, duration time.Duration) {
 	statusStr := strconv.Itoa(statusCode)
 
 	m.httpRequests.WithLabelValues(method, path, statusStr).Inc()
-	m.httpDuration.WithLabelValues(method, path, statusStr).Observe(duration.Seconds())
+	observeWithTrace(ctx, m.httpDuration.WithLabelValues(method, path, statusStr), duration.Seconds())
+
+	m.export(kindCounter, "gateway_http_requests_total", 1, "method", method, "path", path, "status_code", statusStr)
+	m.export(kindHistogram, "gateway_http_request_duration_seconds", duration.Seconds(), "method", method, "path", path, "status_code", statusStr)
 }
 
 // RecordHTTPRequestSize records HTTP request size
 func (m *Manager) RecordHTTPRequestSize(method, path string, size int64) {
 	m.httpRequestSize.WithLabelValues(method, path).Observe(float64(size))
+	m.export(kindHistogram, "gateway_http_request_size_bytes", float64(size), "method", method, "path", path)
 }
 
 // RecordHTTPResponseSize records HTTP response size
 func (m *Manager) RecordHTTPResponseSize(method, path string, statusCode int, size int64) {
 	statusStr := strconv.Itoa(statusCode)
 	m.httpResponseSize.WithLabelValues(method, path, statusStr).Observe(float64(size))
+	m.export(kindHistogram, "gateway_http_response_size_bytes", float64(size), "method", method, "path", path, "status_code", statusStr)
 }
 
 // RecordRateLimitHit records a rate limit hit
 func (m *Manager) RecordRateLimitHit(algorithm, keyType string) {
 	m.rateLimitHits.WithLabelValues(algorithm, keyType).Inc()
+	m.export(kindCounter, "gateway_rate_limit_hits_total", 1, "algorithm", algorithm, "key_type", keyType)
 }
 
 // RecordRateLimitMiss records a rate limit miss (allowed request)
 func (m *Manager) RecordRateLimitMiss(algorithm, keyType string) {
 	m.rateLimitMisses.WithLabelValues(algorithm, keyType).Inc()
+	m.export(kindCounter, "gateway_rate_limit_misses_total", 1, "algorithm", algorithm, "key_type", keyType)
 }
 
 // SetCircuitBreakerState sets the circuit breaker state
 func (m *Manager) SetCircuitBreakerState(name string, state int) {
 	m.circuitBreakerState.WithLabelValues(name).Set(float64(state))
+	m.export(kindGauge, "gateway_circuit_breaker_state", float64(state), "name", name)
 }
 
 // RecordCircuitBreakerRequest records a circuit breaker request
 func (m *Manager) RecordCircuitBreakerRequest(name, state, result string) {
 	m.circuitBreakerReqs.WithLabelValues(name, state, result).Inc()
+	m.export(kindCounter, "gateway_circuit_breaker_requests_total", 1, "name", name, "state", state, "result", result)
 }
 
 // RecordUpstreamRequest records an upstream request
-func (m *Manager) RecordUpstreamRequest(service, method string, statusCode int, duration time.Duration) {
+func (m *Manager) RecordUpstreamRequest(ctx context.Context, service, method string, statusCode int, duration time.Duration) {
 	statusStr := strconv.Itoa(statusCode)
 
 	m.upstreamRequests.WithLabelValues(service, method, statusStr).Inc()
-	m.upstreamDuration.WithLabelValues(service, method).Observe(duration.Seconds())
+	observeWithTrace(ctx, m.upstreamDuration.WithLabelValues(service, method), duration.Seconds())
+	m.export(kindCounter, "gateway_upstream_requests_total", 1, "service", service, "method", method, "status_code", statusStr)
+	m.export(kindHistogram, "gateway_upstream_request_duration_seconds", duration.Seconds(), "service", service, "method", method)
+
+	m.statsMu.Lock()
+	stats := m.serviceStatsLocked(service)
+	stats.Requests++
+	stats.TotalLatencyMs += float64(duration) / float64(time.Millisecond)
+	if statusCode >= 500 {
+		stats.ServerErrors++
+	}
+	m.statsMu.Unlock()
 }
 
 // RecordUpstreamError records an upstream error
 func (m *Manager) RecordUpstreamError(service, errorType string) {
 	m.upstreamErrors.WithLabelValues(service, errorType).Inc()
+	m.export(kindCounter, "gateway_upstream_errors_total", 1, "service", service, "error_type", errorType)
+
+	m.statsMu.Lock()
+	m.serviceStatsLocked(service).Errors++
+	m.statsMu.Unlock()
+}
+
+// serviceStatsLocked returns the aggregate for a service, creating it if needed.
+// The caller must hold statsMu.
+func (m *Manager) serviceStatsLocked(service string) *ServiceStats {
+	stats, exists := m.serviceStats[service]
+	if !exists {
+		stats = &ServiceStats{}
+		m.serviceStats[service] = stats
+	}
+	return stats
+}
+
+// GetServiceStats returns a snapshot of the per-service upstream aggregates
+func (m *Manager) GetServiceStats() map[string]ServiceStats {
+	m.statsMu.RLock()
+	defer m.statsMu.RUnlock()
+
+	snapshot := make(map[string]ServiceStats, len(m.serviceStats))
+	for name, stats := range m.serviceStats {
+		s := *stats
+		if s.Requests > 0 {
+			s.AvgLatencyMs = s.TotalLatencyMs / float64(s.Requests)
+		}
+		snapshot[name] = s
+	}
+	return snapshot
 }
 
 // RecordCacheHit records a cache hit
 func (m *Manager) RecordCacheHit(cacheType string) {
 	m.cacheHits.WithLabelValues(cacheType).Inc()
+	m.export(kindCounter, "gateway_cache_hits_total", 1, "cache_type", cacheType)
 }
 
 // RecordCacheMiss records a cache miss
 func (m *Manager) RecordCacheMiss(cacheType string) {
 	m.cacheMisses.WithLabelValues(cacheType).Inc()
+	m.export(kindCounter, "gateway_cache_misses_total", 1, "cache_type", cacheType)
+}
+
+// RecordWAFMatch records a WAF rule match and whether it blocked the request
+func (m *Manager) RecordWAFMatch(rule, action string) {
+	m.wafMatches.WithLabelValues(rule, action).Inc()
+	m.export(kindCounter, "gateway_waf_matches_total", 1, "rule", rule, "action", action)
+}
+
+// RecordDeprecatedCall records a call to a deprecated API version
+func (m *Manager) RecordDeprecatedCall(service, version string) {
+	m.deprecatedCalls.WithLabelValues(service, version).Inc()
+	m.export(kindCounter, "gateway_deprecated_api_calls_total", 1, "service", service, "version", version)
+}
+
+// SetAdmissionState sets the queued and in-flight requests of a service's
+// admission queue
+func (m *Manager) SetAdmissionState(service string, queued, inFlight int) {
+	m.admissionQueued.WithLabelValues(service).Set(float64(queued))
+	m.admissionInFlight.WithLabelValues(service).Set(float64(inFlight))
+	m.export(kindGauge, "gateway_admission_queue_depth", float64(queued), "service", service)
+	m.export(kindGauge, "gateway_admission_in_flight", float64(inFlight), "service", service)
+}
+
+// RecordAdmissionWait records how long a queued request waited for a slot
+func (m *Manager) RecordAdmissionWait(service string, wait time.Duration) {
+	m.admissionWait.WithLabelValues(service).Observe(wait.Seconds())
+	m.export(kindHistogram, "gateway_admission_wait_seconds", wait.Seconds(), "service", service)
+}
+
+// RecordAdmissionShed records a request shed by the admission queue
+func (m *Manager) RecordAdmissionShed(service, reason string) {
+	m.admissionShed.WithLabelValues(service, reason).Inc()
+	m.export(kindCounter, "gateway_admission_shed_total", 1, "service", service, "reason", reason)
+}
+
+// SetAdmissionLimit sets the concurrency limit of a service's admission queue
+func (m *Manager) SetAdmissionLimit(service string, limit int) {
+	m.admissionLimit.WithLabelValues(service).Set(float64(limit))
+	m.export(kindGauge, "gateway_admission_limit", float64(limit), "service", service)
+}
+
+// RecordExperimentRequest records a request of a user assigned to an
+// experiment variant
+func (m *Manager) RecordExperimentRequest(experiment, variant string, statusCode int, duration time.Duration) {
+	statusStr := strconv.Itoa(statusCode)
+	m.experimentRequests.WithLabelValues(experiment, variant, statusStr).Inc()
+	m.experimentDuration.WithLabelValues(experiment, variant).Observe(duration.Seconds())
+	m.export(kindCounter, "gateway_experiment_requests_total", 1, "experiment", experiment, "variant", variant, "status_code", statusStr)
+	m.export(kindHistogram, "gateway_experiment_request_duration_seconds", duration.Seconds(), "experiment", experiment, "variant", variant)
+}
+
+// RecordSyntheticCheck records a run of a synthetic probe
+func (m *Manager) RecordSyntheticCheck(probe string, passed bool, duration time.Duration) {
+	result, up := "fail", 0.0
+	if passed {
+		result, up = "pass", 1.0
+	}
+	m.syntheticChecks.WithLabelValues(probe, result).Inc()
+	m.syntheticDuration.WithLabelValues(probe).Observe(duration.Seconds())
+	m.syntheticUp.WithLabelValues(probe).Set(up)
+	m.export(kindCounter, "gateway_synthetic_checks_total", 1, "probe", probe, "result", result)
+	m.export(kindHistogram, "gateway_synthetic_check_duration_seconds", duration.Seconds(), "probe", probe)
+	m.export(kindGauge, "gateway_synthetic_check_up", up, "probe", probe)
+}
+
+// RecordChaosFault records a fault injected by a chaos rule
+func (m *Manager) RecordChaosFault(rule, fault string) {
+	m.chaosFaults.WithLabelValues(rule, fault).Inc()
+	m.export(kindCounter, "gateway_chaos_faults_total", 1, "rule", rule, "fault", fault)
+}
+
+// RecordLoadShed records a request shed under overload, with the signal
+// that crossed its threshold
+func (m *Manager) RecordLoadShed(class, signal string) {
+	m.loadShed.WithLabelValues(class, signal).Inc()
+	m.export(kindCounter, "gateway_load_shed_total", 1, "class", class, "signal", signal)
+}
+
+// SetLoadOverload sets an overload signal as a fraction of its threshold
+func (m *Manager) SetLoadOverload(signal string, ratio float64) {
+	m.loadOverload.WithLabelValues(signal).Set(ratio)
+	m.export(kindGauge, "gateway_load_overload_ratio", ratio, "signal", signal)
+}
+
+// SetDegraded records whether the degradation mode is engaged
+func (m *Manager) SetDegraded(engaged bool) {
+	value := 0.0
+	if engaged {
+		value = 1
+	}
+	m.degraded.Set(value)
+	m.export(kindGauge, "gateway_degraded", value)
+}
+
+// RecordEventRetry records a retried event handler failure
+func (m *Manager) RecordEventRetry(provider string) {
+	m.eventRetries.WithLabelValues(provider).Inc()
+	m.export(kindCounter, "gateway_event_consumer_retries_total", 1, "provider", provider)
+}
+
+// RecordEventDeadLettered records an event sent to the dead-letter topic
+// or queue
+func (m *Manager) RecordEventDeadLettered(provider, reason string) {
+	m.eventsDeadLettered.WithLabelValues(provider, reason).Inc()
+	m.export(kindCounter, "gateway_events_dead_lettered_total", 1, "provider", provider, "reason", reason)
+}
+
+// SetDeadLetterDepth sets the number of messages in a dead-letter topic or
+// queue
+func (m *Manager) SetDeadLetterDepth(provider, destination string, depth int64) {
+	m.deadLetterDepth.WithLabelValues(provider, destination).Set(float64(depth))
+	m.export(kindGauge, "gateway_event_dead_letter_depth", float64(depth), "provider", provider, "destination", destination)
+}
+
+// SetEventBufferDepth sets the number of events waiting to be published in
+// memory or on disk
+func (m *Manager) SetEventBufferDepth(storage string, depth int) {
+	m.eventBufferDepth.WithLabelValues(storage).Set(float64(depth))
+	m.export(kindGauge, "gateway_event_buffer_depth", float64(depth), "storage", storage)
+}
+
+// RecordEventDropped records an event dropped before being published
+func (m *Manager) RecordEventDropped(reason string) {
+	m.eventsDropped.WithLabelValues(reason).Inc()
+	m.export(kindCounter, "gateway_events_dropped_total", 1, "reason", reason)
+}
+
+// RecordWebhookDelivery records a webhook delivery attempt. result is
+// success, retry or failed.
+func (m *Manager) RecordWebhookDelivery(endpoint, result string, duration time.Duration) {
+	m.webhookDeliveries.WithLabelValues(endpoint, result).Inc()
+	m.webhookDuration.WithLabelValues(endpoint).Observe(duration.Seconds())
+	m.export(kindCounter, "gateway_webhook_deliveries_total", 1, "endpoint", endpoint, "result", result)
+	m.export(kindHistogram, "gateway_webhook_delivery_duration_seconds", duration.Seconds(), "endpoint", endpoint)
+}
+
+// RecordWebhookDropped records an event not delivered to a webhook because
+// its queue was full
+func (m *Manager) RecordWebhookDropped(endpoint string) {
+	m.webhookDeliveries.WithLabelValues(endpoint, "dropped").Inc()
+	m.export(kindCounter, "gateway_webhook_deliveries_total", 1, "endpoint", endpoint, "result", "dropped")
+}
+
+// RecordHeaderLimitRejection records a request rejected for exceeding a
+// header limit: header_bytes, header_count or cookie_bytes
+func (m *Manager) RecordHeaderLimitRejection(limit string) {
+	m.headerRejections.WithLabelValues(limit).Inc()
+	m.export(kindCounter, "gateway_header_limit_rejections_total", 1, "limit", limit)
+}
+
+// RecordConnectionDropped records a client connection dropped by a
+// connection limit: per_ip_limit, max_lifetime or read_header_timeout
+func (m *Manager) RecordConnectionDropped(reason string) {
+	m.connectionsDropped.WithLabelValues(reason).Inc()
+	m.export(kindCounter, "gateway_connections_dropped_total", 1, "reason", reason)
 }
 
 // SetActiveConnections sets the number of active connections
 func (m *Manager) SetActiveConnections(count int) {
 	m.activeConnections.Set(float64(count))
+	m.export(kindGauge, "gateway_active_connections", float64(count))
 }
 
-// Handler returns the Prometheus HTTP handler
+// EnableRuntimeMetrics registers Go runtime (GC, goroutines, memory,
+// scheduler) and process collectors on the registry
+func (m *Manager) EnableRuntimeMetrics() {
+	m.registry.MustRegister(
+		collectors.NewGoCollector(collectors.WithGoCollectorRuntimeMetrics(
+			collectors.MetricsGC,
+			collectors.MetricsMemory,
+			collectors.MetricsScheduler,
+		)),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler returns the Prometheus HTTP handler. OpenMetrics is negotiated
+// for scrapers that ask for it, which is required to expose exemplars.
 func (m *Manager) Handler() http.Handler {
-	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{EnableOpenMetrics: true})
 }
 
 // GinHandler returns a Gin handler for metrics endpoint
@@ -352,8 +906,33 @@ func (m *Manager) Reset() {
 	m.upstreamErrors.Reset()
 	m.cacheHits.Reset()
 	m.cacheMisses.Reset()
+	m.wafMatches.Reset()
+	m.deprecatedCalls.Reset()
+	m.admissionQueued.Reset()
+	m.admissionInFlight.Reset()
+	m.admissionWait.Reset()
+	m.admissionShed.Reset()
+	m.admissionLimit.Reset()
+	m.experimentRequests.Reset()
+	m.experimentDuration.Reset()
+	m.syntheticChecks.Reset()
+	m.syntheticDuration.Reset()
+	m.syntheticUp.Reset()
+	m.chaosFaults.Reset()
+	m.loadShed.Reset()
+	m.loadOverload.Reset()
+	m.degraded.Set(0)
+	m.eventRetries.Reset()
+	m.eventsDeadLettered.Reset()
+	m.deadLetterDepth.Reset()
+	m.eventBufferDepth.Reset()
+	m.eventsDropped.Reset()
 	m.gatewayUptime.Set(0)
 	m.activeConnections.Set(0)
+
+	m.statsMu.Lock()
+	m.serviceStats = make(map[string]*ServiceStats)
+	m.statsMu.Unlock()
 }
 
 // Middleware creates a Gin middleware for automatic metrics collection
@@ -373,6 +952,7 @@ func (m *Manager) Middleware() gin.HandlerFunc {
 		// Record metrics
 		duration := time.Since(start)
 		m.RecordHTTPRequest(
+			c.Request.Context(),
 			c.Request.Method,
 			c.Request.URL.Path,
 			c.Writer.Status(),
@@ -390,4 +970,3 @@ func (m *Manager) Middleware() gin.HandlerFunc {
 		}
 	}
 }
-
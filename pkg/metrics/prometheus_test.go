@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestManager_EnableRuntimeMetrics(t *testing.T) {
+	manager := NewManager(zap.NewNop())
+	scrape := func() string {
+		w := httptest.NewRecorder()
+		manager.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return w.Body.String()
+	}
+
+	if body := scrape(); strings.Contains(body, "go_goroutines") {
+		t.Error("runtime metrics exposed before EnableRuntimeMetrics()")
+	}
+
+	manager.EnableRuntimeMetrics()
+	body := scrape()
+	for _, name := range []string{"go_goroutines", "go_gc_duration_seconds", "go_memstats_heap_alloc_bytes", "go_sched_goroutines_goroutines", "process_resident_memory_bytes"} {
+		if !strings.Contains(body, name) {
+			t.Errorf("scrape is missing %s", name)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxStatsDPacket keeps UDP packets below the common Ethernet MTU
+const maxStatsDPacket = 1432
+
+// StatsDExporter sends metrics to a StatsD or DogStatsD agent over UDP.
+// Labels are sent as DogStatsD tags, which plain StatsD servers ignore.
+type StatsDExporter struct {
+	conn       net.Conn
+	prefix     string
+	globalTags []string
+
+	mu     sync.Mutex
+	buffer bytes.Buffer
+
+	done   chan struct{}
+	wg     sync.WaitGroup
+	logger *zap.Logger
+}
+
+// NewStatsDExporter creates an exporter sending to address ("host:port").
+// Metric names are prefixed with prefix and carry tags on every metric.
+func NewStatsDExporter(address, prefix string, tags map[string]string, flushInterval time.Duration, logger *zap.Logger) (*StatsDExporter, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to statsd at %s: %w", address, err)
+	}
+	if flushInterval <= 0 {
+		flushInterval = time.Second
+	}
+
+	e := &StatsDExporter{
+		conn:       conn,
+		prefix:     prefix,
+		globalTags: formatTags(tags),
+		done:       make(chan struct{}),
+		logger:     logger,
+	}
+
+	e.wg.Add(1)
+	go e.flushLoop(flushInterval)
+	return e, nil
+}
+
+// Counter sends a count
+func (e *StatsDExporter) Counter(name string, value float64, labels map[string]string) {
+	e.write(name, value, "c", labels)
+}
+
+// Gauge sends a gauge
+func (e *StatsDExporter) Gauge(name string, value float64, labels map[string]string) {
+	e.write(name, value, "g", labels)
+}
+
+// Histogram sends a DogStatsD histogram value
+func (e *StatsDExporter) Histogram(name string, value float64, labels map[string]string) {
+	e.write(name, value, "h", labels)
+}
+
+// Close flushes buffered metrics and closes the connection
+func (e *StatsDExporter) Close() error {
+	close(e.done)
+	e.wg.Wait()
+	e.flush()
+	return e.conn.Close()
+}
+
+// write appends a line to the buffer, flushing first if the packet would
+// grow too large
+func (e *StatsDExporter) write(name string, value float64, metricType string, labels map[string]string) {
+	line := e.format(name, value, metricType, labels)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.buffer.Len() > 0 && e.buffer.Len()+len(line)+1 > maxStatsDPacket {
+		e.flushLocked()
+	}
+	if e.buffer.Len() > 0 {
+		e.buffer.WriteByte('\n')
+	}
+	e.buffer.WriteString(line)
+}
+
+// format builds a "name:value|type|#tag:value,..." line
+func (e *StatsDExporter) format(name string, value float64, metricType string, labels map[string]string) string {
+	var b strings.Builder
+	b.WriteString(e.prefix)
+	b.WriteString(name)
+	b.WriteByte(':')
+	b.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	b.WriteByte('|')
+	b.WriteString(metricType)
+
+	tags := append(append([]string(nil), e.globalTags...), formatTags(labels)...)
+	if len(tags) > 0 {
+		b.WriteString("|#")
+		b.WriteString(strings.Join(tags, ","))
+	}
+	return b.String()
+}
+
+// flushLoop sends the buffer periodically
+func (e *StatsDExporter) flushLoop(interval time.Duration) {
+	defer e.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.flush()
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// flush sends the buffered lines
+func (e *StatsDExporter) flush() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.flushLocked()
+}
+
+// flushLocked sends the buffered lines. The caller must hold mu.
+func (e *StatsDExporter) flushLocked() {
+	if e.buffer.Len() == 0 {
+		return
+	}
+	if _, err := e.conn.Write(e.buffer.Bytes()); err != nil {
+		e.logger.Debug("Failed to send statsd metrics", zap.Error(err))
+	}
+	e.buffer.Reset()
+}
+
+// formatTags renders labels as sorted DogStatsD "key:value" tags
+func formatTags(labels map[string]string) []string {
+	tags := make([]string, 0, len(labels))
+	for key, value := range labels {
+		tags = append(tags, sanitizeTag(key)+":"+sanitizeTag(value))
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// tagReplacer replaces characters that delimit the DogStatsD format
+var tagReplacer = strings.NewReplacer("|", "_", ",", "_", "#", "_", "\n", "_")
+
+// sanitizeTag makes a label safe to use in a tag
+func sanitizeTag(value string) string {
+	return tagReplacer.Replace(value)
+}
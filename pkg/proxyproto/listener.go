@@ -0,0 +1,258 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// v1MaxHeaderLength is the longest valid v1 header including CRLF
+	v1MaxHeaderLength = 107
+	v1Prefix          = "PROXY "
+
+	v2HeaderLength = 16
+	v2CommandLocal = 0x0
+	v2CommandProxy = 0x1
+	v2FamilyTCP4   = 0x11
+	v2FamilyTCP6   = 0x21
+)
+
+// v2Signature starts every PROXY protocol v2 header
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ErrInvalidHeader is returned when a connection sends a malformed PROXY header
+var ErrInvalidHeader = errors.New("invalid PROXY protocol header")
+
+// Listener accepts connections and strips PROXY protocol v1/v2 headers,
+// reporting the original client address from RemoteAddr
+type Listener struct {
+	net.Listener
+	trusted       []*net.IPNet
+	headerTimeout time.Duration
+}
+
+// NewListener wraps a listener with PROXY protocol support. Headers are only
+// honored on connections from the trusted CIDRs or IPs; an empty list
+// trusts no peer, like gin's trusted proxies.
+func NewListener(inner net.Listener, trusted []string, headerTimeout time.Duration) (*Listener, error) {
+	networks, err := ParseCIDRs(trusted)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Listener{
+		Listener:      inner,
+		trusted:       networks,
+		headerTimeout: headerTimeout,
+	}, nil
+}
+
+// Accept waits for the next connection. The PROXY header is read lazily on
+// first use so a slow peer does not block the accept loop.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if !Contains(l.trusted, conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	return &Conn{
+		Conn:          conn,
+		reader:        bufio.NewReader(conn),
+		headerTimeout: l.headerTimeout,
+	}, nil
+}
+
+// Conn is a connection that may start with a PROXY protocol header
+type Conn struct {
+	net.Conn
+	reader        *bufio.Reader
+	headerTimeout time.Duration
+	once          sync.Once
+	remote        net.Addr
+	err           error
+}
+
+// Read reads data after the PROXY header
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address from the PROXY header, or the peer
+// address if none was sent
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readHeader detects and consumes a PROXY header
+func (c *Conn) readHeader() {
+	if c.headerTimeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.headerTimeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+
+	first, err := c.reader.Peek(1)
+	if err != nil {
+		// Let the next Read report the error
+		return
+	}
+
+	switch first[0] {
+	case v1Prefix[0]:
+		c.remote, c.err = readV1(c.reader)
+	case v2Signature[0]:
+		c.remote, c.err = readV2(c.reader)
+	}
+}
+
+// readV1 parses a human-readable v1 header, e.g.
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+func readV1(r *bufio.Reader) (net.Addr, error) {
+	prefix, err := r.Peek(len(v1Prefix))
+	if err != nil || string(prefix) != v1Prefix {
+		// Not a PROXY header; leave the data for the application
+		return nil, nil
+	}
+
+	line, err := r.ReadSlice('\n')
+	if err != nil || len(line) > v1MaxHeaderLength || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrInvalidHeader
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrInvalidHeader
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, ErrInvalidHeader
+	}
+
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readV2 parses a binary v2 header
+func readV2(r *bufio.Reader) (net.Addr, error) {
+	header, err := r.Peek(v2HeaderLength)
+	if err != nil || !bytes.Equal(header[:len(v2Signature)], v2Signature) {
+		return nil, nil
+	}
+
+	if header[12]>>4 != 0x2 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidHeader, header[12]>>4)
+	}
+	command := header[12] & 0x0f
+	family := header[13]
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+
+	if _, err := r.Discard(v2HeaderLength); err != nil {
+		return nil, ErrInvalidHeader
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, ErrInvalidHeader
+	}
+
+	switch command {
+	case v2CommandLocal:
+		// Health checks from the proxy itself carry no client address
+		return nil, nil
+	case v2CommandProxy:
+	default:
+		return nil, fmt.Errorf("%w: unsupported command %d", ErrInvalidHeader, command)
+	}
+
+	switch family {
+	case v2FamilyTCP4:
+		if length < 12 {
+			return nil, ErrInvalidHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:4]),
+			Port: int(binary.BigEndian.Uint16(payload[8:10])),
+		}, nil
+	case v2FamilyTCP6:
+		if length < 36 {
+			return nil, ErrInvalidHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:16]),
+			Port: int(binary.BigEndian.Uint16(payload[32:34])),
+		}, nil
+	}
+
+	// Unix sockets and unspecified families keep the peer address
+	return nil, nil
+}
+
+// ParseCIDRs parses a list of CIDRs or bare IPs
+func ParseCIDRs(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address: %s", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy CIDR %s: %w", value, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Contains reports whether the address falls within any of the networks
+func Contains(networks []*net.IPNet, addr net.Addr) bool {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package proxyproto
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestConn(t *testing.T, data []byte) *Conn {
+	t.Helper()
+	client, server := net.Pipe()
+	go func() {
+		client.Write(data)
+		client.Close()
+	}()
+	t.Cleanup(func() { server.Close() })
+	return &Conn{Conn: server, reader: bufio.NewReader(server)}
+}
+
+func TestConn_V1Header(t *testing.T) {
+	conn := newTestConn(t, []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET / HTTP/1.1\r\n\r\n"))
+
+	if got := conn.RemoteAddr().String(); got != "192.0.2.1:56324" {
+		t.Errorf("Expected client address 192.0.2.1:56324, got %s", got)
+	}
+
+	body, _ := io.ReadAll(conn)
+	if string(body) != "GET / HTTP/1.1\r\n\r\n" {
+		t.Errorf("Expected header to be stripped, got %q", body)
+	}
+}
+
+func TestConn_V2Header(t *testing.T) {
+	header := append([]byte{}, v2Signature...)
+	header = append(header, 0x21, v2FamilyTCP4, 0x00, 0x0c)
+	header = append(header, 203, 0, 113, 7, 10, 0, 0, 1, 0x1f, 0x90, 0x01, 0xbb)
+
+	conn := newTestConn(t, append(header, []byte("payload")...))
+
+	if got := conn.RemoteAddr().String(); got != "203.0.113.7:8080" {
+		t.Errorf("Expected client address 203.0.113.7:8080, got %s", got)
+	}
+
+	body, _ := io.ReadAll(conn)
+	if string(body) != "payload" {
+		t.Errorf("Expected header to be stripped, got %q", body)
+	}
+}
+
+func TestConn_NoHeader(t *testing.T) {
+	conn := newTestConn(t, []byte("POST / HTTP/1.1\r\n\r\n"))
+
+	if _, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		t.Error("Expected peer address when no PROXY header is sent")
+	}
+
+	body, _ := io.ReadAll(conn)
+	if string(body) != "POST / HTTP/1.1\r\n\r\n" {
+		t.Errorf("Expected data to be untouched, got %q", body)
+	}
+}
+
+func TestConn_InvalidV1Header(t *testing.T) {
+	conn := newTestConn(t, []byte("PROXY TCP4 not-an-ip 198.51.100.1 1 2\r\n"))
+
+	if _, err := conn.Read(make([]byte, 1)); err != ErrInvalidHeader {
+		t.Errorf("Expected invalid header error, got %v", err)
+	}
+}
+
+func TestContains(t *testing.T) {
+	networks, err := ParseCIDRs([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !Contains(networks, &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 80}) {
+		t.Error("Expected 10.1.2.3 to be trusted")
+	}
+	if !Contains(networks, &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 80}) {
+		t.Error("Expected 192.0.2.1 to be trusted")
+	}
+	if Contains(networks, &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 80}) {
+		t.Error("Expected 192.0.2.2 not to be trusted")
+	}
+}
+
+func TestListener_TrustsOnlyListedPeers(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		trusted []string
+		wrapped bool
+	}{
+		{"no trusted proxies", nil, false},
+		{"other peer", []string{"10.0.0.0/8"}, false},
+		{"trusted peer", []string{"127.0.0.1"}, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			inner, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			listener, err := NewListener(inner, tt.trusted, time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+
+			go func() {
+				if conn, err := net.Dial("tcp", inner.Addr().String()); err == nil {
+					conn.Close()
+				}
+			}()
+			conn, err := listener.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if _, wrapped := conn.(*Conn); wrapped != tt.wrapped {
+				t.Errorf("PROXY headers honored = %v, want %v", wrapped, tt.wrapped)
+			}
+		})
+	}
+}
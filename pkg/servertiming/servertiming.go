@@ -0,0 +1,111 @@
+// Package servertiming collects how long the phases of a request take and
+// formats them as a Server-Timing header (W3C Server Timing)
+package servertiming
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HeaderName is the response header the timings are sent in
+const HeaderName = "Server-Timing"
+
+// Phases timed by the gateway
+const (
+	Auth      = "auth"
+	RateLimit = "ratelimit"
+	Cache     = "cache"
+	Upstream  = "upstream"
+	Transform = "transform"
+	Total     = "total"
+)
+
+// contextKey is the context key for the timings of a request
+type contextKey struct{}
+
+// metric is the time spent in one phase
+type metric struct {
+	name     string
+	duration time.Duration
+}
+
+// Timings are the durations of the phases of one request. The methods of
+// a nil *Timings do nothing, so phases can be timed whether or not the
+// request carries timings.
+type Timings struct {
+	start   time.Time
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// New creates timings for a request that started at start
+func New(start time.Time) *Timings {
+	return &Timings{start: start}
+}
+
+// NewContext returns a context carrying t
+func NewContext(ctx context.Context, t *Timings) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// FromContext returns the timings carried by ctx, or nil
+func FromContext(ctx context.Context) *Timings {
+	t, _ := ctx.Value(contextKey{}).(*Timings)
+	return t
+}
+
+// Add adds d to the time spent in a phase. Phases are reported in the
+// order they were first added.
+func (t *Timings) Add(name string, d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.metrics {
+		if t.metrics[i].name == name {
+			t.metrics[i].duration += d
+			return
+		}
+	}
+	t.metrics = append(t.metrics, metric{name: name, duration: d})
+}
+
+// Start starts timing a phase and returns the function that ends it
+func (t *Timings) Start(name string) func() {
+	if t == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() { t.Add(name, time.Since(start)) }
+}
+
+// Header returns the Server-Timing header value, ending with the total
+// time since the request started, e.g.
+// "auth;dur=0.41, upstream;dur=12.8, total;dur=13.6"
+func (t *Timings) Header() string {
+	if t == nil {
+		return ""
+	}
+	total := time.Since(t.start)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var b strings.Builder
+	for _, m := range t.metrics {
+		writeMetric(&b, m.name, m.duration)
+		b.WriteString(", ")
+	}
+	writeMetric(&b, Total, total)
+	return b.String()
+}
+
+// writeMetric writes a metric with its duration in milliseconds
+func writeMetric(b *strings.Builder, name string, d time.Duration) {
+	b.WriteString(name)
+	b.WriteString(";dur=")
+	b.WriteString(strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', -1, 64))
+}
@@ -0,0 +1,34 @@
+package servertiming
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestHeader(t *testing.T) {
+	timings := New(time.Now().Add(-20 * time.Millisecond))
+	ctx := NewContext(context.Background(), timings)
+
+	FromContext(ctx).Add(Auth, 1500*time.Microsecond)
+	FromContext(ctx).Add(Upstream, 10*time.Millisecond)
+	FromContext(ctx).Add(Auth, 500*time.Microsecond)
+
+	header := timings.Header()
+	if !regexp.MustCompile(`^auth;dur=2, upstream;dur=10, total;dur=2\d(\.\d+)?$`).MatchString(header) {
+		t.Errorf("unexpected header %q", header)
+	}
+}
+
+func TestNilTimings(t *testing.T) {
+	timings := FromContext(context.Background())
+	if timings != nil {
+		t.Fatal("expected no timings")
+	}
+	timings.Start(Auth)()
+	timings.Add(Cache, time.Millisecond)
+	if header := timings.Header(); header != "" {
+		t.Errorf("expected no header, got %q", header)
+	}
+}
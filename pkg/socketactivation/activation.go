@@ -0,0 +1,102 @@
+// Package socketactivation takes over the listening sockets systemd passes
+// to a socket-activated service
+package socketactivation
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// listenFDsStart is the first descriptor systemd passes sockets on
+const listenFDsStart = 3
+
+// unnamed is the name systemd reports for sockets without a
+// FileDescriptorName
+const unnamed = "unknown"
+
+// Sockets are the sockets passed by systemd, by name
+type Sockets struct {
+	mu    sync.Mutex
+	files map[string][]*os.File
+}
+
+// Passed returns the sockets passed to this process. The environment
+// variables describing them are cleared so that child processes do not
+// claim them.
+func Passed() (*Sockets, error) {
+	pid, fds, names := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	s := &Sockets{files: make(map[string][]*os.File)}
+	// The variables are meant for another process, such as a parent that
+	// did not clear them
+	if pid == "" || pid != strconv.Itoa(os.Getpid()) {
+		return s, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	for i, name := range fdNames(n, names) {
+		fd := listenFDsStart + i
+		s.files[name] = append(s.files[name], os.NewFile(uintptr(fd), name))
+	}
+	return s, nil
+}
+
+// fdNames returns the names of n passed sockets
+func fdNames(n int, names string) []string {
+	var given []string
+	if names != "" {
+		given = strings.Split(names, ":")
+	}
+	out := make([]string, n)
+	for i := range out {
+		out[i] = unnamed
+		if i < len(given) && given[i] != "" {
+			out[i] = given[i]
+		}
+	}
+	return out
+}
+
+// Listener takes the next socket passed under name. A socket unit with
+// several ListenStream lines passes them all under its name.
+func (s *Sockets) Listener(name string) (net.Listener, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files := s.files[name]
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no socket named %q was passed by systemd", name)
+	}
+	file := files[0]
+	s.files[name] = files[1:]
+
+	// FileListener works on a duplicate of the descriptor
+	defer file.Close()
+	ln, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("socket %q is not a listening socket: %w", name, err)
+	}
+	return ln, nil
+}
+
+// Close closes the sockets that were not taken
+func (s *Sockets) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, files := range s.files {
+		for _, file := range files {
+			file.Close()
+		}
+		delete(s.files, name)
+	}
+}
@@ -0,0 +1,59 @@
+package socketactivation
+
+import (
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestFDNames(t *testing.T) {
+	got := fdNames(3, "http:https")
+	want := []string{"http", "https", "unknown"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("fdNames = %v, want %v", got, want)
+	}
+}
+
+func TestPassedIgnoresOtherProcesses(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "http")
+
+	sockets, err := Passed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sockets.Listener("http"); err == nil {
+		t.Fatal("took a socket passed to another process")
+	}
+	if _, set := os.LookupEnv("LISTEN_FDS"); set {
+		t.Fatal("LISTEN_FDS left for child processes")
+	}
+}
+
+func TestListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	file, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sockets := &Sockets{files: map[string][]*os.File{"http": {file}}}
+	passed, err := sockets.Listener("http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer passed.Close()
+	if passed.Addr().String() != ln.Addr().String() {
+		t.Fatalf("listener on %s, want %s", passed.Addr(), ln.Addr())
+	}
+	if _, err := sockets.Listener("http"); err == nil {
+		t.Fatal("socket taken twice")
+	}
+}
@@ -0,0 +1,319 @@
+package tlsfingerprint
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrNotClientHello is returned when a TLS record is not a ClientHello
+var ErrNotClientHello = errors.New("not a TLS ClientHello")
+
+// TLS extension types used by the fingerprints
+const (
+	extServerName          = 0x0000
+	extSupportedGroups     = 0x000a
+	extECPointFormats      = 0x000b
+	extSignatureAlgorithms = 0x000d
+	extALPN                = 0x0010
+	extSupportedVersions   = 0x002b
+)
+
+// Fingerprint identifies a TLS client by its ClientHello
+type Fingerprint struct {
+	// JA3 is the MD5 hash of the JA3 string
+	JA3 string `json:"ja3"`
+	// JA3Raw is the unhashed JA3 string
+	JA3Raw string `json:"ja3_raw"`
+	// JA4 is the JA4 fingerprint (a_b_c form)
+	JA4 string `json:"ja4"`
+}
+
+// clientHello holds the ClientHello fields the fingerprints are built from
+type clientHello struct {
+	version           uint16
+	ciphers           []uint16
+	extensions        []uint16
+	groups            []uint16
+	pointFormats      []uint8
+	signatureAlgs     []uint16
+	supportedVersions []uint16
+	alpn              []string
+	hasSNI            bool
+}
+
+// Parse computes the fingerprint from a TLS record containing a ClientHello
+func Parse(record []byte) (*Fingerprint, error) {
+	hello, err := parseClientHello(record)
+	if err != nil {
+		return nil, err
+	}
+	return &Fingerprint{
+		JA3:    ja3Hash(hello),
+		JA3Raw: ja3String(hello),
+		JA4:    ja4(hello),
+	}, nil
+}
+
+// reader is a bounds-checked cursor over handshake bytes
+type reader struct {
+	data []byte
+	err  bool
+}
+
+// bytes consumes n bytes
+func (r *reader) bytes(n int) []byte {
+	if r.err || n > len(r.data) {
+		r.err = true
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+// u8 consumes a one-byte integer
+func (r *reader) u8() int {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return int(b[0])
+}
+
+// u16 consumes a big-endian two-byte integer
+func (r *reader) u16() int {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(b))
+}
+
+// u24 consumes a big-endian three-byte integer
+func (r *reader) u24() int {
+	b := r.bytes(3)
+	if b == nil {
+		return 0
+	}
+	return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+}
+
+// parseClientHello decodes a TLS handshake record containing a ClientHello.
+// A ClientHello split over several records is parsed as far as the first
+// record goes.
+func parseClientHello(record []byte) (*clientHello, error) {
+	r := &reader{data: record}
+	if r.u8() != 0x16 {
+		return nil, ErrNotClientHello
+	}
+	r.bytes(2) // record version
+	r.data = r.bytes(r.u16())
+	if r.u8() != 0x01 {
+		return nil, ErrNotClientHello
+	}
+	if length := r.u24(); length < len(r.data) {
+		r.data = r.data[:length]
+	}
+
+	hello := &clientHello{version: uint16(r.u16())}
+	r.bytes(32) // random
+	r.bytes(r.u8())
+
+	ciphers := &reader{data: r.bytes(r.u16())}
+	for len(ciphers.data) >= 2 {
+		hello.ciphers = append(hello.ciphers, uint16(ciphers.u16()))
+	}
+	r.bytes(r.u8()) // compression methods
+	if r.err {
+		return nil, fmt.Errorf("truncated ClientHello")
+	}
+
+	// Extensions are optional in very old clients
+	if len(r.data) == 0 {
+		return hello, nil
+	}
+	exts := &reader{data: r.bytes(r.u16())}
+	for len(exts.data) >= 4 && !exts.err {
+		extType := uint16(exts.u16())
+		data := &reader{data: exts.bytes(exts.u16())}
+		hello.extensions = append(hello.extensions, extType)
+
+		switch extType {
+		case extServerName:
+			hello.hasSNI = true
+		case extSupportedGroups:
+			list := &reader{data: data.bytes(data.u16())}
+			for len(list.data) >= 2 {
+				hello.groups = append(hello.groups, uint16(list.u16()))
+			}
+		case extECPointFormats:
+			hello.pointFormats = append(hello.pointFormats, data.bytes(data.u8())...)
+		case extSignatureAlgorithms:
+			list := &reader{data: data.bytes(data.u16())}
+			for len(list.data) >= 2 {
+				hello.signatureAlgs = append(hello.signatureAlgs, uint16(list.u16()))
+			}
+		case extALPN:
+			list := &reader{data: data.bytes(data.u16())}
+			for len(list.data) > 0 && !list.err {
+				if proto := list.bytes(list.u8()); len(proto) > 0 {
+					hello.alpn = append(hello.alpn, string(proto))
+				}
+			}
+		case extSupportedVersions:
+			list := &reader{data: data.bytes(data.u8())}
+			for len(list.data) >= 2 {
+				hello.supportedVersions = append(hello.supportedVersions, uint16(list.u16()))
+			}
+		}
+	}
+
+	return hello, nil
+}
+
+// isGREASE reports whether v is a GREASE value (RFC 8701)
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// withoutGREASE drops GREASE values
+func withoutGREASE(values []uint16) []uint16 {
+	out := make([]uint16, 0, len(values))
+	for _, v := range values {
+		if !isGREASE(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// joinDecimal joins values as decimal numbers
+func joinDecimal[T uint8 | uint16](values []T, sep string) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(parts, sep)
+}
+
+// joinHex joins values as four-digit hex numbers
+func joinHex(values []uint16) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprintf("%04x", v)
+	}
+	return strings.Join(parts, ",")
+}
+
+// ja3String builds "version,ciphers,extensions,groups,point formats"
+func ja3String(h *clientHello) string {
+	return strings.Join([]string{
+		strconv.Itoa(int(h.version)),
+		joinDecimal(withoutGREASE(h.ciphers), "-"),
+		joinDecimal(withoutGREASE(h.extensions), "-"),
+		joinDecimal(withoutGREASE(h.groups), "-"),
+		joinDecimal(h.pointFormats, "-"),
+	}, ",")
+}
+
+// ja3Hash returns the MD5 hex digest of the JA3 string
+func ja3Hash(h *clientHello) string {
+	sum := md5.Sum([]byte(ja3String(h)))
+	return hex.EncodeToString(sum[:])
+}
+
+// ja4 builds the JA4 fingerprint for a TCP client
+func ja4(h *clientHello) string {
+	ciphers := withoutGREASE(h.ciphers)
+	extensions := withoutGREASE(h.extensions)
+
+	// TLS 1.3 clients advertise their real versions in supported_versions
+	version := h.version
+	if supported := withoutGREASE(h.supportedVersions); len(supported) > 0 {
+		version = supported[0]
+		for _, v := range supported {
+			version = max(version, v)
+		}
+	}
+
+	sni := "i"
+	if h.hasSNI {
+		sni = "d"
+	}
+
+	a := fmt.Sprintf("t%s%s%02d%02d%s", ja4Version(version), sni,
+		min(len(ciphers), 99), min(len(extensions), 99), ja4ALPN(h.alpn))
+
+	sortedCiphers := append([]uint16(nil), ciphers...)
+	sort.Slice(sortedCiphers, func(i, j int) bool { return sortedCiphers[i] < sortedCiphers[j] })
+
+	// SNI and ALPN are already covered by the first part
+	var hashedExts []uint16
+	for _, ext := range extensions {
+		if ext != extServerName && ext != extALPN {
+			hashedExts = append(hashedExts, ext)
+		}
+	}
+	sort.Slice(hashedExts, func(i, j int) bool { return hashedExts[i] < hashedExts[j] })
+
+	extInput := joinHex(hashedExts)
+	if sigAlgs := withoutGREASE(h.signatureAlgs); len(sigAlgs) > 0 {
+		extInput += "_" + joinHex(sigAlgs)
+	}
+
+	return a + "_" + ja4Hash(joinHex(sortedCiphers), len(sortedCiphers)) + "_" + ja4Hash(extInput, len(hashedExts))
+}
+
+// ja4Version maps a TLS version to its JA4 code
+func ja4Version(version uint16) string {
+	switch version {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	default:
+		return "00"
+	}
+}
+
+// ja4ALPN returns the first and last character of the first ALPN value, or
+// of its hex form when those are not alphanumeric
+func ja4ALPN(alpn []string) string {
+	if len(alpn) == 0 {
+		return "00"
+	}
+	proto := alpn[0]
+	first, last := proto[0], proto[len(proto)-1]
+	if isAlphanumeric(first) && isAlphanumeric(last) {
+		return string([]byte{first, last})
+	}
+	encoded := hex.EncodeToString([]byte(proto))
+	return encoded[:1] + encoded[len(encoded)-1:]
+}
+
+// isAlphanumeric reports whether b is an ASCII letter or digit
+func isAlphanumeric(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// ja4Hash returns the truncated SHA-256 of a JA4 list, or zeros when empty
+func ja4Hash(input string, count int) string {
+	if count == 0 {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(input))
+	return hex.EncodeToString(sum[:])[:12]
+}
@@ -0,0 +1,120 @@
+package tlsfingerprint
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// maxRecordSize bounds the TLS record buffered for fingerprinting
+const maxRecordSize = 16384 + 2048
+
+// contextKey is the context key for the connection of a request
+type contextKey struct{}
+
+// Listener wraps a listener so every connection records the fingerprint of
+// its TLS ClientHello. It must sit below the TLS listener.
+type Listener struct {
+	net.Listener
+}
+
+// NewListener wraps inner with ClientHello fingerprinting
+func NewListener(inner net.Listener) *Listener {
+	return &Listener{Listener: inner}
+}
+
+// Accept wraps the accepted connection
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn}, nil
+}
+
+// Conn captures the first TLS record read from the connection and replays
+// it to the TLS stack
+type Conn struct {
+	net.Conn
+
+	once    sync.Once
+	pending []byte
+	readErr error
+	fp      atomic.Pointer[Fingerprint]
+}
+
+// Read returns the captured record first, then reads from the connection
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.capture)
+
+	if len(c.pending) > 0 {
+		n := copy(b, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	if c.readErr != nil {
+		return 0, c.readErr
+	}
+	return c.Conn.Read(b)
+}
+
+// Fingerprint returns the client fingerprint once the ClientHello was read
+func (c *Conn) Fingerprint() *Fingerprint {
+	return c.fp.Load()
+}
+
+// capture reads the first record and fingerprints it when it is a ClientHello
+func (c *Conn) capture() {
+	header := make([]byte, 5)
+	n, err := io.ReadFull(c.Conn, header)
+	c.pending = header[:n]
+	if err != nil {
+		c.readErr = err
+		return
+	}
+
+	length := int(binary.BigEndian.Uint16(header[3:5]))
+	if header[0] != 0x16 || length > maxRecordSize {
+		// Not a handshake; let the TLS stack reject it
+		return
+	}
+
+	body := make([]byte, length)
+	n, err = io.ReadFull(c.Conn, body)
+	c.pending = append(c.pending, body[:n]...)
+	if err != nil {
+		c.readErr = err
+		return
+	}
+
+	if fp, err := Parse(c.pending); err == nil {
+		c.fp.Store(fp)
+	}
+}
+
+// ConnContext stores the connection in the request context, for use as
+// http.Server.ConnContext. The fingerprint is resolved later because the
+// context is created before the TLS handshake.
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if fc, ok := conn.(*Conn); ok {
+		return context.WithValue(ctx, contextKey{}, fc)
+	}
+	return ctx
+}
+
+// FromContext returns the TLS fingerprint of the request's connection
+func FromContext(ctx context.Context) (*Fingerprint, bool) {
+	conn, ok := ctx.Value(contextKey{}).(*Conn)
+	if !ok {
+		return nil, false
+	}
+	fp := conn.Fingerprint()
+	return fp, fp != nil
+}
@@ -0,0 +1,57 @@
+package tlsfingerprint
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestConn_CapturesClientHello(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		tlsClient := tls.Client(client, &tls.Config{ServerName: "api.example.com", NextProtos: []string{"h2", "http/1.1"}})
+		tlsClient.Handshake()
+		client.Close()
+	}()
+
+	conn := &Conn{Conn: server}
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(conn, first); err != nil {
+		t.Fatalf("Failed to read from connection: %v", err)
+	}
+	if first[0] != 0x16 {
+		t.Errorf("Expected the captured record to be replayed, got first byte %#x", first[0])
+	}
+
+	fp := conn.Fingerprint()
+	if fp == nil {
+		t.Fatal("Expected a fingerprint after the ClientHello was read")
+	}
+	if !strings.HasPrefix(fp.JA4, "t13d") || !strings.Contains(fp.JA4, "h2_") {
+		t.Errorf("Unexpected JA4 fingerprint: %s", fp.JA4)
+	}
+	if len(fp.JA3) != 32 || !strings.HasPrefix(fp.JA3Raw, "771,") {
+		t.Errorf("Unexpected JA3 fingerprint: %s (%s)", fp.JA3, fp.JA3Raw)
+	}
+}
+
+func TestParse_RejectsNonHandshake(t *testing.T) {
+	if _, err := Parse([]byte("GET / HTTP/1.1\r\n")); err != ErrNotClientHello {
+		t.Errorf("Expected ErrNotClientHello, got %v", err)
+	}
+}
+
+func TestIsGREASE(t *testing.T) {
+	for _, v := range []uint16{0x0a0a, 0x1a1a, 0xfafa} {
+		if !isGREASE(v) {
+			t.Errorf("Expected %#04x to be GREASE", v)
+		}
+	}
+	if isGREASE(0x1301) || isGREASE(0x0a1a) {
+		t.Error("Expected regular values not to be GREASE")
+	}
+}
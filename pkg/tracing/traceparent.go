@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// TraceparentHeader is the W3C Trace Context propagation header
+const TraceparentHeader = "traceparent"
+
+// SpanContext identifies the current position in a distributed trace
+type SpanContext struct {
+	TraceID string
+	SpanID  string
+	Sampled bool
+}
+
+// contextKey is the context key for the span context
+type contextKey struct{}
+
+// ParseTraceparent parses a traceparent header value
+// ("00-<trace-id>-<parent-id>-<flags>")
+func ParseTraceparent(value string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, fmt.Errorf("invalid traceparent: %q", value)
+	}
+	// Version 00 has exactly four fields; later versions may append more
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, fmt.Errorf("invalid traceparent: %q", value)
+	}
+
+	traceID, spanID, flags := parts[1], parts[2], parts[3]
+	if !isHex(traceID, 32) || !isHex(spanID, 16) || !isHex(flags, 2) ||
+		traceID == strings.Repeat("0", 32) || spanID == strings.Repeat("0", 16) {
+		return SpanContext{}, fmt.Errorf("invalid traceparent: %q", value)
+	}
+
+	flagBits, _ := hex.DecodeString(flags)
+	return SpanContext{TraceID: traceID, SpanID: spanID, Sampled: flagBits[0]&0x01 == 1}, nil
+}
+
+// NewTrace starts a sampled trace
+func NewTrace() SpanContext {
+	return SpanContext{TraceID: randomHex(16), SpanID: randomHex(8), Sampled: true}
+}
+
+// Child returns a span context for a new span in the same trace
+func (s SpanContext) Child() SpanContext {
+	return SpanContext{TraceID: s.TraceID, SpanID: randomHex(8), Sampled: s.Sampled}
+}
+
+// Traceparent formats the span context as a traceparent header value
+func (s SpanContext) Traceparent() string {
+	flags := "00"
+	if s.Sampled {
+		flags = "01"
+	}
+	return "00-" + s.TraceID + "-" + s.SpanID + "-" + flags
+}
+
+// WithSpanContext stores the span context in ctx
+func WithSpanContext(ctx context.Context, span SpanContext) context.Context {
+	return context.WithValue(ctx, contextKey{}, span)
+}
+
+// FromContext returns the span context stored in ctx
+func FromContext(ctx context.Context) (SpanContext, bool) {
+	span, ok := ctx.Value(contextKey{}).(SpanContext)
+	return span, ok
+}
+
+// isHex reports whether s is n lowercase hex characters
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= '0' && r <= '9') && !(r >= 'a' && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// randomHex returns n random bytes as hex
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
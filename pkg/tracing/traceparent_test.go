@@ -0,0 +1,33 @@
+package tracing
+
+import "testing"
+
+func TestParseTraceparent(t *testing.T) {
+	span, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil {
+		t.Fatalf("Expected valid traceparent, got %v", err)
+	}
+	if span.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || span.SpanID != "00f067aa0ba902b7" || !span.Sampled {
+		t.Errorf("Unexpected span context: %+v", span)
+	}
+
+	child := span.Child()
+	if child.TraceID != span.TraceID || child.SpanID == span.SpanID {
+		t.Errorf("Expected child in the same trace with a new span, got %+v", child)
+	}
+	if _, err := ParseTraceparent(child.Traceparent()); err != nil {
+		t.Errorf("Expected formatted traceparent to parse, got %v", err)
+	}
+
+	for _, invalid := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+	} {
+		if _, err := ParseTraceparent(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
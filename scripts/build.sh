@@ -53,6 +53,20 @@ GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build \
     -o bin/config-server-linux-amd64 \
     cmd/config-server/main.go
 
+# Build replay tool
+echo "Building replay tool..."
+GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build \
+    -ldflags "${LDFLAGS}" \
+    -o bin/replay-linux-amd64 \
+    cmd/replay/main.go
+
+# Build load generator
+echo "Building load generator..."
+GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build \
+    -ldflags "${LDFLAGS}" \
+    -o bin/loadgen-linux-amd64 \
+    cmd/loadgen/main.go
+
 echo "Build completed successfully!"
 echo "Binaries available in bin/ directory:"
 ls -la bin/